@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/aatype"
+)
+
+var aatypeQuery string
+var aatypeSam string
+var aatypeGenbank string
+var aatypeOutfile string
+
+func init() {
+	rootCmd.AddCommand(aatypeCmd)
+
+	aatypeCmd.Flags().StringVarP(&aatypeQuery, "query", "q", "stdin", "Alignment of sequences to type, in fasta format, aligned to the reference in --genbank")
+	aatypeCmd.Flags().StringVarP(&aatypeSam, "samfile", "s", "", "(Optional) read the queries from this SAM file, mapped to the reference in --genbank, instead of --query")
+	aatypeCmd.Flags().StringVarP(&aatypeGenbank, "genbank", "g", "", "Genbank annotation of the reference (a file, or an accession to fetch from NCBI)")
+	aatypeCmd.Flags().StringVarP(&aatypeOutfile, "outfile", "o", "stdout", "Where to write the amino acids")
+
+	addCodonsFlag(aatypeCmd.Flags())
+	addGeneAliasesFlag(aatypeCmd.Flags())
+
+	inputFlags(aatypeCmd.Flags(), "query", "samfile", "genbank")
+	checkedInputs(aatypeCmd.Flags(), samFormat, "samfile")
+	checkedInputs(aatypeCmd.Flags(), alignmentFormat, "query")
+	checkedInputs(aatypeCmd.Flags(), genbankFormat, "genbank")
+	outputFlags(aatypeCmd.Flags(), "outfile")
+
+	aatypeCmd.Flags().SortFlags = false
+}
+
+var aatypeCmd = &cobra.Command{
+	Use:   "aatype gene:position [gene:position ...]",
+	Short: "Report the amino acid that each query has at particular residues",
+	Long: `Report the amino acid that each query has at particular residues
+
+Residues are given as gene:position, for the CDS with that /gene in --genbank (case-insensitively), with
+the position 1-based in codons, e.g. S:484 S:501 ORF1ab:3675. A gene can be given by any of its names, e.g.
+spike:484, with the built-in SARS-CoV-2 ones and any in --gene-aliases, and is looked for by its /product
+if no CDS has it as its /gene. The queries are read from an alignment to
+the reference (--query) or from a SAM file (--samfile), in which case insertions relative to the reference are
+ignored.
+
+Example usage:
+	gofasta aatype -q alignment.fasta -g MN908947.gb -o types.csv S:484 S:501 ORF1ab:3675
+	gofasta aatype -s aligned.sam -g MN908947.gb S:484
+
+The output is a csv-format file with one line per query, in the order of the input, and a column for each
+residue, headed with its reference amino acid (e.g. S:E484). A codon that is entirely deleted is written as
+del, and one with Ns or other ambiguity codes as X, unless every codon it could be is the same amino acid.
+A codon that is partly deleted is X, or del with --codons del. With --codons frame, the nucleotides either
+side of an in-frame deletion that starts in the middle of a codon are joined up and translated as the first
+codon it touches, and the rest are del (e.g. S:I68 is I, and S:69 and S:70 are del, in sequences with the
+21765-21770 deletion).`,
+	Args: cobra.MinimumNArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		policy, err := codons()
+		if err != nil {
+			return err
+		}
+
+		err = loadGeneAliases()
+		if err != nil {
+			return err
+		}
+
+		err = aatype.AAType(aatypeQuery, aatypeSam, aatypeGenbank, args, policy, aatypeOutfile, numThreads())
+
+		return
+	},
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/align"
+)
+
+var alignQuery string
+var alignOutfile string
+var alignBandWidth int
+
+func init() {
+	rootCmd.AddCommand(alignCmd)
+
+	addThreadsShorthand(alignCmd.Flags())
+	addReferenceFlag(alignCmd.Flags(), "Reference sequence, in fasta format")
+	alignCmd.Flags().StringVarP(&alignQuery, "query", "q", "stdin", "Unaligned sequences to align, in fasta format")
+	alignCmd.Flags().StringVarP(&alignOutfile, "outfile", "o", "stdout", "Where to write the alignments, in sam format")
+	alignCmd.Flags().IntVarP(&alignBandWidth, "band-width", "", 500, "How far alignments between seed matches can stray from the diagonal (as well as any difference in length)")
+
+	inputFlags(alignCmd.Flags(), "query")
+	checkedInputs(alignCmd.Flags(), fastaFormat, "query")
+	outputFlags(alignCmd.Flags(), "outfile")
+
+	alignCmd.Flags().SortFlags = false
+}
+
+var alignCmd = &cobra.Command{
+	Use:   "align",
+	Short: "Align sequences to a reference, writing a sam file",
+	Long: `Align sequences to a reference, writing a sam file
+
+This is a pairwise aligner for whole genomes (e.g. consensus sequences) against a closely related
+reference, for when you don't have minimap2 to hand. Alignments are seeded with 15-mers that are
+unique in the reference, and the gaps between the seeds are filled in by dynamic programming.
+Sequences are aligned on whichever strand matches the reference better, and ones that don't
+match it at all are written as unmapped records.
+
+The output is a sam file with MD tags, which can be used with the sam subcommands, e.g.:
+	gofasta align -r reference.fasta -q unaligned.fasta | gofasta sam toMultiAlign > aligned.fasta
+	gofasta align -r reference.fasta -q unaligned.fasta | gofasta sam snps > snps.csv`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = align.Align(reference, alignQuery, alignOutfile, alignBandWidth, numThreads())
+
+		return
+	},
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// flags that are shared by the subcommands that can process a batch of query files in one run
+// (closest, constellations and sam variants), loading what is shared between them only once
+var batchFiles []string
+var batchList string
+var batchOutdir string
+
+// addBatchFlags adds the shared --batch, --batch-list and --outdir flags to a command's flags.
+// what is what a query file is, for the help
+func addBatchFlags(flags *pflag.FlagSet, what string) {
+	flags.StringSliceVarP(&batchFiles, "batch", "", nil, "Process each of these "+what+" (comma-separated, or repeated) in the same run, writing an output for each to --outdir")
+	flags.StringVarP(&batchList, "batch-list", "", "", "A file listing "+what+" to process in the same run (one path per line), as with --batch")
+	flags.StringVarP(&batchOutdir, "outdir", "", "", "With --batch or --batch-list, the directory to write the outputs to, each named after its input")
+	inputFlags(flags, "batch", "batch-list")
+	outputFlags(flags, "outdir")
+}
+
+// batchMode reports whether --batch or --batch-list was given
+func batchMode() bool {
+	return len(batchFiles) > 0 || len(batchList) > 0
+}
+
+// batchPaths is the query files given by --batch and --batch-list, and the output file for each:
+// its name without its extension, plus suffix, in --outdir (which is made if it doesn't exist)
+func batchPaths(suffix string) ([]string, []string, error) {
+
+	if len(batchOutdir) == 0 {
+		return nil, nil, usage.New("--batch and --batch-list need an --outdir to write the outputs to")
+	}
+
+	inFiles := append([]string{}, batchFiles...)
+
+	if len(batchList) > 0 {
+		f, err := os.Open(batchList)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if len(line) > 0 && !strings.HasPrefix(line, "#") {
+				inFiles = append(inFiles, line)
+			}
+		}
+		if err := s.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(inFiles) == 0 {
+		return nil, nil, usage.Errorf("there are no files to process in %s", batchList)
+	}
+
+	outFiles := make([]string, len(inFiles))
+	seen := make(map[string]string)
+
+	for i, inFile := range inFiles {
+		base := filepath.Base(inFile)
+		name := strings.TrimSuffix(base, filepath.Ext(base))
+		if other, ok := seen[name]; ok {
+			return nil, nil, usage.Errorf("%s and %s would both be written to %s in --outdir", other, inFile, name+suffix)
+		}
+		seen[name] = inFile
+		outFiles[i] = filepath.Join(batchOutdir, name+suffix)
+	}
+
+	err := os.MkdirAll(batchOutdir, 0755)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return inFiles, outFiles, nil
+}
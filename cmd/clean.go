@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/clean"
+)
+
+var cleanInfile string
+var cleanOutfile string
+var cleanReport string
+var cleanInvalid string
+var cleanCase string
+var cleanUToT bool
+
+func init() {
+	rootCmd.AddCommand(cleanCmd)
+
+	cleanCmd.Flags().StringVarP(&cleanInfile, "infile", "i", "stdin", "Sequences to clean, in fasta format")
+	cleanCmd.Flags().StringVarP(&cleanOutfile, "outfile", "o", "stdout", "Where to write the cleaned sequences, in fasta format")
+	cleanCmd.Flags().StringVarP(&cleanReport, "report", "", "", "(Optional) Write what was changed in each sequence to this tab-separated file")
+	addAlphabetFlag(cleanCmd.Flags())
+	cleanCmd.Flags().StringVarP(&cleanInvalid, "invalid", "", "replace", "What to do with characters that aren't in the alphabet: replace (with N, or X for protein), delete, or fail")
+	cleanCmd.Flags().StringVarP(&cleanCase, "case", "", "upper", "Write the sequences in upper or lower case, or keep their case as it is")
+	cleanCmd.Flags().BoolVarP(&cleanUToT, "u-to-t", "", false, "Convert U to T (RNA to DNA)")
+
+	inputFlags(cleanCmd.Flags(), "infile")
+	checkedInputs(cleanCmd.Flags(), fastaFormat, "infile")
+	outputFlags(cleanCmd.Flags(), "outfile", "report")
+
+	cleanCmd.Flags().SortFlags = false
+}
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Check that sequences are made of IUPAC codes, and clean up the characters that aren't",
+	Long: `Check that sequences are made of IUPAC codes, and clean up the characters that aren't
+
+The other commands assume that sequences are made of IUPAC nucleotide codes (ACGTURYSWKMBDHVN, plus -
+for a gap and ? for missing data), and some of them treat anything else as an N, while others fail. clean
+checks each sequence before it gets that far, and replaces each character that isn't a valid code with N,
+deletes it, or fails with the name of the sequence and the position of the character. With --alphabet
+protein the codes are the IUPAC amino acid codes (plus * for a stop and - for a gap) and X is the
+replacement. Whitespace inside the sequences is removed.
+
+By default the sequences are written in upper case. Use --case lower or --case keep to change this, and
+--u-to-t to convert RNA to DNA.
+
+With --report, what was changed in each sequence is written to a tab-separated file with the columns:
+	query	length	invalid	invalid_characters	case_changed	u_to_t
+
+where invalid_characters is each different invalid character that was found (or - if there weren't any).
+The number of sequences that were changed is recorded in the --json-summary.
+
+Example usage:
+	gofasta clean -i sequences.fasta -o cleaned.fasta --report changes.tsv
+	gofasta clean -i proteins.fasta --alphabet protein --invalid fail -o checked.fasta`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = clean.Clean(cleanInfile, cleanOutfile, cleanReport, alphabetName, cleanInvalid, cleanCase, cleanUToT)
+
+		return
+	},
+}
@@ -4,22 +4,39 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/cov-ert/gofasta/pkg/closest"
+	"github.com/cov-ert/gofasta/pkg/usage"
 )
 
-var closestThreads int
 var closestQuery string
 var closestTarget string
 var closestOutfile string
 var closestN int
+var closestMmap bool
+var closestSketch string
+var closestCandidates int
+var closestSeed int64
 
 func init() {
 	rootCmd.AddCommand(closestCmd)
 
-	closestCmd.Flags().IntVarP(&closestThreads, "threads", "t", 0, "Number of CPUs to use (Default: all available CPUs)")
+	addThreadsShorthand(closestCmd.Flags())
 	closestCmd.Flags().StringVarP(&closestQuery, "query", "", "", "Alignment of sequences to find neighbours for, in fasta format")
 	closestCmd.Flags().StringVarP(&closestTarget, "target", "", "", "Alignment of sequences to search for neighbours in, in fasta format")
 	closestCmd.Flags().IntVarP(&closestN, "number", "n", 0, "(Optional) the closest n sequences to each query will be returned")
 	closestCmd.Flags().StringVarP(&closestOutfile, "outfile", "o", "stdout", "The output file to write")
+
+	closestCmd.Flags().BoolVarP(&closestMmap, "mmap", "", false, "Map the target alignment into memory instead of reading it onto the heap")
+	closestCmd.Flags().StringVarP(&closestSketch, "sketch", "", "", "(Optional) Sketches of the targets, written by gofasta sketch, to pick candidates with before they are compared")
+	closestCmd.Flags().IntVarP(&closestCandidates, "candidates", "", 1000, "With --sketch, the number of targets whose sketches are most similar to each query's that are compared with it")
+	closestCmd.Flags().Int64VarP(&closestSeed, "seed", "", -1, "Break ties between equally close and complete targets by a shuffle that only depends on this seed (by default, the one that is first in --target wins)")
+
+	addMaskFlag(closestCmd.Flags())
+	addMetadataFlags(closestCmd.Flags(), true)
+	addBatchFlags(closestCmd.Flags(), "query alignments")
+
+	inputFlags(closestCmd.Flags(), "query", "target", "sketch")
+	checkedInputs(closestCmd.Flags(), alignmentFormat, "query", "target")
+	outputFlags(closestCmd.Flags(), "outfile")
 }
 
 var closestCmd = &cobra.Command{
@@ -32,27 +49,84 @@ need to be found amongst a large number of target sequences. The query alignment
 into memory and the target alignment is read from disk and iterated over once.
 
 Closest neighbours are those with the lowest raw distance per site to the query sequence,
-and ties for this score are broken by how unambiguous the target genomes are.
+and ties for this score are broken by how unambiguous the target genomes are. Any targets
+that are still tied are ranked by their order in --target, or with --seed, by a shuffle of
+their names that only depends on the seed, so the results are the same on every run and
+machine (whatever --threads is). The seed is recorded in the --json-summary.
 
 You can find the single closest neighbour like:
 
-	gofasta closest -t 2 --query query.fasta --target target.fasta -o closest.csv
+	gofasta closest --threads 2 --query query.fasta --target target.fasta -o closest.csv
 
 and the output will be a CSV format file with the headers query, closest, SNPdistance, SNPs.
 
 Or you can find the nearest n neighbours:
 
-	gofasta closest -t 2 -n 1000 --query query.fasta --target target.fasta -o closest.n1000.csv
+	gofasta closest --threads 2 -n 1000 --query query.fasta --target target.fasta -o closest.n1000.csv
 
 and the output will be a CSV format file with just the headers query, closest. The 'closest' column
 is a ";"-delimited list of neighbours, closest first.
+
+With --mask, the masked sites (e.g. homoplasic or primer sites) are ignored in both the queries and
+the targets, so they don't count towards distances, SNPs or completeness.
+
+With --metadata (a csv file whose first column is the sequence name), you can search for only some of
+the queries with --where, --min-date and --max-date, and add metadata columns to the output with --annotate, e.g.:
+
+	gofasta closest --query query.fasta --target target.fasta --metadata metadata.csv --where "lineage==B.1.1.7" --annotate lineage,date
+
+The number of queries that aren't in the metadata is reported. With --name-format, fields parsed from the
+query names (see gofasta names --help) can be used in --where and --annotate as well as, or instead of, --metadata.
+
+With --mmap, the target alignment is mapped into memory, so it is read from the OS's page cache as it
+is needed rather than copied, which cuts memory use and start-up time when the same large target
+alignment is searched repeatedly. The targets can also be converted once with gofasta encode, which
+stores them already encoded so that they aren't parsed (encoded targets are always mapped, with or
+without --mmap, and with gofasta encode --unpacked they aren't copied either):
+
+	gofasta encode --unpacked -i target.fasta -o target.gfe
+	gofasta closest --query query.fasta --target target.gfe -o closest.csv
+
+For very large target alignments, the encoded targets can be sketched once with gofasta sketch, and
+each query is then only compared with the --candidates targets whose sketches are most similar to its
+own, which are read from the encoded file by index, instead of with every target:
+
+	gofasta sketch -i target.gfe -o target.gfs
+	gofasta closest --query query.fasta --target target.gfe --sketch target.gfs --candidates 1000 -o closest.csv
+
+The distances, SNPs and tie-breaks between the candidates are the same as without --sketch, but the
+candidates are picked by an estimate of similarity, so a target that is as close as the one that is
+reported can be missed if it isn't one of them. More candidates make this less likely.
+
+To search for the queries in many alignments (e.g. one per sequencing run) against the same targets, give
+them with --batch (or list them in a file, one per line, with --batch-list) instead of --query. The queries
+in all of them are compared with the targets together, so the targets are only read once, and the output for
+each is written to --outdir, named after it (e.g. run1.fasta's to run1.csv):
+
+	gofasta closest --batch run1.fasta,run2.fasta --target target.gfe --sketch target.gfs --outdir closest/
 `,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
+		md, err := loadMetadata()
+		if err != nil {
+			return err
+		}
+
+		queryFiles, outFiles := []string{closestQuery}, []string{closestOutfile}
+		if batchMode() {
+			if cmd.Flags().Changed("query") || cmd.Flags().Changed("outfile") {
+				return usage.New("--batch and --batch-list are instead of --query and --outfile")
+			}
+			queryFiles, outFiles, err = batchPaths(".csv")
+			if err != nil {
+				return err
+			}
+		}
+
 		if closestN > 0 {
-			err = closest.ClosestN(closestN, closestQuery, closestTarget, closestOutfile, closestThreads)
+			err = closest.ClosestNBatch(closestN, queryFiles, outFiles, closestTarget, maskFile, md, closestMmap, closestSketch, closestCandidates, closestSeed, threads)
 		} else {
-			err = closest.Closest(closestQuery, closestTarget, closestOutfile, closestThreads)
+			err = closest.ClosestBatch(queryFiles, outFiles, closestTarget, maskFile, md, closestMmap, closestSketch, closestCandidates, closestSeed, threads)
 		}
 
 		return err
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/columnize"
+)
+
+var columnizeInfile string
+var columnizeOutfile string
+var columnizeReport string
+var columnizeLength int
+var columnizeDrop bool
+
+func init() {
+	rootCmd.AddCommand(columnizeCmd)
+
+	columnizeCmd.Flags().StringVarP(&columnizeInfile, "infile", "i", "stdin", "Sequences that should all be the same length, in fasta format")
+	columnizeCmd.Flags().StringVarP(&columnizeOutfile, "outfile", "o", "stdout", "Where to write the sequences that are the right length, in fasta format")
+	columnizeCmd.Flags().StringVarP(&columnizeReport, "report", "", "", "Write the name and length of each sequence that isn't the right length to this tab-separated file (default: stderr)")
+	columnizeCmd.Flags().IntVarP(&columnizeLength, "length", "", 0, "The length that every sequence should be (default: the length of --reference, or the most common length)")
+	addReferenceFlag(columnizeCmd.Flags(), "(Optional) Reference sequence, in fasta format, whose length every sequence should be")
+	columnizeCmd.Flags().BoolVarP(&columnizeDrop, "drop", "", false, "Leave out the sequences that aren't the right length, instead of failing")
+
+	inputFlags(columnizeCmd.Flags(), "infile")
+	checkedInputs(columnizeCmd.Flags(), fastaFormat, "infile")
+	outputFlags(columnizeCmd.Flags(), "outfile", "report")
+
+	columnizeCmd.Flags().SortFlags = false
+}
+
+var columnizeCmd = &cobra.Command{
+	Use:   "columnize",
+	Short: "Check that sequences that should already be aligned are all the same length",
+	Long: `Check that sequences that should already be aligned are all the same length
+
+Consensus sequences that were all made against the same reference (e.g. by a pipeline that writes
+reference-length consensuses) are often used as an alignment without being aligned. If any of them
+isn't the same length as the rest, everything that works on alignment columns (snps, closest, distance,
+etc.) goes wrong. columnize checks this, and writes the sequences that are the right length, as they are
+read, and the name and length of each of the others, as a tab-separated report with the columns:
+	query	length	expected
+
+The length that the sequences should be is --length, or the length of the --reference, or (if neither is
+given) the most common length in the input, which has to be a file then, since it is read twice.
+
+Example usage:
+	gofasta columnize -i consensus.fasta -o aligned.fasta
+	gofasta columnize -i consensus.fasta -r reference.fasta --drop --report wrong_length.tsv -o aligned.fasta
+
+Unless --drop is used, the run fails (after writing the report) if any sequence is the wrong length. Either
+way, those sequences aren't written to --outfile, and the number of them is recorded in the --json-summary.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = columnize.Columnize(columnizeInfile, columnizeOutfile, columnizeReport, columnizeLength, reference, columnizeDrop)
+
+		return
+	},
+}
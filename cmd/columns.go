@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/columns"
+)
+
+var columnsInfile string
+var columnsOutfile string
+var columnsPositions []string
+var columnsBed string
+var columnsVariable bool
+var columnsPositionsOut string
+var columnsConstantSites string
+
+func init() {
+	rootCmd.AddCommand(columnsCmd)
+
+	columnsCmd.Flags().StringVarP(&columnsInfile, "infile", "i", "stdin", "Alignment to extract columns from, in fasta format")
+	columnsCmd.Flags().StringVarP(&columnsOutfile, "outfile", "o", "stdout", "Where to write the alignment of the extracted columns")
+	columnsCmd.Flags().StringSliceVarP(&columnsPositions, "positions", "p", nil, "Extract the columns at these 1-based positions (comma-separated, and ranges, e.g. 23063,23403,21563-21570)")
+	columnsCmd.Flags().StringVarP(&columnsBed, "bed", "", "", "Extract the columns in this BED file, or list of 1-based positions and ranges")
+	columnsCmd.Flags().BoolVarP(&columnsVariable, "variable", "", false, "Only extract the variable columns (with more than one of A, C, G and T)")
+	addMaskFlag(columnsCmd.Flags())
+	columnsCmd.Flags().StringVarP(&columnsPositionsOut, "positions-out", "", "", "Optionally, where to write the 1-based position of each extracted column, in csv format")
+	columnsCmd.Flags().StringVarP(&columnsConstantSites, "constant-sites", "", "", "With --variable, optionally, where to write the numbers of constant A, C, G and T columns that were left out, for IQ-TREE's -fconst")
+
+	inputFlags(columnsCmd.Flags(), "infile", "bed")
+	checkedInputs(columnsCmd.Flags(), alignmentFormat, "infile")
+	checkedInputs(columnsCmd.Flags(), maskFormat, "bed")
+	outputFlags(columnsCmd.Flags(), "outfile", "positions-out", "constant-sites")
+
+	columnsCmd.Flags().SortFlags = false
+}
+
+var columnsCmd = &cobra.Command{
+	Use:   "columns",
+	Short: "Extract some of the columns of an alignment, e.g. the variable sites",
+	Long: `Extract some of the columns of an alignment, e.g. the variable sites
+
+Example usage:
+	gofasta columns -i alignment.fasta --variable --mask problematic_sites.bed -o snps.fasta --positions-out snps.csv
+	gofasta columns -i alignment.fasta --variable -o snps.fasta --constant-sites fconst.txt
+	iqtree2 -s snps.fasta -fconst $(cat fconst.txt)
+	gofasta columns -i alignment.fasta -p 21563-25384 -o spike.fasta
+	gofasta columns -i alignment.fasta --bed amplicon.bed -o amplicon.fasta
+
+The columns at --positions and in --bed (a BED file, or a list of 1-based positions and ranges, like a
+--mask) are extracted, in the order they are in the alignment, or all of them if neither is given. With
+--variable, only the ones that have more than one of A, C, G and T in them (not counting gaps, Ns or other
+ambiguity codes) are extracted, e.g. to make a SNP-only alignment to build a tree from quickly, as snp-sites
+does. --variable reads the alignment twice, so it needs an --infile. Columns in --mask are never extracted.
+
+--positions-out is a csv file with one line for each extracted column, and the column 'position', its
+1-based position in the original alignment, so that the sites can be mapped back.
+
+--constant-sites, with --variable, is the numbers of the columns that were left out because they only have
+one of A, C, G or T in them, for each of A, C, G and T, comma-separated (e.g. 8894,5492,5863,9594), as IQ-TREE's
+-fconst option takes them, so that a tree built from the variable sites has the right branch lengths. Only
+the columns at --positions and in --bed (if they are given), and not in --mask, are counted, and columns
+with none of A, C, G and T (e.g. all Ns) aren't. They are counted in the pass that finds the variable sites.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = columns.Extract(columnsInfile, columnsOutfile, columnsPositionsOut, columnsConstantSites, columnsPositions, columnsBed, columnsVariable, maskFile)
+
+		return
+	},
+}
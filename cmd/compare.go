@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/compare"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+var compareOld string
+var compareNew string
+var compareOutfile string
+var compareAll bool
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+
+	compareCmd.Flags().StringVarP(&compareOld, "old", "", "", "One alignment of the samples, in fasta format, e.g. from the current version of a pipeline")
+	compareCmd.Flags().StringVarP(&compareNew, "new", "", "", "The other alignment of the samples, e.g. from the new version of the pipeline")
+	compareCmd.Flags().StringVarP(&compareOutfile, "outfile", "o", "stdout", "Where to write the differences")
+	compareCmd.Flags().BoolVarP(&compareAll, "all", "", false, "Write a line for the samples that are identical in both alignments too")
+
+	inputFlags(compareCmd.Flags(), "old", "new")
+	checkedInputs(compareCmd.Flags(), fastaFormat, "old", "new")
+	outputFlags(compareCmd.Flags(), "outfile")
+
+	compareCmd.Flags().SortFlags = false
+}
+
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Report how each sample differs between two alignments",
+	Long: `Report how each sample differs between two alignments
+
+Compares the sequence of each sample in --old with the sequence with the same name in --new, e.g. to check
+what an upgrade to a consensus pipeline changes before it is used on a whole dataset.
+
+Example usage:
+	gofasta compare --old aligned.v1.fasta --new aligned.v2.fasta -o differences.csv
+
+The output is a csv-format file with one line per sample that isn't identical in both (or every sample, with
+--all), and the columns
+	sample,status,bases,n_gained,n_lost,gaps_gained,gaps_lost,sites
+where status is identical, different, length_differs, only_old or only_new, bases is the number of sites with
+different bases (or ambiguity codes), n_gained and n_lost are the sites where a base became N (or another
+ambiguity code) or the other way round, gaps_gained and gaps_lost are the sites where a gap appeared or
+disappeared (changed indels), and sites lists the sites with different bases or gaps, e.g. 241:C>T|21765:T>-.
+The number of samples with each status is written to stderr.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		if len(compareOld) == 0 || len(compareNew) == 0 {
+			return usage.New("--old and --new are both needed")
+		}
+
+		err = compare.Compare(compareOld, compareNew, compareOutfile, compareAll)
+
+		return
+	},
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/config"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// configFile is the shared --config flag, for a file of flag values (see the config package)
+var configFile string
+
+// applyConfig sets the flags of cmd that weren't given on the command line from --config
+func applyConfig(cmd *cobra.Command) error {
+
+	if len(configFile) == 0 {
+		return nil
+	}
+
+	c, err := config.Load(configFile)
+	if err != nil {
+		return err
+	}
+
+	// the command's path without "gofasta", which its own section is named after
+	command := strings.Join(strings.Fields(cmd.CommandPath())[1:], " ")
+
+	// the most specific setting of each key wins, and none of them can add to another
+	settings := make(map[string]config.Setting)
+	keys := make([]string, 0)
+	for _, s := range c.Settings(command) {
+		if _, ok := settings[s.Key]; !ok {
+			keys = append(keys, s.Key)
+		}
+		settings[s.Key] = s
+	}
+
+	for _, key := range keys {
+		s := settings[key]
+		f := cmd.Flags().Lookup(key)
+		switch {
+		case key == "config":
+			return usage.Errorf("%s line %d: a config file can't set --config", configFile, s.Line)
+		case f == nil && s.Section == command:
+			return usage.Errorf("%s line %d: %s has no --%s flag", configFile, s.Line, cmd.CommandPath(), key)
+		case f == nil || f.Changed:
+			// settings for every command (or a parent command) only apply to the ones with that flag,
+			// and the command line overrides the config file
+			continue
+		}
+		for _, v := range s.Values {
+			err = cmd.Flags().Set(key, v)
+			if err != nil {
+				return usage.Errorf("%s line %d: %v", configFile, s.Line, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/constellation"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+var constellationsQuery string
+var constellationsDefinitions []string
+var constellationsGenbank string
+var constellationsOutfile string
+var constellationsEvidence string
+
+func init() {
+	rootCmd.AddCommand(constellationsCmd)
+
+	constellationsCmd.Flags().StringVarP(&constellationsQuery, "query", "q", "stdin", "Alignment of sequences to classify, in fasta format, aligned to the reference that the sites are numbered against")
+	constellationsCmd.Flags().StringSliceVarP(&constellationsDefinitions, "constellations", "c", nil, "Constellation definitions: json files, or directories of them (comma-separated, or repeated)")
+	constellationsCmd.Flags().StringVarP(&constellationsGenbank, "genbank", "g", "", "Genbank annotation of the reference, for amino acid sites (a file, or an accession to fetch from NCBI)")
+	constellationsCmd.Flags().StringVarP(&constellationsOutfile, "outfile", "o", "stdout", "Where to write the classifications")
+	constellationsCmd.Flags().StringVarP(&constellationsEvidence, "evidence", "", "", "(Optional) write the call at every site, and whether each rule passed, to this csv file")
+
+	addCodonsFlag(constellationsCmd.Flags())
+	addGeneAliasesFlag(constellationsCmd.Flags())
+	addBatchFlags(constellationsCmd.Flags(), "query alignments")
+
+	inputFlags(constellationsCmd.Flags(), "query", "constellations", "genbank")
+	checkedInputs(constellationsCmd.Flags(), alignmentFormat, "query")
+	checkedInputs(constellationsCmd.Flags(), genbankFormat, "genbank")
+	outputFlags(constellationsCmd.Flags(), "outfile", "evidence")
+
+	constellationsCmd.Flags().SortFlags = false
+}
+
+var constellationsCmd = &cobra.Command{
+	Use:   "constellations",
+	Short: "Classify aligned sequences against constellations of mutations",
+	Long: `Classify aligned sequences against constellations of mutations
+
+A constellation is a set of changes that defines a lineage or variant, with rules for how many of them a
+sequence needs, in the same json format as scorpio, e.g.:
+	{
+	  "label": "B.1.1.7-like",
+	  "sites": ["nuc:C3267T", "del:11288:9", "S:N501Y", "S:P681H", "orf1ab:T1001I"],
+	  "rules": {"min_alt": 4, "max_ref": 1, "S:N501Y": "alt"}
+	}
+
+Sites are nucleotide changes (nuc:C3267T), deletions (del:11288:9, 9 nucleotides from 11288) or amino acid
+changes (S:N501Y, for the CDS with /gene=S in --genbank, which can also be given by another of its names, e.g.
+spike:N501Y, with the built-in SARS-CoV-2 ones and any in --gene-aliases; an amino acid can be * for a stop or
+- for a deleted codon). Positions are 1-based, in the reference's coordinates, and the reference alleles are checked against
+--genbank if it is given. Partly deleted codons are translated according to --codons (see gofasta aatype
+--help): e.g. in sequences with the 21765-21770 deletion, S:V70- is missing by default, and alt with
+--codons del or frame. The rules are min_alt (how many sites must have the alt; by default, all of them),
+max_ref (how many can have the ref; by default, any number), and alt, ref, not alt or not ref for particular
+sites.
+
+Example usage:
+	gofasta constellations -q alignment.fasta -c constellations/ -g MN908947.gb -o classifications.csv --evidence evidence.csv
+
+The output is a csv-format file with one line per query per constellation, and the columns
+	query,constellation,status,alt,ref,oth,missing
+where status is match (every rule passed), partial (some alt sites, but not a match) or none, and alt, ref, oth
+and missing count the sites where the query has the alt allele, the ref allele, another allele, or ambiguous or
+missing data. With --evidence, the call and the allele at every site, and whether each rule passed, are written
+with the columns
+	query,constellation,site,call,allele,rule,pass
+
+To classify the queries in many alignments (e.g. one per sequencing run) with the same constellations, give
+them with --batch (or list them in a file, one per line, with --batch-list) instead of --query. The definitions
+and --genbank are only loaded once, and the classifications for each are written to --outdir, named after it
+(e.g. run1.fasta's to run1.csv), with its evidence (if --evidence is set to anything) in e.g. run1.evidence.csv:
+
+	gofasta constellations --batch-list runs.txt -c constellations/ -g MN908947.gb --outdir classifications/`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		policy, err := codons()
+		if err != nil {
+			return err
+		}
+
+		err = loadGeneAliases()
+		if err != nil {
+			return err
+		}
+
+		if !batchMode() {
+			return constellation.Constellations(constellationsQuery, constellationsDefinitions, constellationsGenbank, policy, constellationsOutfile, constellationsEvidence, numThreads())
+		}
+
+		if cmd.Flags().Changed("query") || cmd.Flags().Changed("outfile") {
+			return usage.New("--batch and --batch-list are instead of --query and --outfile")
+		}
+		queryFiles, outFiles, err := batchPaths(".csv")
+		if err != nil {
+			return err
+		}
+		var evidenceFiles []string
+		if len(constellationsEvidence) > 0 {
+			_, evidenceFiles, err = batchPaths(".evidence.csv")
+			if err != nil {
+				return err
+			}
+		}
+
+		err = constellation.ConstellationsBatch(queryFiles, constellationsDefinitions, constellationsGenbank, policy, outFiles, evidenceFiles, numThreads())
+
+		return
+	},
+}
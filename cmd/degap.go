@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/degap"
+)
+
+var degapInfile string
+var degapOutfile string
+var degapGapsFile string
+
+var regapInfile string
+var regapOutfile string
+var regapGapsFile string
+
+func init() {
+	rootCmd.AddCommand(degapCmd)
+	rootCmd.AddCommand(regapCmd)
+
+	degapCmd.Flags().StringVarP(&degapInfile, "infile", "i", "stdin", "Alignment to degap, in fasta format")
+	degapCmd.Flags().StringVarP(&degapOutfile, "outfile", "o", "stdout", "Where to write the degapped sequences")
+	degapCmd.Flags().StringVarP(&degapGapsFile, "gaps", "g", "", "Optionally, where to write a csv file of where the gaps were (for gofasta regap)")
+
+	regapCmd.Flags().StringVarP(&regapInfile, "infile", "i", "stdin", "Degapped sequences, in fasta format")
+	regapCmd.Flags().StringVarP(&regapGapsFile, "gaps", "g", "", "The gaps file written by gofasta degap")
+	regapCmd.Flags().StringVarP(&regapOutfile, "outfile", "o", "stdout", "Where to write the alignment")
+
+	inputFlags(degapCmd.Flags(), "infile")
+	outputFlags(degapCmd.Flags(), "outfile", "gaps")
+	inputFlags(regapCmd.Flags(), "infile", "gaps")
+	outputFlags(regapCmd.Flags(), "outfile")
+}
+
+var degapCmd = &cobra.Command{
+	Use:   "degap",
+	Short: "Remove the gaps from aligned sequences",
+	Long: `Remove the gaps from aligned sequences
+
+Example usage:
+	gofasta degap -i alignment.fasta -o unaligned.fasta -g gaps.csv
+
+This gives you back the sequences that were aligned, e.g. to realign a subset of them to a
+different reference. If --gaps is given, a csv file is written with one line per sequence, and
+three columns: 'query', 'length' (the aligned length) and 'gaps', a "|"-delimited list of the
+1-based, inclusive ranges that were gaps. gofasta regap can use it to undo the degapping.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = degap.Degap(degapInfile, degapOutfile, degapGapsFile)
+
+		return
+	},
+}
+
+var regapCmd = &cobra.Command{
+	Use:   "regap",
+	Short: "Put back the gaps removed by gofasta degap",
+	Long: `Put back the gaps removed by gofasta degap
+
+Example usage:
+	gofasta regap -i unaligned.fasta -g gaps.csv -o alignment.fasta
+
+Every sequence must be in the gaps file, and must be the same length as when it was degapped.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = degap.Regap(regapInfile, regapGapsFile, regapOutfile)
+
+		return
+	},
+}
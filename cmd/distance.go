@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/distance"
+)
+
+var distanceInfile string
+var distanceQuery string
+var distanceOutfile string
+var distanceFormat string
+var distanceThreshold int
+var distanceAmbiguity string
+var distanceGaps string
+
+func init() {
+	rootCmd.AddCommand(distanceCmd)
+
+	distanceCmd.Flags().StringVarP(&distanceInfile, "infile", "i", "stdin", "Alignment of sequences to find the distances between, in fasta format")
+	distanceCmd.Flags().StringVarP(&distanceQuery, "query", "q", "", "(Optional) alignment of queries, to find the distance from each to every sequence in --infile instead")
+	distanceCmd.Flags().StringVarP(&distanceOutfile, "outfile", "o", "stdout", "Where to write the distances")
+	distanceCmd.Flags().StringVarP(&distanceFormat, "format", "", "square", "Format of the output (choose one of: square, long, sparse)")
+	distanceCmd.Flags().IntVarP(&distanceThreshold, "threshold", "", -1, "With --format sparse, only write the pairs that are at most this many SNPs apart")
+	distanceCmd.Flags().StringVarP(&distanceAmbiguity, "ambiguity", "", "match", "How to count ambiguity codes: match (not a difference from what they could be), mismatch (a difference from anything else) or skip (never a difference)")
+	distanceCmd.Flags().StringVarP(&distanceGaps, "gaps", "", "skip", "How to count gaps: skip (never a difference) or mismatch (a difference from anything but a gap)")
+	addMaskFlag(distanceCmd.Flags())
+	addAlphabetFlag(distanceCmd.Flags())
+
+	inputFlags(distanceCmd.Flags(), "infile", "query")
+	checkedInputs(distanceCmd.Flags(), alignmentFormat, "infile", "query")
+	outputFlags(distanceCmd.Flags(), "outfile")
+
+	distanceCmd.Flags().SortFlags = false
+}
+
+var distanceCmd = &cobra.Command{
+	Use:   "distance",
+	Short: "Write a matrix of pairwise SNP distances",
+	Long: `Write a matrix of pairwise SNP distances
+
+Example usage:
+	gofasta distance -i alignment.fasta -o distances.tsv
+	gofasta distance -i panel.fasta -q queries.fasta -o distances.tsv
+	gofasta distance -i alignment.fasta --format sparse --threshold 2 -o clusters.tsv
+
+The distance between two sequences is the number of sites at which they differ, not counting sites
+where either has a gap or an ambiguous nucleotide that could be the same as the other's (so an N is
+never a difference, and nor is an R against an A). With --mask, the masked sites aren't counted either.
+
+Different analyses count differences differently, so how ambiguity codes and gaps are counted can be changed.
+With --ambiguity mismatch, an ambiguity code (including an N) is a difference from anything but the same code,
+and with --ambiguity skip, sites where either sequence has one are never a difference (so an R against a C
+isn't one either). With --gaps mismatch, a gap is a difference from anything but another gap (including an N),
+e.g. to count deletions as differences. Masked sites are never a difference, whatever the settings. Other
+settings than the defaults are a little slower, because the sequences can't be packed.
+
+Without --query, the distance between every pair of sequences in --infile is written. With --query, the
+distance from each query to every sequence in --infile (the panel) is written instead.
+
+The output is tab-separated, after a ## line recording the version of gofasta and the command line. With
+--format square (the default), it is a matrix with a row per sequence (or query) and a column per sequence.
+With --format long, it has the columns query, target and distance, with one line per pair (without --query,
+each pair is written once, and sequences aren't paired with themselves). --format sparse is the same as long,
+but only the pairs that are at most --threshold SNPs apart are written, which is much smaller for cluster
+detection on large alignments.
+
+With --alphabet protein, the sequences are amino acid sequences, and an ambiguous code isn't a difference
+from the amino acids it could be (B is D or N, Z is E or Q, J is I or L, and X is anything).
+
+Nucleotide sequences are packed into 4 bits per nucleotide for counting, and the rows are counted in parallel.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		a, err := sequenceAlphabet()
+		if err != nil {
+			return
+		}
+
+		opts := distance.Options{
+			Format:    distanceFormat,
+			Threshold: distanceThreshold,
+			MaskFile:  maskFile,
+			Ambiguity: distanceAmbiguity,
+			Gaps:      distanceGaps,
+			Alphabet:  a,
+			Threads:   numThreads(),
+		}
+		err = distance.Distance(distanceInfile, distanceQuery, distanceOutfile, opts)
+
+		return
+	},
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+var encodeInfile string
+var encodeOutfile string
+var encodeUnpacked bool
+var encodeAppend bool
+var encodeRemove string
+
+func init() {
+	rootCmd.AddCommand(encodeCmd)
+
+	encodeCmd.Flags().StringVarP(&encodeInfile, "infile", "i", "stdin", "Alignment to encode, in fasta format")
+	encodeCmd.Flags().StringVarP(&encodeOutfile, "outfile", "o", "stdout", "Where to write the encoded alignment (it can't be stdout)")
+
+	encodeCmd.Flags().BoolVarP(&encodeUnpacked, "unpacked", "", false, "Store each base in a byte, instead of packing two into each byte")
+	encodeCmd.Flags().BoolVarP(&encodeAppend, "append", "", false, "Add the sequences in --infile to the end of the existing encoded --outfile")
+	encodeCmd.Flags().StringVarP(&encodeRemove, "remove", "", "", "Remove the sequences named in this file (one per line) from the existing encoded --outfile")
+
+	inputFlags(encodeCmd.Flags(), "infile", "remove")
+	checkedInputs(encodeCmd.Flags(), fastaFormat, "infile")
+	outputFlags(encodeCmd.Flags(), "outfile")
+}
+
+var encodeCmd = &cobra.Command{
+	Use:   "encode",
+	Short: "Store an alignment in a binary format that can be mapped into memory",
+	Long: `Store an alignment in a binary format that can be mapped into memory
+
+Example usage:
+	gofasta encode -i target.fasta -o target.gfe
+
+The sequences are stored in the bitwise coding scheme that gofasta uses internally, as fixed-width
+records followed by a table of names, so that a large alignment that is used repeatedly doesn't
+have to be parsed as text each time. Every command that reads an alignment from a file accepts
+the encoded file in place of fasta.
+
+The bases are packed into 4 bits each, which halves the size of the file (unless the alignment
+uses all 17 nucleotide codes, including '?', which is an error). With --unpacked, each base takes
+a byte, and the --target of gofasta closest is then used straight from the file's mapping in memory,
+without being copied onto the heap at all.
+
+An encoded alignment that grows (e.g. as new sequences are released each day) can be updated,
+instead of being encoded again from scratch. --append adds the sequences in --infile to the end of it,
+and --remove marks the sequences named in a file as removed, so that they are skipped when it is read.
+Removed sequences are still stored, so that the others keep their places (and their sketches, from gofasta
+sketch, stay in step with them) until it is encoded again from scratch. With both, sequences are removed
+before any are appended, so that a sequence can be replaced by a new version of it:
+
+	gofasta encode --remove withdrawn.txt -o target.gfe
+	gofasta encode --append -i new.fasta -o target.gfe
+	gofasta sketch --update -i target.gfe -o target.gfs
+
+Each update writes a new copy of the file next to it (so there has to be room for one) and then renames
+it over the file, so an update that fails leaves the file as it was, and anything that is already reading
+it (e.g. gofasta closest) carries on with the old version.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		if !encodeAppend && len(encodeRemove) == 0 {
+			return fastaio.WriteEncoded(encodeInfile, encodeOutfile, encodeUnpacked)
+		}
+
+		if encodeUnpacked {
+			return usage.New("--unpacked can't be changed when an encoded alignment is updated")
+		}
+
+		if len(encodeRemove) > 0 {
+			err = fastaio.RemoveEncoded(encodeOutfile, encodeRemove)
+			if err != nil {
+				return err
+			}
+		}
+
+		if encodeAppend {
+			err = fastaio.AppendEncoded(encodeInfile, encodeOutfile)
+		}
+
+		return
+	},
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/faidx"
+)
+
+var faidxInfile string
+var faidxNames string
+var faidxOutfile string
+
+func init() {
+	rootCmd.AddCommand(faidxCmd)
+
+	faidxCmd.Flags().StringVarP(&faidxInfile, "infile", "i", "stdin", "Fasta file to index (it can't be stdin)")
+	faidxCmd.Flags().StringVarP(&faidxNames, "names", "n", "", "Optionally, a file with the names of records to extract, one per line")
+	faidxCmd.Flags().StringVarP(&faidxOutfile, "outfile", "o", "stdout", "Where to write the extracted records")
+
+	inputFlags(faidxCmd.Flags(), "infile", "names")
+	checkedInputs(faidxCmd.Flags(), fastaFormat, "infile")
+	outputFlags(faidxCmd.Flags(), "outfile")
+}
+
+var faidxCmd = &cobra.Command{
+	Use:   "faidx [names...]",
+	Short: "Index a fasta file, and extract records from it by name",
+	Long: `Index a fasta file, and extract records from it by name
+
+Example usage:
+	gofasta faidx -i sequences.fasta
+	gofasta faidx -i sequences.fasta seq1 seq2 -o some.fasta
+	gofasta faidx -i sequences.fasta -n names.txt -o some.fasta
+
+The index is written to sequences.fasta.fai (in the same format as samtools faidx), if it isn't
+there already or is older than the fasta file. Records named as arguments or in --names are then
+read using the index, without reading the rest of the file, and written in the order they were given.
+Every line of a record's sequence, except its last, must be the same length.
+
+The sam subcommands also use the index of --reference, if it has an up to date one, to read only
+the record named in the SAM header's @SQ line, so that the reference can be one of many sequences
+in a large file.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = faidx.Faidx(faidxInfile, args, faidxNames, faidxOutfile)
+
+		return
+	},
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/filter"
+)
+
+var filterInfile string
+var filterOutfile string
+
+func init() {
+	rootCmd.AddCommand(filterCmd)
+
+	filterCmd.Flags().StringVarP(&filterInfile, "infile", "i", "stdin", "Alignment to filter, in fasta format")
+	filterCmd.Flags().StringVarP(&filterOutfile, "outfile", "o", "stdout", "Where to write the sequences that pass, in fasta format")
+	addMetadataFlags(filterCmd.Flags(), false)
+	addHeaderTemplateFlag(filterCmd.Flags())
+
+	inputFlags(filterCmd.Flags(), "infile")
+	checkedInputs(filterCmd.Flags(), fastaFormat, "infile")
+	outputFlags(filterCmd.Flags(), "outfile")
+
+	filterCmd.Flags().SortFlags = false
+}
+
+var filterCmd = &cobra.Command{
+	Use:   "filter",
+	Short: "Extract the sequences whose metadata matches, e.g. from a date range",
+	Long: `Extract the sequences whose metadata matches, e.g. from a date range
+
+The sequences are joined by name to --metadata (a csv file with a header, whose first column is the
+sequence name), and/or to the fields parsed from their names with --name-format (see gofasta names --help),
+and those that match every --where and are dated from --min-date to --max-date are written as they are read,
+so a slice of a huge alignment can be extracted in one pass:
+	gofasta filter -i alignment.fasta --metadata metadata.csv --min-date 2020-03-01 --max-date 2020-03-31 -o march.fasta
+	gofasta filter -i alignment.fasta --name-format gisaid --where country==Wales --min-date 2021 -o wales.fasta
+
+Dates are ISO (yyyy-mm-dd), and are read from the --date-column column (by default, date). Incomplete dates
+(2020-03, or 2020) are allowed both in the metadata and as limits: a limit covers the whole month or year
+(so --max-date 2020-03 is the same as --max-date 2020-03-31), and a sequence with an incomplete date is kept
+if any of the days it could be are in the range. Sequences without a date that can be parsed are dropped.
+
+With --header-template, the sequences are named by filling in a template of {name} (the sequence's name) and
+columns of --metadata or fields of --name-format, so that they are labelled the way tree software expects, with or
+without filtering them (sequences that aren't in the metadata keep their names):
+	gofasta filter -i alignment.fasta --metadata metadata.csv --header-template "{name}|{date}|{lineage}" -o labelled.fasta
+
+The numbers of sequences that weren't in the metadata, and that had no date, are written to stderr.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		md, err := loadMetadata()
+		if err != nil {
+			return
+		}
+
+		err = filter.Filter(filterInfile, filterOutfile, md)
+
+		return
+	},
+}
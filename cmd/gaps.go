@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/gaps"
+)
+
+var gapsInfile string
+var gapsOutfile string
+var gapsMinLength int
+var gapsPrimers string
+var gapsDropouts string
+var gapsDropoutSummary string
+
+func init() {
+	rootCmd.AddCommand(gapsCmd)
+
+	gapsCmd.Flags().StringVarP(&gapsInfile, "infile", "i", "stdin", "Alignment to find the gaps in, in fasta format")
+	addReferenceFlag(gapsCmd.Flags(), "(Optional) Reference sequence that the alignment is in the coordinates of, in fasta format, whose name is used as the BED chromosome")
+	gapsCmd.Flags().StringVarP(&gapsOutfile, "outfile", "o", "stdout", "Where to write the gaps, in BED format")
+	gapsCmd.Flags().IntVarP(&gapsMinLength, "min-length", "", 20, "Only report runs of at least this many Ns and gaps")
+	gapsCmd.Flags().StringVarP(&gapsPrimers, "primers", "", "", "(Optional) ARTIC-style primer BED file, to find the amplicons that are missing from each sequence")
+	gapsCmd.Flags().StringVarP(&gapsDropouts, "dropouts", "", "", "With --primers, where to write the amplicons that are partly or wholly missing from each sequence")
+	gapsCmd.Flags().StringVarP(&gapsDropoutSummary, "dropout-summary", "", "", "With --primers, where to write how many sequences each amplicon is complete, partly missing and missing in")
+
+	inputFlags(gapsCmd.Flags(), "infile", "primers")
+	checkedInputs(gapsCmd.Flags(), alignmentFormat, "infile")
+	outputFlags(gapsCmd.Flags(), "outfile", "dropouts", "dropout-summary")
+
+	gapsCmd.Flags().SortFlags = false
+}
+
+var gapsCmd = &cobra.Command{
+	Use:   "gaps",
+	Short: "Report the runs of Ns and gaps in each sequence of an alignment, in BED format",
+	Long: `Report the runs of Ns and gaps in each sequence of an alignment, in BED format
+
+Example usage:
+	gofasta gaps -i aligned.fasta -r MN908947.fasta --min-length 50 -o gaps.bed
+	bedtools intersect -a gaps.bed -b primer_scheme.bed -wa -wb
+	gofasta gaps -i aligned.fasta --primers SARS-CoV-2.primer.bed --dropouts dropouts.tsv --dropout-summary amplicons.tsv -o gaps.bed
+
+Every run of at least --min-length Ns and gaps (in any mix) in each sequence is written, e.g. the amplicons
+that dropped out of a consensus, or the ends that weren't sequenced, so that failing amplicons can be
+tracked from one run to the next. The columns are the chromosome (the name of the --reference, or
+"reference" if there isn't one), the 0-based, end-exclusive start and end of the run, as in any BED file,
+the name of the sequence, and the length of the run. The file starts with a ##gofasta=<version> command=<command
+line> comment. A run at the start (or end) of a sequence starts at 0 (or ends at the width of the alignment).
+
+With --primers, an ARTIC-style primer BED file (chromosome, start, end and name, e.g. SARS-CoV-2_1_LEFT,
+SARS-CoV-2_1_RIGHT or SARS-CoV-2_1_LEFT_alt1), the amplicons that are missing from each sequence are found
+too, to help troubleshoot a primer scheme. Each amplicon's insert runs between the inner ends of its
+innermost left and right primers, and an amplicon is missing from a sequence if all of its insert is Ns and
+gaps, partly missing if some of it is, and otherwise complete. --dropouts is a tab-separated file with a line
+for each amplicon that is missing or partly missing from each sequence, with the columns query, amplicon,
+insert_start and insert_end (1-based and inclusive), missing_bases and status (missing or partial).
+--dropout-summary has a line for each amplicon, with the columns amplicon, insert_start, insert_end, and how
+many of the sequences it is complete, partial and missing in, to find the amplicons that drop out across
+the run. Both start with a ##gofasta=<version> command=<command line> line.
+
+The sequences have to be aligned to the reference already (e.g. with sam toMultiAlign), and if a --reference
+is given, they have to be as long as it is.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = gaps.Report(gapsInfile, gapsOutfile, reference, gapsMinLength, gapsPrimers, gapsDropouts, gapsDropoutSummary)
+
+		return
+	},
+}
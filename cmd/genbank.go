@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/genbank"
+)
+
+var genbankFile string
+var genbankOutfile string
+var genbankRNA bool
+
+func init() {
+	rootCmd.AddCommand(genbankCmd)
+	genbankCmd.AddCommand(genbankToFastaCmd)
+	genbankCmd.AddCommand(genbankToGFFCmd)
+	genbankCmd.AddCommand(genbankValidateCmd)
+
+	genbankToFastaCmd.Flags().BoolVarP(&genbankRNA, "rna", "", false, "Write the sequence as RNA (U instead of T)")
+
+	genbankCmd.PersistentFlags().StringVarP(&genbankFile, "genbank", "g", "", "Genbank file to read, or an accession (e.g. NC_045512.2) to fetch from NCBI")
+	genbankCmd.PersistentFlags().StringVarP(&genbankOutfile, "outfile", "o", "stdout", "Where to write the output")
+
+	inputFlags(genbankCmd.PersistentFlags(), "genbank")
+	checkedInputs(genbankCmd.PersistentFlags(), genbankFormat, "genbank")
+	outputFlags(genbankCmd.PersistentFlags(), "outfile")
+}
+
+var genbankCmd = &cobra.Command{
+	Use:   "genbank",
+	Short: "Do things with genbank files",
+	Long: `Do things with genbank files
+
+Anywhere gofasta takes a genbank file, you can give an accession instead (e.g. -g NC_045512.2),
+and the record will be downloaded from NCBI. Downloaded records are cached in $GOFASTA_CACHE_DIR
+if it is set, or your user cache directory otherwise, so each one is only downloaded once.
+
+Wherever a genbank file is read, it is checked for consistency (see gofasta genbank validate --help),
+and any problems are written to stderr as warnings.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		return nil
+	},
+}
+
+var genbankToFastaCmd = &cobra.Command{
+	Use:     "toFasta",
+	Aliases: []string{"tofasta"},
+	Short:   "Write the sequence in a genbank file in fasta format",
+	Long: `Write the sequence in a genbank file in fasta format
+
+The header is the record's accession.version and its definition, so a genbank reference can
+be used directly with aligners, e.g.:
+	gofasta genbank toFasta -g MN908947.gb -o MN908947.fasta
+	minimap2 -a -x asm5 MN908947.fasta unaligned.fasta > aligned.sam
+
+The sequence of an RNA record (e.g. a RefSeq mRNA) is read as DNA, as it is everywhere else in gofasta,
+so it is written with T instead of U unless you use --rna.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = genbank.ToFasta(genbankFile, genbankOutfile, genbank.ToFastaOptions{RNA: genbankRNA})
+
+		return
+	},
+}
+
+var genbankToGFFCmd = &cobra.Command{
+	Use:     "toGFF",
+	Aliases: []string{"togff"},
+	Short:   "Write the features in a genbank file in GFF3 format",
+	Long: `Write the features in a genbank file in GFF3 format
+
+Example usage:
+	gofasta genbank toGFF -g MN908947.gb -o MN908947.gff3
+
+Each feature is written on one line, or one line per part for features with joined locations
+(all with the same ID). The genbank qualifiers (except /translation) become GFF3 attributes,
+and features with a /gene qualifier get a Parent attribute linking them to that gene.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = genbank.ToGFF(genbankFile, genbankOutfile)
+
+		return
+	},
+}
+
+var genbankValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check that a genbank file is consistent",
+	Long: `Check that a genbank file is consistent
+
+Example usage:
+	gofasta genbank validate -g MN908947.gb
+
+The length on the LOCUS line is checked against the length of the ORIGIN sequence, the numbering
+of each line of ORIGIN against the number of bases before it, and every feature's location against
+the length of the sequence (and whether it can be parsed at all). An annotation that fails these
+checks would make positions in it, and so variant calls against it, silently wrong.
+
+The problems are written as a tsv file with the columns line (of the genbank file), feature and
+problem, and the exit code is 1 if there are any.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = genbank.Validate(genbankFile, genbankOutfile)
+
+		return
+	},
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/haplotype"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+var haplotypesQuery string
+var haplotypesOutfile string
+var haplotypesPositions []string
+var haplotypesCombinations []string
+
+func init() {
+	rootCmd.AddCommand(haplotypesCmd)
+
+	addReferenceFlag(haplotypesCmd.Flags(), "Reference sequence, in fasta format")
+	haplotypesCmd.Flags().StringVarP(&haplotypesQuery, "query", "q", "stdin", "Alignment of sequences to count the haplotypes of, in fasta format, aligned to --reference")
+	haplotypesCmd.Flags().StringVarP(&haplotypesOutfile, "outfile", "o", "stdout", "Where to write the counts")
+	haplotypesCmd.Flags().StringSliceVarP(&haplotypesPositions, "positions", "p", nil, "Count the haplotypes at these 1-based sites (comma-separated, and ranges, e.g. 23063,23403,21563-21570)")
+	haplotypesCmd.Flags().StringArrayVarP(&haplotypesCombinations, "combination", "c", nil, "Count the queries with all, some or none of these mutations, joined by + (e.g. A23063T+A23403G; can be repeated)")
+	addMetadataFlags(haplotypesCmd.Flags(), false)
+
+	inputFlags(haplotypesCmd.Flags(), "query")
+	checkedInputs(haplotypesCmd.Flags(), alignmentFormat, "query")
+	outputFlags(haplotypesCmd.Flags(), "outfile")
+
+	haplotypesCmd.Flags().SortFlags = false
+}
+
+var haplotypesCmd = &cobra.Command{
+	Use:   "haplotypes",
+	Short: "Count the combinations of mutations that aligned sequences have",
+	Long: `Count the combinations of mutations that aligned sequences have
+
+With --positions, every haplotype (the bases at those sites) that the queries have is counted, e.g.:
+	gofasta haplotypes -r reference.fasta -q alignment.fasta -p 23063,23403,23604 -o haplotypes.csv
+The output is a csv-format file with one line per haplotype, most common first, and the columns
+	haplotype,mutations,count,frequency
+where haplotype is the bases at the sites (e.g. TGA), and mutations its differences from the reference (e.g.
+A23063T|A23403G). Queries with N or another ambiguity code at any of the sites aren't counted, or included in
+the frequencies, and how many there were is written to stderr.
+
+With --combination (which can be repeated), the queries that have all, some or none of the mutations in each
+combination are counted instead, e.g.:
+	gofasta haplotypes -r reference.fasta -q alignment.fasta -c A23063T+A23403G -c C21765- -o combinations.csv
+with one line per combination, and the columns
+	combination,all,some,none,missing,frequency
+where missing is the queries with N or another ambiguity code at any of its sites (which aren't counted in the
+others), and frequency is all as a proportion of the queries that aren't missing. A mutation's reference base
+must match --reference, and - is a deletion.
+
+With --metadata or --name-format, only the queries that match --where (and the date range, if given) are
+counted, e.g. to count the haplotypes of one lineage.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		if (len(haplotypesPositions) == 0) == (len(haplotypesCombinations) == 0) {
+			return usage.New("give either --positions or --combination")
+		}
+		if len(reference) == 0 {
+			return usage.New("haplotypes needs the --reference")
+		}
+
+		md, err := loadMetadata()
+		if err != nil {
+			return
+		}
+
+		if len(haplotypesPositions) > 0 {
+			return haplotype.Haplotypes(reference, haplotypesQuery, haplotypesPositions, md, haplotypesOutfile)
+		}
+
+		return haplotype.Combinations(reference, haplotypesQuery, haplotypesCombinations, md, haplotypesOutfile)
+	},
+}
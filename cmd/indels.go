@@ -8,15 +8,36 @@ import (
 
 var indelsInsOut string
 var indelsDelOut string
+var indelsInsFasta string
 var indelsThreshold int
+var indelsFormat string
+var indelsNoSamples bool
+var indelsSort string
+var indelsReadStats bool
+var indelsLeftAlign bool
+var indelsMerge []string
 
 func init() {
 	samCmd.AddCommand(indelCmd)
 
 	indelCmd.Flags().StringVarP(&indelsInsOut, "insertions-out", "", "insertions.txt", "Where to write the insertions")
 	indelCmd.Flags().StringVarP(&indelsDelOut, "deletions-out", "", "deletions.txt", "Where to write the deletions")
+	indelCmd.Flags().StringVarP(&indelsInsFasta, "insertions-fasta", "", "", "(Optional) Where to write every inserted sequence, in fasta format")
 	indelCmd.Flags().IntVarP(&indelsThreshold, "threshold", "", 2, "Minimum count for an indel to be included in the output")
 
+	indelCmd.Flags().StringVarP(&indelsFormat, "format", "", "tsv", "Format of the insertions and deletions files (choose one of: tsv, csv, json)")
+	indelCmd.Flags().BoolVarP(&indelsNoSamples, "no-samples", "", false, "Write the number of queries with each indel instead of their names")
+
+	indelCmd.Flags().StringVarP(&indelsSort, "sort", "", "sequence", "How to order the indels at each position (choose one of: sequence, count)")
+	indelCmd.Flags().BoolVarP(&indelsReadStats, "read-stats", "", false, "Write how many of the reads with each indel are on each strand, and whether they all start or end at the same position")
+
+	indelCmd.Flags().BoolVarP(&indelsLeftAlign, "left-align", "", false, "Move each indel as far left as it can go in the --reference before counting it, as bcftools norm does")
+
+	indelCmd.Flags().StringSliceVarP(&indelsMerge, "merge", "", nil, "More SAM files, or tsv insertions/deletions reports from earlier runs, to merge with the --samfile (comma-separated, or repeated)")
+
+	inputFlags(indelCmd.Flags(), "merge")
+	outputFlags(indelCmd.Flags(), "insertions-out", "deletions-out", "insertions-fasta")
+
 	indelCmd.Flags().SortFlags = false
 }
 
@@ -35,14 +56,83 @@ The format of insertions.txt is a three-column, tab-separated file with the head
 The format of deletions.txt is a three-column, tab-separated file with the headers: ref_start	length	samples
 
 the 'samples' column is a "|"-separated list of the queries with the insertion/deletion described by the first two columns.
+A "|" in a query's name (as in GISAID's names) is written as %7C, and a "%" as %25, so that the list can be split up again.
+Both files start with a ##gofasta=<version> command=<command line> line, recording how they were made.
+
+With --format csv, the same columns are written comma-separated (and quoted where needed). With --format json,
+each file is an array with one object per indel, e.g.:
+	{"ref_start":21765,"length":6,"count":2,"samples":["seq1","seq2"]}
+Indels are ordered by position, and then by inserted sequence (or deletion length), so the output is the
+same from one run to the next. With --sort count, the indels at each position are ordered by how many queries
+have them, most first.
+
+With --no-samples, the samples column is replaced by a count column (and json objects have no samples).
+
+With --reference, two more columns are written after the first two: repeat_unit and repeat_length, the
+homopolymer or short tandem repeat (with a unit of up to 6 bases) in the reference that the indel is in, and
+how long it is in the reference. Indels in homopolymers are often artefacts, especially from nanopore reads,
+so this is a way to filter them out. An indel is in a repeat if it adds or removes whole copies of the unit
+where there are at least two of them in the reference (e.g. deleting an A from AAAA, or inserting an AT into
+ATATAT), and the shortest unit that fits is used; other indels have an empty unit and a length of 0. In json,
+they are in a "repeat" object.
+
+With --left-align (which needs the --reference), each indel is moved as far left along the reference as it
+can go while making the same change to the sequence, as bcftools norm does, before it is counted. Aligners
+can put the same indel in a repeat at different positions (e.g. deleting any one of the As in AAAA), so
+without this the same indel in samples that were aligned with different tools can be counted as different
+indels. An insertion that is moved has its sequence rotated to match (inserting TA after ATA is the same as
+inserting AT before it), and the positions in --insertions-fasta are the left-aligned ones too.
+
+With --read-stats, four more columns are written after those, to help tell real indels from alignment
+artefacts: forward and reverse, the numbers of reads with the indel on each strand (a real indel is usually on
+both), and same_start and same_end, which are true if all of the reads with it start (or end) at the same
+position on the reference, as they do when an artefact comes from e.g. one amplicon or primer (they are always
+true for an indel in only one read). In json, they are in a "reads" object. These are counted for every
+record with the indel, so they are read-level stats for a SAM file of reads, rather than of consensuses.
+
+With --merge, the indels in more SAM files, and in tsv insertions and deletions reports from earlier runs
+(e.g. of the batches of a large dataset), are counted together with the --samfile's, which can then be left
+out. Reports are told apart from SAM files by their first line, and from each other by their headers, so
+they can be given in any order. Each row of a report counts once for every sample in it (a report written
+with --no-samples can only be merged into another --no-samples report), and since a report only has the
+indels that passed its own --threshold, the counts of the rarer indels can be lower than they would be from
+the SAM files. Any other columns in a report are worked out again, from the --reference, and --read-stats
+needs the reads, so it can't be used with reports. With more than one input, a files column is written before
+the samples (or count): the "|"-separated inputs that each indel is in, as they were given. In json, this is
+a "files" array.
+
+With --insertions-fasta, every insertion in every query (whatever the threshold) is also written in fasta
+format, e.g. to BLAST them or check for primer/adapter contamination. The headers are query:ref_start:length,
+where ref_start is the same as in insertions.txt.
 
 Example usage:
 	gofasta sam indels -s aligned.sam --threshold 2 --insertions-out insertions.txt --deletions-out deletions.txt
+	gofasta sam indels -s reads.sam -r reference.fasta --read-stats --threshold 5
+	gofasta sam indels -s combined.sam -r reference.fasta --left-align
+	gofasta sam indels --merge batch1_insertions.txt,batch1_deletions.txt,batch2.sam --no-samples
 `,
 
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		err = sam.Indels(samFile, indelsInsOut, indelsDelOut, indelsThreshold)
+		// with --merge, the --samfile is optional
+		inputs := indelsMerge
+		if len(samFile) > 0 || len(inputs) == 0 {
+			inputs = append([]string{samFile}, inputs...)
+		}
+
+		opts := sam.IndelsOptions{
+			ReferenceFile: reference,
+			Normalize:     indelsLeftAlign,
+			Threshold:     indelsThreshold,
+			Format:        indelsFormat,
+			NoSamples:     indelsNoSamples,
+			SortBy:        indelsSort,
+			WithReadStats: indelsReadStats,
+			SkipCorrupt:   samSkipCorrupt,
+			MissingSeq:    samMissingSeq,
+			Threads:       numThreads(),
+		}
+		err = sam.Indels(inputs, indelsInsOut, indelsDelOut, indelsInsFasta, opts)
 
 		return
 	},
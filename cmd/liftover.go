@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/liftover"
+)
+
+var liftoverAlignment string
+var liftoverInfile string
+var liftoverOutfile string
+var liftoverMinCompleteness float64
+var liftoverMaxN int
+var liftoverRejects string
+
+func init() {
+	rootCmd.AddCommand(liftoverCmd)
+	liftoverCmd.AddCommand(liftoverFastaCmd)
+	liftoverCmd.AddCommand(liftoverBedCmd)
+	liftoverCmd.AddCommand(liftoverSNPsCmd)
+
+	liftoverCmd.PersistentFlags().StringVarP(&liftoverAlignment, "alignment", "a", "", "Pairwise alignment of the old (first record) and new (second record) references, in fasta format")
+	liftoverCmd.PersistentFlags().StringVarP(&liftoverInfile, "infile", "i", "stdin", "File to lift over")
+	liftoverCmd.PersistentFlags().StringVarP(&liftoverOutfile, "outfile", "o", "stdout", "Where to write the output")
+
+	liftoverFastaCmd.Flags().Float64VarP(&liftoverMinCompleteness, "min-completeness", "", 0, "Drop sequences with less than this proportion (0-1) of sites that aren't missing data (N, or unsequenced ends)")
+	liftoverFastaCmd.Flags().IntVarP(&liftoverMaxN, "max-n", "", -1, "Drop sequences with more than this many Ns (-1 for no limit)")
+	liftoverFastaCmd.Flags().StringVarP(&liftoverRejects, "rejects", "", "", "Write the sequences dropped by --min-completeness or --max-n to this fasta file, with the reason in the header")
+
+	inputFlags(liftoverCmd.PersistentFlags(), "alignment", "infile")
+	checkedInputs(liftoverCmd.PersistentFlags(), alignmentFormat, "alignment")
+	outputFlags(liftoverCmd.PersistentFlags(), "outfile")
+	outputFlags(liftoverFastaCmd.Flags(), "rejects")
+}
+
+var liftoverCmd = &cobra.Command{
+	Use:   "liftover",
+	Short: "Move things from one reference's coordinates to another's",
+	Long: `Move things from one reference's coordinates to another's
+
+The two references are given as a pairwise alignment in fasta format (-a), with the old reference
+first and the new reference second, e.g.:
+	cat old.fasta new.fasta | mafft - > refs.fasta
+	gofasta liftover fasta -a refs.fasta -i alignment.fasta -o lifted.fasta`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		return nil
+	},
+}
+
+var liftoverFastaCmd = &cobra.Command{
+	Use:   "fasta",
+	Short: "Move an alignment onto the new reference",
+	Long: `Move an alignment onto the new reference
+
+The input is an alignment to the old reference. Positions that are deleted in the new reference
+are dropped, and positions that are only in the new reference are filled with N (or - if the
+sequence is - on both sides of them, as at unsequenced ends).
+
+Sequences with too much missing data in the new coordinates can be dropped with --min-completeness
+and --max-n (and written to --rejects).`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = liftover.Fasta(liftoverAlignment, liftoverInfile, liftoverOutfile, liftoverMinCompleteness, liftoverMaxN, liftoverRejects)
+
+		return
+	},
+}
+
+var liftoverBedCmd = &cobra.Command{
+	Use:   "bed",
+	Short: "Move the intervals in a BED file onto the new reference",
+	Long: `Move the intervals in a BED file (e.g. a mask) onto the new reference
+
+Each interval becomes the smallest interval that covers the same bases in the new reference,
+and intervals that are entirely deleted in the new reference are dropped with a warning.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = liftover.Bed(liftoverAlignment, liftoverInfile, liftoverOutfile)
+
+		return
+	},
+}
+
+var liftoverSNPsCmd = &cobra.Command{
+	Use:   "snps",
+	Short: "Move the snps in a gofasta snps file onto the new reference",
+	Long: `Move the snps in a gofasta snps file (the output of gofasta snps) onto the new reference
+
+SNPs at positions that are deleted in the new reference are dropped with a warning, and SNPs to
+the new reference's allele are dropped because they aren't SNPs any more. Sites where the two
+references differ aren't added to queries that matched the old reference, because the snps file
+doesn't say whether they had coverage there: lift the alignment over and call snps again if you
+need those.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = liftover.SNPs(liftoverAlignment, liftoverInfile, liftoverOutfile)
+
+		return
+	},
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/merge"
+)
+
+var mergeInfiles []string
+var mergeOutfile string
+var mergeDuplicates string
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	mergeCmd.Flags().StringSliceVarP(&mergeInfiles, "infile", "i", nil, "Alignments to merge, in fasta format (comma-separated, or repeated)")
+	mergeCmd.Flags().StringVarP(&mergeOutfile, "outfile", "o", "stdout", "Where to write the merged alignment")
+	mergeCmd.Flags().StringVarP(&mergeDuplicates, "duplicates", "", "first", "Which sequence to keep for a sample that is in the alignments more than once with different sequences: first, fewest-n or error")
+
+	inputFlags(mergeCmd.Flags(), "infile")
+	checkedInputs(mergeCmd.Flags(), alignmentFormat, "infile")
+	outputFlags(mergeCmd.Flags(), "outfile")
+
+	mergeCmd.Flags().SortFlags = false
+}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge",
+	Short: "Merge alignments that are in the same coordinate system into one",
+	Long: `Merge alignments that are in the same coordinate system into one
+
+Example usage:
+	gofasta merge -i batch1.fasta,batch2.fasta,batch3.fasta -o alignment.fasta
+	gofasta merge -i old.fasta -i rerun.fasta --duplicates fewest-n -o alignment.fasta
+
+The alignments have to be the same width, e.g. because they were all made by gofasta sam toMultiAlign from
+batches of sequences that were mapped to the same reference. The sequences are written in the order
+that they are read, and each sample (sequence name) is only written once. If a sample is in the alignments
+more than once with the same sequence, the copies are dropped. If the sequences are different, --duplicates
+says which one is kept, with a warning: the first one that is read (first), the one with the fewest Ns
+(fewest-n, e.g. to prefer a rerun that got better coverage, or the first if it has no fewer), or none,
+which is an error instead (error).
+
+The numbers of duplicate samples are written to stderr.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = merge.Merge(mergeInfiles, mergeOutfile, mergeDuplicates)
+
+		return
+	},
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/sam"
+)
+
+var minorVariantsOutfile string
+var minorVariantsFormat string
+var minorVariantsSample string
+var minorVariantsMinFreq float64
+var minorVariantsMinDepth int
+
+func init() {
+	samCmd.AddCommand(minorVariantsCmd)
+
+	minorVariantsCmd.Flags().StringVarP(&minorVariantsOutfile, "outfile", "o", "stdout", "Where to write the variants")
+	minorVariantsCmd.Flags().StringVarP(&minorVariantsFormat, "format", "", "tsv", "Format of the output (choose one of: tsv, vcf)")
+	minorVariantsCmd.Flags().StringVarP(&minorVariantsSample, "sample", "", "", "Sample name for reads without a read group (default: the name of the SAM file, without its extension)")
+	minorVariantsCmd.Flags().Float64VarP(&minorVariantsMinFreq, "min-freq", "", 0.02, "Minimum frequency (0-1) of a non-reference base for it to be reported")
+	minorVariantsCmd.Flags().IntVarP(&minorVariantsMinDepth, "min-depth", "", 10, "Minimum number of reads covering a site for its variants to be reported")
+
+	outputFlags(minorVariantsCmd.Flags(), "outfile")
+
+	minorVariantsCmd.Flags().SortFlags = false
+}
+
+var minorVariantsCmd = &cobra.Command{
+	Use:     "minorVariants",
+	Aliases: []string{"minorvariants", "ihv"},
+	Short:   "Estimate within-host variant frequencies from the reads in a SAM file",
+	Long: `Estimate within-host variant frequencies from the reads in a SAM file
+
+When a SAM file has many reads from each sample (e.g. reads aligned to the reference, rather than
+consensus sequences), the bases at each site of the reference are counted across all of a sample's
+reads, on each strand. Every non-reference base with a frequency of at least --min-freq, at a site
+covered by at least --min-depth reads, is reported. Only A, C, G and T are counted, so the depth
+doesn't include deletions, Ns or bases masked by --min-qual.
+
+Reads are assigned to samples using the SM of their read group (the RG tag, and the @RG lines in the
+header). Reads without a read group are from --sample, which by default is the name of the SAM file.
+
+The default output is a tab-separated file with one line per sample per variant, and the columns:
+	sample pos ref alt depth ref_count alt_count alt_freq alt_fwd alt_rev
+With --format vcf, a VCF with one line per site and non-reference base is written instead, with each
+sample's depth (DP), reference and alternative counts (AD), frequency (AF) and strand counts (SB).
+
+Example usage:
+	gofasta sam minorVariants -s reads.sam -r reference.fasta --min-freq 0.05 -o variants.tsv
+	gofasta sam ihv -s reads.sam -r reference.fasta --format vcf -o variants.vcf`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = sam.MinorVariants(samFile, reference, minorVariantsOutfile, minorVariantsFormat, minorVariantsSample, minorVariantsMinFreq, minorVariantsMinDepth,
+			samMinQual, samSkipCorrupt, samMissingSeq, numThreads())
+
+		return
+	},
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/distance"
+)
+
+var mosaicParents string
+var mosaicQuery string
+var mosaicOutfile string
+var mosaicWindow int
+var mosaicStep int
+var mosaicMinWindows int
+
+func init() {
+	rootCmd.AddCommand(mosaicCmd)
+
+	mosaicCmd.Flags().StringVarP(&mosaicParents, "parents", "p", "", "Alignment of the candidate parents, in fasta format")
+	mosaicCmd.Flags().StringVarP(&mosaicQuery, "query", "q", "stdin", "Alignment of the queries to paint, in fasta format")
+	mosaicCmd.Flags().StringVarP(&mosaicOutfile, "outfile", "o", "stdout", "Where to write the mosaics")
+	mosaicCmd.Flags().IntVarP(&mosaicWindow, "window", "", 1000, "Size of each window, in alignment columns")
+	mosaicCmd.Flags().IntVarP(&mosaicStep, "step", "", 250, "Number of columns between the starts of consecutive windows")
+	mosaicCmd.Flags().IntVarP(&mosaicMinWindows, "min-windows", "", 2, "Ignore runs of fewer than this many windows that are closest to the same parent")
+	addMaskFlag(mosaicCmd.Flags())
+
+	inputFlags(mosaicCmd.Flags(), "parents", "query")
+	checkedInputs(mosaicCmd.Flags(), alignmentFormat, "parents", "query")
+	outputFlags(mosaicCmd.Flags(), "outfile")
+
+	mosaicCmd.Flags().SortFlags = false
+}
+
+var mosaicCmd = &cobra.Command{
+	Use:   "mosaic",
+	Short: "Paint aligned sequences by their nearest candidate parent, to screen for recombinants",
+	Long: `Paint aligned sequences by their nearest candidate parent, to screen for recombinants
+
+Each query is split into windows of --window columns, starting every --step columns (as in gofasta scan),
+and each window is painted with the parent that has the fewest SNPs from the query in it, counted as by
+gofasta distance. Windows where two or more parents are equally close aren't painted. Runs of fewer than
+--min-windows windows with the same parent are ignored, so that a few scattered SNPs aren't called as
+recombination. Where the nearest parent changes, the breakpoint is placed between the informative sites
+(where the query has a nucleotide that only one of the two parents could have) that best support each
+side. A parent with the same name as a query isn't used for it.
+
+Example usage:
+	gofasta mosaic -p parents.fasta -q alignment.fasta -o mosaics.csv
+	gofasta mosaic -p parents.fasta -q alignment.fasta --window 500 --step 100 --mask homoplasies.bed
+
+The output is a csv-format file, after a ## line recording the version of gofasta and the command line,
+with one line per query and the columns:
+	query,segments,breakpoints,mosaic
+where segments is the number of segments, breakpoints is a "|"-separated list of the last site that
+supports the parent on the left and the first site that supports the parent on the right (e.g. 21500-21621),
+and mosaic is a "|"-separated list of parent:start-end segments. Positions are 1-based and inclusive.
+Queries with more than one segment are putative recombinants, and are counted in the --json-summary.
+This is a first-pass screen: the breakpoints are only as good as the candidate parents.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = distance.Mosaic(mosaicParents, mosaicQuery, mosaicOutfile, mosaicWindow, mosaicStep, mosaicMinWindows, maskFile, numThreads())
+
+		return
+	},
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/names"
+)
+
+var namesInfile string
+var namesOutfile string
+var namesFormat string
+var namesRename string
+
+func init() {
+	rootCmd.AddCommand(namesCmd)
+
+	namesCmd.Flags().StringVarP(&namesInfile, "infile", "i", "stdin", "Sequences whose names to parse, in fasta format")
+	namesCmd.Flags().StringVarP(&namesOutfile, "outfile", "o", "stdout", "Where to write the table of fields (or the renamed sequences, with --rename)")
+	namesCmd.Flags().StringVarP(&namesFormat, "name-format", "", "gisaid", "The fields of the names, separated by / or | (gisaid is short for virus/country/id/year|epi|date)")
+	namesCmd.Flags().StringVarP(&namesRename, "rename", "", "", "Write the sequences renamed according to this template of {fields}, e.g. {country}/{id}/{year}, instead of the table")
+
+	inputFlags(namesCmd.Flags(), "infile")
+	checkedInputs(namesCmd.Flags(), fastaFormat, "infile")
+	outputFlags(namesCmd.Flags(), "outfile")
+
+	namesCmd.Flags().SortFlags = false
+}
+
+var namesCmd = &cobra.Command{
+	Use:   "names",
+	Short: "Parse sequence names (e.g. GISAID-style headers) into fields, or rename sequences from them",
+	Long: `Parse sequence names (e.g. GISAID-style headers) into fields, or rename sequences from them
+
+--name-format lists the fields of a name, separated by the same / and | characters as the names. The
+default, gisaid, is virus/country/id/year|epi|date, which splits
+	hCoV-19/England/MILK-9E05B3/2020|EPI_ISL_601443|2020-09-20
+into virus=hCoV-19, country=England, id=MILK-9E05B3, year=2020, epi=EPI_ISL_601443 and date=2020-09-20.
+
+Example usage:
+	gofasta names -i sequences.fasta -o names.csv
+	gofasta names -i sequences.fasta --name-format "country/id/year" -o names.csv
+
+The whole header line is parsed, so a field can have spaces in it (e.g. Northern Ireland). The output is
+a csv-format file with the name (the first word of the header, which is how the other commands name
+sequences, so the table can be used as their --metadata), then one column per field. Headers that don't
+have the same delimiters as the format have empty fields, and are counted on stderr.
+
+With --rename, the sequences are written in fasta format instead, with names made by filling in a template
+of fields, each with any whitespace replaced by _ (names that don't match the format are kept as they are):
+	gofasta names -i sequences.fasta --rename "{country}/{id}/{year}" -o renamed.fasta
+
+snps, closest and sam toMultiAlign take the same --name-format, so the fields can be used with --where
+and --annotate as if they were --metadata columns, without writing the table first:
+	gofasta snps -r reference.fasta -q alignment.fasta --name-format gisaid --where country==England --annotate epi`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = names.Names(namesInfile, namesOutfile, namesFormat, namesRename)
+
+		return
+	},
+}
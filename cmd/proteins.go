@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/proteins"
+)
+
+var proteinsQuery string
+var proteinsSam string
+var proteinsGenbank string
+var proteinsGenes []string
+var proteinsOutdir string
+
+func init() {
+	rootCmd.AddCommand(proteinsCmd)
+
+	proteinsCmd.Flags().StringVarP(&proteinsQuery, "query", "q", "stdin", "Alignment of sequences to translate, in fasta format, aligned to the reference in --genbank")
+	proteinsCmd.Flags().StringVarP(&proteinsSam, "samfile", "s", "", "(Optional) read the queries from this SAM file, mapped to the reference in --genbank, instead of --query")
+	proteinsCmd.Flags().StringVarP(&proteinsGenbank, "genbank", "g", "", "Genbank annotation of the reference (a file, or an accession to fetch from NCBI)")
+	proteinsCmd.Flags().StringSliceVarP(&proteinsGenes, "genes", "", nil, "(Optional) only translate these genes (comma-separated), instead of every CDS")
+	proteinsCmd.Flags().StringVarP(&proteinsOutdir, "outdir", "", "", "The directory to write an amino acid alignment for each CDS to")
+
+	addCodonsFlag(proteinsCmd.Flags())
+	addGeneAliasesFlag(proteinsCmd.Flags())
+
+	inputFlags(proteinsCmd.Flags(), "query", "samfile", "genbank")
+	checkedInputs(proteinsCmd.Flags(), samFormat, "samfile")
+	checkedInputs(proteinsCmd.Flags(), alignmentFormat, "query")
+	checkedInputs(proteinsCmd.Flags(), genbankFormat, "genbank")
+	outputFlags(proteinsCmd.Flags(), "outdir")
+
+	proteinsCmd.Flags().SortFlags = false
+}
+
+var proteinsCmd = &cobra.Command{
+	Use:   "proteins",
+	Short: "Translate aligned sequences into an amino acid alignment for each CDS",
+	Long: `Translate aligned sequences into an amino acid alignment for each CDS
+
+Each query, read from an alignment to the reference (--query) or from a SAM file (--samfile), in which case
+insertions relative to the reference are ignored, is translated into every CDS in --genbank (or only the ones
+in --genes, which can be given by any of their names, see gofasta aatype --help), and the translations are
+written to one fasta file per CDS in --outdir, named after its /gene (e.g. S.fasta), in the order of the input.
+
+Example usage:
+	gofasta proteins -q alignment.fasta -g MN908947.gb --outdir proteins/
+	gofasta proteins -s aligned.sam -g MN908947.gb --genes S,N --outdir proteins/
+
+Every query's translation of a CDS has one amino acid per codon of the reference's, so the files are
+alignments: a codon that is entirely deleted is -, one with Ns or other ambiguity codes is X unless every
+codon it could be is the same amino acid, and one that is partly deleted is translated according to --codons
+(see gofasta aatype --help). CDSs are translated from their /codon_start, with their /transl_table and
+/transl_except.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		policy, err := codons()
+		if err != nil {
+			return err
+		}
+
+		err = loadGeneAliases()
+		if err != nil {
+			return err
+		}
+
+		err = proteins.Proteins(proteinsQuery, proteinsSam, proteinsGenbank, proteinsGenes, policy, proteinsOutdir, numThreads())
+
+		return
+	},
+}
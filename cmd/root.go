@@ -3,23 +3,324 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"runtime"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/cov-ert/gofasta/internal/memlimit"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
 )
 
+// flags that are shared by all (--threads, --max-mem, --quiet, --json-summary, --profile, --trace, --validate-only) or several
+// (--reference, --mask, --metadata, --name-format, --where, --min-date, --max-date, --date-column, --annotate,
+// --header-template, --codons, --alphabet, --gene-aliases) subcommands
+var threads int
+var maxMem string
+var quiet bool
+var jsonSummary string
+var cpuProfile string
+var traceFile string
+var reference string
+var maskFile string
+var metadataFile string
+var nameFormat string
+var metadataWhere []string
+var metadataDateColumn string
+var metadataMinDate string
+var metadataMaxDate string
+var metadataAnnotate []string
+var headerTemplate string
+var codonPolicy string
+var alphabetName string
+var geneAliases string
+
+// exit codes, so that workflow managers can tell bad input data from a bad command line
+const (
+	exitDataError  = 1
+	exitUsageError = 2
+)
+
+// started is set once the command line has been parsed, and the command is about to run
+var started bool
+
+// stopProfiling finishes writing --profile and --trace, once the command has run
+var stopProfiling = func() error { return nil }
+
 var (
 	rootCmd = &cobra.Command{
-		Use:     "gofasta",
-		Short:   "some functions for working with alignments",
-		Long:    `some functions for working with alignments`,
-		Version: "0.0.5",
+		Use:   "gofasta",
+		Short: "some functions for working with alignments",
+		Long: `some functions for working with alignments
+
+Each subcommand has its own help, e.g.:
+	gofasta sam toMultiAlign --help
+
+--threads, --max-mem, --quiet, --json-summary, --profile, --trace, --validate-only and --config can be used
+with any subcommand, and subcommands that need a reference sequence all take it with -r/--reference.
+
+--validate-only checks a subcommand's inputs instead of running it, as a cheap pre-flight check for a big
+batch job: that the SAM header, reference, alignments, Genbank annotation, mask and metadata can all be read,
+and that they fit together (the reference is the one that the SAM file was aligned against, the alignments are
+as wide as the reference and each other, the Genbank record is as long as the reference, the mask is inside the
+alignment, and how many sequences aren't in the metadata). Every problem is written to stderr, and the exit
+code is 1 if there are any. No outputs are written. Only the SAM header (and first record) is read, not the
+whole file.
+
+--max-mem (e.g. 2G or 512M) is a memory budget for the run, so that the same command can be run on a small
+cloud instance or a big HPC node. It is given to the Go runtime as a soft limit, and the pipelines shorten
+their channel buffers and in-memory batches to fit in it. Inputs that are too big for it are left on disk
+instead of being read onto the heap: closest maps its targets into memory (as with --mmap), compare reads
+each alignment's sequences one at a time using a fasta index, and sam indels spills the indels it has
+found to temporary files more often.
+
+--json-summary writes the command, version, input and output files, counts (records read, queries processed,
+skipped and filtered) and timing of a run to a json file.
+
+--profile writes a CPU profile of the run (for go tool pprof), and --trace writes an execution trace (for go
+tool trace, in which each pipeline is marked as a region), so that a performance problem with a
+big dataset can be reported with something that shows where the time went.
+
+--config reads flag values from a file, so that standard analysis settings can be version controlled. It has
+key = value lines, in a subset of TOML, where the key is the long name of a flag. Settings at the top apply
+to every subcommand that has that flag, and settings in a section, e.g. [closest] or [sam.toMultiAlign],
+apply to that subcommand (and its subcommands) and override the ones at the top. Flags that are given on the
+command line override the config file:
+
+	reference = "MN908947.fasta"
+	mask = "problematic_sites.bed"
+
+	[sam.toMultiAlign]
+	trim = true
+	trimstart = 265
+	trimend = 29674
+
+	[closest]
+	annotate = ["lineage", "date"]
+
+The exit code is 1 if the input data couldn't be processed, and 2 if the command line was wrong (an unknown
+flag or subcommand, or a flag value that isn't allowed).`,
+		Version: version.Version,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			started = true
+			err := applyConfig(cmd)
+			if err != nil {
+				return err
+			}
+			if quiet {
+				devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+				if err != nil {
+					return err
+				}
+				os.Stderr = devnull
+			}
+			// cap the number of OS threads running Go code at once, as well as the
+			// number of workers in each pipeline, e.g. on shared HPC nodes
+			if threads > 0 && threads < runtime.NumCPU() {
+				runtime.GOMAXPROCS(threads)
+			}
+			if len(maxMem) > 0 {
+				n, err := memlimit.Parse(maxMem)
+				if err != nil {
+					return usage.Errorf("--max-mem: %v", err)
+				}
+				memlimit.Set(n)
+			}
+			if validateOnly {
+				cmd.Run = nil
+				cmd.RunE = validateInputs
+			}
+			if len(cpuProfile) > 0 || len(traceFile) > 0 {
+				stop, err := profiling.Start(cpuProfile, traceFile)
+				if err != nil {
+					return err
+				}
+				stopProfiling = stop
+			}
+			return nil
+		},
 	}
 )
 
+func init() {
+	rootCmd.PersistentFlags().IntVarP(&threads, "threads", "", 0, threadsUsage)
+	rootCmd.PersistentFlags().StringVarP(&maxMem, "max-mem", "", "", "Memory budget for the run, e.g. 2G or 512M (default: no limit)")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "", false, "Don't write warnings to stderr")
+	rootCmd.PersistentFlags().StringVarP(&jsonSummary, "json-summary", "", "", "Write a summary of the run to this json file")
+	rootCmd.PersistentFlags().StringVarP(&cpuProfile, "profile", "", "", "Write a CPU profile of the run to this file")
+	rootCmd.PersistentFlags().StringVarP(&traceFile, "trace", "", "", "Write an execution trace of the run to this file")
+	rootCmd.PersistentFlags().BoolVarP(&validateOnly, "validate-only", "", false, "Check the inputs, and how they fit together, and report any problems, instead of running the command")
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "", "", "Read the values of any flags that aren't given on the command line from this file")
+
+	inputFlags(rootCmd.PersistentFlags(), "config")
+}
+
+// threadsUsage is --threads' help. cobra lists the global flag in a command's help even if the
+// command has its own (see addThreadsShorthand), so it says where -t works
+const threadsUsage = "Number of threads to use (default: all available CPUs; -t for short in closest, sam and align)"
+
+// addThreadsShorthand gives a command's --threads the -t shorthand that closest, sam and align
+// had before --threads was a global flag. It can't be global, because -t is updown topranking's
+// --target
+func addThreadsShorthand(flags *pflag.FlagSet) {
+	flags.IntVarP(&threads, "threads", "t", 0, threadsUsage)
+}
+
+// numThreads is --threads, or the number of CPUs if it wasn't set
+func numThreads() int {
+	if threads <= 0 {
+		return runtime.NumCPU()
+	}
+	return threads
+}
+
+// addReferenceFlag adds the shared -r/--reference flag to a command's flags
+func addReferenceFlag(flags *pflag.FlagSet, help string) {
+	flags.StringVarP(&reference, "reference", "r", "", help)
+	inputFlags(flags, "reference")
+	checkedInputs(flags, referenceFormat, "reference")
+}
+
+// addMaskFlag adds the shared --mask flag, for a file of alignment columns to ignore (see
+// the mask package), to a command's flags
+func addMaskFlag(flags *pflag.FlagSet) {
+	flags.StringVarP(&maskFile, "mask", "", "", "BED file, or list of 1-based positions and ranges (e.g. 265-300), of alignment columns to ignore")
+	inputFlags(flags, "mask")
+	checkedInputs(flags, maskFormat, "mask")
+}
+
+// addMetadataFlags adds the shared --metadata, --name-format, --where, date range and --annotate flags
+// (see the metadata package) to a command's flags. Commands without an output table don't get --annotate
+func addMetadataFlags(flags *pflag.FlagSet, annotate bool) {
+	flags.StringVarP(&metadataFile, "metadata", "", "", "Metadata, in csv format with a header, whose first column is the sequence name")
+	flags.StringVarP(&nameFormat, "name-format", "", "", "Parse the sequence names into fields that can be used like metadata columns (e.g. virus/country/id/year|epi|date, or gisaid for that)")
+	flags.StringArrayVarP(&metadataWhere, "where", "", nil, "Only use sequences whose metadata matches column==value or column!=value (can be repeated; all must match)")
+	flags.StringVarP(&metadataMinDate, "min-date", "", "", "Only use sequences dated on or after this date (yyyy-mm-dd, or an incomplete date like yyyy-mm)")
+	flags.StringVarP(&metadataMaxDate, "max-date", "", "", "Only use sequences dated on or before this date (yyyy-mm-dd, or an incomplete date like yyyy-mm)")
+	flags.StringVarP(&metadataDateColumn, "date-column", "", "date", "The column of --metadata (or field of --name-format) that --min-date and --max-date apply to")
+	if annotate {
+		flags.StringSliceVarP(&metadataAnnotate, "annotate", "", nil, "Add these columns of --metadata (comma-separated) to the output")
+	}
+	inputFlags(flags, "metadata")
+}
+
+// addHeaderTemplateFlag adds the shared --header-template flag, for naming the sequences of an output
+// alignment from their metadata, to the flags of a command that has the metadata flags
+func addHeaderTemplateFlag(flags *pflag.FlagSet) {
+	flags.StringVarP(&headerTemplate, "header-template", "", "", "Name the output sequences by filling in this template of {name} and --metadata (or --name-format) {columns}, e.g. {name}|{date}|{lineage}")
+}
+
+// loadMetadata loads the metadata given by the shared flags
+func loadMetadata() (*metadata.Metadata, error) {
+	var p *metadata.NameParser
+	if len(nameFormat) > 0 {
+		var err error
+		p, err = metadata.NewNameParser(nameFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+	md, err := metadata.Load(metadataFile, p, metadataWhere, metadataDateColumn, metadataMinDate, metadataMaxDate, metadataAnnotate)
+	if err != nil {
+		return nil, err
+	}
+	if len(headerTemplate) > 0 {
+		err = md.SetHeaderTemplate(headerTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return md, nil
+}
+
+// addCodonsFlag adds the shared --codons flag, for how to translate partly deleted codons (see
+// alphabet.CodonPolicy), to a command's flags
+func addCodonsFlag(flags *pflag.FlagSet) {
+	flags.StringVarP(&codonPolicy, "codons", "", "X", "How to translate codons that are partly deleted: X, del, or frame (join up the nucleotides either side of an in-frame deletion)")
+}
+
+// codons is the policy given by --codons
+func codons() (alphabet.CodonPolicy, error) {
+	return alphabet.ParseCodonPolicy(codonPolicy)
+}
+
+// addGeneAliasesFlag adds the shared --gene-aliases flag, for a file of the names that genes go by
+// (see genbank.LoadAliases), to a command's flags
+func addGeneAliasesFlag(flags *pflag.FlagSet) {
+	flags.StringVarP(&geneAliases, "gene-aliases", "", "", "File of other names for genes (one gene per line: its canonical name, then its aliases, comma-separated), as well as the built-in SARS-CoV-2 ones")
+	inputFlags(flags, "gene-aliases")
+}
+
+// loadGeneAliases adds the gene names in --gene-aliases, if it was given, to the built-in ones
+func loadGeneAliases() error {
+	if len(geneAliases) == 0 {
+		return nil
+	}
+	return genbank.LoadAliases(geneAliases)
+}
+
+// addAlphabetFlag adds the shared --alphabet flag, for whether sequences are nucleotides or amino
+// acids (see alphabet.Alphabets), to a command's flags
+func addAlphabetFlag(flags *pflag.FlagSet) {
+	flags.StringVarP(&alphabetName, "alphabet", "", "nucleotide", "The IUPAC codes that the sequences are made of: nucleotide or protein")
+}
+
+// sequenceAlphabet is the alphabet given by --alphabet
+func sequenceAlphabet() (*alphabet.Alphabet, error) {
+	return alphabet.ParseAlphabet(alphabetName)
+}
+
+// exitCode is the exit code for the error that a command returned. Anything that
+// goes wrong before the command runs (e.g. parsing the flags) is a usage error
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case !started || usage.Is(err):
+		return exitUsageError
+	default:
+		return exitDataError
+	}
+}
+
 // Execute executes the root command.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	start := time.Now()
+
+	version.SetCommand(os.Args)
+
+	cmd, err := rootCmd.ExecuteC()
+	code := exitCode(err)
+
+	perr := stopProfiling()
+	if perr != nil {
+		fmt.Println(perr)
+		if code == 0 {
+			code = exitDataError
+		}
+	}
+
+	if len(jsonSummary) > 0 {
+		serr := writeSummary(cmd, start, code, err)
+		if serr != nil {
+			fmt.Println(serr)
+			if code == 0 {
+				code = exitDataError
+			}
+		}
+	}
+
+	if err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+	}
+
+	if code != 0 {
+		os.Exit(code)
 	}
 }
@@ -4,23 +4,57 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var samThreads int
 var samFile string
-var samReference string
+var samMinQual int
+var samSkipCorrupt bool
+var samMissingSeq string
 
 func init() {
 	rootCmd.AddCommand(samCmd)
 
-	samCmd.PersistentFlags().IntVarP(&samThreads, "threads", "t", 1, "Number of threads to use")
+	addThreadsShorthand(samCmd.PersistentFlags())
 	samCmd.PersistentFlags().StringVarP(&samFile, "samfile", "s", "", "samfile to read. If none is specified, will read from stdin")
-	samCmd.PersistentFlags().StringVarP(&samReference, "reference", "r", "", "Reference fasta file used to generate the sam file")
+	addReferenceFlag(samCmd.PersistentFlags(), "Reference fasta file used to generate the sam file (or @SQ, for the one that the SAM header's UR or M5 tag points to)")
+	samCmd.PersistentFlags().BoolVarP(&samSkipCorrupt, "skip-corrupt", "", false, "Skip malformed SAM records (with a warning) instead of stopping")
+	samCmd.PersistentFlags().StringVarP(&samMissingSeq, "missing-seq", "", "skip", "What to do with mapped records that have no SEQ (choose one of: skip, mask)")
+	samCmd.PersistentFlags().IntVarP(&samMinQual, "min-qual", "", 0, "Mask bases with a Phred quality (from the QUAL field) below this with N (or, with toMultiAlign --soft-mask, lower case) when building aligned sequences (default: no masking)")
+
+	// an empty --samfile means stdin
+	samCmd.PersistentFlags().SetAnnotation("samfile", fileAnnotation, []string{"input", "stdin"})
+	checkedInputs(samCmd.PersistentFlags(), samFormat, "samfile")
 }
 
 var samCmd = &cobra.Command{
 	Use:   "sam",
 	Short: "Do things with sam files",
-	Long:  `Do things with sam files`,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Do things with sam files
+
+The sam file is read from stdin unless you give one with -s/--samfile, so you can pipe an aligner's
+output straight in, e.g.:
+	minimap2 -a -x asm5 reference.fasta unaligned.fasta | gofasta sam toMultiAlign > aligned.fasta
+
+Malformed SAM records (e.g. a CIGAR that doesn't match SEQ, or runs past the end of the reference)
+stop the command with an error that says which record and CIGAR operation is wrong. With --skip-corrupt,
+they are skipped with a warning instead, and counted as skipped in the --json-summary.
+
+Aligners can write records without a stored sequence (SEQ is '*'), e.g. for secondary alignments. By
+default these are skipped with a warning. With --missing-seq mask, they are kept, with an N for each query
+base that the CIGAR consumes (soft clips included, hard clips not), so they still cover the span they map to.
+
+The --reference is checked against the @SQ line in the SAM header: its name, its length and (if the @SQ
+line has an M5 tag) its MD5 checksum. With -r @SQ, the reference is the one the header points to, instead
+of a separate file: the file or URL in the UR tag, or if there isn't one, the sequence with the M5 checksum,
+which is downloaded from ENA's CRAM reference registry (as samtools does) and cached, in $GOFASTA_CACHE_DIR
+if it is set:
+	gofasta sam variants -s aligned.sam -r @SQ -g MN908947.3 -o variants.csv
+
+If the SAM header has no @SQ line, toMultiAlign takes the reference's length from the --reference, or
+if there isn't one, from the records: the reference is taken to end where the furthest of them does (with
+a warning), which means reading a --samfile twice, so the --reference is needed for records from stdin.
+Records that reach past the end of the --reference are cut short, with a warning.
+
+toMultiAlign and toPairAlign can also be called toma and topa.`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 
 		return nil
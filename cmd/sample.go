@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/sample"
+)
+
+var sampleInfile string
+var sampleOutfile string
+var sampleN int
+var sampleBy []string
+var sampleSeed int64
+
+func init() {
+	rootCmd.AddCommand(sampleCmd)
+
+	sampleCmd.Flags().StringVarP(&sampleInfile, "infile", "i", "stdin", "Alignment to sample from, in fasta format")
+	sampleCmd.Flags().StringVarP(&sampleOutfile, "outfile", "o", "stdout", "Where to write the sample, in fasta format")
+	sampleCmd.Flags().IntVarP(&sampleN, "number", "n", 0, "How many sequences to sample (from each group, with --by)")
+	sampleCmd.Flags().StringSliceVarP(&sampleBy, "by", "", nil, "Sample from each group of sequences with the same values of these metadata columns (comma-separated; a date column can be binned with column:week or column:month)")
+	sampleCmd.Flags().Int64VarP(&sampleSeed, "seed", "", -1, "Seed for the random sample, so that it can be reproduced (by default, one is picked and written to stderr)")
+	addMetadataFlags(sampleCmd.Flags(), false)
+	addHeaderTemplateFlag(sampleCmd.Flags())
+
+	inputFlags(sampleCmd.Flags(), "infile")
+	checkedInputs(sampleCmd.Flags(), fastaFormat, "infile")
+	outputFlags(sampleCmd.Flags(), "outfile")
+
+	sampleCmd.Flags().SortFlags = false
+}
+
+var sampleCmd = &cobra.Command{
+	Use:   "sample",
+	Short: "Randomly subsample an alignment, optionally stratified by metadata",
+	Long: `Randomly subsample an alignment, optionally stratified by metadata
+
+Example usage:
+	gofasta sample -i alignment.fasta -n 1000 --seed 42 -o sample.fasta
+
+With --by, at most --number sequences are sampled from each group of sequences that share the same values of
+some columns of --metadata (or fields of --name-format; see gofasta names --help). A date column can be binned
+into ISO weeks (2020-W09) or months (2020-03) with column:week or column:month, so that e.g. this samples at
+most 10 sequences per country per week:
+	gofasta sample -i alignment.fasta --metadata metadata.csv --by country,date:week -n 10 -o sample.fasta
+
+Sequences that aren't in the metadata, or have incomplete dates, are sampled as a group of their own. --where,
+--min-date and --max-date restrict which sequences are sampled from.
+
+The alignment is read once, and each group is sampled with a reservoir as it is read, so only the sample is held
+in memory. The sample is written in the same order as the input. The same --seed (and input) gives the same sample.
+
+With --header-template, the sampled sequences are named by filling in a template of {name} (the sequence's name)
+and columns of --metadata or fields of --name-format, e.g. "{name}|{date}|{lineage}", so that they are labelled the
+way tree software expects. Sequences that aren't in the metadata keep their names.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		md, err := loadMetadata()
+		if err != nil {
+			return
+		}
+
+		err = sample.Sample(sampleInfile, sampleOutfile, sampleN, sampleBy, sampleSeed, md)
+
+		return
+	},
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/sam"
+)
+
+var samSNPsOutfile string
+var samSNPsFromCigar bool
+
+func init() {
+	samCmd.AddCommand(samSNPsCmd)
+
+	samSNPsCmd.Flags().StringVarP(&samSNPsOutfile, "outfile", "o", "stdout", "Where to write the snps")
+	samSNPsCmd.Flags().BoolVarP(&samSNPsFromCigar, "from-cigar", "", false, "Call SNPs from the X (mismatch) operations in the CIGAR, e.g. from minimap2 --eqx (needs the --reference)")
+
+	outputFlags(samSNPsCmd.Flags(), "outfile")
+
+	samSNPsCmd.Flags().SortFlags = false
+}
+
+var samSNPsCmd = &cobra.Command{
+	Use:   "snps",
+	Short: "Find snps relative to a reference from a SAM file",
+	Long: `Find snps relative to a reference from a SAM file
+
+SNPs are called for each read directly from its MD tag and CIGAR, without building the
+aligned sequence, so this is much faster and uses much less memory than converting the SAM
+file to an alignment and running gofasta snps. If a read has no MD tag, its SNPs are called
+by comparing it to the --reference instead (minimap2 writes MD tags if you use its --MD flag,
+or you can add them using samtools calmd).
+
+With --from-cigar, the SNPs are the bases under X (mismatch) operations in each CIGAR instead,
+as written by e.g. minimap2 --eqx, and the --reference is only used to look up the reference
+alleles. CIGARs with M operations, which don't say which bases are mismatches, are an error.
+
+Example usage:
+	gofasta sam snps -s aligned.sam -o snps.csv
+
+The output is a csv-format file with one line per query sequence, and two columns:
+'query' and 'SNPs', the second of which is a "|"-delimited list of snps in that query.
+SNPs between ambiguous nucleotides are not reported.
+
+If input sam and output csv files are not specified, the behaviour is to read the sam from stdin and write
+the snps to stdout, e.g.:
+	minimap2 -a --MD -x asm5 reference.fasta unaligned.fasta | gofasta sam snps > snps.csv`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = sam.SNPs(samFile, reference, samSNPsOutfile, samMinQual, samSkipCorrupt, samMissingSeq, samSNPsFromCigar, numThreads())
+
+		return
+	},
+}
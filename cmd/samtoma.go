@@ -4,6 +4,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/cov-ert/gofasta/pkg/sam"
+	"github.com/cov-ert/gofasta/pkg/usage"
 )
 
 var toMultiAlignOutfile string
@@ -11,6 +12,27 @@ var toMultiAlignTrim bool
 var toMultiAlignPad bool
 var toMultiAlignTrimStart int
 var toMultiAlignTrimEnd int
+var toMultiAlignOutFormat string
+var toMultiAlignGenbankFile string
+var toMultiAlignRNA bool
+var toMultiAlignFlatten string
+var toMultiAlignQualMargin int
+var toMultiAlignTrimReadEnds int
+var toMultiAlignTrimReadQual int
+var toMultiAlignSoftMask bool
+var toMultiAlignPaired bool
+var toMultiAlignDiscordant string
+var toMultiAlignPerRead bool
+var toMultiAlignByReadGroup bool
+var toMultiAlignMinDepth int
+var toMultiAlignMinCompleteness float64
+var toMultiAlignMaxN int
+var toMultiAlignRejects string
+var toMultiAlignShardSize int
+var toMultiAlignShardBy string
+var toMultiAlignCheckpoint string
+var toMultiAlignCheckpointEvery int
+var toMultiAlignResume bool
 
 func init() {
 	samCmd.AddCommand(toMultiAlignCmd)
@@ -20,13 +42,42 @@ func init() {
 	toMultiAlignCmd.Flags().BoolVarP(&toMultiAlignPad, "pad", "", false, "If trim, replace the trimmed regions with Ns")
 	toMultiAlignCmd.Flags().IntVarP(&toMultiAlignTrimStart, "trimstart", "", -1, "Start coordinate for trimming")
 	toMultiAlignCmd.Flags().IntVarP(&toMultiAlignTrimEnd, "trimend", "", -1, "End coordinate for trimming")
+	toMultiAlignCmd.Flags().StringVarP(&toMultiAlignOutFormat, "out-format", "", "fasta", "Format of the output alignment (choose one of: fasta, phylip, phylip-interleaved, nexus, diff)")
+	toMultiAlignCmd.Flags().BoolVarP(&toMultiAlignRNA, "rna", "", false, "Write the alignment as RNA (U instead of T). Input sequences with U are always read as T")
+	toMultiAlignCmd.Flags().StringVarP(&toMultiAlignGenbankFile, "genbank", "g", "", "(Optional) Genbank annotation of the reference, used to write a charset for each CDS if --out-format nexus (a file, or an accession to fetch from NCBI)")
+	toMultiAlignCmd.Flags().StringVarP(&toMultiAlignFlatten, "flatten-strategy", "", "letters", "How to resolve sites where a query's primary and supplementary alignments disagree (choose one of: letters, quality; --paired always uses quality)")
+	toMultiAlignCmd.Flags().IntVarP(&toMultiAlignQualMargin, "qual-margin", "", 10, "With --flatten-strategy quality, the minimum difference in base quality needed to pick one base over another")
+	toMultiAlignCmd.Flags().IntVarP(&toMultiAlignTrimReadEnds, "trim-read-ends", "", 0, "Soft-clip this many aligned bases from each end of every read before flattening")
+	toMultiAlignCmd.Flags().IntVarP(&toMultiAlignTrimReadQual, "trim-read-qual", "", 0, "Then keep soft-clipping the bases at each end of every read until one has at least this quality")
+	addMaskFlag(toMultiAlignCmd.Flags())
+	toMultiAlignCmd.Flags().BoolVarP(&toMultiAlignSoftMask, "soft-mask", "", false, "Write the bases masked by --mask, --min-qual, conflicting alignments or --pad in lower case, instead of as Ns")
+	toMultiAlignCmd.Flags().BoolVarP(&toMultiAlignPaired, "paired", "", false, "Merge the mates of each paired-end fragment into one sequence, resolving overlaps by base quality (this implies --flatten-strategy quality)")
+	toMultiAlignCmd.Flags().StringVarP(&toMultiAlignDiscordant, "discordant", "", "", "With --paired, write the fragments whose mates don't map as a proper pair to this tab-separated file, with the reason")
+	toMultiAlignCmd.Flags().BoolVarP(&toMultiAlignPerRead, "per-read", "", false, "Write each SAM record as its own sequence, instead of flattening the records for each query")
+	toMultiAlignCmd.Flags().BoolVarP(&toMultiAlignByReadGroup, "by-read-group", "", false, "Group the reads by read group (RG tag) instead of by query, and write one consensus sequence per sample")
+	toMultiAlignCmd.Flags().IntVarP(&toMultiAlignMinDepth, "min-depth", "", 1, "Write sites covered by fewer than this many of a query's records as Ns (with --by-read-group, as missing)")
+
+	toMultiAlignCmd.Flags().Float64VarP(&toMultiAlignMinCompleteness, "min-completeness", "", 0, "Drop sequences with less than this proportion (0-1) of sites that aren't missing data (N, or unsequenced ends)")
+	toMultiAlignCmd.Flags().IntVarP(&toMultiAlignMaxN, "max-n", "", -1, "Drop sequences with more than this many Ns (-1 for no limit)")
+	toMultiAlignCmd.Flags().StringVarP(&toMultiAlignRejects, "rejects", "", "", "Write the sequences dropped by --min-completeness or --max-n to this fasta file, with the reason in the header")
+	toMultiAlignCmd.Flags().IntVarP(&toMultiAlignShardSize, "shard-size", "", 0, "Split the alignment into files of at most this many sequences, named after --fasta-out, with a manifest")
+	addMetadataFlags(toMultiAlignCmd.Flags(), false)
+	addHeaderTemplateFlag(toMultiAlignCmd.Flags())
+	toMultiAlignCmd.Flags().StringVarP(&toMultiAlignShardBy, "shard-by", "", "", "Split the alignment into one file per value of this column of --metadata (e.g. week or lab)")
+	toMultiAlignCmd.Flags().StringVarP(&toMultiAlignCheckpoint, "checkpoint", "", "", "Periodically record how far through the SAM file the run has got in this file, so that it can be resumed")
+	toMultiAlignCmd.Flags().IntVarP(&toMultiAlignCheckpointEvery, "checkpoint-every", "", 10000, "With --checkpoint, write a checkpoint every this many queries")
+	toMultiAlignCmd.Flags().BoolVarP(&toMultiAlignResume, "resume", "", false, "With --checkpoint, carry on from the last checkpoint of an interrupted run (or start from the beginning if there isn't one)")
+
+	inputFlags(toMultiAlignCmd.Flags(), "genbank")
+	checkedInputs(toMultiAlignCmd.Flags(), genbankFormat, "genbank")
+	outputFlags(toMultiAlignCmd.Flags(), "fasta-out", "discordant", "rejects", "checkpoint")
 
 	toMultiAlignCmd.Flags().SortFlags = false
 }
 
 var toMultiAlignCmd = &cobra.Command{
 	Use:   "toMultiAlign",
-	Aliases: []string{"tomultialign"},
+	Aliases: []string{"tomultialign", "toma"},
 	Short: "Convert a SAM file to a multiple alignment in fasta format",
 	Long:  `Convert a SAM file to a multiple alignment in fasta format
 
@@ -41,11 +92,140 @@ If you want, you can trim (and optionally pad) the output alignment to coordinat
 
 If input and output files are not specified, the behaviour is to read the sam file from stdin and write
 the fasta file to stdout, e.g.:
-	minimap2 -a -x asm5 reference.fasta unaligned.fasta | gofasta sam toMultiAlign > aligned.fasta`,
+	minimap2 -a -x asm5 reference.fasta unaligned.fasta | gofasta sam toMultiAlign > aligned.fasta
+
+You can write the alignment in (relaxed) phylip or nexus format instead of fasta, for direct input into
+RAxML/BEAST/MrBayes etc. If you provide a Genbank file with --out-format nexus, a SETS block with one charset
+per CDS is written after the DATA block:
+	gofasta sam toMultiAlign -s aligned.sam --out-format nexus -g annotation.gb -o aligned.nex
+
+--out-format diff writes each query as its differences from the reference, in the same format as MAPLE
+(runs of Ns and gaps as a start and a length, and other differences one per line), which is much smaller than
+the alignment for closely related sequences. It needs the --reference, which is written first:
+	gofasta sam toMultiAlign -s aligned.sam -r reference.fasta --out-format diff -o aligned.diff
+
+RNA references (e.g. RefSeq RNA records, with U instead of T) are read as DNA, so that a U is never a SNP.
+Use --rna to write the alignment back out as RNA.
+
+If a query has more than one (primary + supplementary) alignment and these overlap and disagree, the default
+behaviour is to write an N at that site. With --flatten-strategy quality, the base with the highest quality
+(from the QUAL field) is written instead, as long as it beats the quality of the other bases by at least
+--qual-margin. Otherwise an N is written. However many alignments a query has, the memory used to flatten it
+is proportional to the length of the reference.
+
+The ends of reads are where sequencing errors pile up. As ivar trim and the ARTIC pipeline do, you can trim
+them before the reads are flattened (or, with --by-read-group, counted), so that they don't get into the
+consensus: --trim-read-ends soft-clips that many aligned bases from each end of every read, and --trim-read-qual
+then carries on soft-clipping until it reaches a base with at least that quality. The trimmed sites aren't
+covered by the read at all (rather than being Ns), so another read that covers them is used instead:
+	gofasta sam toMultiAlign -s reads.sam --by-read-group --trim-read-ends 5 --trim-read-qual 20 -o consensus.fasta
+
+With --mask, the masked sites (e.g. homoplasic or primer sites) are written as Ns. With --soft-mask, the sites
+that would be Ns because of --mask, --min-qual, conflicting alignments or --pad are written in lower case
+instead, so that the call is kept for tools that understand soft-masking, but is marked as less certain. At a
+conflict, the first alignment's base is kept (unless only a later one is of good quality), and a base that
+isn't soft-masked beats the same base that is. With --by-read-group, sites where no base has a majority are
+the most common base in lower case:
+	gofasta sam toMultiAlign -s aligned.sam --min-qual 20 --mask primers.bed --soft-mask -o aligned.fasta
+
+For paired-end (e.g. amplicon) data, both mates of a fragment share a QNAME. With --paired, they are merged
+into one sequence per fragment, and where the mates overlap and disagree the base with the higher quality is
+used (as with --flatten-strategy quality, which --paired implies, and is the only --flatten-strategy it can
+be given with). Fragments whose mates are unmapped or missing, on different references or the same strand,
+or not flagged as a proper pair, are still merged, but are counted in the --json-summary and can be listed
+with --discordant. The SAM file should be sorted (or grouped) by QNAME, as it is when it comes straight from
+the aligner:
+	gofasta sam toMultiAlign -s aligned.sam --paired --discordant discordant.tsv -o aligned.fasta
+
+With --per-read, nothing is flattened: each SAM record is written as its own aligned sequence, e.g. for
+looking at intra-host variation read by read. The mates of a pair are named QNAME/1 and QNAME/2, and unpaired
+records QNAME/1, QNAME/2, etc. in the order they appear. A name that would be repeated (e.g. for a mate's
+supplementary alignment) gets a further _2, _3, etc.
+
+With --by-read-group, the reads are grouped by sample instead of by query, so that a multi-sample SAM file
+(e.g. from plate-based sequencing) can be converted without splitting it first. Each read's sample is the SM
+of its read group (the RG tag), or the read group's ID if it doesn't have an SM, and reads without a read group
+are from a sample named after the SAM file. One consensus sequence is written per sample, in alphabetical order:
+at each site, the base (or deletion) that more than half of the reads covering the site have, or an N if none
+of them does. Sites covered by fewer than --min-depth reads are missing data. The SAM file doesn't need to be
+sorted:
+	gofasta sam toMultiAlign -s plate.sam --by-read-group --min-depth 10 -o consensus.fasta
+
+With amplicon data, where many reads (records) make up each query, a site that only one read covers relies
+on that read's base alone. With --min-depth, sites covered by fewer than that many of a query's records (with a
+base or a deletion) are written as Ns (or in lower case, with --soft-mask) instead:
+	gofasta sam toMultiAlign -s amplicons.sam --min-depth 3 -o aligned.fasta
+
+You can drop sequences with too much missing data as the alignment is written, instead of doing a separate
+QC pass. Completeness is measured on the output sequence (so after any trimming and padding):
+	gofasta sam toMultiAlign -s aligned.sam --min-completeness 0.9 --rejects rejects.fasta -o aligned.fasta
+
+For parallel processing downstream, the alignment can be split into several fasta files: into shards of at most
+--shard-size sequences, and/or by a column of a metadata file with --shard-by. The files are named after --fasta-out,
+without its extension, e.g. aligned.0001.fasta, aligned.week_12.fasta or aligned.week_12.0001.fasta. Sequences that
+aren't in the metadata (or have no value in the column) go in the "unassigned" group. A manifest, aligned.manifest.tsv,
+lists each file with its group and number of sequences:
+	gofasta sam toMultiAlign -s aligned.sam --metadata metadata.csv --shard-by week --shard-size 10000 -o aligned.fasta
+
+With --metadata, only the queries whose metadata matches every --where (column==value or column!=value), and
+whose date is from --min-date to --max-date, are written, e.g. --where "lineage==B.1.1.7". The number of queries that aren't in the metadata is reported. With
+--name-format, fields parsed from the query names (see gofasta names --help) can be used in --where and --shard-by
+as well as, or instead of, --metadata. With --header-template, e.g. "{name}|{date}|{lineage}", the queries are
+named by filling in {name} (the query's name) and {columns} of the metadata, in every --out-format and shard.
+
+Converting an enormous SAM file can take a long time. With --checkpoint, the byte offset in the SAM file after
+the last query that has been written, and how long the output (and rejects) files were at that point, are
+recorded every --checkpoint-every queries. If the run is interrupted, run the same command again with --resume:
+the outputs are cut back to the checkpoint, and the SAM file is read from where it says, instead of from the
+start. The SAM file has to be a file (not stdin), and the alignment has to be written to a file in fasta format:
+	gofasta sam toMultiAlign -s huge.sam --checkpoint huge.ckpt -o aligned.fasta
+	gofasta sam toMultiAlign -s huge.sam --checkpoint huge.ckpt --resume -o aligned.fasta`,
 
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		err = sam.ToMultiAlign(samFile, samReference, toMultiAlignOutfile, toMultiAlignTrim, toMultiAlignPad, toMultiAlignTrimStart, toMultiAlignTrimEnd, samThreads)
+		if toMultiAlignPaired && cmd.Flags().Changed("flatten-strategy") && toMultiAlignFlatten != "quality" {
+			return usage.Errorf("--paired resolves overlapping mates by quality, so it can't be used with --flatten-strategy %s", toMultiAlignFlatten)
+		}
+
+		md, err := loadMetadata()
+		if err != nil {
+			return
+		}
+
+		opts := sam.ToMultiAlignOptions{
+			Trim:            toMultiAlignTrim,
+			Pad:             toMultiAlignPad,
+			TrimStart:       toMultiAlignTrimStart,
+			TrimEnd:         toMultiAlignTrimEnd,
+			Format:          toMultiAlignOutFormat,
+			RNA:             toMultiAlignRNA,
+			GenbankFile:     toMultiAlignGenbankFile,
+			MinQual:         samMinQual,
+			SkipCorrupt:     samSkipCorrupt,
+			MissingSeq:      samMissingSeq,
+			MaskFile:        maskFile,
+			SoftMask:        toMultiAlignSoftMask,
+			Flatten:         toMultiAlignFlatten,
+			QualMargin:      toMultiAlignQualMargin,
+			TrimEnds:        toMultiAlignTrimReadEnds,
+			TrimQual:        toMultiAlignTrimReadQual,
+			Paired:          toMultiAlignPaired,
+			DiscordantFile:  toMultiAlignDiscordant,
+			PerRead:         toMultiAlignPerRead,
+			ByReadGroup:     toMultiAlignByReadGroup,
+			MinDepth:        toMultiAlignMinDepth,
+			MinCompleteness: toMultiAlignMinCompleteness,
+			MaxN:            toMultiAlignMaxN,
+			RejectsFile:     toMultiAlignRejects,
+			ShardSize:       toMultiAlignShardSize,
+			Metadata:        md,
+			ShardBy:         toMultiAlignShardBy,
+			CheckpointFile:  toMultiAlignCheckpoint,
+			CheckpointEvery: toMultiAlignCheckpointEvery,
+			Resume:          toMultiAlignResume,
+			Threads:         numThreads(),
+		}
+		err = sam.ToMultiAlign(samFile, reference, toMultiAlignOutfile, nil, opts)
 
 		return
 	},
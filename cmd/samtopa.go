@@ -15,7 +15,7 @@ var toPairAlignSkipInsertions bool
 func init() {
 	samCmd.AddCommand(toPairAlignCmd)
 
-	toPairAlignCmd.Flags().StringVarP(&toPairAlignGenbankFile, "genbank", "g", "", "Genbank format annotation of a sequence in the same coordinates as the alignment")
+	toPairAlignCmd.Flags().StringVarP(&toPairAlignGenbankFile, "genbank", "g", "", "Genbank format annotation of a sequence in the same coordinates as the alignment (or its accession, e.g. NC_045512.2, to fetch it from NCBI)")
 	toPairAlignCmd.Flags().StringVarP(&toPairAlignGenbankFeature, "feature", "", "", "Feature to output (choose one of: gene, CDS). If none is specified, will output the entire alignment")
 	toPairAlignCmd.Flags().StringVarP(&toPairAlignOutpath, "outpath", "o", "", "Output path where fasta files will be written")
 	toPairAlignCmd.Flags().BoolVarP(&toPairAlignOmitReference, "omit-reference", "", false, "Omit the reference sequences from the output alignments")
@@ -24,18 +24,22 @@ func init() {
 	toPairAlignCmd.Flags().Lookup("omit-reference").NoOptDefVal = "true"
 	toPairAlignCmd.Flags().Lookup("skip-insertions").NoOptDefVal = "true"
 
+	inputFlags(toPairAlignCmd.Flags(), "genbank")
+	checkedInputs(toPairAlignCmd.Flags(), genbankFormat, "genbank")
+	outputFlags(toPairAlignCmd.Flags(), "outpath")
+
 	toPairAlignCmd.Flags().SortFlags = false
 }
 
 var toPairAlignCmd = &cobra.Command{
 	Use:   "toPairAlign",
-	Aliases: []string{"topairalign"},
+	Aliases: []string{"topairalign", "topa"},
 	Short: "convert a SAM file to pairwise alignments in fasta format",
 	Long:  `convert a SAM file to pairwise alignments in fasta format`,
 
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		err = sam.ToPairAlign(samFile, samReference, toPairAlignGenbankFile, toPairAlignGenbankFeature, toPairAlignOutpath, toPairAlignOmitReference, toPairAlignSkipInsertions, samThreads)
+		err = sam.ToPairAlign(samFile, reference, toPairAlignGenbankFile, toPairAlignGenbankFeature, toPairAlignOutpath, toPairAlignOmitReference, toPairAlignSkipInsertions, samMinQual, samSkipCorrupt, samMissingSeq, numThreads())
 
 		return err
 	},
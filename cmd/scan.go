@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/scan"
+)
+
+var scanQuery string
+var scanOutfile string
+var scanWindow int
+var scanStep int
+var scanMaxDivergence float64
+var scanMaxN float64
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+
+	addReferenceFlag(scanCmd.Flags(), "Reference sequence, in fasta format")
+	scanCmd.Flags().StringVarP(&scanQuery, "query", "q", "stdin", "Alignment of sequences to scan, in fasta format")
+	scanCmd.Flags().StringVarP(&scanOutfile, "outfile", "o", "stdout", "Where to write the flagged windows")
+	scanCmd.Flags().IntVarP(&scanWindow, "window", "", 200, "Size of each window, in alignment columns")
+	scanCmd.Flags().IntVarP(&scanStep, "step", "", 50, "Number of columns between the starts of consecutive windows")
+	scanCmd.Flags().Float64VarP(&scanMaxDivergence, "max-divergence", "", 0.05, "Flag windows where more than this proportion of the known nucleotides differ from the reference")
+	scanCmd.Flags().Float64VarP(&scanMaxN, "max-n", "", 0.5, "Flag windows where more than this proportion of the sites are N (or ?)")
+
+	inputFlags(scanCmd.Flags(), "query")
+	checkedInputs(scanCmd.Flags(), alignmentFormat, "query")
+	outputFlags(scanCmd.Flags(), "outfile")
+
+	scanCmd.Flags().SortFlags = false
+}
+
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Flag windows of aligned sequences that are divergent from the reference or full of Ns",
+	Long: `Flag windows of aligned sequences that are divergent from the reference or full of Ns
+
+Each query is scanned in windows of --window columns, starting every --step columns (with one more
+window that ends at the end of the alignment, if the steps don't reach it). A window is flagged if the
+proportion of its unambiguous nucleotides that differ from the reference is more than --max-divergence,
+or the proportion of its sites that are N is more than --max-n. Clusters of differences can be
+assembly or alignment artefacts, or a sign of recombination or contamination.
+
+Example usage:
+	gofasta scan -r reference.fasta -q alignment.fasta --window 300 --step 100 -o windows.csv
+
+reference.fasta and alignment.fasta must be the same length.
+
+The output is a csv-format file with one line per flagged window, and the columns:
+	query,start,end,known_sites,differences,divergence,Ns,N_fraction,reason
+where start and end are 1-based and inclusive, and reason is "divergence", "Ns" or "divergence|Ns".
+Ambiguous nucleotides other than N don't count as differences, and gaps don't count towards either measure.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = scan.Scan(reference, scanQuery, scanOutfile, scanWindow, scanStep, scanMaxDivergence, scanMaxN, numThreads())
+
+		return
+	},
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/serve"
+)
+
+var serveAddr string
+var serveMaxRequests int
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVarP(&serveAddr, "listen", "l", "localhost:8080", "Address to listen on")
+	serveCmd.Flags().IntVarP(&serveMaxRequests, "max-requests", "", 1, "Number of requests to run at once (the rest wait their turn), each with --threads threads")
+
+	serveCmd.Flags().SortFlags = false
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run sam toMultiAlign, snps, sam variants and closest as an HTTP API",
+	Long: `Run sam toMultiAlign, snps, sam variants and closest as an HTTP API
+
+This lets web applications and LIMS call them without starting a gofasta process for each request.
+Each is a POST endpoint, whose input files are the parts of a multipart/form-data request body (or the
+whole body, if there is only one), and whose options are query parameters:
+
+	POST /sam/toMultiAlign   sam (and optionally reference); trim, pad, trimstart, trimend, out-format
+	POST /snps               reference and query
+	POST /sam/variants       sam, reference and genbank (or a genbank=<accession> query parameter); codons
+	POST /closest            query and target
+	GET  /version
+
+Example usage:
+	gofasta serve -l 0.0.0.0:8080 --threads 8 --max-requests 2
+	curl --data-binary @aligned.sam 'localhost:8080/sam/toMultiAlign?trim=true&trimstart=265&trimend=29674'
+	curl -F reference=@reference.fasta -F query=@alignment.fasta localhost:8080/snps
+
+Uploads are written to a temporary directory as they arrive, not held in memory, and are deleted once the
+request is done. The alignment from /sam/toMultiAlign is sent as it is made; the other endpoints send their
+output when they finish. A bad request gets a 400 response, and input data that couldn't be processed a 422,
+with the error as the body. If an error happens after the output has started, it is sent in the
+Gofasta-Error trailer instead.
+
+There is no authentication, so don't listen on an address that untrusted clients can reach.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = serve.Serve(serveAddr, serveMaxRequests, numThreads())
+
+		return
+	},
+}
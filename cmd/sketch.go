@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/sketch"
+)
+
+var sketchInfile string
+var sketchOutfile string
+var sketchK int
+var sketchSize int
+var sketchUpdate bool
+
+func init() {
+	rootCmd.AddCommand(sketchCmd)
+
+	sketchCmd.Flags().StringVarP(&sketchInfile, "infile", "i", "stdin", "Alignment to sketch, in fasta format or written by gofasta encode")
+	sketchCmd.Flags().StringVarP(&sketchOutfile, "outfile", "o", "stdout", "Where to write the sketches (it can't be stdout)")
+	sketchCmd.Flags().IntVarP(&sketchK, "kmer", "k", 21, "The length of the k-mers that are hashed (at most 32)")
+	sketchCmd.Flags().IntVarP(&sketchSize, "size", "", 1000, "The number of hashes in each sequence's sketch")
+	sketchCmd.Flags().BoolVarP(&sketchUpdate, "update", "", false, "Only sketch the sequences that have been appended to --infile since --outfile was written, and add them to it")
+
+	inputFlags(sketchCmd.Flags(), "infile")
+	checkedInputs(sketchCmd.Flags(), alignmentFormat, "infile")
+	outputFlags(sketchCmd.Flags(), "outfile")
+
+	sketchCmd.Flags().SortFlags = false
+}
+
+var sketchCmd = &cobra.Command{
+	Use:   "sketch",
+	Short: "Sketch the sequences in an alignment, so that gofasta closest can pick candidates from them quickly",
+	Long: `Sketch the sequences in an alignment, so that gofasta closest can pick candidates from them quickly
+
+Example usage:
+	gofasta encode -i target.fasta -o target.gfe
+	gofasta sketch -i target.gfe -o target.gfs
+	gofasta closest --query query.fasta --target target.gfe --sketch target.gfs -o closest.csv
+
+Each sequence's sketch is the --size smallest hashes of its k-mers of A, C, G and T (hashed together
+with the column that they start at), and two sequences with fewer differences between them share more
+of their sketches. The sketches are written to a binary file, one for each sequence in the same order
+as the alignment, so they have to be made again whenever the alignment changes (except as below).
+
+Larger sketches, and shorter k-mers, tell close sequences apart better, but make the sketch file bigger
+and slower to search. The sketches are made by --threads workers.
+
+If the sequences are encoded (by gofasta encode), and more are added to them with gofasta encode --append,
+--update sketches only the new ones and adds them to the end of the existing --outfile, with its own
+--kmer and --size. Sequences removed with gofasta encode --remove keep their sketches, but gofasta closest
+skips them. After the sequences are encoded again from scratch, they have to be sketched again too:
+
+	gofasta encode --append -i new.fasta -o target.gfe
+	gofasta sketch --update -i target.gfe -o target.gfs`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		if sketchUpdate {
+			err = sketch.Update(sketchInfile, sketchOutfile, threads)
+		} else {
+			err = sketch.Write(sketchInfile, sketchOutfile, sketchK, sketchSize, threads)
+		}
+
+		return
+	},
+}
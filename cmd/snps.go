@@ -3,19 +3,37 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 
+	"github.com/cov-ert/gofasta/pkg/alphabet"
 	"github.com/cov-ert/gofasta/pkg/snps"
+	"github.com/cov-ert/gofasta/pkg/usage"
 )
 
-var snpsReference string
 var snpsQuery string
 var snpsOutfile string
+var snpsGenbank string
+var snpsEffects string
+var snpsAggregate bool
+var snpsFormat string
 
 func init() {
 	rootCmd.AddCommand(snpCmd)
 
-	snpCmd.Flags().StringVarP(&snpsReference, "reference", "r", "", "Reference sequence, in fasta format")
+	addReferenceFlag(snpCmd.Flags(), "Reference sequence, in fasta format")
 	snpCmd.Flags().StringVarP(&snpsQuery, "query", "q", "stdin", "Alignment of sequences to find snps in, in fasta format")
 	snpCmd.Flags().StringVarP(&snpsOutfile, "outfile", "o", "stdout", "Output to write")
+	addMaskFlag(snpCmd.Flags())
+	addMetadataFlags(snpCmd.Flags(), true)
+	addAlphabetFlag(snpCmd.Flags())
+	snpCmd.Flags().StringVarP(&snpsGenbank, "genbank", "g", "", "(Optional) Genbank annotation of the reference, to find the effect of each SNP on its CDSs (a file, or an accession to fetch from NCBI)")
+	snpCmd.Flags().StringVarP(&snpsEffects, "effects", "", "", "With --genbank, write the gene, codon, amino acids and effect of each SNP to this tsv file")
+
+	snpCmd.Flags().BoolVarP(&snpsAggregate, "aggregate", "", false, "Write the number of queries with each base (and gaps and Ns) at every site where any has a SNP, instead of each query's SNPs")
+	snpCmd.Flags().StringVarP(&snpsFormat, "format", "", "tsv", "With --aggregate, the format of the output (choose one of: tsv, vcf)")
+
+	inputFlags(snpCmd.Flags(), "query", "genbank")
+	checkedInputs(snpCmd.Flags(), alignmentFormat, "query")
+	checkedInputs(snpCmd.Flags(), genbankFormat, "genbank")
+	outputFlags(snpCmd.Flags(), "outfile", "effects")
 }
 
 var snpCmd = &cobra.Command{
@@ -28,16 +46,70 @@ Example usage:
 
 reference.fasta and alignment.fasta must be the same length.
 
+With --mask, SNPs at the masked sites (e.g. homoplasic or primer sites) aren't reported.
+
+With --metadata (a csv file whose first column is the sequence name), only the queries that match every
+--where (and are dated from --min-date to --max-date, if given) are reported, and the columns in --annotate are added to the output, e.g.:
+	gofasta snps -r reference.fasta -q alignment.fasta --metadata metadata.csv --where "lineage==B.1.1.7" --annotate date
+The number of queries that aren't in the metadata is reported. With --name-format, fields parsed from the
+query names (see gofasta names --help) can be used in --where and --annotate as well as, or instead of, --metadata.
+
+With --alphabet protein, the reference and the alignment are amino acid sequences, and the differences are
+amino acid substitutions (e.g. N501Y). An ambiguous code isn't a difference from the amino acids it could be
+(B is D or N, Z is E or Q, J is I or L, and X is anything), and nor is a gap.
+
 The output is a csv-format file with one line per query sequence, and two columns:
 'query' and 'SNPs', the second of which is a "|"-delimited list of snps in that query.
 
+With --genbank and --effects, the effect of each SNP on the CDSs it is in is written to a tsv file too, e.g.:
+	gofasta snps -r reference.fasta -q alignment.fasta -g MN908947.gb --effects effects.tsv -o snps.csv
+with one line per query per SNP per CDS, and the columns
+	query,SNP,gene,codon,codon_position,ref_codon,alt_codon,ref_aa,alt_aa,effect
+where codon is 1-based in the CDS (from its /codon_start, translated with its /transl_table and
+/transl_except), codon_position is 1, 2 or 3, and effect is synonymous, nonsynonymous, stop_gained,
+stop_lost, start_lost, unknown (the query's codon has ambiguity codes or gaps in it) or intergenic (for a SNP
+that isn't in a CDS, whose gene and codon columns are empty). The amino acids are those of the query's
+whole codon, so SNPs in the same codon have the same effect.
+
+With --aggregate, the SNPs are summarised across all the queries instead, for tracking the frequency of each
+mutation in a dataset: there is one line per site where any query differs from the reference, with the number
+of queries that have each of A, C, G and T, a gap, and N (or another ambiguity code), e.g.:
+	gofasta snps -r reference.fasta -q alignment.fasta --aggregate -o sites.tsv
+The tsv has the columns pos, ref, A, C, G, T, gap, N, alt_count and alt_freq (the proportion of the queries
+with A, C, G or T at the site that don't have the reference's base). With --format vcf, it is written as a
+sites-only VCF instead, with every alternative base at a site on its line, and their counts (AC) and
+frequencies (AF), the number of queries with a base (AN), with N (NN) and with a gap (GAP) in INFO.
+
 If query and  outfile are not specified, the behaviour is to read the query alignment
 from stdin and write the snps file to stdout, e.g. you could do this:
 	cat alignment.fasta | gofasta snps -r reference.fasta > snps.csv`,
 
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		err = snps.SNPs(snpsReference, snpsQuery, snpsOutfile)
+		md, err := loadMetadata()
+		if err != nil {
+			return
+		}
+
+		a, err := sequenceAlphabet()
+		if err != nil {
+			return
+		}
+
+		if snpsAggregate {
+			if len(snpsEffects) > 0 || len(metadataAnnotate) > 0 {
+				return usage.New("--effects and --annotate are for each query's SNPs, so they can't be used with --aggregate")
+			}
+			if a != alphabet.Nucleotide {
+				return usage.New("--aggregate is only for nucleotide alignments")
+			}
+			return snps.Aggregate(reference, snpsQuery, snpsOutfile, snpsFormat, maskFile, md, numThreads())
+		}
+		if cmd.Flags().Changed("format") {
+			return usage.New("--format is only for --aggregate")
+		}
+
+		err = snps.SNPsWithEffects(reference, snpsQuery, snpsOutfile, maskFile, md, a, snpsGenbank, snpsEffects, numThreads())
 
 		return
 	},
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/strand"
+)
+
+var strandQuery string
+var strandOutfile string
+var strandReport string
+
+func init() {
+	rootCmd.AddCommand(strandCmd)
+
+	addReferenceFlag(strandCmd.Flags(), "Reference sequence, in fasta format")
+	strandCmd.Flags().StringVarP(&strandQuery, "query", "q", "stdin", "Sequences to orient, in fasta format")
+	strandCmd.Flags().StringVarP(&strandOutfile, "outfile", "o", "stdout", "Where to write the oriented sequences, in fasta format")
+	strandCmd.Flags().StringVarP(&strandReport, "report", "", "", "(Optional) Write the strand of each sequence to this tab-separated file")
+
+	inputFlags(strandCmd.Flags(), "query")
+	checkedInputs(strandCmd.Flags(), fastaFormat, "query")
+	outputFlags(strandCmd.Flags(), "outfile", "report")
+
+	strandCmd.Flags().SortFlags = false
+}
+
+var strandCmd = &cobra.Command{
+	Use:   "strand",
+	Short: "Reverse complement the sequences that are from the other strand to the reference",
+	Long: `Reverse complement the sequences that are from the other strand to the reference
+
+Consensus sequences from some pipelines (and some submissions to public databases) are the reverse
+complement of the reference. Anything that compares them to the reference or to each other column by
+column (snps, distance, closest, columnize, etc.) silently gives nonsense for these. strand finds them,
+by comparing the minimizers (a sample of the 15-mers) of each sequence and of its reverse complement to
+the reference's, and flips them, so that every sequence is written on the same strand as the reference:
+	gofasta strand -r reference.fasta -q consensus.fasta -o oriented.fasta --report strands.tsv
+
+Ambiguity codes are reverse complemented to the codes for the complements of their nucleotides. The
+report has the columns:
+	query	strand	forward_hits	reverse_hits
+
+where the strand is + (the same as the reference), - (reverse complemented), or ? if the sequence doesn't
+share any minimizers with the reference on either strand, in which case it is written as it is. The
+numbers of flipped and unknown sequences are recorded in the --json-summary.
+
+gofasta align already aligns each sequence on whichever strand matches the reference better, so this is
+for sequences that are used without being aligned first.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		err = strand.Orient(reference, strandQuery, strandOutfile, strandReport, numThreads())
+
+		return
+	},
+}
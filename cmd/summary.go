@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// fileAnnotation marks the flags that name input or output files, so that they can be
+// listed by --json-summary. Its value is "input" or "output", optionally followed by
+// what an empty value means (e.g. "stdin")
+const fileAnnotation = "gofasta_file"
+
+// inputFlags marks flags as naming input files
+func inputFlags(flags *pflag.FlagSet, names ...string) {
+	for _, name := range names {
+		if err := flags.SetAnnotation(name, fileAnnotation, []string{"input"}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// outputFlags marks flags as naming output files
+func outputFlags(flags *pflag.FlagSet, names ...string) {
+	for _, name := range names {
+		if err := flags.SetAnnotation(name, fileAnnotation, []string{"output"}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// writeSummary writes the --json-summary of a run of cmd
+func writeSummary(cmd *cobra.Command, start time.Time, code int, err error) error {
+
+	s := summary.New(cmd.CommandPath(), version.Version, start)
+
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		kind, ok := f.Annotations[fileAnnotation]
+		if !ok {
+			return
+		}
+		paths := []string{f.Value.String()}
+		// a list of files, e.g. --constellations, is written as [a,b]
+		if t := f.Value.Type(); t == "stringSlice" || t == "stringArray" {
+			paths = strings.Split(strings.Trim(paths[0], "[]"), ",")
+		}
+		for _, path := range paths {
+			if len(path) == 0 {
+				if len(kind) < 2 {
+					continue
+				}
+				path = kind[1]
+			}
+			if kind[0] == "input" {
+				s.Input(path)
+			} else {
+				s.Output(path)
+			}
+		}
+	})
+
+	s.Finish(code, err)
+
+	return s.Write(jsonSummary)
+}
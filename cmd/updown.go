@@ -11,12 +11,20 @@ func init() {
 	rootCmd.AddCommand(updownCmd)
 
 	updownCmd.PersistentFlags().StringVarP(&udReference, "reference", "r", "", "Reference sequence, in fasta format")
+
+	addMaskFlag(updownCmd.PersistentFlags())
+
+	inputFlags(updownCmd.PersistentFlags(), "reference")
+	checkedInputs(updownCmd.PersistentFlags(), referenceFormat, "reference")
 }
 
 var updownCmd = &cobra.Command{
 	Use:   "updown",
 	Short: "get pseudo-tree-aware catchments for query sequences from alignments",
-	Long:  `get pseudo-tree-aware catchments for query sequences from alignments`,
+	Long:  `get pseudo-tree-aware catchments for query sequences from alignments
+
+With --mask, the masked sites (e.g. homoplasic or primer sites) are ignored by both list and topranking:
+they are neither SNPs nor ambiguities, including in csv files written by list without the mask.`,
 	Args:  cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 
@@ -16,6 +16,10 @@ func init() {
 	updownListCmd.Flags().StringVarP(&UDListQuery, "query", "q", "stdin", "Alignment of sequences to parse, in fasta format")
 	updownListCmd.Flags().StringVarP(&UDListOutfile, "outfile", "o", "stdout", "Output to write")
 
+	inputFlags(updownListCmd.Flags(), "query")
+	checkedInputs(updownListCmd.Flags(), alignmentFormat, "query")
+	outputFlags(updownListCmd.Flags(), "outfile")
+
 	updownListCmd.Flags().SortFlags = false
 }
 
@@ -39,7 +43,7 @@ a "|"-delimited list of ranges (1-based, inclusive) of tracts of ambiguities (an
 
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		err = updown.List(udReference, UDListQuery, UDListOutfile)
+		err = updown.List(udReference, UDListQuery, UDListOutfile, maskFile, numThreads())
 
 		return
 	},
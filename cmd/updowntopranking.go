@@ -58,6 +58,9 @@ func init() {
 	toprankingCmd.Flags().Lookup("no-fill").NoOptDefVal = "true"
 	toprankingCmd.Flags().Lookup("dist-push").NoOptDefVal = "true"
 
+	inputFlags(toprankingCmd.Flags(), "query", "target", "reference", "ignore")
+	outputFlags(toprankingCmd.Flags(), "outfile")
+
 	toprankingCmd.Flags().SortFlags = false
 }
 
@@ -93,10 +96,10 @@ You can combine the two types of flag (size and dist), to return only the closes
 `,
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		err = updown.TopRanking(TRquery, TRtarget, TRoutfile, udReference, TRignore,
+		err = updown.TopRanking(TRquery, TRtarget, TRoutfile, udReference, TRignore, maskFile,
 			TRsizetotal, TRsizeup, TRsizedown, TRsizeside, TRsizesame,
 			TRdistall, TRdistup, TRdistdown, TRdistside,
-			TRthresholdpair, TRthresholdtarget, TRnofill, TRdistpush)
+			TRthresholdpair, TRthresholdtarget, TRnofill, TRdistpush, numThreads())
 
 		return
 	},
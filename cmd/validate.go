@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/cov-ert/gofasta/pkg/validate"
+)
+
+// validateOnly is --validate-only, which checks a command's inputs instead of running it
+var validateOnly bool
+
+// formatAnnotation marks the input flags that --validate-only checks, with the kind of file
+// that they name: one of the formats below
+const formatAnnotation = "gofasta_format"
+
+const (
+	samFormat       = "sam"
+	referenceFormat = "reference"
+	alignmentFormat = "alignment"
+	fastaFormat     = "fasta"
+	genbankFormat   = "genbank"
+	maskFormat      = "mask"
+)
+
+// checkedInputs marks input flags as naming files of a format that --validate-only checks
+func checkedInputs(flags *pflag.FlagSet, format string, names ...string) {
+	for _, name := range names {
+		if err := flags.SetAnnotation(name, formatAnnotation, []string{format}); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// piped reports whether something is being piped into stdin, so that an input that defaults to
+// stdin is only checked if it has been given
+func piped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
+// checkedFiles is the files named by a command's flags of one format. Empty values are left out,
+// except for a SAM file, which is then read from stdin, as is an input that is "stdin"; these are
+// only checked if something is being piped in, or the flag was given
+func checkedFiles(cmd *cobra.Command, format string) []string {
+	files := make([]string, 0)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if kind, ok := f.Annotations[formatAnnotation]; !ok || kind[0] != format {
+			return
+		}
+		values := []string{f.Value.String()}
+		// a list of files, e.g. merge's --infile, is [a,b]
+		if t := f.Value.Type(); t == "stringSlice" || t == "stringArray" {
+			values = strings.Split(strings.Trim(values[0], "[]"), ",")
+		}
+		for _, value := range values {
+			stdin := value == "stdin" || (len(value) == 0 && format == samFormat)
+			switch {
+			case stdin && !f.Changed && !piped():
+			case stdin || len(value) > 0:
+				files = append(files, value)
+			}
+		}
+	})
+	return files
+}
+
+// validateInputs is what a command runs instead with --validate-only. It checks each input that
+// the command has been given (see the validate package), reports what it finds in them to stderr,
+// and returns an error if there are any problems, without writing any outputs
+func validateInputs(cmd *cobra.Command, args []string) error {
+
+	r := validate.New(os.Stderr)
+
+	md, err := loadMetadata()
+	if cmd.Flags().Lookup("metadata") != nil {
+		r.Metadata(metadataFile, err)
+	}
+
+	sams := checkedFiles(cmd, samFormat)
+	for _, infile := range sams {
+		r.SAM(infile, reference)
+	}
+	// with a SAM file, the reference is checked against its header
+	if len(sams) == 0 {
+		for _, referenceFile := range checkedFiles(cmd, referenceFormat) {
+			r.Reference(referenceFile)
+		}
+	}
+	for _, infile := range checkedFiles(cmd, alignmentFormat) {
+		r.Fasta(infile, true, md)
+	}
+	for _, infile := range checkedFiles(cmd, fastaFormat) {
+		r.Fasta(infile, false, md)
+	}
+	for _, genbankFile := range checkedFiles(cmd, genbankFormat) {
+		r.Genbank(genbankFile)
+	}
+	for _, maskFile := range checkedFiles(cmd, maskFormat) {
+		r.Mask(maskFile)
+	}
+
+	if err == nil {
+		md.Report()
+	}
+
+	return r.Err()
+}
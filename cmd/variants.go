@@ -4,6 +4,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/cov-ert/gofasta/pkg/sam"
+	"github.com/cov-ert/gofasta/pkg/usage"
 )
 
 var variantGenbankFile string
@@ -12,9 +13,16 @@ var variantOutfile string
 func init() {
 	samCmd.AddCommand(variantCmd)
 
-	variantCmd.Flags().StringVarP(&variantGenbankFile, "genbank", "g", "", "Genbank format annotation of a sequence in the same coordinates as the alignment")
+	variantCmd.Flags().StringVarP(&variantGenbankFile, "genbank", "g", "", "Genbank format annotation of a sequence in the same coordinates as the alignment (or its accession, e.g. NC_045512.2, to fetch it from NCBI)")
 	variantCmd.Flags().StringVarP(&variantOutfile, "outfile", "o", "stdout", "Where to write the variants")
 
+	addCodonsFlag(variantCmd.Flags())
+	addBatchFlags(variantCmd.Flags(), "sam files")
+
+	inputFlags(variantCmd.Flags(), "genbank")
+	checkedInputs(variantCmd.Flags(), genbankFormat, "genbank")
+	outputFlags(variantCmd.Flags(), "outfile")
+
 	variantCmd.Flags().SortFlags = false
 }
 
@@ -28,14 +36,40 @@ Example usage:
 
 The output is a csv-format file with one line per query sequence, and two columns: 'query' and
 'variants', the second of which is a "|"-delimited list of amino acid changes and synonymous SNPs
-in that query relative to the reference sequence specified using --reference/-r.
+in that query relative to the reference sequence specified using --reference/-r. Deleted codons are
+changes to - (e.g. S:H69-), and partly deleted codons are translated according to --codons (see gofasta
+aatype --help). Codons that translate as X (e.g. because of Ns) aren't reported.
 
 If input sam and output csv files are not specified, the behaviour is to read the sam from stdin and write
-the variants to stdout.`,
+the variants to stdout.
+
+To call the variants in many sam files aligned to the same reference (e.g. one per sequencing run), give them
+with --batch (or list them in a file, one per line, with --batch-list) instead of --samfile. The reference and
+the annotation are only read once, and the variants in each are written to --outdir, named after it (e.g.
+run1.sam's to run1.csv):
+
+	gofasta sam variants --batch run1.sam,run2.sam -r reference.fasta -g annotation.gb --outdir variants/`,
 
 	RunE: func(cmd *cobra.Command, args []string) (err error) {
 
-		err = sam.Variants(samFile, samReference, variantGenbankFile, variantOutfile, samThreads)
+		policy, err := codons()
+		if err != nil {
+			return err
+		}
+
+		if !batchMode() {
+			return sam.Variants(samFile, reference, variantGenbankFile, policy, variantOutfile, samMinQual, samSkipCorrupt, samMissingSeq, numThreads())
+		}
+
+		if cmd.Flags().Changed("samfile") || cmd.Flags().Changed("outfile") {
+			return usage.New("--batch and --batch-list are instead of --samfile and --outfile")
+		}
+		samFiles, outFiles, err := batchPaths(".csv")
+		if err != nil {
+			return err
+		}
+
+		err = sam.VariantsBatch(samFiles, reference, variantGenbankFile, policy, outFiles, samMinQual, samSkipCorrupt, samMissingSeq, numThreads())
 
 		return err
 	},
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/watch"
+)
+
+var watchDir string
+var watchOutdir string
+var watchCommand string
+var watchPatterns []string
+var watchInterval time.Duration
+var watchOnce bool
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().StringVarP(&watchDir, "dir", "d", "", "The directory to watch for new input files")
+	watchCmd.Flags().StringVarP(&watchOutdir, "outdir", "", "", "The directory to write the done (and failed) markers to, which {outdir} in --command is replaced by")
+	watchCmd.Flags().StringVarP(&watchCommand, "command", "c", "", "The gofasta command to run on each new file, in which {input}, {name} and {outdir} are replaced by the file's path, its name without its extension, and --outdir")
+	watchCmd.Flags().StringSliceVarP(&watchPatterns, "pattern", "p", []string{"*.sam", "*.fasta", "*.fa", "*.fas"}, "Only process files whose names match these patterns (comma-separated, or repeated)")
+	watchCmd.Flags().DurationVarP(&watchInterval, "interval", "", 10*time.Second, "How often to look for new files")
+	watchCmd.Flags().BoolVarP(&watchOnce, "once", "", false, "Process the files that are in --dir now, and then stop, instead of watching it")
+
+	inputFlags(watchCmd.Flags(), "dir")
+	outputFlags(watchCmd.Flags(), "outdir")
+
+	watchCmd.Flags().SortFlags = false
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Process SAM or fasta files as they arrive in a directory",
+	Long: `Process SAM or fasta files as they arrive in a directory
+
+Example usage:
+	gofasta watch -d incoming/ --outdir aligned/ -c "sam toMultiAlign -s {input} -r reference.fasta -o {outdir}/{name}.fasta"
+
+--dir is looked at every --interval, and each new file in it whose name matches --pattern is processed by
+running --command (any gofasta command, without "gofasta" at the start) with {input} replaced by the file's
+path, {name} by its name without its extension (e.g. run1 for run1.sam) and {outdir} by --outdir. A file is
+only processed once its size and modification time have stopped changing between two looks, so that files
+that are still being copied in aren't read early (hidden files, e.g. rsync's temporary ones, are ignored).
+
+When a file has been processed, name.done (e.g. run1.sam.done) is written to --outdir, or name.failed with
+the error if the command failed. Files with either marker are skipped, so gofasta watch can be stopped and
+started again without processing anything twice, and a failed file is tried again if its marker is deleted.
+It runs until it is interrupted, or, with --once, until every file in --dir has been processed. The files are
+processed one at a time, each with --threads threads.`,
+
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+
+		if len(watchDir) == 0 || len(watchOutdir) == 0 {
+			return usage.New("--dir and --outdir are both needed")
+		}
+
+		template := strings.Fields(watchCommand)
+		if len(template) == 0 {
+			return usage.New("--command is needed")
+		}
+
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		w, err := watch.New(watchDir, watchPatterns, watchOutdir, func(j watch.Job) error {
+			return runWatchCommand(self, template, j)
+		})
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		return w.Watch(ctx, watchInterval, watchOnce)
+	},
+}
+
+// runWatchCommand runs gofasta (self) with the arguments in template, with the placeholders in
+// them filled in for j. The command's output is passed on to stderr, and its last line (where
+// gofasta writes its error) is the error if it fails
+func runWatchCommand(self string, template []string, j watch.Job) error {
+
+	r := strings.NewReplacer("{input}", j.Path, "{name}", j.Name, "{outdir}", watchOutdir)
+
+	args := make([]string, len(template))
+	for i, arg := range template {
+		args[i] = r.Replace(arg)
+	}
+	if threads > 0 && !hasThreadsFlag(args) {
+		args = append(args, fmt.Sprintf("--threads=%d", threads))
+	}
+
+	tail := &tailWriter{}
+
+	c := exec.Command(self, args...)
+	c.Stdout = io.MultiWriter(os.Stderr, tail)
+	c.Stderr = c.Stdout
+
+	err := c.Run()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(tail.b)), "\n")
+		if last := lines[len(lines)-1]; len(last) > 0 {
+			return fmt.Errorf("gofasta %s: %v: %s", strings.Join(args, " "), err, last)
+		}
+		return fmt.Errorf("gofasta %s: %v", strings.Join(args, " "), err)
+	}
+
+	return nil
+}
+
+// tailWriter keeps the last few kilobytes written to it
+type tailWriter struct {
+	b []byte
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.b = append(t.b, p...)
+	if len(t.b) > 4096 {
+		t.b = append(t.b[:0], t.b[len(t.b)-4096:]...)
+	}
+	return len(p), nil
+}
+
+// hasThreadsFlag reports whether a command line already sets --threads
+func hasThreadsFlag(args []string) bool {
+	for _, arg := range args {
+		if arg == "--threads" || strings.HasPrefix(arg, "--threads=") || arg == "-t" || strings.HasPrefix(arg, "-t=") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+//go:build cgo
+// +build cgo
+
+// Command cshared exports gofasta's main conversions as C functions, so that Python
+// (ctypes/cffi), R and anything else that can load a shared library can call them in-process
+// instead of running gofasta for each sample. Build it with:
+//
+//	go build -buildmode=c-shared -o libgofasta.so ./cshared
+//
+// which also writes libgofasta.h. The functions that work on files return 0 if they
+// succeeded, and otherwise the exit code that gofasta would (1 if the input data couldn't be
+// processed, 2 if an argument wasn't allowed), with the error in *err. The functions that work
+// on sequences in memory return the result as a string, or NULL with the error in *err. Strings
+// returned by the library (including errors) must be freed with gofasta_free. Every function
+// has stopped all of its goroutines by the time it returns, even if it failed, so that nothing
+// is left running (or holding files open) in the calling process
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/sam"
+	"github.com/cov-ert/gofasta/pkg/snps"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// exit codes, as for the command line
+const (
+	exitOK         = 0
+	exitDataError  = 1
+	exitUsageError = 2
+)
+
+// fail puts err in *errOut (if errOut isn't NULL) and returns its exit code
+func fail(err error, errOut **C.char) C.int {
+	if errOut != nil {
+		*errOut = C.CString(err.Error())
+	}
+	if usage.Is(err) {
+		return exitUsageError
+	}
+	return exitDataError
+}
+
+// codons parses a codon policy, which is X if it is NULL or empty
+func codons(s *C.char) (alphabet.CodonPolicy, error) {
+	if s == nil || len(C.GoString(s)) == 0 {
+		return alphabet.CodonX, nil
+	}
+	return alphabet.ParseCodonPolicy(C.GoString(s))
+}
+
+// workers is the number of workers to use for threads, which is one per CPU if threads <= 0 (as
+// for gofasta --threads)
+func workers(threads C.int) int {
+	if threads <= 0 {
+		return runtime.NumCPU()
+	}
+	return int(threads)
+}
+
+//export gofasta_version
+func gofasta_version() *C.char {
+	return C.CString(version.Version)
+}
+
+//export gofasta_free
+func gofasta_free(p *C.char) {
+	C.free(unsafe.Pointer(p))
+}
+
+// gofasta_sam_to_multi_align is gofasta sam toMultiAlign -s samFile -r reference -o outfile,
+// with --trim, --pad, --trimstart and --trimend. reference can be empty
+//
+//export gofasta_sam_to_multi_align
+func gofasta_sam_to_multi_align(samFile *C.char, reference *C.char, outfile *C.char, trim C.int, pad C.int, trimstart C.int, trimend C.int, threads C.int, errOut **C.char) C.int {
+	opts := sam.DefaultToMultiAlignOptions()
+	opts.Trim = trim != 0
+	opts.Pad = pad != 0
+	opts.TrimStart = int(trimstart)
+	opts.TrimEnd = int(trimend)
+	opts.Threads = workers(threads)
+	err := sam.ToMultiAlign(C.GoString(samFile), C.GoString(reference), C.GoString(outfile), nil, opts)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	return exitOK
+}
+
+// gofasta_sam_variants is gofasta sam variants -s samFile -r reference -g genbank --codons codons
+// -o outfile
+//
+//export gofasta_sam_variants
+func gofasta_sam_variants(samFile *C.char, reference *C.char, genbankFile *C.char, codonPolicy *C.char, outfile *C.char, threads C.int, errOut **C.char) C.int {
+	policy, err := codons(codonPolicy)
+	if err != nil {
+		return fail(err, errOut)
+	}
+	err = sam.Variants(C.GoString(samFile), C.GoString(reference), C.GoString(genbankFile), policy, C.GoString(outfile), 0, false, "skip", workers(threads))
+	if err != nil {
+		return fail(err, errOut)
+	}
+	return exitOK
+}
+
+// gofasta_snps is gofasta snps -r reference -q query -o outfile
+//
+//export gofasta_snps
+func gofasta_snps(reference *C.char, query *C.char, outfile *C.char, threads C.int, errOut **C.char) C.int {
+	err := snps.SNPs(C.GoString(reference), C.GoString(query), C.GoString(outfile), "", nil, alphabet.Nucleotide, workers(threads))
+	if err != nil {
+		return fail(err, errOut)
+	}
+	return exitOK
+}
+
+// gofasta_snps_of is the SNPs between a reference sequence and a query sequence that is aligned
+// to it, separated by |s (e.g. C241T|C3037T)
+//
+//export gofasta_snps_of
+func gofasta_snps_of(reference *C.char, query *C.char, errOut **C.char) *C.char {
+	found, err := snps.Find(C.GoString(reference), C.GoString(query))
+	if err != nil {
+		fail(err, errOut)
+		return nil
+	}
+	return C.CString(strings.Join(found, "|"))
+}
+
+// gofasta_variants_of is the variants (as written by gofasta sam variants, separated by |s) of a
+// query sequence that is aligned to a reference sequence, in the CDSs of a genbank record
+// (the contents of the file, not its name)
+//
+//export gofasta_variants_of
+func gofasta_variants_of(reference *C.char, query *C.char, genbankRecord *C.char, codonPolicy *C.char, errOut **C.char) *C.char {
+	policy, err := codons(codonPolicy)
+	if err != nil {
+		fail(err, errOut)
+		return nil
+	}
+	gb, err := genbank.Parse(strings.NewReader(C.GoString(genbankRecord)))
+	if err != nil {
+		fail(err, errOut)
+		return nil
+	}
+	variants, err := sam.VariantsOf(C.GoString(reference), C.GoString(query), "query", gb, policy)
+	if err != nil {
+		fail(err, errOut)
+		return nil
+	}
+	return C.CString(strings.Join(variants, "|"))
+}
+
+func main() {}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// The golden tests run the gofasta binary on the small synthetic dataset in testdata (a reference,
+// its Genbank record, a SAM file of queries aligned to it, an alignment of consensus-like
+// sequences and a primer scheme), and compare what each command writes with the outputs in
+// testdata/golden, so that a change to how a command works that changes its output shows up.
+// If a change to the output is intended, rewrite the golden outputs with:
+//	go test -run TestGolden -update
+// and check the diff. The dataset was made with internal/testutil, with a seed of 1. The files
+// that some commands need as well were made from it: alignment.v2.fasta is the alignment with a
+// few changes (a SNP, some Ns and gaps, and a sample dropped and one added), liftover.fasta is
+// the reference aligned to a version of it with a deletion, an insertion and a SNP, unaligned.fasta
+// is the first four sequences of the alignment degapped, snps.csv is gofasta snps' output for the
+// alignment, and constellation.json is a definition that seq0 and seq2 match.
+
+var update = flag.Bool("update", false, "rewrite the golden outputs in testdata/golden")
+
+// binary is the gofasta binary that the golden tests run, which TestMain builds
+var binary string
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	dir, err := ioutil.TempDir("", "gofasta-golden")
+	if err != nil {
+		panic(err)
+	}
+
+	binary = filepath.Join(dir, "gofasta")
+	build := exec.Command("go", "build", "-o", binary, ".")
+	build.Stderr = os.Stderr
+	err = build.Run()
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(err)
+	}
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// provenance matches the version and command line that some outputs start with, which change
+// from one version (and one run) to the next, and samProvenance matches them in a SAM file's @PG
+// line
+var (
+	provenance    = regexp.MustCompile(`gofasta=\S+( command=[^\n]*)?`)
+	samProvenance = regexp.MustCompile(`(?m)^(@PG\tID:gofasta\tPN:gofasta)\tVN:\S+\tCL:.*$`)
+)
+
+// normalize makes an output comparable from one run to the next
+func normalize(b []byte) []byte {
+	b = samProvenance.ReplaceAll(b, []byte("$1\tVN:<version>\tCL:<command line>"))
+	return provenance.ReplaceAll(b, []byte("gofasta=<version> command=<command line>"))
+}
+
+// goldenCase is one run of a command. {out} in args is a directory for its output files, and
+// outputs are the files that it writes there, which are compared with the golden ones as well as
+// what it writes to stdout
+type goldenCase struct {
+	name    string
+	args    []string
+	outputs []string
+}
+
+var goldenCases = []goldenCase{
+	{"sam_toMultiAlign", []string{"sam", "toMultiAlign", "-s", "testdata/aligned.sam", "-r", "testdata/reference.fasta"}, nil},
+	{"sam_toMultiAlign_trim", []string{"sam", "toMultiAlign", "-s", "testdata/aligned.sam", "--trim", "--trimstart", "100", "--trimend", "800", "--pad"}, nil},
+	{"sam_snps", []string{"sam", "snps", "-s", "testdata/aligned.sam", "-r", "testdata/reference.fasta"}, nil},
+	{"sam_variants", []string{"sam", "variants", "-s", "testdata/aligned.sam", "-r", "testdata/reference.fasta", "-g", "testdata/reference.gb"}, nil},
+	{"sam_indels", []string{"sam", "indels", "-s", "testdata/aligned.sam", "-r", "testdata/reference.fasta", "--threshold", "1", "--insertions-out", "{out}/insertions.txt", "--deletions-out", "{out}/deletions.txt"}, []string{"insertions.txt", "deletions.txt"}},
+	{"sam_minorVariants", []string{"sam", "minorVariants", "-s", "testdata/aligned.sam", "-r", "testdata/reference.fasta", "--min-depth", "1"}, nil},
+	{"snps", []string{"snps", "-r", "testdata/reference.fasta", "-q", "testdata/alignment.fasta"}, nil},
+	{"distance", []string{"distance", "-i", "testdata/alignment.fasta"}, nil},
+	{"closest", []string{"closest", "--query", "testdata/alignment.fasta", "--target", "testdata/alignment.fasta", "-n", "2"}, nil},
+	{"columns", []string{"columns", "-i", "testdata/alignment.fasta", "--variable", "--positions-out", "{out}/positions.csv"}, []string{"positions.csv"}},
+	{"merge", []string{"merge", "-i", "testdata/alignment.fasta,testdata/alignment.fasta"}, nil},
+	{"gaps", []string{"gaps", "-i", "testdata/alignment.fasta", "-r", "testdata/reference.fasta", "--min-length", "5", "--primers", "testdata/primers.bed", "--dropout-summary", "{out}/amplicons.tsv"}, []string{"amplicons.tsv"}},
+	{"genbank_toFasta", []string{"genbank", "toFasta", "-g", "testdata/reference.gb"}, nil},
+	{"genbank_toGFF", []string{"genbank", "toGFF", "-g", "testdata/reference.gb"}, nil},
+	{"updown_list", []string{"updown", "list", "-r", "testdata/reference.fasta", "-q", "testdata/alignment.fasta"}, nil},
+	{"updown_topranking", []string{"updown", "topranking", "-r", "testdata/reference.fasta", "-q", "testdata/alignment.fasta", "-t", "testdata/alignment.v2.fasta", "--size-total", "4"}, nil},
+	{"liftover_fasta", []string{"liftover", "fasta", "-a", "testdata/liftover.fasta", "-i", "testdata/alignment.fasta"}, nil},
+	{"liftover_bed", []string{"liftover", "bed", "-a", "testdata/liftover.fasta", "-i", "testdata/primers.bed"}, nil},
+	{"liftover_snps", []string{"liftover", "snps", "-a", "testdata/liftover.fasta", "-i", "testdata/snps.csv"}, nil},
+	{"align", []string{"align", "-r", "testdata/reference.fasta", "-q", "testdata/unaligned.fasta"}, nil},
+	{"aatype", []string{"aatype", "gene1:11", "gene2:73", "gene3:82", "-q", "testdata/alignment.fasta", "-g", "testdata/reference.gb"}, nil},
+	{"aatype_sam", []string{"aatype", "gene1:11", "gene2:73", "gene3:82", "-s", "testdata/aligned.sam", "-g", "testdata/reference.gb"}, nil},
+	{"constellations", []string{"constellations", "-q", "testdata/alignment.fasta", "-c", "testdata/constellation.json", "-g", "testdata/reference.gb", "--evidence", "{out}/evidence.csv"}, []string{"evidence.csv"}},
+	{"proteins", []string{"proteins", "-q", "testdata/alignment.fasta", "-g", "testdata/reference.gb", "--outdir", "{out}"}, []string{"gene1.fasta", "gene2.fasta", "gene3.fasta"}},
+	{"haplotypes", []string{"haplotypes", "-r", "testdata/reference.fasta", "-q", "testdata/alignment.fasta", "-p", "531,845,896"}, nil},
+	{"haplotypes_combination", []string{"haplotypes", "-r", "testdata/reference.fasta", "-q", "testdata/alignment.fasta", "-c", "T845A+A896T"}, nil},
+	{"compare", []string{"compare", "--old", "testdata/alignment.fasta", "--new", "testdata/alignment.v2.fasta", "--all"}, nil},
+}
+
+// checkGolden compares an output with its golden file, or rewrites the golden file with -update.
+// No output should have a NUL in it, which is how the sam package holds unmapped sites until it
+// renders them
+func checkGolden(t *testing.T, name string, file string, got []byte) {
+	path := filepath.Join("testdata", "golden", name, file)
+	got = normalize(got)
+
+	if bytes.IndexByte(got, 0) >= 0 {
+		t.Errorf("problem in TestGolden: %s %s has a NUL in it", name, file)
+	}
+
+	if *update {
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, got, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("problem in TestGolden: %s %s is different from %s (rewrite it with -update if this is intended):\n%s", name, file, path, got)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	for _, c := range goldenCases {
+		t.Run(c.name, func(t *testing.T) {
+			out := t.TempDir()
+
+			args := make([]string, len(c.args))
+			for i, arg := range c.args {
+				args[i] = strings.ReplaceAll(arg, "{out}", out)
+			}
+
+			var stdout, stderr bytes.Buffer
+			cmd := exec.Command(binary, args...)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			err := cmd.Run()
+			if err != nil {
+				t.Fatalf("problem in TestGolden: gofasta %s: %v\n%s", strings.Join(c.args, " "), err, stderr.String())
+			}
+
+			checkGolden(t, c.name, "stdout", stdout.Bytes())
+
+			for _, file := range c.outputs {
+				got, err := ioutil.ReadFile(filepath.Join(out, file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkGolden(t, c.name, file, got)
+			}
+		})
+	}
+}
+
+// updown topranking's -t is --target, not the global --threads
+func TestToprankingTarget(t *testing.T) {
+	out, err := exec.Command(binary, "updown", "topranking", "--help").CombinedOutput()
+	if err != nil {
+		t.Fatalf("problem in TestToprankingTarget: gofasta updown topranking --help: %v\n%s", err, out)
+	}
+	if !regexp.MustCompile(`-t, --target`).Match(out) {
+		t.Errorf("problem in TestToprankingTarget: -t isn't the shorthand for --target:\n%s", out)
+	}
+	if !regexp.MustCompile(`\s{6}--threads`).Match(out) {
+		t.Errorf("problem in TestToprankingTarget: --threads isn't there, or has a shorthand:\n%s", out)
+	}
+}
+
+// closest, sam and align still take -t for --threads, as they did before it was a global flag
+func TestThreadsShorthand(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"closest", "-t", "2", "--query", "testdata/alignment.fasta", "--target", "testdata/alignment.fasta", "-o", filepath.Join(dir, "closest.csv")},
+		{"sam", "toMultiAlign", "-t", "2", "-s", "testdata/aligned.sam", "-o", filepath.Join(dir, "out.fasta")},
+		{"align", "-t", "2", "-r", "testdata/reference.fasta", "-q", "testdata/unaligned.fasta", "-o", filepath.Join(dir, "out.sam")},
+	} {
+		out, err := exec.Command(binary, args...).CombinedOutput()
+		if err != nil {
+			t.Errorf("problem in TestThreadsShorthand: gofasta %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+}
+
+// --paired always flattens by quality, so asking for another --flatten-strategy is a usage error
+// (exit code 2) rather than being silently overridden
+func TestPairedFlattenStrategy(t *testing.T) {
+	out, err := exec.Command(binary, "sam", "toMultiAlign", "-s", "testdata/aligned.sam", "--paired", "--flatten-strategy", "letters", "-o", filepath.Join(t.TempDir(), "out.fasta")).CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 2 {
+		t.Errorf("problem in TestPairedFlattenStrategy: expected a usage error for --paired with --flatten-strategy letters, got %v\n%s", err, out)
+	}
+
+	out, err = exec.Command(binary, "sam", "toMultiAlign", "-s", "testdata/aligned.sam", "--paired", "--flatten-strategy", "quality", "-o", filepath.Join(t.TempDir(), "out.fasta")).CombinedOutput()
+	if err != nil {
+		t.Errorf("problem in TestPairedFlattenStrategy: --paired with --flatten-strategy quality: %v\n%s", err, out)
+	}
+}
+
+// subcommands is the subcommands that gofasta's help for a command lists
+func subcommands(t *testing.T, command []string) [][]string {
+	out, err := exec.Command(binary, append(command, "--help")...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("problem in TestHelp: gofasta %s --help: %v\n%s", strings.Join(command, " "), err, out)
+	}
+
+	var found [][]string
+	listing := false
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case line == "Available Commands:":
+			listing = true
+		case listing && strings.HasPrefix(line, "  "):
+			name := strings.Fields(line)[0]
+			if name != "help" {
+				found = append(found, append(append([]string{}, command...), name))
+			}
+		default:
+			listing = false
+		}
+	}
+
+	return found
+}
+
+// Every command's help can be shown: cobra panics when it first parses a command's flags if one
+// of them has the same shorthand as one that the command inherits, e.g. from a global flag
+func TestHelp(t *testing.T) {
+	commands := subcommands(t, nil)
+	for len(commands) > 0 {
+		command := commands[0]
+		commands = append(commands[1:], subcommands(t, command)...)
+	}
+}
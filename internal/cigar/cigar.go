@@ -1,11 +1,50 @@
-package sam
+/*
+Package cigar turns the operations of a SAM record's CIGAR string into the stretches of an
+aligned sequence that they make. It is internal: it isn't part of gofasta's public API, and
+can change at any time.
+*/
+package cigar
+
+// Op makes the stretch of an aligned sequence for one CIGAR operation of length length, with the
+// query (seq) at query_start and the reference at ref_start. It returns where the query and the
+// reference are after the operation, and the stretch
+type Op func(query_start, ref_start, length int, seq []byte) (int, int, []byte)
+
+// OpWithRef is Op, that also returns the stretch of the reference (refseq) that goes with it
+type OpWithRef func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte)
+
+// Unmapped is what an aligned sequence has at the sites that the query isn't aligned to, e.g. in
+// a region that it skips (N). It isn't printable, so that it can't be mistaken for a base, a gap or
+// SAM's '*', and has to be rendered (e.g. as an N) before the sequence is written out
+const Unmapped byte = 0
+
+// gapRun and skipRun are read-only runs of '-'s and Unmapped sites that CIGAR operations
+// can return slices of, instead of allocating a new run for every deletion/skip
+var gapRun = repeatByte('-', 1024)
+var skipRun = repeatByte(Unmapped, 1024)
+
+func repeatByte(b byte, n int) []byte {
+	s := make([]byte, n)
+	for i := range s {
+		s[i] = b
+	}
+	return s
+}
 
+// getRun returns a slice of length n of run (which must not be modified by the
+// caller), or a newly allocated slice if n is longer than run
+func getRun(run []byte, n int) []byte {
+	if n <= len(run) {
+		return run[:n:n]
+	}
+	return repeatByte(run[0], n)
+}
 
-// getCigarOperationMapNoInsertions is a map of SAM CIGAR operation types to function literals
+// NoInsertions is a map of SAM CIGAR operation types to function literals
 // that are used to build an aligned sequence. This version DISCARDS insertions relative
 // to the reference.
-func getCigarOperationMapNoInsertions() map[string]func(int, int, int, []byte) (int, int, []byte) {
-	cigarOperationMap := map[string]func(int, int, int, []byte) (int, int, []byte){
+func NoInsertions() map[string]Op {
+	cigarOperationMap := map[string]Op{
 		"M": func(query_start, ref_start, length int, seq []byte) (int, int, []byte) {
 			return query_start + length, ref_start + length, seq[query_start : query_start+length]
 		},
@@ -14,19 +53,11 @@ func getCigarOperationMapNoInsertions() map[string]func(int, int, int, []byte) (
 		},
 
 		"D": func(query_start, ref_start, length int, seq []byte) (int, int, []byte) {
-			gaps := make([]byte, length)
-			for i, _ := range gaps {
-				gaps[i] = '-'
-			}
-			return query_start, ref_start + length, gaps
+			return query_start, ref_start + length, getRun(gapRun, length)
 		},
 
 		"N": func(query_start, ref_start, length int, seq []byte) (int, int, []byte) {
-			skip := make([]byte, length)
-			for i, _ := range skip {
-				skip[i] = '*'
-			}
-			return query_start, ref_start + length, skip
+			return query_start, ref_start + length, getRun(skipRun, length)
 		},
 
 		"S": func(query_start, ref_start, length int, seq []byte) (int, int, []byte) {
@@ -47,11 +78,11 @@ func getCigarOperationMapNoInsertions() map[string]func(int, int, int, []byte) (
 	return cigarOperationMap
 }
 
-// getCigarOperationMapWithInsertions is a map of SAM CIGAR operation types to function literals
+// WithInsertions is a map of SAM CIGAR operation types to function literals
 // that are used to build an aligned sequence. This version INCLUDES insertions relative
 // to the reference.
-func getCigarOperationMapWithInsertions() map[string]func(int, int, int, []byte) (int, int, []byte) {
-	cigarOperationMap := map[string]func(int, int, int, []byte) (int, int, []byte){
+func WithInsertions() map[string]Op {
+	cigarOperationMap := map[string]Op{
 		"M": func(query_start, ref_start, length int, seq []byte) (int, int, []byte) {
 			return query_start + length, ref_start + length, seq[query_start : query_start+length]
 		},
@@ -60,19 +91,11 @@ func getCigarOperationMapWithInsertions() map[string]func(int, int, int, []byte)
 		},
 
 		"D": func(query_start, ref_start, length int, seq []byte) (int, int, []byte) {
-			gaps := make([]byte, length)
-			for i, _ := range gaps {
-				gaps[i] = '-'
-			}
-			return query_start, ref_start + length, gaps
+			return query_start, ref_start + length, getRun(gapRun, length)
 		},
 
 		"N": func(query_start, ref_start, length int, seq []byte) (int, int, []byte) {
-			skip := make([]byte, length)
-			for i, _ := range skip {
-				skip[i] = '*'
-			}
-			return query_start, ref_start + length, skip
+			return query_start, ref_start + length, getRun(skipRun, length)
 		},
 
 		"S": func(query_start, ref_start, length int, seq []byte) (int, int, []byte) {
@@ -93,11 +116,11 @@ func getCigarOperationMapWithInsertions() map[string]func(int, int, int, []byte)
 	return cigarOperationMap
 }
 
-// getCigarOperationMapNoInsertionsWithRef is a map of SAM CIGAR operation types to function literals
+// NoInsertionsWithRef is a map of SAM CIGAR operation types to function literals
 // that are used to build an aligned sequence. This version DISCARDS insertions relative
 // to the reference.
-func getCigarOperationMapNoInsertionsWithRef() map[string]func(int, int, int, []byte, []byte) (int, int, []byte, []byte) {
-	cigarOperationMap := map[string]func(int, int, int, []byte, []byte) (int, int, []byte, []byte){
+func NoInsertionsWithRef() map[string]OpWithRef {
+	cigarOperationMap := map[string]OpWithRef{
 		"M": func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte) {
 			return query_start + length, ref_start + length, seq[query_start : query_start+length], refseq[ref_start : ref_start+length]
 		},
@@ -106,19 +129,11 @@ func getCigarOperationMapNoInsertionsWithRef() map[string]func(int, int, int, []
 		},
 
 		"D": func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte) {
-			gaps := make([]byte, length)
-			for i, _ := range gaps {
-				gaps[i] = '-'
-			}
-			return query_start, ref_start + length, gaps, refseq[ref_start : ref_start+length]
+			return query_start, ref_start + length, getRun(gapRun, length), refseq[ref_start : ref_start+length]
 		},
 
 		"N": func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte) {
-			skip := make([]byte, length)
-			for i, _ := range skip {
-				skip[i] = '*'
-			}
-			return query_start, ref_start + length, skip, refseq[ref_start : ref_start+length]
+			return query_start, ref_start + length, getRun(skipRun, length), refseq[ref_start : ref_start+length]
 		},
 
 		"S": func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte) {
@@ -139,36 +154,24 @@ func getCigarOperationMapNoInsertionsWithRef() map[string]func(int, int, int, []
 	return cigarOperationMap
 }
 
-// getCigarOperationMapWithInsertionsWithRef is a map of SAM CIGAR operation types to function literals
+// WithInsertionsWithRef is a map of SAM CIGAR operation types to function literals
 // that are used to build an aligned sequence. This version INCLUDES insertions relative
 // to the reference.
-func getCigarOperationMapWithInsertionsWithRef() map[string]func(int, int, int, []byte, []byte) (int, int, []byte, []byte) {
-	cigarOperationMap := map[string]func(int, int, int, []byte, []byte) (int, int, []byte, []byte){
+func WithInsertionsWithRef() map[string]OpWithRef {
+	cigarOperationMap := map[string]OpWithRef{
 		"M": func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte) {
 			return query_start + length, ref_start + length, seq[query_start : query_start+length], refseq[ref_start : ref_start+length]
 		},
 		"I": func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte) {
-			gaps := make([]byte, length)
-			for i, _ := range gaps {
-				gaps[i] = '-'
-			}
-			return query_start + length, ref_start, seq[query_start : query_start+length], gaps
+			return query_start + length, ref_start, seq[query_start : query_start+length], getRun(gapRun, length)
 		},
 
 		"D": func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte) {
-			gaps := make([]byte, length)
-			for i, _ := range gaps {
-				gaps[i] = '-'
-			}
-			return query_start, ref_start + length, gaps, refseq[ref_start : ref_start+length]
+			return query_start, ref_start + length, getRun(gapRun, length), refseq[ref_start : ref_start+length]
 		},
 
 		"N": func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte) {
-			skip := make([]byte, length)
-			for i, _ := range skip {
-				skip[i] = '*'
-			}
-			return query_start, ref_start + length, skip, refseq[ref_start : ref_start+length]
+			return query_start, ref_start + length, getRun(skipRun, length), refseq[ref_start : ref_start+length]
 		},
 
 		"S": func(query_start, ref_start, length int, seq []byte, refseq []byte) (int, int, []byte, []byte) {
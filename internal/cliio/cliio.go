@@ -0,0 +1,74 @@
+// Package cliio has the small helpers for reading and writing files that the commands share:
+// opening the output (which may be stdout), reading a reference fasta file, and formatting the
+// frequencies in tabular output the same way everywhere
+package cliio
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+)
+
+// OpenOut opens outfile for writing, or returns stdout if outfile == "stdout"
+func OpenOut(outfile string) (*os.File, error) {
+	if outfile == "stdout" {
+		return os.Stdout, nil
+	}
+	return os.Create(outfile)
+}
+
+// ReadRecords reads all the records in a fasta file, for references that are checked for how
+// many records they have
+func ReadRecords(referenceFile string) ([]fastaio.FastaRecord, error) {
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(referenceFile, cFR, cErr, cDone)
+
+	refs := make([]fastaio.FastaRecord, 0)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return nil, err
+		case FR := <-cFR:
+			refs = append(refs, FR)
+		case <-cDone:
+			n--
+		}
+	}
+
+	return refs, nil
+}
+
+// ReadReference reads the (first) sequence in a fasta file, which mustn't be empty
+func ReadReference(referenceFile string) (fastaio.FastaRecord, error) {
+
+	refs, err := ReadRecords(referenceFile)
+	if err != nil {
+		return fastaio.FastaRecord{}, err
+	}
+
+	if len(refs) == 0 || len(refs[0].Seq) == 0 {
+		return fastaio.FastaRecord{}, errors.New("no reference sequence in " + referenceFile)
+	}
+
+	return refs[0], nil
+}
+
+// FormatFreq formats a frequency to 4 decimal places
+func FormatFreq(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
+
+// FormatRatio formats n/d as a frequency, or as undefined if d == 0
+func FormatRatio(n int, d int, undefined string) string {
+	if d == 0 {
+		return undefined
+	}
+	return FormatFreq(float64(n) / float64(d))
+}
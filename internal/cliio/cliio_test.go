@@ -0,0 +1,67 @@
+package cliio
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadReference(t *testing.T) {
+	dir := t.TempDir()
+
+	refFile := filepath.Join(dir, "ref.fasta")
+	err := ioutil.WriteFile(refFile, []byte(">ref1\nACGT\n>ref2\nTTTT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := ReadRecords(refFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].ID != "ref1" || refs[1].ID != "ref2" {
+		t.Errorf("problem in TestReadReference: got %v from ReadRecords", refs)
+	}
+
+	ref, err := ReadReference(refFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.ID != "ref1" || ref.Seq != "ACGT" {
+		t.Errorf("problem in TestReadReference: got %s %s, expected ref1 ACGT", ref.ID, ref.Seq)
+	}
+
+	emptyFile := filepath.Join(dir, "empty.fasta")
+	err = ioutil.WriteFile(emptyFile, []byte(""), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadReference(emptyFile)
+	if err == nil {
+		t.Errorf("problem in TestReadReference: expected an error for an empty reference")
+	}
+
+	_, err = ReadReference(filepath.Join(dir, "missing.fasta"))
+	if err == nil {
+		t.Errorf("problem in TestReadReference: expected an error for a missing reference")
+	}
+}
+
+func TestFormatRatio(t *testing.T) {
+	tests := []struct {
+		n        int
+		d        int
+		expected string
+	}{
+		{1, 4, "0.2500"},
+		{2, 3, "0.6667"},
+		{0, 5, "0.0000"},
+		{0, 0, "NA"},
+	}
+	for _, test := range tests {
+		got := FormatRatio(test.n, test.d, "NA")
+		if got != test.expected {
+			t.Errorf("problem in TestFormatRatio: got %s for %d/%d, expected %s", got, test.n, test.d, test.expected)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package memlimit holds the memory budget of a run (--max-mem). The pipelines use it to size
+// their channel buffers and the batches that they hold in memory, and to decide whether to keep
+// big intermediate data on the heap or on disk, so that the same command can be run on a small
+// cloud instance or a big HPC node. Without a budget, the pipelines use their default sizes
+package memlimit
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// limit is the budget in bytes, or 0 if there isn't one
+var limit int64
+
+// the fractions of the budget that the channel buffers of one pipeline, and one in-memory
+// batch, are allowed to use, and the size of an input above which it is kept on disk
+const (
+	bufferShare = 16
+	batchShare  = 8
+	spillShare  = 4
+)
+
+// Parse parses a size such as 512M, 4G, 1.5GB or 2000000 (bytes). The suffixes (K, M, G and T,
+// optionally followed by B or iB, in either case) are powers of 1024
+func Parse(s string) (int64, error) {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	num = strings.TrimSuffix(strings.TrimSuffix(num, "B"), "I")
+	unit := int64(1)
+	if len(num) > 0 {
+		switch num[len(num)-1] {
+		case 'K':
+			unit = 1 << 10
+		case 'M':
+			unit = 1 << 20
+		case 'G':
+			unit = 1 << 30
+		case 'T':
+			unit = 1 << 40
+		}
+		if unit > 1 {
+			num = num[:len(num)-1]
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f <= 0 {
+		return 0, usage.Errorf("couldn't parse memory size %s (expected e.g. 512M or 4G)", s)
+	}
+	return int64(f * float64(unit)), nil
+}
+
+// Set sets the budget to n bytes (0 for none). The Go runtime is given the same soft limit (when
+// it is built with Go 1.19 or later, which has them), so that it collects garbage more often as
+// the heap gets close to it
+func Set(n int64) {
+	atomic.StoreInt64(&limit, n)
+	setSoftLimit(n)
+}
+
+// Limit is the budget in bytes, or 0 if there isn't one
+func Limit() int64 {
+	return atomic.LoadInt64(&limit)
+}
+
+// share is how many items of size bytes fit in 1/fraction of the budget, between 1 and n
+func share(n int, size int, fraction int64) int {
+	l := Limit()
+	if l == 0 || size <= 0 {
+		return n
+	}
+	fit := l / fraction / int64(size)
+	if fit < 1 {
+		return 1
+	}
+	if fit < int64(n) {
+		return int(fit)
+	}
+	return n
+}
+
+// Buffer is the size for a channel buffer that would be n items long with no budget, given that
+// each item is about size bytes (e.g. the width of an alignment)
+func Buffer(n int, size int) int {
+	return share(n, size, bufferShare)
+}
+
+// Batch is how many items of about size bytes to hold in memory at once, where it would be n with
+// no budget
+func Batch(n int, size int) int {
+	return share(n, size, batchShare)
+}
+
+// Spill reports whether data of size bytes (e.g. a whole alignment) should be kept on disk rather
+// than read onto the heap
+func Spill(size int64) bool {
+	l := Limit()
+	return l > 0 && size > l/spillShare
+}
+
+// SpillFile reports whether a file is big enough that it should be kept on disk rather than read
+// onto the heap (see Spill). Files that can't be stat-ed (e.g. stdin) are read onto the heap
+func SpillFile(name string) bool {
+	if Limit() == 0 || name == "stdin" {
+		return false
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+	return Spill(info.Size())
+}
@@ -0,0 +1,84 @@
+package memlimit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected int64
+	}{
+		{"2000000", 2000000},
+		{"512M", 512 << 20},
+		{"512mb", 512 << 20},
+		{"4G", 4 << 30},
+		{"4GiB", 4 << 30},
+		{"1.5G", 3 << 29},
+		{"64k", 64 << 10},
+		{"1T", 1 << 40},
+	}
+	for _, test := range tests {
+		n, err := Parse(test.s)
+		if err != nil {
+			t.Errorf("problem in TestParse: %s: %v", test.s, err)
+			continue
+		}
+		if n != test.expected {
+			t.Errorf("problem in TestParse: %s: got %d, expected %d", test.s, n, test.expected)
+		}
+	}
+
+	for _, s := range []string{"", "G", "lots", "-1G", "0", "4X"} {
+		_, err := Parse(s)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestParse: expected a usage error for %q, got %v", s, err)
+		}
+	}
+}
+
+func TestSizes(t *testing.T) {
+	defer Set(0)
+
+	Set(0)
+	if Buffer(8, 30000) != 8 || Batch(1<<20, 128) != 1<<20 || Spill(1<<40) {
+		t.Errorf("problem in TestSizes: sizes changed with no budget")
+	}
+
+	// 1/16 of 1M is room for two 30kb records, 1/8 for four
+	Set(1 << 20)
+	if n := Buffer(8, 30000); n != 2 {
+		t.Errorf("problem in TestSizes: Buffer: got %d, expected 2", n)
+	}
+	if n := Buffer(1, 30000); n != 1 {
+		t.Errorf("problem in TestSizes: Buffer: got %d, expected 1", n)
+	}
+	if n := Buffer(8, 1<<20); n != 1 {
+		t.Errorf("problem in TestSizes: Buffer of items bigger than the budget: got %d, expected 1", n)
+	}
+	if n := Batch(8, 30000); n != 4 {
+		t.Errorf("problem in TestSizes: Batch: got %d, expected 4", n)
+	}
+	if Spill(1<<18) || !Spill(1<<18+1) {
+		t.Errorf("problem in TestSizes: Spill should be true for more than a quarter of the budget")
+	}
+
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.fasta")
+	big := filepath.Join(dir, "big.fasta")
+	err := ioutil.WriteFile(small, make([]byte, 1000), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(big, make([]byte, 1<<19), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SpillFile(small) || !SpillFile(big) || SpillFile("stdin") || SpillFile(filepath.Join(dir, "missing.fasta")) {
+		t.Errorf("problem in TestSizes: SpillFile")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build go1.19
+// +build go1.19
+
+package memlimit
+
+import (
+	"math"
+	"runtime/debug"
+)
+
+// setSoftLimit gives the Go runtime a soft memory limit of n bytes, or none if n is 0
+func setSoftLimit(n int64) {
+	if n > 0 {
+		debug.SetMemoryLimit(n)
+	} else {
+		debug.SetMemoryLimit(math.MaxInt64)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !go1.19
+// +build !go1.19
+
+package memlimit
+
+// setSoftLimit does nothing before Go 1.19, which doesn't have soft memory limits: the budget is
+// only used to size the pipelines' buffers and batches
+func setSoftLimit(n int64) {}
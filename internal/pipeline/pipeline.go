@@ -0,0 +1,75 @@
+// Package pipeline keeps track of the goroutines (stages) of a pipeline, so that if it fails
+// part of the way through, every stage can be stopped and waited for, and nothing is left
+// running (or writing to the output, which may be an http.ResponseWriter, or reading a file
+// that is about to be removed) after the pipeline returns. This matters for the programs that
+// run many pipelines in one process, such as gofasta serve and the C library
+package pipeline
+
+import (
+	"sync"
+)
+
+// Pipeline runs the stages of one pipeline. The stages send their errors to Errs, which is
+// always being read, so that a stage never blocks on reporting one, and the first error is
+// the pipeline's. Stop is closed at the first error, so that the stages that can give up early
+// (such as the readers) can do so
+type Pipeline struct {
+	Errs      chan error
+	Stop      chan struct{}
+	collected chan struct{}
+	stages    sync.WaitGroup
+	once      sync.Once
+	err       error
+}
+
+// New starts a pipeline with no stages
+func New() *Pipeline {
+	p := &Pipeline{
+		Errs:      make(chan error),
+		Stop:      make(chan struct{}),
+		collected: make(chan struct{}),
+	}
+	go func() {
+		for err := range p.Errs {
+			p.Fail(err)
+		}
+		close(p.collected)
+	}()
+	return p
+}
+
+// Fail records err, if it is the pipeline's first error, and closes Stop
+func (p *Pipeline) Fail(err error) {
+	p.once.Do(func() {
+		p.err = err
+		close(p.Stop)
+	})
+}
+
+// Failed reports whether the pipeline has failed
+func (p *Pipeline) Failed() bool {
+	select {
+	case <-p.Stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run runs f as a stage of the pipeline
+func (p *Pipeline) Run(f func()) {
+	p.stages.Add(1)
+	go func() {
+		defer p.stages.Done()
+		f()
+	}()
+}
+
+// Wait waits for every stage to finish, and returns the pipeline's first error (if any). The
+// pipeline can't be used after this
+func (p *Pipeline) Wait() error {
+	p.stages.Wait()
+	close(p.Errs)
+	<-p.collected
+	return p.err
+}
@@ -0,0 +1,55 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	p := New()
+	if p.Failed() {
+		t.Errorf("problem in TestPipeline: a new pipeline has failed")
+	}
+
+	first := errors.New("first")
+
+	// a stage that reports an error and carries on, one that gives up when the pipeline fails,
+	// and one that reports an error after that
+	c := make(chan int)
+	p.Run(func() {
+		for i := range c {
+			if i == 2 {
+				p.Errs <- first
+			}
+		}
+	})
+	p.Run(func() {
+		defer close(c)
+		for i := 0; ; i++ {
+			select {
+			case c <- i:
+			case <-p.Stop:
+				return
+			}
+		}
+	})
+	p.Run(func() {
+		<-p.Stop
+		p.Errs <- errors.New("second")
+	})
+
+	err := p.Wait()
+	if err != first {
+		t.Errorf("problem in TestPipeline: got %v, expected the first error", err)
+	}
+	if !p.Failed() {
+		t.Errorf("problem in TestPipeline: the pipeline hasn't failed")
+	}
+
+	p = New()
+	p.Run(func() {})
+	err = p.Wait()
+	if err != nil {
+		t.Errorf("problem in TestPipeline: got %v from a pipeline that didn't fail", err)
+	}
+}
@@ -0,0 +1,160 @@
+// Package summary keeps track of what a run did: which files it read and wrote, how
+// many records it read, and how many queries it processed, skipped or filtered out.
+// The command line writes this out as json with --json-summary
+package summary
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// names of the counts that are shared by several commands
+const (
+	RecordsRead = "records_read"
+	Processed   = "queries_processed"
+	Skipped     = "skipped"
+	Filtered    = "filtered"
+	MaskedSites = "masked_sites"
+)
+
+var (
+	mu      sync.Mutex
+	counts  = make(map[string]int)
+	records = make(map[string]int)
+	seed    *int64
+)
+
+// Add adds n to the named count. It is safe to call from any goroutine
+func Add(name string, n int) {
+	mu.Lock()
+	counts[name] += n
+	mu.Unlock()
+}
+
+// Read records that n records were read from infile
+func Read(infile string, n int) {
+	mu.Lock()
+	counts[RecordsRead] += n
+	records[infile] += n
+	mu.Unlock()
+}
+
+// Forget forgets how many records were read from the files in paths (but not the counts), e.g.
+// because they were a server's temporary uploads, which would otherwise pile up for as long as
+// the server runs
+func Forget(paths ...string) {
+	mu.Lock()
+	for _, path := range paths {
+		delete(records, path)
+	}
+	mu.Unlock()
+}
+
+// Seed records the seed that was used for anything random (or to break ties), so that the
+// run can be reproduced
+func Seed(n int64) {
+	mu.Lock()
+	seed = &n
+	mu.Unlock()
+}
+
+// Reset sets all the counts back to zero, and forgets the seed
+func Reset() {
+	mu.Lock()
+	counts = make(map[string]int)
+	records = make(map[string]int)
+	seed = nil
+	mu.Unlock()
+}
+
+// File is an input or output file. Records is the number of records that were read
+// from an input, if it was read by something that counts them
+type File struct {
+	Path    string `json:"path"`
+	Records *int   `json:"records,omitempty"`
+}
+
+// Summary is the json summary of one run
+type Summary struct {
+	Command  string         `json:"command"`
+	Version  string         `json:"version"`
+	Inputs   []File         `json:"inputs"`
+	Outputs  []File         `json:"outputs"`
+	Counts   map[string]int `json:"counts"`
+	Seed     *int64         `json:"seed,omitempty"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Seconds  float64        `json:"seconds"`
+	ExitCode int            `json:"exit_code"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// New starts the summary of a run of command
+func New(command string, version string, start time.Time) *Summary {
+	return &Summary{Command: command, Version: version, Inputs: make([]File, 0), Outputs: make([]File, 0), Start: start}
+}
+
+// Input adds an input file to the summary
+func (s *Summary) Input(path string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	f := File{Path: path}
+	if n, ok := records[path]; ok {
+		f.Records = &n
+	}
+	s.Inputs = append(s.Inputs, f)
+}
+
+// Output adds an output file to the summary
+func (s *Summary) Output(path string) {
+	s.Outputs = append(s.Outputs, File{Path: path})
+}
+
+// Finish records the end of the run, its exit code and error (if any), and the
+// counts and seed so far
+func (s *Summary) Finish(exitCode int, err error) {
+	s.End = time.Now()
+	s.Seconds = s.End.Sub(s.Start).Seconds()
+	s.ExitCode = exitCode
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	s.Counts = make(map[string]int)
+	for name, n := range counts {
+		s.Counts[name] = n
+	}
+	s.Seed = seed
+}
+
+// Write writes the summary to outfile (which can be "stdout") as json
+func (s *Summary) Write(outfile string) error {
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	var f *os.File
+
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	_, err = f.Write(b)
+
+	return err
+}
@@ -0,0 +1,123 @@
+package summary
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	Reset()
+	defer Reset()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			Add(Processed, 2)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	Read("in.fasta", 5)
+	Read("ref.fasta", 1)
+	Add(Filtered, 1)
+	Seed(42)
+
+	s := New("gofasta test", "0.0.0", time.Now())
+	s.Input("in.fasta")
+	s.Input("other.txt")
+	s.Output("out.fasta")
+	s.Finish(1, errors.New("bad data"))
+
+	outfile := filepath.Join(dir, "summary.json")
+	err = s.Write(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Summary
+	err = json.Unmarshal(b, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Command != "gofasta test" || got.Version != "0.0.0" || got.ExitCode != 1 || got.Error != "bad data" {
+		t.Errorf("problem in TestSummary: got %+v", got)
+	}
+
+	if len(got.Inputs) != 2 || got.Inputs[0].Records == nil || *got.Inputs[0].Records != 5 || got.Inputs[1].Records != nil {
+		t.Errorf("problem in TestSummary: got inputs %+v", got.Inputs)
+	}
+
+	if len(got.Outputs) != 1 || got.Outputs[0].Path != "out.fasta" {
+		t.Errorf("problem in TestSummary: got outputs %+v", got.Outputs)
+	}
+
+	expected := map[string]int{RecordsRead: 6, Processed: 8, Filtered: 1}
+	for name, n := range expected {
+		if got.Counts[name] != n {
+			t.Errorf("problem in TestSummary: got %d %s, expected %d", got.Counts[name], name, n)
+		}
+	}
+	if len(got.Counts) != len(expected) {
+		t.Errorf("problem in TestSummary: got counts %v", got.Counts)
+	}
+
+	if got.Seed == nil || *got.Seed != 42 {
+		t.Errorf("problem in TestSummary: got seed %v, expected 42", got.Seed)
+	}
+
+	// a run that doesn't use a seed doesn't have one in its summary
+	Reset()
+	s = New("gofasta test", "0.0.0", time.Now())
+	s.Finish(0, nil)
+	if s.Seed != nil {
+		t.Errorf("problem in TestSummary: got seed %d after Reset", *s.Seed)
+	}
+
+	if got.End.Before(got.Start) || got.Seconds < 0 {
+		t.Errorf("problem in TestSummary: started at %v, but ended at %v", got.Start, got.End)
+	}
+}
+
+func TestForget(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	Read("upload/sam", 3)
+	Read("other.sam", 1)
+	Forget("upload/sam")
+
+	s := New("gofasta test", "0.0.0", time.Now())
+	s.Input("upload/sam")
+	s.Input("other.sam")
+	s.Finish(0, nil)
+
+	if s.Inputs[0].Records != nil || s.Inputs[1].Records == nil || *s.Inputs[1].Records != 1 {
+		t.Errorf("problem in TestForget: got inputs %+v", s.Inputs)
+	}
+	if s.Counts[RecordsRead] != 4 {
+		t.Errorf("problem in TestForget: got %d %s, expected 4", s.Counts[RecordsRead], RecordsRead)
+	}
+	if len(records) != 1 {
+		t.Errorf("problem in TestForget: %d files are remembered, expected 1", len(records))
+	}
+}
@@ -0,0 +1,300 @@
+/*
+Package testutil makes synthetic data - reference sequences, SAM files, Genbank
+records and alignments - for tests and benchmarks.
+
+Everything is generated from a *rand.Rand so that the same seed always gives
+the same data.
+*/
+package testutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+var nucs = []byte("ACGT")
+
+// RandomSeq returns a random (upper case) nucleotide sequence of length n
+func RandomSeq(r *rand.Rand, n int) []byte {
+	seq := make([]byte, n)
+	for i := range seq {
+		seq[i] = nucs[r.Intn(4)]
+	}
+	return seq
+}
+
+// mutate returns a nucleotide that is different from b
+func mutate(r *rand.Rand, b byte) byte {
+	for {
+		n := nucs[r.Intn(4)]
+		if n != b {
+			return n
+		}
+	}
+}
+
+// Read is one synthetic SAM record
+type Read struct {
+	Name  string
+	Flag  int
+	Pos   int // 0-based
+	Cigar string
+	Seq   []byte
+	Qual  []byte // Phred+33
+	MD    string
+}
+
+// RandomRead makes a read of roughly readLen reference bases that aligns to ref.
+// Its CIGAR is a mix of operations like a real aligner's: soft or hard clips at
+// either end, and matches interrupted by insertions and deletions. Matches carry
+// SNPs at a rate of about 1%, which are recorded in the read's MD tag.
+func RandomRead(r *rand.Rand, name string, ref []byte, readLen int) Read {
+
+	if readLen > len(ref) {
+		readLen = len(ref)
+	}
+
+	rd := Read{Name: name, Pos: r.Intn(len(ref) - readLen + 1)}
+
+	var cigar strings.Builder
+	var md strings.Builder
+
+	seq := make([]byte, 0, readLen+40)
+	matches := 0
+
+	clip := func() {
+		switch r.Intn(5) {
+		case 0:
+			n := 1 + r.Intn(20)
+			cigar.WriteString(strconv.Itoa(n) + "S")
+			seq = append(seq, RandomSeq(r, n)...)
+		case 1:
+			cigar.WriteString(strconv.Itoa(1+r.Intn(20)) + "H")
+		}
+	}
+
+	clip()
+
+	rpos := rd.Pos
+	end := rd.Pos + readLen
+	mlen := 0
+
+	for rpos < end {
+		if r.Intn(100) == 0 {
+			md.WriteString(strconv.Itoa(matches))
+			md.WriteByte(ref[rpos])
+			matches = 0
+			seq = append(seq, mutate(r, ref[rpos]))
+		} else {
+			seq = append(seq, ref[rpos])
+			matches++
+		}
+		mlen++
+		rpos++
+
+		// maybe an indel, as long as there are enough matches either side of it
+		n := 1 + r.Intn(10)
+		if mlen < 10 || rpos+n+10 > end || r.Intn(50) != 0 {
+			continue
+		}
+
+		cigar.WriteString(strconv.Itoa(mlen) + "M")
+		mlen = 0
+
+		if r.Intn(2) == 0 {
+			cigar.WriteString(strconv.Itoa(n) + "I")
+			seq = append(seq, RandomSeq(r, n)...)
+		} else {
+			cigar.WriteString(strconv.Itoa(n) + "D")
+			md.WriteString(strconv.Itoa(matches) + "^")
+			md.Write(ref[rpos : rpos+n])
+			matches = 0
+			rpos += n
+		}
+	}
+
+	cigar.WriteString(strconv.Itoa(mlen) + "M")
+	md.WriteString(strconv.Itoa(matches))
+
+	clip()
+
+	rd.Cigar = cigar.String()
+	rd.Seq = seq
+	rd.MD = md.String()
+
+	rd.Qual = make([]byte, len(seq))
+	for i := range rd.Qual {
+		rd.Qual[i] = byte(33 + 2 + r.Intn(39))
+	}
+
+	return rd
+}
+
+// WriteSam writes a SAM file with n queries aligned to ref, which is called refName
+// in the header. About one query in ten has a supplementary alignment as well as its
+// primary one, as happens when a sequence spans a rearrangement.
+func WriteSam(w io.Writer, r *rand.Rand, refName string, ref []byte, n int, readLen int) error {
+
+	bw := bufio.NewWriter(w)
+
+	_, err := bw.WriteString("@HD\tVN:1.6\tSO:unsorted\n@SQ\tSN:" + refName + "\tLN:" + strconv.Itoa(len(ref)) + "\n")
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		name := "query" + strconv.Itoa(i)
+
+		reads := []Read{RandomRead(r, name, ref, readLen)}
+		if r.Intn(10) == 0 {
+			// the supplementary alignment is somewhere else on the reference
+			// (reads span exactly their readLen reference bases)
+			for tries := 0; tries < 10; tries++ {
+				supp := RandomRead(r, name, ref, readLen/2)
+				if supp.Pos+readLen/2 <= reads[0].Pos || supp.Pos >= reads[0].Pos+readLen {
+					supp.Flag = 2048
+					reads = append(reads, supp)
+					break
+				}
+			}
+		}
+
+		for _, rd := range reads {
+			_, err = fmt.Fprintf(bw, "%s\t%d\t%s\t%d\t60\t%s\t*\t0\t0\t%s\t%s\tMD:Z:%s\n",
+				rd.Name, rd.Flag, refName, rd.Pos+1, rd.Cigar, rd.Seq, rd.Qual, rd.MD)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Sequence is one named sequence, e.g. for writing to a fasta file
+type Sequence struct {
+	ID  string
+	Seq []byte
+}
+
+// WriteFasta writes sequences in fasta format, unwrapped
+func WriteFasta(w io.Writer, seqs []Sequence) error {
+
+	bw := bufio.NewWriter(w)
+
+	for _, s := range seqs {
+		_, err := bw.WriteString(">" + s.ID + "\n" + string(s.Seq) + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// RandomAlignment returns n sequences that are aligned to ref, like consensus
+// genomes: each has SNPs at a rate of about snpRate, the odd run of Ns (missing
+// data), and some of them have unsequenced ('-') ends
+func RandomAlignment(r *rand.Rand, ref []byte, n int, snpRate float64) []Sequence {
+
+	seqs := make([]Sequence, n)
+
+	for i := range seqs {
+		seq := make([]byte, len(ref))
+		copy(seq, ref)
+
+		for j := range seq {
+			if r.Float64() < snpRate {
+				seq[j] = mutate(r, seq[j])
+			}
+		}
+
+		for k := r.Intn(3); k > 0; k-- {
+			start := r.Intn(len(seq))
+			end := start + 1 + r.Intn(200)
+			for j := start; j < len(seq) && j < end; j++ {
+				seq[j] = 'N'
+			}
+		}
+
+		if r.Intn(2) == 0 {
+			left := r.Intn(50)
+			right := len(seq) - r.Intn(50)
+			for j := range seq {
+				if j < left || j >= right {
+					seq[j] = '-'
+				}
+			}
+		}
+
+		seqs[i] = Sequence{ID: "seq" + strconv.Itoa(i), Seq: seq}
+	}
+
+	return seqs
+}
+
+// WriteGenbank writes a Genbank record for ref, which is split into nGenes
+// consecutive genes, each with one CDS that starts with ATG and ends with a
+// stop codon (ref is edited to make this so)
+func WriteGenbank(w io.Writer, name string, ref []byte, nGenes int) error {
+
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "LOCUS       %-24s%6d bp    RNA     linear   VRL 01-JAN-2020\n", name, len(ref))
+	bw.WriteString("FEATURES             Location/Qualifiers\n")
+	fmt.Fprintf(bw, "     source          1..%d\n", len(ref))
+	bw.WriteString("                     /organism=\"synthetic\"\n")
+
+	geneLen := len(ref) / nGenes
+
+	for g := 0; g < nGenes; g++ {
+		start := g * geneLen
+		end := start + geneLen - geneLen%3
+		gene := "gene" + strconv.Itoa(g+1)
+
+		copy(ref[start:], "ATG")
+		copy(ref[end-3:], "TAA")
+		for j := start + 3; j < end-3; j += 3 {
+			if codon := string(ref[j : j+3]); codon == "TAA" || codon == "TAG" || codon == "TGA" {
+				ref[j] = 'C'
+			}
+		}
+
+		fmt.Fprintf(bw, "     gene            %d..%d\n", start+1, end)
+		bw.WriteString("                     /gene=\"" + gene + "\"\n")
+		fmt.Fprintf(bw, "     CDS             %d..%d\n", start+1, end)
+		bw.WriteString("                     /gene=\"" + gene + "\"\n")
+		bw.WriteString("                     /product=\"" + gene + " protein\"\n")
+		bw.WriteString("                     /codon_start=1\n")
+	}
+
+	bw.WriteString("ORIGIN\n")
+	for i := 0; i < len(ref); i += 60 {
+		fmt.Fprintf(bw, "%9d", i+1)
+		for j := i; j < i+60 && j < len(ref); j += 10 {
+			k := j + 10
+			if k > len(ref) {
+				k = len(ref)
+			}
+			bw.WriteString(" " + strings.ToLower(string(ref[j:k])))
+		}
+		bw.WriteString("\n")
+	}
+
+	_, err := bw.WriteString("//\n")
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// CRLF gives s Windows line endings, so that tests can check that files written on Windows are
+// read in the same way as ones written elsewhere
+func CRLF(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
@@ -0,0 +1,50 @@
+package testutil
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+func TestWriteSam(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ref := RandomSeq(r, 5000)
+
+	var buf bytes.Buffer
+	err := WriteSam(&buf, r, "ref", ref, 200, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := biogosam.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for {
+		rec, err := s.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+
+		rlen, qlen := rec.Cigar.Lengths()
+		if qlen != rec.Seq.Length {
+			t.Errorf("problem in TestWriteSam: CIGAR %s doesn't match SEQ length %d", rec.Cigar, rec.Seq.Length)
+		}
+		if rec.Pos+rlen > len(ref) {
+			t.Errorf("problem in TestWriteSam: %s aligns past the end of the reference", rec.Name)
+		}
+	}
+
+	if n < 200 {
+		t.Errorf("problem in TestWriteSam: wrong number of records (%d)", n)
+	}
+}
@@ -0,0 +1,271 @@
+/*
+Package aatype reports the amino acid that each query has at particular residues of the
+reference's CDSs (e.g. S:484), from an alignment or a SAM file.
+*/
+package aatype
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/sam"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// deleted is what is written for a deleted codon
+const deleted = "del"
+
+// residue is one gene:position to type
+type residue struct {
+	name         string
+	codon        int            // 0-based
+	codons       int            // the number of codons in the gene
+	cols         []int          // the 0-based alignment column of each nucleotide of the gene
+	complemented []bool         // whether each nucleotide is on the reverse strand
+	ref          string         // the reference amino acid
+	coding       genbank.Coding // its genetic code and translation exceptions
+}
+
+// parseResidues finds the codon of each gene:position in the genbank record
+func parseResidues(positions []string, gb genbank.Genbank) ([]residue, error) {
+
+	if len(positions) == 0 {
+		return nil, usage.New("no residues were given (e.g. S:484 S:501)")
+	}
+
+	residues := make([]residue, 0, len(positions))
+	for _, s := range positions {
+		i := strings.LastIndex(s, ":")
+		if i == -1 {
+			return nil, usage.Errorf("couldn't parse residue %s (it should be gene:position, e.g. S:484)", s)
+		}
+		gene := s[:i]
+		codon, err := strconv.Atoi(s[i+1:])
+		if err != nil || codon < 1 {
+			return nil, usage.Errorf("couldn't parse residue %s (it should be gene:position, e.g. S:484)", s)
+		}
+
+		f, ok := gb.FindCDS(gene)
+		if !ok {
+			return nil, usage.Errorf("residue %s: there is no CDS with /gene=%s in the genbank file", s, gene)
+		}
+		positions, complemented, err := f.Positions()
+		if err != nil {
+			return nil, err
+		}
+		if codon*3 > len(positions) {
+			return nil, usage.Errorf("residue %s is past the end of %s, which has %d codons", s, gene, len(positions)/3)
+		}
+
+		aa, err := f.Translate(gb.ORIGIN)
+		if err != nil {
+			return nil, err
+		}
+		coding, err := f.Coding()
+		if err != nil {
+			return nil, err
+		}
+
+		r := residue{name: s, codon: codon - 1, codons: len(positions) / 3, complemented: complemented, coding: coding}
+		for _, pos := range positions {
+			r.cols = append(r.cols, pos-1)
+		}
+		if codon <= len(aa) {
+			r.ref = aa[codon-1 : codon]
+		}
+		residues = append(residues, r)
+	}
+
+	return residues, nil
+}
+
+// typeResidue is the amino acid that a query has at a residue
+func (r residue) typeResidue(seq string, t *alphabet.Translator) string {
+	if r.cols[r.codon*3+2] >= len(seq) {
+		return "X"
+	}
+	aa := r.coding.Translator(t).At(r.codon, r.codons, func(j int) byte {
+		if r.cols[j] >= len(seq) {
+			return 'N'
+		}
+		if r.complemented[j] {
+			return genbank.Complement(seq[r.cols[j]])
+		}
+		return seq[r.cols[j]]
+	})
+	if aa == alphabet.Deleted {
+		return deleted
+	}
+	return string(aa)
+}
+
+// typeQueries types every residue in each query it is sent
+func typeQueries(residues []residue, policy alphabet.CodonPolicy, cFR chan fastaio.FastaRecord, cOut chan []string) {
+	t := alphabet.NewTranslator(policy)
+	for FR := range cFR {
+		row := []string{strconv.Itoa(FR.Idx), FR.ID}
+		for _, r := range residues {
+			row = append(row, r.typeResidue(FR.Seq, t))
+		}
+		cOut <- row
+	}
+}
+
+// writeTypes writes the rows in the order of the queries
+func writeTypes(outfile string, residues []residue, cOut chan []string, cErr chan error, cDone chan bool) {
+
+	var f *os.File
+	var err error
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+
+	header := []string{"query"}
+	for _, r := range residues {
+		i := strings.LastIndex(r.name, ":")
+		header = append(header, r.name[:i+1]+r.ref+r.name[i+1:])
+	}
+	w.WriteString(strings.Join(header, ",") + "\n")
+
+	outputMap := make(map[int][]string)
+	counter := 0
+
+	for row := range cOut {
+		idx, _ := strconv.Atoi(row[0])
+		outputMap[idx] = row[1:]
+		for {
+			next, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			w.WriteString(strings.Join(next, ",") + "\n")
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	summary.Add(summary.Processed, counter)
+
+	cDone <- true
+}
+
+// AAType writes the amino acid that each query has at each of residues (gene:position, for the
+// CDSs in genbankFile), reading the queries from queryFile, an alignment to the reference in fasta
+// format, or from samFile if it isn't empty. A codon that is deleted is written as del, one that
+// is partly deleted is translated according to policy (see alphabet.CodonPolicy), and one with
+// ambiguity codes is X unless every codon it could be is the same amino acid. The queries are
+// typed by threads workers (or one per CPU if threads == 0)
+func AAType(queryFile string, samFile string, genbankFile string, residues []string, policy alphabet.CodonPolicy, outfile string, threads int) error {
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	if len(genbankFile) == 0 {
+		return usage.New("aatype needs the reference's annotation (--genbank)")
+	}
+	gb, err := genbank.Load(genbankFile)
+	if err != nil {
+		return err
+	}
+
+	rs, err := parseResidues(residues, gb)
+	if err != nil {
+		return err
+	}
+
+	cFR := make(chan fastaio.FastaRecord, threads)
+	cIndexed := make(chan fastaio.FastaRecord, threads)
+	cOut := make(chan []string, threads)
+	cErr := make(chan error)
+	cReadDone := make(chan bool)
+	cWriteDone := make(chan bool)
+
+	if len(samFile) > 0 {
+		go sam.ReadAligned(samFile, 0, false, "skip", threads, cFR, cErr, cReadDone)
+	} else {
+		go fastaio.ReadAlignment(queryFile, cFR, cErr, cReadDone)
+	}
+
+	go writeTypes(outfile, rs, cOut, cErr, cWriteDone)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+	for n := 0; n < threads; n++ {
+		go func() {
+			typeQueries(rs, policy, cIndexed, cOut)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(cOut)
+	}()
+
+	// fasta records aren't numbered as they are read, SAM records are
+	counter := 0
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			if len(samFile) == 0 {
+				FR.Idx = counter
+				counter++
+			}
+			select {
+			case cIndexed <- FR:
+			case err := <-cErr:
+				return err
+			}
+		case <-cReadDone:
+			n--
+		}
+	}
+
+	// anything that was sent before cReadDone
+	for len(cFR) > 0 {
+		FR := <-cFR
+		if len(samFile) == 0 {
+			FR.Idx = counter
+			counter++
+		}
+		cIndexed <- FR
+	}
+	close(cIndexed)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cWriteDone:
+			n--
+		}
+	}
+
+	return nil
+}
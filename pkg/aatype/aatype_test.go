@@ -0,0 +1,97 @@
+package aatype
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+var testGenbank = `LOCUS       test                      24 bp    DNA     linear   VRL 01-JAN-2021
+FEATURES             Location/Qualifiers
+     CDS             1..18
+                     /gene="g1"
+     CDS             complement(19..24)
+                     /gene="g2"
+ORIGIN
+        1 atgaaacccg ggttttaaac gtac
+//
+`
+
+func TestAAType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	genbankFile := filepath.Join(dir, "test.gb")
+	err = ioutil.WriteFile(genbankFile, []byte(testGenbank), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// q1 has K2E, a deleted fourth codon and (on the reverse strand) V1A, q2's R could be K or E
+	// and its fourth codon is partly deleted (which is del with CodonDel), and q3's R can only be K
+	queryFile := filepath.Join(dir, "query.fasta")
+	err = ioutil.WriteFile(queryFile, []byte(">q1\nATGGAACCC---TTTTAAACGTGC\n>q2\nATGRAACCCGG-TTTTAAACGTAC\n>q3\nATGAARCCCGGGTTTTAAACGTAC\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "out.csv")
+
+	err = AAType(queryFile, "", genbankFile, []string{"g1:2", "g1:4", "G2:1"}, alphabet.CodonDel, outFile, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "query,g1:K2,g1:G4,G2:V1\n" +
+		"q1,E,del,A\n" +
+		"q2,X,del,V\n" +
+		"q3,K,G,V\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestAAType: got\n%s\nexpected\n%s", out, expected)
+	}
+
+	// the same residues from a SAM file, where q5 has the fourth codon deleted
+	samFile := filepath.Join(dir, "query.sam")
+	err = ioutil.WriteFile(samFile, []byte("@HD\tVN:1.6\n@SQ\tSN:test\tLN:24\n"+
+		"q4\t0\ttest\t1\t60\t24M\t*\t0\t0\tATGGAACCCGGGTTTTAAACGTAC\t*\n"+
+		"q5\t0\ttest\t1\t60\t9M3D12M\t*\t0\t0\tATGAAACCCTTTTAAACGTAC\t*\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = AAType("", samFile, genbankFile, []string{"g1:2", "g1:4", "G2:1"}, alphabet.CodonX, outFile, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = "query,g1:K2,g1:G4,G2:V1\n" +
+		"q4,E,G,V\n" +
+		"q5,K,del,V\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestAAType: got\n%s\nexpected\n%s", out, expected)
+	}
+
+	for _, residues := range [][]string{{"g3:1"}, {"g1:7"}, {"g1"}, {}} {
+		err = AAType(queryFile, "", genbankFile, residues, alphabet.CodonX, outFile, 2)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestAAType: expected a usage error for %v, got %v", residues, err)
+		}
+	}
+}
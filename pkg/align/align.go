@@ -0,0 +1,415 @@
+/*
+Package align aligns unaligned sequences (e.g. consensus genomes) to a reference
+and writes the alignments in SAM format, so that they can be used with the sam
+subcommands without an external aligner.
+
+Alignments are seeded with k-mers that are unique in the reference, which are
+chained and merged into blocks of exact matches; the gaps between the blocks,
+and the ends of the query, are filled in with affine-gap dynamic programming.
+*/
+package align
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// alignment is one query's alignment to the reference, as it goes in a SAM record
+type alignment struct {
+	name    string
+	flag    int
+	pos     int // 0-based
+	cigar   string
+	seq     []byte
+	md      string
+	nm      int
+	idx     int
+	aligned bool
+}
+
+// cleanSeq makes a sequence upper case and drops any gaps, so that aligned
+// sequences can be realigned
+func cleanSeq(seq string) []byte {
+	clean := make([]byte, 0, len(seq))
+	for i := 0; i < len(seq); i++ {
+		b := seq[i]
+		if b == '-' || b == '*' || b == ' ' {
+			continue
+		}
+		if b >= 'a' && b <= 'z' {
+			b -= 'a' - 'A'
+		}
+		clean = append(clean, b)
+	}
+	return clean
+}
+
+// matched is the number of query bases in a set of blocks
+func matched(bs []block) int {
+	n := 0
+	for _, b := range bs {
+		n += b.length
+	}
+	return n
+}
+
+// tidyEnds makes insertions at the ends of an alignment into soft clips, and drops
+// deletions at its ends (moving its start for the ones at the left)
+func tidyEnds(ops []byte, pos int) ([]byte, int) {
+
+	start := 0
+	for start < len(ops) && ops[start] == 'S' {
+		start++
+	}
+	for start < len(ops) && (ops[start] == 'I' || ops[start] == 'D') {
+		if ops[start] == 'D' {
+			pos++
+			ops = append(ops[:start], ops[start+1:]...)
+			continue
+		}
+		ops[start] = 'S'
+		start++
+	}
+
+	end := len(ops) - 1
+	for end >= 0 && ops[end] == 'S' {
+		end--
+	}
+	for end >= 0 && (ops[end] == 'I' || ops[end] == 'D') {
+		if ops[end] == 'D' {
+			ops = append(ops[:end], ops[end+1:]...)
+		} else {
+			ops[end] = 'S'
+		}
+		end--
+	}
+
+	return ops, pos
+}
+
+// describe makes the CIGAR, MD tag and edit distance for an alignment's operations
+func describe(ops []byte, query []byte, ref []byte, pos int) (string, string, int) {
+
+	var cigar strings.Builder
+	var md strings.Builder
+	nm := 0
+	matches := 0
+
+	qi, ri := 0, pos
+
+	for i := 0; i < len(ops); {
+		op := ops[i]
+		j := i
+		for j < len(ops) && ops[j] == op {
+			j++
+		}
+		length := j - i
+		cigar.WriteString(strconv.Itoa(length))
+		cigar.WriteByte(op)
+
+		switch op {
+		case 'M':
+			for x := 0; x < length; x++ {
+				if query[qi] == ref[ri] {
+					matches++
+				} else {
+					md.WriteString(strconv.Itoa(matches))
+					md.WriteByte(ref[ri])
+					matches = 0
+					nm++
+				}
+				qi++
+				ri++
+			}
+		case 'I':
+			nm += length
+			qi += length
+		case 'D':
+			md.WriteString(strconv.Itoa(matches) + "^")
+			md.Write(ref[ri : ri+length])
+			matches = 0
+			nm += length
+			ri += length
+		case 'S':
+			qi += length
+		}
+
+		i = j
+	}
+
+	md.WriteString(strconv.Itoa(matches))
+
+	return cigar.String(), md.String(), nm
+}
+
+// alignQuery aligns one query to the reference (on whichever strand has more
+// seed matches). Queries that don't have any seed matches are unaligned
+func alignQuery(idx kmerIndex, ref []byte, name string, seq string, bandWidth int) (alignment, error) {
+
+	query := cleanSeq(seq)
+
+	a := alignment{name: name, seq: query}
+
+	if len(query) == 0 {
+		a.flag = 4
+		return a, nil
+	}
+
+	bs := blocks(chain(idx.seeds(query)))
+
+	rc := alphabet.ReverseComplement(query)
+	if rcbs := blocks(chain(idx.seeds(rc))); matched(rcbs) > matched(bs) {
+		bs = rcbs
+		query = rc
+		a.seq = rc
+		a.flag = 16
+	}
+
+	if len(bs) == 0 {
+		a.flag = 4
+		return a, nil
+	}
+
+	ops := make([]byte, 0, len(query)+len(query)/10)
+
+	// the left end is anchored at the first block, and can start anywhere in the
+	// part of the reference that the rest of the query could reach
+	first := bs[0]
+	rs := first.r - first.q - bandWidth
+	if rs < 0 {
+		rs = 0
+	}
+	left, err := dp(query[:first.q], ref[rs:first.r], freeStart, bandWidth)
+	if err != nil {
+		return alignment{}, fmt.Errorf("%s: %v", name, err)
+	}
+	for i := 0; i < left.qStart; i++ {
+		ops = append(ops, 'S')
+	}
+	ops = append(ops, left.ops...)
+	pos := rs + left.rStart
+
+	for i, b := range bs {
+		if i > 0 {
+			p := bs[i-1]
+			mid, err := dp(query[p.q+p.length:b.q], ref[p.r+p.length:b.r], global, bandWidth)
+			if err != nil {
+				return alignment{}, fmt.Errorf("%s: %v", name, err)
+			}
+			ops = append(ops, mid.ops...)
+		}
+		for x := 0; x < b.length; x++ {
+			ops = append(ops, 'M')
+		}
+	}
+
+	// the right end is anchored at the last block
+	last := bs[len(bs)-1]
+	qe, re := last.q+last.length, last.r+last.length
+	rEnd := re + len(query) - qe + bandWidth
+	if rEnd > len(ref) {
+		rEnd = len(ref)
+	}
+	right, err := dp(query[qe:], ref[re:rEnd], freeEnd, bandWidth)
+	if err != nil {
+		return alignment{}, fmt.Errorf("%s: %v", name, err)
+	}
+	ops = append(ops, right.ops...)
+	for i := qe + right.qEnd; i < len(query); i++ {
+		ops = append(ops, 'S')
+	}
+
+	ops, pos = tidyEnds(ops, pos)
+
+	a.pos = pos
+	a.cigar, a.md, a.nm = describe(ops, query, ref, pos)
+	a.aligned = true
+
+	return a, nil
+}
+
+// samLine formats an alignment as a SAM record
+func (a alignment) samLine(refName string) string {
+	seq := string(a.seq)
+	if len(seq) == 0 {
+		seq = "*"
+	}
+	if !a.aligned {
+		return a.name + "\t4\t*\t0\t0\t*\t*\t0\t0\t" + seq + "\t*\n"
+	}
+	return a.name + "\t" + strconv.Itoa(a.flag) + "\t" + refName + "\t" + strconv.Itoa(a.pos+1) + "\t60\t" + a.cigar +
+		"\t*\t0\t0\t" + seq + "\t*\tNM:i:" + strconv.Itoa(a.nm) + "\tMD:Z:" + a.md + "\n"
+}
+
+// alignQueries aligns each query that arrives on cIn
+func alignQueries(idx kmerIndex, ref []byte, bandWidth int, cIn chan fastaio.FastaRecord, cOut chan alignment, cErr chan error) {
+	for FR := range cIn {
+		a, err := alignQuery(idx, ref, FR.ID, FR.Seq, bandWidth)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		a.idx = FR.Idx
+		cOut <- a
+	}
+}
+
+// writeOutput writes the SAM header, then the records in the same order as the
+// queries were in the input file as they arrive
+func writeOutput(outFile string, refName string, refLen int, cOut chan alignment, cErr chan error, cWriteDone chan bool) {
+
+	var f *os.File
+	var err error
+
+	if outFile != "stdout" {
+		f, err = os.Create(outFile)
+		if err != nil {
+			cErr <- err
+			return
+		}
+	} else {
+		f = os.Stdout
+	}
+
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	w.WriteString("@HD\tVN:1.6\tSO:unsorted\n")
+	w.WriteString("@SQ\tSN:" + refName + "\tLN:" + strconv.Itoa(refLen) + "\n")
+	w.WriteString("@PG\tID:gofasta\tPN:gofasta\tVN:" + version.Version + "\tCL:" + strings.Join(os.Args, " ") + "\n")
+
+	outputMap := make(map[int]alignment)
+	counter := 0
+
+	for a := range cOut {
+		outputMap[a.idx] = a
+		for {
+			next, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			_, err = w.WriteString(next.samLine(refName))
+			if err != nil {
+				cErr <- err
+				return
+			}
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	cWriteDone <- true
+}
+
+// Align aligns the sequences in a fasta file to a reference, and writes the
+// alignments in SAM format (with MD tags). bandWidth is how far from the diagonal
+// the alignments between seed matches can go, on top of any difference in length
+func Align(referenceFile string, queryFile string, outFile string, bandWidth int, threads int) error {
+
+	defer profiling.Region("align")()
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	} else if threads < runtime.NumCPU() {
+		runtime.GOMAXPROCS(threads)
+	}
+
+	refRecord, err := cliio.ReadReference(referenceFile)
+	if err != nil {
+		return err
+	}
+	ref := cleanSeq(refRecord.Seq)
+
+	idx := newKmerIndex(ref)
+
+	cErr := make(chan error)
+
+	cFR := make(chan fastaio.FastaRecord)
+	cFRDone := make(chan bool)
+
+	cIn := make(chan fastaio.FastaRecord, threads)
+	cOut := make(chan alignment, threads)
+	cAlignDone := make(chan bool)
+	cWriteDone := make(chan bool)
+
+	go fastaio.ReadAlignment(queryFile, cFR, cErr, cFRDone)
+
+	go writeOutput(outFile, refRecord.ID, len(ref), cOut, cErr, cWriteDone)
+
+	var wgAlign sync.WaitGroup
+	wgAlign.Add(threads)
+
+	for n := 0; n < threads; n++ {
+		go func() {
+			alignQueries(idx, ref, bandWidth, cIn, cOut, cErr)
+			wgAlign.Done()
+		}()
+	}
+
+	go func() {
+		wgAlign.Wait()
+		cAlignDone <- true
+	}()
+
+	counter := 0
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			// an empty input file gives us one empty record
+			if len(FR.ID) == 0 && len(FR.Seq) == 0 {
+				continue
+			}
+			FR.Idx = counter
+			counter++
+			cIn <- FR
+		case <-cFRDone:
+			close(cIn)
+			n--
+		}
+	}
+
+	summary.Add(summary.Processed, counter)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cAlignDone:
+			close(cOut)
+			n--
+		}
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cWriteDone:
+			n--
+		}
+	}
+
+	return nil
+}
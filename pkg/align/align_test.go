@@ -0,0 +1,136 @@
+package align
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+)
+
+func TestDP(t *testing.T) {
+	tests := []struct {
+		q      string
+		r      string
+		mode   int
+		ops    string
+		qStart int
+		rStart int
+		qEnd   int
+		rEnd   int
+	}{
+		{"ACGTACGT", "ACGTACGT", global, "MMMMMMMM", 0, 0, 8, 8},
+		{"ACGTGGACGT", "ACGTACGT", global, "MMMMIIMMMM", 0, 0, 10, 8},
+		{"ACGTACGT", "ACGTGGACGT", global, "MMMMDDMMMM", 0, 0, 8, 10},
+		{"", "ACG", global, "DDD", 0, 0, 0, 3},
+		{"ACG", "", global, "III", 0, 0, 3, 0},
+		{"ACGTACGT", "GGGGGGACGTACGT", freeStart, "MMMMMMMM", 0, 6, 8, 14},
+		{"TTTTTTACGTACGT", "ACGTACGT", freeStart, "MMMMMMMM", 6, 0, 14, 8},
+		{"ACGTACGT", "ACGTACGTGGGGGG", freeEnd, "MMMMMMMM", 0, 0, 8, 8},
+		{"ACGTACGTTTTTTT", "ACGTACGT", freeEnd, "MMMMMMMM", 0, 0, 8, 8},
+	}
+
+	for _, test := range tests {
+		res, err := dp([]byte(test.q), []byte(test.r), test.mode, 10)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(res.ops) != test.ops || res.qStart != test.qStart || res.rStart != test.rStart || res.qEnd != test.qEnd || res.rEnd != test.rEnd {
+			t.Errorf("problem in TestDP: aligning %s to %s gave %s (%d-%d, %d-%d), expected %s (%d-%d, %d-%d)",
+				test.q, test.r, res.ops, res.qStart, res.qEnd, res.rStart, res.rEnd,
+				test.ops, test.qStart, test.qEnd, test.rStart, test.rEnd)
+		}
+	}
+
+	maxCells = 10
+	defer func() { maxCells = 50000000 }()
+	_, err := dp([]byte("ACGTACGT"), []byte("ACGTACGT"), global, 10)
+	if err == nil {
+		t.Errorf("problem in TestDP: expected an error for a matrix that is too large")
+	}
+}
+
+func TestChain(t *testing.T) {
+	anchors := []anchor{{0, 0}, {1, 1}, {2, 500}, {3, 3}, {4, 4}, {5, 2}}
+	chained := chain(anchors)
+	expected := []anchor{{0, 0}, {1, 1}, {3, 3}, {4, 4}}
+	if len(chained) != len(expected) {
+		t.Fatalf("problem in TestChain: got %v, expected %v", chained, expected)
+	}
+	for i := range expected {
+		if chained[i] != expected[i] {
+			t.Errorf("problem in TestChain: got %v, expected %v", chained, expected)
+			break
+		}
+	}
+}
+
+func TestAlignQuery(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 5000)
+	idx := newKmerIndex(ref)
+
+	mutated := make([]byte, len(ref))
+	copy(mutated, ref)
+	mutated[1000] = 'A'
+	if ref[1000] == 'A' {
+		mutated[1000] = 'C'
+	}
+
+	// a deletion of 5 bases, an insertion of 3, some Ns and unaligned ends
+	query := string(mutated[100:2000]) + string(mutated[2005:3000]) + "GGG" + string(mutated[3000:3500]) +
+		"NNNNNNNNNN" + string(mutated[3510:4900])
+
+	tests := []struct {
+		name  string
+		seq   string
+		flag  int
+		pos   int
+		cigar string
+		nm    int
+	}{
+		{"same", string(ref), 0, 0, "5000M", 0},
+		{"query", query, 0, 100, "1900M5D995M3I1900M", 19},
+		{"reverse", string(alphabet.ReverseComplement(ref[200:4000])), 16, 200, "3800M", 0},
+		{"unaligned", string(testutil.RandomSeq(r, 200)), 4, 0, "", 0},
+		{"empty", "", 4, 0, "", 0},
+	}
+
+	for _, test := range tests {
+		a, err := alignQuery(idx, ref, test.name, test.seq, 100)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if a.flag != test.flag || a.pos != test.pos || a.cigar != test.cigar || a.nm != test.nm {
+			t.Errorf("problem in TestAlignQuery: %s aligned with flag %d, pos %d, cigar %s, NM %d; expected %d, %d, %s, %d",
+				test.name, a.flag, a.pos, a.cigar, a.nm, test.flag, test.pos, test.cigar, test.nm)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	ref := []byte("ACGTACGTAC")
+	query := []byte("TTACCTAGGAC")
+	cigar, md, nm := describe([]byte("SSMMMMIMDMMM"), query, ref, 0)
+	if cigar != "2S4M1I1M1D3M" || md != "2G1A0^C1T0A0" || nm != 6 {
+		t.Errorf("problem in TestDescribe: got %s %s %d", cigar, md, nm)
+	}
+}
+
+func BenchmarkAlignQuery(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 30000)
+	idx := newKmerIndex(ref)
+	seqs := testutil.RandomAlignment(r, ref, 10, 0.001)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := seqs[i%len(seqs)]
+		_, err := alignQuery(idx, ref, s.ID, string(s.Seq), 500)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package align
+
+import (
+	"fmt"
+	"math"
+)
+
+// the scoring scheme is minimap2's default for long reads: a gap of length L
+// scores -(gapOpen + L*gapExtend)
+const (
+	matchScore    = 2
+	mismatchScore = -4
+	gapOpen       = 4
+	gapExtend     = 2
+)
+
+const negInf = math.MinInt32 / 2
+
+// maxCells is the largest dynamic programming matrix we will fill for one region
+var maxCells = 50000000
+
+// the ends of a region that are fixed, for dp
+const (
+	global    = iota // both sequences are aligned end to end
+	freeStart        // the alignment can start anywhere in the first row or column (it is anchored at the end)
+	freeEnd          // the alignment can end anywhere (it is anchored at the start)
+)
+
+// traceback bits: the lowest two say where a cell's best score came from, and
+// the next two whether its gap scores extended a gap or opened a new one
+const (
+	fromDiag  = 0
+	fromE     = 1 // a deletion (a gap in the query)
+	fromF     = 2 // an insertion (a gap in the reference)
+	fromStart = 3
+	eExtended = 4
+	fExtended = 8
+)
+
+// baseCodes are 0-3 for ACGT (in either case) and -1 for anything else
+var baseCodes [256]int8
+
+func init() {
+	for i := range baseCodes {
+		baseCodes[i] = -1
+	}
+	for i, b := range []byte("ACGT") {
+		baseCodes[b] = int8(i)
+		baseCodes[b+'a'-'A'] = int8(i)
+	}
+}
+
+// score is the score for aligning two bases. Anything other than A, C, G or T
+// (e.g. the Ns in consensus genomes) scores 0, so that runs of missing data don't
+// pull gaps into the alignment
+func score(a, b byte) int32 {
+	ca, cb := baseCodes[a], baseCodes[b]
+	switch {
+	case ca < 0 || cb < 0:
+		return 0
+	case ca == cb:
+		return matchScore
+	default:
+		return mismatchScore
+	}
+}
+
+// dpResult is the alignment of a query region to a reference region
+type dpResult struct {
+	ops    []byte // 'M', 'I' or 'D' for each column of the alignment
+	qStart int    // where the alignment starts in the query (only non-zero for freeStart)
+	rStart int    // where the alignment starts in the reference (only non-zero for freeStart)
+	qEnd   int    // where the alignment ends in the query (only less than len(q) for freeEnd)
+	rEnd   int    // where the alignment ends in the reference (only less than len(r) for freeEnd)
+}
+
+// dp aligns q to r with affine gap penalties (Gotoh's algorithm). Global
+// alignments are banded: only cells within bandWidth (plus the difference in
+// the lengths of q and r) of the diagonal from the start to the end are filled.
+// The free-ended modes are for the ends of a query, where the caller limits the
+// size of r, so they fill the whole matrix.
+func dp(q []byte, r []byte, mode int, bandWidth int) (dpResult, error) {
+
+	m, n := len(q), len(r)
+
+	w := n
+	if mode == global {
+		w = bandWidth + m - n
+		if n > m {
+			w = bandWidth + n - m
+		}
+	}
+
+	// the band is lo[i]..hi[i] (inclusive) in row i, which starts at offsets[i] in tb
+	lo := make([]int, m+1)
+	hi := make([]int, m+1)
+	offsets := make([]int, m+1)
+	cells := 0
+	for i := 0; i <= m; i++ {
+		centre := 0
+		if m > 0 {
+			centre = i * n / m
+		}
+		lo[i], hi[i] = centre-w, centre+w
+		if lo[i] < 0 {
+			lo[i] = 0
+		}
+		if hi[i] > n {
+			hi[i] = n
+		}
+		offsets[i] = cells
+		cells += hi[i] - lo[i] + 1
+		if cells > maxCells {
+			return dpResult{}, fmt.Errorf("region too large to align (%d x %d)", m, n)
+		}
+	}
+
+	tb := make([]byte, cells)
+
+	Hp, Ep, Fp := make([]int32, n+1), make([]int32, n+1), make([]int32, n+1)
+	Hc, Ec, Fc := make([]int32, n+1), make([]int32, n+1), make([]int32, n+1)
+
+	best, bestI, bestJ := int32(negInf), 0, 0
+
+	for j := lo[0]; j <= hi[0]; j++ {
+		Fc[j] = negInf
+		if j == 0 || mode == freeStart {
+			Hc[j], Ec[j] = 0, negInf
+			tb[offsets[0]+j-lo[0]] = fromStart
+		} else {
+			Ec[j] = -(gapOpen + int32(j)*gapExtend)
+			Hc[j] = Ec[j]
+			t := byte(fromE)
+			if j > 1 {
+				t |= eExtended
+			}
+			tb[offsets[0]+j-lo[0]] = t
+		}
+		if mode == freeEnd && Hc[j] >= best {
+			best, bestI, bestJ = Hc[j], 0, j
+		}
+	}
+
+	for i := 1; i <= m; i++ {
+		Hp, Hc = Hc, Hp
+		Ep, Ec = Ec, Ep
+		Fp, Fc = Fc, Fp
+
+		for j := lo[i]; j <= hi[i]; j++ {
+			var t byte
+
+			// an insertion, from the cell above
+			f := int32(negInf)
+			if j >= lo[i-1] && j <= hi[i-1] {
+				open := Hp[j] - gapOpen - gapExtend
+				ext := Fp[j] - gapExtend
+				if ext > open {
+					f = ext
+					t |= fExtended
+				} else {
+					f = open
+				}
+			}
+
+			// a deletion, from the cell to the left
+			e := int32(negInf)
+			if j > lo[i] {
+				open := Hc[j-1] - gapOpen - gapExtend
+				ext := Ec[j-1] - gapExtend
+				if ext > open {
+					e = ext
+					t |= eExtended
+				} else {
+					e = open
+				}
+			}
+
+			h := int32(negInf)
+			src := byte(fromDiag)
+			if j > 0 && j-1 >= lo[i-1] && j-1 <= hi[i-1] {
+				h = Hp[j-1] + score(q[i-1], r[j-1])
+			}
+			if e > h {
+				h, src = e, fromE
+			}
+			if f > h {
+				h, src = f, fromF
+			}
+			if j == 0 && mode == freeStart && h <= 0 {
+				h, src = 0, fromStart
+			}
+
+			Hc[j], Ec[j], Fc[j] = h, e, f
+			tb[offsets[i]+j-lo[i]] = t | src
+
+			if mode == freeEnd && h >= best {
+				best, bestI, bestJ = h, i, j
+			}
+		}
+	}
+
+	res := dpResult{qEnd: m, rEnd: n}
+	if mode == freeEnd {
+		res.qEnd, res.rEnd = bestI, bestJ
+	}
+
+	i, j := res.qEnd, res.rEnd
+	state := byte(fromDiag)
+	ops := make([]byte, 0, i+j)
+
+	for i > 0 || j > 0 {
+		t := tb[offsets[i]+j-lo[i]]
+
+		if state == fromE {
+			ops = append(ops, 'D')
+			if t&eExtended == 0 {
+				state = fromDiag
+			}
+			j--
+			continue
+		}
+
+		if state == fromF {
+			ops = append(ops, 'I')
+			if t&fExtended == 0 {
+				state = fromDiag
+			}
+			i--
+			continue
+		}
+
+		src := t & 3
+		if src == fromStart {
+			break
+		}
+		if src == fromDiag {
+			ops = append(ops, 'M')
+			i--
+			j--
+			continue
+		}
+		state = src
+	}
+
+	res.qStart, res.rStart = i, j
+
+	for a, b := 0, len(ops)-1; a < b; a, b = a+1, b-1 {
+		ops[a], ops[b] = ops[b], ops[a]
+	}
+	res.ops = ops
+
+	return res, nil
+}
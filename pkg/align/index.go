@@ -0,0 +1,141 @@
+package align
+
+import "sort"
+
+// k is the length of the k-mers used to seed alignments
+const k = 15
+
+// kmerIndex is the position of each k-mer that is in the reference exactly once
+// (2 bits per base, so 15-mers fit in a uint32). Repeated k-mers are -1
+type kmerIndex map[uint32]int
+
+// kmers calls fn with the position and code of each k-mer in seq that only has
+// A, C, G and T in it
+func kmers(seq []byte, fn func(pos int, code uint32)) {
+	var code uint32
+	mask := uint32(1)<<(2*k) - 1
+	valid := 0
+	for i, b := range seq {
+		c := baseCodes[b]
+		if c < 0 {
+			valid = 0
+			continue
+		}
+		code = (code<<2 | uint32(c)) & mask
+		valid++
+		if valid >= k {
+			fn(i-k+1, code)
+		}
+	}
+}
+
+// newKmerIndex indexes the k-mers in the reference
+func newKmerIndex(ref []byte) kmerIndex {
+	idx := make(kmerIndex, len(ref))
+	kmers(ref, func(pos int, code uint32) {
+		if _, ok := idx[code]; ok {
+			idx[code] = -1
+		} else {
+			idx[code] = pos
+		}
+	})
+	return idx
+}
+
+// anchor is a k-mer that is in the query at q and the reference at r
+type anchor struct {
+	q int
+	r int
+}
+
+// seeds returns the anchors between a query and the reference, in query order
+func (idx kmerIndex) seeds(query []byte) []anchor {
+	anchors := make([]anchor, 0)
+	kmers(query, func(pos int, code uint32) {
+		if r, ok := idx[code]; ok && r >= 0 {
+			anchors = append(anchors, anchor{q: pos, r: r})
+		}
+	})
+	return anchors
+}
+
+// chain returns the longest subset of anchors that are in the same order in the
+// query and the reference (the anchors must be sorted by their query position)
+func chain(anchors []anchor) []anchor {
+
+	// tails[l] is the index of the anchor with the smallest reference position
+	// that ends a chain of length l+1
+	tails := make([]int, 0)
+	previous := make([]int, len(anchors))
+
+	for i, a := range anchors {
+		l := sort.Search(len(tails), func(x int) bool { return anchors[tails[x]].r >= a.r })
+		if l > 0 {
+			previous[i] = tails[l-1]
+		} else {
+			previous[i] = -1
+		}
+		if l == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[l] = i
+		}
+	}
+
+	chained := make([]anchor, len(tails))
+	if len(tails) == 0 {
+		return chained
+	}
+	for i, x := len(tails)-1, tails[len(tails)-1]; i >= 0; i, x = i-1, previous[x] {
+		chained[i] = anchors[x]
+	}
+
+	return chained
+}
+
+// block is a run of exact matches between the query and the reference
+type block struct {
+	q      int
+	r      int
+	length int
+}
+
+func (b block) diagonal() int {
+	return b.r - b.q
+}
+
+// blocks merges chained anchors into blocks of exact matches. Anchors that overlap
+// the previous block on a different diagonal are dropped, as are short blocks
+// whose diagonal is different to the blocks either side of them, which are likely
+// to be chance matches (the gaps around them are aligned anyway, so losing a
+// real one costs nothing but time)
+func blocks(chained []anchor) []block {
+
+	bs := make([]block, 0)
+
+	for _, a := range chained {
+		if len(bs) > 0 {
+			last := &bs[len(bs)-1]
+			if a.q <= last.q+last.length && a.r-a.q == last.diagonal() {
+				last.length = a.q + k - last.q
+				continue
+			}
+			if a.q < last.q+last.length || a.r < last.r+last.length {
+				continue
+			}
+		}
+		bs = append(bs, block{q: a.q, r: a.r, length: k})
+	}
+
+	kept := make([]block, 0, len(bs))
+	for i, b := range bs {
+		if b.length < 2*k && len(bs) > 1 &&
+			(i == 0 || bs[i-1].diagonal() != b.diagonal()) &&
+			(i == len(bs)-1 || bs[i+1].diagonal() != b.diagonal()) {
+			continue
+		}
+		kept = append(kept, b)
+	}
+
+	return kept
+}
@@ -0,0 +1,75 @@
+package alphabet
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// geneticCodes are the amino acids of NCBI's genetic codes (the numbers that /transl_table
+// uses), for the codons in the order TTT, TTC, TTA, TTG, TCT, ... GGG, as NCBI writes them
+var geneticCodes = map[int]string{
+	1:  "FFLLSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // standard
+	2:  "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSS**VVVVAAAADDEEGGGG", // vertebrate mitochondrial
+	3:  "FFLLSSSSYY**CCWWTTTTPPPPHHQQRRRRIIMMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // yeast mitochondrial
+	4:  "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // mold, protozoan and coelenterate mitochondrial, mycoplasma
+	5:  "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSSSSVVVVAAAADDEEGGGG", // invertebrate mitochondrial
+	6:  "FFLLSSSSYYQQCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // ciliate, dasycladacean and hexamita nuclear
+	9:  "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNNKSSSSVVVVAAAADDEEGGGG", // echinoderm and flatworm mitochondrial
+	10: "FFLLSSSSYY**CCCWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // euplotid nuclear
+	11: "FFLLSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // bacterial, archaeal and plant plastid
+	12: "FFLLSSSSYY**CC*WLLLSPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // alternative yeast nuclear
+	13: "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSSGGVVVVAAAADDEEGGGG", // ascidian mitochondrial
+	14: "FFLLSSSSYYY*CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNNKSSSSVVVVAAAADDEEGGGG", // alternative flatworm mitochondrial
+	16: "FFLLSSSSYY*LCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // chlorophycean mitochondrial
+	21: "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNNKSSSSVVVVAAAADDEEGGGG", // trematode mitochondrial
+	22: "FFLLSS*SYY*LCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // scenedesmus obliquus mitochondrial
+	23: "FF*LSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // thraustochytrium mitochondrial
+	24: "FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSSKVVVVAAAADDEEGGGG", // rhabdopleuridae mitochondrial
+	25: "FFLLSSSSYY**CCGWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // candidate division SR1 and gracilibacteria
+	26: "FFLLSSSSYY**CC*WLLLAPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG", // pachysolen tannophilus nuclear
+}
+
+// codonTables are the genetic codes as maps from codon to amino acid, made once
+var codonTables = make(map[int]map[string]string)
+
+func init() {
+	const bases = "TCAG"
+	for id, aas := range geneticCodes {
+		table := make(map[string]string, 64)
+		for i := 0; i < 64; i++ {
+			codon := string([]byte{bases[i/16], bases[i/4%4], bases[i%4]})
+			table[codon] = aas[i : i+1]
+		}
+		codonTables[id] = table
+	}
+}
+
+// GeneticCode returns one of NCBI's genetic codes (the number that /transl_table gives, e.g. 1
+// for the standard code or 2 for the vertebrate mitochondrial code) as a map from codon to amino
+// acid, like MakeCodonDict. The map is shared, so it mustn't be changed
+func GeneticCode(id int) (map[string]string, error) {
+	table, ok := codonTables[id]
+	if !ok {
+		ids := make([]int, 0, len(geneticCodes))
+		for id := range geneticCodes {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			names[i] = strconv.Itoa(id)
+		}
+		return nil, usage.Errorf("unknown genetic code: %d (choose one of: %s)", id, strings.Join(names, ", "))
+	}
+	return table, nil
+}
+
+// WithCode returns a Translator with t's policy that translates codons with codons (a table from
+// GeneticCode), and that translates codon i (0-based) of a coding sequence as exceptions[i] (e.g.
+// a selenocysteine from a genbank /transl_except) unless it is deleted
+func (t *Translator) WithCode(codons map[string]string, exceptions map[int]byte) *Translator {
+	return &Translator{Policy: t.Policy, codons: codons, exceptions: exceptions}
+}
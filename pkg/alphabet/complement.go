@@ -0,0 +1,35 @@
+package alphabet
+
+// complements is the complement of each IUPAC nucleotide code, in the same case. Gaps and
+// missing data are their own complements, U (RNA) is complemented as T, and anything else is N
+var complements [256]byte
+
+func init() {
+	for i := range complements {
+		complements[i] = 'N'
+	}
+	for _, p := range []string{"AT", "CG", "RY", "KM", "BV", "DH", "SS", "WW", "NN", "--", "??"} {
+		complements[p[0]], complements[p[1]] = p[1], p[0]
+		if p[0] >= 'A' && p[0] <= 'Z' {
+			complements[p[0]+'a'-'A'], complements[p[1]+'a'-'A'] = p[1]+'a'-'A', p[0]+'a'-'A'
+		}
+	}
+	complements['U'] = 'A'
+	complements['u'] = 'a'
+}
+
+// Complement returns the complement of a nucleotide (IUPAC codes included), or N if it isn't one
+func Complement(nuc byte) byte {
+	return complements[nuc]
+}
+
+// ReverseComplement returns the reverse complement of a nucleotide sequence, complementing
+// ambiguity codes to the codes for the complements of their nucleotides (e.g. R, A or G, to Y,
+// C or T) and keeping the case of each nucleotide
+func ReverseComplement(seq []byte) []byte {
+	rc := make([]byte, len(seq))
+	for i, b := range seq {
+		rc[len(seq)-1-i] = complements[b]
+	}
+	return rc
+}
@@ -0,0 +1,102 @@
+package alphabet
+
+import (
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// Alphabet is a set of characters that a sequence can be made of, and how they are encoded
+// for comparing aligned sequences
+type Alphabet struct {
+	Name    string
+	Unknown byte   // the character for an unknown residue (N or X)
+	Residue string // what one character of a sequence is called, e.g. in errors
+	valid   [256]bool
+
+	encoding [256]byte
+	decoding [256]string
+	sets     [256]uint32 // the residues that each encoded character could be, one bit each
+}
+
+func newAlphabet(name string, unknown byte, residue string, chars string, encoding [256]byte, decoding [256]string, sets [256]uint32) *Alphabet {
+	a := &Alphabet{Name: name, Unknown: unknown, Residue: residue, encoding: encoding, decoding: decoding, sets: sets}
+	for i := 0; i < len(chars); i++ {
+		a.valid[chars[i]] = true
+		a.valid[strings.ToLower(chars[i : i+1])[0]] = true
+	}
+	return a
+}
+
+// nucleotideSets are the nucleotide sets of the encoding package's codes, which are their high
+// four bits
+func nucleotideSets() [256]uint32 {
+	var sets [256]uint32
+	for i := range sets {
+		sets[i] = uint32(i >> 4)
+	}
+	return sets
+}
+
+// Nucleotide is the IUPAC nucleotide codes (including U), and - and ? for gaps and missing data.
+// Encoded sequences use the encoding package's (Paradis) scheme, which doesn't have U
+var Nucleotide = newAlphabet("nucleotide", 'N', "nucleotide", "ACGTURYSWKMBDHVN-?",
+	encoding.MakeEncodingArray(), encoding.MakeDecodingArray(), nucleotideSets())
+
+// Protein is the IUPAC amino acid codes (including the ambiguity codes B, Z and J, and the
+// rare amino acids U and O), and * for a stop codon and - for a gap
+var Protein = newAlphabet("protein", 'X', "amino acid", "ACDEFGHIKLMNPQRSTVWYBZJUOX*-",
+	encoding.MakeProteinEncodingArray(), encoding.MakeProteinDecodingArray(), encoding.MakeProteinSetArray())
+
+// Alphabets are the alphabets that ParseAlphabet knows
+var Alphabets = []*Alphabet{Nucleotide, Protein}
+
+// ParseAlphabet returns the alphabet called name (see Alphabets)
+func ParseAlphabet(name string) (*Alphabet, error) {
+	names := make([]string, 0, len(Alphabets))
+	for _, a := range Alphabets {
+		if strings.EqualFold(name, a.Name) {
+			return a, nil
+		}
+		names = append(names, a.Name)
+	}
+	return nil, usage.Errorf("unrecognised alphabet: %s (choose one of: %s)", name, strings.Join(names, ", "))
+}
+
+// Valid is true if c is in the alphabet, in either case
+func (a *Alphabet) Valid(c byte) bool {
+	return a.valid[c]
+}
+
+// Encoding is an array from characters to their encodings in this alphabet, which are 0 for
+// characters that can't be encoded
+func (a *Alphabet) Encoding() [256]byte {
+	return a.encoding
+}
+
+// Decoding is an array from encoded characters back to characters
+func (a *Alphabet) Decoding() [256]string {
+	return a.decoding
+}
+
+// EncodedUnknown is the encoding of the unknown residue, which is the same as any residue
+func (a *Alphabet) EncodedUnknown() byte {
+	return a.encoding[a.Unknown]
+}
+
+// Same is true if two encoded characters could be the same residue, so that (like an N and an
+// A, or a B and a D) they aren't a difference between two aligned sequences
+func (a *Alphabet) Same(x byte, y byte) bool {
+	return a.sets[x]&a.sets[y] != 0
+}
+
+// Ambiguous is true if an encoded character could be more than one residue (like an N, or an R)
+func (a *Alphabet) Ambiguous(x byte) bool {
+	return a.sets[x]&(a.sets[x]-1) != 0
+}
+
+// EncodedGap is the encoding of a gap
+func (a *Alphabet) EncodedGap() byte {
+	return a.encoding['-']
+}
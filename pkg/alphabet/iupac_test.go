@@ -0,0 +1,58 @@
+package alphabet
+
+import (
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func TestAlphabets(t *testing.T) {
+	a, err := ParseAlphabet("Protein")
+	if err != nil || a != Protein {
+		t.Fatalf("problem in TestAlphabets: couldn't parse an alphabet")
+	}
+	_, err = ParseAlphabet("rna")
+	if !usage.Is(err) {
+		t.Errorf("problem in TestAlphabets: expected a usage error for an unknown alphabet, got %v", err)
+	}
+
+	tests := []struct {
+		a        *Alphabet
+		x        byte
+		y        byte
+		expected bool
+	}{
+		{Nucleotide, 'A', 'A', true},
+		{Nucleotide, 'A', 'R', true},
+		{Nucleotide, 'A', 'Y', false},
+		{Nucleotide, 'A', '-', true},
+		{Protein, 'K', 'K', true},
+		{Protein, 'K', 'R', false},
+		{Protein, 'D', 'B', true},
+		{Protein, 'Q', 'B', false},
+		{Protein, 'q', 'Z', true},
+		{Protein, 'L', 'J', true},
+		{Protein, 'W', 'X', true},
+		{Protein, '*', 'X', false},
+		{Protein, '*', '-', true},
+		{Protein, 'K', 'E', false},
+	}
+
+	for _, test := range tests {
+		EA := test.a.Encoding()
+		if test.a.Same(EA[test.x], EA[test.y]) != test.expected {
+			t.Errorf("problem in TestAlphabets: %s %c %c: expected %v", test.a.Name, test.x, test.y, test.expected)
+		}
+	}
+
+	if Protein.Decoding()[Protein.EncodedUnknown()] != "X" {
+		t.Errorf("problem in TestAlphabets: the unknown amino acid doesn't decode to X")
+	}
+}
+
+func TestReverseComplement(t *testing.T) {
+	rc := string(ReverseComplement([]byte("ACGTRYKMBDHVNacgu-?E")))
+	if rc != "N?-acgtNBDHVKMRYACGT" {
+		t.Errorf("problem in TestReverseComplement: got %s", rc)
+	}
+}
@@ -0,0 +1,20 @@
+package alphabet
+
+import (
+	"strings"
+)
+
+var toDNA = strings.NewReplacer("U", "T", "u", "t")
+var toRNA = strings.NewReplacer("T", "U", "t", "u")
+
+// ToDNA converts an RNA sequence to DNA (U to T, keeping the case), so that RNA sequences (e.g.
+// RefSeq RNA records) and DNA sequences can be compared without every U being a SNP. A DNA
+// sequence is returned as it is
+func ToDNA(seq string) string {
+	return toDNA.Replace(seq)
+}
+
+// ToRNA converts a DNA sequence to RNA (T to U, keeping the case)
+func ToRNA(seq string) string {
+	return toRNA.Replace(seq)
+}
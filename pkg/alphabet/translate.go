@@ -0,0 +1,186 @@
+package alphabet
+
+import (
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// CodonPolicy is how a codon that is partly deleted (that has some gaps and some
+// nucleotides) is translated. A codon that is entirely deleted is always Deleted
+type CodonPolicy int
+
+const (
+	// CodonX translates a partly deleted codon as X
+	CodonX CodonPolicy = iota
+	// CodonDel translates a partly deleted codon as Deleted
+	CodonDel
+	// CodonFrame joins up the nucleotides either side of an in-frame deletion that doesn't
+	// start at the start of a codon (e.g. S:69/70 in SARS-CoV-2), and translates them as the
+	// first codon that the deletion touches, with the rest Deleted. Deletions that aren't in
+	// frame are translated as by CodonX
+	CodonFrame
+)
+
+// CodonPolicies are the names of the policies, for --codons
+var CodonPolicies = []string{"X", "del", "frame"}
+
+// Deleted is the translation of a deleted codon
+const Deleted = '-'
+
+// ParseCodonPolicy parses the name of a policy (see CodonPolicies)
+func ParseCodonPolicy(s string) (CodonPolicy, error) {
+	for i, name := range CodonPolicies {
+		if strings.EqualFold(s, name) {
+			return CodonPolicy(i), nil
+		}
+	}
+	return 0, usage.Errorf("unrecognised codon policy: %s (choose one of: %s)", s, strings.Join(CodonPolicies, ", "))
+}
+
+// nucleotides are the bases that each IUPAC code could be
+var nucleotides = map[byte]string{
+	'A': "A", 'C': "C", 'G': "G", 'T': "T",
+	'R': "AG", 'Y': "CT", 'S': "CG", 'W': "AT", 'K': "GT", 'M': "AC",
+	'B': "CGT", 'D': "AGT", 'H': "ACT", 'V': "ACG", 'N': "ACGT", '?': "ACGT",
+}
+
+// Translator translates codons that can have ambiguity codes and gaps in them
+type Translator struct {
+	Policy CodonPolicy
+	codons map[string]string
+	// amino acids for particular codons (see WithCode)
+	exceptions map[int]byte
+}
+
+// NewTranslator makes a Translator that translates partly deleted codons according to policy
+func NewTranslator(policy CodonPolicy) *Translator {
+	return &Translator{Policy: policy, codons: MakeCodonDict()}
+}
+
+// Codon translates a codon with no gaps in it. A codon with ambiguity codes in it is only
+// translated if every codon it could be is the same amino acid, and is X otherwise
+func (t *Translator) Codon(codon []byte) byte {
+	aa := ""
+	for _, a := range nucleotides[codon[0]] {
+		for _, b := range nucleotides[codon[1]] {
+			for _, c := range nucleotides[codon[2]] {
+				next := t.codons[string([]rune{a, b, c})]
+				if len(aa) > 0 && next != aa {
+					return 'X'
+				}
+				aa = next
+			}
+		}
+	}
+	if len(aa) == 0 {
+		return 'X'
+	}
+	return aa[0]
+}
+
+// gaps is the number of gaps in codon i
+func gaps(i int, base func(int) byte) int {
+	n := 0
+	for j := i * 3; j < i*3+3; j++ {
+		if base(j) == '-' {
+			n++
+		}
+	}
+	return n
+}
+
+// translateRange translates codons start up to end of a coding sequence whose jth nucleotide
+// is base(j), into aa[0:end-start]. start must not be in the middle of a deletion
+func (t *Translator) translateRange(start int, end int, base func(int) byte, aa []byte) {
+
+	codon := make([]byte, 3)
+
+	for i := start; i < end; {
+		g := gaps(i, base)
+
+		switch {
+		case g == 0:
+			if a, ok := t.exceptions[i]; ok {
+				aa[i-start] = a
+				i++
+				continue
+			}
+			for j := range codon {
+				codon[j] = base(i*3 + j)
+			}
+			aa[i-start] = t.Codon(codon)
+			i++
+			continue
+		case g == 3:
+			aa[i-start] = Deleted
+			i++
+			continue
+		case t.Policy == CodonDel:
+			aa[i-start] = Deleted
+			i++
+			continue
+		case t.Policy == CodonX:
+			aa[i-start] = 'X'
+			i++
+			continue
+		}
+
+		// take the next three nucleotides, which have to end at the end of a codon for the
+		// deletion to be in frame
+		n := 0
+		j := i * 3
+		for ; j < end*3 && n < 3; j++ {
+			if base(j) != '-' {
+				codon[n] = base(j)
+				n++
+			}
+		}
+		if n < 3 || j%3 != 0 {
+			aa[i-start] = 'X'
+			i++
+			continue
+		}
+		aa[i-start] = t.Codon(codon)
+		for k := i + 1; k < j/3; k++ {
+			aa[k-start] = Deleted
+		}
+		i = j / 3
+	}
+}
+
+// Translate translates a coding sequence (in frame, and aligned to the reference, so that
+// deletions are gaps), one amino acid per codon, with Deleted for deleted codons. An
+// incomplete final codon is dropped
+func (t *Translator) Translate(seq []byte) []byte {
+	n := len(seq) / 3
+	aa := make([]byte, n)
+	t.translateRange(0, n, func(j int) byte { return seq[j] }, aa)
+	return aa
+}
+
+// At translates codon i of a coding sequence of n codons whose jth nucleotide is base(j), which
+// can be called for a few codons of a long sequence without translating all of it. Only the
+// codons around i that have gaps in them are looked at, since they are all that the policy can
+// depend on
+func (t *Translator) At(i int, n int, base func(int) byte) byte {
+
+	if gaps(i, base) == 0 {
+		aa := make([]byte, 1)
+		t.translateRange(i, i+1, base, aa)
+		return aa[0]
+	}
+
+	start := i
+	for start > 0 && gaps(start-1, base) > 0 {
+		start--
+	}
+	end := i + 1
+	for end < n && gaps(end, base) > 0 {
+		end++
+	}
+
+	aa := make([]byte, end-start)
+	t.translateRange(start, end, base, aa)
+	return aa[i-start]
+}
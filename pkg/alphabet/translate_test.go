@@ -0,0 +1,99 @@
+package alphabet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// the first 72 codons of SARS-CoV-2's spike, up to S71 G72
+var spikeStart = "ATGTTTGTTTTTCTTGTTTTATTGCCACTAGTCTCTAGTCAGTGTGTTAATCTTACAACCAGAACTCAAT" +
+	"TACCCCCTGCATACACTAATTCTTTCACACGTGGTGTTTATTACCCTGACAAAGTTTTCAGATCCTCAGT" +
+	"TTTACATTCAACTCAGGACTTGTTCTTACCTTTCTTTTCCAATGTTACTTGGTTCCATGCTATACATGTC" +
+	"TCTGGG"
+
+// deleteRange replaces the (0-based, end-exclusive) range of seq with gaps
+func deleteRange(seq string, start int, end int) []byte {
+	return []byte(seq[:start] + strings.Repeat("-", end-start) + seq[end:])
+}
+
+func TestTranslate(t *testing.T) {
+
+	// 21765-21770 is TACATG, from the middle of I68 to the start of V70 (ATA CAT GTC), which
+	// leaves ATC (I) at 68
+	s := deleteRange(spikeStart, 202, 208)
+
+	// ORF1ab 3675-3677 (11288-11296) is SGF, which is codon-aligned. Here it's
+	// surrounded by the codons either side of it in ORF1ab
+	orf1ab := deleteRange("GTTTCTGGTTTTGCT", 3, 12)
+
+	tests := []struct {
+		policy   CodonPolicy
+		seq      []byte
+		codons   []int // 0-based
+		expected string
+	}{
+		{CodonX, s, []int{66, 67, 68, 69, 70}, "AX-XS"},
+		{CodonDel, s, []int{66, 67, 68, 69, 70}, "A---S"},
+		{CodonFrame, s, []int{66, 67, 68, 69, 70}, "AI--S"},
+		{CodonX, orf1ab, []int{0, 1, 2, 3, 4}, "V---A"},
+		{CodonDel, orf1ab, []int{0, 1, 2, 3, 4}, "V---A"},
+		{CodonFrame, orf1ab, []int{0, 1, 2, 3, 4}, "V---A"},
+		// a deletion that isn't in frame can't be joined up
+		{CodonFrame, deleteRange("ATGAAACCCGGG", 4, 8), []int{0, 1, 2, 3}, "MXXG"},
+		// ambiguity codes are only translated if they can only be one amino acid
+		{CodonFrame, []byte("AARARNCTNTTY"), []int{0, 1, 2, 3}, "KXLF"},
+	}
+
+	for _, test := range tests {
+		tr := NewTranslator(test.policy)
+		aa := tr.Translate(test.seq)
+		got := make([]byte, 0)
+		at := make([]byte, 0)
+		for _, i := range test.codons {
+			got = append(got, aa[i])
+			at = append(at, tr.At(i, len(test.seq)/3, func(j int) byte { return test.seq[j] }))
+		}
+		if string(got) != test.expected {
+			t.Errorf("problem in TestTranslate: %s with policy %s: got %s, expected %s", test.seq, CodonPolicies[test.policy], got, test.expected)
+		}
+		if string(at) != test.expected {
+			t.Errorf("problem in TestTranslate: %s with policy %s: At gave %s, expected %s", test.seq, CodonPolicies[test.policy], at, test.expected)
+		}
+	}
+
+	p, err := ParseCodonPolicy("Frame")
+	if err != nil || p != CodonFrame {
+		t.Errorf("problem in TestTranslate: couldn't parse a codon policy")
+	}
+	_, err = ParseCodonPolicy("restore")
+	if !usage.Is(err) {
+		t.Errorf("problem in TestTranslate: expected a usage error for an unknown codon policy, got %v", err)
+	}
+}
+
+func TestGeneticCode(t *testing.T) {
+	standard, err := GeneticCode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for codon, aa := range MakeCodonDict() {
+		if strings.Trim(codon, "ACGT") == "" && standard[codon] != aa {
+			t.Errorf("problem in TestGeneticCode: %s is %s in the standard code, not %s", codon, standard[codon], aa)
+		}
+	}
+
+	mito, err := GeneticCode(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTranslator(CodonX).WithCode(mito, map[int]byte{1: 'U'})
+	if got := string(tr.Translate([]byte("ATGTGAATAAGA"))); got != "MUM*" {
+		t.Errorf("problem in TestGeneticCode: got %s, want MUM*", got)
+	}
+
+	if _, err := GeneticCode(7); err == nil {
+		t.Errorf("problem in TestGeneticCode: expected an error for genetic code 7")
+	}
+}
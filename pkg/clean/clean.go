@@ -0,0 +1,283 @@
+/*
+Package clean checks that sequences are made of IUPAC nucleotide (or amino acid) codes,
+and deals with the characters that aren't, before they get into anything that assumes
+they are. It can also normalise the case of the sequences, and convert RNA (U) to DNA (T).
+*/
+package clean
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/remote"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// the name of the count of sequences that were changed in the run summary
+const changedSequences = "changed"
+
+// InvalidPolicies are the ways that characters that aren't in the alphabet can be dealt with:
+// replaced with the alphabet's unknown character (N or X), deleted, or treated as an error
+var InvalidPolicies = []string{"replace", "delete", "fail"}
+
+// CasePolicies are the cases that the sequences can be written in
+var CasePolicies = []string{"upper", "lower", "keep"}
+
+// Changes are what cleaning one sequence changed
+type Changes struct {
+	Invalid     int    // the number of characters that weren't in the alphabet
+	Characters  string // each different character that wasn't in the alphabet, in the order they were found
+	CaseChanged int    // the number of characters whose case was changed
+	UToT        int    // the number of Us that were converted to Ts
+}
+
+// Changed is true if the sequence isn't the same as it was
+func (c Changes) Changed() bool {
+	return c.Invalid > 0 || c.CaseChanged > 0 || c.UToT > 0
+}
+
+// Cleaner cleans sequences according to its policies
+type Cleaner struct {
+	alphabet *alphabet.Alphabet
+	invalid  string
+	casing   string
+	uToT     bool
+}
+
+func parsePolicy(kind string, s string, policies []string) (string, error) {
+	for _, p := range policies {
+		if strings.EqualFold(s, p) {
+			return p, nil
+		}
+	}
+	return "", usage.Errorf("unrecognised %s policy: %s (choose one of: %s)", kind, s, strings.Join(policies, ", "))
+}
+
+// NewCleaner returns a Cleaner for the named alphabet (see alphabet.Alphabets) and policies
+// (see InvalidPolicies and CasePolicies). If uToT is true, U and u are converted to T and t,
+// which only makes sense for nucleotides
+func NewCleaner(alphabetName string, invalidPolicy string, casePolicy string, uToT bool) (*Cleaner, error) {
+	a, err := alphabet.ParseAlphabet(alphabetName)
+	if err != nil {
+		return nil, err
+	}
+	invalid, err := parsePolicy("invalid character", invalidPolicy, InvalidPolicies)
+	if err != nil {
+		return nil, err
+	}
+	casing, err := parsePolicy("case", casePolicy, CasePolicies)
+	if err != nil {
+		return nil, err
+	}
+	if uToT && a != alphabet.Nucleotide {
+		return nil, usage.New("U can only be converted to T in nucleotide sequences")
+	}
+	return &Cleaner{alphabet: a, invalid: invalid, casing: casing, uToT: uToT}, nil
+}
+
+// Clean returns a cleaned copy of seq, and what was changed. Whitespace is removed without
+// being counted. With the fail policy, the error gives the (1-based) position of the first
+// invalid character
+func (c *Cleaner) Clean(seq []byte) ([]byte, Changes, error) {
+
+	var changes Changes
+	cleaned := make([]byte, 0, len(seq))
+
+	pos := 0
+	for _, b := range seq {
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		pos++
+
+		if !c.alphabet.Valid(b) {
+			switch c.invalid {
+			case "fail":
+				return nil, changes, fmt.Errorf("%q at position %d isn't a %s character", b, pos, c.alphabet.Name)
+			case "replace":
+				// the replacement is in the case of the output, if that is lower case
+				if c.casing == "lower" {
+					cleaned = append(cleaned, c.alphabet.Unknown+'a'-'A')
+				} else {
+					cleaned = append(cleaned, c.alphabet.Unknown)
+				}
+			}
+			changes.Invalid++
+			if strings.IndexByte(changes.Characters, b) == -1 {
+				changes.Characters += string(b)
+			}
+			continue
+		}
+
+		if c.uToT && (b == 'U' || b == 'u') {
+			b -= 'U' - 'T'
+			changes.UToT++
+		}
+
+		switch {
+		case c.casing == "upper" && b >= 'a' && b <= 'z':
+			b -= 'a' - 'A'
+			changes.CaseChanged++
+		case c.casing == "lower" && b >= 'A' && b <= 'Z':
+			b += 'a' - 'A'
+			changes.CaseChanged++
+		}
+
+		cleaned = append(cleaned, b)
+	}
+
+	return cleaned, changes, nil
+}
+
+// eachRecord calls fn with the description and the (unmodified) sequence of each record in
+// a fasta file
+func eachRecord(r io.Reader, fn func(description string, seq []byte) error) error {
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+
+	description := ""
+	seq := make([]byte, 0)
+	first := true
+
+	for s.Scan() {
+		line := s.Bytes()
+		switch {
+		case len(line) > 0 && line[0] == '>':
+			if !first {
+				err := fn(description, seq)
+				if err != nil {
+					return err
+				}
+			}
+			first = false
+			description = strings.TrimRight(string(line[1:]), "\r")
+			if len(strings.Fields(description)) == 0 {
+				return errors.New("badly formatted fasta file: a record has no name")
+			}
+			seq = seq[:0]
+		case first && len(strings.TrimSpace(string(line))) > 0:
+			return errors.New("badly formatted fasta file")
+		default:
+			seq = append(seq, line...)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if !first {
+		return fn(description, seq)
+	}
+	return nil
+}
+
+// Clean writes the records in infile (fasta) to outfile with their sequences cleaned by a
+// Cleaner with the given alphabet and policies. If reportFile isn't empty, what was changed
+// in each sequence is written to it as a tab-separated file
+func Clean(infile string, outfile string, reportFile string, alphabetName string, invalidPolicy string, casePolicy string, uToT bool) error {
+
+	c, err := NewCleaner(alphabetName, invalidPolicy, casePolicy, uToT)
+	if err != nil {
+		return err
+	}
+
+	var in io.ReadCloser
+	if infile != "stdin" {
+		in, err = remote.Open(infile)
+		if err != nil {
+			return err
+		}
+	} else {
+		in = os.Stdin
+	}
+	defer in.Close()
+
+	var f *os.File
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+	w := bufio.NewWriter(f)
+
+	var rw *bufio.Writer
+	if len(reportFile) > 0 {
+		rf, err := os.Create(reportFile)
+		if err != nil {
+			return err
+		}
+		defer rf.Close()
+		rw = bufio.NewWriter(rf)
+		_, err = rw.WriteString("query\tlength\tinvalid\tinvalid_characters\tcase_changed\tu_to_t\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	n := 0
+	changed := 0
+
+	err = eachRecord(in, func(description string, seq []byte) error {
+		n++
+		query := strings.Fields(description)[0]
+
+		cleaned, changes, err := c.Clean(seq)
+		if err != nil {
+			return fmt.Errorf("%s: %v", query, err)
+		}
+		if changes.Changed() {
+			changed++
+		}
+
+		_, err = w.WriteString(">" + description + "\n" + string(cleaned) + "\n")
+		if err != nil {
+			return err
+		}
+
+		if rw != nil {
+			characters := changes.Characters
+			if len(characters) == 0 {
+				characters = "-"
+			}
+			_, err = rw.WriteString(query + "\t" + strconv.Itoa(len(cleaned)) + "\t" + strconv.Itoa(changes.Invalid) + "\t" +
+				characters + "\t" + strconv.Itoa(changes.CaseChanged) + "\t" + strconv.Itoa(changes.UToT) + "\n")
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	if rw != nil {
+		err = rw.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	summary.Read(infile, n)
+	summary.Add(summary.Processed, n)
+	summary.Add(changedSequences, changed)
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package clean
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func TestCleaner(t *testing.T) {
+	c, err := NewCleaner("nucleotide", "replace", "upper", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleaned, changes, err := c.Clean([]byte("acgu NRY\tJ-?E"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cleaned) != "ACGTNRYN-?N" {
+		t.Errorf("problem in TestCleaner: got %s", cleaned)
+	}
+	if changes != (Changes{Invalid: 2, Characters: "JE", CaseChanged: 4, UToT: 1}) {
+		t.Errorf("problem in TestCleaner: got %+v", changes)
+	}
+
+	c, err = NewCleaner("protein", "delete", "lower", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleaned, changes, err = c.Clean([]byte("MKV1*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cleaned) != "mkv*" || changes.Invalid != 1 || changes.CaseChanged != 3 {
+		t.Errorf("problem in TestCleaner: got %s, %+v", cleaned, changes)
+	}
+
+	c, err = NewCleaner("nucleotide", "fail", "keep", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = c.Clean([]byte("acgtE"))
+	if err == nil || usage.Is(err) {
+		t.Errorf("problem in TestCleaner: expected a data error for an invalid character, got %v", err)
+	}
+
+	_, err = NewCleaner("protein", "replace", "upper", true)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestCleaner: expected a usage error for U to T in protein sequences, got %v", err)
+	}
+	_, err = NewCleaner("nucleotide", "ignore", "upper", false)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestCleaner: expected a usage error for an unknown policy, got %v", err)
+	}
+}
+
+func TestClean(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	infile := filepath.Join(dir, "in.fasta")
+	err = ioutil.WriteFile(infile, []byte(">s1 one\nACGT\nAC\r\n>s2\nacgu\n>s3\nAC.T\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outfile := filepath.Join(dir, "out.fasta")
+	reportFile := filepath.Join(dir, "report.tsv")
+
+	err = Clean(infile, outfile, reportFile, "nucleotide", "replace", "upper", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != ">s1 one\nACGTAC\n>s2\nACGT\n>s3\nACNT\n" {
+		t.Errorf("problem in TestClean: got %q", out)
+	}
+
+	report, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(report) != "query\tlength\tinvalid\tinvalid_characters\tcase_changed\tu_to_t\n"+
+		"s1\t6\t0\t-\t0\t0\ns2\t4\t0\t-\t4\t1\ns3\t4\t1\t.\t0\t0\n" {
+		t.Errorf("problem in TestClean: got report %q", report)
+	}
+
+	err = Clean(infile, outfile, "", "nucleotide", "fail", "upper", false)
+	if err == nil {
+		t.Error("problem in TestClean: expected an error for an invalid character")
+	}
+}
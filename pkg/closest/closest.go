@@ -11,6 +11,12 @@ import (
 
 	"github.com/cov-ert/gofasta/pkg/fastaio"
 	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/internal/memlimit"
+	"github.com/cov-ert/gofasta/internal/pipeline"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/profiling"
 )
 
 type resultsStruct struct {
@@ -20,25 +26,32 @@ type resultsStruct struct {
 	completeness int64
 	distance float64
 	snps []string
+	rank uint64 // see tieBreaker
 }
 
-func scoreEncodedAlignment(cIn chan fastaio.EncodedFastaRecord, cOut chan fastaio.EncodedFastaRecord) {
-	scoring := encoding.MakeEncodedScoreArray()
+// scoreRecord masks the columns in m in a record and then scores it for completeness
+func scoreRecord(m *mask.Mask, scoring *[256]int64, EFR *fastaio.EncodedFastaRecord) {
+	m.ApplyEncoded(EFR.Seq)
 	var score int64
+	for _, nuc := range(EFR.Seq) {
+		score += scoring[nuc]
+	}
+	EFR.Score = score
+}
+
+// scoreEncodedAlignment masks the columns in m and then scores each record for completeness
+func scoreEncodedAlignment(m *mask.Mask, cIn chan fastaio.EncodedFastaRecord, cOut chan fastaio.EncodedFastaRecord) {
+	scoring := encoding.MakeEncodedScoreArray()
 
 	for EFR := range(cIn) {
-		score = 0
-		for _, nuc := range(EFR.Seq) {
-			score += scoring[nuc]
-		}
-		EFR.Score = score
+		scoreRecord(m, &scoring, &EFR)
 		cOut<- EFR
 	}
 
 	return
 }
 
-func findClosest(query fastaio.EncodedFastaRecord, cIn chan fastaio.EncodedFastaRecord, cOut chan resultsStruct) {
+func findClosest(query fastaio.EncodedFastaRecord, tb tieBreaker, cIn chan fastaio.EncodedFastaRecord, cOut chan resultsStruct) {
 	var closest resultsStruct
 	var distance float64
 	var snps []string
@@ -64,37 +77,18 @@ func findClosest(query fastaio.EncodedFastaRecord, cIn chan fastaio.EncodedFasta
 		}
 		distance = float64(n) / float64(d)
 
-		if first {
-			snps = make([]string, 0)
-			for i, tNuc := range(target.Seq) {
-				if (query.Seq[i] & tNuc) < 16 {
-					snps = append(snps,strconv.Itoa(i + 1) + decoding[query.Seq[i]] + decoding[tNuc])
-				}
-			}
-			closest = resultsStruct{tname: target.ID, completeness: target.Score, distance: distance, snps: snps}
-			first = false
-			continue
-		}
+		rs := resultsStruct{tname: target.ID, completeness: target.Score, distance: distance, rank: tb.rank(target)}
 
-		if distance < closest.distance {
+		if first || closer(rs, closest) {
 			snps = make([]string, 0)
 			for i, tNuc := range(target.Seq) {
 				if (query.Seq[i] & tNuc) < 16 {
 					snps = append(snps,strconv.Itoa(i + 1) + decoding[query.Seq[i]] + decoding[tNuc])
 				}
 			}
-			closest = resultsStruct{tname: target.ID, completeness: target.Score, distance: distance, snps: snps}
-
-		} else if distance == closest.distance {
-			if target.Score > closest.completeness {
-				snps = make([]string, 0)
-				for i, tNuc := range(target.Seq) {
-					if (query.Seq[i] & tNuc) < 16 {
-						snps = append(snps,strconv.Itoa(i + 1) + decoding[query.Seq[i]] + decoding[tNuc])
-					}
-				}
-				closest = resultsStruct{tname: target.ID, completeness: target.Score, distance: distance, snps: snps}
-			}
+			rs.snps = snps
+			closest = rs
+			first = false
 		}
 	}
 
@@ -104,7 +98,7 @@ func findClosest(query fastaio.EncodedFastaRecord, cIn chan fastaio.EncodedFasta
 	cOut<- closest
 }
 
-func splitInput(queries []fastaio.EncodedFastaRecord, cIn chan fastaio.EncodedFastaRecord, cOut chan resultsStruct, cErr chan error, cSplitDone chan bool) {
+func splitInput(queries []fastaio.EncodedFastaRecord, tb tieBreaker, cIn chan fastaio.EncodedFastaRecord, cOut chan resultsStruct, cErr chan error, cSplitDone chan bool) {
 
 	nQ := len(queries)
 
@@ -114,13 +108,20 @@ func splitInput(queries []fastaio.EncodedFastaRecord, cIn chan fastaio.EncodedFa
 	   QChanArray[i] = make(chan fastaio.EncodedFastaRecord)
 	}
 
+	// cOut has room for every result, so the queries are all done once their channels are
+	// closed, and they are waited for before this is
+	var wgQueries sync.WaitGroup
+	wgQueries.Add(nQ)
 	for i, q := range(queries) {
-		go findClosest(q, QChanArray[i], cOut)
+		go func(i int, q fastaio.EncodedFastaRecord) {
+			findClosest(q, tb, QChanArray[i], cOut)
+			wgQueries.Done()
+		}(i, q)
 	}
 
 	targetCounter := 0
 	for EFR := range(cIn) {
-		if targetCounter == 0 {
+		if targetCounter == 0 && nQ > 0 {
 			if len(EFR.Seq) != len(queries[0].Seq) {
 				cErr<- errors.New("query and target alignments are not the same width")
 			}
@@ -137,11 +138,12 @@ func splitInput(queries []fastaio.EncodedFastaRecord, cIn chan fastaio.EncodedFa
 	for i, _ := range(QChanArray) {
 		close(QChanArray[i])
 	}
+	wgQueries.Wait()
 
 	cSplitDone<- true
 }
 
-func writeClosest(results []resultsStruct, filepath string) error {
+func writeClosest(results []resultsStruct, filepath string, md *metadata.Metadata) error {
 
 	var err error
 	f := os.Stdout
@@ -155,19 +157,112 @@ func writeClosest(results []resultsStruct, filepath string) error {
 
 	defer f.Close()
 
-	_, err = f.WriteString("query,closest,SNPdistance,SNPs\n")
+	summary.Add(summary.Processed, len(results))
+
+	_, err = f.WriteString("query,closest,SNPdistance,SNPs" + md.CSVHeader() + "\n")
 	if err != nil {
 		return err
 	}
 
 	for _, result := range results {
-		f.WriteString(result.qname + "," + result.tname + "," + strconv.Itoa(len(result.snps)) + "," + strings.Join(result.snps, ";") + "\n")
+		// if there were no targets, there is no closest one, and no distance to it
+		distance := ""
+		if len(result.tname) > 0 {
+			distance = strconv.Itoa(len(result.snps))
+		}
+		f.WriteString(result.qname + "," + result.tname + "," + distance + "," + strings.Join(result.snps, ";") + md.CSVFields(result.qname) + "\n")
 	}
 
 	return nil
 }
 
-func Closest(queryFile string, targetFile string, outFile string, threads int) error {
+// loadMaskedQueries reads the query alignments in queryFiles, keeping the queries that md keeps,
+// and masks them with the mask in maskFile (if it isn't empty), which is returned so that the
+// targets can be masked in the same way. The queries in all the files are numbered in one list,
+// and bounds[i] is where the i'th file's queries start in it (with its length at the end)
+func loadMaskedQueries(queryFiles []string, maskFile string, md *metadata.Metadata) ([]fastaio.EncodedFastaRecord, []int, *mask.Mask, error) {
+
+	queries := make([]fastaio.EncodedFastaRecord, 0)
+	bounds := make([]int, 0, len(queryFiles) + 1)
+
+	for _, queryFile := range queryFiles {
+		all, err := fastaio.ReadEncodeAlignmentToList(queryFile)
+		if err != nil {
+			return queries, bounds, nil, err
+		}
+
+		bounds = append(bounds, len(queries))
+		kept := 0
+		for _, q := range all {
+			if md.Keep(q.ID) {
+				if len(queries) > 0 && len(q.Seq) != len(queries[0].Seq) {
+					return queries, bounds, nil, errors.New("the query alignments are not all the same width")
+				}
+				q.Idx = len(queries)
+				queries = append(queries, q)
+				kept++
+			}
+		}
+		summary.Add(summary.Filtered, len(all) - kept)
+	}
+	bounds = append(bounds, len(queries))
+
+	length := -1
+	if len(queries) > 0 {
+		length = len(queries[0].Seq)
+	}
+
+	m, err := mask.Load(maskFile, length)
+	if err != nil {
+		return queries, bounds, nil, err
+	}
+
+	for _, q := range(queries) {
+		m.ApplyEncoded(q.Seq)
+	}
+
+	return queries, bounds, m, nil
+}
+
+// readTargets returns a function that reads the target alignment to cTEFR, for the caller to
+// run. If useMmap is true, the targets were written by gofasta encode, or they are too big for
+// the --max-mem budget, they are mapped into memory instead of being read onto the heap, and the
+// second function returned unmaps them. It mustn't be called until every target has been compared
+// with every query
+func readTargets(targetFile string, useMmap bool, cTEFR chan fastaio.EncodedFastaRecord, cErr chan error, cTEFRdone chan bool) (func(), func() error, error) {
+
+	if !useMmap && !fastaio.IsEncoded(targetFile) && !memlimit.SpillFile(targetFile) {
+		read := func() { fastaio.ReadEncodeAlignment(targetFile, cTEFR, cErr, cTEFRdone) }
+		return read, func() error { return nil }, nil
+	}
+
+	ma, err := fastaio.OpenMapped(targetFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	read := func() { ma.ReadEncode(cTEFR, cErr, cTEFRdone) }
+
+	return read, ma.Close, nil
+}
+
+// Closest finds the closest target to each query, ignoring the columns in maskFile (if
+// it isn't empty). Queries are kept and annotated according to md. If useMmap is true, the
+// targets are mapped into memory (see readTargets). If sketchFile (written by gofasta sketch from
+// the targets, which were written by gofasta encode) isn't empty, each query is only compared with
+// the nCandidates targets whose sketches are most similar to its own. Targets that are the same
+// distance from a query and equally complete are ranked by their order in the target alignment, or
+// if seed isn't negative, by a shuffle that only depends on seed (see tieBreaker)
+func Closest(queryFile string, targetFile string, outFile string, maskFile string, md *metadata.Metadata, useMmap bool, sketchFile string, nCandidates int, seed int64, threads int) error {
+	return ClosestBatch([]string{queryFile}, []string{outFile}, targetFile, maskFile, md, useMmap, sketchFile, nCandidates, seed, threads)
+}
+
+// ClosestBatch is Closest for a batch of query files, whose results are written to the matching
+// outFiles. The queries in all of them are compared with the targets together, so the targets
+// (and their sketches) are only read once for the whole batch
+func ClosestBatch(queryFiles []string, outFiles []string, targetFile string, maskFile string, md *metadata.Metadata, useMmap bool, sketchFile string, nCandidates int, seed int64, threads int) error {
+
+	defer profiling.Region("closest")()
 
 	if threads == 0 {
 		threads = runtime.NumCPU()
@@ -175,73 +270,107 @@ func Closest(queryFile string, targetFile string, outFile string, threads int) e
 		runtime.GOMAXPROCS(threads)
 	}
 
-	queries, err := fastaio.ReadEncodeAlignmentToList(queryFile)
+	queries, bounds, m, err := loadMaskedQueries(queryFiles, maskFile, md)
 	if err != nil {
 		return err
 	}
 
+	tb := tieBreaker{seed: seed}
+	if seed >= 0 {
+		summary.Seed(seed)
+	}
+
 	nQ := len(queries)
 
 	fmt.Fprintf(os.Stderr, "number of sequences in query alignment: %d\n", nQ)
 
 	QResultsArray := make([]resultsStruct, nQ)
 
-	cErr := make(chan error)
+	if len(sketchFile) > 0 {
+		cResults := make(chan resultsStruct, nQ)
+		err = searchCandidates(queries, targetFile, sketchFile, nCandidates, m, threads, func(q fastaio.EncodedFastaRecord, cIn chan fastaio.EncodedFastaRecord) {
+			findClosest(q, tb, cIn, cResults)
+		})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < nQ; i++ {
+			result := <-cResults
+			QResultsArray[result.qidx] = result
+		}
+	} else {
+		err = searchAll(queries, m, targetFile, useMmap, tb, threads, QResultsArray)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, outFile := range outFiles {
+		err = writeClosest(QResultsArray[bounds[i]:bounds[i+1]], outFile, md)
+		if err != nil {
+			return err
+		}
+	}
+
+	md.Report()
 
-	cTEFR := make(chan fastaio.EncodedFastaRecord, runtime.NumCPU())
-	cTEFRscored := make(chan fastaio.EncodedFastaRecord, runtime.NumCPU())
-	cTEFRdone := make(chan bool)
-	cTEFRscoreddone := make(chan bool)
-	cSplitDone := make(chan bool)
+	return nil
+}
+
+// searchAll compares every query with every target in targetFile, masked with m, and puts the
+// results in QResultsArray. If useMmap is true, the targets are mapped into memory (see readTargets)
+func searchAll(queries []fastaio.EncodedFastaRecord, m *mask.Mask, targetFile string, useMmap bool, tb tieBreaker, threads int, QResultsArray []resultsStruct) error {
 
-	cResults := make(chan resultsStruct)
+	nQ := len(queries)
+
+	// every stage is waited for before this returns, even if it fails, so that the targets
+	// can be unmapped either way
+	pl := pipeline.New()
 
-	go fastaio.ReadEncodeAlignment(targetFile, cTEFR, cErr, cTEFRdone)
+	// each target is as wide as the queries
+	buffer := threads
+	if nQ > 0 {
+		buffer = memlimit.Buffer(threads, len(queries[0].Seq))
+	}
+	cTEFR := make(chan fastaio.EncodedFastaRecord, buffer)
+	cTEFRscored := make(chan fastaio.EncodedFastaRecord, buffer)
+	cTEFRdone := make(chan bool, 1)
+	cSplitDone := make(chan bool, 1)
+
+	cResults := make(chan resultsStruct, nQ)
+
+	readTargetsIn, unmapTargets, err := readTargets(targetFile, useMmap, cTEFR, pl.Errs, cTEFRdone)
+	if err != nil {
+		return err
+	}
+
+	// the targets are closed once the reader has returned, whether it got to the end or not
+	pl.Run(func() {
+		readTargetsIn()
+		close(cTEFR)
+	})
 
 	var wgScore sync.WaitGroup
 	wgScore.Add(threads)
 
 	for n := 0; n < threads; n++ {
-		go func() {
-			scoreEncodedAlignment(cTEFR, cTEFRscored)
+		pl.Run(func() {
+			scoreEncodedAlignment(m, cTEFR, cTEFRscored)
 			wgScore.Done()
-		}()
+		})
 	}
 
-	go splitInput(queries, cTEFRscored, cResults, cErr, cSplitDone)
+	pl.Run(func() { splitInput(queries, tb, cTEFRscored, cResults, pl.Errs, cSplitDone) })
 
-	go func() {
+	pl.Run(func() {
 		wgScore.Wait()
-		cTEFRscoreddone<- true
-	}()
+		close(cTEFRscored)
+	})
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cTEFRdone:
-			close(cTEFR)
-			n--
-		}
-	}
-
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cTEFRscoreddone:
-			close(cTEFRscored)
-			n--
-		}
-	}
-
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cSplitDone:
-			n--
-		}
+	err = pl.Wait()
+	if err != nil {
+		unmapTargets()
+		return err
 	}
 
 	for i := 0; i < nQ; i++ {
@@ -249,10 +378,5 @@ func Closest(queryFile string, targetFile string, outFile string, threads int) e
 		QResultsArray[result.qidx] = result
 	}
 
-	err = writeClosest(QResultsArray, outFile)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return unmapTargets()
 }
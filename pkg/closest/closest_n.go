@@ -10,6 +10,11 @@ import (
 	"strings"
 
 	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/internal/memlimit"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/profiling"
 )
 
 // this is defined elsewhere, but for reference:
@@ -20,26 +25,25 @@ import (
 // 	completeness int64
 // 	distance float64
 // 	snps []string
+// 	rank uint64
 // }
 
 type catchmentStruct struct {
 	qname string
 	qidx int
 	catchment []resultsStruct
-	furthestDistance float64 // this is distance for the least close of the current set of neighbours in catchment
-	furthestCompleteness int64 // this is completeness for the least close of the current set of neighbours in catchment
+	furthest resultsStruct // the least close of the current set of neighbours in catchment
 }
 
 func rearrangeCatchment(nS *catchmentStruct, catchmentSize int) {
 	sort.SliceStable(nS.catchment, func(i, j int) bool {
-		return nS.catchment[i].distance < nS.catchment[j].distance || (nS.catchment[i].distance == nS.catchment[j].distance && nS.catchment[i].completeness > nS.catchment[j].completeness )
+		return closer(nS.catchment[i], nS.catchment[j])
 	})
 	nS.catchment = nS.catchment[0:catchmentSize]
-	nS.furthestDistance = nS.catchment[catchmentSize - 1].distance
-	nS.furthestCompleteness = nS.catchment[catchmentSize - 1].completeness
+	nS.furthest = nS.catchment[catchmentSize - 1]
 }
 
-func findClosestN(query fastaio.EncodedFastaRecord, catchmentSize int, cIn chan fastaio.EncodedFastaRecord, cOut chan catchmentStruct) {
+func findClosestN(query fastaio.EncodedFastaRecord, catchmentSize int, tb tieBreaker, cIn chan fastaio.EncodedFastaRecord, cOut chan catchmentStruct) {
 
 	neighbours := catchmentStruct{qname: query.ID, qidx: query.Idx}
 	neighbours.catchment = make([]resultsStruct, 0)
@@ -64,21 +68,16 @@ func findClosestN(query fastaio.EncodedFastaRecord, catchmentSize int, cIn chan
 		}
 		distance = float64(n) / float64(d)
 
+		rs = resultsStruct{tname: target.ID, completeness: target.Score, distance: distance, rank: tb.rank(target)}
+
 		if len(neighbours.catchment) < catchmentSize {
-			rs = resultsStruct{tname: target.ID, completeness: target.Score, distance: distance}
 			neighbours.catchment = append(neighbours.catchment, rs)
 
 			if len(neighbours.catchment) == catchmentSize {
 				rearrangeCatchment(&neighbours, catchmentSize)
 			}
 
-		} else if distance < neighbours.furthestDistance {
-			rs = resultsStruct{tname: target.ID, completeness: target.Score, distance: distance}
-			neighbours.catchment = append(neighbours.catchment, rs)
-			rearrangeCatchment(&neighbours, catchmentSize)
-
-		} else if distance == neighbours.furthestDistance && target.Score > neighbours.furthestCompleteness {
-			rs = resultsStruct{tname: target.ID, completeness: target.Score, distance: distance}
+		} else if closer(rs, neighbours.furthest) {
 			neighbours.catchment = append(neighbours.catchment, rs)
 			rearrangeCatchment(&neighbours, catchmentSize)
 		}
@@ -86,15 +85,15 @@ func findClosestN(query fastaio.EncodedFastaRecord, catchmentSize int, cIn chan
 
 	// If the user specified a larger catchment than there are records in the target file,
 	// they won't be sorted above, so do it here (need to modify the size argument passed
-	// to the function):
-	if len(neighbours.catchment) < catchmentSize {
+	// to the function). If there were no targets at all, there is nothing to sort:
+	if len(neighbours.catchment) > 0 && len(neighbours.catchment) < catchmentSize {
 		rearrangeCatchment(&neighbours, len(neighbours.catchment))
 	}
 
 	cOut<- neighbours
 }
 
-func splitInputN(queries []fastaio.EncodedFastaRecord, catchmentSize int, cIn chan fastaio.EncodedFastaRecord, cOut chan catchmentStruct, cErr chan error, cSplitDone chan bool) {
+func splitInputN(queries []fastaio.EncodedFastaRecord, catchmentSize int, tb tieBreaker, cIn chan fastaio.EncodedFastaRecord, cOut chan catchmentStruct, cErr chan error, cSplitDone chan bool) {
 
 	nQ := len(queries)
 
@@ -105,12 +104,12 @@ func splitInputN(queries []fastaio.EncodedFastaRecord, catchmentSize int, cIn ch
 	}
 
 	for i, q := range(queries) {
-		go findClosestN(q, catchmentSize, QChanArray[i], cOut)
+		go findClosestN(q, catchmentSize, tb, QChanArray[i], cOut)
 	}
 
 	targetCounter := 0
 	for EFR := range(cIn) {
-		if targetCounter == 0 {
+		if targetCounter == 0 && nQ > 0 {
 			if len(EFR.Seq) != len(queries[0].Seq) {
 				cErr<- errors.New("query and target alignments are not the same width")
 			}
@@ -131,7 +130,7 @@ func splitInputN(queries []fastaio.EncodedFastaRecord, catchmentSize int, cIn ch
 	cSplitDone<- true
 }
 
-func writeClosestN(results []catchmentStruct, filepath string) error {
+func writeClosestN(results []catchmentStruct, filepath string, md *metadata.Metadata) error {
 
 	var err error
 	f := os.Stdout
@@ -145,7 +144,9 @@ func writeClosestN(results []catchmentStruct, filepath string) error {
 
 	defer f.Close()
 
-	_, err = f.WriteString("query,closest\n")
+	summary.Add(summary.Processed, len(results))
+
+	_, err = f.WriteString("query,closest" + md.CSVHeader() + "\n")
 	if err != nil {
 		return err
 	}
@@ -155,13 +156,26 @@ func writeClosestN(results []catchmentStruct, filepath string) error {
 		for _, hit := range(result.catchment) {
 			temp = append(temp, hit.tname)
 		}
-		f.WriteString(result.qname + "," + strings.Join(temp, ";") + "\n")
+		f.WriteString(result.qname + "," + strings.Join(temp, ";") + md.CSVFields(result.qname) + "\n")
 	}
 
 	return nil
 }
 
-func ClosestN(catchmentSize int, queryFile string, targetFile string, outFile string, threads int) error {
+// ClosestN finds the catchmentSize closest targets to each query, ignoring the columns in
+// maskFile (if it isn't empty). Queries are kept and annotated according to md. If useMmap
+// is true, the targets are mapped into memory (see readTargets). If sketchFile isn't empty, each
+// query is only compared with the nCandidates targets whose sketches are most similar, and ties are
+// broken according to seed (see Closest)
+func ClosestN(catchmentSize int, queryFile string, targetFile string, outFile string, maskFile string, md *metadata.Metadata, useMmap bool, sketchFile string, nCandidates int, seed int64, threads int) error {
+	return ClosestNBatch(catchmentSize, []string{queryFile}, []string{outFile}, targetFile, maskFile, md, useMmap, sketchFile, nCandidates, seed, threads)
+}
+
+// ClosestNBatch is ClosestN for a batch of query files, whose results are written to the
+// matching outFiles, reading the targets only once (see ClosestBatch)
+func ClosestNBatch(catchmentSize int, queryFiles []string, outFiles []string, targetFile string, maskFile string, md *metadata.Metadata, useMmap bool, sketchFile string, nCandidates int, seed int64, threads int) error {
+
+	defer profiling.Region("closest")()
 
 	if threads == 0 {
 		threads = runtime.NumCPU()
@@ -169,40 +183,91 @@ func ClosestN(catchmentSize int, queryFile string, targetFile string, outFile st
 		runtime.GOMAXPROCS(threads)
 	}
 
-	queries, err := fastaio.ReadEncodeAlignmentToList(queryFile)
+	queries, bounds, m, err := loadMaskedQueries(queryFiles, maskFile, md)
 	if err != nil {
 		return err
 	}
 
+	tb := tieBreaker{seed: seed}
+	if seed >= 0 {
+		summary.Seed(seed)
+	}
+
 	nQ := len(queries)
 
 	fmt.Fprintf(os.Stderr, "number of sequences in query alignment: %d\n", nQ)
 
 	QResultsArray := make([]catchmentStruct, nQ)
 
+	if len(sketchFile) > 0 {
+		cResults := make(chan catchmentStruct, nQ)
+		err = searchCandidates(queries, targetFile, sketchFile, nCandidates, m, threads, func(q fastaio.EncodedFastaRecord, cIn chan fastaio.EncodedFastaRecord) {
+			findClosestN(q, catchmentSize, tb, cIn, cResults)
+		})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < nQ; i++ {
+			result := <-cResults
+			QResultsArray[result.qidx] = result
+		}
+	} else {
+		err = searchAllN(catchmentSize, queries, m, targetFile, useMmap, tb, threads, QResultsArray)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, outFile := range outFiles {
+		err = writeClosestN(QResultsArray[bounds[i]:bounds[i+1]], outFile, md)
+		if err != nil {
+			return err
+		}
+	}
+
+	md.Report()
+
+	return nil
+}
+
+// searchAllN finds the catchmentSize closest targets in targetFile to every query, masked with m,
+// and puts them in QResultsArray. If useMmap is true, the targets are mapped into memory (see readTargets)
+func searchAllN(catchmentSize int, queries []fastaio.EncodedFastaRecord, m *mask.Mask, targetFile string, useMmap bool, tb tieBreaker, threads int, QResultsArray []catchmentStruct) error {
+
+	nQ := len(queries)
+
 	cErr := make(chan error)
 
-	cTEFR := make(chan fastaio.EncodedFastaRecord, runtime.NumCPU())
-	cTEFRscored := make(chan fastaio.EncodedFastaRecord, runtime.NumCPU())
+	// each target is as wide as the queries
+	buffer := threads
+	if nQ > 0 {
+		buffer = memlimit.Buffer(threads, len(queries[0].Seq))
+	}
+	cTEFR := make(chan fastaio.EncodedFastaRecord, buffer)
+	cTEFRscored := make(chan fastaio.EncodedFastaRecord, buffer)
 	cTEFRdone := make(chan bool)
 	cTEFRscoreddone := make(chan bool)
 	cSplitDone := make(chan bool)
 
 	cResults := make(chan catchmentStruct)
 
-	go fastaio.ReadEncodeAlignment(targetFile, cTEFR, cErr, cTEFRdone)
+	readTargetsIn, unmapTargets, err := readTargets(targetFile, useMmap, cTEFR, cErr, cTEFRdone)
+	if err != nil {
+		return err
+	}
+	go readTargetsIn()
 
 	var wgScore sync.WaitGroup
 	wgScore.Add(threads)
 
 	for n := 0; n < threads; n++ {
 		go func() {
-			scoreEncodedAlignment(cTEFR, cTEFRscored)
+			scoreEncodedAlignment(m, cTEFR, cTEFRscored)
 			wgScore.Done()
 		}()
 	}
 
-	go splitInputN(queries, catchmentSize, cTEFRscored, cResults, cErr, cSplitDone)
+	go splitInputN(queries, catchmentSize, tb, cTEFRscored, cResults, cErr, cSplitDone)
 
 	go func() {
 		wgScore.Wait()
@@ -243,10 +308,5 @@ func ClosestN(catchmentSize int, queryFile string, targetFile string, outFile st
 		QResultsArray[result.qidx] = result
 	}
 
-	err = writeClosestN(QResultsArray, outFile)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return unmapTargets()
 }
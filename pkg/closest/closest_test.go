@@ -0,0 +1,415 @@
+package closest
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/sketch"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// writeBenchmarkAlignments writes query and target alignments of synthetic genomes
+// to a temporary directory and returns their paths
+func writeBenchmarkAlignments(b *testing.B, nQ int, nT int) (string, string) {
+
+	dir := b.TempDir()
+	r := rand.New(rand.NewSource(1))
+
+	ref := testutil.RandomSeq(r, 30000)
+
+	write := func(name string, n int) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer f.Close()
+		err = testutil.WriteFasta(f, testutil.RandomAlignment(r, ref, n, 0.001))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return path
+	}
+
+	return write("query.fasta", nQ), write("target.fasta", nT)
+}
+
+func BenchmarkClosest(b *testing.B) {
+	queryFile, targetFile := writeBenchmarkAlignments(b, 20, 1000)
+	outFile := filepath.Join(b.TempDir(), "closest.csv")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := Closest(queryFile, targetFile, outFile, "", nil, false, "", 0, -1, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkClosestN(b *testing.B) {
+	queryFile, targetFile := writeBenchmarkAlignments(b, 20, 1000)
+	outFile := filepath.Join(b.TempDir(), "closest.csv")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := ClosestN(10, queryFile, targetFile, outFile, "", nil, false, "", 0, -1, 0)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestClosestBatch(t *testing.T) {
+	dir := t.TempDir()
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 500)
+
+	write := func(name string, n int) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		err = testutil.WriteFasta(f, testutil.RandomAlignment(r, ref, n, 0.01))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	queryFiles := []string{write("run1.fasta", 3), write("run2.fasta", 4)}
+	targetFile := write("target.fasta", 50)
+
+	read := func(path string) string {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	for _, n := range []int{0, 5} {
+		batchOut := []string{filepath.Join(dir, "run1.batch.csv"), filepath.Join(dir, "run2.batch.csv")}
+		var err error
+		if n > 0 {
+			err = ClosestNBatch(n, queryFiles, batchOut, targetFile, "", nil, false, "", 0, -1, 2)
+		} else {
+			err = ClosestBatch(queryFiles, batchOut, targetFile, "", nil, false, "", 0, -1, 2)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// each file's output is the same as if it had been searched on its own
+		for i, queryFile := range queryFiles {
+			out := filepath.Join(dir, "single.csv")
+			if n > 0 {
+				err = ClosestN(n, queryFile, targetFile, out, "", nil, false, "", 0, -1, 2)
+			} else {
+				err = Closest(queryFile, targetFile, out, "", nil, false, "", 0, -1, 2)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, expected := read(batchOut[i]), read(out); got != expected {
+				t.Errorf("problem in TestClosestBatch (n = %d): %s is\n%s\nexpected\n%s", n, batchOut[i], got, expected)
+			}
+		}
+	}
+}
+
+func TestClosestMmap(t *testing.T) {
+	dir := t.TempDir()
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 500)
+
+	write := func(name string, n int) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		err = testutil.WriteFasta(f, testutil.RandomAlignment(r, ref, n, 0.01))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	queryFile, targetFile := write("query.fasta", 5), write("target.fasta", 50)
+
+	maskFile := filepath.Join(dir, "mask.txt")
+	err := ioutil.WriteFile(maskFile, []byte("10-60\n200\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedFile := filepath.Join(dir, "target.gfe")
+	err = fastaio.WriteEncoded(targetFile, encodedFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packedFile := filepath.Join(dir, "target.packed.gfe")
+	err = fastaio.WriteEncoded(targetFile, packedFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(name string, target string, useMmap bool, n int) string {
+		out := filepath.Join(dir, name)
+		if n > 0 {
+			err = ClosestN(n, queryFile, target, out, maskFile, nil, useMmap, "", 0, -1, 2)
+		} else {
+			err = Closest(queryFile, target, out, maskFile, nil, useMmap, "", 0, -1, 2)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	for _, n := range []int{0, 5} {
+		expected := run("heap.csv", targetFile, false, n)
+		if got := run("mmap.csv", targetFile, true, n); got != expected {
+			t.Errorf("problem in TestClosestMmap: n=%d: --mmap gave\n%s\nexpected\n%s", n, got, expected)
+		}
+		if got := run("encoded.csv", encodedFile, false, n); got != expected {
+			t.Errorf("problem in TestClosestMmap: n=%d: the encoded targets gave\n%s\nexpected\n%s", n, got, expected)
+		}
+		if got := run("packed.csv", packedFile, false, n); got != expected {
+			t.Errorf("problem in TestClosestMmap: n=%d: the packed targets gave\n%s\nexpected\n%s", n, got, expected)
+		}
+	}
+}
+
+func TestClosestSketch(t *testing.T) {
+	dir := t.TempDir()
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 500)
+
+	write := func(name string, n int) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		err = testutil.WriteFasta(f, testutil.RandomAlignment(r, ref, n, 0.01))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	queryFile, targetFile := write("query.fasta", 5), write("target.fasta", 50)
+
+	encodedFile := filepath.Join(dir, "target.gfe")
+	err := fastaio.WriteEncoded(targetFile, encodedFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sketchFile := filepath.Join(dir, "target.gfs")
+	err = sketch.Write(encodedFile, sketchFile, 11, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(name string, target string, sketchFile string, candidates int, n int) (string, error) {
+		out := filepath.Join(dir, name)
+		if n > 0 {
+			err = ClosestN(n, queryFile, target, out, "", nil, false, sketchFile, candidates, -1, 2)
+		} else {
+			err = Closest(queryFile, target, out, "", nil, false, sketchFile, candidates, -1, 2)
+		}
+		if err != nil {
+			return "", err
+		}
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b), nil
+	}
+
+	// with every target as a candidate, the results are the same as without a sketch
+	for _, n := range []int{0, 5} {
+		expected, err := run("all.csv", targetFile, "", 0, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := run("sketch.csv", encodedFile, sketchFile, 50, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("problem in TestClosestSketch: n=%d: --sketch gave\n%s\nexpected\n%s", n, got, expected)
+		}
+	}
+
+	// removed targets are skipped, with or without a sketch
+	before, err := run("before.csv", encodedFile, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namesFile := filepath.Join(dir, "names.txt")
+	closestToFirst := strings.Split(strings.Split(before, "\n")[1], ",")[1]
+	err = ioutil.WriteFile(namesFile, []byte(closestToFirst+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fastaio.RemoveEncoded(encodedFile, namesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := run("removed.csv", encodedFile, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected == before {
+		t.Errorf("problem in TestClosestSketch: removing the closest target didn't change the output")
+	}
+	got, err := run("sketch.csv", encodedFile, sketchFile, 50, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != expected {
+		t.Errorf("problem in TestClosestSketch: with removed targets, --sketch gave\n%s\nexpected\n%s", got, expected)
+	}
+
+	// the candidates have to be read from an encoded file that has the same number of records
+	_, err = run("sketch.csv", targetFile, sketchFile, 10, 0)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestClosestSketch: expected a usage error for a fasta target, got %v", err)
+	}
+	otherFile := filepath.Join(dir, "other.gfe")
+	err = fastaio.WriteEncoded(queryFile, otherFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = run("sketch.csv", otherFile, sketchFile, 10, 0)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestClosestSketch: expected a usage error for sketches of another alignment, got %v", err)
+	}
+}
+
+func TestClosestEmpty(t *testing.T) {
+	dir := t.TempDir()
+
+	emptyFile := filepath.Join(dir, "empty.fasta")
+	err := ioutil.WriteFile(emptyFile, []byte{}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fastaFile := filepath.Join(dir, "aln.fasta")
+	err = ioutil.WriteFile(fastaFile, []byte(">s1\nACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no queries give no results, and a query with no targets has no closest one
+	tests := []struct {
+		query, target string
+		expected      []string
+	}{
+		{emptyFile, fastaFile, []string{"query,closest,SNPdistance,SNPs\n", "query,closest\n"}},
+		{fastaFile, emptyFile, []string{"query,closest,SNPdistance,SNPs\ns1,,,\n", "query,closest\ns1,\n"}},
+	}
+
+	out := filepath.Join(dir, "closest.csv")
+	for _, test := range tests {
+		for i, n := range []int{0, 2} {
+			if n > 0 {
+				err = ClosestN(n, test.query, test.target, out, "", nil, false, "", 0, -1, 2)
+			} else {
+				err = Closest(test.query, test.target, out, "", nil, false, "", 0, -1, 2)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.expected[i] {
+				t.Errorf("problem in TestClosestEmpty (n = %d): got\n%s\nexpected\n%s", n, got, test.expected[i])
+			}
+		}
+	}
+}
+
+func TestClosestTies(t *testing.T) {
+	dir := t.TempDir()
+
+	queryFile := filepath.Join(dir, "query.fasta")
+	targetFile := filepath.Join(dir, "target.fasta")
+
+	// every target is as close to the query, and as complete, as every other
+	targets := ""
+	for i := 1; i <= 20; i++ {
+		targets += ">t" + strconv.Itoa(i) + "\nACGTACGT\n"
+	}
+	err := ioutil.WriteFile(queryFile, []byte(">q\nACGTACGA\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(targetFile, []byte(targets), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(n int, seed int64, threads int) string {
+		out := filepath.Join(dir, "out.csv")
+		if n > 0 {
+			err = ClosestN(n, queryFile, targetFile, out, "", nil, false, "", 0, seed, threads)
+		} else {
+			err = Closest(queryFile, targetFile, out, "", nil, false, "", 0, seed, threads)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(string(got), "\n")[1]
+	}
+
+	// with no seed, the first targets win
+	for _, threads := range []int{1, 4} {
+		if got := run(0, -1, threads); got != "q,t1,1,8AT" {
+			t.Errorf("problem in TestClosestTies: got %s, expected t1", got)
+		}
+		if got := run(3, -1, threads); got != "q,t1;t2;t3" {
+			t.Errorf("problem in TestClosestTies: got %s, expected t1;t2;t3", got)
+		}
+	}
+
+	// with a seed, the winners are the same on every run, and the closest is the first of the
+	// n closest
+	closest := run(0, 42, 1)
+	catchment := run(3, 42, 1)
+	for i := 0; i < 5; i++ {
+		if got := run(0, 42, 4); got != closest {
+			t.Errorf("problem in TestClosestTies: got %s, then %s, with the same seed", closest, got)
+		}
+		if got := run(3, 42, 4); got != catchment {
+			t.Errorf("problem in TestClosestTies: got %s, then %s, with the same seed", catchment, got)
+		}
+	}
+	name := strings.Split(closest, ",")[1]
+	if !strings.HasPrefix(strings.Split(catchment, ",")[1], name+";") {
+		t.Errorf("problem in TestClosestTies: the closest target (%s) isn't the first of the closest 3 (%s)", closest, catchment)
+	}
+	if run(3, 42, 1) == run(3, 7, 1) && run(3, 7, 1) == run(3, 1, 1) {
+		t.Errorf("problem in TestClosestTies: the ties were broken in the same way with three different seeds")
+	}
+}
@@ -0,0 +1,236 @@
+package closest
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/sketch"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// candidate is a target that might be close to a query, going by the similarity of their sketches
+type candidate struct {
+	idx        int
+	similarity float64
+}
+
+// candidateHeap holds the most similar candidates for a query so far, with the least similar
+// one (or the later one in the target alignment, for a tie) on top, so that it is the first to go
+type candidateHeap []candidate
+
+func (h candidateHeap) Len() int { return len(h) }
+func (h candidateHeap) Less(i, j int) bool {
+	return h[i].similarity < h[j].similarity || (h[i].similarity == h[j].similarity && h[i].idx > h[j].idx)
+}
+func (h candidateHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *candidateHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *candidateHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// offer adds c to the heap if it has fewer than n candidates, or if c is more similar than
+// the least similar of them (which it replaces)
+func (h *candidateHeap) offer(c candidate, n int) {
+	if h.Len() < n {
+		heap.Push(h, c)
+		return
+	}
+	top := (*h)[0]
+	if top.similarity < c.similarity || (top.similarity == c.similarity && top.idx > c.idx) {
+		(*h)[0] = c
+		heap.Fix(h, 0)
+	}
+}
+
+// sketchCandidates picks the nCandidates targets whose sketches (in sketchFile, written by
+// gofasta sketch) are most similar to each query's, and returns their indexes in the target
+// alignment (in the order they are in it) and the number of targets that were sketched. Targets
+// that skip is true for (e.g. removed ones) aren't candidates. The target sketches are shared out
+// between threads workers, which each compare theirs with every query
+func sketchCandidates(queries []fastaio.EncodedFastaRecord, sketchFile string, nCandidates int, skip func(int) bool, threads int) ([][]int, int, error) {
+
+	idx, err := sketch.OpenIndex(sketchFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer idx.Close()
+
+	// there is no width if no targets were sketched
+	if len(queries) > 0 && idx.N > 0 && idx.Width != len(queries[0].Seq) {
+		return nil, 0, errors.New("query alignment and sketched target alignment are not the same width")
+	}
+
+	qSketches := make([]sketch.Sketch, len(queries))
+	for i, q := range queries {
+		qSketches[i] = sketch.New(q.Seq, idx.K, idx.Size)
+	}
+
+	type sketched struct {
+		idx int
+		s   sketch.Sketch
+	}
+
+	cIn := make(chan sketched, threads)
+	heaps := make([][]candidateHeap, threads)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+
+	for n := 0; n < threads; n++ {
+		heaps[n] = make([]candidateHeap, len(queries))
+		go func(hs []candidateHeap) {
+			for t := range cIn {
+				for i, qs := range qSketches {
+					hs[i].offer(candidate{idx: t.idx, similarity: sketch.Similarity(qs, t.s, idx.Size)}, nCandidates)
+				}
+			}
+			wg.Done()
+		}(heaps[n])
+	}
+
+	err = idx.Each(func(i int, s sketch.Sketch) error {
+		if skip(i) {
+			return nil
+		}
+		cIn <- sketched{idx: i, s: append(sketch.Sketch{}, s...)}
+		return nil
+	})
+	close(cIn)
+	wg.Wait()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	candidates := make([][]int, len(queries))
+	for i := range queries {
+		var h candidateHeap
+		for n := range heaps {
+			for _, c := range heaps[n][i] {
+				h.offer(c, nCandidates)
+			}
+		}
+		candidates[i] = make([]int, len(h))
+		for j, c := range h {
+			candidates[i][j] = c.idx
+		}
+		sort.Ints(candidates[i])
+	}
+
+	return candidates, idx.N, nil
+}
+
+// searchCandidates compares each query with only its candidate targets (see sketchCandidates),
+// which are read by index from targetFile (written by gofasta encode), masked with m and scored,
+// then sent to compare, in the same order as they are in the target alignment. Queries are
+// shared out between threads workers, and every call to compare has returned when it returns
+func searchCandidates(queries []fastaio.EncodedFastaRecord, targetFile string, sketchFile string, nCandidates int, m *mask.Mask, threads int, compare func(fastaio.EncodedFastaRecord, chan fastaio.EncodedFastaRecord)) error {
+
+	if nCandidates < 1 {
+		return usage.Errorf("--candidates must be at least 1, not %d", nCandidates)
+	}
+	if !fastaio.IsEncoded(targetFile) {
+		return usage.New("--sketch needs a --target that was written by gofasta encode, so that the candidates can be read from it by index")
+	}
+
+	ma, err := fastaio.OpenMapped(targetFile)
+	if err != nil {
+		return err
+	}
+	defer ma.Close()
+
+	nT, err := ma.Len()
+	if err != nil {
+		return err
+	}
+
+	candidates, nSketched, err := sketchCandidates(queries, sketchFile, nCandidates, ma.Removed, threads)
+	if err != nil {
+		return err
+	}
+	if nSketched != nT {
+		return usage.Errorf("%s has the sketches of %d sequences, but %s has %d: were they made from different alignments?", sketchFile, nSketched, targetFile, nT)
+	}
+
+	nRemoved := 0
+	for i := 0; i < nT; i++ {
+		if ma.Removed(i) {
+			nRemoved++
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "number of sequences in target alignment: %d\n", nT-nRemoved)
+
+	scoring := encoding.MakeEncodedScoreArray()
+
+	cQ := make(chan int)
+	cErr := make(chan error, threads)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+
+	for n := 0; n < threads; n++ {
+		go func() {
+			defer wg.Done()
+			for qi := range cQ {
+				targets := make([]fastaio.EncodedFastaRecord, len(candidates[qi]))
+				for j, ti := range candidates[qi] {
+					EFR, err := ma.Record(ti)
+					if err != nil {
+						cErr <- err
+						return
+					}
+					if len(EFR.Seq) != len(queries[qi].Seq) {
+						cErr <- errors.New("query and target alignments are not the same width")
+						return
+					}
+					scoreRecord(m, &scoring, &EFR)
+					targets[j] = EFR
+				}
+
+				cIn := make(chan fastaio.EncodedFastaRecord)
+				go func() {
+					for _, EFR := range targets {
+						cIn <- EFR
+					}
+					close(cIn)
+				}()
+				compare(queries[qi], cIn)
+			}
+		}()
+	}
+
+	// the workers have to finish with the mapping before it is closed, even after an error
+feed:
+	for qi := range queries {
+		select {
+		case err = <-cErr:
+			break feed
+		case cQ <- qi:
+		}
+	}
+	close(cQ)
+
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err = <-cErr:
+		return err
+	default:
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package closest
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+)
+
+// tieBreaker orders targets that are the same distance from a query and equally complete, so that
+// the closest targets don't depend on the order that the workers happen to compare them in. With no
+// seed (seed < 0), the target that is first in the target alignment wins. With a seed, the order is
+// a pseudo-random shuffle of the targets' names that only depends on the seed, so it is the same on
+// every run and machine
+type tieBreaker struct {
+	seed int64
+}
+
+// rank is where a target comes in the order, lowest first
+func (tb tieBreaker) rank(target fastaio.EncodedFastaRecord) uint64 {
+	if tb.seed < 0 {
+		return uint64(target.Idx)
+	}
+	h := fnv.New64a()
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(tb.seed))
+	h.Write(b[:])
+	h.Write([]byte(target.ID))
+	return h.Sum64()
+}
+
+// closer reports whether target a is closer to a query than b: a smaller distance, then a more
+// complete target, then the tieBreaker's order
+func closer(a resultsStruct, b resultsStruct) bool {
+	if a.distance != b.distance {
+		return a.distance < b.distance
+	}
+	if a.completeness != b.completeness {
+		return a.completeness > b.completeness
+	}
+	return a.rank < b.rank
+}
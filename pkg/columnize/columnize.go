@@ -0,0 +1,186 @@
+/*
+Package columnize checks that sequences that are claimed to be aligned (e.g. consensus
+sequences that were all made against the same reference) really are all the same length,
+before they are used by anything that works on alignment columns, and reports the ones
+that aren't.
+*/
+package columnize
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// the name of the count of sequences that are the wrong length in the run summary
+const wrongLength = "wrong_length"
+
+// eachRecord calls fn with each record in infile, stopping at the first error
+func eachRecord(infile string, fn func(FR fastaio.FastaRecord) error) error {
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			err := fn(FR)
+			if err != nil {
+				return err
+			}
+		case <-cDone:
+			n--
+		}
+	}
+
+	return nil
+}
+
+// modalLength is the most common length of the records in infile (the longest, if there's a tie)
+func modalLength(infile string) (int, error) {
+
+	counts := make(map[int]int)
+	err := eachRecord(infile, func(FR fastaio.FastaRecord) error {
+		counts[len(FR.Seq)]++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	length := -1
+	for l, n := range counts {
+		if length == -1 || n > counts[length] || (n == counts[length] && l > length) {
+			length = l
+		}
+	}
+
+	return length, nil
+}
+
+// referenceLength is the length of the first record in referenceFile
+func referenceLength(referenceFile string) (int, error) {
+	length := -1
+	err := eachRecord(referenceFile, func(FR fastaio.FastaRecord) error {
+		if length == -1 {
+			length = len(FR.Seq)
+		}
+		return nil
+	})
+	if err == nil && length == -1 {
+		err = fmt.Errorf("there is no sequence in %s", referenceFile)
+	}
+	return length, err
+}
+
+// Columnize writes the records in infile that are length long to outfile, and the name and
+// length of each of the rest to reportFile (a tab-separated file, or stderr if reportFile is
+// empty). If length is 0, the records should be as long as the first record in referenceFile,
+// or, if that is empty too, as the most common length in infile (which means reading it twice,
+// so it has to be a file). Unless drop is true, it is an error for any of them to be the wrong
+// length, after they have all been reported
+func Columnize(infile string, outfile string, reportFile string, length int, referenceFile string, drop bool) error {
+
+	var err error
+	switch {
+	case length < 0:
+		return usage.New("--length must be at least 1")
+	case length > 0 && len(referenceFile) > 0:
+		return usage.New("give --length or --reference, not both")
+	case length == 0 && len(referenceFile) > 0:
+		length, err = referenceLength(referenceFile)
+	case length == 0 && infile == "stdin":
+		return usage.New("give --length or --reference to read the sequences from stdin (otherwise the most common length is used, which means reading them twice)")
+	case length == 0:
+		length, err = modalLength(infile)
+	}
+	if err != nil {
+		return err
+	}
+
+	var f *os.File
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	rf := os.Stderr
+	if len(reportFile) > 0 {
+		rf, err = os.Create(reportFile)
+		if err != nil {
+			return err
+		}
+		defer rf.Close()
+	}
+
+	w := bufio.NewWriter(f)
+	rw := bufio.NewWriter(rf)
+
+	// the report's header is only written to stderr if there is something to report
+	header := func() error {
+		_, err := rw.WriteString("query\tlength\texpected\n")
+		return err
+	}
+	if len(reportFile) > 0 {
+		err = header()
+		if err != nil {
+			return err
+		}
+	}
+
+	kept := 0
+	wrong := 0
+
+	err = eachRecord(infile, func(FR fastaio.FastaRecord) error {
+		if len(FR.Seq) != length {
+			if wrong == 0 && len(reportFile) == 0 {
+				err := header()
+				if err != nil {
+					return err
+				}
+			}
+			wrong++
+			_, err := rw.WriteString(FR.ID + "\t" + strconv.Itoa(len(FR.Seq)) + "\t" + strconv.Itoa(length) + "\n")
+			return err
+		}
+		kept++
+		_, err := w.WriteString(">" + FR.Description + "\n" + FR.Seq + "\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	err = rw.Flush()
+	if err != nil {
+		return err
+	}
+
+	summary.Add(summary.Processed, kept)
+	summary.Add(wrongLength, wrong)
+
+	if wrong > 0 && !drop {
+		return fmt.Errorf("%d of %d sequences aren't %d long, so they aren't aligned (use --drop to leave them out)", wrong, wrong+kept, length)
+	}
+
+	return nil
+}
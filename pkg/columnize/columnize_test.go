@@ -0,0 +1,69 @@
+package columnize
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestColumnize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	infile := filepath.Join(dir, "consensus.fasta")
+	err = ioutil.WriteFile(infile, []byte(">s1\nACGT\nAC\n>s2 two\nACGTAC\n>s3\nACGTA\n>s4\nacgtac\n>s5\nACGTACG\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outfile := filepath.Join(dir, "aligned.fasta")
+	reportFile := filepath.Join(dir, "report.tsv")
+
+	// the most common length is 6, and s3 and s5 aren't that long
+	err = Columnize(infile, outfile, reportFile, 0, "", false)
+	if err == nil {
+		t.Error("problem in TestColumnize: expected an error for sequences of the wrong length")
+	}
+
+	out, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != ">s1\nACGTAC\n>s2 two\nACGTAC\n>s4\nACGTAC\n" {
+		t.Errorf("problem in TestColumnize: got %q", out)
+	}
+
+	report, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(report) != "query\tlength\texpected\ns3\t5\t6\ns5\t7\t6\n" {
+		t.Errorf("problem in TestColumnize: got report %q", report)
+	}
+
+	err = Columnize(infile, outfile, reportFile, 0, "", true)
+	if err != nil {
+		t.Errorf("problem in TestColumnize: %v", err)
+	}
+
+	// with a reference (or --length), that is the length the sequences should be
+	refFile := filepath.Join(dir, "ref.fasta")
+	err = ioutil.WriteFile(refFile, []byte(">ref\nACGTACG\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Columnize(infile, outfile, reportFile, 0, refFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != ">s5\nACGTACG\n" {
+		t.Errorf("problem in TestColumnize: got %q", out)
+	}
+}
@@ -0,0 +1,271 @@
+/*
+Package columns extracts some of the columns of an alignment (particular positions, ranges from
+a BED file, or all the variable sites) into a smaller alignment, e.g. a SNP-only alignment to
+build a tree from quickly, as snp-sites does.
+*/
+package columns
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// nucleotide bits, for finding the variable sites. Anything else (gaps, Ns and other
+// ambiguity codes) doesn't count
+var nucBits = map[byte]uint8{'A': 1, 'C': 2, 'G': 4, 'T': 8}
+
+// siteBits reads an alignment, and reports which of A, C, G and T (as nucBits) there are in each of
+// its columns
+func siteBits(infile string) ([]uint8, error) {
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	var seen []uint8
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return nil, err
+		case FR := <-cFR:
+			if seen == nil {
+				seen = make([]uint8, len(FR.Seq))
+			}
+			if len(FR.Seq) != len(seen) {
+				return nil, fmt.Errorf("%s is %d long, but the first sequence is %d long, so %s isn't an alignment", FR.ID, len(FR.Seq), len(seen), infile)
+			}
+			for i := 0; i < len(FR.Seq); i++ {
+				seen[i] |= nucBits[FR.Seq[i]]
+			}
+		case <-cDone:
+			n--
+		}
+	}
+
+	return seen, nil
+}
+
+// variableSites is which columns have more than one of A, C, G and T in them, from their siteBits
+func variableSites(seen []uint8) []bool {
+	variable := make([]bool, len(seen))
+	for i, bits := range seen {
+		// more than one bit is set
+		variable[i] = bits&(bits-1) != 0
+	}
+	return variable
+}
+
+// constantSites counts the columns that are in positions or bed (or all of them if they are both
+// nil), but not in exclude, that only have one of A, C, G and T in them, for each of A, C, G and T
+func constantSites(seen []uint8, positions *mask.Mask, bed *mask.Mask, exclude *mask.Mask) [4]int {
+	var counts [4]int
+	for i, bits := range seen {
+		if (positions != nil || bed != nil) && !positions.Masked(i) && !bed.Masked(i) {
+			continue
+		}
+		if exclude.Masked(i) {
+			continue
+		}
+		for j, nuc := range []byte("ACGT") {
+			if bits == nucBits[nuc] {
+				counts[j]++
+			}
+		}
+	}
+	return counts
+}
+
+// writeConstantSites writes the numbers of constant A, C, G and T columns, comma-separated, as
+// IQ-TREE's -fconst option takes them
+func writeConstantSites(constantFile string, counts [4]int) error {
+
+	f, err := os.Create(constantFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%d,%d,%d,%d\n", counts[0], counts[1], counts[2], counts[3])
+
+	return err
+}
+
+// pick is the 0-based columns of a width-wide alignment to extract: those in positions or bed (or
+// every column if they are both nil) that are variable (if variable isn't nil) and aren't in
+// exclude
+func pick(width int, positions *mask.Mask, bed *mask.Mask, variable []bool, exclude *mask.Mask) []int {
+	picked := make([]int, 0)
+	for i := 0; i < width; i++ {
+		if (positions != nil || bed != nil) && !positions.Masked(i) && !bed.Masked(i) {
+			continue
+		}
+		if variable != nil && !variable[i] {
+			continue
+		}
+		if exclude.Masked(i) {
+			continue
+		}
+		picked = append(picked, i)
+	}
+	return picked
+}
+
+// writePositions writes the 1-based positions of the extracted columns to a csv file
+func writePositions(positionsFile string, picked []int) error {
+
+	f, err := os.Create(positionsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	w.WriteString("position\n")
+	for _, i := range picked {
+		w.WriteString(strconv.Itoa(i+1) + "\n")
+	}
+
+	return w.Flush()
+}
+
+// Extract writes some of the columns of the alignment in infile to outfile, as an alignment in
+// fasta format, in order: those at the 1-based positions and ranges in positions (e.g. 265 or
+// 265-300) and in bedFile (see mask.Load), or all of them if neither is given. If variable, only
+// the columns with more than one of A, C, G and T are extracted, which means reading infile twice,
+// so it can't be stdin. Columns in maskFile are never extracted. If positionsFile isn't empty, the
+// 1-based position of each extracted column in the original alignment is written to it, so that
+// the sites can be mapped back. If constantFile isn't empty (which needs variable), the numbers of
+// the columns that would have been extracted, but only have one of A, C, G or T in them, are
+// written to it for each of A, C, G and T, for IQ-TREE's -fconst. They are counted in the first
+// pass through infile
+func Extract(infile string, outfile string, positionsFile string, constantFile string, positions []string, bedFile string, variable bool, maskFile string) error {
+
+	if len(positions) == 0 && len(bedFile) == 0 && !variable {
+		return usage.New("columns needs some columns to extract, with --positions, --bed or --variable")
+	}
+
+	selected, err := mask.Parse(positions)
+	if err != nil {
+		return usage.Errorf("--positions: %v", err)
+	}
+	bed, err := mask.Read(bedFile, -1)
+	if err != nil {
+		return err
+	}
+	exclude, err := mask.Load(maskFile, -1)
+	if err != nil {
+		return err
+	}
+
+	if len(constantFile) > 0 && !variable {
+		return usage.New("--constant-sites counts the constant columns that --variable leaves out, so it needs --variable")
+	}
+
+	var variableCols []bool
+	if variable {
+		if infile == "stdin" {
+			return usage.New("--variable reads the alignment twice, so it needs an --infile rather than stdin")
+		}
+		seen, err := siteBits(infile)
+		if err != nil {
+			return err
+		}
+		variableCols = variableSites(seen)
+		if len(constantFile) > 0 {
+			counts := constantSites(seen, selected, bed, exclude)
+			err = writeConstantSites(constantFile, counts)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "constant sites (A,C,G,T): %d,%d,%d,%d\n", counts[0], counts[1], counts[2], counts[3])
+		}
+	}
+
+	var f *os.File
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	width := -1
+	var picked []int
+	var seq []byte
+	written := 0
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			if width < 0 {
+				width = len(FR.Seq)
+				for _, m := range []*mask.Mask{selected, bed, exclude} {
+					err = m.Check(width)
+					if err != nil {
+						return err
+					}
+				}
+				picked = pick(width, selected, bed, variableCols, exclude)
+				seq = make([]byte, len(picked))
+			}
+			if len(FR.Seq) != width {
+				return fmt.Errorf("%s is %d long, but the first sequence is %d long, so %s isn't an alignment", FR.ID, len(FR.Seq), width, infile)
+			}
+			for j, i := range picked {
+				seq[j] = FR.Seq[i]
+			}
+			_, err = w.WriteString(">" + FR.Description + "\n" + string(seq) + "\n")
+			if err != nil {
+				return err
+			}
+			written++
+		case <-cDone:
+			n--
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(positionsFile) > 0 {
+		err = writePositions(positionsFile, picked)
+		if err != nil {
+			return err
+		}
+	}
+
+	if width < 0 {
+		width = 0
+	}
+	fmt.Fprintf(os.Stderr, "extracted %d of %d columns\n", len(picked), width)
+
+	summary.Add(summary.Processed, written)
+
+	return nil
+}
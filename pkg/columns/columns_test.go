@@ -0,0 +1,124 @@
+package columns
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+
+	infile := filepath.Join(dir, "in.fasta")
+	err := ioutil.WriteFile(infile, []byte(">s1 one\nACGTNA\n>s2\nACTT-G\n>s3\nRCGTAA\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bedFile := filepath.Join(dir, "columns.bed")
+	err = ioutil.WriteFile(bedFile, []byte("ref\t0\t2\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maskFile := filepath.Join(dir, "mask")
+	err = ioutil.WriteFile(maskFile, []byte("6\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outfile := filepath.Join(dir, "out.fasta")
+	positionsFile := filepath.Join(dir, "positions.csv")
+
+	tests := []struct {
+		positions []string
+		bedFile   string
+		variable  bool
+		maskFile  string
+		expected  string
+		sites     string
+	}{
+		{[]string{"2", "4-5"}, "", false, "", ">s1 one\nCTN\n>s2\nCT-\n>s3\nCTA\n", "position\n2\n4\n5\n"},
+		{[]string{"5"}, bedFile, false, "", ">s1 one\nACN\n>s2\nAC-\n>s3\nRCA\n", "position\n1\n2\n5\n"},
+		// column 1 only has one nucleotide (and an ambiguity code), and column 5 one nucleotide (and an N and a gap)
+		{nil, "", true, "", ">s1 one\nGA\n>s2\nTG\n>s3\nGA\n", "position\n3\n6\n"},
+		{nil, "", true, maskFile, ">s1 one\nG\n>s2\nT\n>s3\nG\n", "position\n3\n"},
+		{[]string{"1-4"}, "", true, "", ">s1 one\nG\n>s2\nT\n>s3\nG\n", "position\n3\n"},
+	}
+
+	for _, tt := range tests {
+		err = Extract(infile, outfile, positionsFile, "", tt.positions, tt.bedFile, tt.variable, tt.maskFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(outfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.expected {
+			t.Errorf("problem in TestExtract: %v %s %t %s gave\n%s\nexpected\n%s", tt.positions, tt.bedFile, tt.variable, tt.maskFile, out, tt.expected)
+		}
+		sites, err := ioutil.ReadFile(positionsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(sites) != tt.sites {
+			t.Errorf("problem in TestExtract: %v %s %t %s gave positions\n%s\nexpected\n%s", tt.positions, tt.bedFile, tt.variable, tt.maskFile, sites, tt.sites)
+		}
+	}
+
+	errors := []struct {
+		infile    string
+		positions []string
+		variable  bool
+	}{
+		{infile, nil, false},
+		{infile, []string{"7"}, false},
+		{infile, []string{"x"}, false},
+		{"stdin", nil, true},
+	}
+
+	for _, tt := range errors {
+		err = Extract(tt.infile, outfile, "", "", tt.positions, "", tt.variable, "")
+		if err == nil {
+			t.Errorf("problem in TestExtract: expected an error for %s %v %t", tt.infile, tt.positions, tt.variable)
+		}
+	}
+
+	err = Extract(infile, outfile, "", filepath.Join(dir, "fconst"), nil, "", false, "")
+	if err == nil {
+		t.Errorf("problem in TestExtract: expected an error for --constant-sites without --variable")
+	}
+}
+
+func TestConstantSites(t *testing.T) {
+	dir := t.TempDir()
+
+	infile := filepath.Join(dir, "in.fasta")
+	err := ioutil.WriteFile(infile, []byte(">s1 one\nACGTNA\n>s2\nACTT-G\n>s3\nRCGTAA\n>s4\nNNNNNN\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outfile := filepath.Join(dir, "out.fasta")
+	constantFile := filepath.Join(dir, "fconst")
+
+	tests := []struct {
+		positions []string
+		expected  string
+	}{
+		// columns 3 and 6 are variable, and the rest only have one nucleotide
+		{nil, "2,1,0,1\n"},
+		{[]string{"1-4"}, "1,1,0,1\n"},
+	}
+
+	for _, tt := range tests {
+		err = Extract(infile, outfile, "", constantFile, tt.positions, "", true, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts, err := ioutil.ReadFile(constantFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(counts) != tt.expected {
+			t.Errorf("problem in TestConstantSites: %v gave %q, expected %q", tt.positions, counts, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+/*
+Package compare compares two alignments of the same samples, e.g. the outputs of two versions of a
+pipeline, and reports how each sample's sequence differs between them: changed bases, Ns that
+were gained or lost, and gaps (indels) that were gained or lost.
+*/
+package compare
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/memlimit"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+)
+
+// the statuses of a sample
+const (
+	statusIdentical = "identical"
+	statusDifferent = "different"
+	statusLength    = "length_differs"
+	statusOnlyOld   = "only_old"
+	statusOnlyNew   = "only_new"
+)
+
+// the name of the count of samples that differ between the alignments in the run summary
+const differentCount = "different"
+
+// difference is how a sample's sequence differs between the two alignments
+type difference struct {
+	status     string
+	bases      int // sites with different bases (A, C, G or T) in each
+	nGained    int // sites with a base in the old alignment and N (or another ambiguity code) in the new one
+	nLost      int // the other way round
+	gapsGained int // sites with a base (or N) in the old alignment and a gap in the new one
+	gapsLost   int // the other way round
+	sites      []string
+}
+
+// isBase reports whether b is A, C, G or T
+func isBase(b byte) bool {
+	return b == 'A' || b == 'C' || b == 'G' || b == 'T'
+}
+
+// diff compares a sample's old (a) and new (b) sequences, which are the same length and in upper case. The sites with
+// different bases or gaps are listed as e.g. 241:C>T or 21765:T>-
+func diff(a string, b string) difference {
+	d := difference{status: statusIdentical, sites: make([]string, 0)}
+	for i := 0; i < len(a); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		switch {
+		case b[i] == '-':
+			d.gapsGained++
+		case a[i] == '-':
+			d.gapsLost++
+		case isBase(a[i]) && isBase(b[i]):
+			d.bases++
+		case isBase(a[i]):
+			d.nGained++
+			continue
+		case isBase(b[i]):
+			d.nLost++
+			continue
+		default:
+			// one ambiguity code for another
+			d.bases++
+		}
+		d.sites = append(d.sites, strconv.Itoa(i+1)+":"+string(a[i])+">"+string(b[i]))
+	}
+	if d.bases+d.nGained+d.nLost+d.gapsGained+d.gapsLost > 0 {
+		d.status = statusDifferent
+	}
+	return d
+}
+
+// alignment is the (upper case) sequences of an alignment by name, and its names in order. An
+// alignment that is too big for the --max-mem budget is left on disk, and its sequences are read
+// one at a time using a fasta index
+type alignment struct {
+	names []string
+	seqs  map[string]string
+	idx   *fastaio.Index
+	f     *os.File
+}
+
+// get returns the sequence called name, and whether there is one
+func (a *alignment) get(name string) (string, bool, error) {
+	if a.idx == nil {
+		seq, ok := a.seqs[name]
+		return seq, ok, nil
+	}
+	if !a.has(name) {
+		return "", false, nil
+	}
+	FR, err := a.idx.Fetch(a.f, name)
+	if err != nil {
+		return "", false, err
+	}
+	return FR.Seq, true, nil
+}
+
+// has reports whether there is a sequence called name
+func (a *alignment) has(name string) bool {
+	if a.idx == nil {
+		_, ok := a.seqs[name]
+		return ok
+	}
+	return a.idx.Has(name)
+}
+
+// close closes the alignment's file, if it was left on disk
+func (a *alignment) close() {
+	if a.f != nil {
+		a.f.Close()
+	}
+}
+
+// open reads an alignment onto the heap, or indexes it if it is too big for the --max-mem budget
+// (and its lines can be indexed)
+func open(infile string) (*alignment, error) {
+	if memlimit.SpillFile(infile) {
+		idx, err := fastaio.LoadIndex(infile)
+		if err == nil {
+			f, err := os.Open(infile)
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, len(idx.Entries))
+			for i, e := range idx.Entries {
+				names[i] = e.Name
+			}
+			summary.Read(infile, len(names))
+			return &alignment{names: names, idx: idx, f: f}, nil
+		}
+	}
+	seqs, names, err := readAll(infile)
+	if err != nil {
+		return nil, err
+	}
+	return &alignment{names: names, seqs: seqs}, nil
+}
+
+// readAll reads an alignment into a map from name to (upper case) sequence, and its names in order
+func readAll(infile string) (map[string]string, []string, error) {
+
+	cErr := make(chan error)
+	cFR := make(chan fastaio.FastaRecord)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	seqs := make(map[string]string)
+	names := make([]string, 0)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return nil, nil, err
+		case FR := <-cFR:
+			if _, dup := seqs[FR.ID]; dup {
+				return nil, nil, fmt.Errorf("%s is in %s more than once", FR.ID, infile)
+			}
+			seqs[FR.ID] = strings.ToUpper(FR.Seq)
+			names = append(names, FR.ID)
+		case <-cDone:
+			n--
+		}
+	}
+
+	return seqs, names, nil
+}
+
+// Compare compares the sequence of each sample (matched by name) in oldFile with its sequence in
+// newFile (e.g. the outputs of two versions of a pipeline), and writes a csv-format report to
+// outfile, with one line per sample (in the order of oldFile, then any that are only in newFile)
+// and the columns sample, status (identical, different, length_differs, only_old or only_new),
+// bases, n_gained, n_lost, gaps_gained, gaps_lost and sites (the sites with different bases or
+// gaps, e.g. 241:C>T|21765:T>-). If identicalToo is false, samples that are identical in both
+// aren't written
+func Compare(oldFile string, newFile string, outfile string, identicalToo bool) error {
+
+	alnOld, err := open(oldFile)
+	if err != nil {
+		return err
+	}
+	defer alnOld.close()
+	alnNew, err := open(newFile)
+	if err != nil {
+		return err
+	}
+	defer alnNew.close()
+
+	f := os.Stdout
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	w := bufio.NewWriter(f)
+
+	w.WriteString("sample,status,bases,n_gained,n_lost,gaps_gained,gaps_lost,sites\n")
+
+	counts := make(map[string]int)
+
+	write := func(name string, d difference) error {
+		counts[d.status]++
+		if d.status == statusIdentical && !identicalToo {
+			return nil
+		}
+		_, err := w.WriteString(strings.Join([]string{
+			name, d.status,
+			strconv.Itoa(d.bases), strconv.Itoa(d.nGained), strconv.Itoa(d.nLost),
+			strconv.Itoa(d.gapsGained), strconv.Itoa(d.gapsLost),
+			strings.Join(d.sites, "|"),
+		}, ",") + "\n")
+		return err
+	}
+
+	for _, name := range alnOld.names {
+		a, _, err := alnOld.get(name)
+		if err != nil {
+			return err
+		}
+		b, ok, err := alnNew.get(name)
+		if err != nil {
+			return err
+		}
+		var d difference
+		switch {
+		case !ok:
+			d = difference{status: statusOnlyOld}
+		case len(a) != len(b):
+			d = difference{status: statusLength}
+		default:
+			d = diff(a, b)
+		}
+		err = write(name, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range alnNew.names {
+		if !alnOld.has(name) {
+			err = write(name, difference{status: statusOnlyNew})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	report := make([]string, len(statuses))
+	for i, status := range statuses {
+		report[i] = status + ": " + strconv.Itoa(counts[status])
+	}
+	if len(report) == 0 {
+		report = append(report, "0")
+	}
+	fmt.Fprintf(os.Stderr, "samples compared: %s\n", strings.Join(report, ", "))
+
+	summary.Add(summary.Processed, counts[statusIdentical]+counts[statusDifferent]+counts[statusLength])
+	summary.Add(differentCount, counts[statusDifferent]+counts[statusLength]+counts[statusOnlyOld]+counts[statusOnlyNew])
+
+	return nil
+}
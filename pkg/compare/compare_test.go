@@ -0,0 +1,74 @@
+package compare
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/memlimit"
+)
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+
+	oldFile := filepath.Join(dir, "old.fasta")
+	newFile := filepath.Join(dir, "new.fasta")
+	outFile := filepath.Join(dir, "out.csv")
+
+	// s1 is identical, s2 has a changed base, a new N, a lost N and a new gap, s3 is shorter in
+	// new.fasta, s4 is only in old.fasta and s5 only in new.fasta
+	err := ioutil.WriteFile(oldFile, []byte(">s1\nACGTACGT\n>s2\nACGTNCGT\n>s3\nACGTACGT\n>s4\nACGTACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(newFile, []byte(">s1\nacgtacgt\n>s2\nATNTAC-T\n>s3\nACGTACG\n>s5\nACGTACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Compare(oldFile, newFile, outFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "sample,status,bases,n_gained,n_lost,gaps_gained,gaps_lost,sites\n" +
+		"s2,different,1,1,1,1,0,2:C>T|7:G>-\n" +
+		"s3,length_differs,0,0,0,0,0,\n" +
+		"s4,only_old,0,0,0,0,0,\n" +
+		"s5,only_new,0,0,0,0,0,\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestCompare: got\n%s\nwant\n%s", out, expected)
+	}
+
+	// alignments that are too big for the --max-mem budget are read with an index instead, and
+	// give the same report
+	memlimit.Set(64)
+	err = Compare(oldFile, newFile, outFile, false)
+	memlimit.Set(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != expected {
+		t.Errorf("problem in TestCompare: with --max-mem got\n%s\nwant\n%s", out, expected)
+	}
+
+	err = Compare(oldFile, newFile, outFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "sample,status,bases,n_gained,n_lost,gaps_gained,gaps_lost,sites\ns1,identical,") {
+		t.Errorf("problem in TestCompare: expected s1 with --all, got\n%s", out)
+	}
+}
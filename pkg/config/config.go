@@ -0,0 +1,335 @@
+/*
+Package config reads the configuration files that hold the settings of a lab's standard analyses
+(reference paths, annotation, masks, thresholds, output formats), so that they can be version
+controlled instead of being repeated on every command line.
+
+A configuration file is in a subset of TOML: key = value lines, where the key is the long name of a
+command line flag and the value is a string ("..." or '...'), a number, true or false, or an array
+of them in [...] (which can run over several lines), with # comments. Settings before the first
+[section] header apply to every command, and those in a section apply to the command it names
+(e.g. [closest], or [sam.toMultiAlign] or ["sam toMultiAlign"] for a subcommand) and its subcommands,
+overriding the more general ones:
+
+	# the reference and mask that every command uses
+	reference = "MN908947.fasta"
+	mask = "problematic_sites.bed"
+	threads = 8
+
+	[sam]
+	min-qual = 20
+
+	[sam.toMultiAlign]
+	trim = true
+	trimstart = 265
+	trimend = 29674
+
+	[closest]
+	target = "target.gfe"
+	annotate = ["lineage", "date"]
+*/
+package config
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// Setting is the value of one key in a configuration file, as the strings that its flag would be
+// given on the command line (one for each item, if it was an array)
+type Setting struct {
+	Key    string
+	Values []string
+	// Section is the command that the setting is for ("" for every command), and Line is the
+	// line it is on, for error messages
+	Section string
+	Line    int
+}
+
+// Config is a parsed configuration file
+type Config struct {
+	Path     string
+	sections map[string][]Setting
+}
+
+// Load reads the configuration file at path
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, path)
+}
+
+// Parse reads a configuration file from r. path is only used in error messages
+func Parse(r io.Reader, path string) (*Config, error) {
+
+	c := &Config{Path: path, sections: make(map[string][]Setting)}
+	seen := make(map[string]bool)
+
+	section := ""
+
+	s := bufio.NewScanner(r)
+	lineNumber := 0
+
+	for s.Scan() {
+		lineNumber++
+		start := lineNumber
+		line := strings.TrimSpace(stripComment(s.Text()))
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, usage.Errorf("%s line %d: bad section header %s", path, start, line)
+			}
+			name, err := parseSectionName(strings.TrimSpace(line[1 : len(line)-1]))
+			if err != nil {
+				return nil, usage.Errorf("%s line %d: %v", path, start, err)
+			}
+			section = name
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, usage.Errorf("%s line %d: expected key = value, not %s", path, start, line)
+		}
+		key := unquote(strings.TrimSpace(line[:eq]))
+		value := strings.TrimSpace(line[eq+1:])
+		if len(key) == 0 || len(value) == 0 {
+			return nil, usage.Errorf("%s line %d: expected key = value, not %s", path, start, line)
+		}
+
+		// an array can go on over several lines, until its brackets are closed
+		for strings.HasPrefix(value, "[") && !closed(value) && s.Scan() {
+			lineNumber++
+			value += " " + strings.TrimSpace(stripComment(s.Text()))
+		}
+
+		values, err := parseValue(value)
+		if err != nil {
+			return nil, usage.Errorf("%s line %d: %s: %v", path, start, key, err)
+		}
+
+		if seen[section+"\x00"+key] {
+			return nil, usage.Errorf("%s line %d: %s is set more than once", path, start, key)
+		}
+		seen[section+"\x00"+key] = true
+
+		c.sections[section] = append(c.sections[section], Setting{Key: key, Values: values, Section: section, Line: start})
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Settings returns the settings that apply to command (its path without the program name, e.g.
+// "sam toMultiAlign"): the ones for every command, then the ones for each command that it is a
+// subcommand of, then its own, so that a later setting of the same key overrides an earlier one
+func (c *Config) Settings(command string) []Setting {
+
+	settings := append([]Setting{}, c.sections[""]...)
+
+	words := strings.Fields(command)
+	for i := range words {
+		settings = append(settings, c.sections[strings.Join(words[:i+1], " ")]...)
+	}
+
+	return settings
+}
+
+// Sections returns the names of the sections in the file (apart from the one for every command), sorted
+func (c *Config) Sections() []string {
+	names := make([]string, 0, len(c.sections))
+	for name := range c.sections {
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseSectionName turns a section header into the command path that it is for: sam.toMultiAlign
+// and "sam toMultiAlign" are both sam toMultiAlign
+func parseSectionName(header string) (string, error) {
+	if len(header) == 0 {
+		return "", usage.New("empty section header")
+	}
+	if header[0] == '"' || header[0] == '\'' {
+		name := unquote(header)
+		if name == header {
+			return "", usage.Errorf("bad section header %s", header)
+		}
+		return strings.Join(strings.Fields(name), " "), nil
+	}
+	parts := strings.Split(header, ".")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+		if len(parts[i]) == 0 {
+			return "", usage.Errorf("bad section header %s", header)
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// parseValue parses a value into the strings that a flag would be given for it
+func parseValue(value string) ([]string, error) {
+
+	if !strings.HasPrefix(value, "[") {
+		v, rest, err := parseScalar(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(rest)) > 0 {
+			return nil, usage.Errorf("unexpected %s after the value", strings.TrimSpace(rest))
+		}
+		return []string{v}, nil
+	}
+
+	if !closed(value) {
+		return nil, usage.New("the array isn't closed with ]")
+	}
+
+	values := make([]string, 0)
+	rest := strings.TrimSpace(value[1:])
+	for {
+		if strings.HasPrefix(rest, "]") {
+			break
+		}
+		var v string
+		var err error
+		v, rest, err = parseScalar(rest)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		rest = strings.TrimSpace(rest)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, usage.New("the items of an array must be separated by commas")
+		}
+	}
+	if len(strings.TrimSpace(rest[1:])) > 0 {
+		return nil, usage.Errorf("unexpected %s after the array", strings.TrimSpace(rest[1:]))
+	}
+
+	return values, nil
+}
+
+// parseScalar parses a string, number or boolean from the start of s, and returns it and what is after it
+func parseScalar(s string) (string, string, error) {
+
+	if len(s) == 0 {
+		return "", "", usage.New("missing value")
+	}
+
+	switch s[0] {
+	case '\'':
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", usage.New("the string isn't closed with '")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '"':
+				return b.String(), s[i+1:], nil
+			case '\\':
+				i++
+				if i == len(s) {
+					break
+				}
+				switch s[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\':
+					b.WriteByte(s[i])
+				default:
+					return "", "", usage.Errorf("unknown escape \\%c", s[i])
+				}
+			default:
+				b.WriteByte(s[i])
+			}
+		}
+		return "", "", usage.New("the string isn't closed with \"")
+	}
+
+	end := strings.IndexAny(s, ",] \t")
+	if end < 0 {
+		end = len(s)
+	}
+	token := s[:end]
+	if token != "true" && token != "false" && strings.Trim(token, "+-0123456789._eE") != "" {
+		return "", "", usage.Errorf("%s isn't a string (which needs quotes), number or boolean", token)
+	}
+
+	return strings.ReplaceAll(token, "_", ""), s[end:], nil
+}
+
+// stripComment removes a # comment from the end of a line, unless the # is in a string
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0:
+			if line[i] == '\\' && quote == '"' {
+				i++
+			} else if line[i] == quote {
+				quote = 0
+			}
+		case line[i] == '"' || line[i] == '\'':
+			quote = line[i]
+		case line[i] == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// closed reports whether an array value's brackets are balanced (outside of strings)
+func closed(value string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		switch {
+		case quote != 0:
+			if value[i] == '\\' && quote == '"' {
+				i++
+			} else if value[i] == quote {
+				quote = 0
+			}
+		case value[i] == '"' || value[i] == '\'':
+			quote = value[i]
+		case value[i] == '[':
+			depth++
+		case value[i] == ']':
+			depth--
+		}
+	}
+	return depth == 0
+}
+
+// unquote removes the quotes from a quoted key or section name
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func TestParse(t *testing.T) {
+	in := `# shared settings
+reference = "MN908947.fasta"   # a comment
+threads = 8
+
+[sam]
+min-qual = 20
+annotate = ["lineage",
+	'date#1'] # split over lines
+
+[sam.toMultiAlign]
+trim = true
+trimstart = 1_000
+
+["sam toMultiAlign"]
+pad = false
+`
+	c, err := Parse(strings.NewReader(in), "test.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := func(settings []Setting) map[string][]string {
+		m := make(map[string][]string)
+		for _, s := range settings {
+			m[s.Key] = s.Values
+		}
+		return m
+	}
+
+	got := values(c.Settings("sam toMultiAlign"))
+	expected := map[string][]string{
+		"reference": {"MN908947.fasta"},
+		"threads":   {"8"},
+		"min-qual":  {"20"},
+		"annotate":  {"lineage", "date#1"},
+		"trim":      {"true"},
+		"trimstart": {"1000"},
+		"pad":       {"false"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("problem in TestParse: got %v, expected %v", got, expected)
+	}
+
+	got = values(c.Settings("closest"))
+	if len(got) != 2 || got["threads"][0] != "8" {
+		t.Errorf("problem in TestParse: closest got %v", got)
+	}
+
+	if s := c.Sections(); !reflect.DeepEqual(s, []string{"sam", "sam toMultiAlign"}) {
+		t.Errorf("problem in TestParse: sections are %v", s)
+	}
+
+	settings := c.Settings("sam toMultiAlign")
+	if last := settings[len(settings)-1]; last.Line != 15 || last.Section != "sam toMultiAlign" {
+		t.Errorf("problem in TestParse: the last setting is %+v", last)
+	}
+
+	// a file with Windows line endings is read in the same way
+	c, err = Parse(strings.NewReader(testutil.CRLF(in)), "test.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values(c.Settings("sam toMultiAlign")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("problem in TestParse: with CRLF got %v, expected %v", got, expected)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		"reference = MN908947.fasta\n",
+		"reference = \"MN908947.fasta\n",
+		"reference\n",
+		"[closest\n",
+		"annotate = [\"a\" \"b\"]\n",
+		"threads = 8\nthreads = 2\n",
+	} {
+		_, err := Parse(strings.NewReader(in), "test.toml")
+		if !usage.Is(err) {
+			t.Errorf("problem in TestParseErrors: %q gave %v", in, err)
+		}
+	}
+}
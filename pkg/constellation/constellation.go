@@ -0,0 +1,330 @@
+/*
+Package constellation classifies aligned sequences against constellations: sets of nucleotide,
+deletion and amino acid changes that define a lineage or variant (as in scorpio), with rules for
+how many of the changes a sequence must have to match.
+*/
+package constellation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+)
+
+// the statuses of a query against a constellation
+const (
+	statusMatch   = "match"
+	statusPartial = "partial"
+	statusNone    = "none"
+)
+
+// evidence is one line of the evidence for a classification: a site's call, or a count rule
+type evidence struct {
+	site   string
+	call   string
+	allele string
+	rule   string
+	pass   string
+}
+
+// classification is a query's status against one constellation
+type classification struct {
+	label    string
+	status   string
+	counts   map[string]int
+	evidence []evidence
+}
+
+// result is every classification of one query
+type result struct {
+	name            string
+	idx             int
+	classifications []classification
+}
+
+// classify calls each of a constellation's sites in a query, and checks its rules
+func classify(seq string, d *Definition, t *alphabet.Translator) classification {
+
+	c := classification{label: d.Label, counts: make(map[string]int)}
+	calls := make([]string, len(d.sites))
+	rules := make(map[int]string)
+	for _, r := range d.rules {
+		rules[r.site] = r.value
+	}
+
+	match := true
+
+	for i, st := range d.sites {
+		call, allele := st.call(seq, t)
+		calls[i] = call
+		c.counts[call]++
+		e := evidence{site: st.name, call: call, allele: allele}
+		if value, ok := rules[i]; ok {
+			pass := false
+			switch value {
+			case callAlt, callRef:
+				pass = call == value
+			case "not alt":
+				pass = call != callAlt
+			case "not ref":
+				pass = call != callRef
+			}
+			e.rule = value
+			e.pass = strconv.FormatBool(pass)
+			match = match && pass
+		}
+		c.evidence = append(c.evidence, e)
+	}
+
+	pass := c.counts[callAlt] >= d.minAlt
+	c.evidence = append(c.evidence, evidence{site: "min_alt", call: strconv.Itoa(c.counts[callAlt]), rule: strconv.Itoa(d.minAlt), pass: strconv.FormatBool(pass)})
+	match = match && pass
+
+	if d.maxRef >= 0 {
+		pass = c.counts[callRef] <= d.maxRef
+		c.evidence = append(c.evidence, evidence{site: "max_ref", call: strconv.Itoa(c.counts[callRef]), rule: strconv.Itoa(d.maxRef), pass: strconv.FormatBool(pass)})
+		match = match && pass
+	}
+
+	switch {
+	case match:
+		c.status = statusMatch
+	case c.counts[callAlt] > 0:
+		c.status = statusPartial
+	default:
+		c.status = statusNone
+	}
+
+	return c
+}
+
+// classifyQueries classifies each query it is sent against every constellation
+func classifyQueries(defs []*Definition, length int, policy alphabet.CodonPolicy, cFR chan fastaio.FastaRecord, cResults chan result, cErr chan error) {
+	t := alphabet.NewTranslator(policy)
+	for FR := range cFR {
+		if len(FR.Seq) < length {
+			cErr <- fmt.Errorf("%s is too short (%d) for the constellations' sites (which go up to %d): is it aligned to the reference?", FR.ID, len(FR.Seq), length)
+			return
+		}
+		r := result{name: FR.ID, idx: FR.Idx}
+		for _, d := range defs {
+			r.classifications = append(r.classifications, classify(FR.Seq, d, t))
+		}
+		cResults <- r
+	}
+}
+
+// create opens outfile for writing, or stdout
+func create(outfile string) (*os.File, error) {
+	if outfile == "stdout" {
+		return os.Stdout, nil
+	}
+	return os.Create(outfile)
+}
+
+// writeResults writes the classifications, and their evidence if evidenceFile isn't empty, in
+// the same order as the queries
+func writeResults(outfile string, evidenceFile string, cResults chan result, cErr chan error, cDone chan bool) {
+
+	f, err := create(outfile)
+	if err != nil {
+		cErr <- err
+		return
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	w.WriteString("query,constellation,status,alt,ref,oth,missing\n")
+
+	var ew *bufio.Writer
+	if len(evidenceFile) > 0 {
+		ef, err := create(evidenceFile)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		defer ef.Close()
+		ew = bufio.NewWriter(ef)
+		ew.WriteString("query,constellation,site,call,allele,rule,pass\n")
+	}
+
+	matches := 0
+
+	write := func(r result) {
+		for _, c := range r.classifications {
+			w.WriteString(r.name + "," + c.label + "," + c.status + "," + strconv.Itoa(c.counts[callAlt]) + "," +
+				strconv.Itoa(c.counts[callRef]) + "," + strconv.Itoa(c.counts[callOther]) + "," + strconv.Itoa(c.counts[callMissing]) + "\n")
+			if c.status == statusMatch {
+				matches++
+			}
+			if ew == nil {
+				continue
+			}
+			for _, e := range c.evidence {
+				ew.WriteString(r.name + "," + c.label + "," + e.site + "," + e.call + "," + e.allele + "," + e.rule + "," + e.pass + "\n")
+			}
+		}
+	}
+
+	outputMap := make(map[int]result)
+	counter := 0
+
+	for r := range cResults {
+		outputMap[r.idx] = r
+		for {
+			next, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			write(next)
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		cErr <- err
+		return
+	}
+	if ew != nil {
+		err = ew.Flush()
+		if err != nil {
+			cErr <- err
+			return
+		}
+	}
+
+	summary.Add(summary.Processed, counter)
+	summary.Add(constellationMatches, matches)
+
+	cDone <- true
+}
+
+// the name of the count of query/constellation matches in the run summary
+const constellationMatches = "constellation_matches"
+
+// Constellations classifies each query in queryFile (aligned to the reference that the sites are
+// numbered against) against the definitions in definitionFiles (json files, or directories of
+// them; see readDefinition), as match, partial (some alt sites, but not a match) or none. Amino
+// acid sites need genbankFile, the annotation of the reference. If evidenceFile isn't empty, the
+// call at every site, and whether each rule passed, is written to it. Partly deleted codons are
+// translated according to policy. The queries are classified by threads workers (or one per CPU
+// if threads == 0)
+func Constellations(queryFile string, definitionFiles []string, genbankFile string, policy alphabet.CodonPolicy, outfile string, evidenceFile string, threads int) error {
+	return ConstellationsBatch([]string{queryFile}, definitionFiles, genbankFile, policy, []string{outfile}, []string{evidenceFile}, threads)
+}
+
+// ConstellationsBatch is Constellations for a batch of query files, whose classifications (and
+// evidence, if evidenceFiles isn't nil) are written to the matching outfiles. The definitions and
+// the annotation are only loaded once, for the whole batch
+func ConstellationsBatch(queryFiles []string, definitionFiles []string, genbankFile string, policy alphabet.CodonPolicy, outfiles []string, evidenceFiles []string, threads int) error {
+
+	defer profiling.Region("constellations")()
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	var gb *genbank.Genbank
+	if len(genbankFile) > 0 {
+		record, err := genbank.Load(genbankFile)
+		if err != nil {
+			return err
+		}
+		gb = &record
+	}
+
+	defs, err := LoadDefinitions(definitionFiles, gb)
+	if err != nil {
+		return err
+	}
+
+	length := 0
+	for _, d := range defs {
+		if d.Length() > length {
+			length = d.Length()
+		}
+	}
+
+	for i, queryFile := range queryFiles {
+		evidenceFile := ""
+		if evidenceFiles != nil {
+			evidenceFile = evidenceFiles[i]
+		}
+		err = classifyFile(queryFile, defs, length, policy, outfiles[i], evidenceFile, threads)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// classifyFile classifies each query in queryFile against defs, whose sites are all within the
+// first length columns, and writes the classifications (and evidence) as Constellations does
+func classifyFile(queryFile string, defs []*Definition, length int, policy alphabet.CodonPolicy, outfile string, evidenceFile string, threads int) error {
+
+	cFR := make(chan fastaio.FastaRecord)
+	cIndexed := make(chan fastaio.FastaRecord, threads)
+	cResults := make(chan result, threads)
+	cErr := make(chan error)
+	cReadDone := make(chan bool)
+	cWriteDone := make(chan bool)
+
+	go fastaio.ReadAlignment(queryFile, cFR, cErr, cReadDone)
+
+	go writeResults(outfile, evidenceFile, cResults, cErr, cWriteDone)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+	for n := 0; n < threads; n++ {
+		go func() {
+			classifyQueries(defs, length, policy, cIndexed, cResults, cErr)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(cResults)
+	}()
+
+	counter := 0
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			FR.Idx = counter
+			counter++
+			select {
+			case cIndexed <- FR:
+			case err := <-cErr:
+				return err
+			}
+		case <-cReadDone:
+			close(cIndexed)
+			n--
+		}
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cWriteDone:
+			n--
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package constellation
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+)
+
+var testGenbank = `LOCUS       test                      24 bp    DNA     linear   VRL 01-JAN-2021
+FEATURES             Location/Qualifiers
+     CDS             1..18
+                     /gene="g1"
+     CDS             complement(19..24)
+                     /gene="g2"
+ORIGIN
+        1 atgaaacccg ggttttaaac gtac
+//
+`
+
+func writeFile(t *testing.T, dir string, name string, contents string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConstellations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	genbankFile := writeFile(t, dir, "test.gb", testGenbank)
+
+	defDir := filepath.Join(dir, "constellations")
+	err = os.Mkdir(defDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, defDir, "a.json", `{"label": "A-like", "sites": ["nuc:A4G", "g1:K2E", "del:10:3", "G2:V1A"], "rules": {"min_alt": 3, "g2:V1A": "alt"}}`)
+
+	// the reference is ATGAAACCCGGGTTTTAAACGTAC. g2 is on the reverse strand, so V1A is T>C at 23
+	queryFile := writeFile(t, dir, "query.fasta", ">q1\nATGGAACCC---TTTTAAACGTGC\n>q2\nATGGAACCCGGGTTTTAAACGTAC\n>q3\nATGAAACCCGGGTTTTAAACGTAC\n>q4\nATGNAACCC---TTTTAAACGTGC\n")
+
+	outFile := filepath.Join(dir, "out.csv")
+	evidenceFile := filepath.Join(dir, "evidence.csv")
+
+	err = Constellations(queryFile, []string{defDir}, genbankFile, alphabet.CodonX, outFile, evidenceFile, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "query,constellation,status,alt,ref,oth,missing\n" +
+		"q1,A-like,match,4,0,0,0\n" +
+		"q2,A-like,partial,2,2,0,0\n" +
+		"q3,A-like,none,0,4,0,0\n" +
+		"q4,A-like,partial,2,0,0,2\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestConstellations: got\n%s\nexpected\n%s", out, expected)
+	}
+
+	evidence, err := ioutil.ReadFile(evidenceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "q2,A-like,nuc:A4G,alt,G,,\n" +
+		"q2,A-like,g1:K2E,alt,E,,\n" +
+		"q2,A-like,del:10:3,ref,GGG,,\n" +
+		"q2,A-like,G2:V1A,ref,V,alt,false\n" +
+		"q2,A-like,min_alt,2,,3,false\n"
+	if !strings.Contains(string(evidence), expected) {
+		t.Errorf("problem in TestConstellations: the evidence is\n%s\nexpected it to include\n%s", evidence, expected)
+	}
+}
+
+func TestConstellationsBatch(t *testing.T) {
+	dir := t.TempDir()
+
+	defFile := writeFile(t, dir, "a.json", `{"label": "A-like", "sites": ["nuc:A4G", "del:10:3"]}`)
+
+	queryFiles := []string{
+		writeFile(t, dir, "run1.fasta", ">q1\nATGGAACCC---TTTTAAACGTGC\n"),
+		writeFile(t, dir, "run2.fasta", ">q2\nATGAAACCCGGGTTTTAAACGTAC\n>q3\nATGGAACCC---TTTTAAACGTAC\n"),
+	}
+	outFiles := []string{filepath.Join(dir, "run1.csv"), filepath.Join(dir, "run2.csv")}
+
+	err := ConstellationsBatch(queryFiles, []string{defFile}, "", alphabet.CodonX, outFiles, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"query,constellation,status,alt,ref,oth,missing\nq1,A-like,match,2,0,0,0\n",
+		"query,constellation,status,alt,ref,oth,missing\nq2,A-like,none,0,2,0,0\nq3,A-like,match,2,0,0,0\n",
+	}
+	for i, outFile := range outFiles {
+		out, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != expected[i] {
+			t.Errorf("problem in TestConstellationsBatch: %s is\n%s\nexpected\n%s", outFile, out, expected[i])
+		}
+	}
+}
+
+func TestLoadDefinitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gb, err := genbank.Load(writeFile(t, dir, "test.gb", testGenbank))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, contents := range []string{
+		`{"label": "x", "sites": ["nuc:C4G"]}`,
+		`{"label": "x", "sites": ["g1:P2E"]}`,
+		`{"label": "x", "sites": ["g3:K2E"]}`,
+		`{"label": "x", "sites": ["g1:K7E"]}`,
+		`{"label": "x", "sites": ["ins:4:AT"]}`,
+		`{"label": "x", "sites": ["nuc:A4G"], "rules": {"nuc:A4G": "maybe"}}`,
+		`{"label": "x", "sites": []}`,
+	} {
+		path := writeFile(t, dir, "def.json", contents)
+		_, err = LoadDefinitions([]string{path}, &gb)
+		if err == nil {
+			t.Errorf("problem in TestLoadDefinitions: expected an error for %s", contents)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package constellation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// the kinds of site
+const (
+	nucSite = iota // a SNP, e.g. nuc:C3267T
+	delSite        // a deletion, e.g. del:11288:9
+	aaSite         // an amino acid change, e.g. S:N501Y
+)
+
+// the calls that a query can have at a site
+const (
+	callAlt     = "alt"
+	callRef     = "ref"
+	callOther   = "oth"
+	callMissing = "missing"
+)
+
+// site is one of the changes that define a constellation
+type site struct {
+	name  string
+	kind  int
+	cols  []int // the 0-based alignment columns of the site
+	gene  *cds  // for an amino acid site, its CDS
+	codon int   // for an amino acid site, the 0-based codon in gene
+	ref   string
+	alt   string
+}
+
+// cds is the alignment columns of a CDS, so that the codons around an amino acid site can be
+// translated with it (see alphabet.Translator.At)
+type cds struct {
+	cols         []int          // the 0-based alignment column of each nucleotide
+	complemented []bool         // whether each nucleotide is on the reverse strand
+	coding       genbank.Coding // its genetic code and translation exceptions
+}
+
+// rule is a requirement of one site (alt, ref, not alt or not ref)
+type rule struct {
+	site  int
+	value string
+}
+
+// Definition is a constellation: a set of sites, and the rules for how many of them (and which)
+// a query must have to match it
+type Definition struct {
+	Label  string
+	sites  []site
+	minAlt int
+	maxRef int // -1 for no limit
+	rules  []rule
+}
+
+// definitionFile is the json format of a definition (as used by scorpio)
+type definitionFile struct {
+	Label string                     `json:"label"`
+	Name  string                     `json:"name"`
+	Sites []string                   `json:"sites"`
+	Rules map[string]json.RawMessage `json:"rules"`
+}
+
+var (
+	nucPattern = regexp.MustCompile(`^([ACGT])(\d+)([ACGT])$`)
+	aaPattern  = regexp.MustCompile(`^([A-Z*-])(\d+)([A-Z*-])$`)
+)
+
+// genes looks up the CDSs of a genbank record by their /gene (see genbank.FindCDS), and keeps
+// what has been worked out about each one, keyed by its canonical name
+type genes struct {
+	gb  *genbank.Genbank
+	aa  map[string]string // the translations, to check the reference amino acids
+	cds map[string]*cds
+}
+
+func newGenes(gb *genbank.Genbank) *genes {
+	return &genes{gb: gb, aa: make(map[string]string), cds: make(map[string]*cds)}
+}
+
+// parseSite parses a site, which is nuc:C3267T (or snp:C3267T, or C3267T), del:11288:9 (a
+// deletion of 9 nucleotides starting at 11288) or gene:N501Y (or aa:gene:N501Y), for the CDS
+// with that /gene, where the amino acids can be * (a stop) or - (a deletion of the codon)
+func parseSite(s string, g *genes) (site, error) {
+
+	parts := strings.Split(s, ":")
+	if parts[0] == "nuc" || parts[0] == "snp" || parts[0] == "aa" {
+		parts = parts[1:]
+	}
+	if len(parts) == 0 {
+		return site{}, fmt.Errorf("couldn't parse site %s", s)
+	}
+
+	switch {
+	case len(parts) == 1:
+		m := nucPattern.FindStringSubmatch(parts[0])
+		if m == nil {
+			return site{}, fmt.Errorf("couldn't parse site %s", s)
+		}
+		pos, _ := strconv.Atoi(m[2])
+		if pos < 1 {
+			return site{}, fmt.Errorf("couldn't parse site %s", s)
+		}
+		if g.gb != nil && pos <= len(g.gb.ORIGIN) && strings.ToUpper(string(g.gb.ORIGIN[pos-1])) != m[1] {
+			return site{}, fmt.Errorf("site %s: the reference has %s at %d, not %s", s, strings.ToUpper(string(g.gb.ORIGIN[pos-1])), pos, m[1])
+		}
+		return site{name: s, kind: nucSite, cols: []int{pos - 1}, ref: m[1], alt: m[3]}, nil
+
+	case parts[0] == "del" && len(parts) == 3:
+		pos, err1 := strconv.Atoi(parts[1])
+		length, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || pos < 1 || length < 1 {
+			return site{}, fmt.Errorf("couldn't parse site %s", s)
+		}
+		cols := make([]int, length)
+		for i := range cols {
+			cols[i] = pos - 1 + i
+		}
+		return site{name: s, kind: delSite, cols: cols}, nil
+
+	case len(parts) == 2:
+		m := aaPattern.FindStringSubmatch(strings.ToUpper(parts[1]))
+		if m == nil {
+			return site{}, fmt.Errorf("couldn't parse site %s", s)
+		}
+		if g.gb == nil {
+			return site{}, usage.Errorf("site %s is an amino acid change, which needs --genbank", s)
+		}
+		f, ok := g.gb.FindCDS(parts[0])
+		if !ok {
+			return site{}, fmt.Errorf("site %s: there is no CDS with /gene=%s in the genbank file", s, parts[0])
+		}
+		key := strings.ToLower(genbank.CanonicalGeneName(parts[0]))
+		gene, ok := g.cds[key]
+		if !ok {
+			positions, complemented, err := f.Positions()
+			if err != nil {
+				return site{}, err
+			}
+			coding, err := f.Coding()
+			if err != nil {
+				return site{}, err
+			}
+			gene = &cds{cols: make([]int, len(positions)), complemented: complemented, coding: coding}
+			for i, pos := range positions {
+				gene.cols[i] = pos - 1
+			}
+			g.cds[key] = gene
+		}
+		codon, _ := strconv.Atoi(m[2])
+		if codon < 1 || codon*3 > len(gene.cols) {
+			return site{}, fmt.Errorf("site %s is outside %s", s, parts[0])
+		}
+		aa, ok := g.aa[key]
+		if !ok {
+			var err error
+			aa, err = f.Translate(g.gb.ORIGIN)
+			if err != nil {
+				return site{}, err
+			}
+			g.aa[key] = aa
+		}
+		if m[1] != "-" && codon <= len(aa) && aa[codon-1:codon] != m[1] {
+			return site{}, fmt.Errorf("site %s: the reference has %s at codon %d of %s, not %s", s, aa[codon-1:codon], codon, parts[0], m[1])
+		}
+		return site{name: s, kind: aaSite, cols: gene.cols[(codon-1)*3 : codon*3], gene: gene, codon: codon - 1, ref: m[1], alt: m[3]}, nil
+	}
+
+	return site{}, fmt.Errorf("couldn't parse site %s", s)
+}
+
+// call is a query's call at a site (alt, ref, oth or missing), and what it has there. Amino acid
+// sites are translated by t
+func (st site) call(seq string, t *alphabet.Translator) (string, string) {
+
+	switch st.kind {
+	case nucSite:
+		b := seq[st.cols[0] : st.cols[0]+1]
+		switch {
+		case b == st.alt:
+			return callAlt, b
+		case b == st.ref:
+			return callRef, b
+		case strings.Contains("ACGT-", b):
+			return callOther, b
+		}
+		return callMissing, b
+
+	case delSite:
+		allele := seq[st.cols[0] : st.cols[len(st.cols)-1]+1]
+		gaps := strings.Count(allele, "-")
+		bases := 0
+		for _, b := range allele {
+			if strings.ContainsRune("ACGT", b) {
+				bases++
+			}
+		}
+		switch {
+		case gaps == len(allele):
+			return callAlt, allele
+		case bases == len(allele):
+			return callRef, allele
+		case gaps+bases == len(allele):
+			return callOther, allele
+		}
+		return callMissing, allele
+	}
+
+	aa := st.gene.coding.Translator(t).At(st.codon, len(st.gene.cols)/3, func(j int) byte {
+		col := st.gene.cols[j]
+		switch {
+		case col >= len(seq):
+			return 'N'
+		case st.gene.complemented[j]:
+			return genbank.Complement(seq[col])
+		}
+		return seq[col]
+	})
+	switch {
+	case aa == 'X':
+		return callMissing, string(aa)
+	case string(aa) == st.alt:
+		return callAlt, string(aa)
+	case string(aa) == st.ref:
+		return callRef, string(aa)
+	}
+	return callOther, string(aa)
+}
+
+// same reports whether two sites are the same change, however they are written
+func (st site) same(other site) bool {
+	if st.kind != other.kind || st.alt != other.alt || len(st.cols) != len(other.cols) {
+		return false
+	}
+	for i := range st.cols {
+		if st.cols[i] != other.cols[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// siteIndex is the index of a site in d, which is added if it isn't there
+func (d *Definition) siteIndex(name string, g *genes) (int, error) {
+	st, err := parseSite(name, g)
+	if err != nil {
+		return 0, err
+	}
+	for i, other := range d.sites {
+		if other.same(st) {
+			return i, nil
+		}
+	}
+	d.sites = append(d.sites, st)
+	return len(d.sites) - 1, nil
+}
+
+// readDefinition reads a definition from a json file. Its rules can include min_alt (the number of
+// sites the query must have the alt at; by default, all of them), max_ref (the most sites it can
+// have the ref at; by default, no limit), and site: alt, ref, not alt or not ref for particular sites
+func readDefinition(path string, g *genes) (*Definition, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var df definitionFile
+	err = json.Unmarshal(b, &df)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %v", path, err)
+	}
+
+	d := &Definition{Label: df.Label, maxRef: -1}
+	if len(d.Label) == 0 {
+		d.Label = df.Name
+	}
+	if len(d.Label) == 0 {
+		d.Label = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	for _, s := range df.Sites {
+		_, err = d.siteIndex(s, g)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	d.minAlt = len(d.sites)
+
+	keys := make([]string, 0, len(df.Rules))
+	for key := range df.Rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := df.Rules[key]
+		switch key {
+		case "min_alt", "max_ref":
+			var n int
+			err = json.Unmarshal(value, &n)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s: %s should be a number", path, key)
+			}
+			if key == "min_alt" {
+				d.minAlt = n
+			} else {
+				d.maxRef = n
+			}
+		default:
+			var v string
+			err = json.Unmarshal(value, &v)
+			if err != nil || (v != callAlt && v != callRef && v != "not alt" && v != "not ref") {
+				return nil, fmt.Errorf("%s: the rule for %s should be alt, ref, not alt or not ref", path, key)
+			}
+			i, err := d.siteIndex(key, g)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			d.rules = append(d.rules, rule{site: i, value: v})
+		}
+	}
+
+	if len(d.sites) == 0 {
+		return nil, fmt.Errorf("%s doesn't have any sites", path)
+	}
+
+	return d, nil
+}
+
+// LoadDefinitions reads the definitions in paths, each of which is a json file or a directory of
+// them. Amino acid sites are found using (and the reference alleles of every site are checked
+// against) the genbank record, which can be nil if there aren't any amino acid sites
+func LoadDefinitions(paths []string, gb *genbank.Genbank) ([]*Definition, error) {
+
+	files := make([]string, 0)
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, usage.New("no constellation definitions were given (--constellations)")
+	}
+
+	g := newGenes(gb)
+
+	defs := make([]*Definition, 0, len(files))
+	for _, file := range files {
+		d, err := readDefinition(file, g)
+		if err != nil {
+			return nil, err
+		}
+		defs = append(defs, d)
+	}
+
+	return defs, nil
+}
+
+// Length is the alignment length that the definition's sites need
+func (d *Definition) Length() int {
+	max := 0
+	for _, st := range d.sites {
+		for _, col := range st.cols {
+			if col+1 > max {
+				max = col + 1
+			}
+		}
+	}
+	return max
+}
@@ -0,0 +1,252 @@
+/*
+Package degap strips the gaps out of aligned sequences, and can put them back
+again using a record of where they were.
+*/
+package degap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+)
+
+// gapRuns returns the runs of '-' in an aligned sequence as 1-based, inclusive
+// start-end strings, and the sequence without them
+func gapRuns(seq string) ([]string, string) {
+	runs := make([]string, 0)
+	var degapped strings.Builder
+	for i := 0; i < len(seq); i++ {
+		if seq[i] != '-' {
+			degapped.WriteByte(seq[i])
+			continue
+		}
+		j := i
+		for j < len(seq) && seq[j] == '-' {
+			j++
+		}
+		runs = append(runs, strconv.Itoa(i+1)+"-"+strconv.Itoa(j))
+		i = j - 1
+	}
+	return runs, degapped.String()
+}
+
+// regap puts the gaps in runs back into a degapped sequence, making it alignedLength long
+func regap(seq string, alignedLength int, runs []string) (string, error) {
+
+	gaps := make([]bool, alignedLength)
+	nGaps := 0
+
+	for _, run := range runs {
+		ends := strings.Split(run, "-")
+		if len(ends) != 2 {
+			return "", errors.New("couldn't parse gap: " + run)
+		}
+		start, err := strconv.Atoi(ends[0])
+		if err != nil {
+			return "", errors.New("couldn't parse gap: " + run)
+		}
+		end, err := strconv.Atoi(ends[1])
+		if err != nil {
+			return "", errors.New("couldn't parse gap: " + run)
+		}
+		if start < 1 || end < start || end > alignedLength {
+			return "", fmt.Errorf("gap %s is outside the alignment (length %d)", run, alignedLength)
+		}
+		for i := start - 1; i < end; i++ {
+			if !gaps[i] {
+				gaps[i] = true
+				nGaps++
+			}
+		}
+	}
+
+	if len(seq)+nGaps != alignedLength {
+		return "", fmt.Errorf("sequence is length %d, but with its %d gaps it should be %d", len(seq), nGaps, alignedLength-nGaps)
+	}
+
+	regapped := make([]byte, alignedLength)
+	j := 0
+	for i := range regapped {
+		if gaps[i] {
+			regapped[i] = '-'
+		} else {
+			regapped[i] = seq[j]
+			j++
+		}
+	}
+
+	return string(regapped), nil
+}
+
+// Degap writes the sequences in an alignment without their gaps. If gapsFile isn't
+// empty, a csv file is written there with the aligned length of each sequence and
+// where its gaps were (as a "|"-delimited list of 1-based, inclusive ranges), which
+// Regap can use to put them back
+func Degap(infile string, outfile string, gapsFile string) error {
+
+	f, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	var gw *bufio.Writer
+	if len(gapsFile) > 0 {
+		g, err := os.Create(gapsFile)
+		if err != nil {
+			return err
+		}
+		defer g.Close()
+		gw = bufio.NewWriter(g)
+		gw.WriteString("query,length,gaps\n")
+	}
+
+	cErr := make(chan error)
+	cFR := make(chan fastaio.FastaRecord)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			summary.Add(summary.Processed, 1)
+			runs, seq := gapRuns(FR.Seq)
+			_, err = w.WriteString(">" + FR.Description + "\n" + seq + "\n")
+			if err != nil {
+				return err
+			}
+			if gw != nil {
+				_, err = gw.WriteString(FR.ID + "," + strconv.Itoa(len(FR.Seq)) + "," + strings.Join(runs, "|") + "\n")
+				if err != nil {
+					return err
+				}
+			}
+		case <-cDone:
+			n--
+		}
+	}
+
+	if gw != nil {
+		err = gw.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// gapRecord is one line of a gaps file
+type gapRecord struct {
+	length int
+	runs   []string
+}
+
+// readGaps reads a gaps file written by Degap
+func readGaps(gapsFile string) (map[string]gapRecord, error) {
+
+	f, err := os.Open(gapsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := make(map[string]gapRecord)
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	lineNumber := 0
+
+	for s.Scan() {
+		line := s.Text()
+		lineNumber++
+
+		if lineNumber == 1 {
+			if line != "query,length,gaps" {
+				return nil, errors.New("the gaps file should have the header query,length,gaps")
+			}
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("couldn't parse gaps file line %d: %s", lineNumber, line)
+		}
+
+		length, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse gaps file line %d: %s", lineNumber, line)
+		}
+
+		runs := make([]string, 0)
+		if len(fields[2]) > 0 {
+			runs = strings.Split(fields[2], "|")
+		}
+
+		records[fields[0]] = gapRecord{length: length, runs: runs}
+	}
+
+	return records, s.Err()
+}
+
+// Regap puts the gaps back into sequences that were degapped by Degap, using the
+// gaps file that it wrote. Every sequence must be in the gaps file
+func Regap(infile string, gapsFile string, outfile string) error {
+
+	gaps, err := readGaps(gapsFile)
+	if err != nil {
+		return err
+	}
+
+	f, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	cErr := make(chan error)
+	cFR := make(chan fastaio.FastaRecord)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			summary.Add(summary.Processed, 1)
+			g, ok := gaps[FR.ID]
+			if !ok {
+				return errors.New(FR.ID + " isn't in the gaps file")
+			}
+			seq, err := regap(FR.Seq, g.length, g.runs)
+			if err != nil {
+				return fmt.Errorf("%s: %v", FR.ID, err)
+			}
+			_, err = w.WriteString(">" + FR.Description + "\n" + seq + "\n")
+			if err != nil {
+				return err
+			}
+		case <-cDone:
+			n--
+		}
+	}
+
+	return w.Flush()
+}
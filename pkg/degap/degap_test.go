@@ -0,0 +1,54 @@
+package degap
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGapRuns(t *testing.T) {
+	tests := []struct {
+		seq      string
+		runs     string
+		degapped string
+	}{
+		{"ACGT", "", "ACGT"},
+		{"--AC-GT---", "1-2|5-5|8-10", "ACGT"},
+		{"----", "1-4", ""},
+	}
+
+	for _, test := range tests {
+		runs, degapped := gapRuns(test.seq)
+		if strings.Join(runs, "|") != test.runs || degapped != test.degapped {
+			t.Errorf("problem in TestGapRuns: %s gave %v %s, expected %s %s", test.seq, runs, degapped, test.runs, test.degapped)
+		}
+
+		regapped, err := regap(degapped, len(test.seq), runs)
+		if err != nil {
+			t.Error(err)
+		}
+		if regapped != test.seq {
+			t.Errorf("problem in TestGapRuns: regapping %s gave %s, expected %s", degapped, regapped, test.seq)
+		}
+	}
+}
+
+func TestRegapErrors(t *testing.T) {
+	tests := []struct {
+		seq    string
+		length int
+		runs   []string
+	}{
+		{"ACGT", 6, []string{"1-1"}},
+		{"ACGT", 6, []string{"1-7"}},
+		{"ACGT", 6, []string{"2-1"}},
+		{"ACGT", 6, []string{"x-2"}},
+		{"ACGT", 6, []string{"1"}},
+	}
+
+	for _, test := range tests {
+		_, err := regap(test.seq, test.length, test.runs)
+		if err == nil {
+			t.Errorf("problem in TestRegapErrors: expected an error for %s %d %v", test.seq, test.length, test.runs)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+/*
+Package distance writes matrices of pairwise SNP distances, between every pair of sequences
+in an alignment or between queries and a panel, e.g. for finding clusters, and paints queries by
+their nearest parent in windows, to screen for recombinants.
+*/
+package distance
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// the name of the count of pairs written in the run summary
+const pairsWritten = "pairs_written"
+
+// packed is a sequence that has been packed for counting differences. Sequences that aren't
+// nucleotides can't be packed, and are kept encoded in residues instead
+type packed struct {
+	name     string
+	seq      []uint64
+	residues []byte
+}
+
+// row is the distances from one row's sequence to the columns' sequences, starting at column start
+type row struct {
+	idx   int
+	start int
+	dists []int
+}
+
+// loadPacked reads an alignment of sequences in alphabet a, masks it, and packs it
+func loadPacked(infile string, m *mask.Mask, a *alphabet.Alphabet) ([]packed, int, error) {
+	return load(infile, m, a, nil)
+}
+
+// load reads an alignment of sequences in alphabet a and masks it. Without a differ, the sequences
+// are packed (which they have to be nucleotides for); with one, they are kept encoded in residues,
+// with the masked sites as 0s (see differ)
+func load(infile string, m *mask.Mask, a *alphabet.Alphabet, d *differ) ([]packed, int, error) {
+
+	records, err := fastaio.ReadEncodeAlignmentToListIn(infile, a)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	length := -1
+	seqs := make([]packed, 0, len(records))
+	for _, EFR := range records {
+		if length == -1 {
+			length = len(EFR.Seq)
+		} else if len(EFR.Seq) != length {
+			return nil, 0, fmt.Errorf("the sequences in %s aren't all the same length (is it aligned?)", infile)
+		}
+		if d == nil {
+			m.Fill(EFR.Seq, a.EncodedUnknown())
+			seqs = append(seqs, packed{name: EFR.ID, seq: pack(EFR.Seq)})
+		} else {
+			m.Fill(EFR.Seq, 0)
+			seqs = append(seqs, packed{name: EFR.ID, residues: EFR.Seq})
+		}
+	}
+
+	return seqs, length, nil
+}
+
+// getRows counts the differences for each row index it is sent. With square, every row has
+// every column; otherwise, with no queries, each pair is only counted once (so the row for
+// sequence i starts at column i+1)
+func getRows(rows []packed, cols []packed, d *differ, square bool, allVsAll bool, threshold int, cIdx chan int, cRows chan row) {
+	for i := range cIdx {
+		start := 0
+		if allVsAll && !square {
+			start = i + 1
+		}
+		r := row{idx: i, start: start, dists: make([]int, 0, len(cols)-start)}
+		for j := start; j < len(cols); j++ {
+			if d == nil {
+				r.dists = append(r.dists, differences(rows[i].seq, cols[j].seq, threshold))
+			} else {
+				r.dists = append(r.dists, residueDifferences(d, rows[i].residues, cols[j].residues, threshold))
+			}
+		}
+		cRows <- r
+	}
+}
+
+// writeRows writes the rows in order as they arrive
+func writeRows(outfile string, format string, threshold int, rows []packed, cols []packed, cRows chan row, cErr chan error, cDone chan bool) {
+
+	var f *os.File
+	var err error
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+
+	w.WriteString("##" + version.Provenance() + "\n")
+	if format == "square" {
+		for _, col := range cols {
+			w.WriteString("\t" + col.name)
+		}
+		w.WriteString("\n")
+	} else {
+		w.WriteString("query\ttarget\tdistance\n")
+	}
+
+	pairs := 0
+
+	write := func(r row) {
+		if format == "square" {
+			w.WriteString(rows[r.idx].name)
+			for _, d := range r.dists {
+				w.WriteString("\t" + strconv.Itoa(d))
+			}
+			w.WriteString("\n")
+			pairs += len(r.dists)
+			return
+		}
+		for j, d := range r.dists {
+			if format == "sparse" && d > threshold {
+				continue
+			}
+			w.WriteString(rows[r.idx].name + "\t" + cols[r.start+j].name + "\t" + strconv.Itoa(d) + "\n")
+			pairs++
+		}
+	}
+
+	outputMap := make(map[int]row)
+	counter := 0
+
+	for r := range cRows {
+		outputMap[r.idx] = r
+		for {
+			next, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			write(next)
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	summary.Add(summary.Processed, counter)
+	summary.Add(pairsWritten, pairs)
+
+	cDone <- true
+}
+
+// Options are the options of Distance. DefaultOptions returns them as gofasta distance's flags
+// default to, which is where callers should start from
+type Options struct {
+	// Format is square (a matrix), long (one pair per line) or sparse (long, but only the
+	// pairs that are at most Threshold apart, which is negative for the other formats). In
+	// long and sparse format, without queries, each pair is written once, and sequences
+	// aren't paired with themselves
+	Format    string
+	Threshold int
+
+	// the columns in MaskFile are ignored, if it isn't empty. By default, sites where either
+	// sequence has a gap, or is ambiguous in a way that could match the other, don't count;
+	// Ambiguity and Gaps say otherwise (see newDiffer)
+	MaskFile  string
+	Ambiguity string
+	Gaps      string
+
+	// Alphabet is the alphabet of the sequences (nucleotides, if it is nil)
+	Alphabet *alphabet.Alphabet
+
+	// Threads is the number of workers that count the rows, or one per CPU if it is <= 0
+	Threads int
+}
+
+// DefaultOptions returns the options that gofasta distance uses if none of its flags are given
+func DefaultOptions() Options {
+	return Options{
+		Format:    "square",
+		Threshold: -1,
+		Ambiguity: "match",
+		Gaps:      "skip",
+		Alphabet:  alphabet.Nucleotide,
+	}
+}
+
+// Distance writes the SNP distance between every pair of sequences in panelFile, or if
+// queryFile isn't empty, between every query and every sequence in panelFile, to outfile, as
+// set out by opts (see Options)
+func Distance(panelFile string, queryFile string, outfile string, opts Options) error {
+
+	defer profiling.Region("distance")()
+
+	switch opts.Format {
+	case "square", "long":
+		if opts.Threshold >= 0 {
+			return usage.Errorf("--threshold only works with --format sparse")
+		}
+	case "sparse":
+		if opts.Threshold < 0 {
+			return usage.New("--format sparse needs a --threshold")
+		}
+	default:
+		return usage.Errorf("unrecognised --format: %s (choose one of: square, long, sparse)", opts.Format)
+	}
+
+	err := checkComparison(opts.Ambiguity, opts.Gaps)
+	if err != nil {
+		return err
+	}
+
+	if opts.Threads <= 0 {
+		opts.Threads = runtime.NumCPU()
+	}
+	if opts.Alphabet == nil {
+		opts.Alphabet = alphabet.Nucleotide
+	}
+
+	// nucleotides are packed for the default comparison, and everything else is compared with a
+	// table of differences
+	var d *differ
+	if opts.Alphabet != alphabet.Nucleotide || opts.Ambiguity != "match" || opts.Gaps != "skip" {
+		d = newDiffer(opts.Alphabet, opts.Ambiguity, opts.Gaps)
+	}
+
+	m, err := mask.Load(opts.MaskFile, -1)
+	if err != nil {
+		return err
+	}
+
+	cols, length, err := load(panelFile, m, opts.Alphabet, d)
+	if err != nil {
+		return err
+	}
+	if length >= 0 {
+		err = m.Check(length)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows := cols
+	allVsAll := len(queryFile) == 0
+	if !allVsAll {
+		var qlength int
+		rows, qlength, err = load(queryFile, m, opts.Alphabet, d)
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 && len(cols) > 0 && qlength != length {
+			return fmt.Errorf("the queries (%s) and the panel (%s) aren't the same length", queryFile, panelFile)
+		}
+	}
+
+	cIdx := make(chan int, opts.Threads)
+	cRows := make(chan row, opts.Threads)
+	cErr := make(chan error)
+	cWriteDone := make(chan bool)
+
+	go writeRows(outfile, opts.Format, opts.Threshold, rows, cols, cRows, cErr, cWriteDone)
+
+	// the row workers stop counting a pair's differences once they're past the threshold
+	max := -1
+	if opts.Format == "sparse" {
+		max = opts.Threshold
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(opts.Threads)
+	for n := 0; n < opts.Threads; n++ {
+		go func() {
+			getRows(rows, cols, d, opts.Format == "square", allVsAll, max, cIdx, cRows)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		for i := range rows {
+			cIdx <- i
+		}
+		close(cIdx)
+		wg.Wait()
+		close(cRows)
+	}()
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cWriteDone:
+			n--
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package distance
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+func TestDifferences(t *testing.T) {
+	EA := encoding.MakeEncodingArray()
+	encode := func(s string) []byte {
+		seq := make([]byte, len(s))
+		for i := range s {
+			seq[i] = EA[s[i]]
+		}
+		return seq
+	}
+
+	tests := []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"ACGT", "ACGT", 0},
+		{"ACGT", "TGCA", 4},
+		{"ACGTN-?", "TTTTAAA", 3},
+		{"RYKM", "AGTC", 1},
+		{strings.Repeat("A", 37), strings.Repeat("C", 37), 37},
+	}
+
+	for _, test := range tests {
+		d := differences(pack(encode(test.a)), pack(encode(test.b)), -1)
+		if d != test.expected {
+			t.Errorf("problem in TestDifferences: %s %s: got %d, expected %d", test.a, test.b, d, test.expected)
+		}
+	}
+}
+
+func TestDifferencesIn(t *testing.T) {
+	EA := encoding.MakeEncodingArray()
+	a := make([]byte, 40)
+	b := make([]byte, 40)
+	for i := range a {
+		a[i] = EA['A']
+		b[i] = EA['A']
+		if i%3 == 0 {
+			b[i] = EA['C']
+		}
+	}
+	pa, pb := pack(a), pack(b)
+
+	for start := 0; start < 40; start++ {
+		for end := start; end <= 40; end++ {
+			expected := 0
+			for i := start; i < end; i++ {
+				if i%3 == 0 {
+					expected++
+				}
+			}
+			d := differencesIn(pa, pb, start, end)
+			if d != expected {
+				t.Errorf("problem in TestDifferencesIn: %d-%d: got %d, expected %d", start, end, d, expected)
+			}
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	panelFile := filepath.Join(dir, "panel.fasta")
+	err = ioutil.WriteFile(panelFile, []byte(">s1\nACGTACGT\n>s2\nACGTACGA\n>s3\nTCGTNCGA\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryFile := filepath.Join(dir, "query.fasta")
+	err = ioutil.WriteFile(queryFile, []byte(">q1\nACGTACGG\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out.tsv")
+
+	tests := []struct {
+		query     string
+		format    string
+		threshold int
+		expected  string
+	}{
+		{"", "square", -1, "\ts1\ts2\ts3\ns1\t0\t1\t2\ns2\t1\t0\t1\ns3\t2\t1\t0\n"},
+		{"", "long", -1, "query\ttarget\tdistance\ns1\ts2\t1\ns1\ts3\t2\ns2\ts3\t1\n"},
+		{"", "sparse", 1, "query\ttarget\tdistance\ns1\ts2\t1\ns2\ts3\t1\n"},
+		{queryFile, "square", -1, "\ts1\ts2\ts3\nq1\t1\t1\t2\n"},
+	}
+
+	for _, test := range tests {
+		opts := DefaultOptions()
+		opts.Format = test.format
+		opts.Threshold = test.threshold
+		opts.Threads = 2
+		err = Distance(panelFile, test.query, outFile, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "##" + version.Provenance() + "\n" + test.expected
+		if string(out) != expected {
+			t.Errorf("problem in TestDistance: %s %s: got\n%s\nexpected\n%s", test.query, test.format, out, expected)
+		}
+	}
+
+	for _, format := range []string{"sparse", "wide"} {
+		opts := DefaultOptions()
+		opts.Format = format
+		opts.Threads = 2
+		err = Distance(panelFile, "", outFile, opts)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestDistance: expected a usage error for --format %s, got %v", format, err)
+		}
+	}
+}
+
+func TestDistanceComparison(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// R could be A but not C, and s2 has a gap where the others have an N
+	panelFile := filepath.Join(dir, "panel.fasta")
+	err = ioutil.WriteFile(panelFile, []byte(">s1\nACGTNA\n>s2\nACGT-A\n>s3\nRRCTNA\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maskFile := filepath.Join(dir, "mask")
+	err = ioutil.WriteFile(maskFile, []byte("3\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out.tsv")
+
+	tests := []struct {
+		ambiguity string
+		gaps      string
+		maskFile  string
+		expected  string
+	}{
+		{"match", "skip", "", "s1\ts2\t0\ns1\ts3\t2\ns2\ts3\t2\n"},
+		{"mismatch", "skip", "", "s1\ts2\t0\ns1\ts3\t3\ns2\ts3\t3\n"},
+		{"skip", "skip", "", "s1\ts2\t0\ns1\ts3\t1\ns2\ts3\t1\n"},
+		{"match", "mismatch", "", "s1\ts2\t1\ns1\ts3\t2\ns2\ts3\t3\n"},
+		// masked sites are never a difference
+		{"mismatch", "mismatch", maskFile, "s1\ts2\t1\ns1\ts3\t2\ns2\ts3\t3\n"},
+	}
+
+	for _, test := range tests {
+		opts := DefaultOptions()
+		opts.Format = "long"
+		opts.MaskFile = test.maskFile
+		opts.Ambiguity = test.ambiguity
+		opts.Gaps = test.gaps
+		opts.Threads = 2
+		err = Distance(panelFile, "", outFile, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "##" + version.Provenance() + "\n" + "query\ttarget\tdistance\n" + test.expected
+		if string(out) != expected {
+			t.Errorf("problem in TestDistanceComparison: --ambiguity %s --gaps %s %s: got\n%s\nexpected\n%s", test.ambiguity, test.gaps, test.maskFile, out, expected)
+		}
+	}
+
+	for _, settings := range [][2]string{{"same", "skip"}, {"match", "match"}} {
+		opts := DefaultOptions()
+		opts.Format = "long"
+		opts.Ambiguity = settings[0]
+		opts.Gaps = settings[1]
+		opts.Threads = 2
+		err = Distance(panelFile, "", outFile, opts)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestDistanceComparison: expected a usage error for --ambiguity %s --gaps %s, got %v", settings[0], settings[1], err)
+		}
+	}
+}
+
+func TestDistanceProtein(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// B could be N, and X and - could be anything, but K isn't R
+	panelFile := filepath.Join(dir, "panel.faa")
+	err = ioutil.WriteFile(panelFile, []byte(">p1\nMKNLV*\n>p2\nMRBXV-\n>p3\nmkdlv*\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out.tsv")
+
+	opts := DefaultOptions()
+	opts.Alphabet = alphabet.Protein
+	opts.Threads = 2
+	err = Distance(panelFile, "", outFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "##" + version.Provenance() + "\n" + "\tp1\tp2\tp3\np1\t0\t1\t1\np2\t1\t0\t1\np3\t1\t1\t0\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestDistanceProtein: got\n%s\nexpected\n%s", out, expected)
+	}
+
+	// J isn't a nucleotide
+	err = ioutil.WriteFile(panelFile, []byte(">p1\nMKJ\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = DefaultOptions()
+	opts.Threads = 2
+	err = Distance(panelFile, "", outFile, opts)
+	if err == nil {
+		t.Error("problem in TestDistanceProtein: expected an error for amino acids read as nucleotides")
+	}
+}
@@ -0,0 +1,376 @@
+package distance
+
+import (
+	"bufio"
+	"fmt"
+	"math/bits"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/scan"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// the name of the count of queries with more than one segment in the run summary
+const putativeRecombinants = "putative_recombinants"
+
+// run is a run of windows of a query that are closest to the same parent
+type run struct {
+	parent  int
+	first   int // the index of the first window
+	last    int // the index of the last window
+	windows int // the number of windows in the run that are closest to the parent
+}
+
+// segment is a stretch of a query that is closest to one parent. start and end are 0-based, end
+// exclusive
+type segment struct {
+	parent int
+	start  int
+	end    int
+}
+
+// painting is a query's segments, and the breakpoints between them: the last site that supports
+// the parent on the left, and the first site that supports the parent on the right (0-based)
+type painting struct {
+	idx         int
+	segments    []segment
+	breakpoints [][2]int
+}
+
+// nearest is the parent with the fewest differences from the query in a window, or -1 if there
+// is a tie (or every parent is excluded)
+func nearest(query packed, parents []packed, exclude int, start int, end int) int {
+	best := -1
+	min := -1
+	tied := false
+	for j, p := range parents {
+		if j == exclude {
+			continue
+		}
+		d := differencesIn(query.seq, p.seq, start, end)
+		switch {
+		case min == -1 || d < min:
+			best, min, tied = j, d, false
+		case d == min:
+			tied = true
+		}
+	}
+	if tied {
+		return -1
+	}
+	return best
+}
+
+// getRuns groups consecutive windows that are closest to the same parent. Windows without a
+// nearest parent don't interrupt a run. Runs with fewer than minWindows windows are dropped (unless
+// every run is that short), and the runs either side of them are joined if they have the same parent
+func getRuns(assigned []int, minWindows int) []run {
+
+	runs := make([]run, 0)
+	for i, parent := range assigned {
+		switch {
+		case parent == -1:
+			continue
+		case len(runs) > 0 && runs[len(runs)-1].parent == parent:
+			runs[len(runs)-1].last = i
+			runs[len(runs)-1].windows++
+		default:
+			runs = append(runs, run{parent: parent, first: i, last: i, windows: 1})
+		}
+	}
+
+	kept := make([]run, 0, len(runs))
+	for _, r := range runs {
+		if r.windows < minWindows {
+			continue
+		}
+		if len(kept) > 0 && kept[len(kept)-1].parent == r.parent {
+			kept[len(kept)-1].last = r.last
+			kept[len(kept)-1].windows += r.windows
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if len(kept) == 0 && len(runs) > 0 {
+		return runs
+	}
+
+	return kept
+}
+
+// breakpoint finds where a query switches from parent p to parent q, between start and end: the
+// split that the most informative sites (where the query has a nucleotide that only one of the
+// parents could have) agree with. It returns the last site before the split that supports p and
+// the first site after it that supports q
+func breakpoint(query packed, p packed, q packed, start int, end int) (int, int) {
+
+	type site struct {
+		pos int
+		p   bool
+	}
+
+	sites := make([]site, 0)
+	for i := start; i < end; i++ {
+		x := nucleotides(query.seq, i)
+		a := nucleotides(p.seq, i)
+		b := nucleotides(q.seq, i)
+		if bits.OnesCount64(x) != 1 || a&b != 0 {
+			continue
+		}
+		switch {
+		case x&a != 0:
+			sites = append(sites, site{pos: i, p: true})
+		case x&b != 0:
+			sites = append(sites, site{pos: i, p: false})
+		}
+	}
+
+	// the score of splitting before sites[k] is the number of p sites before it plus the
+	// number of q sites from it on
+	score := 0
+	for _, s := range sites {
+		if !s.p {
+			score++
+		}
+	}
+	best, split := score, 0
+	for k, s := range sites {
+		if s.p {
+			score++
+		} else {
+			score--
+		}
+		if score > best {
+			best, split = score, k+1
+		}
+	}
+
+	last, first := start-1, end
+	for k := split - 1; k >= 0; k-- {
+		if sites[k].p {
+			last = sites[k].pos
+			break
+		}
+	}
+	for k := split; k < len(sites); k++ {
+		if !sites[k].p {
+			first = sites[k].pos
+			break
+		}
+	}
+
+	return last, first
+}
+
+// paint paints a query by its nearest parent in each window, and finds the breakpoints between
+// the segments. A parent with the same name as the query isn't used
+func paint(query packed, parents []packed, starts []int, size int, length int, minWindows int) painting {
+
+	exclude := -1
+	for j, p := range parents {
+		if p.name == query.name {
+			exclude = j
+		}
+	}
+
+	end := func(i int) int {
+		if starts[i]+size > length {
+			return length
+		}
+		return starts[i] + size
+	}
+
+	assigned := make([]int, len(starts))
+	for i, start := range starts {
+		assigned[i] = nearest(query, parents, exclude, start, end(i))
+	}
+
+	runs := getRuns(assigned, minWindows)
+
+	var pt painting
+	if len(runs) == 0 {
+		return pt
+	}
+
+	s := segment{parent: runs[0].parent, start: 0}
+	for i := 1; i < len(runs); i++ {
+		last, first := breakpoint(query, parents[runs[i-1].parent], parents[runs[i].parent], starts[runs[i-1].last], end(runs[i].first))
+		s.end = last + 1
+		pt.segments = append(pt.segments, s)
+		pt.breakpoints = append(pt.breakpoints, [2]int{last, first})
+		s = segment{parent: runs[i].parent, start: first}
+	}
+	s.end = length
+	pt.segments = append(pt.segments, s)
+
+	return pt
+}
+
+// getPaintings paints each query whose index it is sent
+func getPaintings(queries []packed, parents []packed, starts []int, size int, length int, minWindows int, cIdx chan int, cPaintings chan painting) {
+	for i := range cIdx {
+		pt := paint(queries[i], parents, starts, size, length, minWindows)
+		pt.idx = i
+		cPaintings <- pt
+	}
+}
+
+// writePaintings writes the paintings in the order of the queries
+func writePaintings(outfile string, queries []packed, parents []packed, cPaintings chan painting, cErr chan error, cDone chan bool) {
+
+	var f *os.File
+	var err error
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+
+	w.WriteString("##" + version.Provenance() + "\n")
+	w.WriteString("query,segments,breakpoints,mosaic\n")
+
+	recombinants := 0
+
+	write := func(pt painting) {
+		breakpoints := make([]string, 0, len(pt.breakpoints))
+		for _, b := range pt.breakpoints {
+			breakpoints = append(breakpoints, strconv.Itoa(b[0]+1)+"-"+strconv.Itoa(b[1]+1))
+		}
+		segments := make([]string, 0, len(pt.segments))
+		for _, s := range pt.segments {
+			segments = append(segments, parents[s.parent].name+":"+strconv.Itoa(s.start+1)+"-"+strconv.Itoa(s.end))
+		}
+		w.WriteString(queries[pt.idx].name + "," + strconv.Itoa(len(pt.segments)) + "," + strings.Join(breakpoints, "|") + "," + strings.Join(segments, "|") + "\n")
+		if len(pt.segments) > 1 {
+			recombinants++
+		}
+	}
+
+	outputMap := make(map[int]painting)
+	counter := 0
+
+	for pt := range cPaintings {
+		outputMap[pt.idx] = pt
+		for {
+			next, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			write(next)
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	summary.Add(summary.Processed, counter)
+	summary.Add(putativeRecombinants, recombinants)
+
+	cDone <- true
+}
+
+// Mosaic paints each query in queryFile by the sequence in parentsFile that it is closest to
+// (has the fewest SNPs from) in windows of size columns, every step columns, ignoring the columns
+// in maskFile (if it isn't empty). Windows where parents are tied aren't painted, and runs of fewer
+// than minWindows windows are ignored. Where the nearest parent changes, the breakpoint is placed
+// between the sites that support each parent. Queries are painted by threads workers (or one per
+// CPU if threads == 0)
+func Mosaic(parentsFile string, queryFile string, outfile string, size int, step int, minWindows int, maskFile string, threads int) error {
+
+	defer profiling.Region("mosaic")()
+
+	if size < 1 || step < 1 {
+		return usage.New("--window and --step must be at least 1")
+	}
+	if minWindows < 1 {
+		return usage.New("--min-windows must be at least 1")
+	}
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	m, err := mask.Load(maskFile, -1)
+	if err != nil {
+		return err
+	}
+
+	parents, length, err := loadPacked(parentsFile, m, alphabet.Nucleotide)
+	if err != nil {
+		return err
+	}
+	if len(parents) < 2 {
+		return usage.Errorf("there need to be at least two parents (%s has %d)", parentsFile, len(parents))
+	}
+	err = m.Check(length)
+	if err != nil {
+		return err
+	}
+
+	queries, qlength, err := loadPacked(queryFile, m, alphabet.Nucleotide)
+	if err != nil {
+		return err
+	}
+	if len(queries) > 0 && qlength != length {
+		return fmt.Errorf("the queries (%s) and the parents (%s) aren't the same length", queryFile, parentsFile)
+	}
+
+	starts := scan.WindowStarts(length, size, step)
+
+	cIdx := make(chan int, threads)
+	cPaintings := make(chan painting, threads)
+	cErr := make(chan error)
+	cWriteDone := make(chan bool)
+
+	go writePaintings(outfile, queries, parents, cPaintings, cErr, cWriteDone)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+	for n := 0; n < threads; n++ {
+		go func() {
+			getPaintings(queries, parents, starts, size, length, minWindows, cIdx, cPaintings)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		for i := range queries {
+			cIdx <- i
+		}
+		close(cIdx)
+		wg.Wait()
+		close(cPaintings)
+	}()
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cWriteDone:
+			n--
+		}
+	}
+
+	return nil
+}
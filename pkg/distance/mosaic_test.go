@@ -0,0 +1,86 @@
+package distance
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+func TestMosaic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// B differs from A at every fourth site. q1 is A up to 32 and B after it, so its breakpoint
+	// is between the last site that supports A (29) and the first that supports B (33)
+	a := strings.Repeat("ACGT", 16)
+	b := strings.Repeat("GCGT", 16)
+
+	parentsFile := filepath.Join(dir, "parents.fasta")
+	err = ioutil.WriteFile(parentsFile, []byte(">A\n"+a+"\n>B\n"+b+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryFile := filepath.Join(dir, "query.fasta")
+	err = ioutil.WriteFile(queryFile, []byte(">q1\n"+a[:32]+b[32:]+"\n>q2\n"+a+"\n>q3\n"+strings.Repeat("N", 64)+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out.csv")
+
+	err = Mosaic(parentsFile, queryFile, outFile, 16, 8, 1, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "##" + version.Provenance() + "\n" +
+		"query,segments,breakpoints,mosaic\n" +
+		"q1,2,29-33,A:1-29|B:33-64\n" +
+		"q2,1,,A:1-64\n" +
+		"q3,0,,\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestMosaic: got\n%s\nexpected\n%s", out, expected)
+	}
+
+	err = Mosaic(queryFile, queryFile, outFile, 0, 8, 1, "", 2)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestMosaic: expected a usage error for --window 0, got %v", err)
+	}
+}
+
+func TestGetRuns(t *testing.T) {
+	tests := []struct {
+		assigned   []int
+		minWindows int
+		expected   []int // the parents of the runs
+	}{
+		{[]int{0, 0, -1, 0, 1, 1}, 1, []int{0, 1}},
+		{[]int{0, 0, 1, 0, 0, 2, 2}, 2, []int{0, 2}},
+		{[]int{0, 1, 0}, 2, []int{0, 1, 0}},
+		{[]int{-1, -1}, 1, []int{}},
+	}
+
+	for _, test := range tests {
+		runs := getRuns(test.assigned, test.minWindows)
+		parents := make([]int, 0, len(runs))
+		for _, r := range runs {
+			parents = append(parents, r.parent)
+		}
+		if !reflect.DeepEqual(parents, test.expected) {
+			t.Errorf("problem in TestGetRuns: %v: got %v, expected %v", test.assigned, parents, test.expected)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package distance
+
+import (
+	"math/bits"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// the low bit of each nibble of a uint64
+const lowBits = 0x1111111111111111
+
+// pack packs the nucleotide sets (the high four bits of the encoding package's codes, so that
+// two bases can be the same if their sets overlap) of an encoded sequence into 16 bases per
+// uint64. The end of the last word is padded with Ns, which are never different
+func pack(seq []byte) []uint64 {
+	packed := make([]uint64, (len(seq)+15)/16)
+	for i := range packed {
+		packed[i] = ^uint64(0)
+	}
+	for i, nuc := range seq {
+		shift := uint(i%16) * 4
+		packed[i/16] &^= 0xf << shift
+		packed[i/16] |= uint64(nuc>>4) << shift
+	}
+	return packed
+}
+
+// differences counts the sites at which two packed sequences can't be the same (i.e. the
+// number of SNPs between them). It stops counting once it is past max, if max >= 0
+func differences(a []uint64, b []uint64, max int) int {
+	d := 0
+	for i := range a {
+		// fold each nibble of a & b into its low bit, which is then set if the sets overlap
+		z := a[i] & b[i]
+		z |= z >> 1
+		z |= z >> 2
+		d += 16 - bits.OnesCount64(z&lowBits)
+		if max >= 0 && d > max {
+			return d
+		}
+	}
+	return d
+}
+
+// differ is whether each pair of encoded characters is a difference between two aligned sequences,
+// for sequences that aren't packed: those that aren't nucleotides, or that are compared with
+// non-default --ambiguity or --gaps. The 0 character (which no character is encoded as) is a masked
+// site, which is never a difference
+type differ [256][256]bool
+
+// checkComparison makes sure that --ambiguity and --gaps are things we know how to do
+func checkComparison(ambiguity string, gaps string) error {
+	switch ambiguity {
+	case "match", "mismatch", "skip":
+	default:
+		return usage.Errorf("unrecognised --ambiguity: %s (choose one of: match, mismatch, skip)", ambiguity)
+	}
+	switch gaps {
+	case "skip", "mismatch":
+	default:
+		return usage.Errorf("unrecognised --gaps: %s (choose one of: skip, mismatch)", gaps)
+	}
+	return nil
+}
+
+// newDiffer makes the table of differences between the characters of alphabet a. A gap (if either
+// character is one) is never a difference if gaps is "skip", and is a difference from anything but
+// another gap if it is "mismatch". Otherwise, an ambiguous character (if either is one) isn't a
+// difference from the residues that it could be if ambiguity is "match", is a difference from
+// anything but the same character if it is "mismatch", and is never a difference if it is "skip"
+func newDiffer(a *alphabet.Alphabet, ambiguity string, gaps string) *differ {
+	var d differ
+	gap := a.EncodedGap()
+	for x := 1; x < 256; x++ {
+		for y := 1; y < 256; y++ {
+			X, Y := byte(x), byte(y)
+			switch {
+			case X == gap || Y == gap:
+				d[x][y] = gaps == "mismatch" && X != Y
+			case a.Ambiguous(X) || a.Ambiguous(Y):
+				switch ambiguity {
+				case "match":
+					d[x][y] = !a.Same(X, Y)
+				case "mismatch":
+					d[x][y] = X != Y
+				}
+			default:
+				d[x][y] = !a.Same(X, Y)
+			}
+		}
+	}
+	return &d
+}
+
+// residueDifferences counts the sites at which two encoded sequences are different according to
+// d, for sequences that aren't packed. It stops counting once it is past max, if max >= 0
+func residueDifferences(d *differ, x []byte, y []byte, max int) int {
+	n := 0
+	for i := range x {
+		if d[x[i]][y[i]] {
+			n++
+			if max >= 0 && n > max {
+				return n
+			}
+		}
+	}
+	return n
+}
+
+// differencesIn counts the differences between two packed sequences in the sites from start up to
+// (but not including) end
+func differencesIn(a []uint64, b []uint64, start int, end int) int {
+	d := 0
+	for i := start / 16; i*16 < end; i++ {
+		z := a[i] & b[i]
+		z |= z >> 1
+		z |= z >> 2
+		// the low bit of each nibble that is in the range
+		in := uint64(lowBits)
+		if i*16 < start {
+			in &= lowBits << (uint(start-i*16) * 4)
+		}
+		if (i+1)*16 > end {
+			in &= lowBits >> (uint((i+1)*16-end) * 4)
+		}
+		d += bits.OnesCount64(in) - bits.OnesCount64(z&in)
+	}
+	return d
+}
+
+// nucleotides is the nucleotide set (as in pack) at site i of a packed sequence
+func nucleotides(a []uint64, i int) uint64 {
+	return (a[i/16] >> (uint(i%16) * 4)) & 0xf
+}
@@ -12,6 +12,7 @@ func MakeByteDict() map[rune]uint8 {
 	byteMap[71] = 72
 	byteMap[67] = 40
 	byteMap[84] = 24
+	byteMap[85] = 24
 	byteMap[82] = 192
 	byteMap[77] = 160
 	byteMap[87] = 144
@@ -38,6 +39,7 @@ func MakeByteDict2() map[byte]uint8 {
 	byteMap['G'] = 72
 	byteMap['C'] = 40
 	byteMap['T'] = 24
+	byteMap['U'] = 24
 	byteMap['R'] = 192
 	byteMap['M'] = 160
 	byteMap['W'] = 144
@@ -73,6 +75,8 @@ func MakeEncodingArray() [256]byte {
 	byteArray['c'] = 40
 	byteArray['T'] = 24
 	byteArray['t'] = 24
+	byteArray['U'] = 24 // RNA is read as DNA
+	byteArray['u'] = 24
 	byteArray['R'] = 192
 	byteArray['r'] = 192
 	byteArray['M'] = 160
@@ -181,6 +185,8 @@ func MakeScoreArray() [256]int64 {
 	byteArray['c'] = 12
 	byteArray['T'] = 12
 	byteArray['t'] = 12
+	byteArray['U'] = 12
+	byteArray['u'] = 12
 	byteArray['R'] = 6
 	byteArray['r'] = 6
 	byteArray['M'] = 6
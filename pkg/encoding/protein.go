@@ -0,0 +1,69 @@
+package encoding
+
+import (
+	"strings"
+)
+
+// proteinCodes are the IUPAC amino acid codes (the 20 standard amino acids, selenocysteine,
+// pyrrolysine and stop, then the ambiguity codes and a gap), in the order of their encodings
+const proteinCodes = "ACDEFGHIKLMNPQRSTVWYUO*BZJX-"
+
+// the number of unambiguous codes at the start of proteinCodes
+const aminoAcids = 23
+
+// proteinAmbiguities are the unambiguous codes that each ambiguous code could be. As in the
+// BLOSUM matrices, B is D or N, Z is E or Q, and X is any amino acid. J (I or L) is from the
+// IUPAC codes. A gap could be anything, so that (like a nucleotide gap) it is never a difference
+var proteinAmbiguities = map[byte]string{
+	'B': "DN",
+	'Z': "EQ",
+	'J': "IL",
+	'X': "ACDEFGHIKLMNPQRSTVWYUO",
+	'-': "ACDEFGHIKLMNPQRSTVWYUO*",
+}
+
+// MakeProteinEncodingArray returns an array whose indices are the byte representations of
+// IUPAC amino acid codes (in either case) and whose contents are their encodings, which are
+// from 1 to 28. Anything else is encoded as 0
+func MakeProteinEncodingArray() [256]byte {
+	var byteArray [256]byte
+
+	for i := 0; i < len(proteinCodes); i++ {
+		byteArray[proteinCodes[i]] = byte(i + 1)
+		if proteinCodes[i] >= 'A' && proteinCodes[i] <= 'Z' {
+			byteArray[proteinCodes[i]+'a'-'A'] = byte(i + 1)
+		}
+	}
+
+	return byteArray
+}
+
+// MakeProteinDecodingArray returns an array whose indices are encoded amino acids (see
+// MakeProteinEncodingArray) and whose contents are IUPAC codes as strings
+func MakeProteinDecodingArray() [256]string {
+	var stringArray [256]string
+
+	for i := 0; i < len(proteinCodes); i++ {
+		stringArray[i+1] = proteinCodes[i : i+1]
+	}
+
+	return stringArray
+}
+
+// MakeProteinSetArray returns an array whose indices are encoded amino acids and whose
+// contents are the set of amino acids (one bit each) that they could be, so that two
+// encoded amino acids could be the same if their sets overlap
+func MakeProteinSetArray() [256]uint32 {
+	var setArray [256]uint32
+
+	for i := 0; i < aminoAcids; i++ {
+		setArray[i+1] = 1 << uint(i)
+	}
+	for i := aminoAcids; i < len(proteinCodes); i++ {
+		for _, aa := range []byte(proteinAmbiguities[proteinCodes[i]]) {
+			setArray[i+1] |= setArray[strings.IndexByte(proteinCodes, aa)+1]
+		}
+	}
+
+	return setArray
+}
@@ -0,0 +1,102 @@
+/*
+Package faidx indexes fasta files (in the same .fai format as samtools faidx) and
+extracts records from them by name, without reading the rest of the file.
+*/
+package faidx
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/remote"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// readNames reads one record name per line (the first field of each line), skipping blank lines
+func readNames(namesFile string) ([]string, error) {
+
+	f, err := os.Open(namesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make([]string, 0)
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+
+	return names, s.Err()
+}
+
+// Faidx writes fastaFile's index to fastaFile.fai if it isn't there or is out of date (unless
+// fastaFile is remote).
+// If any names are given (as arguments, or in namesFile), those records are then written
+// to outfile in fasta format, in the order they were given
+func Faidx(fastaFile string, names []string, namesFile string, outfile string) error {
+
+	if fastaFile == "stdin" {
+		return usage.New("faidx needs a fasta file (--infile) to index, not stdin")
+	}
+
+	// a remote file's index can't be written next to it, so it is built each time it's needed
+	if !fastaio.HasIndex(fastaFile) && !remote.IsURL(fastaFile) {
+		idx, err := fastaio.BuildIndex(fastaFile)
+		if err != nil {
+			return err
+		}
+		err = fastaio.WriteIndex(idx, fastaio.IndexPath(fastaFile))
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 && len(namesFile) == 0 {
+			summary.Read(fastaFile, len(idx.Entries))
+		}
+	}
+
+	if len(namesFile) > 0 {
+		fileNames, err := readNames(namesFile)
+		if err != nil {
+			return err
+		}
+		names = append(names, fileNames...)
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	records, err := fastaio.ReadIndexedRecords(fastaFile, names)
+	if err != nil {
+		return err
+	}
+
+	var f *os.File
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+	for _, FR := range records {
+		_, err = w.WriteString(">" + FR.ID + "\n" + FR.Seq + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,59 @@
+package fastaio
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// ReadClustal reads a Clustal (.aln) format alignment into a slice of FastaRecords,
+// in the order that the sequences first appear in the file. Conservation lines and
+// the optional cumulative residue counts at the end of each line are ignored.
+func ReadClustal(r io.Reader) ([]FastaRecord, error) {
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+
+	records := make([]FastaRecord, 0)
+	lookup := make(map[string]int)
+
+	first := true
+
+	for s.Scan() {
+		line := s.Text()
+
+		if first {
+			if !strings.HasPrefix(line, "CLUSTAL") {
+				return []FastaRecord{}, errors.New("badly formatted clustal file")
+			}
+			first = false
+			continue
+		}
+
+		// conservation lines start with whitespace, and blocks are separated
+		// by blank lines
+		if len(strings.TrimSpace(line)) == 0 || unicode.IsSpace(rune(line[0])) {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 3 {
+			return []FastaRecord{}, errors.New("badly formatted clustal file: couldn't parse line: " + line)
+		}
+
+		records = appendAlignmentBlockLine(records, lookup, fields[0], strings.ToUpper(fields[1]))
+	}
+
+	err := s.Err()
+	if err != nil {
+		return []FastaRecord{}, err
+	}
+
+	if first {
+		return []FastaRecord{}, errors.New("empty clustal file")
+	}
+
+	return records, nil
+}
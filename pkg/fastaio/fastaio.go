@@ -6,7 +6,12 @@ import (
 	"bufio"
 	"errors"
 	"strings"
+	"io"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
 	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/remote"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/usage"
 )
 
 // FastaRecord is a simple struct for Fasta records
@@ -30,17 +35,26 @@ func getAlignmentDims(infile string) (int, int, error) {
 	n := 0
 	l := 0
 
-	f, err := os.Open(infile)
+	f, err := remote.Open(infile)
 	if err != nil {
 		return 0, 0, err
 	}
 	defer f.Close()
 
-	s := bufio.NewScanner(f)
+	r, err := normaliseAlignment(f)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	s := bufio.NewScanner(r)
 
 	for s.Scan() {
 		line := s.Text()
 
+		if len(line) == 0 {
+			continue
+		}
+
 		if string(line[0]) == ">" {
 			n++
 		}
@@ -59,21 +73,59 @@ func getAlignmentDims(infile string) (int, int, error) {
 	return n, l, err
 }
 
-// ReadAlignment reads an alignment in fasta format to a channel
+// warnEmpty writes a warning that there are no records in infile. An empty input isn't an
+// error: whatever is made from it is empty too
+func warnEmpty(infile string) {
+	os.Stderr.WriteString("warning: there are no sequences in " + infile + "\n")
+}
+
+// ReadAlignment reads an alignment in fasta format (or written by gofasta encode) to a channel
 // of FastaRecord structs
 func ReadAlignment(infile string, chnl chan FastaRecord, chnlerr chan error, cdone chan bool) {
 
-	f, err := os.Open(infile)
+	var err error
+	var f io.ReadCloser
+
+	if IsEncoded(infile) {
+		decoding := encoding.MakeDecodingArray()
+		err = readEncodedFile(infile, func(EFR EncodedFastaRecord) error {
+			var seq strings.Builder
+			for _, nuc := range EFR.Seq {
+				seq.WriteString(decoding[nuc])
+			}
+			chnl <- FastaRecord{ID: EFR.ID, Description: EFR.Description, Seq: seq.String(), Idx: EFR.Idx}
+			return nil
+		})
+		if err != nil {
+			chnlerr <- err
+			return
+		}
+		cdone <- true
+		return
+	}
 
-	if err != nil {
-		chnlerr <- err
+	if infile != "stdin" {
+		f, err = remote.Open(infile)
+		if err != nil {
+			chnlerr <- err
+			return
+		}
+	} else {
+		f = os.Stdin
 	}
 
 	defer f.Close()
 
-	s := bufio.NewScanner(f)
+	r, err := normaliseAlignment(f)
+	if err != nil {
+		chnlerr <- err
+		return
+	}
+
+	s := bufio.NewScanner(r)
 
 	first := true
+	counter := 0
 
 	var id string
 	var description string
@@ -82,6 +134,10 @@ func ReadAlignment(infile string, chnl chan FastaRecord, chnlerr chan error, cdo
 	for s.Scan() {
 		line := string(s.Text())
 
+		if len(line) == 0 {
+			continue
+		}
+
 		if first {
 
 			if string(line[0]) != ">" {
@@ -97,25 +153,33 @@ func ReadAlignment(infile string, chnl chan FastaRecord, chnlerr chan error, cdo
 
 			fr := FastaRecord{ID: id, Description: description, Seq: seqBuffer}
 			chnl <- fr
+			counter++
 
 			description = line[1:]
 			id = strings.Fields(description)[0]
 			seqBuffer = ""
 
 		} else {
-			seqBuffer = seqBuffer + strings.ToUpper(line)
+			seqBuffer = seqBuffer + alphabet.ToDNA(strings.ToUpper(line))
 		}
 
 	}
 
-	fr := FastaRecord{ID: id, Description: description, Seq: seqBuffer}
-	chnl <- fr
-
 	err = s.Err()
 	if err != nil {
 		chnlerr <- err
+		return
+	}
+
+	if first {
+		warnEmpty(infile)
+	} else {
+		chnl <- FastaRecord{ID: id, Description: description, Seq: seqBuffer}
+		counter++
 	}
 
+	summary.Read(infile, counter)
+
 	cdone <- true
 }
 
@@ -181,17 +245,41 @@ func PopulateByteArrayGetNames(infile string) ([][]uint8, []string, error) {
 }
 
 
-// ReadEncodeAlignment reads an alignment in fasta format to a channel
+// ReadEncodeAlignment reads an alignment in fasta format (or written by gofasta encode) to a channel
 // of encodedFastaRecord structs - converting sequence to EP's bitwise coding scheme
 func ReadEncodeAlignment(inFile string, chnl chan EncodedFastaRecord, cErr chan error, cDone chan bool) {
+	ReadEncodeAlignmentIn(inFile, alphabet.Nucleotide, chnl, cErr, cDone)
+}
+
+// ReadEncodeAlignmentIn is ReadEncodeAlignment for an alignment of sequences in alphabet a,
+// which are encoded as a.Encoding() says
+func ReadEncodeAlignmentIn(inFile string, a *alphabet.Alphabet, chnl chan EncodedFastaRecord, cErr chan error, cDone chan bool) {
 
 	var err error
-	var f *os.File
+	var f io.ReadCloser
+
+	if IsEncoded(inFile) {
+		if a != alphabet.Nucleotide {
+			cErr <- usage.Errorf("%s is an encoded alignment, which can only be of nucleotides", inFile)
+			return
+		}
+		err = readEncodedFile(inFile, func(EFR EncodedFastaRecord) error {
+			chnl <- EFR
+			return nil
+		})
+		if err != nil {
+			cErr <- err
+			return
+		}
+		cDone <- true
+		return
+	}
 
 	if inFile != "stdin" {
-		f, err = os.Open(inFile)
+		f, err = remote.Open(inFile)
 		if err != nil {
 			cErr <- err
+			return
 		}
 	} else {
 		f = os.Stdin
@@ -199,9 +287,15 @@ func ReadEncodeAlignment(inFile string, chnl chan EncodedFastaRecord, cErr chan
 
 	defer f.Close()
 
-	coding := encoding.MakeEncodingArray()
+	r, err := normaliseAlignment(f)
+	if err != nil {
+		cErr <- err
+		return
+	}
 
-	s := bufio.NewScanner(f)
+	coding := a.Encoding()
+
+	s := bufio.NewScanner(r)
 
 	first := true
 
@@ -219,10 +313,15 @@ func ReadEncodeAlignment(inFile string, chnl chan EncodedFastaRecord, cErr chan
 	for s.Scan() {
 		line = s.Bytes()
 
+		if len(line) == 0 {
+			continue
+		}
+
 		if first {
 
 			if line[0] != '>' {
 				cErr <- errors.New("badly formatted fasta file")
+				return
 			}
 
 			description = string(line[1:])
@@ -236,6 +335,7 @@ func ReadEncodeAlignment(inFile string, chnl chan EncodedFastaRecord, cErr chan
 				width = len(seqBuffer)
 			} else if len(seqBuffer) != width {
 				cErr<- errors.New("different length sequences in input file: is this an alignment?")
+				return
 			}
 
 			fr = EncodedFastaRecord{ID: id, Description: description, Seq: seqBuffer, Idx: counter}
@@ -251,7 +351,8 @@ func ReadEncodeAlignment(inFile string, chnl chan EncodedFastaRecord, cErr chan
 			for i := range(line) {
 				nuc = coding[line[i]]
 				if nuc == 0 {
-					cErr<- fmt.Errorf("invalid nucleotide in fasta file (%s)", string(line[i]))
+					cErr<- fmt.Errorf("invalid %s in fasta file (%s)", a.Residue, string(line[i]))
+					return
 				}
 				encodedLine[i] = nuc
 			}
@@ -259,24 +360,49 @@ func ReadEncodeAlignment(inFile string, chnl chan EncodedFastaRecord, cErr chan
 		}
 	}
 
-	fr = EncodedFastaRecord{ID: id, Description: description, Seq: seqBuffer, Idx: counter}
-	chnl <- fr
-
 	err = s.Err()
 	if err != nil {
 		cErr <- err
+		return
+	}
+
+	if first {
+		warnEmpty(inFile)
+	} else {
+		chnl <- EncodedFastaRecord{ID: id, Description: description, Seq: seqBuffer, Idx: counter}
+		counter++
 	}
 
+	summary.Read(inFile, counter)
+
 	cDone <- true
 }
 
 func ReadEncodeAlignmentToList(inFile string) ([]EncodedFastaRecord, error) {
+	return ReadEncodeAlignmentToListIn(inFile, alphabet.Nucleotide)
+}
+
+// ReadEncodeAlignmentToListIn is ReadEncodeAlignmentToList for an alignment of sequences in
+// alphabet a
+func ReadEncodeAlignmentToListIn(inFile string, a *alphabet.Alphabet) ([]EncodedFastaRecord, error) {
 
 	var err error
-	var f *os.File
+	var f io.ReadCloser
+
+	if IsEncoded(inFile) {
+		if a != alphabet.Nucleotide {
+			return []EncodedFastaRecord{}, usage.Errorf("%s is an encoded alignment, which can only be of nucleotides", inFile)
+		}
+		records := make([]EncodedFastaRecord, 0)
+		err = readEncodedFile(inFile, func(EFR EncodedFastaRecord) error {
+			records = append(records, EFR)
+			return nil
+		})
+		return records, err
+	}
 
 	if inFile != "stdin" {
-		f, err = os.Open(inFile)
+		f, err = remote.Open(inFile)
 		if err != nil {
 			return []EncodedFastaRecord{}, err
 		}
@@ -286,11 +412,16 @@ func ReadEncodeAlignmentToList(inFile string) ([]EncodedFastaRecord, error) {
 
 	defer f.Close()
 
+	r, err := normaliseAlignment(f)
+	if err != nil {
+		return []EncodedFastaRecord{}, err
+	}
+
 	records := make([]EncodedFastaRecord, 0)
 
-	coding := encoding.MakeEncodingArray()
+	coding := a.Encoding()
 
-	s := bufio.NewScanner(f)
+	s := bufio.NewScanner(r)
 
 	first := true
 
@@ -306,6 +437,10 @@ func ReadEncodeAlignmentToList(inFile string) ([]EncodedFastaRecord, error) {
 	for s.Scan() {
 		line = s.Bytes()
 
+		if len(line) == 0 {
+			continue
+		}
+
 		if first {
 
 			if line[0] != '>' {
@@ -338,7 +473,7 @@ func ReadEncodeAlignmentToList(inFile string) ([]EncodedFastaRecord, error) {
 			for i := range(line) {
 				nuc = coding[line[i]]
 				if nuc == 0 {
-					return []EncodedFastaRecord{}, fmt.Errorf("invalid nucleotide in fasta file (%s)", string(line[i]))
+					return []EncodedFastaRecord{}, fmt.Errorf("invalid %s in fasta file (%s)", a.Residue, string(line[i]))
 				}
 				encodedLine[i] = nuc
 			}
@@ -346,26 +481,32 @@ func ReadEncodeAlignmentToList(inFile string) ([]EncodedFastaRecord, error) {
 		}
 	}
 
-	fr := EncodedFastaRecord{ID: id, Description: description, Seq: seqBuffer, Idx: counter}
-	records = append(records, fr)
-
 	err = s.Err()
 	if err != nil {
 		return []EncodedFastaRecord{}, err
 	}
 
+	if first {
+		warnEmpty(inFile)
+	} else {
+		records = append(records, EncodedFastaRecord{ID: id, Description: description, Seq: seqBuffer, Idx: counter})
+	}
+
+	summary.Read(inFile, len(records))
+
 	return records, nil
 }
 
 func ReadEncodeScoreAlignment(inFile string, chnl chan EncodedFastaRecord, cErr chan error, cDone chan bool) {
 
 	var err error
-	var f *os.File
+	var f io.ReadCloser
 
 	if inFile != "stdin" {
-		f, err = os.Open(inFile)
+		f, err = remote.Open(inFile)
 		if err != nil {
 			cErr <- err
+			return
 		}
 	} else {
 		f = os.Stdin
@@ -373,10 +514,16 @@ func ReadEncodeScoreAlignment(inFile string, chnl chan EncodedFastaRecord, cErr
 
 	defer f.Close()
 
+	r, err := normaliseAlignment(f)
+	if err != nil {
+		cErr <- err
+		return
+	}
+
 	coding := encoding.MakeEncodingArray()
 	scoring := encoding.MakeScoreArray()
 
-	s := bufio.NewScanner(f)
+	s := bufio.NewScanner(r)
 
 	first := true
 
@@ -393,10 +540,15 @@ func ReadEncodeScoreAlignment(inFile string, chnl chan EncodedFastaRecord, cErr
 	for s.Scan() {
 		line = s.Bytes()
 
+		if len(line) == 0 {
+			continue
+		}
+
 		if first {
 
 			if line[0] != '>' {
 				cErr <- errors.New("badly formatted fasta file")
+				return
 			}
 
 			description = string(line[1:])
@@ -410,6 +562,7 @@ func ReadEncodeScoreAlignment(inFile string, chnl chan EncodedFastaRecord, cErr
 				width = len(seqBuffer)
 			} else if len(seqBuffer) != width {
 				cErr<- errors.New("different length sequences in input file: is this an alignment?")
+				return
 			}
 
 			fr := EncodedFastaRecord{ID: id, Description: description, Seq: seqBuffer, Score: score, Idx: counter}
@@ -427,6 +580,7 @@ func ReadEncodeScoreAlignment(inFile string, chnl chan EncodedFastaRecord, cErr
 				nuc = coding[line[i]]
 				if nuc == 0 {
 					cErr<- fmt.Errorf("invalid nucleotide in fasta file (%s)", string(line[i]))
+					return
 				}
 				encodedLine[i] = nuc
 
@@ -436,13 +590,20 @@ func ReadEncodeScoreAlignment(inFile string, chnl chan EncodedFastaRecord, cErr
 		}
 	}
 
-	fr := EncodedFastaRecord{ID: id, Description: description, Seq: seqBuffer, Score: score, Idx: counter}
-	chnl <- fr
-
 	err = s.Err()
 	if err != nil {
 		cErr <- err
+		return
 	}
 
+	if first {
+		warnEmpty(inFile)
+	} else {
+		chnl <- EncodedFastaRecord{ID: id, Description: description, Seq: seqBuffer, Score: score, Idx: counter}
+		counter++
+	}
+
+	summary.Read(inFile, counter)
+
 	cDone <- true
 }
@@ -0,0 +1,315 @@
+package fastaio
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+)
+
+func TestReadStockholm(t *testing.T) {
+	sto := `# STOCKHOLM 1.0
+#=GF ID test
+#=GS seq1 DE a sequence
+seq1 ACGT..AC
+seq2 acgtTTAC
+#=GC SS_cons ........
+
+seq1 GG
+seq2 G-
+//
+`
+	records, err := ReadStockholm(strings.NewReader(sto))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(records) != 2 {
+		t.Errorf("problem in TestReadStockholm: wrong number of records (%d)", len(records))
+	}
+	if records[0].ID != "seq1" || records[0].Seq != "ACGT--ACGG" {
+		t.Errorf("problem in TestReadStockholm: %s %s", records[0].ID, records[0].Seq)
+	}
+	if records[1].ID != "seq2" || records[1].Seq != "ACGTTTACG-" || records[1].Idx != 1 {
+		t.Errorf("problem in TestReadStockholm: %s %s", records[1].ID, records[1].Seq)
+	}
+
+	_, err = ReadStockholm(strings.NewReader(">seq1\nACGT\n"))
+	if err == nil {
+		t.Errorf("problem in TestReadStockholm: expected an error for non-stockholm input")
+	}
+}
+
+func TestReadClustal(t *testing.T) {
+	aln := `CLUSTAL W (1.83) multiple sequence alignment
+
+seq1      ACGT--AC 6
+seq2      ACGTTTAC 8
+          ****  **
+
+seq1      GG 8
+seq2      G- 9
+          *
+`
+	records, err := ReadClustal(strings.NewReader(aln))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(records) != 2 {
+		t.Errorf("problem in TestReadClustal: wrong number of records (%d)", len(records))
+	}
+	if records[0].ID != "seq1" || records[0].Seq != "ACGT--ACGG" {
+		t.Errorf("problem in TestReadClustal: %s %s", records[0].ID, records[0].Seq)
+	}
+	if records[1].ID != "seq2" || records[1].Seq != "ACGTTTACG-" {
+		t.Errorf("problem in TestReadClustal: %s %s", records[1].ID, records[1].Seq)
+	}
+}
+
+func TestReadFasta(t *testing.T) {
+	records, err := ReadFasta(strings.NewReader(">seq1 first one\nacgt\nAC\r\n\n>seq2\nACGuUU\n"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("problem in TestReadFasta: wrong number of records (%d)", len(records))
+	}
+	if records[0].ID != "seq1" || records[0].Description != "seq1 first one" || records[0].Seq != "ACGTAC" {
+		t.Errorf("problem in TestReadFasta: %s %s %s", records[0].ID, records[0].Description, records[0].Seq)
+	}
+	if records[1].ID != "seq2" || records[1].Seq != "ACGTTT" || records[1].Idx != 1 {
+		t.Errorf("problem in TestReadFasta: %s %s %d", records[1].ID, records[1].Seq, records[1].Idx)
+	}
+
+	_, err = ReadFasta(strings.NewReader("ACGT\n"))
+	if err == nil {
+		t.Error("problem in TestReadFasta: expected an error for a file without a header")
+	}
+}
+
+func TestReadFastq(t *testing.T) {
+	f, err := os.CreateTemp("", "*.fastq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("@read1 a description\nACGTAC\n+\nII#II5\n@read2\nacgt\n+read2\nIIII\n")
+	f.Close()
+
+	cFQ := make(chan FastqRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go ReadFastq(f.Name(), cFQ, cErr, cDone)
+
+	records := make([]FastqRecord, 0)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			t.Fatal(err)
+		case FQ := <-cFQ:
+			records = append(records, FQ)
+		case <-cDone:
+			n--
+		}
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("problem in TestReadFastq: wrong number of records (%d)", len(records))
+	}
+	if records[0].ID != "read1" || records[0].Description != "read1 a description" || records[0].Qual[2] != 2 {
+		t.Errorf("problem in TestReadFastq: %s %s %v", records[0].ID, records[0].Description, records[0].Qual)
+	}
+	if records[1].Seq != "ACGT" || records[1].Idx != 1 {
+		t.Errorf("problem in TestReadFastq: %s %d", records[1].Seq, records[1].Idx)
+	}
+
+	masked := MaskFastqRecord(records[0], 20)
+	if masked.Seq != "ACNTAC" {
+		t.Errorf("problem in TestReadFastq: bad masking: %s", masked.Seq)
+	}
+}
+
+func BenchmarkReadEncodeAlignment(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+
+	f, err := os.CreateTemp(b.TempDir(), "*.fasta")
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = testutil.WriteFasta(f, testutil.RandomAlignment(r, testutil.RandomSeq(r, 30000), 1000, 0.001))
+	f.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := ReadEncodeAlignmentToList(f.Name())
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReadEmpty(t *testing.T) {
+	dir := t.TempDir()
+
+	// an empty file, and one with nothing but blank lines, have no records
+	for _, contents := range []string{"", "\n\n"} {
+		file := filepath.Join(dir, "empty.fasta")
+		err := os.WriteFile(file, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cFR := make(chan FastaRecord)
+		cEFR := make(chan EncodedFastaRecord)
+		cErr := make(chan error)
+		cDone := make(chan bool)
+
+		go ReadAlignment(file, cFR, cErr, cDone)
+		go ReadEncodeAlignment(file, cEFR, cErr, cDone)
+		go ReadEncodeScoreAlignment(file, cEFR, cErr, cDone)
+
+		for n := 3; n > 0; {
+			select {
+			case err := <-cErr:
+				t.Fatal(err)
+			case FR := <-cFR:
+				t.Errorf("problem in TestReadEmpty: got a record (%q) from %q", FR.ID, contents)
+			case EFR := <-cEFR:
+				t.Errorf("problem in TestReadEmpty: got a record (%q) from %q", EFR.ID, contents)
+			case <-cDone:
+				n--
+			}
+		}
+
+		records, err := ReadEncodeAlignmentToList(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 0 {
+			t.Errorf("problem in TestReadEmpty: ReadEncodeAlignmentToList got %d records from %q", len(records), contents)
+		}
+	}
+
+	// blank lines between records are skipped
+	file := filepath.Join(dir, "blank.fasta")
+	err := os.WriteFile(file, []byte(">s1\nACGT\n\n>s2\nAC\n\nGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadEncodeAlignmentToList(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].ID != "s2" || len(records[1].Seq) != 4 {
+		t.Errorf("problem in TestReadEmpty: got %v", records)
+	}
+}
+
+func TestReadCRLF(t *testing.T) {
+	dir := t.TempDir()
+
+	fasta := ">s1 first\nACGT\nAC\n>s2\nNNGT\n-C\n"
+	lf := filepath.Join(dir, "lf.fasta")
+	crlf := filepath.Join(dir, "crlf.fasta")
+	for file, contents := range map[string]string{lf: fasta, crlf: testutil.CRLF(fasta)} {
+		err := os.WriteFile(file, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	readAll := func(file string) []FastaRecord {
+		cFR := make(chan FastaRecord)
+		cErr := make(chan error)
+		cDone := make(chan bool)
+		go ReadAlignment(file, cFR, cErr, cDone)
+		records := make([]FastaRecord, 0)
+		for n := 1; n > 0; {
+			select {
+			case err := <-cErr:
+				t.Fatal(err)
+			case FR := <-cFR:
+				records = append(records, FR)
+			case <-cDone:
+				n--
+			}
+		}
+		return records
+	}
+	if got, expected := readAll(crlf), readAll(lf); !reflect.DeepEqual(got, expected) {
+		t.Errorf("problem in TestReadCRLF: ReadAlignment got %v, expected %v", got, expected)
+	}
+
+	expected, err := ReadEncodeAlignmentToList(lf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadEncodeAlignmentToList(crlf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("problem in TestReadCRLF: ReadEncodeAlignmentToList got %v, expected %v", got, expected)
+	}
+
+	ma, err := OpenMapped(crlf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ma.Close()
+	if got := readMapped(t, ma); !reflect.DeepEqual(got, expected) {
+		t.Errorf("problem in TestReadCRLF: a mapped alignment got %v, expected %v", got, expected)
+	}
+
+	// the index counts the carriage returns in each line's width
+	idx, err := BuildIndex(crlf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(crlf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	FR, err := idx.Fetch(f, "s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FR.Seq != "NNGT-C" {
+		t.Errorf("problem in TestReadCRLF: Fetch got %s", FR.Seq)
+	}
+
+	fastq := filepath.Join(dir, "crlf.fastq")
+	err = os.WriteFile(fastq, []byte(testutil.CRLF("@read1\nACGT\n+\nII#I\n")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cFQ := make(chan FastqRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+	go ReadFastq(fastq, cFQ, cErr, cDone)
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			t.Fatal(err)
+		case FQ := <-cFQ:
+			if FQ.ID != "read1" || FQ.Seq != "ACGT" || len(FQ.Qual) != 4 {
+				t.Errorf("problem in TestReadCRLF: ReadFastq got %+v", FQ)
+			}
+		case <-cDone:
+			n--
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package fastaio
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/remote"
+)
+
+// FastqRecord is a simple struct for Fastq records. Qual holds Phred quality
+// scores, i.e. with the ASCII offset of 33 already removed
+type FastqRecord struct {
+	ID          string
+	Description string
+	Seq         string
+	Qual        []byte
+	Idx         int
+}
+
+// MaskFastqRecord returns a FastaRecord with all the bases in FQ whose Phred
+// quality is below minQual replaced with Ns
+func MaskFastqRecord(FQ FastqRecord, minQual int) FastaRecord {
+
+	seq := []byte(FQ.Seq)
+
+	for i, q := range FQ.Qual {
+		if int(q) < minQual {
+			seq[i] = 'N'
+		}
+	}
+
+	return FastaRecord{ID: FQ.ID, Description: FQ.Description, Seq: string(seq), Idx: FQ.Idx}
+}
+
+// ReadFastq reads a file in fastq format to a channel of FastqRecord structs.
+// Records must be four lines each: multi-line sequence/quality strings are not
+// supported
+func ReadFastq(infile string, chnl chan FastqRecord, chnlerr chan error, cdone chan bool) {
+
+	var err error
+	var f io.ReadCloser
+
+	if infile != "stdin" {
+		f, err = remote.Open(infile)
+		if err != nil {
+			chnlerr <- err
+			return
+		}
+	} else {
+		f = os.Stdin
+	}
+
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+
+	var FQ FastqRecord
+
+	counter := 0
+	linecounter := 0
+
+	for s.Scan() {
+		line := s.Text()
+
+		// skip blank lines between (or at the end of) records
+		if linecounter%4 == 0 && len(line) == 0 {
+			continue
+		}
+
+		switch linecounter % 4 {
+		case 0:
+			if line[0] != '@' {
+				chnlerr <- errors.New("badly formatted fastq file")
+				return
+			}
+			FQ = FastqRecord{Description: line[1:], Idx: counter}
+			if fields := strings.Fields(FQ.Description); len(fields) > 0 {
+				FQ.ID = fields[0]
+			}
+		case 1:
+			FQ.Seq = alphabet.ToDNA(strings.ToUpper(line))
+		case 2:
+			if len(line) == 0 || line[0] != '+' {
+				chnlerr <- errors.New("badly formatted fastq file")
+				return
+			}
+		case 3:
+			if len(line) != len(FQ.Seq) {
+				chnlerr <- errors.New("badly formatted fastq file: sequence and quality are different lengths: " + FQ.ID)
+				return
+			}
+			FQ.Qual = make([]byte, len(line))
+			for i := range line {
+				FQ.Qual[i] = line[i] - 33
+			}
+			chnl <- FQ
+			counter++
+		}
+
+		linecounter++
+	}
+
+	err = s.Err()
+	if err != nil {
+		chnlerr <- err
+		return
+	}
+
+	if linecounter%4 != 0 {
+		chnlerr <- errors.New("badly formatted fastq file: truncated record")
+		return
+	}
+
+	if counter == 0 {
+		warnEmpty(infile)
+	}
+
+	summary.Read(infile, counter)
+
+	cdone <- true
+}
@@ -0,0 +1,190 @@
+package fastaio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// Filter drops sequences with too much missing data from an output alignment, and
+// optionally writes them, with the reason, to a rejects file. It isn't safe for
+// concurrent use: use it from the goroutine that writes the output
+type Filter struct {
+	MinCompleteness float64 // the smallest proportion of sites that aren't missing data (0 turns this off)
+	MaxN            int     // the most Ns a sequence can have (-1 turns this off)
+	Dropped         int     // how many sequences have been dropped
+	rejects         *os.File
+}
+
+// NewFilter makes a Filter. If rejectsFile isn't empty, the sequences that are
+// dropped are written there in fasta format, with the reason in the header
+func NewFilter(minCompleteness float64, maxN int, rejectsFile string) (*Filter, error) {
+	return ResumeFilter(minCompleteness, maxN, rejectsFile, 0)
+}
+
+// ResumeFilter is NewFilter, except that the first offset bytes of the rejects file (see
+// RejectsOffset) are kept, for picking up where an interrupted run left off
+func ResumeFilter(minCompleteness float64, maxN int, rejectsFile string, offset int64) (*Filter, error) {
+
+	if minCompleteness < 0 || minCompleteness > 1 {
+		return nil, usage.New("--min-completeness should be between 0 and 1")
+	}
+
+	flt := &Filter{MinCompleteness: minCompleteness, MaxN: maxN}
+
+	if len(rejectsFile) > 0 {
+		f, err := OpenAt(rejectsFile, offset)
+		if err != nil {
+			return nil, err
+		}
+		flt.rejects = f
+	}
+
+	return flt, nil
+}
+
+// OpenAt opens a file for writing from offset, keeping what is before it and dropping what is
+// after it. An offset of 0 is the same as os.Create
+func OpenAt(path string, offset int64) (*os.File, error) {
+
+	if offset == 0 {
+		return os.Create(path)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.Size() < offset {
+		f.Close()
+		return nil, fmt.Errorf("%s is only %d bytes long, but should be at least %d", path, fi.Size(), offset)
+	}
+
+	err = f.Truncate(offset)
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// RejectsOffset is how much has been written to the rejects file so far (0 if there isn't one),
+// after making sure that it is on disk
+func (flt *Filter) RejectsOffset() (int64, error) {
+	if flt.rejects == nil {
+		return 0, nil
+	}
+	err := flt.rejects.Sync()
+	if err != nil {
+		return 0, err
+	}
+	return flt.rejects.Seek(0, io.SeekCurrent)
+}
+
+// Completeness is the proportion of sites in an aligned sequence that aren't
+// missing data: N, ?, or the gaps at either end (which are unsequenced, unlike
+// internal gaps, which are deletions)
+func Completeness(seq string) float64 {
+
+	if len(seq) == 0 {
+		return 0
+	}
+
+	start, end := 0, len(seq)
+	for start < end && seq[start] == '-' {
+		start++
+	}
+	for end > start && seq[end-1] == '-' {
+		end--
+	}
+
+	missing := len(seq) - (end - start)
+	for i := start; i < end; i++ {
+		switch seq[i] {
+		case 'N', 'n', '?':
+			missing++
+		}
+	}
+
+	return float64(len(seq)-missing) / float64(len(seq))
+}
+
+// CountN is the number of Ns (in either case) in a sequence
+func CountN(seq string) int {
+	n := 0
+	for i := 0; i < len(seq); i++ {
+		if seq[i] == 'N' || seq[i] == 'n' {
+			n++
+		}
+	}
+	return n
+}
+
+// Reason returns why a sequence should be dropped, or the empty string if it passes
+func (flt *Filter) Reason(seq string) string {
+
+	if flt.MinCompleteness > 0 {
+		if c := Completeness(seq); c < flt.MinCompleteness {
+			return fmt.Sprintf("completeness=%.4f<%s", c, strconv.FormatFloat(flt.MinCompleteness, 'f', -1, 64))
+		}
+	}
+
+	if flt.MaxN >= 0 {
+		if n := CountN(seq); n > flt.MaxN {
+			return "N=" + strconv.Itoa(n) + ">" + strconv.Itoa(flt.MaxN)
+		}
+	}
+
+	return ""
+}
+
+// Keep is true if a record passes the filter. If it doesn't, it is written to the
+// rejects file (if there is one)
+func (flt *Filter) Keep(FR FastaRecord) (bool, error) {
+
+	reason := flt.Reason(FR.Seq)
+	if len(reason) == 0 {
+		return true, nil
+	}
+
+	flt.Dropped++
+
+	if flt.rejects != nil {
+		_, err := flt.rejects.WriteString(">" + FR.ID + " " + reason + "\n" + FR.Seq + "\n")
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// Close closes the rejects file, and reports how many sequences were dropped
+func (flt *Filter) Close() error {
+
+	summary.Add(summary.Filtered, flt.Dropped)
+
+	if flt.Dropped > 0 {
+		fmt.Fprintf(os.Stderr, "dropped %d sequences with too much missing data\n", flt.Dropped)
+	}
+
+	if flt.rejects != nil {
+		return flt.rejects.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package fastaio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompleteness(t *testing.T) {
+	tests := []struct {
+		seq      string
+		expected float64
+	}{
+		{"ACGT", 1},
+		{"ACNN", 0.5},
+		{"--ACGT--", 0.5},
+		{"AC--GT", 1},
+		{"-N?A", 0.25},
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		if c := Completeness(test.seq); c != test.expected {
+			t.Errorf("problem in TestCompleteness: %s has completeness %f, expected %f", test.seq, c, test.expected)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rejects := filepath.Join(dir, "rejects.fasta")
+
+	flt, err := NewFilter(0.5, 2, rejects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []FastaRecord{
+		{ID: "good", Seq: "ACGTACGTNN"},
+		{ID: "incomplete", Seq: "--NNNNACGT"},
+		{ID: "tooManyNs", Seq: "ANGNTNACGT"},
+	}
+
+	for i, expected := range []bool{true, false, false} {
+		keep, err := flt.Keep(records[i])
+		if err != nil {
+			t.Error(err)
+		}
+		if keep != expected {
+			t.Errorf("problem in TestFilter: Keep(%s) was %t", records[i].ID, keep)
+		}
+	}
+
+	err = flt.Close()
+	if err != nil {
+		t.Error(err)
+	}
+
+	written, err := ioutil.ReadFile(rejects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ">incomplete completeness=0.4000<0.5\n--NNNNACGT\n>tooManyNs N=3>2\nANGNTNACGT\n"
+	if string(written) != expected {
+		t.Errorf("problem in TestFilter: rejects file is\n%s\nexpected\n%s", written, expected)
+	}
+
+	_, err = NewFilter(1.5, -1, "")
+	if err == nil {
+		t.Errorf("problem in TestFilter: expected an error for --min-completeness 1.5")
+	}
+}
@@ -0,0 +1,104 @@
+package fastaio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+)
+
+// sniffAlignmentFormat peeks at the start of an alignment and returns one of
+// "stockholm", "clustal" or "fasta"
+func sniffAlignmentFormat(br *bufio.Reader) string {
+	// Peek returns whatever it can if the input is shorter than asked for
+	start, _ := br.Peek(16)
+
+	switch {
+	case strings.HasPrefix(string(start), "# STOCKHOLM"):
+		return "stockholm"
+	case strings.HasPrefix(string(start), "CLUSTAL"):
+		return "clustal"
+	}
+
+	return "fasta"
+}
+
+// normaliseAlignment returns a reader of fasta-format records. If r is already
+// fasta it is passed through as-is, but if it is Stockholm or Clustal it is parsed
+// and converted to fasta so that the downstream readers in this package
+// don't need to know what format the alignment was originally in.
+func normaliseAlignment(r io.Reader) (io.Reader, error) {
+
+	br := bufio.NewReader(r)
+
+	var records []FastaRecord
+	var err error
+
+	switch sniffAlignmentFormat(br) {
+	case "stockholm":
+		records, err = ReadStockholm(br)
+	case "clustal":
+		records, err = ReadClustal(br)
+	default:
+		return br, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, record := range records {
+		buf.WriteString(">" + record.ID + "\n")
+		buf.WriteString(record.Seq + "\n")
+	}
+
+	return &buf, nil
+}
+
+// ReadFasta reads every record in an alignment (in fasta, Stockholm or Clustal format) from r,
+// with the sequences upper-cased, as ReadAlignment does for a file. It is for callers that
+// have the alignment in memory rather than in a file, e.g. in a browser
+func ReadFasta(r io.Reader) ([]FastaRecord, error) {
+
+	nr, err := normaliseAlignment(r)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]FastaRecord, 0)
+
+	s := bufio.NewScanner(nr)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+
+	var seq strings.Builder
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		switch {
+		case len(line) == 0:
+			continue
+		case line[0] == '>':
+			if len(records) > 0 {
+				records[len(records)-1].Seq = seq.String()
+				seq.Reset()
+			}
+			fields := strings.Fields(line[1:])
+			if len(fields) == 0 {
+				return nil, errors.New("badly formatted fasta file: a record has no name")
+			}
+			records = append(records, FastaRecord{ID: fields[0], Description: line[1:], Idx: len(records)})
+		case len(records) == 0:
+			return nil, errors.New("badly formatted fasta file")
+		default:
+			seq.WriteString(alphabet.ToDNA(strings.ToUpper(line)))
+		}
+	}
+	if len(records) > 0 {
+		records[len(records)-1].Seq = seq.String()
+	}
+
+	return records, s.Err()
+}
@@ -0,0 +1,284 @@
+package fastaio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/remote"
+)
+
+// IndexEntry is one record in a (samtools-style) .fai index of a fasta file
+type IndexEntry struct {
+	Name      string
+	Length    int   // the number of bases in the record
+	Offset    int64 // the byte offset of the record's first base
+	LineBases int   // the number of bases on each line
+	LineWidth int   // the number of bytes in each line, including the line ending
+}
+
+// Index is the index of a fasta file, which says where each record's sequence is, so that
+// a few records can be read from a large file without reading all of it
+type Index struct {
+	Entries []IndexEntry
+	byName  map[string]int
+}
+
+func newIndex(entries []IndexEntry) (*Index, error) {
+	idx := &Index{Entries: entries, byName: make(map[string]int)}
+	for i, e := range entries {
+		if _, ok := idx.byName[e.Name]; ok {
+			return nil, fmt.Errorf("there is more than one record called %s", e.Name)
+		}
+		idx.byName[e.Name] = i
+	}
+	return idx, nil
+}
+
+// IndexPath is where the index of a fasta file goes
+func IndexPath(fastaFile string) string {
+	return fastaFile + ".fai"
+}
+
+// BuildIndex reads a fasta file once, recording where each record's sequence starts and how
+// long its lines are. Every line of a record's sequence except the last must be the same length
+func BuildIndex(fastaFile string) (*Index, error) {
+
+	f, err := remote.Open(fastaFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	entries := make([]IndexEntry, 0)
+
+	var offset int64
+	var e *IndexEntry
+	short := false // whether the current record has had a line shorter than the first
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		width := len(line)
+		bases := len(bytes.TrimRight(line, "\r\n"))
+
+		switch {
+		case len(line) > 0 && line[0] == '>':
+			fields := strings.Fields(string(line[1:]))
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("record with no name at byte %d of %s", offset, fastaFile)
+			}
+			entries = append(entries, IndexEntry{Name: fields[0], Offset: offset + int64(width)})
+			e = &entries[len(entries)-1]
+			short = false
+
+		case e == nil:
+			return nil, errors.New("badly formatted fasta file")
+
+		case bases == 0:
+			short = true
+
+		default:
+			if e.LineBases == 0 {
+				e.LineBases = bases
+				e.LineWidth = width
+			} else if short || bases > e.LineBases || (bases < e.LineBases && err == nil && !lastLine(r)) {
+				return nil, fmt.Errorf("can't index %s: the lines of %s aren't all the same length", fastaFile, e.Name)
+			}
+			if bases < e.LineBases {
+				short = true
+			}
+			e.Length += bases
+		}
+
+		offset += int64(width)
+	}
+
+	return newIndex(entries)
+}
+
+// lastLine reports whether the next line from r is the header of another record or
+// the end of the file, i.e. whether the line that was just read was the last of its record
+func lastLine(r *bufio.Reader) bool {
+	b, err := r.Peek(1)
+	return err != nil || b[0] == '>'
+}
+
+// WriteIndex writes an index in the same (tab-separated) format as samtools faidx
+func WriteIndex(idx *Index, indexFile string) error {
+
+	f, err := os.Create(indexFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, e := range idx.Entries {
+		w.WriteString(strings.Join([]string{
+			e.Name,
+			strconv.Itoa(e.Length),
+			strconv.FormatInt(e.Offset, 10),
+			strconv.Itoa(e.LineBases),
+			strconv.Itoa(e.LineWidth),
+		}, "\t") + "\n")
+	}
+
+	return w.Flush()
+}
+
+// ReadIndex reads an index written by WriteIndex (or samtools faidx)
+func ReadIndex(indexFile string) (*Index, error) {
+
+	f, err := remote.Open(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]IndexEntry, 0)
+
+	s := bufio.NewScanner(f)
+	lineNumber := 0
+	for s.Scan() {
+		lineNumber++
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("couldn't parse line %d of %s", lineNumber, indexFile)
+		}
+		var e IndexEntry
+		var errs [4]error
+		e.Name = fields[0]
+		e.Length, errs[0] = strconv.Atoi(fields[1])
+		e.Offset, errs[1] = strconv.ParseInt(fields[2], 10, 64)
+		e.LineBases, errs[2] = strconv.Atoi(fields[3])
+		e.LineWidth, errs[3] = strconv.Atoi(fields[4])
+		for _, err := range errs {
+			if err != nil {
+				return nil, fmt.Errorf("couldn't parse line %d of %s", lineNumber, indexFile)
+			}
+		}
+		entries = append(entries, e)
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return newIndex(entries)
+}
+
+// HasIndex reports whether a fasta file has an index that is at least as new as it is. The
+// index of a remote file (see the remote package) can't be checked for being up to date, so it
+// only has to exist
+func HasIndex(fastaFile string) bool {
+	if remote.IsURL(fastaFile) {
+		return remote.Exists(IndexPath(fastaFile))
+	}
+	fi, err := os.Stat(fastaFile)
+	if err != nil {
+		return false
+	}
+	ii, err := os.Stat(IndexPath(fastaFile))
+	if err != nil {
+		return false
+	}
+	return !ii.ModTime().Before(fi.ModTime())
+}
+
+// LoadIndex reads a fasta file's index if it has an up to date one, and builds it otherwise
+// (without writing it)
+func LoadIndex(fastaFile string) (*Index, error) {
+	if HasIndex(fastaFile) {
+		return ReadIndex(IndexPath(fastaFile))
+	}
+	return BuildIndex(fastaFile)
+}
+
+// Has reports whether the indexed file has a record called name
+func (idx *Index) Has(name string) bool {
+	_, ok := idx.byName[name]
+	return ok
+}
+
+// Fetch reads the record called name from f, which is the indexed fasta file. The sequence
+// is upper-cased, as it is by ReadAlignment
+func (idx *Index) Fetch(f io.ReaderAt, name string) (FastaRecord, error) {
+
+	i, ok := idx.byName[name]
+	if !ok {
+		return FastaRecord{}, fmt.Errorf("there is no record called %s in the index", name)
+	}
+	e := idx.Entries[i]
+
+	// the bytes from the first base to the last, with the line ends between them (but not the
+	// one after the last line, which the file may not have)
+	size := e.Length
+	if e.LineBases > 0 && e.Length > 0 {
+		size = ((e.Length-1)/e.LineBases)*e.LineWidth + (e.Length-1)%e.LineBases + 1
+	}
+
+	b := make([]byte, size)
+	n, err := f.ReadAt(b, e.Offset)
+	if err != nil && !(err == io.EOF && n == size) {
+		return FastaRecord{}, fmt.Errorf("couldn't read %s using the index (is the index out of date?): %v", name, err)
+	}
+
+	seq := make([]byte, 0, e.Length)
+	for _, c := range b {
+		if c != '\n' && c != '\r' {
+			seq = append(seq, c)
+		}
+	}
+	if len(seq) != e.Length {
+		return FastaRecord{}, fmt.Errorf("couldn't read %s using the index: is the index out of date?", name)
+	}
+
+	return FastaRecord{ID: name, Description: name, Seq: alphabet.ToDNA(strings.ToUpper(string(seq))), Idx: i}, nil
+}
+
+// ReadIndexedRecords reads the records called names from a fasta file, in that order, using
+// its index (see LoadIndex), so that only those records are read
+func ReadIndexedRecords(fastaFile string, names []string) ([]FastaRecord, error) {
+
+	idx, err := LoadIndex(fastaFile)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := remote.Open(fastaFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := make([]FastaRecord, 0, len(names))
+	for i, name := range names {
+		FR, err := idx.Fetch(f, name)
+		if err != nil {
+			return nil, err
+		}
+		FR.Idx = i
+		records = append(records, FR)
+	}
+
+	summary.Read(fastaFile, len(records))
+
+	return records, nil
+}
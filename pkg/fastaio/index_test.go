@@ -0,0 +1,175 @@
+package fastaio
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// s2 has windows line endings, and s3 is in lower case and fills its last line exactly
+	fastaFile := filepath.Join(dir, "seqs.fasta")
+	err = ioutil.WriteFile(fastaFile, []byte(">s1 first one\nACGTA\nCGTAC\nGT\n>s2\r\nAAAA\r\nCC\r\n>s3\nacgt\nacgt\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := BuildIndex(fastaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []IndexEntry{
+		{"s1", 12, 14, 5, 6},
+		{"s2", 6, 34, 4, 6},
+		{"s3", 8, 48, 4, 5},
+	}
+	if !reflect.DeepEqual(idx.Entries, expected) {
+		t.Errorf("problem in TestIndex: got %v, expected %v", idx.Entries, expected)
+	}
+
+	err = WriteIndex(idx, IndexPath(fastaFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasIndex(fastaFile) {
+		t.Errorf("problem in TestIndex: HasIndex is false after writing the index")
+	}
+	idx, err = ReadIndex(IndexPath(fastaFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(idx.Entries, expected) {
+		t.Errorf("problem in TestIndex: the index read back is %v, expected %v", idx.Entries, expected)
+	}
+
+	records, err := ReadIndexedRecords(fastaFile, []string{"s3", "s1", "s2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqs := []string{"ACGTACGT", "ACGTACGTACGT", "AAAACC"}
+	for i, FR := range records {
+		if FR.Seq != seqs[i] || FR.Idx != i {
+			t.Errorf("problem in TestIndex: record %d (%s) is %s, expected %s", i, FR.ID, FR.Seq, seqs[i])
+		}
+	}
+
+	_, err = ReadIndexedRecords(fastaFile, []string{"s4"})
+	if err == nil {
+		t.Errorf("problem in TestIndex: expected an error for a record that isn't there")
+	}
+}
+
+// A record whose last line is full, at the end of a file without a final line end, is read
+// without reading past the end of the file
+func TestFetchNoFinalNewline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, contents := range []string{">a\nACGT\nACGT", ">a\r\nACGT\r\nACGT", ">a\nACGTACGT", ">a\nACGT\nAC\n>b\nACGT\nACGT"} {
+		fastaFile := filepath.Join(dir, "seqs.fasta")
+		err = ioutil.WriteFile(fastaFile, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		idx, err := BuildIndex(fastaFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := idx.Entries[len(idx.Entries)-1].Name
+
+		records, err := ReadIndexedRecords(fastaFile, []string{name})
+		if err != nil {
+			t.Errorf("problem in TestFetchNoFinalNewline: %q: %v", contents, err)
+			continue
+		}
+		if records[0].Seq != "ACGTACGT" {
+			t.Errorf("problem in TestFetchNoFinalNewline: %q: got %s, expected ACGTACGT", contents, records[0].Seq)
+		}
+	}
+}
+
+func TestBuildIndexUnevenLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, contents := range []string{">s1\nACGT\nAC\nACGT\n", ">s1\nAC\nACGT\n", ">s1\nACGT\n\nACGT\n"} {
+		fastaFile := filepath.Join(dir, "seqs.fasta")
+		err = ioutil.WriteFile(fastaFile, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = BuildIndex(fastaFile)
+		if err == nil {
+			t.Errorf("problem in TestBuildIndexUnevenLines: expected an error for %q", contents)
+		}
+	}
+}
+
+func TestReadIndexedRecordsRemote(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fastaFile := filepath.Join(dir, "seqs.fasta")
+	err = ioutil.WriteFile(fastaFile, []byte(">s1\nACGTA\nCGTAC\nGT\n>s2\nAAAA\nCC\n>s3\nacgt\nacgt\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer ts.Close()
+
+	url := ts.URL + "/seqs.fasta"
+
+	// without an index, it is built by reading the whole file
+	if HasIndex(url) {
+		t.Error("problem in TestReadIndexedRecordsRemote: a remote file without an index has one")
+	}
+	records, err := ReadIndexedRecords(url, []string{"s3", "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Seq != "ACGTACGT" || records[1].Seq != "ACGTACGTACGT" {
+		t.Errorf("problem in TestReadIndexedRecordsRemote: got %v", records)
+	}
+
+	// with one, only the records are read
+	idx, err := BuildIndex(fastaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteIndex(idx, IndexPath(fastaFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasIndex(url) {
+		t.Error("problem in TestReadIndexedRecordsRemote: a remote file's index wasn't found")
+	}
+	records, err = ReadIndexedRecords(url, []string{"s2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Seq != "AAAACC" {
+		t.Errorf("problem in TestReadIndexedRecordsRemote: got %v", records)
+	}
+}
@@ -0,0 +1,633 @@
+package fastaio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/remote"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// encodedMagic starts every file written by WriteEncoded. The rest of the 64-byte header is:
+//
+//	16-23  the number of records (little-endian uint64)
+//	24-31  the alignment width
+//	32     the number of bits per base: 8 (EP's coding scheme as is) or 4 (packed)
+//	33     the number of codes in the table
+//	34     1 if some records have been removed (see RemoveEncoded), otherwise 0
+//	48-63  the table: the EP code for each 4-bit value, if the bases are packed
+//
+// The header is followed by the records' sequences, which are all the same number of bytes
+// (width, or (width+1)/2 if packed, with the first base of each pair in the high 4 bits), then
+// the name table: every record's description, each preceded by its length as a little-endian uint32.
+// If some records have been removed, the name table is followed by the number of them and then the
+// (0-based) index of each one, as little-endian uint64s. Removed records are still stored, but
+// are skipped when the file is read
+const encodedMagic = "GOFASTA-ENCODED\x01"
+
+const encodedHeaderSize = 64
+
+// encodedHeader is the part of the header of an encoded file that describes its records
+type encodedHeader struct {
+	n       int
+	width   int
+	bits    int
+	table   []byte
+	removed bool
+}
+
+// stride is the number of bytes that each record's sequence takes up
+func (h encodedHeader) stride() int {
+	if h.bits == 4 {
+		return (h.width + 1) / 2
+	}
+	return h.width
+}
+
+func (h encodedHeader) bytes() []byte {
+	b := make([]byte, encodedHeaderSize)
+	copy(b, encodedMagic)
+	binary.LittleEndian.PutUint64(b[16:], uint64(h.n))
+	binary.LittleEndian.PutUint64(b[24:], uint64(h.width))
+	b[32] = byte(h.bits)
+	b[33] = byte(len(h.table))
+	if h.removed {
+		b[34] = 1
+	}
+	copy(b[48:], h.table)
+	return b
+}
+
+func parseEncodedHeader(b []byte) (encodedHeader, error) {
+	if len(b) < encodedHeaderSize || string(b[:len(encodedMagic)]) != encodedMagic {
+		return encodedHeader{}, errors.New("not a gofasta encode file, or truncated")
+	}
+	n := binary.LittleEndian.Uint64(b[16:])
+	width := binary.LittleEndian.Uint64(b[24:])
+	bits, nCodes := int(b[32]), int(b[33])
+	if bits != 4 && bits != 8 || nCodes > 16 || width > 2*uint64(len(b)) {
+		return encodedHeader{}, errors.New("bad gofasta encode header")
+	}
+	h := encodedHeader{bits: bits, table: b[48 : 48+nCodes], removed: b[34] == 1}
+	h.width = int(width)
+	if h.stride() > 0 && n > uint64(len(b)-encodedHeaderSize)/uint64(h.stride()) {
+		return encodedHeader{}, errors.New("truncated gofasta encode file")
+	}
+	h.n = int(n)
+	return h, nil
+}
+
+// packer packs encoded sequences into 4 bits per base, assigning each EP code a 4-bit value
+// the first time it is seen
+type packer struct {
+	table  []byte
+	lookup [256]int // the 4-bit value + 1 of each EP code, or 0 if it hasn't been seen yet
+}
+
+func (p *packer) pack(seq []byte, packed []byte) error {
+	for i := range packed {
+		packed[i] = 0
+	}
+	for i, nuc := range seq {
+		v := p.lookup[nuc]
+		if v == 0 {
+			if len(p.table) == 16 {
+				return usage.New("the alignment uses all 17 nucleotide codes, so its bases can't be packed into 4 bits: use --unpacked")
+			}
+			p.table = append(p.table, nuc)
+			v = len(p.table)
+			p.lookup[nuc] = v
+		}
+		if i%2 == 0 {
+			packed[i/2] = byte(v-1) << 4
+		} else {
+			packed[i/2] |= byte(v - 1)
+		}
+	}
+	return nil
+}
+
+// WriteEncoded writes an alignment in fasta format to outFile in EP's bitwise coding scheme,
+// in a binary format that can be read (or mapped into memory by OpenMapped) without parsing any
+// text. The bases are packed into 4 bits each, unless unpacked is true, in which case they take a
+// byte each but can be used straight from the mapping without being copied
+func WriteEncoded(inFile string, outFile string, unpacked bool) error {
+
+	if outFile == "stdout" {
+		return usage.New("the encoded alignment has to be written to a file (--outfile), not stdout")
+	}
+
+	f, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	// the header is filled in at the end, once we know the number of records and the table
+	_, err = w.Write(make([]byte, encodedHeaderSize))
+	if err != nil {
+		return err
+	}
+
+	h := encodedHeader{width: -1, bits: 4}
+	if unpacked {
+		h.bits = 8
+	}
+	p := &packer{}
+
+	descriptions, err := writeRecords(inFile, w, &h, p)
+	if err != nil {
+		return err
+	}
+
+	err = writeTail(w, descriptions, nil)
+	if err != nil {
+		return err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	h.n = len(descriptions)
+	h.table = p.table
+	_, err = f.WriteAt(h.bytes(), 0)
+
+	return err
+}
+
+// writeRecords encodes the alignment in inFile and writes its records' sequences to w, packed by
+// p if h.bits is 4. If h.width is -1 it is set by the first record, and every record has to be that
+// width. It returns the records' descriptions
+func writeRecords(inFile string, w *bufio.Writer, h *encodedHeader, p *packer) ([]string, error) {
+
+	cErr := make(chan error)
+	cEFR := make(chan EncodedFastaRecord)
+	cDone := make(chan bool)
+
+	go ReadEncodeAlignment(inFile, cEFR, cErr, cDone)
+
+	var packed []byte
+	if h.width != -1 {
+		packed = make([]byte, h.stride())
+	}
+
+	descriptions := make([]string, 0)
+
+	var err error
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return nil, err
+		case EFR := <-cEFR:
+			if h.width == -1 {
+				h.width = len(EFR.Seq)
+				packed = make([]byte, h.stride())
+			} else if len(EFR.Seq) != h.width {
+				return nil, errors.New("different length sequences in input file: is this an alignment?")
+			}
+			if h.bits == 8 {
+				_, err = w.Write(EFR.Seq)
+			} else {
+				err = p.pack(EFR.Seq, packed)
+				if err != nil {
+					return nil, err
+				}
+				_, err = w.Write(packed)
+			}
+			if err != nil {
+				return nil, err
+			}
+			descriptions = append(descriptions, EFR.Description)
+		case <-cDone:
+			n--
+		}
+	}
+
+	// an alignment with no records has no width
+	if h.width == -1 {
+		h.width = 0
+	}
+
+	return descriptions, nil
+}
+
+// writeTail writes what comes after the records' sequences in an encoded file: the name table,
+// and the removed records if there are any
+func writeTail(w *bufio.Writer, descriptions []string, removed []int) error {
+
+	for _, description := range descriptions {
+		err := binary.Write(w, binary.LittleEndian, uint32(len(description)))
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteString(description)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	err := binary.Write(w, binary.LittleEndian, uint64(len(removed)))
+	if err != nil {
+		return err
+	}
+	for _, i := range removed {
+		err = binary.Write(w, binary.LittleEndian, uint64(i))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsEncoded reports whether inFile was written by WriteEncoded. Only regular files are
+// checked, so that nothing is consumed from e.g. a pipe
+func IsEncoded(inFile string) bool {
+	if inFile == "stdin" {
+		return false
+	}
+	fi, err := os.Stat(inFile)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	f, err := os.Open(inFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	b := make([]byte, len(encodedMagic))
+	_, err = f.Read(b)
+	return err == nil && string(b) == encodedMagic
+}
+
+// readEncodedFile reads every record in a file written by WriteEncoded onto the heap, calling
+// fn with each one. It is how the usual alignment readers read encoded files
+func readEncodedFile(inFile string, fn func(EncodedFastaRecord) error) error {
+
+	ma, err := OpenMapped(inFile)
+	if err != nil {
+		return err
+	}
+	defer ma.Close()
+
+	n, err := ma.eachRecord(true, fn)
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		warnEmpty(inFile)
+	}
+	summary.Read(inFile, n)
+
+	return nil
+}
+
+// MappedAlignment is an alignment file (in fasta format, or written by WriteEncoded) that has been
+// mapped into memory, so that its pages are read from the OS's page cache when they're needed,
+// rather than all being copied onto the heap. The mapping is copy-on-write, so the sequences
+// from an unpacked encoded file can be changed (e.g. masked) without changing the file
+type MappedAlignment struct {
+	path  string
+	data  []byte
+	unmap func() error
+
+	// for encoded files, the header, the offset of each record's name and the records that have
+	// been removed, found the first time they're needed
+	indexOnce sync.Once
+	header    encodedHeader
+	names     []int
+	removed   map[int]bool
+	indexErr  error
+}
+
+// OpenMapped maps an alignment file into memory. Close must be called once none of the
+// sequences read from it are needed any more
+func OpenMapped(inFile string) (*MappedAlignment, error) {
+
+	if inFile == "stdin" {
+		return nil, usage.New("stdin can't be mapped into memory: give the alignment as a file")
+	}
+	if remote.IsURL(inFile) {
+		return nil, usage.New("remote files can't be mapped into memory: download the alignment first")
+	}
+
+	f, err := os.Open(inFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	data, unmap, err := mapFile(f, int(fi.Size()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MappedAlignment{path: inFile, data: data, unmap: unmap}, nil
+}
+
+// Close unmaps the alignment. None of the sequences read from it can be used afterwards
+func (ma *MappedAlignment) Close() error {
+	return ma.unmap()
+}
+
+// ReadEncode sends the mapped alignment's records to chnl, in the same way as ReadEncodeAlignment.
+// The sequences in an unpacked encoded file are slices of the mapping, so aren't copied at all;
+// the sequences in a packed file are unpacked, and those in a fasta file are encoded straight
+// from the mapping
+func (ma *MappedAlignment) ReadEncode(chnl chan EncodedFastaRecord, cErr chan error, cDone chan bool) {
+
+	counter, err := ma.eachRecord(false, func(EFR EncodedFastaRecord) error {
+		chnl <- EFR
+		return nil
+	})
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	summary.Read(ma.path, counter)
+
+	cDone <- true
+}
+
+// eachRecord calls fn with each of the alignment's records, in order, and returns how many there
+// were. If copySeqs is true, every sequence is on the heap, so can be used after Close
+func (ma *MappedAlignment) eachRecord(copySeqs bool, fn func(EncodedFastaRecord) error) (int, error) {
+	if bytes.HasPrefix(ma.data, []byte(encodedMagic)) {
+		return ma.readEncoded(copySeqs, fn)
+	}
+	return ma.readFasta(fn)
+}
+
+// unpack returns a record's sequence from its stored bytes. Unpacked sequences are slices of the
+// mapping unless copySeq is true
+func (h encodedHeader) unpack(stored []byte, copySeq bool) []byte {
+	var seq []byte
+	switch {
+	case h.bits == 4:
+		var unpacking [16]byte
+		copy(unpacking[:], h.table)
+		seq = make([]byte, h.width)
+		for j := range seq {
+			if j%2 == 0 {
+				seq[j] = unpacking[stored[j/2]>>4]
+			} else {
+				seq[j] = unpacking[stored[j/2]&15]
+			}
+		}
+	case copySeq:
+		seq = make([]byte, h.width)
+		copy(seq, stored)
+	default:
+		seq = stored
+	}
+	return seq
+}
+
+// readName reads the description at the start of names, and returns it and the rest of names
+func (ma *MappedAlignment) readName(i int, names []byte) (string, []string, []byte, error) {
+	if len(names) < 4 {
+		return "", nil, nil, fmt.Errorf("%s is truncated", ma.path)
+	}
+	l := int(binary.LittleEndian.Uint32(names))
+	if len(names) < 4+l {
+		return "", nil, nil, fmt.Errorf("%s is truncated", ma.path)
+	}
+	description := string(names[4 : 4+l])
+	fields := strings.Fields(description)
+	if len(fields) == 0 {
+		return "", nil, nil, fmt.Errorf("record %d in %s has no name", i+1, ma.path)
+	}
+	return description, fields, names[4+l:], nil
+}
+
+// readEncoded reads the records that haven't been removed, numbering them from 0 in the order
+// they are in the file
+func (ma *MappedAlignment) readEncoded(copySeqs bool, fn func(EncodedFastaRecord) error) (int, error) {
+
+	err := ma.index()
+	if err != nil {
+		return 0, err
+	}
+
+	h := ma.header
+	stride := h.stride()
+	seqs := ma.data[encodedHeaderSize:]
+
+	counter := 0
+
+	for i := 0; i < h.n; i++ {
+		if ma.removed[i] {
+			continue
+		}
+
+		description, fields, _, err := ma.readName(i, ma.data[ma.names[i]:])
+		if err != nil {
+			return 0, err
+		}
+
+		stored := seqs[i*stride : (i+1)*stride : (i+1)*stride]
+
+		err = fn(EncodedFastaRecord{ID: fields[0], Description: description, Seq: h.unpack(stored, copySeqs), Idx: counter})
+		if err != nil {
+			return 0, err
+		}
+		counter++
+	}
+
+	return counter, nil
+}
+
+// index finds where each record's name is in a mapped encoded file, and which records have
+// been removed
+func (ma *MappedAlignment) index() error {
+	ma.indexOnce.Do(func() {
+		if !bytes.HasPrefix(ma.data, []byte(encodedMagic)) {
+			ma.indexErr = usage.Errorf("%s wasn't written by gofasta encode, so its records can't be read by index", ma.path)
+			return
+		}
+		h, err := parseEncodedHeader(ma.data)
+		if err != nil {
+			ma.indexErr = fmt.Errorf("%s: %v", ma.path, err)
+			return
+		}
+		start := encodedHeaderSize + h.n*h.stride()
+		names := ma.data[start:]
+		offsets := make([]int, h.n)
+		for i := range offsets {
+			offsets[i] = len(ma.data) - len(names)
+			_, _, names, err = ma.readName(i, names)
+			if err != nil {
+				ma.indexErr = err
+				return
+			}
+		}
+		removed := make(map[int]bool)
+		if h.removed {
+			if len(names) < 8 {
+				ma.indexErr = fmt.Errorf("%s is truncated", ma.path)
+				return
+			}
+			nRemoved := binary.LittleEndian.Uint64(names)
+			names = names[8:]
+			if nRemoved > uint64(len(names)/8) {
+				ma.indexErr = fmt.Errorf("%s is truncated", ma.path)
+				return
+			}
+			for j := 0; j < int(nRemoved); j++ {
+				i := binary.LittleEndian.Uint64(names[8*j:])
+				if i >= uint64(h.n) {
+					ma.indexErr = fmt.Errorf("%s has a removed record that isn't in it", ma.path)
+					return
+				}
+				removed[int(i)] = true
+			}
+		}
+		ma.header = h
+		ma.names = offsets
+		ma.removed = removed
+	})
+	return ma.indexErr
+}
+
+// Len is the number of records in a mapped file that was written by WriteEncoded, including
+// any that have been removed
+func (ma *MappedAlignment) Len() (int, error) {
+	err := ma.index()
+	if err != nil {
+		return 0, err
+	}
+	return ma.header.n, nil
+}
+
+// Removed reports whether the i'th (0-based) record of a mapped file that was written by
+// WriteEncoded has been removed by RemoveEncoded
+func (ma *MappedAlignment) Removed(i int) bool {
+	if ma.index() != nil {
+		return false
+	}
+	return ma.removed[i]
+}
+
+// Record returns the i'th (0-based) record of a mapped file that was written by WriteEncoded,
+// without reading the ones before it (and even if it has been removed). Its sequence is always
+// copied onto the heap, so it can be changed (e.g. masked) while other goroutines read the same
+// record
+func (ma *MappedAlignment) Record(i int) (EncodedFastaRecord, error) {
+	err := ma.index()
+	if err != nil {
+		return EncodedFastaRecord{}, err
+	}
+	if i < 0 || i >= ma.header.n {
+		return EncodedFastaRecord{}, fmt.Errorf("%s has no record %d", ma.path, i+1)
+	}
+
+	description, fields, _, err := ma.readName(i, ma.data[ma.names[i]:])
+	if err != nil {
+		return EncodedFastaRecord{}, err
+	}
+
+	stride := ma.header.stride()
+	stored := ma.data[encodedHeaderSize+i*stride : encodedHeaderSize+(i+1)*stride]
+
+	return EncodedFastaRecord{ID: fields[0], Description: description, Seq: ma.header.unpack(stored, true), Idx: i}, nil
+}
+
+func (ma *MappedAlignment) readFasta(fn func(EncodedFastaRecord) error) (int, error) {
+
+	coding := encoding.MakeEncodingArray()
+
+	data := ma.data
+	counter := 0
+	width := -1
+
+	var fr *EncodedFastaRecord
+
+	send := func() error {
+		if width == -1 {
+			width = len(fr.Seq)
+		} else if len(fr.Seq) != width {
+			return errors.New("different length sequences in input file: is this an alignment?")
+		}
+		counter++
+		return fn(*fr)
+	}
+
+	for len(data) > 0 {
+		var line []byte
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			line, data = data, nil
+		} else {
+			line, data = data[:i], data[i+1:]
+		}
+		line = bytes.TrimRight(line, "\r")
+
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] == '>' {
+			if fr != nil {
+				err := send()
+				if err != nil {
+					return 0, err
+				}
+			}
+			description := string(line[1:])
+			fields := strings.Fields(description)
+			if len(fields) == 0 {
+				return 0, errors.New("badly formatted fasta file")
+			}
+			fr = &EncodedFastaRecord{ID: fields[0], Description: description, Idx: counter}
+			if width > 0 {
+				fr.Seq = make([]byte, 0, width)
+			}
+			continue
+		}
+
+		if fr == nil {
+			return 0, fmt.Errorf("%s isn't in fasta format (or written by gofasta encode), so can't be mapped into memory", ma.path)
+		}
+
+		for _, c := range line {
+			nuc := coding[c]
+			if nuc == 0 {
+				return 0, fmt.Errorf("invalid nucleotide in fasta file (%s)", string(c))
+			}
+			fr.Seq = append(fr.Seq, nuc)
+		}
+	}
+
+	if fr != nil {
+		err := send()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return counter, nil
+}
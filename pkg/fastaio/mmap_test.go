@@ -0,0 +1,225 @@
+package fastaio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readMapped reads every record from a mapped alignment
+func readMapped(t *testing.T, ma *MappedAlignment) []EncodedFastaRecord {
+	cErr := make(chan error)
+	cEFR := make(chan EncodedFastaRecord)
+	cDone := make(chan bool)
+
+	go ma.ReadEncode(cEFR, cErr, cDone)
+
+	records := make([]EncodedFastaRecord, 0)
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			t.Fatal(err)
+		case EFR := <-cEFR:
+			records = append(records, EFR)
+		case <-cDone:
+			n--
+		}
+	}
+	return records
+}
+
+func TestMappedAlignment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fastaFile := filepath.Join(dir, "aln.fasta")
+	err = ioutil.WriteFile(fastaFile, []byte(">s1 first\nACGT\nAC\n>s2\r\nNNGT-C\r\n>s3\nacgtrY\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ReadEncodeAlignmentToList(fastaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, unpacked := range []bool{false, true} {
+		encodedFile := filepath.Join(dir, "aln.gfe")
+		err = WriteEncoded(fastaFile, encodedFile, unpacked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncoded(encodedFile) || IsEncoded(fastaFile) {
+			t.Errorf("problem in TestMappedAlignment: IsEncoded is wrong")
+		}
+
+		before, err := ioutil.ReadFile(encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, file := range []string{fastaFile, encodedFile} {
+			ma, err := OpenMapped(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file == encodedFile {
+				n, err := ma.Len()
+				if err != nil || n != len(expected) {
+					t.Errorf("problem in TestMappedAlignment: unpacked=%t: Len gave %d (%v), expected %d", unpacked, n, err, len(expected))
+				}
+				for i := len(expected) - 1; i >= 0; i-- {
+					EFR, err := ma.Record(i)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(EFR, expected[i]) {
+						t.Errorf("problem in TestMappedAlignment: unpacked=%t: Record(%d) is %v, expected %v", unpacked, i, EFR, expected[i])
+					}
+				}
+				_, err = ma.Record(len(expected))
+				if err == nil {
+					t.Errorf("problem in TestMappedAlignment: expected an error for a record past the end")
+				}
+			} else if _, err = ma.Record(0); err == nil {
+				t.Errorf("problem in TestMappedAlignment: expected an error for Record on a fasta file")
+			}
+			records := readMapped(t, ma)
+			if len(records) != len(expected) {
+				t.Fatalf("problem in TestMappedAlignment: %s (unpacked=%t): got %d records, expected %d", file, unpacked, len(records), len(expected))
+			}
+			for i := range records {
+				if records[i].ID != expected[i].ID || records[i].Description != expected[i].Description || records[i].Idx != expected[i].Idx || !reflect.DeepEqual(records[i].Seq, expected[i].Seq) {
+					t.Errorf("problem in TestMappedAlignment: %s (unpacked=%t): record %d is %v, expected %v", file, unpacked, i, records[i], expected[i])
+				}
+				// changing a mapped sequence shouldn't change the file
+				records[i].Seq[0] = 240
+			}
+			err = ma.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		after, err := ioutil.ReadFile(encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(before, after) {
+			t.Errorf("problem in TestMappedAlignment: changing the mapped sequences changed the file")
+		}
+
+		// the usual readers should read the encoded file as if it was the fasta file
+		records, err := ReadEncodeAlignmentToList(encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records, expected) {
+			t.Errorf("problem in TestMappedAlignment: ReadEncodeAlignmentToList (unpacked=%t) gave %v, expected %v", unpacked, records, expected)
+		}
+	}
+
+	_, err = OpenMapped("stdin")
+	if err == nil {
+		t.Errorf("problem in TestMappedAlignment: expected an error mapping stdin")
+	}
+}
+
+func TestWriteEncodedPacking(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fastaFile := filepath.Join(dir, "aln.fasta")
+	err = ioutil.WriteFile(fastaFile, []byte(">s1\nACGTRYSWK\n>s2\nMBDHVN-?A\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedFile := filepath.Join(dir, "aln.gfe")
+	err = WriteEncoded(fastaFile, encodedFile, false)
+	if err == nil {
+		t.Errorf("problem in TestWriteEncodedPacking: expected an error packing all 17 codes")
+	}
+
+	err = WriteEncoded(fastaFile, encodedFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cErr := make(chan error)
+	cFR := make(chan FastaRecord)
+	cDone := make(chan bool)
+
+	go ReadAlignment(encodedFile, cFR, cErr, cDone)
+
+	seqs := make([]string, 0)
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			t.Fatal(err)
+		case FR := <-cFR:
+			seqs = append(seqs, FR.ID+" "+FR.Seq)
+		case <-cDone:
+			n--
+		}
+	}
+
+	if !reflect.DeepEqual(seqs, []string{"s1 ACGTRYSWK", "s2 MBDHVN-?A"}) {
+		t.Errorf("problem in TestWriteEncodedPacking: ReadAlignment gave %v", seqs)
+	}
+}
+
+func TestWriteEncodedEmpty(t *testing.T) {
+	dir := t.TempDir()
+
+	emptyFile := filepath.Join(dir, "empty.fasta")
+	err := ioutil.WriteFile(emptyFile, []byte{}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedFile := filepath.Join(dir, "aln.gfe")
+	err = WriteEncoded(emptyFile, encodedFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ma, err := OpenMapped(encodedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := readMapped(t, ma); len(records) != 0 {
+		t.Errorf("problem in TestWriteEncodedEmpty: got %d records", len(records))
+	}
+	ma.Close()
+
+	// the first records that are appended set the width
+	fastaFile := filepath.Join(dir, "aln.fasta")
+	err = ioutil.WriteFile(fastaFile, []byte(">s1\nACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AppendEncoded(fastaFile, encodedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ma, err = OpenMapped(encodedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ma.Close()
+	records := readMapped(t, ma)
+	if len(records) != 1 || records[0].ID != "s1" || len(records[0].Seq) != 4 {
+		t.Errorf("problem in TestWriteEncodedEmpty: got %v", records)
+	}
+}
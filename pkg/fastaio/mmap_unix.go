@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package fastaio
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps a file into memory, copy-on-write, so that the mapping can be changed (e.g.
+// masked) without changing the file. unmap must be called once the mapping isn't needed
+func mapFile(f *os.File, size int) (data []byte, unmap func() error, err error) {
+	if size == 0 {
+		return []byte{}, func() error { return nil }, nil
+	}
+	data, err = syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
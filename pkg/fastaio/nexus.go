@@ -0,0 +1,106 @@
+package fastaio
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// Charset is a named set of alignment columns for the SETS block of a nexus file.
+// Positions are pairs of 1-based, inclusive start/stop coordinates, e.g.
+// [266, 13468, 13468, 21555] for a CDS that is join(266..13468,13468..21555)
+type Charset struct {
+	Name      string
+	Positions []int
+}
+
+// nexusName quotes a taxon or charset name if it contains anything that isn't
+// safe to leave unquoted in a nexus file
+func nexusName(name string) string {
+	safe := len(name) > 0
+	for _, r := range name {
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.') {
+			safe = false
+			break
+		}
+	}
+
+	if safe {
+		return name
+	}
+
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
+
+// WriteNexus writes records as a nexus format alignment with a DATA block. If
+// any charsets are provided, they are written to a SETS block after the DATA block.
+func WriteNexus(w io.Writer, records []FastaRecord, charsets []Charset) error {
+
+	width, err := checkAlignmentRecords(records)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{
+		"#NEXUS",
+		"[" + version.Provenance() + "]",
+		"",
+		"BEGIN DATA;",
+		"\tDIMENSIONS NTAX=" + strconv.Itoa(len(records)) + " NCHAR=" + strconv.Itoa(width) + ";",
+		"\tFORMAT DATATYPE=DNA MISSING=N GAP=-;",
+		"\tMATRIX",
+	}
+
+	for _, line := range lines {
+		_, err = io.WriteString(w, line+"\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, record := range records {
+		_, err = io.WriteString(w, "\t"+nexusName(record.ID)+" "+record.Seq+"\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "\t;\nEND;\n")
+	if err != nil {
+		return err
+	}
+
+	if len(charsets) == 0 {
+		return nil
+	}
+
+	_, err = io.WriteString(w, "\nBEGIN SETS;\n")
+	if err != nil {
+		return err
+	}
+
+	for _, cs := range charsets {
+		if len(cs.Positions) == 0 || len(cs.Positions)%2 != 0 {
+			return errors.New("bad charset positions for " + cs.Name)
+		}
+		ranges := make([]string, 0)
+		for i := 0; i < len(cs.Positions); i += 2 {
+			ranges = append(ranges, strconv.Itoa(cs.Positions[i])+"-"+strconv.Itoa(cs.Positions[i+1]))
+		}
+		_, err = io.WriteString(w, "\tCHARSET "+nexusName(cs.Name)+" = "+strings.Join(ranges, " ")+";\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = io.WriteString(w, "END;\n")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package fastaio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteNexus(t *testing.T) {
+	records := []FastaRecord{
+		{ID: "q1", Seq: "ACGTAC"},
+		{ID: "hCoV-19/England/1/2020", Seq: "AC-TNN"},
+		{ID: "it's", Seq: "ACGTAA"},
+	}
+	charsets := []Charset{
+		{Name: "gene1", Positions: []int{1, 3}},
+		{Name: "gene 2", Positions: []int{2, 3, 5, 6}},
+	}
+
+	var b bytes.Buffer
+	err := WriteNexus(&b, records, charsets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(b.String(), "\n")
+	if lines[0] != "#NEXUS" || !strings.HasPrefix(lines[1], "[gofasta=") {
+		t.Errorf("problem in TestWriteNexus: got the start:\n%s\n%s", lines[0], lines[1])
+	}
+
+	// the names that aren't safe unquoted are quoted, with any quotes in them doubled
+	desired := "\nBEGIN DATA;\n" +
+		"\tDIMENSIONS NTAX=3 NCHAR=6;\n" +
+		"\tFORMAT DATATYPE=DNA MISSING=N GAP=-;\n" +
+		"\tMATRIX\n" +
+		"\tq1 ACGTAC\n" +
+		"\t'hCoV-19/England/1/2020' AC-TNN\n" +
+		"\t'it''s' ACGTAA\n" +
+		"\t;\nEND;\n" +
+		"\nBEGIN SETS;\n" +
+		"\tCHARSET gene1 = 1-3;\n" +
+		"\tCHARSET 'gene 2' = 2-3 5-6;\n" +
+		"END;\n"
+	if strings.Join(lines[2:], "\n") != desired {
+		t.Errorf("problem in TestWriteNexus: got:\n%s\nwanted:\n%s", strings.Join(lines[2:], "\n"), desired)
+	}
+
+	// without charsets, there isn't a SETS block
+	b.Reset()
+	err = WriteNexus(&b, records, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "SETS") || !strings.HasSuffix(b.String(), "\t;\nEND;\n") {
+		t.Errorf("problem in TestWriteNexus: got a SETS block without charsets:\n%s", b.String())
+	}
+
+	err = WriteNexus(&b, records, []Charset{{Name: "bad", Positions: []int{1, 2, 3}}})
+	if err == nil {
+		t.Error("problem in TestWriteNexus: expected an error for a charset with an odd number of positions")
+	}
+
+	err = WriteNexus(&b, []FastaRecord{{ID: "q1", Seq: "ACGT"}, {ID: "q2", Seq: "AC"}}, nil)
+	if err == nil {
+		t.Error("problem in TestWriteNexus: expected an error for records that aren't the same length")
+	}
+}
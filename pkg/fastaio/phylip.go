@@ -0,0 +1,90 @@
+package fastaio
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// phylipBlockWidth is the number of alignment columns written per line/block
+// in interleaved phylip output
+const phylipBlockWidth = 60
+
+// checkAlignmentRecords makes sure there is at least one record and that all
+// the records are the same length, and returns that length
+func checkAlignmentRecords(records []FastaRecord) (int, error) {
+	if len(records) == 0 {
+		return 0, errors.New("no sequences to write")
+	}
+
+	width := len(records[0].Seq)
+	for _, record := range records {
+		if len(record.Seq) != width {
+			return 0, errors.New("different length sequences in output: is this an alignment?")
+		}
+	}
+
+	return width, nil
+}
+
+// phylipName replaces whitespace in a sequence name, which relaxed phylip
+// uses to separate the name from the sequence
+func phylipName(id string) string {
+	return strings.Join(strings.Fields(id), "_")
+}
+
+// WritePhylip writes records as a relaxed phylip format alignment: names can be
+// any length and are separated from the sequence by a single space. If interleaved
+// is true the sequences are written in blocks of phylipBlockWidth columns.
+func WritePhylip(w io.Writer, records []FastaRecord, interleaved bool) error {
+
+	width, err := checkAlignmentRecords(records)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, strconv.Itoa(len(records))+" "+strconv.Itoa(width)+"\n")
+	if err != nil {
+		return err
+	}
+
+	if !interleaved {
+		for _, record := range records {
+			_, err = io.WriteString(w, phylipName(record.ID)+" "+record.Seq+"\n")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for start := 0; start < width; start += phylipBlockWidth {
+		stop := start + phylipBlockWidth
+		if stop > width {
+			stop = width
+		}
+
+		if start > 0 {
+			_, err = io.WriteString(w, "\n")
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, record := range records {
+			if start == 0 {
+				_, err = io.WriteString(w, phylipName(record.ID)+" ")
+				if err != nil {
+					return err
+				}
+			}
+			_, err = io.WriteString(w, record.Seq[start:stop]+"\n")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package fastaio
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWritePhylip(t *testing.T) {
+	records := []FastaRecord{
+		{ID: "q1", Seq: "ACGT"},
+		{ID: "a much longer name", Seq: "AC-T"},
+		{ID: "q3\twith\ttabs", Seq: "NNGT"},
+	}
+
+	var b bytes.Buffer
+	err := WritePhylip(&b, records, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// relaxed phylip: the names aren't padded or cut to a fixed width, but their whitespace
+	// is replaced, because it separates the name from the sequence
+	desired := "3 4\nq1 ACGT\na_much_longer_name AC-T\nq3_with_tabs NNGT\n"
+	if b.String() != desired {
+		t.Errorf("problem in TestWritePhylip: got:\n%s\nwanted:\n%s", b.String(), desired)
+	}
+}
+
+func TestWritePhylipInterleaved(t *testing.T) {
+	tests := []struct {
+		width  int
+		blocks int
+	}{
+		{1, 1},
+		{phylipBlockWidth - 1, 1},
+		{phylipBlockWidth, 1},
+		{phylipBlockWidth + 1, 2},
+		{2*phylipBlockWidth + 7, 3},
+	}
+
+	for _, tt := range tests {
+		seq1 := strings.Repeat("A", tt.width)
+		seq2 := strings.Repeat("C", tt.width)
+		records := []FastaRecord{{ID: "q1", Seq: seq1}, {ID: "query 2", Seq: seq2}}
+
+		var b bytes.Buffer
+		err := WritePhylip(&b, records, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blocks := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n\n")
+		if len(blocks) != tt.blocks {
+			t.Errorf("problem in TestWritePhylipInterleaved: width %d: got %d blocks, wanted %d:\n%s", tt.width, len(blocks), tt.blocks, b.String())
+			continue
+		}
+
+		// the names are only in the first block, and the sequences are put back together
+		// from the blocks in order
+		got := make([]string, 2)
+		for i, block := range blocks {
+			lines := strings.Split(block, "\n")
+			if i == 0 {
+				if lines[0] != "2 "+strconv.Itoa(tt.width) {
+					t.Errorf("problem in TestWritePhylipInterleaved: width %d: got header %s", tt.width, lines[0])
+				}
+				lines = lines[1:]
+				if !strings.HasPrefix(lines[0], "q1 ") || !strings.HasPrefix(lines[1], "query_2 ") {
+					t.Errorf("problem in TestWritePhylipInterleaved: width %d: got names %s and %s", tt.width, lines[0], lines[1])
+				}
+				lines[0] = strings.TrimPrefix(lines[0], "q1 ")
+				lines[1] = strings.TrimPrefix(lines[1], "query_2 ")
+			}
+			if len(lines) != 2 {
+				t.Fatalf("problem in TestWritePhylipInterleaved: width %d: block %d has %d lines", tt.width, i, len(lines))
+			}
+			for j, line := range lines {
+				if len(line) > phylipBlockWidth {
+					t.Errorf("problem in TestWritePhylipInterleaved: width %d: block %d is %d wide", tt.width, i, len(line))
+				}
+				got[j] += line
+			}
+		}
+		if got[0] != seq1 || got[1] != seq2 {
+			t.Errorf("problem in TestWritePhylipInterleaved: width %d: got %s and %s", tt.width, got[0], got[1])
+		}
+	}
+}
+
+func TestWritePhylipErrors(t *testing.T) {
+	var b bytes.Buffer
+
+	err := WritePhylip(&b, nil, false)
+	if err == nil {
+		t.Error("problem in TestWritePhylipErrors: expected an error for no records")
+	}
+
+	err = WritePhylip(&b, []FastaRecord{{ID: "q1", Seq: "ACGT"}, {ID: "q2", Seq: "ACG"}}, true)
+	if err == nil {
+		t.Error("problem in TestWritePhylipErrors: expected an error for records that aren't the same length")
+	}
+}
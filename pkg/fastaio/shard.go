@@ -0,0 +1,207 @@
+package fastaio
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// unassigned is the group of sequences that aren't in the metadata, or have no value in its column
+const unassigned = "unassigned"
+
+// shard is one of the files that a Sharder writes
+type shard struct {
+	group string
+	path  string
+	f     *os.File
+	w     *bufio.Writer
+	n     int
+}
+
+// Sharder splits an output alignment into several fasta files: by a column of a metadata file, so
+// that e.g. each week or lab gets its own file, and/or into shards of at most so many sequences.
+// A manifest of the files is written when it is flushed. A Sharder is an OutputWriter, which
+// renames the sequences with md's header template, if it has one, after grouping them by name
+type Sharder struct {
+	prefix string
+	size   int
+	md     *metadata.Metadata
+	column string // the column of md that sequences are grouped by, if any
+	shards []*shard
+	open   map[string]*shard // the shard that each group is writing to
+	counts map[string]int    // how many shards each group has had
+	paths  map[string]string // which group wrote each file, to catch clashes
+}
+
+// ShardPrefix is what the files from sharding outfile are called, up to the shard's name:
+// outfile without any fasta extension
+func ShardPrefix(outfile string) string {
+	for _, ext := range []string{".fasta", ".fas", ".fa"} {
+		if strings.HasSuffix(outfile, ext) {
+			return strings.TrimSuffix(outfile, ext)
+		}
+	}
+	return outfile
+}
+
+// NewSharder makes a Sharder that writes files called prefix.<group>.<n>.fasta (with either part
+// left out if it isn't used), and a manifest called prefix.manifest.tsv. If size > 0, each file has at
+// most size sequences. If column isn't empty, sequences are grouped by that column of md
+func NewSharder(prefix string, size int, md *metadata.Metadata, column string) (*Sharder, error) {
+
+	if size < 0 {
+		return nil, usage.New("--shard-size can't be negative")
+	}
+	if len(column) > 0 && md == nil {
+		return nil, usage.New("--shard-by needs --metadata or --name-format")
+	}
+	if len(column) > 0 && !md.HasColumn(column) {
+		return nil, usage.Errorf("--shard-by: there is no column called %s in %s", column, md.Path)
+	}
+	if size == 0 && len(column) == 0 {
+		return nil, usage.New("sharding needs --shard-size or --shard-by")
+	}
+	if prefix == "stdout" {
+		return nil, usage.New("sharded output needs a file name (--fasta-out) to use as the prefix, not stdout")
+	}
+
+	sh := &Sharder{
+		prefix: prefix,
+		size:   size,
+		md:     md,
+		column: column,
+		open:   make(map[string]*shard),
+		counts: make(map[string]int),
+		paths:  make(map[string]string),
+	}
+
+	return sh, nil
+}
+
+// safeName makes a group usable in a file name
+func safeName(group string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, group)
+}
+
+// WriteRecord writes a record to its group's current shard, starting a new shard if that one is full
+func (sh *Sharder) WriteRecord(FR FastaRecord) error {
+
+	group := ""
+	if len(sh.column) > 0 {
+		group, _ = sh.md.Value(FR.ID, sh.column)
+		if len(group) == 0 {
+			group = unassigned
+		}
+	}
+
+	s, ok := sh.open[group]
+	if !ok || (sh.size > 0 && s.n == sh.size) {
+		var err error
+		s, err = sh.newShard(group)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := s.w.WriteString(">" + sh.md.Header(FR.ID, FR.ID) + "\n" + FR.Seq + "\n")
+	if err != nil {
+		return err
+	}
+	s.n++
+
+	return nil
+}
+
+func (sh *Sharder) newShard(group string) (*shard, error) {
+
+	if old, ok := sh.open[group]; ok {
+		err := old.close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sh.counts[group]++
+
+	parts := []string{sh.prefix}
+	if len(sh.column) > 0 {
+		parts = append(parts, safeName(group))
+	}
+	if sh.size > 0 {
+		parts = append(parts, fmt.Sprintf("%04d", sh.counts[group]))
+	}
+	path := strings.Join(parts, ".") + ".fasta"
+
+	if other, ok := sh.paths[path]; ok {
+		return nil, fmt.Errorf("groups %q and %q would both be written to %s", other, group, path)
+	}
+	sh.paths[path] = group
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &shard{group: group, path: path, f: f, w: bufio.NewWriter(f)}
+	sh.open[group] = s
+	sh.shards = append(sh.shards, s)
+
+	return s, nil
+}
+
+func (s *shard) close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if err != nil {
+		return err
+	}
+	err = s.f.Close()
+	s.f = nil
+	return err
+}
+
+// ManifestPath is where the manifest of the shards is written
+func (sh *Sharder) ManifestPath() string {
+	return sh.prefix + ".manifest.tsv"
+}
+
+// Flush closes every shard and writes the manifest, which lists each file (relative to the
+// manifest's directory), its group and how many sequences it has, in the order they were started
+func (sh *Sharder) Flush() error {
+
+	for _, s := range sh.shards {
+		err := s.close()
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(sh.ManifestPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.WriteString("##" + version.Provenance() + "\nfile\tgroup\tsequences\n")
+	for _, s := range sh.shards {
+		w.WriteString(filepath.Base(s.path) + "\t" + s.group + "\t" + strconv.Itoa(s.n) + "\n")
+	}
+
+	return w.Flush()
+}
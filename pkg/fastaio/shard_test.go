@@ -0,0 +1,145 @@
+package fastaio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+func TestSharder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	metadataFile := filepath.Join(dir, "metadata.csv")
+	err = ioutil.WriteFile(metadataFile, []byte("name,week,lab\ns1,12,A\ns2,13,B\ns3,12,A\ns4,12 b,A\ns5,,B\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := metadata.Load(metadataFile, nil, nil, "date", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []FastaRecord{
+		{ID: "s1", Seq: "A"}, {ID: "s2", Seq: "C"}, {ID: "s3", Seq: "G"},
+		{ID: "s4", Seq: "T"}, {ID: "s5", Seq: "N"}, {ID: "s6", Seq: "-"},
+	}
+
+	tests := []struct {
+		size     int
+		column   string
+		files    map[string]string
+		manifest string
+	}{
+		{4, "", map[string]string{
+			"out.0001.fasta": ">s1\nA\n>s2\nC\n>s3\nG\n>s4\nT\n",
+			"out.0002.fasta": ">s5\nN\n>s6\n-\n",
+		}, "out.0001.fasta\t\t4\nout.0002.fasta\t\t2\n"},
+		{0, "week", map[string]string{
+			"out.12.fasta":         ">s1\nA\n>s3\nG\n",
+			"out.13.fasta":         ">s2\nC\n",
+			"out.12_b.fasta":       ">s4\nT\n",
+			"out.unassigned.fasta": ">s5\nN\n>s6\n-\n",
+		}, "out.12.fasta\t12\t2\nout.13.fasta\t13\t1\nout.12_b.fasta\t12 b\t1\nout.unassigned.fasta\tunassigned\t2\n"},
+		{2, "lab", map[string]string{
+			"out.A.0001.fasta":          ">s1\nA\n>s3\nG\n",
+			"out.A.0002.fasta":          ">s4\nT\n",
+			"out.B.0001.fasta":          ">s2\nC\n>s5\nN\n",
+			"out.unassigned.0001.fasta": ">s6\n-\n",
+		}, "out.A.0001.fasta\tA\t2\nout.B.0001.fasta\tB\t2\nout.A.0002.fasta\tA\t1\nout.unassigned.0001.fasta\tunassigned\t1\n"},
+	}
+
+	for _, test := range tests {
+		sub, err := ioutil.TempDir(dir, "shards")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sh, err := NewSharder(ShardPrefix(filepath.Join(sub, "out.fasta")), test.size, md, test.column)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, FR := range records {
+			err = sh.WriteRecord(FR)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = sh.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, expected := range test.files {
+			got, err := ioutil.ReadFile(filepath.Join(sub, name))
+			if err != nil {
+				t.Errorf("problem in TestSharder: %d %q: %v", test.size, test.column, err)
+				continue
+			}
+			if string(got) != expected {
+				t.Errorf("problem in TestSharder: %d %q: %s is\n%s\nexpected\n%s", test.size, test.column, name, got, expected)
+			}
+		}
+
+		manifest, err := ioutil.ReadFile(filepath.Join(sub, "out.manifest.tsv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "##" + version.Provenance() + "\nfile\tgroup\tsequences\n" + test.manifest
+		if string(manifest) != expected {
+			t.Errorf("problem in TestSharder: %d %q: the manifest is\n%s\nexpected\n%s", test.size, test.column, manifest, expected)
+		}
+	}
+
+	// with a header template, the sequences are grouped by their own names, and written renamed
+	err = md.SetHeaderTemplate("{name}|{lab}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := ioutil.TempDir(dir, "shards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, err := NewSharder(filepath.Join(sub, "out"), 0, md, "week")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, FR := range records[:3] {
+		err = sh.WriteRecord(FR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = sh.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(sub, "out.12.fasta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != ">s1|A\nA\n>s3|A\nG\n" {
+		t.Errorf("problem in TestSharder: with a header template, out.12.fasta is\n%s", got)
+	}
+
+	_, err = NewSharder(filepath.Join(dir, "out"), 0, md, "month")
+	if !usage.Is(err) {
+		t.Errorf("problem in TestSharder: expected a usage error for a missing column, got %v", err)
+	}
+	_, err = NewSharder(filepath.Join(dir, "out"), 0, nil, "week")
+	if !usage.Is(err) {
+		t.Errorf("problem in TestSharder: expected a usage error for --shard-by without --metadata, got %v", err)
+	}
+	_, err = NewSharder("stdout", 10, nil, "")
+	if !usage.Is(err) {
+		t.Errorf("problem in TestSharder: expected a usage error for stdout, got %v", err)
+	}
+}
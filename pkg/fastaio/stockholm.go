@@ -0,0 +1,76 @@
+package fastaio
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// appendAlignmentBlockLine adds the sequence on one line of a blocked alignment
+// (Stockholm/Clustal) to the record with the same name, creating a new record if
+// this is the first block that name has been seen in
+func appendAlignmentBlockLine(records []FastaRecord, lookup map[string]int, name string, seq string) []FastaRecord {
+	if i, ok := lookup[name]; ok {
+		records[i].Seq = records[i].Seq + seq
+		return records
+	}
+
+	lookup[name] = len(records)
+	return append(records, FastaRecord{ID: name, Description: name, Seq: seq, Idx: len(records)})
+}
+
+// ReadStockholm reads a Stockholm format alignment into a slice of FastaRecords,
+// in the order that the sequences first appear in the file. Markup lines (#=GF,
+// #=GS, #=GR, #=GC) are ignored, and '.' gaps are normalised to '-'.
+// Only the first alignment in the file (up to the first "//") is read.
+func ReadStockholm(r io.Reader) ([]FastaRecord, error) {
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024*1024)
+
+	records := make([]FastaRecord, 0)
+	lookup := make(map[string]int)
+
+	first := true
+
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+
+		if first {
+			if !strings.HasPrefix(line, "# STOCKHOLM") {
+				return []FastaRecord{}, errors.New("badly formatted stockholm file")
+			}
+			first = false
+			continue
+		}
+
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if line == "//" {
+			break
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return []FastaRecord{}, errors.New("badly formatted stockholm file: couldn't parse line: " + line)
+		}
+
+		seq := strings.ToUpper(strings.ReplaceAll(fields[1], ".", "-"))
+
+		records = appendAlignmentBlockLine(records, lookup, fields[0], seq)
+	}
+
+	err := s.Err()
+	if err != nil {
+		return []FastaRecord{}, err
+	}
+
+	if first {
+		return []FastaRecord{}, errors.New("empty stockholm file")
+	}
+
+	return records, nil
+}
@@ -0,0 +1,238 @@
+package fastaio
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// the name of the count of records removed by RemoveEncoded in the run summary
+const removedCount = "removed"
+
+// encodedContents is what is needed to rewrite the end of an encoded file: its header, the
+// descriptions of its records and the ones that have been removed
+type encodedContents struct {
+	h            encodedHeader
+	descriptions []string
+	ids          []string
+	removed      []int
+}
+
+// readContents reads the header and name table of a file that was written by WriteEncoded
+func readContents(file string) (encodedContents, error) {
+
+	ma, err := OpenMapped(file)
+	if err != nil {
+		return encodedContents{}, err
+	}
+	defer ma.Close()
+
+	n, err := ma.Len()
+	if usage.Is(err) {
+		return encodedContents{}, usage.Errorf("%s wasn't written by gofasta encode, so it can't be updated", file)
+	}
+	if err != nil {
+		return encodedContents{}, err
+	}
+
+	c := encodedContents{h: ma.header, descriptions: make([]string, n), ids: make([]string, n)}
+	// the table is part of the mapping, which is about to go
+	c.h.table = append([]byte{}, ma.header.table...)
+
+	for i := 0; i < n; i++ {
+		description, fields, _, err := ma.readName(i, ma.data[ma.names[i]:])
+		if err != nil {
+			return encodedContents{}, err
+		}
+		c.descriptions[i] = description
+		c.ids[i] = fields[0]
+		if ma.removed[i] {
+			c.removed = append(c.removed, i)
+		}
+	}
+
+	return c, nil
+}
+
+// rewrite starts a new copy of an encoded file, in a temporary file in the same directory, with
+// its records' sequences copied over and the copy open for writing more from there on. finish
+// writes the rest of the copy and renames it over the file, so that a failure (or an interruption)
+// part of the way through leaves the file as it was, and readers that already have it open (or
+// mapped) keep reading the old one
+func (c *encodedContents) rewrite(file string) (*os.File, error) {
+
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Chmod(info.Mode().Perm())
+	if err == nil {
+		_, err = io.CopyN(f, in, int64(encodedHeaderSize+c.h.n*c.h.stride()))
+	}
+	if err != nil {
+		abandon(f)
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// abandon removes a copy that was started by rewrite, leaving the file as it was
+func abandon(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// finish writes the name table and the removed records after whatever has been written to w,
+// and then the header, and renames the copy (which it closes) over file. If anything goes wrong,
+// the copy is removed instead
+func (c *encodedContents) finish(f *os.File, w *bufio.Writer, file string) error {
+
+	err := writeTail(w, c.descriptions, c.removed)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		c.h.n = len(c.descriptions)
+		c.h.removed = len(c.removed) > 0
+		_, err = f.WriteAt(c.h.bytes(), 0)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		abandon(f)
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), file)
+}
+
+// AppendEncoded adds the records in the alignment in inFile to the end of encodedFile (which was
+// written by WriteEncoded), so that a growing alignment can be kept up to date without encoding
+// it all again. The new records have to be the same width as the ones already there. If the file is
+// packed and the new records use codes that it doesn't, the codes are added to its table, as long as
+// there is room for them
+func AppendEncoded(inFile string, encodedFile string) error {
+
+	c, err := readContents(encodedFile)
+	if err != nil {
+		return err
+	}
+
+	// a file with no records gets its width from the first new one
+	if c.h.n == 0 {
+		c.h.width = -1
+	}
+
+	p := &packer{}
+	for i, nuc := range c.h.table {
+		p.table = append(p.table, nuc)
+		p.lookup[nuc] = i + 1
+	}
+
+	f, err := c.rewrite(encodedFile)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	added, err := writeRecords(inFile, w, &c.h, p)
+	if err != nil {
+		abandon(f)
+		return err
+	}
+
+	c.descriptions = append(c.descriptions, added...)
+	c.h.table = p.table
+
+	return c.finish(f, w, encodedFile)
+}
+
+// RemoveEncoded marks the records in encodedFile (which was written by WriteEncoded) whose names
+// are in namesFile (one per line) as removed, so that they are skipped when it is read. They are
+// still stored, so that the other records keep their places (e.g. in gofasta sketch's index), until
+// the file is encoded again from scratch. Names that aren't in the file are ignored
+func RemoveEncoded(encodedFile string, namesFile string) error {
+
+	names, err := readNameList(namesFile)
+	if err != nil {
+		return err
+	}
+
+	c, err := readContents(encodedFile)
+	if err != nil {
+		return err
+	}
+
+	removed := make(map[int]bool)
+	for _, i := range c.removed {
+		removed[i] = true
+	}
+
+	counter := 0
+	for i, id := range c.ids {
+		if names[id] && !removed[i] {
+			c.removed = append(c.removed, i)
+			counter++
+		}
+	}
+	sort.Ints(c.removed)
+
+	summary.Add(removedCount, counter)
+
+	f, err := c.rewrite(encodedFile)
+	if err != nil {
+		return err
+	}
+
+	return c.finish(f, bufio.NewWriter(f), encodedFile)
+}
+
+// readNameList reads one record name per line (the first field of each line), skipping blank lines
+func readNameList(namesFile string) (map[string]bool, error) {
+
+	f, err := os.Open(namesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[string]bool)
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) > 0 {
+			names[fields[0]] = true
+		}
+	}
+
+	return names, s.Err()
+}
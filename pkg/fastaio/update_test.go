@@ -0,0 +1,208 @@
+package fastaio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateEncoded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	first := write("first.fasta", ">s1\nACGT\n>s2\nACGA\n>s3\nNNGT\n")
+	second := write("second.fasta", ">s4\nRYGT\n>s5\nAC-T\n")
+	all := write("all.fasta", ">s1\nACGT\n>s2\nACGA\n>s3\nNNGT\n>s4\nRYGT\n>s5\nAC-T\n")
+	kept := write("kept.fasta", ">s1\nACGT\n>s3\nNNGT\n>s4\nRYGT\n")
+	names := write("names.txt", "s2\n\ns5 withdrawn\nnot_there\n")
+	wide := write("wide.fasta", ">s6\nACGTA\n")
+
+	for _, unpacked := range []bool{false, true} {
+		encodedFile := filepath.Join(dir, "aln.gfe")
+		err = WriteEncoded(first, encodedFile, unpacked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = AppendEncoded(second, encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := ReadEncodeAlignmentToList(all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadEncodeAlignmentToList(encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("problem in TestUpdateEncoded: unpacked=%t: after --append got %v, expected %v", unpacked, got, expected)
+		}
+
+		// records of a different width aren't appended, and the file is left as it was
+		err = AppendEncoded(wide, encodedFile)
+		if err == nil {
+			t.Errorf("problem in TestUpdateEncoded: unpacked=%t: expected an error appending a wider record", unpacked)
+		}
+		got, err = ReadEncodeAlignmentToList(encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("problem in TestUpdateEncoded: unpacked=%t: after a failed --append got %v, expected %v", unpacked, got, expected)
+		}
+
+		err = RemoveEncoded(encodedFile, names)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err = ReadEncodeAlignmentToList(kept)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = ReadEncodeAlignmentToList(encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("problem in TestUpdateEncoded: unpacked=%t: after --remove got %v, expected %v", unpacked, got, expected)
+		}
+
+		// removed records keep their places
+		ma, err := OpenMapped(encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := ma.Len()
+		if err != nil || n != 5 {
+			t.Errorf("problem in TestUpdateEncoded: unpacked=%t: Len gave %d (%v), expected 5", unpacked, n, err)
+		}
+		for i, removed := range []bool{false, true, false, false, true} {
+			if ma.Removed(i) != removed {
+				t.Errorf("problem in TestUpdateEncoded: unpacked=%t: Removed(%d) should be %t", unpacked, i, removed)
+			}
+		}
+		EFR, err := ma.Record(4)
+		if err != nil || EFR.ID != "s5" {
+			t.Errorf("problem in TestUpdateEncoded: unpacked=%t: Record(4) gave %v (%v)", unpacked, EFR, err)
+		}
+		ma.Close()
+
+		// and stay removed when more are appended
+		err = AppendEncoded(write("third.fasta", ">s6\nACGG\n"), encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = ReadEncodeAlignmentToList(encodedFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 4 || got[3].ID != "s6" || got[3].Idx != 3 {
+			t.Errorf("problem in TestUpdateEncoded: unpacked=%t: after removing and appending got %v", unpacked, got)
+		}
+	}
+
+	err = AppendEncoded(second, first)
+	if err == nil {
+		t.Errorf("problem in TestUpdateEncoded: expected an error appending to a fasta file")
+	}
+}
+
+// an update writes a new copy of the file and renames it over the old one, so a reader that
+// already has the file mapped keeps its records, a failed update leaves the file exactly as it
+// was, and neither leaves a temporary file behind
+func TestUpdateEncodedRename(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	first := write("first.fasta", ">s1\nACGT\n>s2\nACGA\n")
+	second := write("second.fasta", ">s3\nRYGT\n")
+	wide := write("wide.fasta", ">s4\nACGTA\n")
+	names := write("names.txt", "s1\n")
+
+	encodedFile := filepath.Join(dir, "aln.gfe")
+	err := WriteEncoded(first, encodedFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chmod(encodedFile, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ma, err := OpenMapped(encodedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ma.Close()
+
+	err = AppendEncoded(second, encodedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RemoveEncoded(encodedFile, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := ma.Len()
+	if err != nil || n != 2 || ma.Removed(0) {
+		t.Errorf("problem in TestUpdateEncodedRename: the mapping from before the updates has %d records (%v), and Removed(0) is %t", n, err, ma.Removed(0))
+	}
+
+	before, err := ioutil.ReadFile(encodedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AppendEncoded(wide, encodedFile)
+	if err == nil {
+		t.Errorf("problem in TestUpdateEncodedRename: expected an error appending a wider record")
+	}
+	after, err := ioutil.ReadFile(encodedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("problem in TestUpdateEncodedRename: a failed --append changed the file")
+	}
+
+	info, err := os.Stat(encodedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("problem in TestUpdateEncodedRename: the updated file's mode is %v, expected %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) > 0 {
+		t.Errorf("problem in TestUpdateEncodedRename: the updates left %v behind", files)
+	}
+}
@@ -0,0 +1,239 @@
+package fastaio
+
+import (
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// OutputWriter is somewhere that a pipeline writes its output sequences, one at a time, in order.
+// Flush is called once, after the last record, and must write anything that is still buffered
+// (but doesn't close the underlying file). Any implementation can be given to a pipeline that
+// takes one, e.g. to write to a database or an object store
+type OutputWriter interface {
+	WriteRecord(FR FastaRecord) error
+	Flush() error
+}
+
+// OutputFormats are the formats that NewOutputWriter can write
+var OutputFormats = []string{"fasta", "phylip", "phylip-interleaved", "nexus", "diff"}
+
+// NewOutputWriter returns an OutputWriter for format (see OutputFormats) that writes to w. The
+// nexus format writes charsets (which can be nil), and the diff format needs the reference
+func NewOutputWriter(w io.Writer, format string, charsets []Charset, ref []byte) (OutputWriter, error) {
+	switch format {
+	case "fasta":
+		return NewFastaWriter(w), nil
+	case "phylip":
+		return NewPhylipWriter(w, false), nil
+	case "phylip-interleaved":
+		return NewPhylipWriter(w, true), nil
+	case "nexus":
+		return NewNexusWriter(w, charsets), nil
+	case "diff":
+		if len(ref) == 0 {
+			return nil, usage.New("--out-format diff needs the --reference")
+		}
+		return NewDiffWriter(w, ref), nil
+	}
+	return nil, usage.Errorf("unrecognised output format: %s (choose one of: fasta, phylip, phylip-interleaved, nexus, diff)", format)
+}
+
+// FastaWriter writes each record as soon as it is given it, with the whole sequence on one line.
+// It doesn't buffer anything, so the underlying file's offset is always the end of the last record
+type FastaWriter struct {
+	w io.Writer
+}
+
+// NewFastaWriter returns a FastaWriter that writes to w
+func NewFastaWriter(w io.Writer) *FastaWriter {
+	return &FastaWriter{w: w}
+}
+
+// WriteRecord writes one record
+func (fw *FastaWriter) WriteRecord(FR FastaRecord) error {
+	_, err := io.WriteString(fw.w, ">"+FR.ID+"\n"+FR.Seq+"\n")
+	return err
+}
+
+// Flush does nothing, since nothing is buffered
+func (fw *FastaWriter) Flush() error {
+	return nil
+}
+
+// RNAWriter writes its records to another OutputWriter as RNA, with their Ts converted to Us
+type RNAWriter struct {
+	out OutputWriter
+}
+
+// NewRNAWriter returns an RNAWriter that writes to out
+func NewRNAWriter(out OutputWriter) *RNAWriter {
+	return &RNAWriter{out: out}
+}
+
+// WriteRecord writes one record, as RNA
+func (rw *RNAWriter) WriteRecord(FR FastaRecord) error {
+	FR.Seq = alphabet.ToRNA(FR.Seq)
+	return rw.out.WriteRecord(FR)
+}
+
+// Flush flushes the OutputWriter that rw writes to
+func (rw *RNAWriter) Flush() error {
+	return rw.out.Flush()
+}
+
+// HeaderWriter writes its records to another OutputWriter, renamed with md's header template
+// (see metadata.Metadata.SetHeaderTemplate), so that they are labelled the way e.g. tree software
+// expects. Records that aren't in the metadata keep their names
+type HeaderWriter struct {
+	out OutputWriter
+	md  *metadata.Metadata
+}
+
+// NewHeaderWriter returns a HeaderWriter that writes to out
+func NewHeaderWriter(out OutputWriter, md *metadata.Metadata) *HeaderWriter {
+	return &HeaderWriter{out: out, md: md}
+}
+
+// WriteRecord writes one record, renamed
+func (hw *HeaderWriter) WriteRecord(FR FastaRecord) error {
+	FR.ID = hw.md.Header(FR.ID, FR.ID)
+	FR.Description = FR.ID
+	return hw.out.WriteRecord(FR)
+}
+
+// Flush flushes the OutputWriter that hw writes to
+func (hw *HeaderWriter) Flush() error {
+	return hw.out.Flush()
+}
+
+// PhylipWriter keeps the records until it is flushed, since the phylip header needs the number
+// of sequences, and then writes them with WritePhylip
+type PhylipWriter struct {
+	w           io.Writer
+	interleaved bool
+	records     []FastaRecord
+}
+
+// NewPhylipWriter returns a PhylipWriter that writes to w
+func NewPhylipWriter(w io.Writer, interleaved bool) *PhylipWriter {
+	return &PhylipWriter{w: w, interleaved: interleaved}
+}
+
+// WriteRecord keeps one record
+func (pw *PhylipWriter) WriteRecord(FR FastaRecord) error {
+	pw.records = append(pw.records, FR)
+	return nil
+}
+
+// Flush writes every record
+func (pw *PhylipWriter) Flush() error {
+	return WritePhylip(pw.w, pw.records, pw.interleaved)
+}
+
+// NexusWriter keeps the records until it is flushed, and then writes them with WriteNexus
+type NexusWriter struct {
+	w        io.Writer
+	charsets []Charset
+	records  []FastaRecord
+}
+
+// NewNexusWriter returns a NexusWriter that writes to w, with a charset block if charsets
+// isn't empty
+func NewNexusWriter(w io.Writer, charsets []Charset) *NexusWriter {
+	return &NexusWriter{w: w, charsets: charsets}
+}
+
+// WriteRecord keeps one record
+func (nw *NexusWriter) WriteRecord(FR FastaRecord) error {
+	nw.records = append(nw.records, FR)
+	return nil
+}
+
+// Flush writes every record
+func (nw *NexusWriter) Flush() error {
+	return WriteNexus(nw.w, nw.records, nw.charsets)
+}
+
+// DiffWriter writes each record as its differences from the reference, in the same format as
+// MAPLE: the file starts with the reference, and then each record is a >name line and one line
+// per difference. A run of Ns or gaps is written as n or -, its 1-based start, and its length
+// (e.g. "n	1	54"), and any other difference as the query's nucleotide and its position
+// (e.g. "T	241"). Other sites where the reference is N aren't differences
+type DiffWriter struct {
+	w       io.Writer
+	ref     []byte
+	started bool
+}
+
+// NewDiffWriter returns a DiffWriter that writes to w, with the differences from ref
+func NewDiffWriter(w io.Writer, ref []byte) *DiffWriter {
+	return &DiffWriter{w: w, ref: ref}
+}
+
+// diffRun is the kind of a run of missing data: n or -, or 0 for anything else
+func diffRun(nuc byte) byte {
+	switch nuc {
+	case 'N', 'n', '?':
+		return 'n'
+	case '-':
+		return '-'
+	}
+	return 0
+}
+
+// WriteRecord writes one record's differences, and the reference first if this is the first
+func (dw *DiffWriter) WriteRecord(FR FastaRecord) error {
+
+	if len(FR.Seq) != len(dw.ref) {
+		return errors.New(FR.ID + " isn't the same length as the reference: is this an alignment?")
+	}
+
+	if !dw.started {
+		_, err := io.WriteString(dw.w, ">reference\n"+string(dw.ref)+"\n")
+		if err != nil {
+			return err
+		}
+		dw.started = true
+	}
+
+	b := make([]byte, 0)
+	b = append(b, '>')
+	b = append(b, FR.ID...)
+	b = append(b, '\n')
+
+	for i := 0; i < len(FR.Seq); i++ {
+		nuc := FR.Seq[i]
+		if kind := diffRun(nuc); kind != 0 {
+			j := i + 1
+			for j < len(FR.Seq) && diffRun(FR.Seq[j]) == kind {
+				j++
+			}
+			b = append(b, kind, '\t')
+			b = strconv.AppendInt(b, int64(i+1), 10)
+			b = append(b, '\t')
+			b = strconv.AppendInt(b, int64(j-i), 10)
+			b = append(b, '\n')
+			i = j - 1
+			continue
+		}
+		if nuc == dw.ref[i] || dw.ref[i] == 'N' {
+			continue
+		}
+		b = append(b, nuc, '\t')
+		b = strconv.AppendInt(b, int64(i+1), 10)
+		b = append(b, '\n')
+	}
+
+	_, err := dw.w.Write(b)
+	return err
+}
+
+// Flush does nothing, since nothing is buffered
+func (dw *DiffWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,163 @@
+package fastaio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func TestDiffWriter(t *testing.T) {
+	var b bytes.Buffer
+
+	out, err := NewOutputWriter(&b, "diff", nil, []byte("ACGTNACGTA"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []FastaRecord{
+		{ID: "q1", Seq: "ACGTNACGTA"},
+		{ID: "q2", Seq: "NNGTAAC--T"},
+		{ID: "q3", Seq: "?CGT-ACGTN"},
+	}
+	for _, FR := range records {
+		err = out.WriteRecord(FR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desired := ">reference\nACGTNACGTA\n" +
+		">q1\nn\t5\t1\n" +
+		">q2\nn\t1\t2\n-\t8\t2\nT\t10\n" +
+		">q3\nn\t1\t1\n-\t5\t1\nn\t10\t1\n"
+	if b.String() != desired {
+		t.Errorf("problem in TestDiffWriter: got:\n%s\nwanted:\n%s", b.String(), desired)
+	}
+
+	err = out.WriteRecord(FastaRecord{ID: "q4", Seq: "ACGT"})
+	if err == nil {
+		t.Error("problem in TestDiffWriter: expected an error for a record that isn't the same length as the reference")
+	}
+}
+
+func TestNewOutputWriter(t *testing.T) {
+	var b bytes.Buffer
+
+	for _, format := range OutputFormats {
+		_, err := NewOutputWriter(&b, format, nil, []byte("ACGT"))
+		if err != nil {
+			t.Errorf("problem in TestNewOutputWriter: %s: %v", format, err)
+		}
+	}
+
+	_, err := NewOutputWriter(&b, "diff", nil, nil)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestNewOutputWriter: expected a usage error for diff without a reference, got %v", err)
+	}
+
+	_, err = NewOutputWriter(&b, "clustal", nil, nil)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestNewOutputWriter: expected a usage error for an unrecognised format, got %v", err)
+	}
+}
+
+func TestPhylipWriter(t *testing.T) {
+	var b bytes.Buffer
+
+	out := NewPhylipWriter(&b, false)
+	for _, FR := range []FastaRecord{{ID: "q1", Seq: "ACGT"}, {ID: "q2", Seq: "ACGA"}} {
+		err := out.WriteRecord(FR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Len() != 0 {
+		t.Error("problem in TestPhylipWriter: records were written before Flush")
+	}
+
+	err := out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var desired bytes.Buffer
+	err = WritePhylip(&desired, []FastaRecord{{ID: "q1", Seq: "ACGT"}, {ID: "q2", Seq: "ACGA"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != desired.String() {
+		t.Errorf("problem in TestPhylipWriter: got:\n%s\nwanted:\n%s", b.String(), desired.String())
+	}
+}
+
+func TestRNAWriter(t *testing.T) {
+	var b bytes.Buffer
+
+	out := NewRNAWriter(NewFastaWriter(&b))
+	err := out.WriteRecord(FastaRecord{ID: "q1", Seq: "ACGTN-T"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.String() != ">q1\nACGUN-U\n" {
+		t.Errorf("problem in TestRNAWriter: got %q", b.String())
+	}
+}
+
+func TestHeaderWriter(t *testing.T) {
+	metadataFile := filepath.Join(t.TempDir(), "metadata.csv")
+	err := ioutil.WriteFile(metadataFile, []byte("strain,date,lineage\nq1,2020-03-01,B.1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := metadata.Load(metadataFile, nil, nil, "date", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = md.SetHeaderTemplate("{name}|{date}|{lineage}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+
+	// q2 isn't in the metadata, so it keeps its name
+	out := NewHeaderWriter(NewFastaWriter(&b), md)
+	for _, FR := range []FastaRecord{{ID: "q1", Description: "q1", Seq: "ACGT"}, {ID: "q2", Description: "q2", Seq: "ACGA"}} {
+		err = out.WriteRecord(FR)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = out.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.String() != ">q1|2020-03-01|B.1\nACGT\n>q2\nACGA\n" {
+		t.Errorf("problem in TestHeaderWriter: got %q", b.String())
+	}
+
+	// with no metadata, nothing is renamed
+	b.Reset()
+	out = NewHeaderWriter(NewFastaWriter(&b), nil)
+	err = out.WriteRecord(FastaRecord{ID: "q1", Seq: "ACGT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != ">q1\nACGT\n" {
+		t.Errorf("problem in TestHeaderWriter: got %q with no metadata", b.String())
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Package filter extracts the sequences of an alignment whose metadata (or the fields of
+whose names) match some conditions, such as a date range, in one pass through it.
+*/
+package filter
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// Filter writes the records in infile that md keeps (see metadata.Metadata.Keep) to outfile,
+// in fasta format, as they are read, with the headers from md's header template if it has one
+// (see metadata.Metadata.Header)
+func Filter(infile string, outfile string, md *metadata.Metadata) error {
+
+	if md == nil {
+		return usage.New("filter needs --metadata or --name-format, and something to filter on (e.g. --where or --min-date) or a --header-template")
+	}
+
+	var f *os.File
+	var err error
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	kept := 0
+	dropped := 0
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			if !md.Keep(FR.ID) {
+				dropped++
+				continue
+			}
+			_, err = w.WriteString(">" + md.Header(FR.ID, FR.Description) + "\n" + FR.Seq + "\n")
+			if err != nil {
+				return err
+			}
+			kept++
+		case <-cDone:
+			n--
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	summary.Add(summary.Processed, kept)
+	summary.Add(summary.Filtered, dropped)
+
+	md.Report()
+
+	return nil
+}
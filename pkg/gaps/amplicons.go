@@ -0,0 +1,239 @@
+package gaps
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// Amplicon is one amplicon of a primer scheme, with the 0-based, end-exclusive columns of its
+// insert: the sequence between the inner ends of its primers, which is what a consensus is made of
+type Amplicon struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// the states of an amplicon in one sequence
+const (
+	complete = "complete"
+	partial  = "partial"
+	dropped  = "missing"
+)
+
+// primerAmplicon splits the name of a primer in an ARTIC-style scheme, e.g. SARS-CoV-2_1_LEFT
+// or SARS-CoV-2_1_RIGHT_alt1, into the name of its amplicon (SARS-CoV-2_1) and whether it is a
+// left primer
+func primerAmplicon(name string) (string, bool, error) {
+	fields := strings.Split(name, "_")
+	for i := 1; i < len(fields); i++ {
+		switch fields[i] {
+		case "LEFT":
+			return strings.Join(fields[:i], "_"), true, nil
+		case "RIGHT":
+			return strings.Join(fields[:i], "_"), false, nil
+		}
+	}
+	return "", false, fmt.Errorf("couldn't tell which amplicon primer %s is for: ARTIC-style primer names are <scheme>_<amplicon>_LEFT or _RIGHT", name)
+}
+
+// LoadPrimers reads the amplicons of a primer scheme from an ARTIC-style primer BED file, with
+// the chromosome, the 0-based, end-exclusive start and end, and the name of each primer (see
+// primerAmplicon). The insert of each amplicon runs from the end of its innermost left primer
+// to the start of its innermost right primer, counting alternative primers. The amplicons are
+// sorted by where their inserts start
+func LoadPrimers(path string) ([]Amplicon, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	byName := make(map[string]*Amplicon)
+	hasLeft := make(map[string]bool)
+	hasRight := make(map[string]bool)
+	names := make([]string, 0)
+
+	s := bufio.NewScanner(f)
+	lineNumber := 0
+
+	for s.Scan() {
+		line := s.Text()
+		lineNumber++
+
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %d of %s doesn't have a primer name in the fourth column: %s", lineNumber, path, line)
+		}
+		start, err1 := strconv.Atoi(fields[1])
+		end, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || start < 0 || end < start {
+			return nil, fmt.Errorf("couldn't parse line %d of %s as BED: %s", lineNumber, path, line)
+		}
+
+		name, left, err := primerAmplicon(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("line %d of %s: %v", lineNumber, path, err)
+		}
+
+		a, ok := byName[name]
+		if !ok {
+			a = &Amplicon{Name: name}
+			byName[name] = a
+			names = append(names, name)
+		}
+		switch {
+		case left && (!hasLeft[name] || end > a.Start):
+			a.Start = end
+			hasLeft[name] = true
+		case !left && (!hasRight[name] || start < a.End):
+			a.End = start
+			hasRight[name] = true
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	amplicons := make([]Amplicon, 0, len(names))
+	for _, name := range names {
+		a := byName[name]
+		if !hasLeft[name] || !hasRight[name] {
+			return nil, fmt.Errorf("amplicon %s in %s needs both a LEFT and a RIGHT primer", name, path)
+		}
+		if a.Start >= a.End {
+			return nil, fmt.Errorf("the primers of amplicon %s in %s overlap, so it has no insert", name, path)
+		}
+		amplicons = append(amplicons, *a)
+	}
+
+	sort.SliceStable(amplicons, func(i, j int) bool { return amplicons[i].Start < amplicons[j].Start })
+
+	return amplicons, nil
+}
+
+// dropouts counts how many of the sequences of an alignment each amplicon is complete, partly
+// missing (some of its insert is Ns or gaps) and missing (all of it is) in. If w isn't nil, the
+// amplicons that are partly or wholly missing in each sequence are written to it as well
+type dropouts struct {
+	amplicons []Amplicon
+	counts    [][3]int // the number of sequences that each amplicon is complete, partial and missing in
+	missing   []int    // scratch: the number of missing columns up to each column of a sequence
+	w         *bufio.Writer
+	f         *os.File
+}
+
+// statusIndex is where each state is counted in dropouts.counts
+var statusIndex = map[string]int{complete: 0, partial: 1, dropped: 2}
+
+// newDropouts returns dropouts for an alignment that is width wide (or -1, if there are no
+// sequences), which writes the missing amplicons of each sequence to dropoutsFile, if it isn't empty
+func newDropouts(amplicons []Amplicon, width int, dropoutsFile string) (*dropouts, error) {
+
+	for _, a := range amplicons {
+		if width >= 0 && a.End > width {
+			return nil, fmt.Errorf("amplicon %s runs past the end of the alignment (%d > %d)", a.Name, a.End, width)
+		}
+	}
+
+	d := &dropouts{amplicons: amplicons, counts: make([][3]int, len(amplicons)), missing: make([]int, width+1)}
+
+	if len(dropoutsFile) > 0 {
+		f, err := os.Create(dropoutsFile)
+		if err != nil {
+			return nil, err
+		}
+		d.f = f
+		d.w = bufio.NewWriter(f)
+		_, err = d.w.WriteString("##" + version.Provenance() + "\nquery\tamplicon\tinsert_start\tinsert_end\tmissing_bases\tstatus\n")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// add adds one (upper case) sequence to the counts, and writes its partly and wholly missing amplicons
+func (d *dropouts) add(id string, seq string) error {
+
+	for i := 0; i < len(seq); i++ {
+		d.missing[i+1] = d.missing[i]
+		if missing(seq[i]) {
+			d.missing[i+1]++
+		}
+	}
+
+	for i, a := range d.amplicons {
+		n := d.missing[a.End] - d.missing[a.Start]
+		status := partial
+		switch n {
+		case 0:
+			status = complete
+		case a.End - a.Start:
+			status = dropped
+		}
+		d.counts[i][statusIndex[status]]++
+		if d.w != nil && status != complete {
+			// the insert in 1-based, inclusive coordinates
+			_, err := d.w.WriteString(id + "\t" + a.Name + "\t" + strconv.Itoa(a.Start+1) + "\t" + strconv.Itoa(a.End) + "\t" + strconv.Itoa(n) + "\t" + status + "\n")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// close finishes the per-sequence file, and writes the summary to summaryFile (if it isn't empty)
+func (d *dropouts) close(summaryFile string) error {
+
+	if d.w != nil {
+		defer d.f.Close()
+		err := d.w.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	wholly := 0
+	for _, c := range d.counts {
+		if c[statusIndex[dropped]] > 0 {
+			wholly++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d amplicons are missing from at least one sequence\n", wholly, len(d.amplicons))
+
+	if len(summaryFile) == 0 {
+		return nil
+	}
+
+	f, err := os.Create(summaryFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	w.WriteString("##" + version.Provenance() + "\namplicon\tinsert_start\tinsert_end\tcomplete\tpartial\tmissing\n")
+	for i, a := range d.amplicons {
+		c := d.counts[i]
+		w.WriteString(a.Name + "\t" + strconv.Itoa(a.Start+1) + "\t" + strconv.Itoa(a.End) + "\t" + strconv.Itoa(c[0]) + "\t" + strconv.Itoa(c[1]) + "\t" + strconv.Itoa(c[2]) + "\n")
+	}
+
+	return w.Flush()
+}
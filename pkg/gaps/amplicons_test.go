@@ -0,0 +1,90 @@
+package gaps
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+func TestLoadPrimers(t *testing.T) {
+	dir := t.TempDir()
+
+	primerFile := filepath.Join(dir, "primers.bed")
+	err := ioutil.WriteFile(primerFile, []byte(
+		"ref\t10\t12\ts_2_LEFT\t2\t+\n"+
+			"ref\t0\t2\ts_1_LEFT\t1\t+\n"+
+			"ref\t8\t10\ts_1_RIGHT\t1\t-\n"+
+			"ref\t1\t3\ts_1_LEFT_alt1\t1\t+\n"+
+			"ref\t18\t20\ts_2_RIGHT\t2\t-\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amplicons, err := LoadPrimers(primerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Amplicon{{"s_1", 3, 8}, {"s_2", 12, 18}}
+	if !reflect.DeepEqual(amplicons, expected) {
+		t.Errorf("problem in TestLoadPrimers: got %v, expected %v", amplicons, expected)
+	}
+
+	for _, bed := range []string{"ref\t0\t2\ts_1_LEFT\n", "ref\t0\t2\tprimer1\n", "ref\t0\t6\ts_1_LEFT\nref\t4\t8\ts_1_RIGHT\n"} {
+		err = ioutil.WriteFile(primerFile, []byte(bed), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadPrimers(primerFile)
+		if err == nil {
+			t.Errorf("problem in TestLoadPrimers: expected an error for %q", bed)
+		}
+	}
+}
+
+func TestDropouts(t *testing.T) {
+	dir := t.TempDir()
+
+	primerFile := filepath.Join(dir, "primers.bed")
+	err := ioutil.WriteFile(primerFile, []byte("ref\t0\t2\ts_1_LEFT\nref\t6\t8\ts_1_RIGHT\nref\t5\t7\ts_2_LEFT\nref\t10\t12\ts_2_RIGHT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infile := filepath.Join(dir, "in.fasta")
+	err = ioutil.WriteFile(infile, []byte(">s1\nACGNACGTACGT\n>s2\nACNNNNGTACGT\n>s3\nACGTACNNN-GT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outfile := filepath.Join(dir, "gaps.bed")
+	dropoutsFile := filepath.Join(dir, "dropouts.tsv")
+	summaryFile := filepath.Join(dir, "summary.tsv")
+
+	err = Report(infile, outfile, "", 4, primerFile, dropoutsFile, summaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedDropouts := "##" + version.Provenance() + "\nquery\tamplicon\tinsert_start\tinsert_end\tmissing_bases\tstatus\n" +
+		"s1\ts_1\t3\t6\t1\tpartial\n" +
+		"s2\ts_1\t3\t6\t4\tmissing\n" +
+		"s3\ts_2\t8\t10\t3\tmissing\n"
+	expectedSummary := "##" + version.Provenance() + "\namplicon\tinsert_start\tinsert_end\tcomplete\tpartial\tmissing\n" +
+		"s_1\t3\t6\t1\t1\t1\n" +
+		"s_2\t8\t10\t2\t0\t1\n"
+
+	dropouts, _ := ioutil.ReadFile(dropoutsFile)
+	if string(dropouts) != expectedDropouts {
+		t.Errorf("problem in TestDropouts: dropouts were\n%s\nexpected\n%s", dropouts, expectedDropouts)
+	}
+	summary, _ := ioutil.ReadFile(summaryFile)
+	if string(summary) != expectedSummary {
+		t.Errorf("problem in TestDropouts: summary was\n%s\nexpected\n%s", summary, expectedSummary)
+	}
+
+	err = Report(infile, outfile, "", 4, primerFile, "", "")
+	if err == nil {
+		t.Errorf("problem in TestDropouts: expected an error with --primers and no outputs for it")
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Package gaps finds the runs of missing sequence (Ns and gaps) in each sequence of an alignment,
+e.g. the amplicons that dropped out of a consensus, or the ends that weren't sequenced or trimmed,
+and writes them in BED format, so that failing amplicons can be tracked from one run to the next.
+*/
+package gaps
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// Gap is a run of missing sequence in one sequence of an alignment, from the 0-based column
+// Start up to (but not including) End
+type Gap struct {
+	Start int
+	End   int
+}
+
+// missing is true for the characters that count as missing sequence: N and the gap
+func missing(c byte) bool {
+	return c == 'N' || c == '-'
+}
+
+// Find is the runs of at least minLength missing characters (Ns and gaps, in any mix) in seq,
+// which has to be upper case
+func Find(seq string, minLength int) []Gap {
+	gaps := make([]Gap, 0)
+	for i := 0; i < len(seq); {
+		if !missing(seq[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(seq) && missing(seq[i]) {
+			i++
+		}
+		if i-start >= minLength {
+			gaps = append(gaps, Gap{Start: start, End: i})
+		}
+	}
+	return gaps
+}
+
+// Report writes the runs of at least minLength Ns and gaps in each sequence of the alignment in
+// infile to outfile in BED format, in the order of the sequences and then of the runs. The
+// columns are the chromosome (the name of the reference in referenceFile, which the alignment has
+// to be as wide as, or "reference" if there isn't one), the 0-based, end-exclusive start and end
+// of the run, the name of the sequence, and the length of the run. If primerFile isn't empty, it
+// is an ARTIC-style primer BED file (see LoadPrimers), and the amplicons that are partly or
+// wholly missing in each sequence are written to dropoutsFile, and how many sequences each
+// amplicon is complete, partly missing and missing in to summaryFile (see dropouts), if they
+// aren't empty
+func Report(infile string, outfile string, referenceFile string, minLength int, primerFile string, dropoutsFile string, summaryFile string) error {
+
+	if minLength < 1 {
+		return usage.Errorf("--min-length has to be at least 1, not %d", minLength)
+	}
+
+	var amplicons []Amplicon
+	switch {
+	case len(primerFile) > 0 && len(dropoutsFile) == 0 && len(summaryFile) == 0:
+		return usage.New("--primers is for finding the amplicons that are missing, so it needs --dropouts or --dropout-summary")
+	case len(primerFile) == 0 && (len(dropoutsFile) > 0 || len(summaryFile) > 0):
+		return usage.New("--dropouts and --dropout-summary need the amplicons, from --primers")
+	case len(primerFile) > 0:
+		var err error
+		amplicons, err = LoadPrimers(primerFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	chrom := "reference"
+	width := -1
+	widthOf := ""
+	if len(referenceFile) > 0 {
+		refs, err := cliio.ReadRecords(referenceFile)
+		if err != nil {
+			return err
+		}
+		if len(refs) != 1 {
+			return errors.New("there should be exactly one record in --reference")
+		}
+		chrom = refs[0].ID
+		width = len(refs[0].Seq)
+		widthOf = "the reference"
+	}
+
+	var err error
+	var f *os.File
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+
+	_, err = w.WriteString("##" + version.Provenance() + "\n")
+	if err != nil {
+		return err
+	}
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	var d *dropouts
+
+	n := 0
+	withGaps := 0
+	total := 0
+
+	for running := 1; running > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			if width < 0 {
+				width = len(FR.Seq)
+				widthOf = "the first sequence"
+			}
+			if len(FR.Seq) != width {
+				return fmt.Errorf("%s is %d long, but %s is %d long, so they aren't in the same coordinates", FR.ID, len(FR.Seq), widthOf, width)
+			}
+			if amplicons != nil && d == nil {
+				d, err = newDropouts(amplicons, width, dropoutsFile)
+				if err != nil {
+					return err
+				}
+			}
+			if d != nil {
+				err = d.add(FR.ID, FR.Seq)
+				if err != nil {
+					return err
+				}
+			}
+			n++
+			gaps := Find(FR.Seq, minLength)
+			if len(gaps) > 0 {
+				withGaps++
+			}
+			for _, g := range gaps {
+				_, err = w.WriteString(chrom + "\t" + strconv.Itoa(g.Start) + "\t" + strconv.Itoa(g.End) + "\t" + FR.ID + "\t" + strconv.Itoa(g.End-g.Start) + "\n")
+				if err != nil {
+					return err
+				}
+			}
+			total += len(gaps)
+		case <-cDone:
+			running--
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "found %d runs of at least %d Ns or gaps, in %d of %d sequences\n", total, minLength, withGaps, n)
+
+	if amplicons != nil {
+		if d == nil {
+			d, err = newDropouts(amplicons, width, dropoutsFile)
+			if err != nil {
+				return err
+			}
+		}
+		err = d.close(summaryFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	summary.Add(summary.Processed, n)
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package gaps
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+func TestFind(t *testing.T) {
+	tests := []struct {
+		seq       string
+		minLength int
+		expected  []Gap
+	}{
+		{"NNACGT-N-NACN", 1, []Gap{{0, 2}, {6, 10}, {12, 13}}},
+		{"NNACGT-N-NACN", 2, []Gap{{0, 2}, {6, 10}}},
+		{"NNACGT-N-NACN", 3, []Gap{{6, 10}}},
+		{"ACGT", 1, []Gap{}},
+		{"", 1, []Gap{}},
+	}
+
+	for _, test := range tests {
+		gaps := Find(test.seq, test.minLength)
+		if !reflect.DeepEqual(gaps, test.expected) {
+			t.Errorf("problem in TestFind: %s, %d: got %v, expected %v", test.seq, test.minLength, gaps, test.expected)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+
+	infile := filepath.Join(dir, "in.fasta")
+	err := ioutil.WriteFile(infile, []byte(">s1 one\nnnACGTACGTAC\n>s2\nACGT---NNTAC\n>s3\nACGTACGTACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refFile := filepath.Join(dir, "ref.fasta")
+	err = ioutil.WriteFile(refFile, []byte(">MN908947.3\nACGTACGTACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shortRef := filepath.Join(dir, "short.fasta")
+	err = ioutil.WriteFile(shortRef, []byte(">MN908947.3\nACGTACGTAC\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outfile := filepath.Join(dir, "gaps.bed")
+
+	tests := []struct {
+		referenceFile string
+		minLength     int
+		expected      string
+	}{
+		{"", 2, "reference\t0\t2\ts1\t2\nreference\t4\t9\ts2\t5\n"},
+		{refFile, 3, "MN908947.3\t4\t9\ts2\t5\n"},
+	}
+
+	for _, test := range tests {
+		err = Report(infile, outfile, test.referenceFile, test.minLength, "", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(outfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "##" + version.Provenance() + "\n" + test.expected
+		if string(out) != expected {
+			t.Errorf("problem in TestReport: %s, %d gave\n%s\nexpected\n%s", test.referenceFile, test.minLength, out, expected)
+		}
+	}
+
+	err = Report(infile, outfile, shortRef, 2, "", "", "")
+	if err == nil {
+		t.Errorf("problem in TestReport: expected an error with a reference that the alignment is longer than")
+	}
+	err = Report(infile, outfile, "", 0, "", "", "")
+	if err == nil {
+		t.Errorf("problem in TestReport: expected an error with a --min-length of 0")
+	}
+}
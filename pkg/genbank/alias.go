@@ -0,0 +1,105 @@
+package genbank
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// builtinAliases are the names that SARS-CoV-2's genes go by in different annotations (the /gene
+// and /product of NC_045512 and MN908947, Nextclade's and GISAID's names, and so on). The first
+// name on each line is the gene's canonical name
+var builtinAliases = [][]string{
+	{"ORF1ab", "1ab", "ORF1ab polyprotein", "pp1ab"},
+	{"ORF1a", "1a", "ORF1a polyprotein", "pp1a"},
+	{"S", "spike", "spike protein", "spike glycoprotein", "surface glycoprotein"},
+	{"ORF3a", "3a", "ORF3a protein"},
+	{"E", "envelope", "envelope protein"},
+	{"M", "membrane", "membrane protein", "membrane glycoprotein"},
+	{"ORF6", "6", "ORF6 protein"},
+	{"ORF7a", "7a", "ORF7a protein"},
+	{"ORF7b", "7b", "ORF7b protein"},
+	{"ORF8", "8", "ORF8 protein"},
+	{"N", "nucleocapsid", "nucleocapsid protein", "nucleocapsid phosphoprotein"},
+	{"ORF10", "10", "ORF10 protein"},
+}
+
+// aliases maps each (normalised) name that a gene goes by to its canonical name
+var aliases = makeAliases(builtinAliases)
+
+func makeAliases(lines [][]string) map[string]string {
+	m := make(map[string]string)
+	for _, names := range lines {
+		for _, name := range names {
+			m[normaliseGeneName(name)] = names[0]
+		}
+	}
+	return m
+}
+
+// normaliseGeneName is how gene names are compared: case-insensitively, with underscores the same
+// as spaces, and runs of spaces the same as one
+func normaliseGeneName(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.ReplaceAll(name, "_", " "))), " ")
+}
+
+// LoadAliases adds the gene names in file to the built-in ones (for SARS-CoV-2), so that a gene can
+// be looked up (e.g. by FindCDS) by any of its names, whichever of them the genbank record uses.
+// Each line of the file is a gene's names, separated by commas or tabs, with its canonical name
+// first, e.g. ORF1ab,1ab,orf1ab polyprotein. Blank lines and lines that start with # are skipped.
+// A name in the file replaces the same name in the built-in table
+func LoadAliases(file string) error {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lines := make([][]string, 0)
+
+	s := bufio.NewScanner(f)
+	n := 0
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names := make([]string, 0)
+		for _, name := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == '\t' }) {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				names = append(names, name)
+			}
+		}
+		if len(names) < 2 {
+			return usage.Errorf("%s line %d: expected a gene's names separated by commas, not %s", file, n, line)
+		}
+		lines = append(lines, names)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	for name, canonical := range makeAliases(lines) {
+		aliases[name] = canonical
+	}
+
+	return nil
+}
+
+// CanonicalGeneName is the canonical name of a gene that goes by name (name itself, if it isn't
+// one of the aliases)
+func CanonicalGeneName(name string) string {
+	if canonical, ok := aliases[normaliseGeneName(name)]; ok {
+		return canonical
+	}
+	return name
+}
+
+// SameGene reports whether two gene names are names of the same gene
+func SameGene(a string, b string) bool {
+	return normaliseGeneName(CanonicalGeneName(a)) == normaliseGeneName(CanonicalGeneName(b))
+}
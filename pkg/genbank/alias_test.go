@@ -0,0 +1,82 @@
+package genbank
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindCDSAliases(t *testing.T) {
+	gb := Genbank{FEATURES: []GenbankFeature{
+		{Feature: "gene", Pos: "1..12"},
+		{Feature: "CDS", Pos: "1..12"},
+		{Feature: "CDS", Pos: "13..24"},
+		{Feature: "CDS", Pos: "25..36"},
+		{Feature: "CDS", Pos: "37..48"},
+	}}
+	gb.FEATURES[0].Info.Add("gene", "S")
+	gb.FEATURES[1].Info.Add("gene", "S")
+	gb.FEATURES[1].Info.Add("product", "surface glycoprotein")
+	gb.FEATURES[2].Info.Add("gene", "ORF1ab")
+	gb.FEATURES[2].Info.Add("product", "ORF1ab polyprotein")
+	gb.FEATURES[3].Info.Add("gene", "ORF1ab")
+	gb.FEATURES[3].Info.Add("product", "ORF1a polyprotein")
+	gb.FEATURES[4].Info.Add("product", "hypothetical_protein")
+
+	tests := map[string]string{
+		"S":                    "1..12",
+		"spike":                "1..12",
+		"Surface_Glycoprotein": "1..12",
+		"orf1ab":               "13..24",
+		"1ab":                  "13..24",
+		"ORF1a":                "25..36",
+		"1a":                   "25..36",
+		"hypothetical protein": "37..48",
+	}
+
+	for name, want := range tests {
+		f, ok := gb.FindCDS(name)
+		if !ok {
+			t.Errorf("problem in TestFindCDSAliases: no CDS found for %s", name)
+			continue
+		}
+		if f.Pos != want {
+			t.Errorf("problem in TestFindCDSAliases: %s: got %s, want %s", name, f.Pos, want)
+		}
+	}
+
+	if _, ok := gb.FindCDS("N"); ok {
+		t.Errorf("problem in TestFindCDSAliases: found a CDS for N")
+	}
+
+	saved := make(map[string]string)
+	for k, v := range aliases {
+		saved[k] = v
+	}
+	t.Cleanup(func() { aliases = saved })
+
+	file := filepath.Join(t.TempDir(), "aliases.csv")
+	err := ioutil.WriteFile(file, []byte("# other names\nORF1ab, replicase\n\nhypothetical protein\tHP,XYZ\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = LoadAliases(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"replicase": "13..24", "xyz": "37..48", "spike": "1..12"} {
+		f, ok := gb.FindCDS(name)
+		if !ok || f.Pos != want {
+			t.Errorf("problem in TestFindCDSAliases: %s: got %s, want %s", name, f.Pos, want)
+		}
+	}
+
+	err = ioutil.WriteFile(file, []byte("ORF1ab\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = LoadAliases(file); err == nil {
+		t.Errorf("problem in TestFindCDSAliases: expected an error for a line with one name")
+	}
+}
@@ -0,0 +1,136 @@
+package genbank
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+)
+
+// Coding is how a CDS is translated, from its /codon_start, /transl_table and /transl_except
+// qualifiers
+type Coding struct {
+	// CodonStart is the (1-based) position in the CDS of the first base of its first codon
+	CodonStart int
+	// Table is the number of its genetic code (1, the standard code, if it has no /transl_table)
+	Table int
+	// Exceptions are the amino acids of codons (0-based, counted from CodonStart) that aren't
+	// translated by the genetic code, e.g. selenocysteines, or stop codons that are only
+	// completed by polyadenylation
+	Exceptions map[int]byte
+}
+
+// aminoAcids are the one-letter codes of the three-letter amino acid abbreviations that
+// /transl_except uses
+var aminoAcids = map[string]byte{
+	"Ala": 'A', "Arg": 'R', "Asn": 'N', "Asp": 'D', "Asx": 'B', "Cys": 'C', "Gln": 'Q', "Glu": 'E',
+	"Glx": 'Z', "Gly": 'G', "His": 'H', "Ile": 'I', "Leu": 'L', "Lys": 'K', "Met": 'M', "Phe": 'F',
+	"Pro": 'P', "Pyl": 'O', "Sec": 'U', "Ser": 'S', "Thr": 'T', "Trp": 'W', "Tyr": 'Y', "Val": 'V',
+	"Xle": 'J', "Xaa": 'X', "OTHER": 'X', "TERM": '*',
+}
+
+// Coding parses the feature's /codon_start, /transl_table and /transl_except qualifiers. The
+// codon that an exception is for is found from the position of its first base, so that
+// exceptions are put in the right place in CDSs whose location is a join (e.g. ORF1ab, whose
+// ribosomal slippage is annotated as a join of two overlapping spans)
+func (f GenbankFeature) Coding() (Coding, error) {
+
+	c := Coding{CodonStart: 1, Table: 1}
+
+	if cs := f.Info.Get("codon_start"); cs != "" {
+		frame, err := strconv.Atoi(cs)
+		if err != nil || frame < 1 || frame > 3 {
+			return c, errors.New("bad /codon_start: " + cs)
+		}
+		c.CodonStart = frame
+	}
+
+	if tt := f.Info.Get("transl_table"); tt != "" {
+		table, err := strconv.Atoi(tt)
+		if err != nil {
+			return c, errors.New("bad /transl_table: " + tt)
+		}
+		if _, err = alphabet.GeneticCode(table); err != nil {
+			return c, errors.New("bad /transl_table: " + tt + " isn't one of NCBI's genetic codes")
+		}
+		c.Table = table
+	}
+
+	exceptions := f.Info.GetAll("transl_except")
+	if len(exceptions) == 0 {
+		return c, nil
+	}
+
+	positions, _, err := f.Positions()
+	if err != nil {
+		return c, err
+	}
+	index := make(map[int]int, len(positions))
+	for i, pos := range positions {
+		if _, ok := index[pos]; !ok {
+			index[pos] = i
+		}
+	}
+
+	c.Exceptions = make(map[int]byte)
+	for _, te := range exceptions {
+		pos, aa, err := parseTranslExcept(te)
+		if err != nil {
+			return c, err
+		}
+		i, ok := index[pos]
+		if !ok || i%3 != 0 {
+			return c, errors.New("/transl_except " + te + " isn't at the start of a codon of the CDS at " + f.Pos)
+		}
+		c.Exceptions[i/3] = aa
+	}
+
+	return c, nil
+}
+
+// parseTranslExcept parses a /transl_except, e.g. (pos:213..215,aa:Sec), into the position of the
+// first base of its codon (the end of the range, if it is complemented) and its amino acid
+func parseTranslExcept(te string) (int, byte, error) {
+
+	bad := errors.New("couldn't parse /transl_except: " + te)
+
+	s := strings.TrimSpace(te)
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return 0, 0, bad
+	}
+	s = strings.ReplaceAll(s[1:len(s)-1], " ", "")
+
+	i := strings.LastIndex(s, ",aa:")
+	if !strings.HasPrefix(s, "pos:") || i == -1 {
+		return 0, 0, bad
+	}
+
+	aa, ok := aminoAcids[s[i+len(",aa:"):]]
+	if !ok {
+		return 0, 0, errors.New("unknown amino acid in /transl_except: " + te)
+	}
+
+	spans, err := parseLocation(s[len("pos:"):i])
+	if err != nil || len(spans) != 1 {
+		return 0, 0, bad
+	}
+	if spans[0].complement {
+		return spans[0].end, aa, nil
+	}
+	return spans[0].start, aa, nil
+}
+
+// Translator returns a Translator with t's policy that translates the CDS's codons with its
+// genetic code and exceptions. It is t if the CDS uses the standard code with no exceptions
+func (c Coding) Translator(t *alphabet.Translator) *alphabet.Translator {
+	if (c.Table == 0 || c.Table == 1) && len(c.Exceptions) == 0 {
+		return t
+	}
+	table := c.Table
+	if table == 0 {
+		table = 1
+	}
+	codons, _ := alphabet.GeneticCode(table)
+	return t.WithCode(codons, c.Exceptions)
+}
@@ -0,0 +1,227 @@
+package genbank
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// seqID is the name we give the record's sequence in other formats: its
+// accession.version if it has one, otherwise its accession or its locus name
+func (gb Genbank) seqID() string {
+	switch {
+	case len(gb.VERSION) > 0:
+		return gb.VERSION
+	case len(gb.ACCESSION) > 0:
+		return gb.ACCESSION
+	default:
+		return gb.LOCUS.Name
+	}
+}
+
+// writeFasta writes the ORIGIN sequence of a genbank record in fasta format, as RNA if rna
+func writeFasta(w io.Writer, gb Genbank, rna bool) error {
+
+	if len(gb.ORIGIN) == 0 {
+		return errors.New("no ORIGIN sequence in the genbank file")
+	}
+
+	header := ">" + gb.seqID()
+	if len(gb.DEFINITION) > 0 {
+		header += " " + gb.DEFINITION
+	}
+
+	seq := strings.ToUpper(string(gb.ORIGIN))
+	if rna {
+		seq = alphabet.ToRNA(seq)
+	}
+
+	_, err := io.WriteString(w, header+"\n"+seq+"\n")
+
+	return err
+}
+
+// ToFastaOptions are the options of ToFasta. Their zero value is what gofasta genbank toFasta
+// uses if none of its flags are given
+type ToFastaOptions struct {
+	// ORIGIN sequences are always read as DNA, so if RNA, the sequence is written back as RNA
+	// (T to U)
+	RNA bool
+}
+
+// ToFasta writes the ORIGIN sequence of a genbank file in fasta format, with its
+// accession.version and definition in the header, as set out by opts (see ToFastaOptions)
+func ToFasta(genbankFile string, outfile string, opts ToFastaOptions) error {
+
+	gb, err := Load(genbankFile)
+	if err != nil {
+		return err
+	}
+
+	f, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeFasta(f, gb, opts.RNA)
+}
+
+// gffTypes are the Sequence Ontology names for the genbank feature keys
+// that don't have the same name in GFF3
+var gffTypes = map[string]string{
+	"source":       "region",
+	"5'UTR":        "five_prime_UTR",
+	"3'UTR":        "three_prime_UTR",
+	"misc_feature": "sequence_feature",
+	"mat_peptide":  "mature_protein_region_of_CDS",
+	"sig_peptide":  "signal_peptide",
+}
+
+// gffEscape escapes the characters that have a special meaning in GFF3 columns
+var gffEscape = strings.NewReplacer("%", "%25", ";", "%3B", "=", "%3D", "&", "%26", ",", "%2C", "\t", "%09", "\n", "%0A")
+
+// gffAttributes makes the attributes column for a feature: an ID, a Parent (for
+// features in a gene that we have written), a Name, then every qualifier (except
+// /translation) in the order they are in the file, with repeated qualifiers' values
+// comma-separated and qualifiers without a value (e.g. /pseudo) set to true
+func gffAttributes(f GenbankFeature, id string, parent string) string {
+
+	attributes := []string{"ID=" + gffEscape.Replace(id)}
+
+	if len(parent) > 0 {
+		attributes = append(attributes, "Parent="+gffEscape.Replace(parent))
+	}
+
+	for _, key := range []string{"gene", "product", "locus_tag"} {
+		if f.Info.Has(key) {
+			attributes = append(attributes, "Name="+gffEscape.Replace(f.Info.Get(key)))
+			break
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, q := range f.Info {
+		if q.Key == "translation" || seen[q.Key] {
+			continue
+		}
+		seen[q.Key] = true
+		values := f.Info.GetAll(q.Key)
+		for i := range values {
+			// qualifiers without a value are flags
+			if len(values[i]) == 0 {
+				values[i] = "true"
+			}
+			values[i] = gffEscape.Replace(values[i])
+		}
+		attributes = append(attributes, gffEscape.Replace(q.Key)+"="+strings.Join(values, ","))
+	}
+
+	return strings.Join(attributes, ";")
+}
+
+// writeGFF writes the FEATURES of a genbank record in GFF3 format. Features with
+// joined locations are written as one line per span, all with the same ID. CDSs
+// get the phase of each span from their /codon_start, and features with a /gene
+// qualifier are made children of the gene feature with the same /gene
+func writeGFF(w io.Writer, gb Genbank) error {
+
+	bw := bufio.NewWriter(w)
+
+	seqid := gffEscape.Replace(gb.seqID())
+
+	bw.WriteString("##gff-version 3\n")
+	bw.WriteString("##" + version.Provenance() + "\n")
+	length := gb.LOCUS.Length
+	if len(gb.ORIGIN) > 0 {
+		length = len(gb.ORIGIN)
+	}
+	if length > 0 {
+		bw.WriteString("##sequence-region " + seqid + " 1 " + strconv.Itoa(length) + "\n")
+	}
+
+	counts := make(map[string]int)
+	geneIDs := make(map[string]string)
+
+	for _, f := range gb.FEATURES {
+		spans, err := parseLocation(f.Pos)
+		if err != nil {
+			return err
+		}
+
+		featureType, ok := gffTypes[f.Feature]
+		if !ok {
+			featureType = f.Feature
+		}
+
+		counts[featureType]++
+		id := featureType + "-" + strconv.Itoa(counts[featureType])
+
+		parent := ""
+		if f.Feature == "gene" && f.Info.Has("gene") {
+			id = "gene-" + f.Info.Get("gene")
+			if _, dup := geneIDs[f.Info.Get("gene")]; dup {
+				id += "-" + strconv.Itoa(counts[featureType])
+			} else {
+				geneIDs[f.Info.Get("gene")] = id
+			}
+		} else if f.Info.Has("gene") {
+			parent = geneIDs[f.Info.Get("gene")]
+		}
+
+		attributes := gffAttributes(f, id, parent)
+
+		// the number of bases to skip at the start of the next span before the
+		// first complete codon
+		phase := 0
+		if f.Feature == "CDS" {
+			if cs, err := strconv.Atoi(f.Info.Get("codon_start")); err == nil && cs >= 1 && cs <= 3 {
+				phase = cs - 1
+			}
+		}
+
+		for _, s := range spans {
+			strand := "+"
+			if s.complement {
+				strand = "-"
+			}
+
+			phaseColumn := "."
+			if f.Feature == "CDS" {
+				phaseColumn = strconv.Itoa(phase)
+				phase = (3 - (s.end-s.start+1-phase)%3) % 3
+			}
+
+			_, err = bw.WriteString(strings.Join([]string{seqid, "Genbank", gffEscape.Replace(featureType),
+				strconv.Itoa(s.start), strconv.Itoa(s.end), ".", strand, phaseColumn, attributes}, "\t") + "\n")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ToGFF writes the FEATURES of a genbank file in GFF3 format
+func ToGFF(genbankFile string, outfile string) error {
+
+	gb, err := Load(genbankFile)
+	if err != nil {
+		return err
+	}
+
+	f, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeGFF(f, gb)
+}
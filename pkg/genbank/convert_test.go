@@ -0,0 +1,99 @@
+package genbank
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+var testConvertGenbank = `LOCUS       MN908947                  60 bp    ss-RNA     linear   VRL 18-MAR-2020
+DEFINITION  Severe acute respiratory syndrome coronavirus 2 isolate Wuhan-Hu-1,
+            complete genome.
+ACCESSION   MN908947
+VERSION     MN908947.3
+FEATURES             Location/Qualifiers
+     source          1..60
+                     /db_xref="taxon:2697049"
+     gene            1..50
+                     /gene="orf1ab"
+     CDS             join(1..31,31..50)
+                     /gene="orf1ab"
+                     /ribosomal_slippage
+                     /product="orf1ab polyprotein; a=b"
+                     /translation="MESLVPGF"
+     CDS             complement(51..60)
+                     /codon_start=2
+ORIGIN
+        1 attaaaggtt tataccttcc caggtaacaa accaaccaac tttcgatctc ttgtagatct
+//
+`
+
+func TestWriteFasta(t *testing.T) {
+	gb, err := parseGenBank(strings.NewReader(testConvertGenbank))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gb.LOCUS.Name != "MN908947" || gb.LOCUS.Length != 60 || gb.LOCUS.Type != "ss-RNA" || gb.LOCUS.Division != "VRL" || gb.LOCUS.Date != "18-MAR-2020" {
+		t.Errorf("problem in TestWriteFasta: bad LOCUS: %v", gb.LOCUS)
+	}
+
+	var buf bytes.Buffer
+	err = writeFasta(&buf, gb, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := ">MN908947.3 Severe acute respiratory syndrome coronavirus 2 isolate Wuhan-Hu-1, complete genome.\n" +
+		"ATTAAAGGTTTATACCTTCCCAGGTAACAAACCAACCAACTTTCGATCTCTTGTAGATCT\n"
+	if buf.String() != want {
+		t.Errorf("problem in TestWriteFasta: %s", buf.String())
+	}
+
+	// an RNA record is read as DNA, and can be written back as RNA
+	rnaRecord := strings.Replace(testConvertGenbank, "attaaaggtt", "auuaaagguu", 1)
+	gb, err = parseGenBank(strings.NewReader(rnaRecord))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(gb.ORIGIN), "attaaaggtt") {
+		t.Errorf("problem in TestWriteFasta: RNA wasn't read as DNA: %s", gb.ORIGIN)
+	}
+
+	buf.Reset()
+	err = writeFasta(&buf, gb, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), "\nAUUAAAGGUUUAUACCUUCCCAGGUAACAAACCAACCAACUUUCGAUCUCUUGUAGAUCU\n") {
+		t.Errorf("problem in TestWriteFasta: %s", buf.String())
+	}
+}
+
+func TestWriteGFF(t *testing.T) {
+	gb, err := parseGenBank(strings.NewReader(testConvertGenbank))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = writeGFF(&buf, gb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `##gff-version 3
+##` + version.Provenance() + `
+##sequence-region MN908947.3 1 60
+MN908947.3	Genbank	region	1	60	.	+	.	ID=region-1;db_xref=taxon:2697049
+MN908947.3	Genbank	gene	1	50	.	+	.	ID=gene-orf1ab;Name=orf1ab;gene=orf1ab
+MN908947.3	Genbank	CDS	1	31	.	+	0	ID=CDS-1;Parent=gene-orf1ab;Name=orf1ab;gene=orf1ab;ribosomal_slippage=true;product=orf1ab polyprotein%3B a%3Db
+MN908947.3	Genbank	CDS	31	50	.	+	2	ID=CDS-1;Parent=gene-orf1ab;Name=orf1ab;gene=orf1ab;ribosomal_slippage=true;product=orf1ab polyprotein%3B a%3Db
+MN908947.3	Genbank	CDS	51	60	.	-	1	ID=CDS-2;codon_start=2
+`
+	if buf.String() != want {
+		t.Errorf("problem in TestWriteGFF: got:\n%s", buf.String())
+	}
+}
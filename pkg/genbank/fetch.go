@@ -0,0 +1,154 @@
+package genbank
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+)
+
+// eutilsURL is NCBI's E-utilities efetch endpoint (a variable so that it can be
+// pointed at a test server)
+var eutilsURL = "https://eutils.ncbi.nlm.nih.gov/entrez/eutils/efetch.fcgi"
+
+// accessionRegex matches nucleotide accessions (with or without a version), e.g.
+// MN908947, MN908947.3, NC_045512.2
+var accessionRegex = regexp.MustCompile(`^[A-Z]{1,6}_?[0-9]{5,9}(\.[0-9]+)?$`)
+
+// IsAccession is true if s looks like a nucleotide accession (e.g. NC_045512.2)
+func IsAccession(s string) bool {
+	return accessionRegex.MatchString(s)
+}
+
+// cacheDir is where fetched records are kept: $GOFASTA_CACHE_DIR if it is set,
+// otherwise a gofasta directory in the user's cache directory
+func cacheDir() (string, error) {
+	if dir := os.Getenv("GOFASTA_CACHE_DIR"); len(dir) > 0 {
+		return filepath.Join(dir, "genbank"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gofasta", "genbank"), nil
+}
+
+// download gets the genbank flat file for an accession from NCBI
+func download(accession string) ([]byte, error) {
+
+	query := url.Values{}
+	query.Set("db", "nuccore")
+	query.Set("id", accession)
+	query.Set("rettype", "gb")
+	query.Set("retmode", "text")
+
+	client := http.Client{Timeout: 60 * time.Second}
+
+	resp, err := client.Get(eutilsURL + "?" + query.Encode())
+	if err != nil {
+		return []byte{}, fmt.Errorf("couldn't fetch %s from NCBI: %v", accession, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return []byte{}, fmt.Errorf("couldn't fetch %s from NCBI: %s", accession, resp.Status)
+	}
+
+	// NCBI can return errors in an otherwise successful response
+	if !bytes.HasPrefix(body, []byte("LOCUS")) {
+		return []byte{}, fmt.Errorf("couldn't fetch %s from NCBI: the response isn't a genbank record", accession)
+	}
+
+	return body, nil
+}
+
+// Fetch gets the genbank record for an accession (e.g. NC_045512.2) from NCBI
+// using E-utilities. Records are cached (see cacheDir), so each accession is only
+// downloaded once.
+func Fetch(accession string) (Genbank, error) {
+
+	if !IsAccession(accession) {
+		return Genbank{}, errors.New("not a nucleotide accession: " + accession)
+	}
+
+	dir, err := cacheDir()
+	if err != nil {
+		return Genbank{}, err
+	}
+
+	cached := filepath.Join(dir, accession+".gb")
+
+	if _, err := os.Stat(cached); err == nil {
+		return ReadGenBank(cached)
+	}
+
+	record, err := download(accession)
+	if err != nil {
+		return Genbank{}, err
+	}
+
+	gb, err := parseGenBank(bytes.NewReader(record))
+	if err != nil {
+		return Genbank{}, err
+	}
+
+	// a failure to cache the record isn't fatal: we can still use it
+	err = os.MkdirAll(dir, 0755)
+	if err == nil {
+		tmp, err := ioutil.TempFile(dir, accession+".*.tmp")
+		if err == nil {
+			_, err = tmp.Write(record)
+			tmp.Close()
+			if err == nil {
+				err = os.Rename(tmp.Name(), cached)
+			}
+			if err != nil {
+				os.Remove(tmp.Name())
+			}
+		}
+	}
+
+	return gb, nil
+}
+
+// Load reads a genbank record from a file or, if there is no such file and
+// genbankFile looks like an accession, fetches it from NCBI (see Fetch). Any
+// problems that Validate finds with it are written to stderr as warnings
+func Load(genbankFile string) (Genbank, error) {
+
+	gb, err := load(genbankFile)
+	if err != nil {
+		return gb, err
+	}
+
+	warnings := gb.Validate()
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s\n", genbankFile, w)
+	}
+	summary.Add(warningsCount, len(warnings))
+
+	return gb, nil
+}
+
+// load is Load without the validation
+func load(genbankFile string) (Genbank, error) {
+
+	if _, err := os.Stat(genbankFile); os.IsNotExist(err) && IsAccession(genbankFile) {
+		return Fetch(genbankFile)
+	}
+
+	return ReadGenBank(genbankFile)
+}
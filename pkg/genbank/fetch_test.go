@@ -0,0 +1,59 @@
+package genbank
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("id") != "MN908947.3" || r.URL.Query().Get("rettype") != "gb" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, testConvertGenbank)
+	}))
+	defer server.Close()
+
+	defaultURL := eutilsURL
+	eutilsURL = server.URL
+	defer func() { eutilsURL = defaultURL }()
+
+	dir := t.TempDir()
+	os.Setenv("GOFASTA_CACHE_DIR", dir)
+	defer os.Unsetenv("GOFASTA_CACHE_DIR")
+
+	for i := 0; i < 2; i++ {
+		gb, err := Load("MN908947.3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gb.VERSION != "MN908947.3" || len(gb.ORIGIN) != 60 {
+			t.Errorf("problem in TestFetch: %s %d", gb.VERSION, len(gb.ORIGIN))
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("problem in TestFetch: the record wasn't cached (%d requests)", requests)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "genbank", "MN908947.3.gb")); err != nil {
+		t.Errorf("problem in TestFetch: %s", err)
+	}
+
+	_, err := Fetch("MN000000.1")
+	if err == nil {
+		t.Errorf("problem in TestFetch: expected an error for a bad response")
+	}
+
+	_, err = Fetch("not an accession")
+	if err == nil {
+		t.Errorf("problem in TestFetch: expected an error for a bad accession")
+	}
+}
@@ -2,31 +2,40 @@ package genbank
 
 import (
 	"bufio"
-	"os"
+	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
-	// "fmt"
+
+	"github.com/cov-ert/gofasta/pkg/remote"
 )
 
 // Genbank is a master struct containing all the info from a single genbank record
 type Genbank struct {
-	LOCUS struct {Name string; Length int; Type string; Division string; Date string} // NOT implemented
-	DEFINITION string // NOT implemented
-	ACCESSION string // NOT implemented
-	VERSION  string // NOT implemented
+	LOCUS struct {Name string; Length int; Type string; Division string; Date string} // implemented
+	DEFINITION string // implemented
+	ACCESSION string // implemented (the primary accession only)
+	VERSION  string // implemented
 	KEYWORDS string // NOT implemented
 	SOURCE struct {Source string; Organism string} // NOT implemented
 	REFERENCE struct {Authors string; Title string; Journal string; Pubmed string; Remark string} // NOT implemented
 	COMMENT string // NOT implemented
 	FEATURES []GenbankFeature // implemented
 	ORIGIN []byte  // implemented
+
+	// where the LOCUS line is in the file, and the problems with ORIGIN's numbering, for Validate
+	locusLine int
+	originWarnings []Warning
 }
 
 // genbankField is a utility struct for moving main toplevel genbank FIELDS +
 // their associated lines around through channels, etc.
 type genbankField struct {
 	header string
+	text string // the rest of the header line
+	start int // line number of the header
 	lines []string
 }
 
@@ -35,182 +44,297 @@ type genbankField struct {
 type GenbankFeature struct {
 	Feature string
 	Pos string
-	Info map[string]string
+	Info Qualifiers
+	Line int // the line of the file that the feature starts on (0 if it wasn't read from one)
 }
 
-// TO DO make this work on a pointer to a map instead of making copies
-func updateMap(key string, value string, m map[string]string) map[string]string {
-	m[key] = value
-	return m
+// Qualifier is one /key=value qualifier of a feature. Qualifiers without a
+// value (e.g. /pseudo) have an empty Value
+type Qualifier struct {
+	Key string
+	Value string
 }
 
-// true/false does this line of the file code a new FEATURE (CDS, gene, 5'UTR etc)
-func isFeatureLine(line string, quoteClosed bool) bool {
-
-	lineFields := strings.Fields(line)
+// Qualifiers are a feature's qualifiers, in the order they are in the file.
+// Keys can be repeated (e.g. there can be several /db_xref or /note qualifiers)
+type Qualifiers []Qualifier
+
+// Get returns the value of a qualifier, or "" if the feature doesn't have it. If
+// the key is repeated, it returns the last value, which is what Info[key]
+// returned when Info was a map
+func (q Qualifiers) Get(key string) string {
+	for i := len(q) - 1; i >= 0; i-- {
+		if q[i].Key == key {
+			return q[i].Value
+		}
+	}
+	return ""
+}
 
-	if quoteClosed {
-		if len(lineFields) == 2 {
-			if lineFields[0][0] != '/' {
-				return true
-			}
+// GetAll returns all the values of a qualifier, in order
+func (q Qualifiers) GetAll(key string) []string {
+	values := make([]string, 0)
+	for _, qual := range q {
+		if qual.Key == key {
+			values = append(values, qual.Value)
 		}
 	}
+	return values
+}
 
+// Has is true if the feature has at least one qualifier with this key
+func (q Qualifiers) Has(key string) bool {
+	for _, qual := range q {
+		if qual.Key == key {
+			return true
+		}
+	}
 	return false
 }
 
-// get the FEATURES info
-func parseGenbankFEATURES(field genbankField) ([]GenbankFeature) {
+// Add appends a qualifier
+func (q *Qualifiers) Add(key string, value string) {
+	*q = append(*q, Qualifier{Key: key, Value: value})
+}
+
+// qualifiers start in the 22nd column of the FEATURES section (after 21 spaces),
+// feature keys start in the 6th
+const qualifierIndent = 21
+
+// indentation returns the number of leading spaces in a line
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// isQualifierKey checks that a qualifier name is made of letters, digits and underscores
+func isQualifierKey(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// readQuotedValue adds text from a quoted qualifier value to value, up to the closing
+// quote if there is one (a doubled quote "" is a literal quote). It returns whether
+// the quote is still open at the end of the text, and any text after the closing quote.
+func readQuotedValue(text string, value *strings.Builder) (bool, string) {
+	for i := 0; i < len(text); i++ {
+		if text[i] != '"' {
+			value.WriteByte(text[i])
+			continue
+		}
+		if i+1 < len(text) && text[i+1] == '"' {
+			value.WriteByte('"')
+			i++
+			continue
+		}
+		return false, text[i+1:]
+	}
+	return true, ""
+}
+
+// get the FEATURES info. Each line is a feature (its key and its location), a
+// qualifier (/key=value or /key), or a continuation of the location or of a
+// quoted qualifier value on the line before. Anything else is an error, which
+// gives the line number in the file (field.start is the line number of the
+// FEATURES line itself).
+func parseGenbankFEATURES(field genbankField) ([]GenbankFeature, error) {
 
 	rawLines := field.lines
 
 	features := make([]GenbankFeature, 0)
 
-	quoteClosed := true
-	var feature string
-	var pos string
 	var gb GenbankFeature
-	var keyBuffer []rune
-	var valueBuffer []rune
-	var isKey bool
-
-	for linecounter, line := range(rawLines) {
+	var key string
+	var value strings.Builder
+	inFeature := false
+	inLocation := false
+	inQualifier := false
+	quoteOpen := false
+
+	// put the qualifier we have been reading (if there is one) into the feature
+	finishQualifier := func() {
+		if inQualifier {
+			gb.Info.Add(key, value.String())
+		}
+		inQualifier = false
+		key = ""
+		value.Reset()
+	}
 
-		newFeature := isFeatureLine(line, quoteClosed)
+	for i, line := range(rawLines) {
 
-		if newFeature && linecounter == 0 {
+		lineNumber := field.start + 1 + i
 
-			lineFields := strings.Fields(line)
+		trimmed := strings.TrimSpace(line)
 
-			feature = lineFields[0]
-			pos = lineFields[1]
+		if len(trimmed) == 0 {
+			continue
+		}
 
-			gb = GenbankFeature{}
-			gb.Feature = feature
-			gb.Pos = pos
-			gb.Info = make(map[string]string)
+		malformed := func(problem string) error {
+			return fmt.Errorf("malformed genbank FEATURES at line %d: %s: %s", lineNumber, problem, trimmed)
+		}
 
-			keyBuffer = make([]rune, 0)
-			valueBuffer = make([]rune, 0)
+		switch {
+		case quoteOpen:
+			// quoted values that are wrapped over lines are joined with a space,
+			// except for translations, which are sequences
+			if key != "translation" {
+				value.WriteByte(' ')
+			}
+			var rest string
+			quoteOpen, rest = readQuotedValue(trimmed, &value)
+			if len(strings.TrimSpace(rest)) > 0 {
+				return []GenbankFeature{}, malformed("unexpected text after the closing quote of /" + key)
+			}
 
-		} else if strings.TrimSpace(line)[0] == '/' && len(keyBuffer) == 0 {
+		case indentation(line) < qualifierIndent:
+			lineFields := strings.Fields(line)
+			if len(lineFields) != 2 || lineFields[0][0] == '/' {
+				return []GenbankFeature{}, malformed("expected a feature key and location")
+			}
 
-			keyBuffer = make([]rune, 0)
-			valueBuffer = make([]rune, 0)
+			finishQualifier()
+			if inFeature {
+				features = append(features, gb)
+			}
 
-			isKey = true
+			gb = GenbankFeature{}
+			gb.Feature = lineFields[0]
+			gb.Pos = lineFields[1]
+			gb.Info = make(Qualifiers, 0)
+			gb.Line = lineNumber
 
-			quoteClosed = true
+			inFeature = true
+			inLocation = true
 
-			for _, character := range(strings.TrimSpace(line)[1:]) {
+		case trimmed[0] == '/':
+			if !inFeature {
+				return []GenbankFeature{}, malformed("qualifier before the first feature")
+			}
 
-				if character == '=' {
-					isKey = false
-					continue
-				}
+			finishQualifier()
+			inLocation = false
 
-				if isKey == true {
-					keyBuffer = append(keyBuffer, character)
-				} else {
-					if character == '"' {
-						quoteClosed = ! quoteClosed
-						continue
-					}
-					valueBuffer = append(valueBuffer, character)
-				}
+			text := trimmed[1:]
+			eq := strings.IndexByte(text, '=')
+			if eq < 0 {
+				key = text
+			} else {
+				key = text[:eq]
 			}
 
-		} else if ! quoteClosed {
+			if !isQualifierKey(key) {
+				return []GenbankFeature{}, malformed("bad qualifier name")
+			}
 
-			for _, character := range(strings.TrimSpace(line)) {
-				if character == '"' {
-					quoteClosed = ! quoteClosed
-					continue
-				}
+			inQualifier = true
 
-				valueBuffer = append(valueBuffer, character)
+			if eq < 0 {
+				continue
 			}
 
-		} else if strings.TrimSpace(line)[0] == '/' && len(keyBuffer) != 0 {
+			text = text[eq+1:]
+			if len(text) > 0 && text[0] == '"' {
+				var rest string
+				quoteOpen, rest = readQuotedValue(text[1:], &value)
+				if len(strings.TrimSpace(rest)) > 0 {
+					return []GenbankFeature{}, malformed("unexpected text after the closing quote of /" + key)
+				}
+			} else {
+				value.WriteString(text)
+			}
 
-			quoteClosed = true
+		case inLocation:
+			// locations (e.g. long joins) can be wrapped over lines too
+			gb.Pos += trimmed
 
-			gb.Info = updateMap(string(keyBuffer), string(valueBuffer), gb.Info)
+		default:
+			return []GenbankFeature{}, malformed("expected a qualifier")
+		}
+	}
 
-			keyBuffer = make([]rune, 0)
-			valueBuffer = make([]rune, 0)
+	if quoteOpen {
+		return []GenbankFeature{}, fmt.Errorf("malformed genbank FEATURES: the quote in /%s is never closed", key)
+	}
 
-			isKey = true
+	finishQualifier()
+	if inFeature {
+		features = append(features, gb)
+	}
 
-			for _, character := range(strings.TrimSpace(line)[1:]) {
+	return features, nil
+}
 
-				if character == '=' {
-					isKey = false
-					continue
-				}
+// get the text of a simple field (e.g. DEFINITION), which can be wrapped over lines
+func parseGenbankText(field genbankField) string {
 
-				if isKey {
-					keyBuffer = append(keyBuffer, character)
-				} else {
-					if character == '"' {
-						quoteClosed = ! quoteClosed
-						continue
-					}
-					valueBuffer = append(valueBuffer, character)
-				}
-			}
+	words := strings.Fields(field.text)
 
-		} else if newFeature && linecounter != 0 {
+	for _, line := range(field.lines) {
+		words = append(words, strings.Fields(line)...)
+	}
 
-			quoteClosed = true
+	return strings.Join(words, " ")
+}
 
-			gb.Info = updateMap(string(keyBuffer), string(valueBuffer), gb.Info)
-			features = append(features, gb)
+// get the LOCUS info, e.g.:
+// LOCUS       NC_045512              29903 bp    ss-RNA     linear   VRL 18-JUL-2020
+func parseGenbankLOCUS(field genbankField, gb *Genbank) {
 
-			lineFields := strings.Fields(line)
-			feature = lineFields[0]
-			pos = lineFields[1]
+	fields := strings.Fields(field.text)
 
-			gb = GenbankFeature{}
-			gb.Feature = feature
-			gb.Pos = pos
-			gb.Info = make(map[string]string)
+	if len(fields) > 0 {
+		gb.LOCUS.Name = fields[0]
+	}
 
-			keyBuffer = make([]rune, 0)
-			valueBuffer = make([]rune, 0)
+	if len(fields) > 2 && (fields[2] == "bp" || fields[2] == "aa") {
+		gb.LOCUS.Length, _ = strconv.Atoi(fields[1])
+		if len(fields) > 3 {
+			gb.LOCUS.Type = fields[3]
 		}
 	}
 
-	features = append(features, gb)
-
-	// for _, feature := range(features){
-	// 	fmt.Println(feature.feature + ", " + feature.pos)
-	// 	for key, value := range(feature.info) {
-	// 		fmt.Println(key + ": " + value)
-	// 	}
-	// 	fmt.Println(" ")
-	// }
-
-	return features
+	if len(fields) > 5 {
+		gb.LOCUS.Division = fields[len(fields)-2]
+		gb.LOCUS.Date = fields[len(fields)-1]
+	}
 }
 
-// get the ORIGIN info
-func parseGenbankORIGIN(field genbankField) ([]byte) {
+// get the ORIGIN info. Each line starts with the position of its first base, and lines
+// where that doesn't match the number of bases before them (e.g. because a line has been
+// lost) are returned as warnings
+func parseGenbankORIGIN(field genbankField) ([]byte, []Warning) {
 
 	rawLines := field.lines
 
 	seq := make([]byte, 0)
+	warnings := make([]Warning, 0)
 
-	for _, line := range(rawLines) {
+	for i, line := range(rawLines) {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			if pos, err := strconv.Atoi(fields[0]); err == nil && pos != len(seq) + 1 {
+				warnings = append(warnings, Warning{Line: field.start + 1 + i, Problem: fmt.Sprintf("ORIGIN line numbered %d follows %d bases", pos, len(seq))})
+			}
+		}
 		for _, character := range(line) {
 			if unicode.IsLetter(character) {
+				// RNA records (e.g. RefSeq mRNAs) are read as DNA, like fasta files
+				if character == 'u' || character == 'U' {
+					character += 't' - 'u'
+				}
 				seq = append(seq, []byte(string(character))...)
 			}
 		}
 	}
 
-	return seq
+	return seq, warnings
 }
 
 // ReadGenBank reads a genbank annotation file and returns a struct that contains
@@ -218,23 +342,60 @@ func parseGenbankORIGIN(field genbankField) ([]byte) {
 // Not all fields are currently parsed.
 func ReadGenBank(infile string) (Genbank, error) {
 
-	gb := Genbank{}
-
-	f, err := os.Open(infile)
+	f, err := remote.Open(infile)
 	if err != nil {
 		return Genbank{}, err
 	}
 	defer f.Close()
 
+	return parseGenBank(f)
+}
+
+// Parse reads a genbank record from r, which is for callers that have it in memory rather
+// than in a file (see ReadGenBank)
+func Parse(r io.Reader) (Genbank, error) {
+	return parseGenBank(r)
+}
+
+// parseGenBank does the work for ReadGenBank, on anything that can be read from
+func parseGenBank(f io.Reader) (Genbank, error) {
+
+	gb := Genbank{}
+
 	s := bufio.NewScanner(f)
 
-	first := true
 	var header string
+	var headerText string
+	var headerLine int
 	var lines []string
-	var field genbankField
+
+	// parse the field we have been reading (if it is one we parse)
+	parseField := func() error {
+		var err error
+		field := genbankField{header: header, text: headerText, start: headerLine, lines: lines}
+		switch {
+		case header == "LOCUS":
+			parseGenbankLOCUS(field, &gb)
+			gb.locusLine = field.start
+		case header == "DEFINITION":
+			gb.DEFINITION = parseGenbankText(field)
+		case header == "ACCESSION":
+			gb.ACCESSION = strings.SplitN(parseGenbankText(field), " ", 2)[0]
+		case header == "VERSION":
+			gb.VERSION = strings.SplitN(parseGenbankText(field), " ", 2)[0]
+		case header == "FEATURES":
+			gb.FEATURES, err = parseGenbankFEATURES(field)
+		case header == "ORIGIN":
+			gb.ORIGIN, gb.originWarnings = parseGenbankORIGIN(field)
+		}
+		return err
+	}
+
+	lineNumber := 0
 
 	for s.Scan() {
 		line := s.Text()
+		lineNumber++
 
 		if len(line) == 0 {
 			continue
@@ -242,26 +403,20 @@ func ReadGenBank(infile string) (Genbank, error) {
 
 		r, _ := utf8.DecodeRune([]byte{line[0]})
 
-		if unicode.IsUpper(r){
-			// fmt.Println(line)
-			if first {
-				header = strings.Fields(line)[0]
-				first = false
-				continue
+		// a new field, or the end of the record
+		if unicode.IsUpper(r) || strings.HasPrefix(line, "//") {
+			err := parseField()
+			if err != nil {
+				return Genbank{}, err
 			}
 
-			switch {
-			case header == "FEATURES":
-				field = genbankField{header: header, lines: lines}
-				gb.FEATURES = parseGenbankFEATURES(field)
-				// fmt.Println(gb.FEATURES)
-			case header == "ORIGIN":
-				field = genbankField{header: header, lines: lines}
-				gb.ORIGIN = parseGenbankORIGIN(field)
-				// fmt.Println(string(gb.ORIGIN))
+			header = ""
+			headerText = ""
+			if unicode.IsUpper(r) {
+				header = strings.Fields(line)[0]
+				headerText = strings.TrimSpace(line[len(header):])
 			}
-
-			header = strings.Fields(line)[0]
+			headerLine = lineNumber
 			lines = make([]string, 0)
 
 			continue
@@ -270,26 +425,15 @@ func ReadGenBank(infile string) (Genbank, error) {
 		lines = append(lines, line)
 	}
 
-	switch {
-	case header == "FEATURES":
-		field = genbankField{header: header, lines: lines}
-		gb.FEATURES = parseGenbankFEATURES(field)
-		// fmt.Println(gb.FEATURES)
-	case header == "ORIGIN":
-		field = genbankField{header: header, lines: lines}
-		gb.ORIGIN = parseGenbankORIGIN(field)
-		// fmt.Println(string(gb.ORIGIN))
+	err := s.Err()
+	if err != nil {
+		return Genbank{}, err
 	}
 
-	// for _, feature := range(gb.FEATURES){
-	// 	fmt.Println(feature.Feature + ", " + feature.Pos)
-	// 	for key, value := range(feature.Info) {
-	// 		fmt.Println(key + ": " + value)
-	// 	}
-	// 	fmt.Println(" ")
-	// }
-	//
-	// fmt.Println(string(gb.ORIGIN))
+	err = parseField()
+	if err != nil {
+		return Genbank{}, err
+	}
 
 	return gb, nil
 }
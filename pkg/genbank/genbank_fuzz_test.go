@@ -0,0 +1,22 @@
+//go:build go1.18
+// +build go1.18
+
+package genbank
+
+import (
+	"strings"
+	"testing"
+)
+
+// the fuzz targets are in their own file, because testing.F needs Go 1.18
+
+func FuzzParseGenBank(f *testing.F) {
+	f.Add([]byte(testGenbank))
+	f.Add([]byte("FEATURES\n     /gene=\"g1\"\n   \n     CDS 1..3\n"))
+	f.Add([]byte("FEATURES\n     CDS 1..3\n                     /note=\"unclosed\nORIGIN\n 1 atg\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// malformed input may be rejected, but must not cause a panic
+		parseGenBank(strings.NewReader(string(data)))
+	})
+}
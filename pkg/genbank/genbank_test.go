@@ -0,0 +1,113 @@
+package genbank
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+)
+
+var testGenbank = `LOCUS       ref                       48 bp    RNA     linear   VRL 01-JAN-2020
+FEATURES             Location/Qualifiers
+     source          1..48
+                     /organism="test"
+     gene            1..48
+                     /gene="g1"
+                     /db_xref="GeneID:1"
+                     /pseudo
+                     /db_xref="GeneID:2"
+     CDS             join(1..12,
+                     25..48)
+                     /gene="g1"
+                     /note="a long note that is
+                     split over two lines"
+                     /codon_start=1
+ORIGIN
+        1 atgaaacccg ggtttatgcc caaatttggg aaaccctttg ggaaataa
+//
+`
+
+func TestParseGenBank(t *testing.T) {
+	gb, err := parseGenBank(strings.NewReader(testGenbank))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gb.FEATURES) != 3 {
+		t.Fatalf("problem in TestParseGenBank: wrong number of features (%d)", len(gb.FEATURES))
+	}
+	if gb.FEATURES[2].Feature != "CDS" || gb.FEATURES[2].Pos != "join(1..12,25..48)" {
+		t.Errorf("problem in TestParseGenBank: %s %s", gb.FEATURES[2].Feature, gb.FEATURES[2].Pos)
+	}
+	if gb.FEATURES[2].Info.Get("note") != "a long note that is split over two lines" {
+		t.Errorf("problem in TestParseGenBank: %s", gb.FEATURES[2].Info.Get("note"))
+	}
+	if gb.FEATURES[2].Info.Get("codon_start") != "1" {
+		t.Errorf("problem in TestParseGenBank: the last qualifier is missing")
+	}
+	if string(gb.ORIGIN) != "atgaaacccgggtttatgcccaaatttgggaaaccctttgggaaataa" {
+		t.Errorf("problem in TestParseGenBank: %s", gb.ORIGIN)
+	}
+}
+
+// a record with Windows line endings is read in the same way
+func TestParseGenBankCRLF(t *testing.T) {
+	gb, err := parseGenBank(strings.NewReader(testGenbank))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlf, err := parseGenBank(strings.NewReader(testutil.CRLF(testGenbank)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(crlf, gb) {
+		t.Errorf("problem in TestParseGenBankCRLF: got\n%+v\nexpected\n%+v", crlf, gb)
+	}
+}
+
+func TestQualifiers(t *testing.T) {
+	gb, err := parseGenBank(strings.NewReader(testGenbank))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := gb.FEATURES[1].Info
+
+	if len(info) != 4 || info[2].Key != "pseudo" || info[2].Value != "" {
+		t.Errorf("problem in TestQualifiers: %v", info)
+	}
+	if xrefs := info.GetAll("db_xref"); len(xrefs) != 2 || xrefs[0] != "GeneID:1" || xrefs[1] != "GeneID:2" {
+		t.Errorf("problem in TestQualifiers: %v", xrefs)
+	}
+	if info.Get("db_xref") != "GeneID:2" || info.Get("gene") != "g1" || info.Get("product") != "" {
+		t.Errorf("problem in TestQualifiers: bad Get")
+	}
+	if !info.Has("pseudo") || info.Has("product") {
+		t.Errorf("problem in TestQualifiers: bad Has")
+	}
+}
+
+func TestParseGenBankErrors(t *testing.T) {
+	bad := map[string]string{
+		"FEATURES\n                     /gene=\"g1\"\n":                                     "line 2",
+		"FEATURES\n     CDS             1..3\n                     /gene=g\"1\n":            "",
+		"FEATURES\n     CDS             1..3\n                     /=\"g1\"\n":              "line 3",
+		"FEATURES\n     CDS             1..3\n     /note=\"a\" b\n":                         "line 3",
+		"LOCUS x\nFEATURES\n     CDS             1..3\n                     /note=\"a\"b\n": "line 4",
+		"FEATURES\n     CDS             1..3\n                     /note=\"open\nORIGIN\n":  "never closed",
+	}
+
+	for gbk, where := range bad {
+		_, err := parseGenBank(strings.NewReader(gbk))
+		if where == "" {
+			if err != nil {
+				t.Errorf("problem in TestParseGenBankErrors: unexpected error: %s", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), where) {
+			t.Errorf("problem in TestParseGenBankErrors: expected an error at %s, got: %v", where, err)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package genbank
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+)
+
+// span is one stretch of a feature's location, in 1-based, inclusive coordinates.
+// If complement is true, the feature is on the reverse strand of this stretch
+type span struct {
+	start      int
+	end        int
+	complement bool
+}
+
+// parseLocation parses a feature location, e.g. "join(266..13468,13468..21555)" or
+// "complement(<1..>200)", into the spans that make up the feature, in the order
+// that they make up the feature's sequence. order() is treated the same as join().
+// Partial ends (< and >) are ignored, and remote (accession:position) and
+// between-base (^) locations aren't supported.
+func parseLocation(location string) ([]span, error) {
+
+	location = strings.TrimSpace(location)
+
+	switch {
+	case strings.HasPrefix(location, "complement(") && strings.HasSuffix(location, ")"):
+		inner, err := parseLocation(location[len("complement(") : len(location)-1])
+		if err != nil {
+			return []span{}, err
+		}
+		spans := make([]span, len(inner))
+		for i, s := range inner {
+			s.complement = !s.complement
+			spans[len(inner)-1-i] = s
+		}
+		return spans, nil
+
+	case (strings.HasPrefix(location, "join(") || strings.HasPrefix(location, "order(")) && strings.HasSuffix(location, ")"):
+		inner := location[strings.IndexByte(location, '(')+1 : len(location)-1]
+		spans := make([]span, 0)
+		for _, part := range splitLocation(inner) {
+			s, err := parseLocation(part)
+			if err != nil {
+				return []span{}, err
+			}
+			spans = append(spans, s...)
+		}
+		return spans, nil
+	}
+
+	if strings.ContainsAny(location, "(),:^") {
+		return []span{}, errors.New("unsupported feature location: " + location)
+	}
+
+	ends := strings.Split(strings.NewReplacer("<", "", ">", "").Replace(location), "..")
+	if len(ends) > 2 {
+		return []span{}, errors.New("couldn't parse feature location: " + location)
+	}
+
+	start, err := strconv.Atoi(ends[0])
+	if err != nil {
+		return []span{}, errors.New("couldn't parse feature location: " + location)
+	}
+	end := start
+	if len(ends) == 2 {
+		end, err = strconv.Atoi(ends[1])
+		if err != nil {
+			return []span{}, errors.New("couldn't parse feature location: " + location)
+		}
+	}
+
+	if start < 1 || end < start {
+		return []span{}, errors.New("bad feature location: " + location)
+	}
+
+	return []span{{start: start, end: end}}, nil
+}
+
+// splitLocation splits the inside of a join() on the commas that aren't inside
+// another pair of brackets
+func splitLocation(location string) []string {
+	parts := make([]string, 0)
+	depth := 0
+	last := 0
+	for i, c := range location {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, location[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, location[last:])
+}
+
+// Extract returns the feature's nucleotide sequence (in upper case) from a
+// genbank record's ORIGIN, using its location: joined spans are concatenated,
+// and complemented spans are reverse complemented
+func (f GenbankFeature) Extract(origin []byte) ([]byte, error) {
+
+	spans, err := parseLocation(f.Pos)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	seq := make([]byte, 0)
+
+	for _, s := range spans {
+		if s.end > len(origin) {
+			return []byte{}, fmt.Errorf("feature location %s is outside the sequence (length %d)", f.Pos, len(origin))
+		}
+
+		sub := []byte(strings.ToUpper(string(origin[s.start-1 : s.end])))
+
+		if s.complement {
+			sub = alphabet.ReverseComplement(sub)
+		}
+
+		seq = append(seq, sub...)
+	}
+
+	return seq, nil
+}
+
+// Positions returns the (1-based) position in the genbank record's sequence of each nucleotide of
+// the feature, in the order they make up the feature's sequence (as Extract), and whether each one
+// is complemented. With /codon_start, the first one or two positions are left out, so that every
+// three positions are a codon
+func (f GenbankFeature) Positions() ([]int, []bool, error) {
+
+	spans, err := parseLocation(f.Pos)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	positions := make([]int, 0)
+	complemented := make([]bool, 0)
+
+	for _, s := range spans {
+		for i := 0; i <= s.end-s.start; i++ {
+			if s.complement {
+				positions = append(positions, s.end-i)
+			} else {
+				positions = append(positions, s.start+i)
+			}
+			complemented = append(complemented, s.complement)
+		}
+	}
+
+	if cs := f.Info.Get("codon_start"); cs != "" {
+		frame, err := strconv.Atoi(cs)
+		if err != nil || frame < 1 || frame > 3 || frame > len(positions) {
+			return nil, nil, errors.New("bad /codon_start: " + cs)
+		}
+		positions = positions[frame-1:]
+		complemented = complemented[frame-1:]
+	}
+
+	return positions, complemented, nil
+}
+
+// FindCDS returns the CDS whose /gene is gene, or failing that, whose /product is, where either
+// can be any of the gene's names (see LoadAliases), e.g. spike for the CDS with /gene="S"
+func (gb Genbank) FindCDS(gene string) (GenbankFeature, bool) {
+	for _, key := range []string{"gene", "product"} {
+		for _, f := range gb.FEATURES {
+			if f.Feature == "CDS" && f.Info.Has(key) && SameGene(f.Info.Get(key), gene) {
+				return f, true
+			}
+		}
+	}
+	return GenbankFeature{}, false
+}
+
+// CDSs returns the record's CDSs, and a name for each one that no other has: its /gene, or its
+// canonical name (see LoadAliases) from its /product if another CDS has the same /gene (e.g. ORF1a,
+// whose /gene is ORF1ab in SARS-CoV-2's annotation), or CDS_n (for the nth feature) if it has
+// neither. Names that are still the same are numbered, e.g. ORF1ab_2
+func (gb Genbank) CDSs() ([]GenbankFeature, []string) {
+
+	features := make([]GenbankFeature, 0)
+	names := make([]string, 0)
+	seen := make(map[string]int)
+
+	for i, f := range gb.FEATURES {
+		if f.Feature != "CDS" {
+			continue
+		}
+		name := f.Info.Get("gene")
+		if (len(name) == 0 || seen[name] > 0) && f.Info.Has("product") {
+			name = CanonicalGeneName(f.Info.Get("product"))
+		}
+		if len(name) == 0 {
+			name = "CDS_" + strconv.Itoa(i+1)
+		}
+		seen[name]++
+		if seen[name] > 1 {
+			name = name + "_" + strconv.Itoa(seen[name])
+		}
+		features = append(features, f)
+		names = append(names, name)
+	}
+
+	return features, names
+}
+
+// Complement returns the complement of a nucleotide (IUPAC codes included), or N if it isn't one
+func Complement(nuc byte) byte {
+	return alphabet.Complement(nuc)
+}
+
+// Translate returns the amino acid sequence of the feature (e.g. a CDS), starting from its
+// /codon_start if it has one, with its /transl_table's genetic code and its /transl_except
+// exceptions. Codons that could be more than one amino acid are translated as X, and an
+// incomplete final codon is dropped
+func (f GenbankFeature) Translate(origin []byte) (string, error) {
+
+	seq, err := f.Extract(origin)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := f.Coding()
+	if err != nil {
+		return "", err
+	}
+
+	if c.CodonStart-1 > len(seq) {
+		return "", nil
+	}
+
+	t := c.Translator(alphabet.NewTranslator(alphabet.CodonX))
+
+	return string(t.Translate(seq[c.CodonStart-1:])), nil
+}
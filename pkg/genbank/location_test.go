@@ -0,0 +1,136 @@
+package genbank
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	origin := []byte("atgaaacccgggtttatgcccaaatttgggaaaccctttgggaaataa")
+
+	tests := map[string]string{
+		"1..6":                                   "ATGAAA",
+		"<1..>6":                                 "ATGAAA",
+		"7":                                      "C",
+		"join(1..3,10..12)":                      "ATGGGG",
+		"complement(1..6)":                       "TTTCAT",
+		"complement(join(1..3,10..12))":          "CCCCAT",
+		"join(complement(10..12),1..3)":          "CCCATG",
+		"order(1..3,complement(4..6))":           "ATGTTT",
+		"join(1..3,join(4..6,complement(7..9)))": "ATGAAAGGG",
+	}
+
+	for location, want := range tests {
+		got, err := GenbankFeature{Pos: location}.Extract(origin)
+		if err != nil {
+			t.Errorf("problem in TestExtract: %s: %s", location, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("problem in TestExtract: %s: got %s, want %s", location, got, want)
+		}
+	}
+
+	for _, location := range []string{"1..49", "6..1", "J00194.1:1..3", "1^2", "join(1..3", "a..b"} {
+		_, err := GenbankFeature{Pos: location}.Extract(origin)
+		if err == nil {
+			t.Errorf("problem in TestExtract: expected an error for %s", location)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	origin := []byte("atgaaacccgggtttatgcccaaatttgggaaaccctttgggaaataa")
+
+	f := GenbankFeature{Feature: "CDS", Pos: "join(1..12,25..48)"}
+	aa, err := f.Translate(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aa != "MKPGFGKPFGK*" {
+		t.Errorf("problem in TestTranslate: %s", aa)
+	}
+
+	f = GenbankFeature{Feature: "CDS", Pos: "2..13"}
+	f.Info.Add("codon_start", "3")
+	aa, err = f.Translate(origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aa != "KPG" {
+		t.Errorf("problem in TestTranslate: %s", aa)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	f := GenbankFeature{Pos: "join(2..4,complement(7..8))"}
+	f.Info.Add("codon_start", "2")
+
+	positions, complemented, err := f.Positions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(positions, []int{3, 4, 8, 7}) || !reflect.DeepEqual(complemented, []bool{false, false, true, true}) {
+		t.Errorf("problem in TestPositions: got %v %v", positions, complemented)
+	}
+}
+
+func TestTranslateCoding(t *testing.T) {
+	origin := []byte("atgaaacccgggtttatgcccaaatttgggaaaccctttgggaaataa")
+
+	tests := []struct {
+		location   string
+		qualifiers map[string]string
+		want       string
+	}{
+		// a selenocysteine in the second span of a join
+		{"join(1..12,25..48)", map[string]string{"transl_except": "(pos:28..30,aa:Sec)"}, "MKPGFUKPFGK*"},
+		// a stop on the reverse strand, which is at the end of its range
+		{"complement(1..12)", map[string]string{"transl_except": "(pos:complement(4..6),aa:TERM)"}, "PG*H"},
+		// counted from /codon_start
+		{"2..13", map[string]string{"codon_start": "3", "transl_except": "(pos:7..9,aa:Pyl)"}, "KOG"},
+	}
+
+	for _, test := range tests {
+		f := GenbankFeature{Feature: "CDS", Pos: test.location}
+		for k, v := range test.qualifiers {
+			f.Info.Add(k, v)
+		}
+		aa, err := f.Translate(origin)
+		if err != nil {
+			t.Errorf("problem in TestTranslateCoding: %s: %s", test.location, err)
+			continue
+		}
+		if aa != test.want {
+			t.Errorf("problem in TestTranslateCoding: %s: got %s, want %s", test.location, aa, test.want)
+		}
+	}
+
+	// TGA, ATA and AGA are W, M and a stop in the vertebrate mitochondrial code
+	f := GenbankFeature{Feature: "CDS", Pos: "1..12"}
+	f.Info.Add("transl_table", "2")
+	aa, err := f.Translate([]byte("atgtgaataaga"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aa != "MWM*" {
+		t.Errorf("problem in TestTranslateCoding: transl_table 2: got %s, want MWM*", aa)
+	}
+
+	bad := []map[string]string{
+		{"transl_table": "7"},
+		{"transl_table": "mito"},
+		{"transl_except": "(pos:2..4,aa:Sec)"},
+		{"transl_except": "(pos:4..6,aa:Foo)"},
+		{"transl_except": "pos:4..6,aa:Sec"},
+	}
+	for _, qualifiers := range bad {
+		f := GenbankFeature{Feature: "CDS", Pos: "1..12"}
+		for k, v := range qualifiers {
+			f.Info.Add(k, v)
+		}
+		if _, err := f.Coding(); err == nil {
+			t.Errorf("problem in TestTranslateCoding: expected an error for %v", qualifiers)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package genbank
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/internal/summary"
+)
+
+// the name of the count of validation warnings in the run summary
+const warningsCount = "genbank_warnings"
+
+// Warning is a problem with a genbank record that doesn't stop it from being read, but that
+// means that positions in it (or in alignments to its sequence) could be wrong
+type Warning struct {
+	// Line is the line of the file that the problem is on, or 0 if it isn't on one line
+	Line int
+	// Feature is the key and location of the feature that the problem is with, if it is with one
+	Feature string
+	Problem string
+}
+
+func (w Warning) String() string {
+	s := w.Problem
+	if len(w.Feature) > 0 {
+		s = w.Feature + ": " + s
+	}
+	if w.Line > 0 {
+		s = "line " + strconv.Itoa(w.Line) + ": " + s
+	}
+	return s
+}
+
+// Validate checks that a genbank record is consistent: that it isn't empty, that its ORIGIN is as long as its LOCUS
+// line says, that ORIGIN's lines are numbered to match, and that every feature's location can
+// be parsed and is within the sequence. It returns what is wrong, in the order it is in the file
+func (gb Genbank) Validate() []Warning {
+
+	warnings := make([]Warning, 0)
+
+	// e.g. an empty file, which is read as a record with no features
+	if gb.locusLine == 0 && len(gb.FEATURES) == 0 && len(gb.ORIGIN) == 0 {
+		return append(warnings, Warning{Problem: "the record is empty"})
+	}
+
+	if gb.LOCUS.Length > 0 && len(gb.ORIGIN) > 0 && gb.LOCUS.Length != len(gb.ORIGIN) {
+		warnings = append(warnings, Warning{Line: gb.locusLine, Problem: fmt.Sprintf("LOCUS length is %d, but ORIGIN has %d bases", gb.LOCUS.Length, len(gb.ORIGIN))})
+	}
+
+	// without an ORIGIN, the features can still be checked against the LOCUS length
+	length := len(gb.ORIGIN)
+	if length == 0 {
+		length = gb.LOCUS.Length
+	}
+
+	for _, f := range gb.FEATURES {
+		feature := f.Feature + " " + f.Pos
+		spans, err := parseLocation(f.Pos)
+		if err != nil {
+			warnings = append(warnings, Warning{Line: f.Line, Feature: feature, Problem: err.Error()})
+			continue
+		}
+		if length == 0 {
+			continue
+		}
+		for _, s := range spans {
+			if s.end > length {
+				warnings = append(warnings, Warning{Line: f.Line, Feature: feature, Problem: fmt.Sprintf("the location ends after the end of the sequence (length %d)", length)})
+				break
+			}
+		}
+	}
+
+	return append(warnings, gb.originWarnings...)
+}
+
+// Validate reads a genbank record (from a file, or fetched by accession as Load does) and writes
+// the problems that Genbank.Validate finds with it to outfile, as a tsv with the columns line,
+// feature and problem. It returns an error if there are any, so that they can't go unnoticed
+func Validate(genbankFile string, outfile string) error {
+
+	gb, err := load(genbankFile)
+	if err != nil {
+		return err
+	}
+
+	warnings := gb.Validate()
+	summary.Add(warningsCount, len(warnings))
+
+	f, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	_, err = w.WriteString("line\tfeature\tproblem\n")
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		_, err = fmt.Fprintf(w, "%d\t%s\t%s\n", warning.Line, warning.Feature, warning.Problem)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(warnings) > 0 {
+		return fmt.Errorf("problems found in %s: %d", genbankFile, len(warnings))
+	}
+
+	return nil
+}
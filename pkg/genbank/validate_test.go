@@ -0,0 +1,59 @@
+package genbank
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	gb, err := parseGenBank(strings.NewReader(testGenbank))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings := gb.Validate(); len(warnings) != 0 {
+		t.Errorf("problem in TestValidate: %v", warnings)
+	}
+
+	// a LOCUS length that is one out, a feature that runs off the end, and an ORIGIN line that
+	// has been lost
+	bad := `LOCUS       ref                       31 bp    DNA     linear   VRL 01-JAN-2020
+FEATURES             Location/Qualifiers
+     CDS             1..12
+                     /gene="g1"
+     CDS             complement(25..33)
+                     /gene="g2"
+     misc_feature    12^13
+ORIGIN
+        1 atgaaacccg ggtttatgcc
+       31 caaatttggg
+//
+`
+	gb, err = parseGenBank(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings := gb.Validate(); len(warnings) != 1 || warnings[0].String() != "the record is empty" {
+		t.Errorf("problem in TestValidate: got %v for an empty record", warnings)
+	}
+
+	gb, err = parseGenBank(strings.NewReader(bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"line 1: LOCUS length is 31, but ORIGIN has 30 bases",
+		"line 5: CDS complement(25..33): the location ends after the end of the sequence (length 30)",
+		"line 7: misc_feature 12^13: unsupported feature location: 12^13",
+		"line 10: ORIGIN line numbered 31 follows 20 bases",
+	}
+	warnings := gb.Validate()
+	if len(warnings) != len(expected) {
+		t.Fatalf("problem in TestValidate: got %v, expected %v", warnings, expected)
+	}
+	for i, w := range warnings {
+		if w.String() != expected[i] {
+			t.Errorf("problem in TestValidate: got %s, expected %s", w, expected[i])
+		}
+	}
+}
@@ -0,0 +1,322 @@
+/*
+Package haplotype counts combinations of mutations across the queries in an alignment: either the
+haplotypes (the bases at a set of sites) that the queries have, or how many of them have all, some
+or none of the mutations in particular combinations, e.g. co-occurring spike mutations.
+*/
+package haplotype
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// the name of the count of queries with N (or another ambiguity code) at any of the sites, which
+// aren't counted in any haplotype, in the run summary
+const incompleteCount = "incomplete"
+
+// mutation is a change from the reference's base at a (0-based) site, e.g. A23403G
+type mutation struct {
+	name string
+	pos  int
+	ref  byte
+	alt  byte
+}
+
+// known reports whether b is a base (or a gap), not N or another ambiguity code
+func known(b byte) bool {
+	return b == 'A' || b == 'C' || b == 'G' || b == 'T' || b == '-'
+}
+
+// parseMutation parses a mutation, e.g. A23403G, or C21765- for a deletion, checking its
+// reference base against ref
+func parseMutation(s string, ref string) (mutation, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) < 3 {
+		return mutation{}, usage.Errorf("couldn't parse mutation %s (it should be e.g. A23403G, or C21765- for a deletion)", s)
+	}
+	pos, err := strconv.Atoi(s[1 : len(s)-1])
+	if err != nil || !known(s[0]) || !known(s[len(s)-1]) || s[0] == s[len(s)-1] {
+		return mutation{}, usage.Errorf("couldn't parse mutation %s (it should be e.g. A23403G, or C21765- for a deletion)", s)
+	}
+	if pos < 1 || pos > len(ref) {
+		return mutation{}, usage.Errorf("mutation %s is outside the reference, which is %d long", s, len(ref))
+	}
+	if ref[pos-1] != s[0] {
+		return mutation{}, usage.Errorf("mutation %s: the reference has %c at %d, not %c", s, ref[pos-1], pos, s[0])
+	}
+	return mutation{name: s, pos: pos - 1, ref: s[0], alt: s[len(s)-1]}, nil
+}
+
+// parseCombination parses a combination of mutations separated by +, e.g. A23063T+A23403G
+func parseCombination(s string, ref string) ([]mutation, error) {
+	combination := make([]mutation, 0)
+	for _, part := range strings.Split(s, "+") {
+		m, err := parseMutation(part, ref)
+		if err != nil {
+			return nil, err
+		}
+		combination = append(combination, m)
+	}
+	return combination, nil
+}
+
+// parsePositions parses 1-based sites and ranges of sites, e.g. 23063 or 21563-21570, into 0-based
+// sites, in order, checking that they are in a reference of length length
+func parsePositions(positions []string, length int) ([]int, error) {
+	sites := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, p := range positions {
+		ends := strings.Split(strings.TrimSpace(p), "-")
+		start, err1 := strconv.Atoi(ends[0])
+		end, err2 := start, error(nil)
+		if len(ends) == 2 {
+			end, err2 = strconv.Atoi(ends[1])
+		}
+		if len(ends) > 2 || err1 != nil || err2 != nil || start < 1 || end < start {
+			return nil, usage.Errorf("couldn't parse position %s (it should be e.g. 23063, or 21563-21570 for a range)", p)
+		}
+		if end > length {
+			return nil, usage.Errorf("position %s is outside the reference, which is %d long", p, length)
+		}
+		for i := start - 1; i < end; i++ {
+			if !seen[i] {
+				sites = append(sites, i)
+				seen[i] = true
+			}
+		}
+	}
+	sort.Ints(sites)
+	if len(sites) == 0 {
+		return nil, usage.New("no positions were given")
+	}
+	return sites, nil
+}
+
+// eachQuery calls fn on each query in queryFile that md keeps, in upper case, checking that it is
+// as long as the reference
+func eachQuery(queryFile string, length int, md *metadata.Metadata, fn func(seq string)) error {
+
+	cErr := make(chan error)
+	cFR := make(chan fastaio.FastaRecord)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(queryFile, cFR, cErr, cDone)
+
+	filtered := 0
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			if len(FR.Seq) != length {
+				return fmt.Errorf("%s isn't the same length as the reference: is it aligned to it?", FR.ID)
+			}
+			if !md.Keep(FR.ID) {
+				filtered++
+				continue
+			}
+			fn(strings.ToUpper(FR.Seq))
+		case <-cDone:
+			n--
+		}
+	}
+
+	summary.Add(summary.Filtered, filtered)
+
+	return nil
+}
+
+// Haplotypes counts the haplotypes (the bases at the 1-based sites and ranges of sites in positions)
+// of the queries in queryFile (an alignment to the reference in referenceFile) that md keeps, and
+// writes them to outfile, most common first, in csv format with the columns haplotype (the bases,
+// e.g. TGA), mutations (its differences from the reference, e.g. A23063T|A23403G), count and
+// frequency. Queries with N or another ambiguity code at any of the sites aren't counted, or
+// included in the frequencies
+func Haplotypes(referenceFile string, queryFile string, positions []string, md *metadata.Metadata, outfile string) error {
+
+	refRecord, err := cliio.ReadReference(referenceFile)
+	if err != nil {
+		return err
+	}
+	ref := strings.ToUpper(refRecord.Seq)
+
+	sites, err := parsePositions(positions, len(ref))
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	complete := 0
+	incomplete := 0
+
+	hap := make([]byte, len(sites))
+	err = eachQuery(queryFile, len(ref), md, func(seq string) {
+		for i, site := range sites {
+			hap[i] = seq[site]
+			if !known(hap[i]) {
+				incomplete++
+				return
+			}
+		}
+		counts[string(hap)]++
+		complete++
+	})
+	if err != nil {
+		return err
+	}
+
+	haplotypes := make([]string, 0, len(counts))
+	for h := range counts {
+		haplotypes = append(haplotypes, h)
+	}
+	sort.Slice(haplotypes, func(i, j int) bool {
+		if counts[haplotypes[i]] != counts[haplotypes[j]] {
+			return counts[haplotypes[i]] > counts[haplotypes[j]]
+		}
+		return haplotypes[i] < haplotypes[j]
+	})
+
+	f, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	w.WriteString("haplotype,mutations,count,frequency\n")
+	for _, h := range haplotypes {
+		mutations := make([]string, 0)
+		for i, site := range sites {
+			if h[i] != ref[site] {
+				mutations = append(mutations, string(ref[site])+strconv.Itoa(site+1)+string(h[i]))
+			}
+		}
+		_, err = w.WriteString(h + "," + strings.Join(mutations, "|") + "," + strconv.Itoa(counts[h]) + "," + cliio.FormatRatio(counts[h], complete, "NA") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	reportIncomplete(incomplete)
+	summary.Add(summary.Processed, complete)
+
+	md.Report()
+
+	return nil
+}
+
+// Combinations counts the queries in queryFile (an alignment to the reference in referenceFile) that
+// md keeps that have all, some or none of the mutations in each of combinations (e.g.
+// A23063T+A23403G), and writes them to outfile in csv format with the columns combination, all, some,
+// none, missing (the queries with N or another ambiguity code at any of its sites, which aren't
+// counted in the others) and frequency (all, as a proportion of the queries that aren't missing)
+func Combinations(referenceFile string, queryFile string, combinations []string, md *metadata.Metadata, outfile string) error {
+
+	if len(combinations) == 0 {
+		return usage.New("no combinations of mutations were given")
+	}
+
+	refRecord, err := cliio.ReadReference(referenceFile)
+	if err != nil {
+		return err
+	}
+	ref := strings.ToUpper(refRecord.Seq)
+
+	parsed := make([][]mutation, len(combinations))
+	for i, c := range combinations {
+		parsed[i], err = parseCombination(c, ref)
+		if err != nil {
+			return err
+		}
+	}
+
+	// all, some, none and missing for each combination
+	counts := make([][4]int, len(parsed))
+	queries := 0
+
+	err = eachQuery(queryFile, len(ref), md, func(seq string) {
+		queries++
+		for i, combination := range parsed {
+			present := 0
+			missing := false
+			for _, m := range combination {
+				switch {
+				case !known(seq[m.pos]):
+					missing = true
+				case seq[m.pos] == m.alt:
+					present++
+				}
+			}
+			switch {
+			case missing:
+				counts[i][3]++
+			case present == len(combination):
+				counts[i][0]++
+			case present > 0:
+				counts[i][1]++
+			default:
+				counts[i][2]++
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	w.WriteString("combination,all,some,none,missing,frequency\n")
+	for i, combination := range parsed {
+		names := make([]string, len(combination))
+		for j, m := range combination {
+			names[j] = m.name
+		}
+		c := counts[i]
+		_, err = w.WriteString(strings.Join(names, "+") + "," + strconv.Itoa(c[0]) + "," + strconv.Itoa(c[1]) + "," +
+			strconv.Itoa(c[2]) + "," + strconv.Itoa(c[3]) + "," + cliio.FormatRatio(c[0], c[0]+c[1]+c[2], "NA") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	summary.Add(summary.Processed, queries)
+
+	md.Report()
+
+	return nil
+}
+
+// reportIncomplete writes the number of queries that weren't counted because of ambiguity codes
+// to stderr, and records it in the run summary
+func reportIncomplete(n int) {
+	if n > 0 {
+		fmt.Fprintf(os.Stderr, "%d queries have N or another ambiguity code at one or more of the sites, and weren't counted\n", n)
+	}
+	summary.Add(incompleteCount, n)
+}
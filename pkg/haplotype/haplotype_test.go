@@ -0,0 +1,80 @@
+package haplotype
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func writeTestFiles(t *testing.T, dir string) (string, string) {
+	refFile := filepath.Join(dir, "ref.fasta")
+	queryFile := filepath.Join(dir, "query.fasta")
+	err := ioutil.WriteFile(refFile, []byte(">ref\nACGTACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// q1 and q3 have A1T and C2G, q2 only has A1T, q4 has neither, and q5 has an N at 2
+	err = ioutil.WriteFile(queryFile, []byte(">q1\nTGGTACGT\n>q2\nTCGTACGT\n>q3\nTGGTACGT\n>q4\nACGTAC-T\n>q5\nTNGTACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refFile, queryFile
+}
+
+func TestHaplotypes(t *testing.T) {
+	dir := t.TempDir()
+	refFile, queryFile := writeTestFiles(t, dir)
+	outFile := filepath.Join(dir, "haplotypes.csv")
+
+	err := Haplotypes(refFile, queryFile, []string{"1-2", "7"}, nil, outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "haplotype,mutations,count,frequency\n" +
+		"TGG,A1T|C2G,2,0.5000\n" +
+		"AC-,G7-,1,0.2500\n" +
+		"TCG,A1T,1,0.2500\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestHaplotypes: got\n%s\nwant\n%s", out, expected)
+	}
+
+	for _, positions := range [][]string{{"9"}, {"2-1"}, {"x"}} {
+		err = Haplotypes(refFile, queryFile, positions, nil, outFile)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestHaplotypes: expected a usage error for %v, got %v", positions, err)
+		}
+	}
+}
+
+func TestCombinations(t *testing.T) {
+	dir := t.TempDir()
+	refFile, queryFile := writeTestFiles(t, dir)
+	outFile := filepath.Join(dir, "combinations.csv")
+
+	err := Combinations(refFile, queryFile, []string{"A1T+C2G", "g7-"}, nil, outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "combination,all,some,none,missing,frequency\n" +
+		"A1T+C2G,2,1,1,1,0.5000\n" +
+		"G7-,1,0,4,0,0.2000\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestCombinations: got\n%s\nwant\n%s", out, expected)
+	}
+
+	// the reference has C at 2, not A
+	err = Combinations(refFile, queryFile, []string{"A1T+A2G"}, nil, outFile)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestCombinations: expected a usage error for the wrong reference base, got %v", err)
+	}
+}
@@ -0,0 +1,420 @@
+/*
+Package liftover moves data between the coordinates of two versions of a
+reference sequence, using a pairwise alignment of the old reference (the first
+record in the alignment) and the new one (the second).
+*/
+package liftover
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// coordMap maps 0-based positions between two references
+type coordMap struct {
+	fromID  string
+	toID    string
+	fromSeq []byte // the old reference, ungapped
+	toSeq   []byte // the new reference, ungapped
+	fromTo  []int  // the position in the new reference of each old position, or -1 where it is deleted
+	toFrom  []int  // the position in the old reference of each new position, or -1 where it is inserted
+}
+
+// newCoordMap makes a coordMap from the aligned old (from) and new (to) references
+func newCoordMap(from fastaio.FastaRecord, to fastaio.FastaRecord) (coordMap, error) {
+
+	if len(from.Seq) != len(to.Seq) {
+		return coordMap{}, errors.New("the references in the liftover alignment are different lengths: is it an alignment?")
+	}
+
+	cm := coordMap{fromID: from.ID, toID: to.ID}
+
+	for i := 0; i < len(from.Seq); i++ {
+		f := from.Seq[i] != '-'
+		t := to.Seq[i] != '-'
+		switch {
+		case f && t:
+			cm.fromTo = append(cm.fromTo, len(cm.toSeq))
+			cm.toFrom = append(cm.toFrom, len(cm.fromSeq))
+		case f:
+			cm.fromTo = append(cm.fromTo, -1)
+		case t:
+			cm.toFrom = append(cm.toFrom, -1)
+		}
+		if f {
+			cm.fromSeq = append(cm.fromSeq, from.Seq[i])
+		}
+		if t {
+			cm.toSeq = append(cm.toSeq, to.Seq[i])
+		}
+	}
+
+	return cm, nil
+}
+
+// readCoordMap makes a coordMap from a fasta file with two records: the old
+// reference aligned to the new one
+func readCoordMap(alignmentFile string) (coordMap, error) {
+
+	cErr := make(chan error)
+	cFR := make(chan fastaio.FastaRecord)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(alignmentFile, cFR, cErr, cDone)
+
+	records := make([]fastaio.FastaRecord, 0)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return coordMap{}, err
+		case FR := <-cFR:
+			records = append(records, FR)
+		case <-cDone:
+			n--
+		}
+	}
+
+	if len(records) != 2 {
+		return coordMap{}, fmt.Errorf("the liftover alignment should have two records (the old and new references), not %d", len(records))
+	}
+
+	return newCoordMap(records[0], records[1])
+}
+
+// openIn opens infile for reading, or returns stdin if infile == "stdin"
+func openIn(infile string) (*os.File, error) {
+	if infile != "stdin" {
+		return os.Open(infile)
+	}
+	return os.Stdin, nil
+}
+
+// liftSeq moves a sequence that is aligned to the old reference onto the new one.
+// Bases that are deleted in the new reference are dropped, and bases that are
+// only in the new reference are N, or - if the sequence is - either side of them
+func (cm coordMap) liftSeq(seq string) (string, error) {
+
+	if len(seq) != len(cm.fromSeq) {
+		return "", fmt.Errorf("sequence is length %d but the old reference (%s) is length %d", len(seq), cm.fromID, len(cm.fromSeq))
+	}
+
+	lifted := make([]byte, len(cm.toFrom))
+
+	// first pass: copy the mapped bases, and the base to the left of each insertion
+	left := byte('-')
+	for j, i := range cm.toFrom {
+		if i >= 0 {
+			left = seq[i]
+		}
+		lifted[j] = left
+	}
+
+	// second pass: fill in the insertions, now that we know what is to their right
+	right := byte('-')
+	for j := len(lifted) - 1; j >= 0; j-- {
+		if cm.toFrom[j] >= 0 {
+			right = lifted[j]
+			continue
+		}
+		if lifted[j] != '-' || right != '-' {
+			lifted[j] = 'N'
+		}
+	}
+
+	return string(lifted), nil
+}
+
+// Fasta moves an alignment from the old reference's coordinates to the new
+// reference's (see liftSeq). Lifted sequences that don't pass the missing data
+// filter (see fastaio.Filter) are dropped
+func Fasta(alignmentFile string, infile string, outfile string, minCompleteness float64, maxN int, rejectsFile string) error {
+
+	cm, err := readCoordMap(alignmentFile)
+	if err != nil {
+		return err
+	}
+
+	flt, err := fastaio.NewFilter(minCompleteness, maxN, rejectsFile)
+	if err != nil {
+		return err
+	}
+	defer flt.Close()
+
+	f, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	cErr := make(chan error)
+	cFR := make(chan fastaio.FastaRecord)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			summary.Add(summary.Processed, 1)
+			seq, err := cm.liftSeq(FR.Seq)
+			if err != nil {
+				return fmt.Errorf("%s: %v", FR.ID, err)
+			}
+			keep, err := flt.Keep(fastaio.FastaRecord{ID: FR.ID, Seq: seq})
+			if err != nil {
+				return err
+			}
+			if !keep {
+				continue
+			}
+			_, err = w.WriteString(">" + FR.Description + "\n" + seq + "\n")
+			if err != nil {
+				return err
+			}
+		case <-cDone:
+			n--
+		}
+	}
+
+	return w.Flush()
+}
+
+// liftBed moves the intervals in a BED file onto the new reference. Each interval
+// becomes the smallest interval that contains all of its bases that are still in
+// the new reference (so any insertions inside it are included), and intervals that
+// are entirely deleted are dropped with a warning. Header lines and any columns
+// after the third are passed through unchanged.
+func (cm coordMap) liftBed(r io.Reader, w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+
+	s := bufio.NewScanner(r)
+
+	lineNumber := 0
+
+	for s.Scan() {
+		line := s.Text()
+		lineNumber++
+
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			bw.WriteString(line + "\n")
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			fields = strings.Fields(line)
+		}
+		if len(fields) < 3 {
+			return fmt.Errorf("couldn't parse BED line %d: %s", lineNumber, line)
+		}
+
+		if fields[0] != cm.fromID {
+			return fmt.Errorf("BED line %d is on %s, but the old reference is %s", lineNumber, fields[0], cm.fromID)
+		}
+
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("couldn't parse BED line %d: %s", lineNumber, line)
+		}
+		end, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return fmt.Errorf("couldn't parse BED line %d: %s", lineNumber, line)
+		}
+		if start < 0 || end < start || end > len(cm.fromTo) {
+			return fmt.Errorf("BED line %d is outside the old reference (length %d): %s", lineNumber, len(cm.fromTo), line)
+		}
+
+		newStart, newEnd := -1, -1
+		for i := start; i < end; i++ {
+			if j := cm.fromTo[i]; j >= 0 {
+				if newStart == -1 {
+					newStart = j
+				}
+				newEnd = j + 1
+			}
+		}
+
+		if newStart == -1 {
+			fmt.Fprintf(os.Stderr, "BED line %d (%s:%d-%d) is deleted in %s, skipping it\n", lineNumber, fields[0], start, end, cm.toID)
+			continue
+		}
+
+		fields[0] = cm.toID
+		fields[1] = strconv.Itoa(newStart)
+		fields[2] = strconv.Itoa(newEnd)
+
+		_, err = bw.WriteString(strings.Join(fields, "\t") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.Err()
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// Bed moves the intervals in a BED file (e.g. a mask) from the old reference's
+// coordinates to the new reference's (see liftBed)
+func Bed(alignmentFile string, infile string, outfile string) error {
+
+	cm, err := readCoordMap(alignmentFile)
+	if err != nil {
+		return err
+	}
+
+	in, err := openIn(infile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = out.WriteString("##" + version.Provenance() + "\n")
+	if err != nil {
+		return err
+	}
+
+	return cm.liftBed(in, out)
+}
+
+// liftSNP moves one SNP (e.g. C241T) onto the new reference. It returns the empty
+// string if the SNP's position is deleted in the new reference, or if the new
+// reference has the SNP's alternative allele (so that it is no longer a SNP)
+func (cm coordMap) liftSNP(snp string) (string, error) {
+
+	if len(snp) < 3 {
+		return "", errors.New("couldn't parse SNP: " + snp)
+	}
+
+	ref := snp[0]
+	alt := snp[len(snp)-1]
+
+	pos, err := strconv.Atoi(snp[1 : len(snp)-1])
+	if err != nil {
+		return "", errors.New("couldn't parse SNP: " + snp)
+	}
+	if pos < 1 || pos > len(cm.fromSeq) {
+		return "", fmt.Errorf("SNP %s is outside the old reference (length %d)", snp, len(cm.fromSeq))
+	}
+	if cm.fromSeq[pos-1] != ref {
+		return "", fmt.Errorf("SNP %s doesn't match the old reference (%s), which has %c at %d", snp, cm.fromID, cm.fromSeq[pos-1], pos)
+	}
+
+	newPos := cm.fromTo[pos-1]
+	if newPos == -1 || cm.toSeq[newPos] == alt {
+		return "", nil
+	}
+
+	return string(cm.toSeq[newPos]) + strconv.Itoa(newPos+1) + string(alt), nil
+}
+
+// liftSNPs moves the SNPs in a gofasta snps csv file onto the new reference (see
+// liftSNP). SNPs that are deleted in the new reference are dropped with a warning.
+func (cm coordMap) liftSNPs(r io.Reader, w io.Writer) error {
+
+	bw := bufio.NewWriter(w)
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	lineNumber := 0
+
+	for s.Scan() {
+		line := s.Text()
+		lineNumber++
+
+		if lineNumber == 1 {
+			if line != "query,SNPs" {
+				return errors.New("the snps file should have the header query,SNPs")
+			}
+			bw.WriteString(line + "\n")
+			continue
+		}
+
+		comma := strings.LastIndexByte(line, ',')
+		if comma == -1 {
+			return fmt.Errorf("couldn't parse snps file line %d: %s", lineNumber, line)
+		}
+
+		query := line[:comma]
+
+		lifted := make([]string, 0)
+		if comma+1 < len(line) {
+			for _, snp := range strings.Split(line[comma+1:], "|") {
+				newSNP, err := cm.liftSNP(snp)
+				if err != nil {
+					return fmt.Errorf("snps file line %d: %v", lineNumber, err)
+				}
+				if len(newSNP) > 0 {
+					lifted = append(lifted, newSNP)
+				} else if pos, _ := strconv.Atoi(snp[1 : len(snp)-1]); cm.fromTo[pos-1] == -1 {
+					fmt.Fprintf(os.Stderr, "%s: %s is deleted in %s, skipping it\n", query, snp, cm.toID)
+				}
+			}
+		}
+
+		_, err := bw.WriteString(query + "," + strings.Join(lifted, "|") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.Err()
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// SNPs moves the SNPs in a gofasta snps csv file from the old reference's
+// coordinates to the new reference's. Only the listed SNPs are moved: sites where
+// the two references differ, but the query matched the old reference, aren't
+// added, because the file doesn't say whether the query had coverage there. Lift
+// the alignment over with Fasta and call the SNPs again if you need those.
+func SNPs(alignmentFile string, infile string, outfile string) error {
+
+	cm, err := readCoordMap(alignmentFile)
+	if err != nil {
+		return err
+	}
+
+	in, err := openIn(infile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := cliio.OpenOut(outfile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return cm.liftSNPs(in, out)
+}
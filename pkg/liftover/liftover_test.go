@@ -0,0 +1,124 @@
+package liftover
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+)
+
+// old:  ACGTAC--GTACGT
+// new:  AC-TACTTGTACGA
+func testCoordMap(t *testing.T) coordMap {
+	cm, err := newCoordMap(fastaio.FastaRecord{ID: "old", Seq: "ACGTAC--GTACGT"},
+		fastaio.FastaRecord{ID: "new", Seq: "AC-TACTTGTACGA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cm
+}
+
+func TestNewCoordMap(t *testing.T) {
+	cm := testCoordMap(t)
+
+	if string(cm.fromSeq) != "ACGTACGTACGT" || string(cm.toSeq) != "ACTACTTGTACGA" {
+		t.Errorf("problem in TestNewCoordMap: bad sequences %s %s", cm.fromSeq, cm.toSeq)
+	}
+
+	fromTo := []int{0, 1, -1, 2, 3, 4, 7, 8, 9, 10, 11, 12}
+	for i := range fromTo {
+		if cm.fromTo[i] != fromTo[i] {
+			t.Errorf("problem in TestNewCoordMap: fromTo %v, expected %v", cm.fromTo, fromTo)
+			break
+		}
+	}
+
+	toFrom := []int{0, 1, 3, 4, 5, -1, -1, 6, 7, 8, 9, 10, 11}
+	for i := range toFrom {
+		if cm.toFrom[i] != toFrom[i] {
+			t.Errorf("problem in TestNewCoordMap: toFrom %v, expected %v", cm.toFrom, toFrom)
+			break
+		}
+	}
+
+	_, err := newCoordMap(fastaio.FastaRecord{Seq: "ACGT"}, fastaio.FastaRecord{Seq: "ACG"})
+	if err == nil {
+		t.Errorf("problem in TestNewCoordMap: expected an error for unaligned references")
+	}
+}
+
+func TestLiftSeq(t *testing.T) {
+	cm := testCoordMap(t)
+
+	tests := []struct {
+		seq      string
+		expected string
+	}{
+		{"ACGTACGTACGT", "ACTACNNGTACGT"},
+		{"ACCTAAGTACGT", "ACTAANNGTACGT"},
+		{"-----------T", "------------T"},
+		{"ACGTA-GTACGT", "ACTA-NNGTACGT"},
+	}
+
+	for _, test := range tests {
+		lifted, err := cm.liftSeq(test.seq)
+		if err != nil {
+			t.Error(err)
+		}
+		if lifted != test.expected {
+			t.Errorf("problem in TestLiftSeq: %s lifted to %s, expected %s", test.seq, lifted, test.expected)
+		}
+	}
+
+	_, err := cm.liftSeq("ACGT")
+	if err == nil {
+		t.Errorf("problem in TestLiftSeq: expected an error for a sequence of the wrong length")
+	}
+}
+
+func TestLiftBed(t *testing.T) {
+	cm := testCoordMap(t)
+
+	in := "track name=mask\nold\t0\t2\tfirst\nold\t2\t3\tdeleted\nold\t4\t8\nold\t2\t12\n"
+	expected := "track name=mask\nnew\t0\t2\tfirst\nnew\t3\t9\nnew\t2\t13\n"
+
+	var out bytes.Buffer
+	err := cm.liftBed(strings.NewReader(in), &out)
+	if err != nil {
+		t.Error(err)
+	}
+	if out.String() != expected {
+		t.Errorf("problem in TestLiftBed: got\n%s\nexpected\n%s", out.String(), expected)
+	}
+
+	for _, bad := range []string{"other\t0\t2\n", "old\t0\n", "old\t0\t13\n", "old\tx\t2\n"} {
+		err = cm.liftBed(strings.NewReader(bad), &out)
+		if err == nil {
+			t.Errorf("problem in TestLiftBed: expected an error for %q", bad)
+		}
+	}
+}
+
+func TestLiftSNPs(t *testing.T) {
+	cm := testCoordMap(t)
+
+	in := "query,SNPs\nq1,A1G|G3T|C6T|T12A\nq2,\nq3,G7C\n"
+	expected := "query,SNPs\nq1,A1G|C5T\nq2,\nq3,G8C\n"
+
+	var out bytes.Buffer
+	err := cm.liftSNPs(strings.NewReader(in), &out)
+	if err != nil {
+		t.Error(err)
+	}
+	if out.String() != expected {
+		t.Errorf("problem in TestLiftSNPs: got\n%s\nexpected\n%s", out.String(), expected)
+	}
+
+	for _, bad := range []string{"q,SNPs\n", "query,SNPs\nq1,C1T\n", "query,SNPs\nq1,A13T\n", "query,SNPs\nq1,AxT\n"} {
+		err = cm.liftSNPs(strings.NewReader(bad), &out)
+		if err == nil {
+			t.Errorf("problem in TestLiftSNPs: expected an error for %q", bad)
+		}
+	}
+}
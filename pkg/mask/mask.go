@@ -0,0 +1,210 @@
+// Package mask reads sets of alignment columns to ignore (e.g. homoplasic or primer
+// sites) when counting SNPs and distances, so that every command that takes a --mask
+// ignores the same sites in the same way
+package mask
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+)
+
+// Mask is a set of masked alignment columns. A nil *Mask masks nothing, so its methods
+// can be called whether or not a mask was given
+type Mask struct {
+	Path  string
+	sites []bool // indexed by 0-based column, up to the last masked column
+	n     int
+}
+
+// add masks the 0-based columns from start up to (but not including) end
+func (m *Mask) add(start int, end int) {
+	if end > len(m.sites) {
+		sites := make([]bool, end)
+		copy(sites, m.sites)
+		m.sites = sites
+	}
+	for i := start; i < end; i++ {
+		if !m.sites[i] {
+			m.sites[i] = true
+			m.n++
+		}
+	}
+}
+
+// parseRange parses a 1-based position ("265") or inclusive range ("265-300") into
+// 0-based, end-exclusive coordinates
+func parseRange(s string) (int, int, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("couldn't parse %s as a position or range", s)
+	}
+	start, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("couldn't parse %s as a position or range", s)
+	}
+	end := start
+	if len(parts) == 2 {
+		end, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("couldn't parse %s as a position or range", s)
+		}
+	}
+	if start < 1 || end < start {
+		return 0, 0, fmt.Errorf("%s isn't a valid (1-based) position or range", s)
+	}
+	return start - 1, end, nil
+}
+
+// Load reads a mask from a file, which is either in BED format (0-based, end-exclusive
+// intervals in the second and third columns; the first column isn't checked) or a list of
+// 1-based positions or inclusive ranges (e.g. 265 or 265-300), separated by commas or
+// whitespace. Blank lines, and lines starting with #, track or browser, are skipped. If the
+// alignment length is known, masked columns past the end of it are an error (see Check).
+// An empty path gives a nil *Mask. The number of masked sites is added to the run summary
+func Load(path string, length int) (*Mask, error) {
+
+	m, err := Read(path, length)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.Add(summary.MaskedSites, m.Len())
+
+	return m, nil
+}
+
+// Read is Load, without adding to the run summary, for a file of columns that are used for
+// something other than masking (e.g. the columns that gofasta columns extracts)
+func Read(path string, length int) (*Mask, error) {
+
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &Mask{Path: path, sites: make([]bool, 0)}
+
+	s := bufio.NewScanner(f)
+	lineNumber := 0
+
+	for s.Scan() {
+		line := s.Text()
+		lineNumber++
+
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		// a BED line has a chromosome, then the start and end
+		if len(fields) >= 3 {
+			start, err1 := strconv.Atoi(fields[1])
+			end, err2 := strconv.Atoi(fields[2])
+			if err1 != nil || err2 != nil || start < 0 || end < start {
+				return nil, fmt.Errorf("couldn't parse line %d of the mask as BED: %s", lineNumber, line)
+			}
+			m.add(start, end)
+			continue
+		}
+
+		for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			start, end, err := parseRange(field)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of the mask: %v", lineNumber, err)
+			}
+			m.add(start, end)
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if length >= 0 {
+		err = m.Check(length)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Parse makes a set of columns from 1-based positions or inclusive ranges (e.g. 265 or
+// 265-300), as given on the command line. No ranges give a nil *Mask
+func Parse(ranges []string) (*Mask, error) {
+
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+
+	m := &Mask{sites: make([]bool, 0)}
+
+	for _, r := range ranges {
+		start, end, err := parseRange(strings.TrimSpace(r))
+		if err != nil {
+			return nil, err
+		}
+		m.add(start, end)
+	}
+
+	return m, nil
+}
+
+// Check makes sure that the mask doesn't go past the end of a length-long alignment
+func (m *Mask) Check(length int) error {
+	if m == nil {
+		return nil
+	}
+	if len(m.sites) > length && len(m.Path) == 0 {
+		return fmt.Errorf("the positions go up to %d, but the alignment is only %d long", len(m.sites), length)
+	}
+	if len(m.sites) > length {
+		return fmt.Errorf("the mask (%s) goes up to position %d, but the alignment is only %d long", m.Path, len(m.sites), length)
+	}
+	return nil
+}
+
+// Len is the number of masked columns
+func (m *Mask) Len() int {
+	if m == nil {
+		return 0
+	}
+	return m.n
+}
+
+// Masked reports whether the (0-based) column i is masked
+func (m *Mask) Masked(i int) bool {
+	return m != nil && i < len(m.sites) && m.sites[i]
+}
+
+// ApplyEncoded replaces every masked column of an encoded sequence (see the encoding
+// package) with an N, which matches every nucleotide
+func (m *Mask) ApplyEncoded(seq []byte) {
+	m.Fill(seq, 240)
+}
+
+// Fill replaces every masked column of seq with c, e.g. the encoded unknown residue of an
+// alphabet (see alphabet.Alphabet.EncodedUnknown)
+func (m *Mask) Fill(seq []byte, c byte) {
+	if m == nil {
+		return
+	}
+	for i, masked := range m.sites {
+		if masked && i < len(seq) {
+			seq[i] = c
+		}
+	}
+}
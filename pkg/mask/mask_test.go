@@ -0,0 +1,101 @@
+package mask
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+)
+
+func writeMask(t *testing.T, dir string, contents string) string {
+	path := filepath.Join(dir, "mask")
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		contents string
+		expected []int // 0-based
+	}{
+		{"track name=mask\nref\t2\t4\tprimer\nref\t8\t9\n", []int{2, 3, 8}},
+		{"# homoplasies\n3\n5-7,10\n", []int{2, 4, 5, 6, 9}},
+		{"3 4\n", []int{2, 3}},
+		{testutil.CRLF("track name=mask\nref\t2\t4\tprimer\nref\t8\t9\n"), []int{2, 3, 8}},
+		{testutil.CRLF("# homoplasies\n3\n5-7,10\n"), []int{2, 4, 5, 6, 9}},
+	}
+
+	for _, test := range tests {
+		m, err := Load(writeMask(t, dir, test.contents), 12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Len() != len(test.expected) {
+			t.Errorf("problem in TestLoad: %q: got %d masked sites, expected %d", test.contents, m.Len(), len(test.expected))
+		}
+		for _, i := range test.expected {
+			if !m.Masked(i) {
+				t.Errorf("problem in TestLoad: %q: site %d isn't masked", test.contents, i)
+			}
+		}
+	}
+
+	_, err = Load(writeMask(t, dir, "ref\t2\t20\n"), 12)
+	if err == nil {
+		t.Errorf("problem in TestLoad: expected an error for a mask past the end of the alignment")
+	}
+
+	_, err = Load(writeMask(t, dir, "0\n"), 12)
+	if err == nil {
+		t.Errorf("problem in TestLoad: expected an error for position 0")
+	}
+
+	m, err := Load("", 12)
+	if err != nil || m != nil || m.Masked(0) || m.Len() != 0 {
+		t.Errorf("problem in TestLoad: an empty path should give no mask")
+	}
+}
+
+func TestApplyEncoded(t *testing.T) {
+	m := &Mask{}
+	m.add(1, 3)
+
+	seq := []byte{136, 72, 40, 24}
+	m.ApplyEncoded(seq)
+
+	if seq[0] != 136 || seq[1] != 240 || seq[2] != 240 || seq[3] != 24 {
+		t.Errorf("problem in TestApplyEncoded: got %v", seq)
+	}
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]string{"3", "5-7", " 10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 12; i++ {
+		expected := i == 2 || (i >= 4 && i <= 6) || i == 9
+		if m.Masked(i) != expected {
+			t.Errorf("problem in TestParse: site %d: got %t, expected %t", i, m.Masked(i), expected)
+		}
+	}
+	if m.Check(9) == nil {
+		t.Errorf("problem in TestParse: expected an error for positions past the end of the alignment")
+	}
+
+	_, err = Parse([]string{"7-5"})
+	if err == nil {
+		t.Errorf("problem in TestParse: expected an error for a backwards range")
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Package merge combines alignments that are in the same coordinate system (e.g. batches of
+sequences that were aligned to the same reference separately) into one, checking that they
+are all the same width, and resolving the samples that are in more than one of them.
+*/
+package merge
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// what to do with a sample that is in the alignments more than once, with different sequences
+const (
+	keepFirst   = "first"
+	keepFewestN = "fewest-n"
+	conflictErr = "error"
+)
+
+// checkDuplicates makes sure that --duplicates is something we know how to do
+func checkDuplicates(duplicates string) error {
+	switch duplicates {
+	case keepFirst, keepFewestN, conflictErr:
+		return nil
+	}
+	return usage.Errorf("unrecognised --duplicates: %s (choose one of: %s, %s, %s)", duplicates, keepFirst, keepFewestN, conflictErr)
+}
+
+// merged is the records of the merged alignment, in the order that their names were first seen
+type merged struct {
+	records    []fastaio.FastaRecord
+	from       []string       // the file that each record came from
+	index      map[string]int // where each name is in records
+	width      int
+	widthOf    string // the file that width came from
+	duplicates int    // records whose sequence was the same as the one that was already kept
+	conflicts  int    // records whose sequence was different
+	replaced   int    // conflicts that were kept instead of the earlier record
+}
+
+func newMerged() *merged {
+	return &merged{index: make(map[string]int), width: -1}
+}
+
+// add adds a record from infile to the merged alignment, resolving it with a record of the
+// same name that is already there according to duplicates
+func (m *merged) add(FR fastaio.FastaRecord, infile string, duplicates string) error {
+
+	switch {
+	case m.width < 0:
+		m.width = len(FR.Seq)
+		m.widthOf = infile
+	case len(FR.Seq) != m.width:
+		return fmt.Errorf("%s in %s is %d long, but the sequences in %s are %d long, so they aren't in the same coordinate system", FR.ID, infile, len(FR.Seq), m.widthOf, m.width)
+	}
+
+	i, ok := m.index[FR.ID]
+	if !ok {
+		m.index[FR.ID] = len(m.records)
+		m.records = append(m.records, FR)
+		m.from = append(m.from, infile)
+		return nil
+	}
+
+	if FR.Seq == m.records[i].Seq {
+		m.duplicates++
+		return nil
+	}
+
+	m.conflicts++
+
+	switch duplicates {
+	case conflictErr:
+		return fmt.Errorf("%s is in %s and %s with different sequences", FR.ID, m.from[i], infile)
+	case keepFewestN:
+		if fastaio.CountN(FR.Seq) < fastaio.CountN(m.records[i].Seq) {
+			fmt.Fprintf(os.Stderr, "warning: %s is in %s and %s with different sequences: keeping the one from %s, which has fewer Ns\n", FR.ID, m.from[i], infile, infile)
+			m.records[i] = FR
+			m.from[i] = infile
+			m.replaced++
+			return nil
+		}
+	}
+	fmt.Fprintf(os.Stderr, "warning: %s is in %s and %s with different sequences: keeping the one from %s\n", FR.ID, m.from[i], infile, m.from[i])
+
+	return nil
+}
+
+// read adds every record in infile to the merged alignment
+func (m *merged) read(infile string, duplicates string) error {
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	for {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			err := m.add(FR, infile, duplicates)
+			if err != nil {
+				return err
+			}
+		case <-cDone:
+			return nil
+		}
+	}
+}
+
+// Merge writes the sequences in the alignments in infiles to outfile as one alignment, in
+// fasta format, in the order that they are first seen. The alignments all have to be the
+// same width. A sample that is in more than one of them (or more than once in one) is only
+// written once: if the sequences are different, duplicates says which one is kept
+// ("first", the first one that is read; "fewest-n", the one with the fewest Ns, or the
+// first of those; or "error", which is an error instead), with a warning
+func Merge(infiles []string, outfile string, duplicates string) error {
+
+	if len(infiles) == 0 {
+		return usage.New("merge needs some alignments to merge, with --infile")
+	}
+
+	err := checkDuplicates(duplicates)
+	if err != nil {
+		return err
+	}
+
+	m := newMerged()
+
+	for _, infile := range infiles {
+		err = m.read(infile, duplicates)
+		if err != nil {
+			return err
+		}
+	}
+
+	var f *os.File
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+
+	for _, FR := range m.records {
+		_, err = w.WriteString(">" + FR.Description + "\n" + FR.Seq + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if m.duplicates+m.conflicts > 0 {
+		fmt.Fprintf(os.Stderr, "%d records were of samples that had already been read: %d with the same sequence, and %d with a different one (%d of which replaced the earlier one)\n",
+			m.duplicates+m.conflicts, m.duplicates, m.conflicts, m.replaced)
+	}
+
+	summary.Add(summary.Processed, len(m.records))
+	summary.Add(summary.Filtered, m.duplicates+m.conflicts)
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package merge
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"a.fasta":      ">s1\nACGT\n>s2 first run\nANNT\n>s3\nAC-T\n",
+		"b.fasta":      ">s2 rerun\nACNT\n>s3\nAC-T\n>s4\nTTTT\n",
+		"wide.fasta":   ">s5\nACGTA\n",
+		"narrow.fasta": ">s1\nACGT\n>s6\nACG\n",
+	}
+	for name, contents := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := filepath.Join(dir, "a.fasta")
+	b := filepath.Join(dir, "b.fasta")
+	outfile := filepath.Join(dir, "out.fasta")
+
+	tests := []struct {
+		infiles    []string
+		duplicates string
+		expected   string
+	}{
+		{[]string{a, b}, "first", ">s1\nACGT\n>s2 first run\nANNT\n>s3\nAC-T\n>s4\nTTTT\n"},
+		{[]string{a, b}, "fewest-n", ">s1\nACGT\n>s2 rerun\nACNT\n>s3\nAC-T\n>s4\nTTTT\n"},
+		{[]string{b, a}, "fewest-n", ">s2 rerun\nACNT\n>s3\nAC-T\n>s4\nTTTT\n>s1\nACGT\n"},
+		// the same sequence twice isn't a conflict
+		{[]string{a, a}, "error", ">s1\nACGT\n>s2 first run\nANNT\n>s3\nAC-T\n"},
+	}
+
+	for _, tt := range tests {
+		err := Merge(tt.infiles, outfile, tt.duplicates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(outfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.expected {
+			t.Errorf("problem in TestMerge: %v with --duplicates %s gave\n%s\nexpected\n%s", tt.infiles, tt.duplicates, out, tt.expected)
+		}
+	}
+
+	errors := []struct {
+		infiles    []string
+		duplicates string
+	}{
+		{[]string{a, b}, "error"},
+		{[]string{a, b}, "last"},
+		{[]string{a, filepath.Join(dir, "wide.fasta")}, "first"},
+		{[]string{filepath.Join(dir, "narrow.fasta")}, "first"},
+		{nil, "first"},
+	}
+
+	for _, tt := range errors {
+		err := Merge(tt.infiles, outfile, tt.duplicates)
+		if err == nil {
+			t.Errorf("problem in TestMerge: expected an error for %v with --duplicates %s", tt.infiles, tt.duplicates)
+		}
+	}
+}
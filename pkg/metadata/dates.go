@@ -0,0 +1,115 @@
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// dateRange is --min-date and --max-date, as yyyymmdd numbers so that they can be compared
+type dateRange struct {
+	col int
+	lo  int
+	hi  int
+}
+
+// parseDate parses an ISO date, which can be incomplete (2020-03, or 2020), into the first and
+// last days it could be, as yyyymmdd numbers
+func parseDate(s string) (int, int, error) {
+
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) > 3 || len(parts[0]) != 4 {
+		return 0, 0, fmt.Errorf("couldn't parse %s as a date (yyyy-mm-dd, yyyy-mm or yyyy)", s)
+	}
+
+	n := make([]int, 0, 3)
+	for _, part := range parts {
+		i, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, 0, fmt.Errorf("couldn't parse %s as a date (yyyy-mm-dd, yyyy-mm or yyyy)", s)
+		}
+		n = append(n, i)
+	}
+
+	switch len(n) {
+	case 1:
+		return n[0]*10000 + 101, n[0]*10000 + 1231, nil
+	case 2:
+		if n[1] < 1 || n[1] > 12 {
+			return 0, 0, fmt.Errorf("%s isn't a valid date", s)
+		}
+		return n[0]*10000 + n[1]*100 + 1, n[0]*10000 + n[1]*100 + 31, nil
+	}
+
+	_, err := time.Parse("2006-1-2", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s isn't a valid date", s)
+	}
+	d := n[0]*10000 + n[1]*100 + n[2]
+	return d, d, nil
+}
+
+// newDateRange makes the range from minDate to maxDate (either of which can be empty, for no
+// limit) of the dates in column
+func newDateRange(column string, minDate string, maxDate string, columns map[string]int) (*dateRange, error) {
+
+	col, ok := columns[column]
+	if !ok {
+		return nil, usage.Errorf("--min-date and --max-date: there is no column called %s in the metadata (see --date-column)", column)
+	}
+
+	dr := &dateRange{col: col, lo: 0, hi: 99999999}
+
+	var err error
+	if len(minDate) > 0 {
+		dr.lo, _, err = parseDate(minDate)
+		if err != nil {
+			return nil, usage.Errorf("--min-date: %v", err)
+		}
+	}
+	if len(maxDate) > 0 {
+		_, dr.hi, err = parseDate(maxDate)
+		if err != nil {
+			return nil, usage.Errorf("--max-date: %v", err)
+		}
+	}
+	if dr.lo > dr.hi {
+		return nil, usage.Errorf("--min-date %s is after --max-date %s", minDate, maxDate)
+	}
+
+	return dr, nil
+}
+
+// contains reports whether a date could be in the range (so an incomplete date is kept if any of
+// the days it could be are), and whether the date could be parsed at all
+func (dr *dateRange) contains(date string) (bool, bool) {
+	lo, hi, err := parseDate(date)
+	if err != nil {
+		return false, false
+	}
+	return hi >= dr.lo && lo <= dr.hi, true
+}
+
+// DateBin is the ISO week (2020-W09) or month (2020-03) of a date, for grouping sequences by
+// time. It is empty if the date is too incomplete (or can't be parsed)
+func DateBin(date string, unit string) string {
+	lo, hi, err := parseDate(date)
+	if err != nil {
+		return ""
+	}
+	switch unit {
+	case "month":
+		if lo/100 == hi/100 {
+			return fmt.Sprintf("%d-%02d", lo/10000, lo/100%100)
+		}
+	case "week":
+		if lo == hi {
+			year, week := time.Date(lo/10000, time.Month(lo/100%100), lo%100, 0, 0, 0, 0, time.UTC).ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}
+	}
+	return ""
+}
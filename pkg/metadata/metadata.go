@@ -0,0 +1,374 @@
+// Package metadata joins a csv file of metadata, and/or fields parsed from the sequence names
+// themselves (see NameParser), to sequences by name, so that commands can filter their queries
+// on it (--where), add its columns to their output tables (--annotate) and say which sequences
+// it doesn't cover, instead of this being done afterwards
+package metadata
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// the names of the counts of sequences that aren't in the metadata, and whose dates can't be parsed
+const (
+	Unmatched = "unmatched_metadata"
+	Undated   = "undated"
+)
+
+// condition is one --where, e.g. lineage==B.1.1.7
+type condition struct {
+	col   int
+	value string
+	equal bool
+}
+
+// Metadata is a metadata table, keyed on its first column, which is the sequence name, with
+// the fields parsed from the names as extra columns. A nil *Metadata keeps every sequence and
+// adds no columns, so its methods can be called whether or not metadata was given. It is safe
+// for concurrent use
+type Metadata struct {
+	Path     string
+	columns  map[string]int
+	rows     map[string][]string // nil if there is no metadata file
+	parser   *NameParser
+	parsed   []string // the fields of parser that are columns, after the file's columns
+	width    int      // the number of columns
+	where    []condition
+	dates    *dateRange
+	annotate []int
+	header   []string
+	template *NameTemplate // for the fasta headers of output sequences, if there is one
+
+	mu        sync.Mutex
+	unmatched map[string]bool
+	undated   map[string]bool // sequences whose dates couldn't be parsed, with --min-date or --max-date
+}
+
+// parseCondition parses a --where, which is column==value or column!=value
+func parseCondition(s string, columns map[string]int) (condition, error) {
+	for _, op := range []string{"==", "!="} {
+		i := strings.Index(s, op)
+		if i == -1 {
+			continue
+		}
+		name := strings.TrimSpace(s[:i])
+		col, ok := columns[name]
+		if !ok {
+			return condition{}, usage.Errorf("--where %s: there is no column called %s in the metadata", s, name)
+		}
+		return condition{col: col, value: strings.TrimSpace(s[i+2:]), equal: op == "=="}, nil
+	}
+	return condition{}, usage.Errorf("couldn't parse --where %s (it should be column==value or column!=value)", s)
+}
+
+// Load reads a metadata csv file, which has a header, and the sequence name in its first column.
+// If p isn't nil, the fields it parses from each name are added as columns (unless the file has a
+// column with the same name). Sequences are kept if they satisfy every condition in where, and
+// their date (in dateColumn) could be from minDate to maxDate (either of which can be empty, for no
+// limit; see parseDate), and the columns in annotate are added to output tables. An empty path and a
+// nil p give a nil *Metadata (and the filters and annotate need one or the other)
+func Load(path string, p *NameParser, where []string, dateColumn string, minDate string, maxDate string, annotate []string) (*Metadata, error) {
+
+	if len(path) == 0 && p == nil {
+		if len(where) > 0 || len(annotate) > 0 || len(minDate) > 0 || len(maxDate) > 0 {
+			return nil, usage.New("--where, --min-date, --max-date and --annotate need --metadata or --name-format")
+		}
+		return nil, nil
+	}
+
+	md := &Metadata{
+		Path:      path,
+		columns:   make(map[string]int),
+		parser:    p,
+		unmatched: make(map[string]bool),
+		undated:   make(map[string]bool),
+	}
+
+	if len(path) > 0 {
+		err := md.read(path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		md.Path = "--name-format " + p.Format
+	}
+
+	if p != nil {
+		for _, field := range p.Fields() {
+			if _, ok := md.columns[field]; !ok {
+				md.columns[field] = md.width
+				md.parsed = append(md.parsed, field)
+				md.width++
+			}
+		}
+	}
+
+	for _, s := range where {
+		c, err := parseCondition(s, md.columns)
+		if err != nil {
+			return nil, err
+		}
+		md.where = append(md.where, c)
+	}
+
+	if len(minDate) > 0 || len(maxDate) > 0 {
+		dr, err := newDateRange(dateColumn, minDate, maxDate, md.columns)
+		if err != nil {
+			return nil, err
+		}
+		md.dates = dr
+	}
+
+	for _, name := range annotate {
+		col, ok := md.columns[name]
+		if !ok {
+			return nil, usage.Errorf("--annotate: there is no column called %s in the metadata", name)
+		}
+		md.annotate = append(md.annotate, col)
+		md.header = append(md.header, name)
+	}
+
+	return md, nil
+}
+
+// read reads the metadata file
+func (md *Metadata) read(path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("couldn't read the header of %s: %v", path, err)
+	}
+	for i, name := range header {
+		md.columns[name] = i
+	}
+	md.width = len(header)
+
+	md.rows = make(map[string][]string)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := md.rows[record[0]]; ok {
+			return fmt.Errorf("%s is in %s more than once", record[0], path)
+		}
+		md.rows[record[0]] = record
+	}
+
+	return nil
+}
+
+// row is the metadata for a sequence, which is noted if there isn't any. With a metadata file,
+// that means the sequence isn't in it, and without one, that its name couldn't be parsed
+func (md *Metadata) row(name string) ([]string, bool) {
+	var row []string
+	ok := true
+	if md.rows != nil {
+		row, ok = md.rows[name]
+	}
+	if md.parser != nil && len(md.parsed) > 0 {
+		fields, parsed := md.parser.Parse(name)
+		if md.rows == nil {
+			ok = parsed
+		}
+		if ok {
+			extended := make([]string, md.width)
+			copy(extended, row)
+			for i, field := range md.parsed {
+				extended[md.width-len(md.parsed)+i] = fields[field]
+			}
+			row = extended
+		}
+	}
+	if !ok {
+		md.mu.Lock()
+		md.unmatched[name] = true
+		md.mu.Unlock()
+	}
+	return row, ok
+}
+
+// HasColumn reports whether the metadata has a column called column
+func (md *Metadata) HasColumn(column string) bool {
+	if md == nil {
+		return false
+	}
+	_, ok := md.columns[column]
+	return ok
+}
+
+// Value is the value of column for a sequence, and whether the sequence is in the metadata
+func (md *Metadata) Value(name string, column string) (string, bool) {
+	if md == nil {
+		return "", false
+	}
+	row, ok := md.row(name)
+	if !ok {
+		return "", false
+	}
+	return row[md.columns[column]], true
+}
+
+// Keep reports whether a sequence satisfies every --where, and is in the date range. A sequence
+// that isn't in the metadata can't, so it is only kept if there aren't any
+func (md *Metadata) Keep(name string) bool {
+	if md == nil {
+		return true
+	}
+	row, ok := md.row(name)
+	if !ok {
+		return len(md.where) == 0 && md.dates == nil
+	}
+	for _, c := range md.where {
+		if (row[c.col] == c.value) != c.equal {
+			return false
+		}
+	}
+	if md.dates != nil {
+		in, dated := md.dates.contains(row[md.dates.col])
+		if !dated {
+			md.mu.Lock()
+			md.undated[name] = true
+			md.mu.Unlock()
+		}
+		return in
+	}
+	return true
+}
+
+// NameField is the {placeholder} in a header template (see SetHeaderTemplate) for the sequence's
+// own name
+const NameField = "name"
+
+// SetHeaderTemplate sets the template for the fasta headers that output sequences are written
+// with, e.g. {name}|{date}|{lineage}, whose {placeholders} are {name} (the sequence's name) and
+// any of the metadata's columns, including the fields parsed from the names
+func (md *Metadata) SetHeaderTemplate(template string) error {
+	if md == nil {
+		return usage.New("--header-template needs --metadata or --name-format")
+	}
+	t, unknown := parseTemplate(template, func(field string) bool { return field == NameField || md.HasColumn(field) })
+	if t == nil {
+		return usage.Errorf("--header-template %s: there is no column called %s in %s", template, unknown, md.Path)
+	}
+	if len(t.fields) == 0 {
+		return usage.Errorf("--header-template %s doesn't use any fields (e.g. {name} or {date})", template)
+	}
+	md.template = t
+	return nil
+}
+
+// Header is the fasta header to write a sequence with: the header template filled in with its
+// name and metadata, or header (the one it already has) if there is no template, or the sequence
+// isn't in the metadata. Fields are normalised as they are by NameTemplate.Format
+func (md *Metadata) Header(name string, header string) string {
+	if md == nil || md.template == nil {
+		return header
+	}
+	row, ok := md.row(name)
+	if !ok {
+		return header
+	}
+	fields := make(map[string]string, len(md.template.fields))
+	for _, field := range md.template.fields {
+		if field == NameField && !md.HasColumn(NameField) {
+			fields[field] = name
+			continue
+		}
+		fields[field] = row[md.columns[field]]
+	}
+	return md.template.Format(fields)
+}
+
+// quote quotes a csv field, if it needs it
+func quote(field string) string {
+	if !strings.ContainsAny(field, ",\"\r\n") {
+		return field
+	}
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
+
+// CSVHeader is the names of the --annotate columns, each preceded by a comma, for adding to
+// the header of a csv output
+func (md *Metadata) CSVHeader() string {
+	if md == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, name := range md.header {
+		b.WriteString("," + quote(name))
+	}
+	return b.String()
+}
+
+// CSVFields is a sequence's values for the --annotate columns, each preceded by a comma, for
+// adding to its row of a csv output. They are empty if it isn't in the metadata
+func (md *Metadata) CSVFields(name string) string {
+	if md == nil || len(md.annotate) == 0 {
+		return ""
+	}
+	row, ok := md.row(name)
+	var b strings.Builder
+	for _, col := range md.annotate {
+		b.WriteString(",")
+		if ok {
+			b.WriteString(quote(row[col]))
+		}
+	}
+	return b.String()
+}
+
+// examples lists (sorted) up to five of a set of names
+func examples(set map[string]bool) string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 5 {
+		names = append(names[:5], "...")
+	}
+	return strings.Join(names, ", ")
+}
+
+// Report writes how many of the sequences that were looked up weren't in the metadata, or had
+// names that couldn't be parsed, and how many had dates that couldn't be parsed (with a few
+// examples), to stderr, and adds the numbers to the run summary
+func (md *Metadata) Report() {
+	if md == nil {
+		return
+	}
+
+	if len(md.unmatched) > 0 {
+		if md.rows == nil {
+			fmt.Fprintf(os.Stderr, "%d sequence names didn't match %s: %s\n", len(md.unmatched), md.Path, examples(md.unmatched))
+		} else {
+			fmt.Fprintf(os.Stderr, "%d sequences weren't in the metadata (%s): %s\n", len(md.unmatched), md.Path, examples(md.unmatched))
+		}
+		summary.Add(Unmatched, len(md.unmatched))
+	}
+
+	if len(md.undated) > 0 {
+		fmt.Fprintf(os.Stderr, "%d sequences didn't have a date that could be parsed, so weren't in the date range: %s\n", len(md.undated), examples(md.undated))
+		summary.Add(Undated, len(md.undated))
+	}
+}
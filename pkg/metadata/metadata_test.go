@@ -0,0 +1,163 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func writeMetadata(t *testing.T, dir string, contents string) string {
+	path := filepath.Join(dir, "metadata.csv")
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeMetadata(t, dir, "name,lineage,country\ns1,B.1.1.7,UK\ns2,B.1.1.7,USA\ns3,B.1.617.2,UK\n")
+
+	tests := []struct {
+		where    []string
+		expected map[string]bool
+	}{
+		{nil, map[string]bool{"s1": true, "s2": true, "s3": true, "s4": true}},
+		{[]string{"lineage==B.1.1.7"}, map[string]bool{"s1": true, "s2": true, "s3": false, "s4": false}},
+		{[]string{"lineage == B.1.1.7", "country!=UK"}, map[string]bool{"s1": false, "s2": true, "s3": false, "s4": false}},
+	}
+
+	for _, test := range tests {
+		md, err := Load(path, nil, test.where, "date", "", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, expected := range test.expected {
+			if md.Keep(name) != expected {
+				t.Errorf("problem in TestKeep: %v: Keep(%s) should be %t", test.where, name, expected)
+			}
+		}
+		if len(md.unmatched) != 1 || !md.unmatched["s4"] {
+			t.Errorf("problem in TestKeep: %v: unmatched is %v", test.where, md.unmatched)
+		}
+	}
+
+	for _, where := range []string{"lineage=B.1.1.7", "clade==20I"} {
+		_, err = Load(path, nil, []string{where}, "date", "", "", nil)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestKeep: expected a usage error for --where %s, got %v", where, err)
+		}
+	}
+
+	md, err := Load("", nil, nil, "date", "", "", nil)
+	if err != nil || md != nil || !md.Keep("s1") || md.CSVHeader() != "" || md.CSVFields("s1") != "" {
+		t.Errorf("problem in TestKeep: an empty path should give no metadata")
+	}
+	_, err = Load("", nil, []string{"lineage==B.1.1.7"}, "date", "", "", nil)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestKeep: expected a usage error for --where without --metadata, got %v", err)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeMetadata(t, dir, "name,lineage,location\ns1,B.1.1.7,\"Cardiff, Wales\"\n")
+
+	md, err := Load(path, nil, nil, "date", "", "", []string{"location", "lineage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if md.CSVHeader() != ",location,lineage" {
+		t.Errorf("problem in TestAnnotate: the header is %q", md.CSVHeader())
+	}
+	if md.CSVFields("s1") != ",\"Cardiff, Wales\",B.1.1.7" {
+		t.Errorf("problem in TestAnnotate: the fields for s1 are %q", md.CSVFields("s1"))
+	}
+	if md.CSVFields("s2") != ",," {
+		t.Errorf("problem in TestAnnotate: the fields for s2 are %q", md.CSVFields("s2"))
+	}
+
+	_, err = Load(path, nil, nil, "date", "", "", []string{"date"})
+	if !usage.Is(err) {
+		t.Errorf("problem in TestAnnotate: expected a usage error for a missing column, got %v", err)
+	}
+}
+
+func TestDates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeMetadata(t, dir, "name,date,collected\ns1,2020-03-01,2020-03\ns2,2020-02-29,2020\ns3,2020-03,\ns4,2020,2021-01-01\ns5,?,2020-04-01\n")
+
+	tests := []struct {
+		column   string
+		min      string
+		max      string
+		expected map[string]bool
+	}{
+		{"date", "2020-03-01", "", map[string]bool{"s1": true, "s2": false, "s3": true, "s4": true, "s5": false}},
+		{"date", "", "2020-02", map[string]bool{"s1": false, "s2": true, "s3": false, "s4": true, "s5": false}},
+		{"date", "2020-03-15", "2020-03-20", map[string]bool{"s1": false, "s2": false, "s3": true, "s4": true, "s5": false}},
+		{"collected", "2021", "", map[string]bool{"s1": false, "s2": false, "s3": false, "s4": true, "s5": false}},
+	}
+
+	for _, test := range tests {
+		md, err := Load(path, nil, nil, test.column, test.min, test.max, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, expected := range test.expected {
+			if md.Keep(name) != expected {
+				t.Errorf("problem in TestDates: %s %s-%s: Keep(%s) should be %t", test.column, test.min, test.max, name, expected)
+			}
+		}
+	}
+
+	for _, dates := range [][3]string{{"date", "2020-13", ""}, {"date", "", "2020-02-30"}, {"date", "2020-03", "2020-02"}, {"sampled", "2020", ""}} {
+		_, err = Load(path, nil, nil, dates[0], dates[1], dates[2], nil)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestDates: expected a usage error for %v, got %v", dates, err)
+		}
+	}
+}
+
+func TestDateBin(t *testing.T) {
+	tests := []struct {
+		date     string
+		unit     string
+		expected string
+	}{
+		{"2020-03-01", "week", "2020-W09"},
+		{"2021-01-01", "week", "2020-W53"},
+		{"2020-03", "week", ""},
+		{"2020-03-01", "month", "2020-03"},
+		{"2020-03", "month", "2020-03"},
+		{"2020", "month", ""},
+		{"?", "month", ""},
+	}
+
+	for _, test := range tests {
+		bin := DateBin(test.date, test.unit)
+		if bin != test.expected {
+			t.Errorf("problem in TestDateBin: %s %s: got %q, expected %q", test.date, test.unit, bin, test.expected)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package metadata
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// GISAIDFormat is the format of GISAID-style sequence names, e.g.
+// hCoV-19/England/MILK-9E05B3/2020|EPI_ISL_601443|2020-09-20
+const GISAIDFormat = "virus/country/id/year|epi|date"
+
+// nameDelimiters are the characters that can separate the fields of a name format
+const nameDelimiters = "/|"
+
+// NameParser splits sequence names into fields according to a format, which is a list of
+// field names separated by any of / and |, e.g. virus/country/id/year|epi|date. A name is
+// parsed if it has the same delimiters in the same order as the format
+type NameParser struct {
+	Format string
+	fields []string
+	re     *regexp.Regexp
+}
+
+// NewNameParser makes a NameParser for format, or for GISAIDFormat if format is "gisaid"
+func NewNameParser(format string) (*NameParser, error) {
+
+	if format == "gisaid" {
+		format = GISAIDFormat
+	}
+
+	p := &NameParser{Format: format}
+	seen := make(map[string]bool)
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+
+	field := ""
+	addField := func() error {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			return usage.Errorf("couldn't parse --name-format %s: every field needs a name", format)
+		}
+		if seen[field] {
+			return usage.Errorf("couldn't parse --name-format %s: %s is in it more than once", format, field)
+		}
+		seen[field] = true
+		p.fields = append(p.fields, field)
+		pattern.WriteString("([^" + regexp.QuoteMeta(nameDelimiters) + "]*)")
+		field = ""
+		return nil
+	}
+
+	for _, r := range format {
+		if strings.ContainsRune(nameDelimiters, r) {
+			err := addField()
+			if err != nil {
+				return nil, err
+			}
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+			continue
+		}
+		field += string(r)
+	}
+	err := addField()
+	if err != nil {
+		return nil, err
+	}
+
+	pattern.WriteString("$")
+	p.re = regexp.MustCompile(pattern.String())
+
+	return p, nil
+}
+
+// Fields is the names of the fields, in the order they are in the format
+func (p *NameParser) Fields() []string {
+	return p.fields
+}
+
+// Parse splits a name into its fields, and reports whether it matched the format
+func (p *NameParser) Parse(name string) (map[string]string, bool) {
+	m := p.re.FindStringSubmatch(name)
+	if m == nil {
+		return nil, false
+	}
+	fields := make(map[string]string, len(p.fields))
+	for i, field := range p.fields {
+		fields[field] = m[i+1]
+	}
+	return fields, true
+}
+
+// NameTemplate makes new sequence names from parsed fields, e.g. {country}/{id}/{year}
+type NameTemplate struct {
+	parts  []string // the literal text around the fields
+	fields []string
+}
+
+var placeholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// parseTemplate splits a template into its literal text and its {placeholders}, and returns the
+// first placeholder that known is false for, if there is one
+func parseTemplate(template string, known func(string) bool) (*NameTemplate, string) {
+	t := &NameTemplate{}
+	last := 0
+	for _, loc := range placeholder.FindAllStringSubmatchIndex(template, -1) {
+		field := template[loc[2]:loc[3]]
+		if !known(field) {
+			return nil, field
+		}
+		t.parts = append(t.parts, template[last:loc[0]])
+		t.fields = append(t.fields, field)
+		last = loc[1]
+	}
+	t.parts = append(t.parts, template[last:])
+	return t, ""
+}
+
+// NewNameTemplate makes a NameTemplate whose {placeholders} must all be fields of p
+func NewNameTemplate(template string, p *NameParser) (*NameTemplate, error) {
+
+	known := make(map[string]bool)
+	for _, field := range p.Fields() {
+		known[field] = true
+	}
+
+	t, unknown := parseTemplate(template, func(field string) bool { return known[field] })
+	if t == nil {
+		return nil, usage.Errorf("--rename %s: %s isn't one of the fields of --name-format %s", template, unknown, p.Format)
+	}
+
+	if len(t.fields) == 0 {
+		return nil, usage.Errorf("--rename %s doesn't use any fields (e.g. {id})", template)
+	}
+
+	return t, nil
+}
+
+// Format fills in the template. Each field is normalised by trimming it and replacing any
+// whitespace inside it with _, so that the new name is one word
+func (t *NameTemplate) Format(fields map[string]string) string {
+	var b strings.Builder
+	for i, field := range t.fields {
+		b.WriteString(t.parts[i])
+		b.WriteString(strings.Join(strings.Fields(fields[field]), "_"))
+	}
+	b.WriteString(t.parts[len(t.parts)-1])
+	return b.String()
+}
@@ -0,0 +1,151 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func TestNameParser(t *testing.T) {
+	p, err := NewNameParser("gisaid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, ok := p.Parse("hCoV-19/England/MILK-9E05B3/2020|EPI_ISL_601443|2020-09-20")
+	expected := map[string]string{"virus": "hCoV-19", "country": "England", "id": "MILK-9E05B3", "year": "2020", "epi": "EPI_ISL_601443", "date": "2020-09-20"}
+	if !ok || !reflect.DeepEqual(fields, expected) {
+		t.Errorf("problem in TestNameParser: got %v", fields)
+	}
+
+	for _, name := range []string{"England/MILK-9E05B3/2020", "hCoV-19/England/MILK-9E05B3/2020|EPI_ISL_601443", "a/b/c/d/e|f|g"} {
+		_, ok = p.Parse(name)
+		if ok {
+			t.Errorf("problem in TestNameParser: %s shouldn't match %s", name, p.Format)
+		}
+	}
+
+	for _, format := range []string{"country//year", "id/id", "country/"} {
+		_, err = NewNameParser(format)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestNameParser: expected a usage error for %s, got %v", format, err)
+		}
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+	p, err := NewNameParser("country/id/year|epi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := NewNameTemplate("{epi}_{country}/{id}", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, _ := p.Parse("Northern Ireland/QEUH-13ADEF/2020|EPI_ISL_1")
+	name := tmpl.Format(fields)
+	if name != "EPI_ISL_1_Northern_Ireland/QEUH-13ADEF" {
+		t.Errorf("problem in TestNameTemplate: got %s", name)
+	}
+
+	for _, template := range []string{"{lineage}", "no fields"} {
+		_, err = NewNameTemplate(template, p)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestNameTemplate: expected a usage error for %s, got %v", template, err)
+		}
+	}
+}
+
+func TestLoadNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := NewNameParser("country/id/year")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := Load("", p, []string{"country==Wales"}, "date", "", "", []string{"year"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !md.Keep("Wales/A/2020") || md.Keep("England/B/2020") || md.Keep("unparseable") {
+		t.Errorf("problem in TestLoadNames: --where on a parsed field")
+	}
+	if md.CSVFields("Wales/A/2020") != ",2020" {
+		t.Errorf("problem in TestLoadNames: the fields for Wales/A/2020 are %q", md.CSVFields("Wales/A/2020"))
+	}
+	if len(md.unmatched) != 1 || !md.unmatched["unparseable"] {
+		t.Errorf("problem in TestLoadNames: unmatched is %v", md.unmatched)
+	}
+
+	// the file's columns take precedence over fields of the same name
+	path := writeMetadata(t, dir, "name,country,lineage\nWales/A/2020,Cymru,B.1.1.7\n")
+	md, err = Load(path, p, []string{"country==Cymru", "year==2020"}, "date", "", "", []string{"lineage", "id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !md.Keep("Wales/A/2020") || md.Keep("Wales/B/2020") {
+		t.Errorf("problem in TestLoadNames: --where on a file column and a parsed field")
+	}
+	if md.CSVFields("Wales/A/2020") != ",B.1.1.7,A" {
+		t.Errorf("problem in TestLoadNames: the fields for Wales/A/2020 are %q", md.CSVFields("Wales/A/2020"))
+	}
+}
+
+func TestHeaderTemplate(t *testing.T) {
+	dir := t.TempDir()
+
+	p, err := NewNameParser("country/id/year")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeMetadata(t, dir, "strain,date,lineage\nWales/A/2020,2020-03-01,B.1.1 7\nEngland/B/2020,2020-04-02,B.1\n")
+	md, err := Load(path, p, nil, "date", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, template := range []string{"{name}|{location}", "no fields"} {
+		err = md.SetHeaderTemplate(template)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestHeaderTemplate: expected a usage error for %s, got %v", template, err)
+		}
+	}
+	var none *Metadata
+	if !usage.Is(none.SetHeaderTemplate("{name}")) {
+		t.Errorf("problem in TestHeaderTemplate: expected a usage error for a template with no metadata")
+	}
+
+	// before there is a template, and for sequences that aren't in the metadata, the header is kept
+	if got := md.Header("Wales/A/2020", "Wales/A/2020 description"); got != "Wales/A/2020 description" {
+		t.Errorf("problem in TestHeaderTemplate: got %s with no template", got)
+	}
+
+	err = md.SetHeaderTemplate("{country}_{id}|{date}|{lineage}|{name}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Wales/A/2020", "Wales_A|2020-03-01|B.1.1_7|Wales/A/2020"},
+		{"England/B/2020", "England_B|2020-04-02|B.1|England/B/2020"},
+		{"Scotland/C/2020", "Scotland/C/2020 original"},
+	}
+	for _, test := range tests {
+		if got := md.Header(test.name, test.name+" original"); got != test.expected {
+			t.Errorf("problem in TestHeaderTemplate: got %s for %s, expected %s", got, test.name, test.expected)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Package names parses sequence names, such as GISAID-style
+hCoV-19/England/MILK-9E05B3/2020|EPI_ISL_601443|2020-09-20 headers, into fields, which it
+writes as a table (which can be used as metadata), or uses to rename the sequences.
+*/
+package names
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+)
+
+// Names parses the whole header of each record in infile according to format (see
+// metadata.NameParser). If rename is empty, a csv table of the name (the first word of the
+// header, as other commands name records) and its fields is written to outfile, with empty
+// fields for names that don't match the format. Otherwise the records are written to outfile in
+// fasta format, renamed according to rename (see metadata.NameTemplate), except for those that
+// don't match, which keep their names
+func Names(infile string, outfile string, format string, rename string) error {
+
+	p, err := metadata.NewNameParser(format)
+	if err != nil {
+		return err
+	}
+
+	var t *metadata.NameTemplate
+	if len(rename) > 0 {
+		t, err = metadata.NewNameTemplate(rename, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	var f *os.File
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+	table := csv.NewWriter(w)
+
+	if t == nil {
+		err = table.Write(append([]string{"name"}, p.Fields()...))
+		if err != nil {
+			return err
+		}
+	}
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	unmatched := make([]string, 0)
+	counter := 0
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			counter++
+			fields, ok := p.Parse(FR.Description)
+			if !ok {
+				unmatched = append(unmatched, FR.Description)
+			}
+			if t == nil {
+				row := []string{FR.ID}
+				for _, field := range p.Fields() {
+					row = append(row, fields[field])
+				}
+				err = table.Write(row)
+			} else {
+				name := FR.ID
+				if ok {
+					name = t.Format(fields)
+				}
+				_, err = w.WriteString(">" + name + "\n" + FR.Seq + "\n")
+			}
+			if err != nil {
+				return err
+			}
+		case <-cDone:
+			n--
+		}
+	}
+
+	if len(unmatched) > 0 {
+		fmt.Fprintf(os.Stderr, "%d sequence names didn't match %s, e.g. %s\n", len(unmatched), p.Format, unmatched[0])
+	}
+	summary.Add(summary.Processed, counter)
+	summary.Add(metadata.Unmatched, len(unmatched))
+
+	table.Flush()
+	err = table.Error()
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
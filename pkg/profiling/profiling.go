@@ -0,0 +1,79 @@
+/*
+Package profiling writes a CPU profile and an execution trace of a run (--profile and --trace), so that
+a performance problem with a big dataset can be reported with a file that shows where the time went. The
+long-running pipelines are marked as trace regions, which go tool trace shows by name, and programs
+that use gofasta as a library can call Start and Region around their own calls in the same way.
+*/
+package profiling
+
+import (
+	"context"
+	"os"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// Start starts writing a CPU profile to cpuFile and an execution trace to traceFile (either of which
+// can be empty, for none). The function that it returns stops them and closes the files, and must be
+// called before the program exits, or the files will be incomplete
+func Start(cpuFile string, traceFile string) (func() error, error) {
+
+	stops := make([]func() error, 0)
+
+	stop := func() error {
+		var err error
+		for i := len(stops) - 1; i >= 0; i-- {
+			serr := stops[i]()
+			if err == nil {
+				err = serr
+			}
+		}
+		return err
+	}
+
+	if len(cpuFile) > 0 {
+		f, err := os.Create(cpuFile)
+		if err != nil {
+			return nil, err
+		}
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			return f.Close()
+		})
+	}
+
+	if len(traceFile) > 0 {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		err = trace.Start(f)
+		if err != nil {
+			f.Close()
+			stop()
+			return nil, err
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return f.Close()
+		})
+	}
+
+	return stop, nil
+}
+
+// Region marks the start of a named stage of a pipeline in the execution trace (if one is being
+// written), and returns the function that marks its end, e.g.
+//
+//	defer profiling.Region("closest")()
+//
+// It must be ended by the goroutine that started it
+func Region(name string) func() {
+	return trace.StartRegion(context.Background(), name).End
+}
@@ -0,0 +1,59 @@
+package profiling
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	dir := t.TempDir()
+	cpuFile := filepath.Join(dir, "cpu.out")
+	traceFile := filepath.Join(dir, "trace.out")
+
+	stop, err := Start(cpuFile, traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := Region("test")
+	n := 0
+	for i := 0; i < 1000000; i++ {
+		n += i % 7
+	}
+	end()
+	err = stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{cpuFile, traceFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 {
+			t.Errorf("problem in TestStart: %s is empty", f)
+		}
+	}
+
+	// with neither file, there is nothing to stop
+	stop, err = Start("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stop()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// if the trace can't be written, the profile is stopped too, so that another can be started
+	_, err = Start(cpuFile, filepath.Join(dir, "missing", "trace.out"))
+	if err == nil {
+		t.Errorf("problem in TestStart: expected an error for a trace in a missing directory")
+	}
+	stop, err = Start(cpuFile, "")
+	if err != nil {
+		t.Fatalf("problem in TestStart: the profile wasn't stopped after an error: %v", err)
+	}
+	stop()
+}
@@ -0,0 +1,284 @@
+/*
+Package proteins translates the queries in an alignment to the reference (or a SAM file) into an
+amino acid alignment for each of the reference's CDSs, with deleted codons as gaps, so that each
+protein can be analysed on its own.
+*/
+package proteins
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/sam"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// protein is one CDS to translate
+type protein struct {
+	name         string         // the name of its output file, without .fasta
+	cols         []int          // the 0-based alignment column of each nucleotide, from /codon_start
+	complemented []bool         // whether each nucleotide is on the reverse strand
+	coding       genbank.Coding // its genetic code and translation exceptions
+}
+
+// translated is a query's translation of every protein
+type translated struct {
+	idx  int
+	id   string
+	seqs []string
+}
+
+// newProtein gets what is needed to translate a CDS
+func newProtein(name string, f genbank.GenbankFeature) (protein, error) {
+	positions, complemented, err := f.Positions()
+	if err != nil {
+		return protein{}, err
+	}
+	coding, err := f.Coding()
+	if err != nil {
+		return protein{}, err
+	}
+	p := protein{name: strings.ReplaceAll(name, " ", "_"), complemented: complemented, coding: coding, cols: make([]int, len(positions))}
+	for i, pos := range positions {
+		p.cols[i] = pos - 1
+	}
+	return p, nil
+}
+
+// findProteins finds the CDSs in gb that are named in genes (by any of their names, see
+// genbank.FindCDS), or all of them if genes is empty. Each is named after its /gene, or its canonical
+// name if that isn't the name it was found by, or, for all of them, as genbank.CDSs names them
+func findProteins(gb genbank.Genbank, genes []string) ([]protein, error) {
+
+	proteins := make([]protein, 0)
+
+	if len(genes) > 0 {
+		for _, gene := range genes {
+			f, ok := gb.FindCDS(gene)
+			if !ok {
+				return nil, usage.Errorf("there is no CDS with /gene=%s in the genbank file", gene)
+			}
+			name := genbank.CanonicalGeneName(gene)
+			if genbank.SameGene(gene, f.Info.Get("gene")) {
+				name = f.Info.Get("gene")
+			}
+			p, err := newProtein(name, f)
+			if err != nil {
+				return nil, err
+			}
+			proteins = append(proteins, p)
+		}
+		return proteins, nil
+	}
+
+	features, names := gb.CDSs()
+	for i, f := range features {
+		p, err := newProtein(names[i], f)
+		if err != nil {
+			return nil, err
+		}
+		proteins = append(proteins, p)
+	}
+
+	if len(proteins) == 0 {
+		return nil, usage.New("there are no CDSs in the genbank file")
+	}
+
+	return proteins, nil
+}
+
+// translate translates a query's copy of the protein. Codons that are past the end of the query
+// are translated as missing data
+func (p protein) translate(seq string, t *alphabet.Translator) string {
+	cds := make([]byte, len(p.cols))
+	for j, col := range p.cols {
+		switch {
+		case col >= len(seq):
+			cds[j] = 'N'
+		case p.complemented[j]:
+			cds[j] = genbank.Complement(seq[col])
+		default:
+			cds[j] = seq[col]
+		}
+	}
+	return string(p.coding.Translator(t).Translate(cds))
+}
+
+// translateQueries translates every protein in each query it is sent
+func translateQueries(proteins []protein, policy alphabet.CodonPolicy, cFR chan fastaio.FastaRecord, cOut chan translated) {
+	t := alphabet.NewTranslator(policy)
+	for FR := range cFR {
+		tr := translated{idx: FR.Idx, id: FR.ID, seqs: make([]string, len(proteins))}
+		seq := strings.ToUpper(FR.Seq)
+		for i, p := range proteins {
+			tr.seqs[i] = p.translate(seq, t)
+		}
+		cOut <- tr
+	}
+}
+
+// writeProteins writes each query's proteins to their files in outdir, in the order of the queries
+func writeProteins(outdir string, proteins []protein, cOut chan translated, cErr chan error, cDone chan bool) {
+
+	files := make([]*os.File, len(proteins))
+	writers := make([]*bufio.Writer, len(proteins))
+	for i, p := range proteins {
+		f, err := os.Create(filepath.Join(outdir, p.name+".fasta"))
+		if err != nil {
+			cErr <- err
+			return
+		}
+		defer f.Close()
+		files[i] = f
+		writers[i] = bufio.NewWriter(f)
+	}
+
+	outputMap := make(map[int]translated)
+	counter := 0
+
+	for tr := range cOut {
+		outputMap[tr.idx] = tr
+		for {
+			next, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			for i, w := range writers {
+				_, err := w.WriteString(">" + next.id + "\n" + next.seqs[i] + "\n")
+				if err != nil {
+					cErr <- err
+					return
+				}
+			}
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	for _, w := range writers {
+		err := w.Flush()
+		if err != nil {
+			cErr <- err
+			return
+		}
+	}
+
+	summary.Add(summary.Processed, counter)
+
+	cDone <- true
+}
+
+// Proteins translates each query, read from queryFile, an alignment to the reference in fasta
+// format, or from samFile if it isn't empty, into every CDS in genbankFile (or the ones named in
+// genes), and writes one alignment per CDS to outdir (which is made if it doesn't exist), named
+// after the CDS, e.g. S.fasta. Deleted codons are -, partly deleted ones are translated according to
+// policy (see alphabet.CodonPolicy), and ones with ambiguity codes are X unless every codon they could
+// be is the same amino acid. The queries are translated by threads workers (or one per CPU if threads == 0)
+func Proteins(queryFile string, samFile string, genbankFile string, genes []string, policy alphabet.CodonPolicy, outdir string, threads int) error {
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	if len(genbankFile) == 0 {
+		return usage.New("proteins needs the reference's annotation (--genbank)")
+	}
+	if len(outdir) == 0 {
+		return usage.New("proteins needs a directory to write the alignments to (--outdir)")
+	}
+
+	gb, err := genbank.Load(genbankFile)
+	if err != nil {
+		return err
+	}
+
+	ps, err := findProteins(gb, genes)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(outdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	cFR := make(chan fastaio.FastaRecord, threads)
+	cIndexed := make(chan fastaio.FastaRecord, threads)
+	cOut := make(chan translated, threads)
+	cErr := make(chan error)
+	cReadDone := make(chan bool)
+	cWriteDone := make(chan bool)
+
+	if len(samFile) > 0 {
+		go sam.ReadAligned(samFile, 0, false, "skip", threads, cFR, cErr, cReadDone)
+	} else {
+		go fastaio.ReadAlignment(queryFile, cFR, cErr, cReadDone)
+	}
+
+	go writeProteins(outdir, ps, cOut, cErr, cWriteDone)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+	for n := 0; n < threads; n++ {
+		go func() {
+			translateQueries(ps, policy, cIndexed, cOut)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(cOut)
+	}()
+
+	// fasta records aren't numbered as they are read, SAM records are
+	counter := 0
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			if len(samFile) == 0 {
+				FR.Idx = counter
+				counter++
+			}
+			select {
+			case cIndexed <- FR:
+			case err := <-cErr:
+				return err
+			}
+		case <-cReadDone:
+			n--
+		}
+	}
+
+	// anything that was sent before cReadDone
+	for len(cFR) > 0 {
+		FR := <-cFR
+		if len(samFile) == 0 {
+			FR.Idx = counter
+			counter++
+		}
+		cIndexed <- FR
+	}
+	close(cIndexed)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cWriteDone:
+			n--
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package proteins
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+var testGenbank = `LOCUS       test                      24 bp    DNA     linear   VRL 01-JAN-2021
+FEATURES             Location/Qualifiers
+     CDS             1..18
+                     /gene="g1"
+     CDS             complement(19..24)
+                     /gene="g2"
+ORIGIN
+        1 atgaaacccg ggttttaaac gtac
+//
+`
+
+func TestProteins(t *testing.T) {
+	dir := t.TempDir()
+
+	genbankFile := filepath.Join(dir, "test.gb")
+	err := ioutil.WriteFile(genbankFile, []byte(testGenbank), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// q1 has K2E, a deleted fourth codon and (on the reverse strand) V1A, q2's R could be K or E
+	// and its fourth codon is partly deleted, and q3's R can only be K
+	queryFile := filepath.Join(dir, "query.fasta")
+	err = ioutil.WriteFile(queryFile, []byte(">q1\nATGGAACCC---TTTTAAACGTGC\n>q2\nATGRAACCCGG-TTTTAAACGTAC\n>q3\nATGAARCCCGGGTTTTAAACGTAC\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outdir := filepath.Join(dir, "proteins")
+
+	err = Proteins(queryFile, "", genbankFile, nil, alphabet.CodonX, outdir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"g1.fasta": ">q1\nMEP-F*\n>q2\nMXPXF*\n>q3\nMKPGF*\n",
+		"g2.fasta": ">q1\nAR\n>q2\nVR\n>q3\nVR\n",
+	}
+	for name, want := range expected {
+		got, err := ioutil.ReadFile(filepath.Join(outdir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("problem in TestProteins: %s: got %q, want %q", name, got, want)
+		}
+	}
+
+	// only the genes that are asked for
+	outdir = filepath.Join(dir, "g2")
+	err = Proteins(queryFile, "", genbankFile, []string{"G2"}, alphabet.CodonX, outdir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(outdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "g2.fasta" {
+		t.Errorf("problem in TestProteins: expected only g2.fasta in %s", outdir)
+	}
+
+	err = Proteins(queryFile, "", genbankFile, []string{"g3"}, alphabet.CodonX, outdir, 1)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestProteins: expected a usage error for a gene that isn't in the genbank file, got %v", err)
+	}
+}
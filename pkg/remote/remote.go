@@ -0,0 +1,262 @@
+// Package remote opens input files that are given as https:// (or http://) or s3:// URLs,
+// as well as local paths, so that gofasta can read from object storage without the files
+// being downloaded first. Remote files are read with HTTP range requests, so seeking in them
+// (e.g. to resume from a checkpoint) and reading a few records using an index only fetch the
+// bytes that are needed
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// File is an open input file: an *os.File, or a remote file
+type File interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// client is the HTTP client that remote files are read with. It has no timeout, since
+// reading a large file can take a long time
+var client = &http.Client{}
+
+// IsURL is true if path is an https://, http:// or s3:// URL rather than a local path
+func IsURL(path string) bool {
+	for _, scheme := range []string{"https://", "http://", "s3://"} {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open opens a local file, or a remote file if path is a URL (see IsURL). Nothing is
+// read from a remote file until it is
+func Open(path string) (File, error) {
+	if !IsURL(path) {
+		return os.Open(path)
+	}
+	u, err := resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return &httpFile{path: path, url: u, size: -1}, nil
+}
+
+// Size is the size of a local or remote file, in bytes
+func Size(path string) (int64, error) {
+	if !IsURL(path) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	f, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	return f.Seek(0, io.SeekEnd)
+}
+
+// Exists is true if a local or remote file exists (and can be read, if it is remote)
+func Exists(path string) bool {
+	if !IsURL(path) {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	_, err := Size(path)
+	return err == nil
+}
+
+// resolve is the https:// URL that a file is read from, which for an s3:// URL depends on the
+// environment (see s3Endpoint)
+func resolve(path string) (string, error) {
+	if !strings.HasPrefix(path, "s3://") {
+		return path, nil
+	}
+	bucket, key, err := parseS3(path)
+	if err != nil {
+		return "", err
+	}
+	return s3Endpoint(bucket, key), nil
+}
+
+// httpFile is a remote file. Reads from it are streamed from a single ranged request,
+// starting at offset, which is made again if the file is seeked
+type httpFile struct {
+	path   string // the URL that the file was opened with (which can be s3://)
+	url    string
+	offset int64
+	size   int64 // -1 until it is known
+	body   io.ReadCloser
+}
+
+// get makes a GET request for the bytes of the file from start up to end (exclusive), or up to
+// the end of the file if end is -1
+func (f *httpFile) get(start int64, end int64) (*http.Response, error) {
+
+	req, err := http.NewRequest("GET", f.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case end >= 0:
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end-1, 10))
+	case start > 0:
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-")
+	}
+
+	if strings.HasPrefix(f.path, "s3://") {
+		err = signS3(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %v", f.path, err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, io.EOF
+	}
+
+	resp.Body.Close()
+	return nil, fmt.Errorf("couldn't read %s: %s", f.path, resp.Status)
+}
+
+// Read reads the next bytes of the file
+func (f *httpFile) Read(p []byte) (int, error) {
+
+	if f.body == nil {
+		resp, err := f.get(f.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		// a server that doesn't do range requests sends the whole file
+		if resp.StatusCode == http.StatusOK && f.offset > 0 {
+			_, err = io.CopyN(ioutil.Discard, resp.Body, f.offset)
+			if err != nil {
+				resp.Body.Close()
+				return 0, err
+			}
+		}
+		f.body = resp.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// Seek sets where the next Read reads from. Seeking from the end finds the size of the file
+// with a HEAD request
+func (f *httpFile) Seek(offset int64, whence int) (int64, error) {
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		size, err := f.getSize()
+		if err != nil {
+			return 0, err
+		}
+		offset += size
+	}
+	if offset < 0 {
+		return 0, errors.New("can't seek to before the start of " + f.path)
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+// getSize finds the size of the file
+func (f *httpFile) getSize() (int64, error) {
+
+	if f.size >= 0 {
+		return f.size, nil
+	}
+
+	req, err := http.NewRequest("HEAD", f.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(f.path, "s3://") {
+		err = signS3(req)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't read %s: %v", f.path, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("couldn't read %s: %s", f.path, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("couldn't find the size of %s", f.path)
+	}
+
+	f.size = resp.ContentLength
+	return f.size, nil
+}
+
+// ReadAt reads len(p) bytes from off, with one range request, without changing where the
+// next Read reads from
+func (f *httpFile) ReadAt(p []byte, off int64) (int, error) {
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	resp, err := f.get(off, off+int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && off > 0 {
+		_, err = io.CopyN(ioutil.Discard, resp.Body, off)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := io.ReadFull(resp.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close closes the response that is being read from, if there is one
+func (f *httpFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
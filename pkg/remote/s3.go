@@ -0,0 +1,138 @@
+package remote
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptyHash is the SHA-256 of an empty request body
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// now is the time that S3 requests are signed at (a variable so that it can be fixed in tests)
+var now = time.Now
+
+// parseS3 splits an s3://bucket/key URL into its bucket and key
+func parseS3(path string) (string, string, error) {
+	rest := strings.TrimPrefix(path, "s3://")
+	i := strings.Index(rest, "/")
+	if i < 1 || i == len(rest)-1 {
+		return "", "", errors.New("badly formatted S3 URL (it should be s3://bucket/key): " + path)
+	}
+	return rest[:i], rest[i+1:], nil
+}
+
+// s3Region is the region that S3 requests are made to and signed for: $AWS_REGION,
+// $AWS_DEFAULT_REGION, or us-east-1
+func s3Region() string {
+	for _, v := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(v); len(region) > 0 {
+			return region
+		}
+	}
+	return "us-east-1"
+}
+
+// s3Endpoint is the https:// URL of an object in S3. If $AWS_ENDPOINT_URL is set (e.g. for an
+// S3-compatible store like MinIO), the object is requested from there, path-style
+func s3Endpoint(bucket string, key string) string {
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); len(endpoint) > 0 {
+		return strings.TrimRight(endpoint, "/") + "/" + bucket + "/" + escapePath(key)
+	}
+	return "https://" + bucket + ".s3." + s3Region() + ".amazonaws.com/" + escapePath(key)
+}
+
+// escapePath URI-encodes each segment of a key the way that S3 signing needs it to be
+func escapePath(key string) string {
+	const unreserved = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_.~/"
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if strings.IndexByte(unreserved, c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signS3 signs a request (which has no body) with AWS Signature Version 4, using the credentials
+// in $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and (optionally) $AWS_SESSION_TOKEN. Without
+// credentials, the request is left unsigned, which is enough for public buckets
+func signS3(req *http.Request) error {
+
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if len(accessKey) == 0 || len(secretKey) == 0 {
+		return nil
+	}
+
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+	region := s3Region()
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyHash)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); len(token) > 0 {
+		req.Header.Set("x-amz-security-token", token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package sam
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// ReadAligned reads the queries in a SAM file (or stdin, if infile is empty) as sequences aligned to
+// the reference, without insertions (as toMultiAlign writes them, untrimmed), so that commands that
+// work on an alignment can read SAM instead. The records are sent to cFR as they are made, not in
+// order, with their Idx set to the query's index in the SAM file, by threads workers (or one per CPU
+// if threads <= 0)
+func ReadAligned(infile string, minQual int, skipCorrupt bool, missingSeq string, threads int, cFR chan fastaio.FastaRecord, cErr chan error, cDone chan bool) {
+
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	err := checkMissingSeq(missingSeq)
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	cSR := make(chan samRecords, threads)
+	cSH := make(chan biogosam.Header)
+	cReadDone := make(chan bool)
+
+	go groupSamRecords(infile, minQual, skipCorrupt, missingSeq, cSH, cSR, cReadDone, cErr)
+
+	header := <-cSH
+
+	// without an @SQ line, the reference's length is inferred from the records
+	refLen := 0
+	if len(header.Refs()) > 0 {
+		refLen = header.Refs()[0].Len()
+	} else {
+		refLen, err = inferRefLen(infile, cSR, cReadDone, cErr)
+		if err != nil {
+			cErr <- err
+			return
+		}
+	}
+	if refLen == 0 {
+		cDone <- true
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+	for n := 0; n < threads; n++ {
+		go func() {
+			blockToFastaRecord(cSR, cFR, nil, cErr, refLen, nil, false, 0, false, false, -1, -1, false, "letters", 0, 1, false, nil)
+			wg.Done()
+		}()
+	}
+
+	<-cReadDone
+	close(cSR)
+	close(cSH)
+
+	wg.Wait()
+
+	cDone <- true
+}
@@ -0,0 +1,112 @@
+package sam
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+)
+
+// writeBenchmarkFiles writes a synthetic reference (as fasta and genbank) and a SAM
+// file of n queries aligned to it to a temporary directory, and returns their paths
+func writeBenchmarkFiles(b *testing.B, refLen int, n int, readLen int) (string, string, string) {
+
+	dir := b.TempDir()
+	r := rand.New(rand.NewSource(1))
+
+	ref := testutil.RandomSeq(r, refLen)
+
+	write := func(name string, w func(f *os.File) error) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer f.Close()
+		err = w(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return path
+	}
+
+	// the genbank file has to be written first, because it puts ORFs into ref
+	gbFile := write("ref.gb", func(f *os.File) error {
+		return testutil.WriteGenbank(f, "ref", ref, 10)
+	})
+	refFile := write("ref.fasta", func(f *os.File) error {
+		return testutil.WriteFasta(f, []testutil.Sequence{{ID: "ref", Seq: ref}})
+	})
+	samFile := write("aligned.sam", func(f *os.File) error {
+		return testutil.WriteSam(f, r, "ref", ref, n, readLen)
+	})
+
+	return samFile, refFile, gbFile
+}
+
+func BenchmarkToMultiAlign(b *testing.B) {
+	samFile, refFile, _ := writeBenchmarkFiles(b, 30000, 2000, 1000)
+	outFile := filepath.Join(b.TempDir(), "aligned.fasta")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		opts := DefaultToMultiAlignOptions()
+		opts.Threads = 2
+		err := ToMultiAlign(samFile, refFile, outFile, nil, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkToPairAlign(b *testing.B) {
+	samFile, refFile, gbFile := writeBenchmarkFiles(b, 30000, 200, 1000)
+	outPath := b.TempDir()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := ToPairAlign(samFile, refFile, gbFile, "", outPath, false, true, 0, false, "skip", 2)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVariants(b *testing.B) {
+	samFile, refFile, gbFile := writeBenchmarkFiles(b, 30000, 200, 1000)
+	outFile := filepath.Join(b.TempDir(), "variants.csv")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := Variants(samFile, refFile, gbFile, alphabet.CodonX, outFile, 0, false, "skip", 2)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSNPs(b *testing.B) {
+	samFile, _, _ := writeBenchmarkFiles(b, 30000, 2000, 1000)
+	outFile := filepath.Join(b.TempDir(), "snps.csv")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := SNPs(samFile, "", outFile, 0, false, "skip", false, 2)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIndels(b *testing.B) {
+	samFile, _, _ := writeBenchmarkFiles(b, 30000, 2000, 1000)
+	dir := b.TempDir()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		opts := DefaultIndelsOptions()
+		opts.Threads = 2
+		err := Indels([]string{samFile}, filepath.Join(dir, "insertions.txt"), filepath.Join(dir, "deletions.txt"), "", opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package sam
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/remote"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// checkpoint is how far a toMultiAlign run has got: every query before samOffset in the
+// SAM file has been written, at which point the output was outOffset bytes long, and the
+// rejects file rejectsOffset bytes long
+type checkpoint struct {
+	samFile       string
+	samSize       int64
+	samOffset     int64
+	queries       int
+	lastQuery     string
+	outOffset     int64
+	rejectsOffset int64
+	complete      bool
+}
+
+// writeCheckpoint writes a checkpoint as tab-separated keys and values. It is written to a
+// temporary file that is then renamed, so that an interruption can't leave half a checkpoint
+func writeCheckpoint(path string, cp checkpoint) error {
+
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "sam_file\t%s\n", cp.samFile)
+	fmt.Fprintf(w, "sam_size\t%d\n", cp.samSize)
+	fmt.Fprintf(w, "sam_offset\t%d\n", cp.samOffset)
+	fmt.Fprintf(w, "queries\t%d\n", cp.queries)
+	fmt.Fprintf(w, "last_query\t%s\n", cp.lastQuery)
+	fmt.Fprintf(w, "out_offset\t%d\n", cp.outOffset)
+	fmt.Fprintf(w, "rejects_offset\t%d\n", cp.rejectsOffset)
+	fmt.Fprintf(w, "complete\t%t\n", cp.complete)
+
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readCheckpoint reads a checkpoint written by writeCheckpoint
+func readCheckpoint(path string) (checkpoint, error) {
+
+	var cp checkpoint
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cp, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.SplitN(s.Text(), "\t", 2)
+		if len(fields) != 2 {
+			return cp, fmt.Errorf("couldn't parse checkpoint %s: %s", path, s.Text())
+		}
+		var err error
+		switch fields[0] {
+		case "sam_file":
+			cp.samFile = fields[1]
+		case "sam_size":
+			cp.samSize, err = strconv.ParseInt(fields[1], 10, 64)
+		case "sam_offset":
+			cp.samOffset, err = strconv.ParseInt(fields[1], 10, 64)
+		case "queries":
+			cp.queries, err = strconv.Atoi(fields[1])
+		case "last_query":
+			cp.lastQuery = fields[1]
+		case "out_offset":
+			cp.outOffset, err = strconv.ParseInt(fields[1], 10, 64)
+		case "rejects_offset":
+			cp.rejectsOffset, err = strconv.ParseInt(fields[1], 10, 64)
+		case "complete":
+			cp.complete, err = strconv.ParseBool(fields[1])
+		}
+		if err != nil {
+			return cp, fmt.Errorf("couldn't parse checkpoint %s: %s", path, s.Text())
+		}
+	}
+
+	return cp, s.Err()
+}
+
+// blockEnd is where in the SAM file a block of records ended
+type blockEnd struct {
+	offset int64
+	query  string
+}
+
+// checkpointer writes a checkpoint every so many queries as the output of toMultiAlign is
+// written. Workers tell it where each block ends (blockDone), and the writer tells it when
+// each block has been written (written). A nil *checkpointer does nothing, so that its methods
+// can be called whether or not checkpointing was asked for
+type checkpointer struct {
+	path  string
+	every int
+	state checkpoint
+	since int
+
+	mu   sync.Mutex
+	ends map[int]blockEnd
+}
+
+// newCheckpointer makes a checkpointer that writes to path every so many queries (it is nil if path
+// is empty). If resume, and there is already a checkpoint at path for samFile, the run carries on
+// from where it says
+func newCheckpointer(path string, every int, samFile string, resume bool) (*checkpointer, error) {
+
+	if len(path) == 0 {
+		if resume {
+			return nil, usage.New("--resume needs --checkpoint")
+		}
+		return nil, nil
+	}
+
+	if every < 1 {
+		return nil, usage.New("--checkpoint-every should be at least 1")
+	}
+
+	if len(samFile) == 0 {
+		return nil, usage.New("--checkpoint needs a SAM file (--samfile), not stdin")
+	}
+
+	size, err := remote.Size(samFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &checkpointer{
+		path:  path,
+		every: every,
+		state: checkpoint{samFile: samFile, samSize: size},
+		ends:  make(map[int]blockEnd),
+	}
+
+	if !resume {
+		return cp, nil
+	}
+
+	previous, err := readCheckpoint(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if previous.samFile != samFile || previous.samSize != size {
+		return nil, fmt.Errorf("the checkpoint %s is for %s (%d bytes), not %s (%d bytes)", path, previous.samFile, previous.samSize, samFile, size)
+	}
+
+	cp.state = previous
+
+	return cp, nil
+}
+
+// resumed reports whether the run is carrying on from a checkpoint
+func (cp *checkpointer) resumed() bool {
+	return cp != nil && cp.state.samOffset > 0
+}
+
+// complete reports whether the checkpoint is from a run that finished
+func (cp *checkpointer) complete() bool {
+	return cp != nil && cp.state.complete
+}
+
+// samOffset is where in the SAM file to start reading records
+func (cp *checkpointer) samOffset() int64 {
+	if cp == nil {
+		return 0
+	}
+	return cp.state.samOffset
+}
+
+// outOffset is how much of the output to keep
+func (cp *checkpointer) outOffset() int64 {
+	if cp == nil {
+		return 0
+	}
+	return cp.state.outOffset
+}
+
+// rejectsOffset is how much of the rejects file to keep
+func (cp *checkpointer) rejectsOffset() int64 {
+	if cp == nil {
+		return 0
+	}
+	return cp.state.rejectsOffset
+}
+
+// blockDone records where in the SAM file the block with index idx ended
+func (cp *checkpointer) blockDone(idx int, end int64, query string) {
+	if cp == nil || end < 0 {
+		return
+	}
+	cp.mu.Lock()
+	cp.ends[idx] = blockEnd{offset: end, query: query}
+	cp.mu.Unlock()
+}
+
+// written is called once the output with index idx (and everything before it) has been written to
+// out. If it was the end of a block, and enough blocks have been written since the last checkpoint,
+// another checkpoint is written
+func (cp *checkpointer) written(idx int, out *os.File, flt *fastaio.Filter) error {
+	if cp == nil {
+		return nil
+	}
+
+	cp.mu.Lock()
+	end, ok := cp.ends[idx]
+	delete(cp.ends, idx)
+	cp.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	cp.state.samOffset = end.offset
+	cp.state.lastQuery = end.query
+	cp.state.queries++
+	cp.since++
+
+	if cp.since < cp.every {
+		return nil
+	}
+
+	return cp.save(out, flt)
+}
+
+// save writes a checkpoint, once the output and rejects files are on disk
+func (cp *checkpointer) save(out *os.File, flt *fastaio.Filter) error {
+
+	err := out.Sync()
+	if err != nil {
+		return err
+	}
+	cp.state.outOffset, err = out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	cp.state.rejectsOffset, err = flt.RejectsOffset()
+	if err != nil {
+		return err
+	}
+
+	cp.since = 0
+
+	return writeCheckpoint(cp.path, cp.state)
+}
+
+// finish writes a last checkpoint, which says that the run is complete
+func (cp *checkpointer) finish(out *os.File, flt *fastaio.Filter) error {
+	if cp == nil {
+		return nil
+	}
+	cp.state.complete = true
+	return cp.save(out, flt)
+}
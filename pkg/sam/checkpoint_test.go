@@ -0,0 +1,161 @@
+package sam
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ckpt")
+	cp := checkpoint{samFile: "in.sam", samSize: 1000, samOffset: 500, queries: 12, lastQuery: "q 12", outOffset: 300, rejectsOffset: 20}
+
+	err = writeCheckpoint(path, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != cp {
+		t.Errorf("problem in TestCheckpointRoundTrip: got %v, expected %v", got, cp)
+	}
+}
+
+func TestToMultiAlignResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 200)
+
+	var sam bytes.Buffer
+	err = testutil.WriteSam(&sam, r, "ref", ref, 40, 110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samFile := filepath.Join(dir, "in.sam")
+	err = ioutil.WriteFile(samFile, sam.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first 15 queries, as if the run had been interrupted there
+	prefix := sam.String()[:strings.Index(sam.String(), "query15\t")]
+	prefixFile := filepath.Join(dir, "prefix.sam")
+	err = ioutil.WriteFile(prefixFile, []byte(prefix), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toma := func(samFile string, outFile string, rejectsFile string, checkpointFile string, resume bool) error {
+		opts := DefaultToMultiAlignOptions()
+		opts.MinCompleteness = 0.6
+		opts.RejectsFile = rejectsFile
+		opts.CheckpointFile = checkpointFile
+		opts.CheckpointEvery = 4
+		opts.Resume = resume
+		opts.Threads = 2
+		return ToMultiAlign(samFile, "", outFile, nil, opts)
+	}
+
+	expectedOut := filepath.Join(dir, "expected.fasta")
+	expectedRejects := filepath.Join(dir, "expected.rejects.fasta")
+	err = toma(samFile, expectedOut, expectedRejects, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "out.fasta")
+	rejectsFile := filepath.Join(dir, "rejects.fasta")
+	checkpointFile := filepath.Join(dir, "ckpt")
+
+	err = toma(prefixFile, outFile, rejectsFile, checkpointFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make the checkpoint look like it came from part way through the whole file, and
+	// add some output that was written after it
+	cp, err := readCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.queries != 15 || cp.lastQuery != "query14" || cp.samOffset != int64(len(prefix)) {
+		t.Errorf("problem in TestToMultiAlignResume: the checkpoint is %v", cp)
+	}
+	cp.samFile = samFile
+	cp.samSize = int64(sam.Len())
+	cp.complete = false
+	err = writeCheckpoint(checkpointFile, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(outFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(">query15\nACGT")
+	f.Close()
+
+	err = toma(samFile, outFile, rejectsFile, checkpointFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pair := range [][2]string{{outFile, expectedOut}, {rejectsFile, expectedRejects}} {
+		got, err := ioutil.ReadFile(pair[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := ioutil.ReadFile(pair[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(expected) == 0 || !bytes.Equal(got, expected) {
+			t.Errorf("problem in TestToMultiAlignResume: the resumed %s is\n%s\nexpected\n%s", filepath.Base(pair[0]), got, expected)
+		}
+	}
+
+	cp, err = readCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.complete || cp.queries != 40 {
+		t.Errorf("problem in TestToMultiAlignResume: the last checkpoint is %v", cp)
+	}
+
+	opts := DefaultToMultiAlignOptions()
+	opts.CheckpointFile = checkpointFile
+	opts.CheckpointEvery = 4
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", "stdout", nil, opts)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestToMultiAlignResume: expected a usage error for --checkpoint with stdout, got %v", err)
+	}
+
+	opts = DefaultToMultiAlignOptions()
+	opts.Resume = true
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestToMultiAlignResume: expected a usage error for --resume without --checkpoint, got %v", err)
+	}
+}
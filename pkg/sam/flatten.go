@@ -0,0 +1,286 @@
+package sam
+
+import (
+	"sort"
+	"unicode"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// flattener builds the flattened sequence for one query from its SAM records,
+// one record at a time, instead of holding every record's aligned sequence
+// in a block and flattening it column by column.
+//
+// Memory use is bounded by the reference length, not by the number of records:
+// one refLen byte slice for the sequence, plus (when flattening by quality) one refLen
+// byte slice of qualities and a small map entry for each site where the records
+// carry different letters. Each record's own aligned sequence can be discarded
+// as soon as it has been added.
+type flattener struct {
+	qname     string
+	seq       []byte
+	byQuality bool
+	margin    int
+	soft      bool                  // soft-mask conflicts and low quality bases, instead of writing Ns
+	minQual   int                   // if soft, bases with a quality below this are soft-masked
+	quals     []byte                // the highest quality seen for the letter in seq, for each site
+	contested map[int]map[byte]byte // for sites with more than one letter: letter -> its highest quality
+	minDepth  int                   // if > 1, sites covered by fewer records than this are Ns
+	depth     []int32               // with minDepth, the number of records that cover each site
+	iv        alignedInterval       // reused for each record passed to addRecord
+}
+
+func isLetter(b byte) bool {
+	return unicode.IsLetter(rune(b))
+}
+
+// softMask is the soft-masked (lower case) form of a base, which is written instead of an N
+// with --soft-mask, so that the call is kept but marked as less certain. An N has no call to
+// keep, so stays an N
+func softMask(b byte) byte {
+	if b >= 'A' && b <= 'Z' && b != 'N' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// isSoftMasked reports whether a base has been soft-masked
+func isSoftMasked(b byte) bool {
+	return b >= 'a' && b <= 'z'
+}
+
+// unmasked is the upper case form of a (possibly soft-masked) base
+func unmasked(b byte) byte {
+	if isSoftMasked(b) {
+		return b - ('a' - 'A')
+	}
+	return b
+}
+
+// newFlattener returns a flattener with an empty (unmapped) sequence of length refLen
+func newFlattener(qname string, refLen int, byQuality bool, margin int) *flattener {
+
+	fl := &flattener{margin: margin, contested: make(map[int]map[byte]byte)}
+
+	fl.reset(qname, refLen, byQuality)
+
+	return fl
+}
+
+// softMasking makes the flattener soft-mask the sites where letters conflict, instead of
+// writing an N, and the bases with a quality below minQual (if it is > 0)
+func (fl *flattener) softMasking(minQual int) {
+	fl.soft = true
+	fl.minQual = minQual
+}
+
+// requireDepth makes the flattener write an N (or with soft-masking, soft-mask the base) at the
+// sites that fewer than minDepth of a query's records cover with a base or a deletion, instead of
+// trusting fewer records than that. Sites that no record covers are still unmapped
+func (fl *flattener) requireDepth(minDepth int) {
+	fl.minDepth = minDepth
+}
+
+// reset empties the flattener so that it can be used for another query, keeping the
+// memory it has already allocated, so a worker goroutine only ever needs one
+func (fl *flattener) reset(qname string, refLen int, byQuality bool) {
+
+	fl.qname = qname
+	fl.byQuality = byQuality
+
+	if cap(fl.seq) < refLen {
+		fl.seq = make([]byte, refLen)
+	}
+	fl.seq = fl.seq[:refLen]
+	for i := range fl.seq {
+		fl.seq[i] = unmappedSite
+	}
+
+	// quals don't need clearing, because they are only read at sites
+	// where seq has a letter, and adding that letter set them
+	if byQuality {
+		if cap(fl.quals) < refLen {
+			fl.quals = make([]byte, refLen)
+		}
+		fl.quals = fl.quals[:refLen]
+	}
+
+	for j := range fl.contested {
+		delete(fl.contested, j)
+	}
+
+	if fl.minDepth > 1 {
+		if cap(fl.depth) < refLen {
+			fl.depth = make([]int32, refLen)
+		}
+		fl.depth = fl.depth[:refLen]
+		for i := range fl.depth {
+			fl.depth[i] = 0
+		}
+	}
+}
+
+// addRecord adds one SAM record to the flattened sequence
+func (fl *flattener) addRecord(samLine biogosam.Record) error {
+
+	err := getAlignedInterval(samLine, fl.byQuality || fl.minQual > 0, &fl.iv)
+	if err != nil {
+		return err
+	}
+
+	fl.add(fl.iv.start, fl.iv.seq, fl.iv.quals)
+
+	return nil
+}
+
+// add merges one record's aligned sequence, which starts at (0-based) reference
+// position start, into the flattened sequence. quals are the base qualities of
+// seq, and are only used when flattening by quality (they can be nil otherwise).
+// The rules are the same as getNucFromSite's: a site's state (see siteState) is the highest of
+// the records' states there, so alphabetic characters override '-'s and unmapped sites, and '-'s
+// override unmapped sites. If two different letters are present at the same
+// site, the result is an N, unless we are flattening by quality, in which case
+// they are resolved in result(). When soft-masking, quals are also used to soft-mask the
+// low quality bases, a base that isn't soft-masked wins over the same base that is, and
+// a conflict is soft-masked instead of being an N: the first record's call is kept, unless
+// only the later one is of good quality.
+func (fl *flattener) add(start int, seq []byte, quals []byte) {
+
+	for k, b := range seq {
+		j := start + k
+		if j < 0 {
+			continue
+		}
+		if j >= len(fl.seq) {
+			break
+		}
+
+		if fl.minDepth > 1 && stateOf(b) != unmapped {
+			fl.depth[j]++
+		}
+
+		if fl.minQual > 0 && isLetter(b) && int(quals[k]) < fl.minQual {
+			b = softMask(b)
+		}
+
+		cur := fl.seq[j]
+
+		switch {
+		case !isLetter(b):
+			if !isLetter(cur) && stateOf(b) > stateOf(cur) {
+				fl.seq[j] = b
+			}
+
+		case cur == b:
+			if fl.byQuality {
+				fl.updateQual(j, b, quals[k])
+			}
+
+		case !isLetter(cur):
+			fl.seq[j] = b
+			if fl.byQuality {
+				fl.quals[j] = quals[k]
+			}
+
+		case unmasked(cur) == unmasked(b):
+			if !isSoftMasked(b) {
+				fl.seq[j] = b
+				if fl.byQuality {
+					fl.quals[j] = quals[k]
+				}
+			}
+			if fl.byQuality {
+				fl.updateQual(j, b, quals[k])
+			}
+
+		default:
+			switch {
+			case fl.byQuality:
+				if _, ok := fl.contested[j]; !ok {
+					fl.contested[j] = map[byte]byte{unmasked(cur): fl.quals[j]}
+				}
+				fl.updateQual(j, b, quals[k])
+			case fl.soft:
+				if isSoftMasked(cur) && !isSoftMasked(b) {
+					cur = b
+				}
+				fl.seq[j] = softMask(cur)
+			default:
+				fl.seq[j] = getNucFromSite([]byte{cur, b}, fl.qname)
+			}
+		}
+	}
+}
+
+// updateQual records the quality of a letter at a site if it is higher than any
+// quality we have seen for that letter at that site so far. Contested sites are
+// keyed by the upper case letter, whether or not it was soft-masked
+func (fl *flattener) updateQual(j int, b byte, q byte) {
+	if fl.seq[j] == b && q > fl.quals[j] {
+		fl.quals[j] = q
+	}
+	if m, ok := fl.contested[j]; ok {
+		b = unmasked(b)
+		if old, seen := m[b]; !seen || q > old {
+			m[b] = q
+		}
+	}
+}
+
+// result returns the flattened sequence. When flattening by quality, the letter with
+// the highest quality at each contested site is used, provided its quality is at least
+// margin higher than that of every other letter at the site (and is higher, if margin is 0),
+// otherwise we fall back to getNucFromSite (an N). Missing qualities (0xff) also cause a
+// fall back. When
+// soft-masking, the best letter is soft-masked instead of falling back, or if its quality is
+// below minQual. With a minDepth, the sites that aren't covered by enough records are then
+// masked (see requireDepth).
+func (fl *flattener) result() []byte {
+
+	for j, m := range fl.contested {
+		var best byte
+		var qbest byte
+		letters := make([]byte, 0, len(m))
+		unknown := false
+
+		for b, q := range m {
+			letters = append(letters, b)
+			if q == 0xff {
+				unknown = true
+			}
+			// the map is in no particular order, so ties go to the lowest letter
+			if len(letters) == 1 || q > qbest || (q == qbest && b < best) {
+				best = b
+				qbest = q
+			}
+		}
+		sort.Slice(letters, func(i, k int) bool { return letters[i] < letters[k] })
+
+		// a tie is never resolved, even with a margin of 0
+		resolved := !unknown
+		for b, q := range m {
+			if b != best && (int(qbest)-int(q) < fl.margin || q == qbest) {
+				resolved = false
+			}
+		}
+
+		switch {
+		case fl.soft && (!resolved || int(qbest) < fl.minQual):
+			fl.seq[j] = softMask(best)
+		case resolved:
+			fl.seq[j] = best
+		default:
+			fl.seq[j] = getNucFromSite(letters, fl.qname)
+		}
+	}
+
+	if fl.minDepth > 1 {
+		for j, d := range fl.depth {
+			if d > 0 && int(d) < fl.minDepth {
+				fl.seq[j] = hardOrSoftMask(fl.seq[j], fl.soft)
+			}
+		}
+	}
+
+	return fl.seq
+}
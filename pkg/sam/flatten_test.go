@@ -0,0 +1,112 @@
+package sam
+
+import (
+	"testing"
+)
+
+// the flattener should give the same answer as flattening the whole block at once
+func TestFlattener(t *testing.T) {
+	block := [][]byte{
+		sites("ACGT-***AC**"),
+		sites("**GTAA--AG**"),
+		sites("*****A-*AC*-"),
+	}
+
+	expected := checkAndGetFlattenedSeq(block, "test")
+
+	fl := newFlattener("test", len(block[0]), false, 0)
+	for _, line := range block {
+		fl.add(0, line, nil)
+	}
+
+	if string(fl.result()) != string(expected) {
+		t.Errorf("problem in TestFlattener: %s %s", showSites(fl.result()), showSites(expected))
+	}
+}
+
+func TestFlattenerByQuality(t *testing.T) {
+	fl := newFlattener("test", 6, true, 10)
+
+	fl.add(0, []byte("ACGT"), []byte{30, 30, 30, 5})
+	fl.add(2, []byte("GAAA"), []byte{30, 30, 30, 30})
+	fl.add(4, []byte("C"), []byte{25})
+
+	// site 3: A (30) beats T (5); site 4: A (30) doesn't beat C (25) by 10
+	if string(fl.result()) != "ACGANA" {
+		t.Errorf("problem in TestFlattenerByQuality: %s", string(fl.result()))
+	}
+}
+
+func TestFlattenerSoftMask(t *testing.T) {
+	fl := newFlattener("test", 6, false, 10)
+	fl.softMasking(20)
+
+	fl.add(0, []byte("ACGT"), []byte{30, 30, 30, 5})
+	fl.add(2, []byte("GAAA"), []byte{30, 30, 30, 30})
+	fl.add(4, []byte("C"), []byte{25})
+
+	// site 3: the A beats the low quality T, but they conflict; site 4: the first A is kept
+	if string(fl.result()) != "ACGaaA" {
+		t.Errorf("problem in TestFlattenerSoftMask: %s", string(fl.result()))
+	}
+
+	fl.reset("test", 6, true)
+
+	fl.add(0, []byte("ACGT"), []byte{30, 30, 10, 5})
+	fl.add(2, []byte("GAAA"), []byte{15, 30, 30, 30})
+	fl.add(4, []byte("C"), []byte{25})
+
+	// site 2: both Gs are low quality; site 4: A (30) doesn't beat C (25) by 10
+	if string(fl.result()) != "ACgAaA" {
+		t.Errorf("problem in TestFlattenerSoftMask: %s", string(fl.result()))
+	}
+}
+
+func TestFlattenerMinDepth(t *testing.T) {
+	fl := newFlattener("test", 8, false, 10)
+	fl.requireDepth(2)
+	fl.reset("test", 8, false)
+
+	fl.add(0, []byte("ACGT-A"), nil)
+	fl.add(2, []byte("GT-AC"), nil)
+
+	// sites 0, 1 and 6 are only covered by one record, and site 7 by none
+	if showSites(fl.result()) != "NNGT-AN*" {
+		t.Errorf("problem in TestFlattenerMinDepth: %s", showSites(fl.result()))
+	}
+
+	// the depth starts again for the next query
+	fl.reset("test", 8, false)
+	fl.add(0, []byte("AC"), nil)
+	fl.add(0, []byte("AC"), nil)
+
+	if showSites(fl.result()) != "AC******" {
+		t.Errorf("problem in TestFlattenerMinDepth: %s", showSites(fl.result()))
+	}
+}
+
+// with a margin of 0, letters of the same quality don't depend on the order that the map of
+// contested sites is read in
+func TestFlattenerByQualityTies(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		fl := newFlattener("test", 3, true, 0)
+		fl.add(0, []byte("ACG"), []byte{30, 30, 30})
+		fl.add(0, []byte("TCA"), []byte{30, 30, 31})
+		fl.add(0, []byte("G"), []byte{30})
+
+		// site 0: a three-way tie; site 2: A (31) beats G (30)
+		if string(fl.result()) != "NCA" {
+			t.Fatalf("problem in TestFlattenerByQualityTies: %s", string(fl.result()))
+		}
+
+		fl = newFlattener("test", 3, true, 0)
+		fl.softMasking(0)
+		fl.add(0, []byte("ACG"), []byte{30, 30, 30})
+		fl.add(0, []byte("TCG"), []byte{30, 30, 30})
+
+		// soft-masking keeps the lowest of the tied letters
+		if string(fl.result()) != "aCG" {
+			t.Fatalf("problem in TestFlattenerByQualityTies: %s", string(fl.result()))
+		}
+	}
+}
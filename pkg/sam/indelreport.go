@@ -0,0 +1,159 @@
+package sam
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/remote"
+)
+
+// the names in the samples and files columns of the tsv and csv reports are separated by "|"s,
+// which some names have in them too (e.g. GISAID's hCoV-19/England/ABCD-123/2020|EPI_ISL_123|2020-03-01),
+// so those (and the "%"s that escape them) are percent-encoded
+var (
+	nameEscaper   = strings.NewReplacer("%", "%25", "|", "%7C")
+	nameUnescaper = strings.NewReplacer("%7C", "|", "%7c", "|", "%25", "%")
+)
+
+// joinNames joins sample (or file) names for the samples (or files) column of a report
+func joinNames(names []string) string {
+	escaped := make([]string, len(names))
+	for i, name := range names {
+		escaped[i] = nameEscaper.Replace(name)
+	}
+	return strings.Join(escaped, "|")
+}
+
+// splitNames splits the samples column of a report back into the names that joinNames joined
+func splitNames(column string) []string {
+	names := strings.Split(column, "|")
+	for i, name := range names {
+		names[i] = nameUnescaper.Replace(name)
+	}
+	return names
+}
+
+// isIndelReport is true if infile is a tsv insertions or deletions report written by sam indels,
+// rather than a SAM file: it starts with the ##gofasta line, or the ref_start column's header
+func isIndelReport(infile string) (bool, error) {
+
+	if len(infile) == 0 {
+		return false, nil
+	}
+
+	f, err := remote.Open(infile)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	return strings.HasPrefix(line, "##gofasta=") || strings.HasPrefix(line, "ref_start"), nil
+}
+
+// readIndelReport sends the indels in a tsv insertions or deletions report from an earlier run of
+// sam indels, the file'th input, to cIns or cDel (which one depends on the report's header). Each
+// sample in the samples column is an occurrence of the indel; if the report has a count column
+// instead, the occurrences don't have queries. Any other columns (e.g. repeat_unit) are ignored.
+// If ref isn't nil, the indels are left-aligned against it. Only the indels that passed the
+// earlier run's --threshold are in the report
+func readIndelReport(infile string, file int, ref []byte, cIns chan indelKey, cDel chan indelKey) error {
+
+	f, err := remote.Open(infile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	var header []string
+	rows := 0
+
+	for {
+		// the samples column can be too long for a bufio.Scanner
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.HasPrefix(line, "##") || len(line) == 0 {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+
+		if header == nil {
+			header = fields
+			if len(header) < 3 || header[0] != "ref_start" || (header[1] != "insertion" && header[1] != "length") ||
+				(header[len(header)-1] != "samples" && header[len(header)-1] != "count") {
+				return fmt.Errorf("%s isn't a tsv insertions or deletions report from sam indels: its header is %s", infile, line)
+			}
+			continue
+		}
+
+		rows++
+
+		if len(fields) != len(header) {
+			return fmt.Errorf("row %d of %s has %d columns, but the header has %d", rows, infile, len(fields), len(header))
+		}
+
+		pos, err := strconv.Atoi(fields[0])
+		if err != nil || pos < 1 {
+			return fmt.Errorf("row %d of %s has a bad ref_start: %s", rows, infile, fields[0])
+		}
+
+		// ref_start is 1-based
+		k := indelKey{pos: pos - 1, file: file}
+		insertion := header[1] == "insertion"
+		if insertion {
+			k.seq = fields[1]
+		} else {
+			k.length, err = strconv.Atoi(fields[1])
+		}
+		if err != nil || (insertion && len(k.seq) == 0) || (!insertion && k.length < 1) {
+			return fmt.Errorf("row %d of %s has a bad %s: %s", rows, infile, header[1], fields[1])
+		}
+
+		if ref != nil {
+			k = leftAlign(ref, k)
+		}
+
+		c := cDel
+		if insertion {
+			c = cIns
+		}
+
+		last := fields[len(fields)-1]
+
+		if header[len(header)-1] == "count" {
+			k.count, err = strconv.Atoi(last)
+			if err != nil || k.count < 1 {
+				return fmt.Errorf("row %d of %s has a bad count: %s", rows, infile, last)
+			}
+			c <- k
+			continue
+		}
+
+		for _, sample := range splitNames(last) {
+			k.query = sample
+			c <- k
+		}
+	}
+
+	summary.Read(infile, rows)
+
+	return nil
+}
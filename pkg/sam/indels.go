@@ -1,48 +1,67 @@
 package sam
 
 import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"io"
 	"os"
-	"sort"
-	"sync"
-	"errors"
+	"runtime"
 	"strconv"
 	"strings"
-	"runtime"
-	biogosam "github.com/biogo/hts/sam"
-)
+	"sync"
 
-// TODO: tidy this up wrt to the struct(s) in topa.go
-type insOccurrence struct {
-	query string
-	start int
-	seq string
-}
+	"github.com/cov-ert/gofasta/internal/cigar"
+	"github.com/cov-ert/gofasta/internal/pipeline"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/remote"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
 
-type delOccurrence struct {
-	query string
-	start int
-	length int
-}
+	biogosam "github.com/biogo/hts/sam"
+)
 
-func getSamRecords(infile string, chnl chan biogosam.Record, cdone chan bool, cerr chan error) {
+// getSamRecords sends the header of a SAM file (an empty one, if the file is empty), and then
+// each of its mapped records that can be turned into an aligned sequence. If stop is closed
+// (because the pipeline has failed), it returns without reading any more of the file
+func getSamRecords(infile string, skipCorrupt bool, missingSeq string, cHeader chan biogosam.Header, chnl chan biogosam.Record, cerr chan error, stop chan struct{}) {
 
 	var err error
 
-	f, err := os.Open(infile)
+	f, err := remote.Open(infile)
 	if err != nil {
 		cerr<- err
+		return
 	}
 
 	defer f.Close()
 
-	s, err := biogosam.NewReader(f)
-	if err != nil {
+	// a zero-length file is read as a SAM file with no header and no records
+	s, err := newSamReader(f)
+	empty := err == io.EOF
+	if err != nil && !empty {
 		cerr<- err
+		return
+	}
+
+	header := biogosam.Header{}
+	if !empty {
+		header = *s.Header()
 	}
+	select {
+	case cHeader <- header:
+	case <-stop:
+		return
+	}
+
+	nRead := 0
+	nSkipped := 0
 
-	for {
-		rec, err := s.Read()
+	for !empty {
+		rec, err := readSamRecord(s)
 
 		if err == io.EOF {
 
@@ -50,31 +69,49 @@ func getSamRecords(infile string, chnl chan biogosam.Record, cdone chan bool, ce
 
 		} else if err != nil {
 
+			nRead++
+			if skipRecord(err, nRead, skipCorrupt) {
+				nSkipped++
+				continue
+			}
 			cerr<- err
+			return
 
 		} else {
-			// if this read is unmapped, then skip it.
-			// the third bit (== 4) in the sam flag is set if the read is unmapped,
-			// can use the rightshift method to check this:
-			if ((rec.Flags >> 2) & 1) == 1 {
-				os.Stderr.WriteString("skipping unmapped read: " + rec.Name + "\n")
+			nRead++
+
+			keep, err := acceptRecord(rec, nRead, true, skipCorrupt, missingSeq)
+			if err != nil {
+				cerr<- err
+				return
+			}
+			if !keep {
+				nSkipped++
 				continue
 			}
 
-			chnl<- *rec
+			select {
+			case chnl <- *rec:
+			case <-stop:
+				return
+			}
 
 		}
 	}
 
-	cdone <- true
+	if nRead == 0 {
+		warnNoRecords(infile)
+	}
+	summary.Read(infile, nRead)
+	summary.Add(summary.Skipped, nSkipped)
+	summary.Add(summary.Processed, nRead-nSkipped)
 }
 
-func getIndels(cSR chan biogosam.Record, cIns chan insOccurrence, cDel chan delOccurrence, cErr chan error) {
-
-	lambda_dict := getCigarOperationMapNoInsertions()
+// getIndels sends the insertions and deletions in each record of the file'th input. If ref
+// isn't nil, they are left-aligned against it first (see leftAlign)
+func getIndels(cSR chan biogosam.Record, ref []byte, file int, cIns chan indelKey, cDel chan indelKey, cErr chan error) {
 
-	var ins insOccurrence
-	var del delOccurrence
+	lambda_dict := cigar.NoInsertions()
 
 	for samLine := range(cSR) {
 
@@ -84,12 +121,16 @@ func getIndels(cSR chan biogosam.Record, cIns chan insOccurrence, cDel chan delO
 
 		if POS < 0 {
 			cErr<- errors.New("unmapped read")
+			continue
 		}
 
-		SEQ := samLine.Seq.Expand()
+		bp := getExpandedSeq(samLine.Seq)
+		SEQ := *bp
 
 		CIGAR := samLine.Cigar
 
+		read := readInfo{reverse: samLine.Flags&biogosam.Reverse != 0, start: POS, end: samLine.End()}
+
 		qstart := 0
 		rstart := POS
 
@@ -99,13 +140,19 @@ func getIndels(cSR chan biogosam.Record, cIns chan insOccurrence, cDel chan delO
 			size := op.Len()
 
 			if operation == "I" {
-				ins = insOccurrence{query: QNAME, start: rstart, seq: string(SEQ[qstart:qstart + size])}
-				cIns<- ins
+				k := indelKey{pos: rstart, seq: string(SEQ[qstart:qstart + size]), query: QNAME, read: read, file: file}
+				if ref != nil {
+					k = leftAlign(ref, k)
+				}
+				cIns<- k
 			}
 
 			if operation == "D" {
-				del = delOccurrence{query: QNAME, start: rstart, length: size}
-				cDel<- del
+				k := indelKey{pos: rstart, length: size, query: QNAME, read: read, file: file}
+				if ref != nil {
+					k = leftAlign(ref, k)
+				}
+				cDel<- k
 			}
 
 			new_qstart, new_rstart, _ := lambda_dict[operation](qstart, rstart, size, SEQ)
@@ -114,239 +161,512 @@ func getIndels(cSR chan biogosam.Record, cIns chan insOccurrence, cDel chan delO
 			rstart = new_rstart
 
 		}
+
+		putExpandedSeq(bp)
 	}
 
 	return
 }
 
-func populateInsMap(cIns chan insOccurrence, cInsMap chan map[int]map[string][]string, cErr chan error)  {
+// populateIndelTable adds the occurrences of insertions or deletions to a table
+// (which must only be used by one goroutine)
+func populateIndelTable(cIndel chan indelKey, t *indelTable, byQuery *indelTable, cErr chan error) {
 
-	insMap := make(map[int]map[string][]string)
+	failed := false
+	for k := range(cIndel) {
+		if failed {
+			continue
+		}
+		err := t.add(k)
+		if err == nil && byQuery != nil {
+			err = byQuery.add(k)
+		}
+		if err != nil {
+			cErr<- err
+			failed = true
+		}
+	}
+}
 
-	// type insertionOccurrence struct {
-	// 	query string
-	// 	start int
-	// 	seq string
-	// }
+// insEvent is one insertion in the insertions report
+type insEvent struct {
+	RefStart  int        `json:"ref_start"`
+	Insertion string     `json:"insertion"`
+	Count     int        `json:"count"`
+	Samples   []string   `json:"samples,omitempty"`
+	Repeat    *repeat    `json:"repeat,omitempty"`
+	Reads     *readStats `json:"reads,omitempty"`
+	Files     []string   `json:"files,omitempty"`
+}
 
-	var q string
-	var strt int
-	var sq string
+// delEvent is one deletion in the deletions report
+type delEvent struct {
+	RefStart int        `json:"ref_start"`
+	Length   int        `json:"length"`
+	Count    int        `json:"count"`
+	Samples  []string   `json:"samples,omitempty"`
+	Repeat   *repeat    `json:"repeat,omitempty"`
+	Reads    *readStats `json:"reads,omitempty"`
+	Files    []string   `json:"files,omitempty"`
+}
 
-	for ins := range(cIns) {
-		q = ins.query
-		strt = ins.start
-		sq = ins.seq
+// repeatHeader is the names of the columns that the repeat an indel is in is written in
+var repeatHeader = []string{"repeat_unit", "repeat_length"}
+
+// annotations is the repeat that an indel is in (if there is a reference to find it in), the
+// readStats of the reads with it and the inputs it is in (if t keeps them), as they are
+// written in the report
+func annotations(ref []byte, t *indelTable, k indelKey) (*repeat, *readStats, []string, []string) {
+	columns := make([]string, 0)
+	var r *repeat
+	if ref != nil {
+		context := repeatContext(ref, k)
+		r = &context
+		columns = append(columns, r.Unit, strconv.Itoa(r.Length))
+	}
+	stats := t.readStats(k)
+	if stats != nil {
+		columns = append(columns, stats.columns()...)
+	}
+	files := t.inputs(k)
+	if files != nil {
+		columns = append(columns, joinNames(files))
+	}
+	return r, stats, files, columns
+}
 
-		if _, ok := insMap[strt]; ok {
-			if _, ok := insMap[strt][sq]; ok {
-				insMap[strt][sq] = append(insMap[strt][sq], q)
-			} else {
-				insMap[strt][sq] = []string{q}
-			}
-		} else {
-			insMap[strt] = make(map[string][]string)
-			insMap[strt][sq] = []string{q}
-		}
+// annotationsHeader is the names of the columns that annotations are written in
+func annotationsHeader(ref []byte, t *indelTable) []string {
+	header := make([]string, 0)
+	if ref != nil {
+		header = append(header, repeatHeader...)
+	}
+	if t.stats != nil {
+		header = append(header, readStatsHeader...)
+	}
+	if t.seenIn != nil {
+		header = append(header, "files")
+	}
+	return header
+}
+
+// checkIndelsFormat makes sure that the indel report format is one we can write
+func checkIndelsFormat(format string) error {
+	switch format {
+	case "tsv", "csv", "json":
+		return nil
 	}
+	return usage.Errorf("unrecognised --format: %s (choose one of: tsv, csv, json)", format)
+}
 
-	cInsMap<- insMap
+// indelReport writes an indel report one row at a time. For tsv and csv, each row
+// is its first two columns followed by either the "|"-joined samples (see joinNames) or, if
+// noSamples, their count. For json, it is an array with one object (an insEvent
+// or a delEvent) per line
+type indelReport struct {
+	f         *os.File
+	w         *bufio.Writer
+	cw        *csv.Writer
+	format    string
+	noSamples bool
+	rows      int
 }
 
-func populateDelMap(cDel chan delOccurrence, cDelMap chan map[int]map[int][]string, cErr chan error)  {
+func newIndelReport(outfile string, format string, noSamples bool, header []string) (*indelReport, error) {
+
+	f, err := os.Create(outfile)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &indelReport{f: f, w: bufio.NewWriter(f), format: format, noSamples: noSamples}
+
+	if format == "json" {
+		_, err = r.w.WriteString("[")
+		return r, err
+	}
 
-	delMap := make(map[int]map[int][]string)
+	if noSamples {
+		header = append(header, "count")
+	} else {
+		header = append(header, "samples")
+	}
 
-	// type deletionOccurrence struct {
-	// 	query string
-	// 	start int
-	// 	length int
-	// }
+	if format == "csv" {
+		r.cw = csv.NewWriter(r.w)
+		return r, r.cw.Write(header)
+	}
 
-	var q string
-	var strt int
-	var ln int
+	_, err = r.w.WriteString("##" + version.Provenance() + "\n" + strings.Join(header, "\t") + "\n")
 
-	for del := range(cDel) {
-		q = del.query
-		strt = del.start
-		ln = del.length
+	return r, err
+}
 
-		if _, ok := delMap[strt]; ok {
-			if _, ok := delMap[strt][ln]; ok {
-				delMap[strt][ln] = append(delMap[strt][ln], q)
-			} else {
-				delMap[strt][ln] = []string{q}
-			}
-		} else {
-			delMap[strt] = make(map[int][]string)
-			delMap[strt][ln] = []string{q}
+func (r *indelReport) write(columns []string, samples []string, count int, event interface{}) error {
+
+	r.rows++
+
+	if r.format == "json" {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return err
 		}
+		if r.rows > 1 {
+			r.w.WriteString(",")
+		}
+		r.w.WriteString("\n")
+		_, err = r.w.Write(b)
+		return err
 	}
 
-	cDelMap<- delMap
+	if r.noSamples {
+		columns = append(columns, strconv.Itoa(count))
+	} else {
+		columns = append(columns, joinNames(samples))
+	}
+
+	if r.format == "csv" {
+		return r.cw.Write(columns)
+	}
+
+	_, err := r.w.WriteString(strings.Join(columns, "\t") + "\n")
+
+	return err
 }
 
-func writeInsMap(outfile string, insmap map[int]map[string][]string, threshold int) error {
+func (r *indelReport) close() error {
 
-	keys := make([]int, 0, len(insmap))
-	for k := range insmap {
-		keys = append(keys, k)
+	defer r.f.Close()
+
+	if r.format == "json" {
+		r.w.WriteString("\n]\n")
+	}
+
+	if r.cw != nil {
+		r.cw.Flush()
+		if err := r.cw.Error(); err != nil {
+			return err
+		}
 	}
-	sort.Ints(keys)
+
+	return r.w.Flush()
+}
+
+// writeInsFasta writes every occurrence of every insertion in t (a table from newQueryTable) in
+// fasta format, sorted by query and then by position. Each header is query:ref_start:length,
+// where ref_start is the same (1-based) position as in the insertions report
+func writeInsFasta(outfile string, t *indelTable) error {
 
 	f, err := os.Create(outfile)
 	if err != nil {
 		return err
 	}
-
 	defer f.Close()
 
-	_, err = f.WriteString("ref_start\tinsertion\tsamples\n")
+	w := bufio.NewWriter(f)
+
+	err = t.occurrences(func(k indelKey) error {
+		_, err := w.WriteString(">" + k.query + ":" + strconv.Itoa(k.pos+1) + ":" + strconv.Itoa(len(k.seq)) + "\n" + k.seq + "\n")
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
-	for _, k := range(keys) {
-		for v := range(insmap[k]) {
-			if len(insmap[k][v]) < threshold {
-				continue
-			}
-			// k + 1 to get things in 1-based coordinates
-			c1 := strconv.Itoa(k + 1)
-			c2 := v
-			c3 := strings.Join(insmap[k][v], "|")
+	return w.Flush()
+}
 
-			_, err = f.WriteString(c1 + "\t" + c2 + "\t" + c3 + "\n")
-			if err != nil {
-				return err
-			}
-		}
+// writeInsertions writes the insertions that are in at least threshold queries. If ref isn't
+// nil, the repeat that each insertion is in is written too
+func writeInsertions(outfile string, ref []byte, t *indelTable, threshold int, format string, noSamples bool, byCount bool) error {
+
+	header := append([]string{"ref_start", "insertion"}, annotationsHeader(ref, t)...)
+
+	report, err := newIndelReport(outfile, format, noSamples, header)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	err = t.eachByPosition(byCount, func(k indelKey, samples []string, count int) error {
 
-func writeDelMap(outfile string, delmap map[int]map[int][]string, threshold int) error {
+		// k.pos + 1 to get things in 1-based coordinates
+		start := strconv.Itoa(k.pos + 1)
 
-	keys := make([]int, 0, len(delmap))
-	for k := range delmap {
-		keys = append(keys, k)
-	}
-	sort.Ints(keys)
+		if count < threshold {
+			return nil
+		}
 
-	f, err := os.Create(outfile)
+		r, stats, files, annotated := annotations(ref, t, k)
+
+		event := insEvent{RefStart: k.pos + 1, Insertion: k.seq, Count: count, Repeat: r, Reads: stats, Files: files}
+		if !noSamples {
+			event.Samples = samples
+		}
+
+		return report.write(append([]string{start, k.seq}, annotated...), samples, count, event)
+	})
 	if err != nil {
 		return err
 	}
 
-	defer f.Close()
+	return report.close()
+}
+
+// writeDeletions writes the deletions that are in at least threshold queries. If ref isn't
+// nil, the repeat that each deletion is in is written too
+func writeDeletions(outfile string, ref []byte, t *indelTable, threshold int, format string, noSamples bool, byCount bool) error {
+
+	header := append([]string{"ref_start", "length"}, annotationsHeader(ref, t)...)
 
-	_, err = f.WriteString("ref_start\tlength\tsamples\n")
+	report, err := newIndelReport(outfile, format, noSamples, header)
 	if err != nil {
 		return err
 	}
 
-	for _, k := range(keys) {
-		for v := range(delmap[k]) {
-			if len(delmap[k][v]) < threshold {
-				continue
-			}
-			// k + 1 to get things in 1-based coordinates
-			c1 := strconv.Itoa(k + 1)
-			c2 := strconv.Itoa(v)
-			c3 := strings.Join(delmap[k][v], "|")
+	err = t.eachByPosition(byCount, func(k indelKey, samples []string, count int) error {
 
-			_, err = f.WriteString(c1 + "\t" + c2 + "\t" + c3 + "\n")
-			if err != nil {
-				return err
-			}
+		if count < threshold {
+			return nil
+		}
+
+		r, stats, files, annotated := annotations(ref, t, k)
+
+		event := delEvent{RefStart: k.pos + 1, Length: k.length, Count: count, Repeat: r, Reads: stats, Files: files}
+		if !noSamples {
+			event.Samples = samples
 		}
+
+		// k.pos + 1 to get things in 1-based coordinates
+		return report.write(append([]string{strconv.Itoa(k.pos + 1), strconv.Itoa(k.length)}, annotated...), samples, count, event)
+	})
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return report.close()
 }
 
-func Indels(samFile string, insOut string, delOut string, threshold int) error {
-	cErr := make(chan error)
+// readSamIndels sends the indels in a SAM file, the file'th input, to cIns and cDel, with the
+// records parsed by threads workers. useHeader is given the file's header before any of the
+// records are parsed, and returns the reference to left-align the indels against (or nil). The
+// reader and the workers are stages of pl, whose errors are theirs, and they have all returned
+// by the time this does
+func readSamIndels(samFile string, file int, skipCorrupt bool, missingSeq string, threads int, useHeader func(biogosam.Header) ([]byte, error), cIns chan indelKey, cDel chan indelKey, pl *pipeline.Pipeline) {
+
+	cSH := make(chan biogosam.Header, 1)
+	cSR := make(chan biogosam.Record, threads)
+
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		pl.Run(func() {
+			defer wg.Done()
+			f()
+		})
+	}
+	defer wg.Wait()
+
+	// cSR is closed once the reader has returned, whether it got to the end of the file or not
+	run(func() {
+		getSamRecords(samFile, skipCorrupt, missingSeq, cSH, cSR, pl.Errs, pl.Stop)
+		close(cSR)
+	})
+
+	var header biogosam.Header
+	select {
+	case header = <-cSH:
+	case <-pl.Stop:
+		return
+	}
 
-	cSR := make(chan biogosam.Record, runtime.NumCPU())
+	alignTo, err := useHeader(header)
+	if err != nil {
+		pl.Fail(err)
+		return
+	}
 
-	cIns := make(chan insOccurrence)
-	cDel := make(chan delOccurrence)
+	for n := 0; n < threads; n++ {
+		run(func() { getIndels(cSR, alignTo, file, cIns, cDel, pl.Errs) })
+	}
+}
 
-	cInsMap := make(chan map[int]map[string][]string)
-	cDelMap := make(chan map[int]map[int][]string)
+// IndelsOptions are the options of Indels. DefaultIndelsOptions returns them as gofasta sam
+// indels's flags default to, which is where callers should start from
+type IndelsOptions struct {
+	// if ReferenceFile isn't empty, the homopolymer or short tandem repeat in it that each
+	// indel is in is written too (see repeatContext), and if Normalize, each indel is
+	// left-aligned against it before it is counted (see leftAlign), so that the same indel is
+	// counted together however it was aligned
+	ReferenceFile string
+	Normalize     bool
+
+	// only the indels that are in at least Threshold queries are written, in Format (tsv, csv
+	// or json). If NoSamples, the number of queries with each one is written instead of their
+	// names. The indels at each position are sorted by sequence (or length), or by count if
+	// SortBy is "count". If WithReadStats, how the reads with each indel are spread is written
+	// too (see readStats)
+	Threshold     int
+	Format        string
+	NoSamples     bool
+	SortBy        string
+	WithReadStats bool
+
+	// if SkipCorrupt, malformed SAM records are skipped instead of being an error. Records
+	// without a SEQ are skipped or masked according to MissingSeq
+	SkipCorrupt bool
+	MissingSeq  string
+
+	// Threads is the number of workers that parse the SAM records, or one per CPU if it is <= 0
+	Threads int
+}
 
-	cReadDone := make(chan bool)
-	cInDelsDone := make(chan bool)
+// DefaultIndelsOptions returns the options that gofasta sam indels uses if none of its flags
+// are given
+func DefaultIndelsOptions() IndelsOptions {
+	return IndelsOptions{
+		Threshold:  2,
+		Format:     "tsv",
+		SortBy:     "sequence",
+		MissingSeq: "skip",
+	}
+}
 
-	go getSamRecords(samFile, cSR, cReadDone, cErr)
+// Indels writes the insertions and deletions in some SAM files, as set out by opts (see
+// IndelsOptions), to insOut and delOut. Unless opts.NoSamples, the occurrences are spilled to
+// temporary files as they are collected (see indelTable), and otherwise memory use only
+// depends on the number of distinct indels. If insFasta isn't empty, every insertion is also
+// written there in fasta format (see writeInsFasta). Any of samFiles can instead be a tsv
+// insertions or deletions report from an earlier run (see readIndelReport), so that the indels
+// in batches of SAM files can be merged; with more than one input, which of them each indel
+// is in is written too
+func Indels(samFiles []string, insOut string, delOut string, insFasta string, opts IndelsOptions) error {
 
-	var wgInDels sync.WaitGroup
-	wgInDels.Add(runtime.NumCPU())
+	defer profiling.Region("sam indels")()
 
-	for n := 0; n < runtime.NumCPU(); n++ {
-		go func() {
-			getIndels(cSR, cIns, cDel, cErr)
-			wgInDels.Done()
-		}()
+	if opts.Threads <= 0 {
+		opts.Threads = runtime.NumCPU()
 	}
 
-	go populateInsMap(cIns, cInsMap, cErr)
-	go populateDelMap(cDel, cDelMap, cErr)
+	err := checkIndelsFormat(opts.Format)
+	if err != nil {
+		return err
+	}
 
-	go func() {
-		wgInDels.Wait()
-		cInDelsDone<- true
-	}()
+	if opts.SortBy != "sequence" && opts.SortBy != "count" {
+		return usage.Errorf("unrecognised --sort: %s (choose one of: sequence, count)", opts.SortBy)
+	}
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
+	err = checkMissingSeq(opts.MissingSeq)
+	if err != nil {
+		return err
+	}
+
+	if opts.Normalize && len(opts.ReferenceFile) == 0 {
+		return usage.New("--left-align moves the indels along the reference, so it needs the --reference")
+	}
+
+	// which of the inputs are reports from earlier runs, rather than SAM files
+	reports := make([]bool, len(samFiles))
+	for i, infile := range samFiles {
+		reports[i], err = isIndelReport(infile)
+		if err != nil {
 			return err
-		case <-cReadDone:
-			close(cSR)
-			n--
+		}
+		if reports[i] && opts.WithReadStats {
+			return usage.Errorf("%s is an indel report, which doesn't have the reads that --read-stats needs", infile)
 		}
 	}
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cInDelsDone:
-			close(cIns)
-			close(cDel)
-			n--
+	var files []string
+	if len(samFiles) > 1 {
+		files = samFiles
+	}
+
+	// every stage of the pipeline is waited for before this returns, even if it fails, so
+	// nothing is still writing to the tables' spill files when they are cleaned up
+	pl := pipeline.New()
+
+	cIns := make(chan indelKey, opts.Threads)
+	cDel := make(chan indelKey, opts.Threads)
+
+	insTable := newIndelTable(opts.NoSamples, opts.WithReadStats, files)
+	delTable := newIndelTable(opts.NoSamples, opts.WithReadStats, files)
+	defer insTable.cleanup()
+	defer delTable.cleanup()
+
+	// the insertions fasta has every query with each insertion, in order of query
+	var fastaTable *indelTable
+	if len(insFasta) > 0 {
+		fastaTable = newQueryTable()
+		defer fastaTable.cleanup()
+	}
+
+	pl.Run(func() { populateIndelTable(cIns, insTable, fastaTable, pl.Errs) })
+	pl.Run(func() { populateIndelTable(cDel, delTable, nil, pl.Errs) })
+
+	// the reference is read with the first header, and each header is checked against it. The
+	// indels are only left-aligned if asked to be
+	var refRecord fastaio.FastaRecord
+	var ref []byte
+	var alignTo []byte
+	useHeader := func(header biogosam.Header) ([]byte, error) {
+		if len(opts.ReferenceFile) == 0 {
+			return nil, nil
+		}
+		if ref == nil {
+			r, err := readReference(opts.ReferenceFile, header)
+			if err != nil {
+				return nil, err
+			}
+			refRecord = r
+			ref = []byte(strings.ToUpper(refRecord.Seq))
+			if opts.Normalize {
+				alignTo = ref
+			}
 		}
+		return alignTo, checkReference(header, refRecord)
 	}
 
-	var insertionmap map[int]map[string][]string
-	var deletionmap map[int]map[int][]string
+	// the inputs are read one after another, until one of them fails
+	for i, infile := range samFiles {
 
-	for n := 2; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case insertionmap = <-cInsMap:
-			// close(cInsMap)
-			n--
-		case deletionmap = <-cDelMap:
-			// close(cDelMap)
-			n--
+		if pl.Failed() {
+			break
+		}
+
+		if !reports[i] {
+			readSamIndels(infile, i, opts.SkipCorrupt, opts.MissingSeq, opts.Threads, useHeader, cIns, cDel, pl)
+			continue
+		}
+
+		alignTo, err := useHeader(biogosam.Header{})
+		if err == nil {
+			err = readIndelReport(infile, i, alignTo, cIns, cDel)
+		}
+		if err != nil {
+			pl.Fail(err)
 		}
 	}
 
-	err := writeInsMap(insOut, insertionmap, threshold)
+	close(cIns)
+	close(cDel)
+
+	err = pl.Wait()
 	if err != nil {
 		return err
 	}
 
-	err = writeDelMap(delOut, deletionmap, threshold)
+	if fastaTable != nil {
+		err = writeInsFasta(insFasta, fastaTable)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeInsertions(insOut, ref, insTable, opts.Threshold, opts.Format, opts.NoSamples, opts.SortBy == "count")
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return writeDeletions(delOut, ref, delTable, opts.Threshold, opts.Format, opts.NoSamples, opts.SortBy == "count")
 }
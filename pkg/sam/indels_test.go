@@ -0,0 +1,700 @@
+package sam
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+func TestIndelsInsertionsFasta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:20\n" +
+		"q2\t0\tref\t1\t60\t5M3I5M\t*\t0\t0\tACGTAGGGCGTAC\t*\n" +
+		"q1\t0\tref\t3\t60\t2M2I4M1I2M\t*\t0\t0\tGTTTACGTAAC\t*\n"
+	err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insFasta := filepath.Join(dir, "insertions.fasta")
+
+	opts := DefaultIndelsOptions()
+	opts.Threads = 2
+	err = Indels([]string{samFile}, filepath.Join(dir, "insertions.txt"), filepath.Join(dir, "deletions.txt"), insFasta, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := ioutil.ReadFile(insFasta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ">q1:5:2\nTT\n>q1:9:1\nA\n>q2:6:3\nGGG\n"
+	if string(written) != expected {
+		t.Errorf("problem in TestIndelsInsertionsFasta: got\n%s\nexpected\n%s", written, expected)
+	}
+}
+
+func TestIndelsFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:20\n" +
+		"q1\t0\tref\t1\t60\t5M3I2M2D3M\t*\t0\t0\tACGTAGGGCGTAC\t*\n" +
+		"q2\t0\tref\t1\t60\t5M3I2M2D3M\t*\t0\t0\tACGTAGGGCGTAC\t*\n" +
+		"q3\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTATCGTA\t*\n"
+	err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format    string
+		noSamples bool
+		ins       string
+		del       string
+	}{
+		{"tsv", false,
+			"##" + version.Provenance() + "\nref_start\tinsertion\tsamples\n6\tGGG\tq1|q2\n6\tT\tq3\n",
+			"##" + version.Provenance() + "\nref_start\tlength\tsamples\n8\t2\tq1|q2\n"},
+		{"csv", true,
+			"ref_start,insertion,count\n6,GGG,2\n6,T,1\n",
+			"ref_start,length,count\n8,2,2\n"},
+		{"json", false,
+			"[\n{\"ref_start\":6,\"insertion\":\"GGG\",\"count\":2,\"samples\":[\"q1\",\"q2\"]},\n{\"ref_start\":6,\"insertion\":\"T\",\"count\":1,\"samples\":[\"q3\"]}\n]\n",
+			"[\n{\"ref_start\":8,\"length\":2,\"count\":2,\"samples\":[\"q1\",\"q2\"]}\n]\n"},
+		{"json", true,
+			"[\n{\"ref_start\":6,\"insertion\":\"GGG\",\"count\":2},\n{\"ref_start\":6,\"insertion\":\"T\",\"count\":1}\n]\n",
+			"[\n{\"ref_start\":8,\"length\":2,\"count\":2}\n]\n"},
+	}
+
+	insOut := filepath.Join(dir, "insertions")
+	delOut := filepath.Join(dir, "deletions")
+
+	for _, test := range tests {
+		opts := DefaultIndelsOptions()
+		opts.Threshold = 1
+		opts.Format = test.format
+		opts.NoSamples = test.noSamples
+		opts.Threads = 2
+		err = Indels([]string{samFile}, insOut, delOut, "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ins, _ := ioutil.ReadFile(insOut)
+		del, _ := ioutil.ReadFile(delOut)
+
+		if string(ins) != test.ins {
+			t.Errorf("problem in TestIndelsFormats (%s): insertions were\n%s\nexpected\n%s", test.format, ins, test.ins)
+		}
+		if string(del) != test.del {
+			t.Errorf("problem in TestIndelsFormats (%s): deletions were\n%s\nexpected\n%s", test.format, del, test.del)
+		}
+	}
+
+	opts := DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.Format = "xml"
+	opts.Threads = 2
+	err = Indels([]string{samFile}, insOut, delOut, "", opts)
+	if err == nil {
+		t.Errorf("problem in TestIndelsFormats: expected an error for --format xml")
+	}
+}
+
+func TestIndelsSortByCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:20\n" +
+		"q1\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTAACGTA\t*\n" +
+		"q2\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTATCGTA\t*\n" +
+		"q3\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTATCGTA\t*\n"
+	err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insOut := filepath.Join(dir, "insertions")
+	delOut := filepath.Join(dir, "deletions")
+
+	for sortBy, expected := range map[string]string{
+		"sequence": "##" + version.Provenance() + "\nref_start\tinsertion\tsamples\n6\tA\tq1\n6\tT\tq2|q3\n",
+		"count":    "##" + version.Provenance() + "\nref_start\tinsertion\tsamples\n6\tT\tq2|q3\n6\tA\tq1\n",
+	} {
+		opts := DefaultIndelsOptions()
+		opts.Threshold = 1
+		opts.SortBy = sortBy
+		opts.Threads = 2
+		err = Indels([]string{samFile}, insOut, delOut, "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ins, _ := ioutil.ReadFile(insOut)
+		if string(ins) != expected {
+			t.Errorf("problem in TestIndelsSortByCount (--sort %s): got\n%s\nexpected\n%s", sortBy, ins, expected)
+		}
+	}
+}
+
+// The default order doesn't depend on the order of the records: the indels at a position
+// are ordered by sequence (or length), and the samples in a row by name
+func TestIndelsSortedOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:20\n" +
+		"q3\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTATCGTA\t*\n" +
+		"q4\t0\tref\t1\t60\t4M3D3M\t*\t0\t0\tACGTTAC\t*\n" +
+		"q2\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTAACGTA\t*\n" +
+		"q1\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTATCGTA\t*\n" +
+		"q0\t0\tref\t1\t60\t4M1D5M\t*\t0\t0\tACGTCGTAC\t*\n"
+	err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insOut := filepath.Join(dir, "insertions")
+	delOut := filepath.Join(dir, "deletions")
+
+	expectedIns := "##" + version.Provenance() + "\nref_start\tinsertion\tsamples\n6\tA\tq2\n6\tT\tq1|q3\n"
+	expectedDel := "##" + version.Provenance() + "\nref_start\tlength\tsamples\n5\t1\tq0\n5\t3\tq4\n"
+
+	for _, threads := range []int{1, 4} {
+		opts := DefaultIndelsOptions()
+		opts.Threshold = 1
+		opts.Threads = threads
+		err = Indels([]string{samFile}, insOut, delOut, "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ins, _ := ioutil.ReadFile(insOut)
+		if string(ins) != expectedIns {
+			t.Errorf("problem in TestIndelsSortedOrder (%d threads): got\n%s\nexpected\n%s", threads, ins, expectedIns)
+		}
+		del, _ := ioutil.ReadFile(delOut)
+		if string(del) != expectedDel {
+			t.Errorf("problem in TestIndelsSortedOrder (%d threads): got\n%s\nexpected\n%s", threads, del, expectedDel)
+		}
+	}
+}
+
+func TestIndelsReadStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// r1 and r2 are on different strands, but span the same part of the reference, and r3
+	// starts later but ends in the same place
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:20\n" +
+		"r1\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTATCGTA\t*\n" +
+		"r2\t16\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTATCGTA\t*\n" +
+		"r3\t0\tref\t3\t60\t3M1I4M\t*\t0\t0\tGTATCGTA\t*\n" +
+		"r4\t16\tref\t1\t60\t4M2D4M\t*\t0\t0\tACGTCGTA\t*\n"
+	err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insOut := filepath.Join(dir, "insertions")
+	delOut := filepath.Join(dir, "deletions")
+
+	tests := []struct {
+		format    string
+		noSamples bool
+		ins       string
+		del       string
+	}{
+		{"tsv", false,
+			"##" + version.Provenance() + "\nref_start\tinsertion\tforward\treverse\tsame_start\tsame_end\tsamples\n6\tT\t2\t1\tfalse\ttrue\tr1|r2|r3\n",
+			"##" + version.Provenance() + "\nref_start\tlength\tforward\treverse\tsame_start\tsame_end\tsamples\n5\t2\t0\t1\ttrue\ttrue\tr4\n"},
+		{"json", true,
+			"[\n{\"ref_start\":6,\"insertion\":\"T\",\"count\":3,\"reads\":{\"forward\":2,\"reverse\":1,\"same_start\":false,\"same_end\":true}}\n]\n",
+			"[\n{\"ref_start\":5,\"length\":2,\"count\":1,\"reads\":{\"forward\":0,\"reverse\":1,\"same_start\":true,\"same_end\":true}}\n]\n"},
+	}
+
+	for _, test := range tests {
+		opts := DefaultIndelsOptions()
+		opts.Threshold = 1
+		opts.Format = test.format
+		opts.NoSamples = test.noSamples
+		opts.WithReadStats = true
+		opts.Threads = 2
+		err = Indels([]string{samFile}, insOut, delOut, "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ins, _ := ioutil.ReadFile(insOut)
+		del, _ := ioutil.ReadFile(delOut)
+		if string(ins) != test.ins {
+			t.Errorf("problem in TestIndelsReadStats (%s): insertions were\n%s\nexpected\n%s", test.format, ins, test.ins)
+		}
+		if string(del) != test.del {
+			t.Errorf("problem in TestIndelsReadStats (%s): deletions were\n%s\nexpected\n%s", test.format, del, test.del)
+		}
+	}
+}
+
+func TestIndelsThreads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:20\n" +
+		"q1\t0\tref\t1\t60\t5M3I2M2D3M\t*\t0\t0\tACGTAGGGCGTAC\t*\n" +
+		"q2\t0\tref\t1\t60\t5M3I2M2D3M\t*\t0\t0\tACGTAGGGCGTAC\t*\n" +
+		"q3\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTATCGTA\t*\n" +
+		"q4\t0\tref\t1\t60\t5M1I4M\t*\t0\t0\tACGTATCGTA\t*\n"
+	err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insOut := filepath.Join(dir, "insertions")
+	delOut := filepath.Join(dir, "deletions")
+
+	expectedIns := "##" + version.Provenance() + "\nref_start\tinsertion\tsamples\n6\tGGG\tq1|q2\n6\tT\tq3|q4\n"
+	expectedDel := "##" + version.Provenance() + "\nref_start\tlength\tsamples\n8\t2\tq1|q2\n"
+
+	for _, threads := range []int{0, 1, 8} {
+		opts := DefaultIndelsOptions()
+		opts.Threads = threads
+		err = Indels([]string{samFile}, insOut, delOut, "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ins, _ := ioutil.ReadFile(insOut)
+		if string(ins) != expectedIns {
+			t.Errorf("problem in TestIndelsThreads (%d threads): got\n%s\nexpected\n%s", threads, ins, expectedIns)
+		}
+		del, _ := ioutil.ReadFile(delOut)
+		if string(del) != expectedDel {
+			t.Errorf("problem in TestIndelsThreads (%d threads): got\n%s\nexpected\n%s", threads, del, expectedDel)
+		}
+	}
+}
+
+func TestRepeatContext(t *testing.T) {
+	ref := []byte("CAAAAGTATATGCTCAG")
+
+	tests := []struct {
+		k        indelKey
+		expected repeat
+	}{
+		// deleting an A from the homopolymer, wherever in it the aligner put the deletion
+		{indelKey{pos: 1, length: 1}, repeat{"A", 4}},
+		{indelKey{pos: 3, length: 2}, repeat{"A", 4}},
+		// inserting an A next to it, at either end
+		{indelKey{pos: 1, seq: "A"}, repeat{"A", 4}},
+		{indelKey{pos: 5, seq: "AA"}, repeat{"A", 4}},
+		// the dinucleotide repeat TATAT, out of phase
+		{indelKey{pos: 7, length: 2}, repeat{"TA", 5}},
+		{indelKey{pos: 8, seq: "TA"}, repeat{"TA", 5}},
+		{indelKey{pos: 8, seq: "AT"}, repeat{}},
+		// a single copy of a unit isn't a repeat
+		{indelKey{pos: 12, length: 3}, repeat{}},
+		{indelKey{pos: 12, seq: "G"}, repeat{}},
+		{indelKey{pos: 5, seq: "AC"}, repeat{}},
+		{indelKey{pos: 17, seq: "GG"}, repeat{}},
+		{indelKey{pos: 30, length: 1}, repeat{}},
+	}
+
+	for _, test := range tests {
+		r := repeatContext(ref, test.k)
+		if r != test.expected {
+			t.Errorf("problem in TestRepeatContext: %+v: got %+v, expected %+v", test.k, r, test.expected)
+		}
+	}
+}
+
+func TestIndelsRepeats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	refFile := filepath.Join(dir, "ref.fasta")
+	err = ioutil.WriteFile(refFile, []byte(">ref\nACGTAAAACGTA\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n" +
+		"q1\t0\tref\t1\t60\t4M1D7M\t*\t0\t0\tACGTAAACGTA\t*\n" +
+		"q2\t0\tref\t1\t60\t2M1I10M\t*\t0\t0\tACTGTAAAACGTA\t*\n"
+	err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insOut := filepath.Join(dir, "insertions")
+	delOut := filepath.Join(dir, "deletions")
+
+	opts := DefaultIndelsOptions()
+	opts.ReferenceFile = refFile
+	opts.Threshold = 1
+	opts.Threads = 2
+	err = Indels([]string{samFile}, insOut, delOut, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedIns := "##" + version.Provenance() + "\nref_start\tinsertion\trepeat_unit\trepeat_length\tsamples\n3\tT\t\t0\tq2\n"
+	expectedDel := "##" + version.Provenance() + "\nref_start\tlength\trepeat_unit\trepeat_length\tsamples\n5\t1\tA\t4\tq1\n"
+	ins, _ := ioutil.ReadFile(insOut)
+	del, _ := ioutil.ReadFile(delOut)
+	if string(ins) != expectedIns {
+		t.Errorf("problem in TestIndelsRepeats: insertions were\n%s\nexpected\n%s", ins, expectedIns)
+	}
+	if string(del) != expectedDel {
+		t.Errorf("problem in TestIndelsRepeats: deletions were\n%s\nexpected\n%s", del, expectedDel)
+	}
+}
+
+func TestLeftAlign(t *testing.T) {
+	ref := []byte("CAAAAGTATATGCTCAG")
+
+	tests := []struct {
+		k        indelKey
+		expected indelKey
+	}{
+		// deleting an A from the homopolymer
+		{indelKey{pos: 3, length: 1}, indelKey{pos: 1, length: 1}},
+		{indelKey{pos: 1, length: 1}, indelKey{pos: 1, length: 1}},
+		// deleting a TA from TATAT
+		{indelKey{pos: 8, length: 2}, indelKey{pos: 6, length: 2}},
+		// inserting a TA into TATAT, which is rotated as it moves
+		{indelKey{pos: 10, seq: "TA"}, indelKey{pos: 6, seq: "TA"}},
+		{indelKey{pos: 9, seq: "AT"}, indelKey{pos: 6, seq: "TA"}},
+		{indelKey{pos: 5, seq: "A"}, indelKey{pos: 1, seq: "A"}},
+		// indels that can't move
+		{indelKey{pos: 11, length: 1}, indelKey{pos: 11, length: 1}},
+		{indelKey{pos: 12, seq: "GC"}, indelKey{pos: 12, seq: "GC"}},
+		{indelKey{pos: 0, seq: "C"}, indelKey{pos: 0, seq: "C"}},
+		{indelKey{pos: 16, length: 2}, indelKey{pos: 16, length: 2}},
+		{indelKey{pos: 20, seq: "G"}, indelKey{pos: 20, seq: "G"}},
+	}
+
+	for _, test := range tests {
+		k := leftAlign(ref, test.k)
+		if k != test.expected {
+			t.Errorf("problem in TestLeftAlign: %+v: got %+v, expected %+v", test.k, k, test.expected)
+		}
+	}
+}
+
+func TestIndelsLeftAlign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	refFile := filepath.Join(dir, "ref.fasta")
+	err = ioutil.WriteFile(refFile, []byte(">ref\nACGTAAAACGTA\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same deletion and insertion of an A, put at either end of AAAA
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n" +
+		"q1\t0\tref\t1\t60\t4M1D7M\t*\t0\t0\tACGTAAACGTA\t*\n" +
+		"q2\t0\tref\t1\t60\t7M1D4M\t*\t0\t0\tACGTAAACGTA\t*\n" +
+		"q3\t0\tref\t1\t60\t4M1I8M\t*\t0\t0\tACGTAAAAACGTA\t*\n" +
+		"q4\t0\tref\t1\t60\t8M1I4M\t*\t0\t0\tACGTAAAAACGTA\t*\n"
+	err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insOut := filepath.Join(dir, "insertions")
+	delOut := filepath.Join(dir, "deletions")
+
+	tests := []struct {
+		normalize   bool
+		expectedIns string
+		expectedDel string
+	}{
+		{false, "5\tA\tA\t4\tq3\n9\tA\tA\t4\tq4\n", "5\t1\tA\t4\tq1\n8\t1\tA\t4\tq2\n"},
+		{true, "5\tA\tA\t4\tq3|q4\n", "5\t1\tA\t4\tq1|q2\n"},
+	}
+
+	for _, test := range tests {
+		opts := DefaultIndelsOptions()
+		opts.ReferenceFile = refFile
+		opts.Threshold = 1
+		opts.Normalize = test.normalize
+		opts.Threads = 2
+		err = Indels([]string{samFile}, insOut, delOut, "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedIns := "##" + version.Provenance() + "\nref_start\tinsertion\trepeat_unit\trepeat_length\tsamples\n" + test.expectedIns
+		expectedDel := "##" + version.Provenance() + "\nref_start\tlength\trepeat_unit\trepeat_length\tsamples\n" + test.expectedDel
+		ins, _ := ioutil.ReadFile(insOut)
+		del, _ := ioutil.ReadFile(delOut)
+		if string(ins) != expectedIns {
+			t.Errorf("problem in TestIndelsLeftAlign: with normalize %t, insertions were\n%s\nexpected\n%s", test.normalize, ins, expectedIns)
+		}
+		if string(del) != expectedDel {
+			t.Errorf("problem in TestIndelsLeftAlign: with normalize %t, deletions were\n%s\nexpected\n%s", test.normalize, del, expectedDel)
+		}
+	}
+
+	opts := DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.Normalize = true
+	opts.Threads = 2
+	err = Indels([]string{samFile}, insOut, delOut, "", opts)
+	if err == nil {
+		t.Errorf("problem in TestIndelsLeftAlign: expected an error without a reference")
+	}
+}
+
+func TestIndelsMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	header := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n"
+	a := filepath.Join(dir, "a.sam")
+	err = ioutil.WriteFile(a, []byte(header+
+		"q1\t0\tref\t1\t60\t4M1D7M\t*\t0\t0\tACGTAAACGTA\t*\n"+
+		"q2\t0\tref\t1\t60\t2M1I10M\t*\t0\t0\tACTGTAAAACGTA\t*\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := filepath.Join(dir, "b.sam")
+	err = ioutil.WriteFile(b, []byte(header+
+		"q3\t0\tref\t1\t60\t4M1D7M\t*\t0\t0\tACGTAAACGTA\t*\n"+
+		"q4\t0\tref\t1\t60\t12M\t*\t0\t0\tACGTAAAACGTA\t*\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insOut := filepath.Join(dir, "insertions")
+	delOut := filepath.Join(dir, "deletions")
+	aIns := filepath.Join(dir, "a_insertions")
+	aDel := filepath.Join(dir, "a_deletions")
+
+	check := func(name string, expectedIns string, expectedDel string) {
+		expectedIns = "##" + version.Provenance() + "\nref_start\tinsertion\tfiles\t" + expectedIns
+		expectedDel = "##" + version.Provenance() + "\nref_start\tlength\tfiles\t" + expectedDel
+		ins, _ := ioutil.ReadFile(insOut)
+		del, _ := ioutil.ReadFile(delOut)
+		if string(ins) != expectedIns {
+			t.Errorf("problem in TestIndelsMerge: %s: insertions were\n%s\nexpected\n%s", name, ins, expectedIns)
+		}
+		if string(del) != expectedDel {
+			t.Errorf("problem in TestIndelsMerge: %s: deletions were\n%s\nexpected\n%s", name, del, expectedDel)
+		}
+	}
+
+	// two SAM files
+	opts := DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.Threads = 2
+	err = Indels([]string{a, b}, insOut, delOut, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("two SAM files", "samples\n3\tT\t"+a+"\tq2\n", "samples\n5\t1\t"+a+"|"+b+"\tq1|q3\n")
+
+	// the reports from one of them, and the other
+
+	opts = DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.Threads = 2
+	err = Indels([]string{a}, aIns, aDel, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.Threads = 2
+	err = Indels([]string{aDel, b, aIns}, insOut, delOut, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("reports", "samples\n3\tT\t"+aIns+"\tq2\n", "samples\n5\t1\t"+aDel+"|"+b+"\tq1|q3\n")
+
+	// reports with counts
+
+	opts = DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.NoSamples = true
+	opts.Threads = 2
+	err = Indels([]string{a}, aIns, aDel, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.NoSamples = true
+	opts.Threads = 2
+	err = Indels([]string{aIns, aDel, b}, insOut, delOut, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("reports with counts", "count\n3\tT\t"+aIns+"\t1\n", "count\n5\t1\t"+aDel+"|"+b+"\t2\n")
+
+	opts = DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.Threads = 2
+	err = Indels([]string{aIns, aDel, b}, insOut, delOut, "", opts)
+	if err == nil {
+		t.Errorf("problem in TestIndelsMerge: expected an error merging reports with counts into a report with samples")
+	}
+
+	opts = DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.WithReadStats = true
+	opts.Threads = 2
+	err = Indels([]string{aIns, b}, insOut, delOut, "", opts)
+	if err == nil {
+		t.Errorf("problem in TestIndelsMerge: expected an error with --read-stats and a report")
+	}
+}
+
+// names with "|"s in them (as GISAID's have) come back out of a report as they went in
+func TestIndelsMergeNames(t *testing.T) {
+	dir := t.TempDir()
+
+	gisaid := "hCoV-19/England/ABCD-123/2020|EPI_ISL_123|2020-03-01"
+	percent := "q%7C2"
+
+	header := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n"
+	a := filepath.Join(dir, "a.sam")
+	err := ioutil.WriteFile(a, []byte(header+
+		gisaid+"\t0\tref\t1\t60\t4M1D7M\t*\t0\t0\tACGTAAACGTA\t*\n"+
+		percent+"\t0\tref\t1\t60\t4M1D7M\t*\t0\t0\tACGTAAACGTA\t*\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := filepath.Join(dir, "b.sam")
+	err = ioutil.WriteFile(b, []byte(header+
+		"q3\t0\tref\t1\t60\t4M1D7M\t*\t0\t0\tACGTAAACGTA\t*\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aIns := filepath.Join(dir, "a_insertions")
+	aDel := filepath.Join(dir, "a_deletions")
+	insOut := filepath.Join(dir, "insertions")
+	delOut := filepath.Join(dir, "deletions")
+
+	opts := DefaultIndelsOptions()
+	opts.Threshold = 1
+	opts.Threads = 2
+	err = Indels([]string{a}, aIns, aDel, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	del, err := ioutil.ReadFile(aDel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "##" + version.Provenance() + "\nref_start\tlength\tsamples\n" +
+		"5\t1\thCoV-19/England/ABCD-123/2020%7CEPI_ISL_123%7C2020-03-01|q%257C2\n"
+	if string(del) != expected {
+		t.Errorf("problem in TestIndelsMergeNames: the deletions were\n%s\nexpected\n%s", del, expected)
+	}
+
+	opts.Format = "json"
+	err = Indels([]string{aDel, b}, insOut, delOut, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	del, err = ioutil.ReadFile(delOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []delEvent
+	err = json.Unmarshal(del, &events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []string{gisaid, percent, "q3"}
+	if len(events) != 1 || events[0].Count != 3 || !reflect.DeepEqual(events[0].Samples, samples) {
+		t.Errorf("problem in TestIndelsMergeNames: the merged deletions were %s, expected %v", del, samples)
+	}
+}
+
+// a corrupt record halfway through the input is an error, and the stages that were still reading
+// the rest of it, or counting its indels, are stopped rather than left running
+func TestIndelsCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:20\n"
+	for i := 0; i < 1000; i++ {
+		if i == 500 {
+			sam += "bad\t0\tref\t1\t60\t5M\t*\t0\t0\tACG\t*\n"
+		}
+		sam += fmt.Sprintf("q%d\t0\tref\t1\t60\t5M3I2M2D3M\t*\t0\t0\tACGTAGGGCGTAC\t*\n", i)
+	}
+	err := ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+
+	for _, threads := range []int{-1, 1} {
+		opts := DefaultIndelsOptions()
+		opts.Threads = threads
+		err = Indels([]string{samFile}, filepath.Join(dir, "insertions"), filepath.Join(dir, "deletions"), "", opts)
+		if err == nil {
+			t.Errorf("problem in TestIndelsCorruptRecord (%d threads): expected an error", threads)
+		}
+	}
+
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Errorf("problem in TestIndelsCorruptRecord: %d goroutines are running after the failed runs, %d before", runtime.NumGoroutine(), before)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,456 @@
+package sam
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// the bases that are tallied at each site, in the order they are stored in siteCounts
+var pileupBases = []byte("ACGT")
+
+// pileupIndex is the index of a base in pileupBases, or -1 if it isn't tallied
+func pileupIndex(b byte) int {
+	switch b {
+	case 'A', 'a':
+		return 0
+	case 'C', 'c':
+		return 1
+	case 'G', 'g':
+		return 2
+	case 'T', 't':
+		return 3
+	}
+	return -1
+}
+
+// siteCounts is the number of reads with each base at one site, on the forward [0]
+// and reverse [1] strands
+type siteCounts [2][4]int32
+
+func (c *siteCounts) count(b int) int {
+	return int(c[0][b] + c[1][b])
+}
+
+func (c *siteCounts) depth() int {
+	d := 0
+	for b := range pileupBases {
+		d += c.count(b)
+	}
+	return d
+}
+
+// pileup is the base counts at every site of a refLen-long reference, for each sample,
+// and the number of reads with a deletion at each site. Any number of goroutines can add
+// reads to it at once
+type pileup struct {
+	mu        sync.Mutex
+	refLen    int
+	samples   map[string][]siteCounts
+	deletions map[string][]int32
+}
+
+func newPileup(refLen int) *pileup {
+	return &pileup{refLen: refLen, samples: make(map[string][]siteCounts), deletions: make(map[string][]int32)}
+}
+
+// counts returns a sample's counts, adding the sample if it is new
+func (p *pileup) counts(sample string) []siteCounts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, ok := p.samples[sample]
+	if !ok {
+		c = make([]siteCounts, p.refLen)
+		p.samples[sample] = c
+		p.deletions[sample] = make([]int32, p.refLen)
+	}
+	return c
+}
+
+// addRecord adds the bases of one read that are aligned to the reference to a sample's
+// counts, and its deletions to the sample's deletion counts. Insertions, clips and
+// ambiguous (or masked) bases aren't counted
+func (p *pileup) addRecord(rec biogosam.Record, sample string) {
+
+	counts := p.counts(sample)
+	p.mu.Lock()
+	deletions := p.deletions[sample]
+	p.mu.Unlock()
+
+	strand := 0
+	if rec.Flags&biogosam.Reverse != 0 {
+		strand = 1
+	}
+
+	qpos := 0
+	rpos := rec.Pos
+
+	for _, op := range rec.Cigar {
+		size := op.Len()
+		consumes := op.Type().Consumes()
+
+		if consumes.Query == 1 && consumes.Reference == 1 {
+			for i := 0; i < size && rpos+i < p.refLen; i++ {
+				b := pileupIndex(rec.Seq.At(qpos + i))
+				if b >= 0 {
+					atomic.AddInt32(&counts[rpos+i][strand][b], 1)
+				}
+			}
+		}
+
+		if op.Type() == biogosam.CigarDeletion {
+			for i := 0; i < size && rpos+i < p.refLen; i++ {
+				atomic.AddInt32(&deletions[rpos+i], 1)
+			}
+		}
+
+		qpos += size * consumes.Query
+		rpos += size * consumes.Reference
+	}
+}
+
+// sampleNames returns the samples in the pileup, in alphabetical order
+func (p *pileup) sampleNames() []string {
+	names := make([]string, 0, len(p.samples))
+	for name := range p.samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readGroupSamples maps the ID of each read group in a SAM header to its sample (SM),
+// or to the ID itself if the read group doesn't name a sample
+func readGroupSamples(header biogosam.Header) map[string]string {
+
+	samples := make(map[string]string)
+	sm := biogosam.NewTag("SM")
+
+	for _, rg := range header.RGs() {
+		sample := rg.Get(sm)
+		if len(sample) == 0 {
+			sample = rg.Name()
+		}
+		samples[rg.Name()] = sample
+	}
+
+	return samples
+}
+
+// recordSample is the sample that a read comes from: the sample of its read group (RG tag)
+// if it has one, otherwise defaultSample
+func recordSample(rec biogosam.Record, rgSamples map[string]string, defaultSample string) string {
+
+	aux := rec.AuxFields.Get(biogosam.NewTag("RG"))
+	if aux == nil {
+		return defaultSample
+	}
+
+	rg := fmt.Sprint(aux.Value())
+	if sample, ok := rgSamples[rg]; ok {
+		return sample
+	}
+
+	return rg
+}
+
+// blockToPileup is a worker that adds every record in the blocks from cSR to p
+func blockToPileup(cSR chan samRecords, p *pileup, rgSamples map[string]string, defaultSample string) {
+	for group := range cSR {
+		for _, rec := range group.records {
+			p.addRecord(rec, recordSample(rec, rgSamples, defaultSample))
+		}
+	}
+}
+
+// minorVariant is one non-reference base at one site in one sample
+type minorVariant struct {
+	pos   int
+	ref   byte
+	alt   byte
+	depth int
+	refN  [2]int // reads with the reference base on each strand
+	altN  [2]int // reads with the alternative base on each strand
+}
+
+func (v minorVariant) freq() float64 {
+	return float64(v.altN[0]+v.altN[1]) / float64(v.depth)
+}
+
+// variantAt returns the counts of alt at (0-based) site j in c, where the reference has ref
+func variantAt(c *siteCounts, j int, ref byte, alt int) minorVariant {
+
+	v := minorVariant{pos: j, ref: ref, alt: pileupBases[alt], depth: c.depth()}
+
+	v.altN = [2]int{int(c[0][alt]), int(c[1][alt])}
+	if r := pileupIndex(ref); r >= 0 {
+		v.refN = [2]int{int(c[0][r]), int(c[1][r])}
+	}
+
+	return v
+}
+
+// passes reports whether a variant is frequent enough, at a deep enough site, to report
+func (v minorVariant) passes(minFreq float64, minDepth int) bool {
+	return v.depth > 0 && v.depth >= minDepth && v.altN[0]+v.altN[1] > 0 && v.freq() >= minFreq
+}
+
+// minorVariants returns the variants in one sample that pass minFreq and minDepth, in order of position
+func minorVariants(counts []siteCounts, refSeq []byte, minFreq float64, minDepth int) []minorVariant {
+
+	variants := make([]minorVariant, 0)
+
+	for j := range counts {
+		ref := byte(strings.ToUpper(string(refSeq[j]))[0])
+		for b, alt := range pileupBases {
+			if alt == ref {
+				continue
+			}
+			v := variantAt(&counts[j], j, ref, b)
+			if v.passes(minFreq, minDepth) {
+				variants = append(variants, v)
+			}
+		}
+	}
+
+	return variants
+}
+
+// writeMinorVariantsTSV writes one line per sample per variant, with its depth and
+// frequency, and its counts on each strand
+func writeMinorVariantsTSV(w *bufio.Writer, p *pileup, refSeq []byte, minFreq float64, minDepth int) error {
+
+	w.WriteString("##" + version.Provenance() + "\n")
+	w.WriteString("sample\tpos\tref\talt\tdepth\tref_count\talt_count\talt_freq\talt_fwd\talt_rev\n")
+
+	for _, sample := range p.sampleNames() {
+		for _, v := range minorVariants(p.samples[sample], refSeq, minFreq, minDepth) {
+			_, err := w.WriteString(strings.Join([]string{
+				sample,
+				strconv.Itoa(v.pos + 1),
+				string(v.ref),
+				string(v.alt),
+				strconv.Itoa(v.depth),
+				strconv.Itoa(v.refN[0] + v.refN[1]),
+				strconv.Itoa(v.altN[0] + v.altN[1]),
+				cliio.FormatFreq(v.freq()),
+				strconv.Itoa(v.altN[0]),
+				strconv.Itoa(v.altN[1]),
+			}, "\t") + "\n")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeMinorVariantsVCF writes a multi-sample VCF with one line per site and alternative base
+// that passes the thresholds in at least one sample. Every sample has its depth (DP), reference
+// and alternative counts (AD), alternative frequency (AF) and strand counts (SB) on every line
+func writeMinorVariantsVCF(w *bufio.Writer, p *pileup, refName string, refSeq []byte, minFreq float64, minDepth int) error {
+
+	samples := p.sampleNames()
+
+	// the sites and bases to write, as a set of pos*4+base
+	sites := make(map[int]bool)
+	for _, sample := range samples {
+		for _, v := range minorVariants(p.samples[sample], refSeq, minFreq, minDepth) {
+			sites[v.pos*4+pileupIndex(v.alt)] = true
+		}
+	}
+	keys := make([]int, 0, len(sites))
+	for k := range sites {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	w.WriteString("##fileformat=VCFv4.2\n")
+	w.WriteString("##source=" + version.Provenance() + "\n")
+	w.WriteString("##contig=<ID=" + refName + ",length=" + strconv.Itoa(len(refSeq)) + ">\n")
+	w.WriteString("##FORMAT=<ID=DP,Number=1,Type=Integer,Description=\"Number of reads with A, C, G or T at the site\">\n")
+	w.WriteString("##FORMAT=<ID=AD,Number=R,Type=Integer,Description=\"Number of reads with the reference and alternative bases\">\n")
+	w.WriteString("##FORMAT=<ID=AF,Number=A,Type=Float,Description=\"Frequency of the alternative base\">\n")
+	w.WriteString("##FORMAT=<ID=SB,Number=4,Type=Integer,Description=\"Reads with the reference base on the forward and reverse strands, then the alternative base on the forward and reverse strands\">\n")
+	w.WriteString("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t" + strings.Join(samples, "\t") + "\n")
+
+	for _, k := range keys {
+		j := k / 4
+		ref := byte(strings.ToUpper(string(refSeq[j]))[0])
+		line := []string{refName, strconv.Itoa(j + 1), ".", string(ref), string(pileupBases[k%4]), ".", ".", ".", "DP:AD:AF:SB"}
+
+		for _, sample := range samples {
+			v := variantAt(&p.samples[sample][j], j, ref, k%4)
+			af := "."
+			if v.depth > 0 {
+				af = cliio.FormatFreq(v.freq())
+			}
+			line = append(line, strconv.Itoa(v.depth)+":"+
+				strconv.Itoa(v.refN[0]+v.refN[1])+","+strconv.Itoa(v.altN[0]+v.altN[1])+":"+
+				af+":"+
+				strconv.Itoa(v.refN[0])+","+strconv.Itoa(v.refN[1])+","+strconv.Itoa(v.altN[0])+","+strconv.Itoa(v.altN[1]))
+		}
+
+		_, err := w.WriteString(strings.Join(line, "\t") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// defaultSampleName is the sample for reads without a read group: the SAM file's name
+// without its extension, or "stdin"
+func defaultSampleName(samFile string) string {
+	if len(samFile) == 0 {
+		return "stdin"
+	}
+	base := filepath.Base(samFile)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// MinorVariants tallies the bases at each site of the reference across all the reads from
+// each sample in a SAM file, and writes every non-reference base whose frequency is at least
+// minFreq, at sites with at least minDepth reads, in tsv or vcf format. Reads are assigned
+// to samples by their read group's SM, or are all from sample (if it isn't empty) or a sample
+// named after the SAM file otherwise. Bases with a quality below minQual aren't counted.
+// If skipCorrupt, malformed SAM records are skipped instead of being an error, and records
+// without a SEQ are skipped or masked according to missingSeq.
+func MinorVariants(samFile string, referenceFile string, outfile string, format string, sample string, minFreq float64, minDepth int,
+	minQual int, skipCorrupt bool, missingSeq string, threads int) error {
+
+	defer profiling.Region("sam minorVariants")()
+
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	if format != "tsv" && format != "vcf" {
+		return usage.Errorf("unrecognised --format: %s (choose one of: tsv, vcf)", format)
+	}
+
+	if minFreq < 0 || minFreq > 1 {
+		return usage.Errorf("--min-freq should be between 0 and 1, not %v", minFreq)
+	}
+
+	if len(referenceFile) == 0 {
+		return usage.New("sam minorVariants needs the --reference")
+	}
+
+	err := checkMissingSeq(missingSeq)
+	if err != nil {
+		return err
+	}
+
+	if len(sample) == 0 {
+		sample = defaultSampleName(samFile)
+	}
+
+	cErr := make(chan error)
+
+	cSR := make(chan samRecords, threads)
+	cSH := make(chan biogosam.Header)
+
+	cReadDone := make(chan bool)
+	cPileupDone := make(chan bool)
+
+	go groupSamRecords(samFile, minQual, skipCorrupt, missingSeq, cSH, cSR, cReadDone, cErr)
+
+	var header biogosam.Header
+	select {
+	case err := <-cErr:
+		return err
+	case header = <-cSH:
+	}
+
+	ref, err := readReference(referenceFile, header)
+	if err != nil {
+		return err
+	}
+	refSeq := []byte(ref.Seq)
+
+	err = checkReference(header, ref)
+	if err != nil {
+		return err
+	}
+
+	p := newPileup(len(refSeq))
+	rgSamples := readGroupSamples(header)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+
+	for n := 0; n < threads; n++ {
+		go func() {
+			blockToPileup(cSR, p, rgSamples, sample)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cPileupDone <- true
+	}()
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cReadDone:
+			close(cSR)
+			close(cSH)
+			n--
+		}
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cPileupDone:
+			n--
+		}
+	}
+
+	var f *os.File
+
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+
+	if format == "vcf" {
+		err = writeMinorVariantsVCF(w, p, ref.ID, refSeq, minFreq, minDepth)
+	} else {
+		err = writeMinorVariantsTSV(w, p, refSeq, minFreq, minDepth)
+	}
+	if err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,94 @@
+package sam
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+var minorVariantsSam = "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n@RG\tID:r1\tSM:s1\n@RG\tID:r2\n" +
+	"a1\t0\tref\t1\t60\t4M\t*\t0\t0\tACGT\tIIII\tRG:Z:r1\n" +
+	"a2\t0\tref\t1\t60\t4M\t*\t0\t0\tACGT\tIIII\tRG:Z:r1\n" +
+	"a3\t0\tref\t1\t60\t4M\t*\t0\t0\tACGT\tIIII\tRG:Z:r1\n" +
+	"a4\t16\tref\t1\t60\t4M\t*\t0\t0\tACTT\tIIII\tRG:Z:r1\n" +
+	"b1\t0\tref\t2\t60\t1M1D2M\t*\t0\t0\tCAT\tIII\tRG:Z:r2\n" +
+	"c1\t0\tref\t2\t60\t3M\t*\t0\t0\tCAN\tIII\n"
+
+func TestMinorVariants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "reads.sam")
+	err = ioutil.WriteFile(samFile, []byte(minorVariantsSam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refFile := filepath.Join(dir, "ref.fasta")
+	err = ioutil.WriteFile(refFile, []byte(">ref\nACGTACGTACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "out.tsv")
+
+	err = MinorVariants(samFile, refFile, outFile, "tsv", "", 0.2, 1, 0, false, "skip", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// r2 has no SM, so is its own sample, and c1 has no read group, so is from
+	// the file. c1's N isn't counted, and b1's deletion means that its A is at site 4
+	expected := "##" + version.Provenance() + "\n" +
+		"sample\tpos\tref\talt\tdepth\tref_count\talt_count\talt_freq\talt_fwd\talt_rev\n" +
+		"r2\t4\tT\tA\t1\t0\t1\t1.0000\t1\t0\n" +
+		"r2\t5\tA\tT\t1\t0\t1\t1.0000\t1\t0\n" +
+		"reads\t3\tG\tA\t1\t0\t1\t1.0000\t1\t0\n" +
+		"s1\t3\tG\tT\t4\t3\t1\t0.2500\t0\t1\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestMinorVariants: got\n%s\nexpected\n%s", out, expected)
+	}
+
+	err = MinorVariants(samFile, refFile, outFile, "vcf", "sampleX", 0.3, 1, 0, false, "skip", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err = ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every sample is on every line, whether or not the variant passes in it
+	expectedLines := []string{
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tr2\ts1\tsampleX",
+		"ref\t3\t.\tG\tA\t.\t.\t.\tDP:AD:AF:SB\t0:0,0:.:0,0,0,0\t4:3,0:0.0000:3,0,0,0\t1:0,1:1.0000:0,0,1,0",
+		"ref\t4\t.\tT\tA\t.\t.\t.\tDP:AD:AF:SB\t1:0,1:1.0000:0,0,1,0\t4:4,0:0.0000:3,1,0,0\t0:0,0:.:0,0,0,0",
+		"ref\t5\t.\tA\tT\t.\t.\t.\tDP:AD:AF:SB\t1:0,1:1.0000:0,0,1,0\t0:0,0:.:0,0,0,0\t0:0,0:.:0,0,0,0",
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	lines = lines[len(lines)-len(expectedLines):]
+	for i := range expectedLines {
+		if lines[i] != expectedLines[i] {
+			t.Errorf("problem in TestMinorVariants: got\n%s\nexpected\n%s", lines[i], expectedLines[i])
+		}
+	}
+
+	err = MinorVariants(samFile, refFile, outFile, "bcf", "", 0.2, 1, 0, false, "skip", 2)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestMinorVariants: expected a usage error for --format bcf, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package sam
+
+// leftAlign moves the indel k as far left in ref as it can go while describing the same change
+// to the sequence, as bcftools norm does, so that an indel in a repeat is at the same position
+// whichever aligner placed it. A deletion moves left while the base before it is the same as its
+// last base; an insertion moves left while the base before it is the same as the last inserted
+// base, which is rotated round to the start of the inserted sequence. An indel that isn't inside
+// ref (which has to be upper case) is left where it is
+func leftAlign(ref []byte, k indelKey) indelKey {
+
+	p := k.pos
+
+	if len(k.seq) > 0 {
+		if p > len(ref) {
+			return k
+		}
+		seq := []byte(k.seq)
+		last := len(seq) - 1
+		for p > 0 && ref[p-1] == seq[last] {
+			copy(seq[1:], seq[:last])
+			seq[0] = ref[p-1]
+			p--
+		}
+		k.pos = p
+		k.seq = string(seq)
+		return k
+	}
+
+	if k.length == 0 || p+k.length > len(ref) {
+		return k
+	}
+	for p > 0 && ref[p-1] == ref[p+k.length-1] {
+		p--
+	}
+	k.pos = p
+
+	return k
+}
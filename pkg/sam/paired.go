@@ -0,0 +1,105 @@
+package sam
+
+import (
+	"bufio"
+	"os"
+	"sort"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/version"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// the name of the count of discordant read pairs in the run summary
+const discordantPairs = "discordant_pairs"
+
+// discordantPair is a paired-end fragment whose mates don't map together as expected.
+// idx is the index of its block of records in the SAM file, so that the report can be
+// written in input order
+type discordantPair struct {
+	idx    int
+	query  string
+	reason string
+}
+
+// checkPair says why the mates in one query's block of SAM records are discordant,
+// or returns "" if they aren't (or the records aren't paired). Only the primary
+// alignment of each mate is looked at: supplementary alignments are flattened into
+// the fragment in the same way as in single-end mode
+func checkPair(records []biogosam.Record) string {
+
+	var mates [2]*biogosam.Record
+
+	for i := range records {
+		rec := &records[i]
+		if rec.Flags&biogosam.Paired == 0 || rec.Flags&(biogosam.Supplementary|biogosam.Secondary) != 0 {
+			continue
+		}
+		if rec.Flags&biogosam.Read2 != 0 {
+			mates[1] = rec
+		} else {
+			mates[0] = rec
+		}
+	}
+
+	switch {
+	case mates[0] == nil && mates[1] == nil:
+		return ""
+	case mates[0] == nil || mates[1] == nil:
+		for _, rec := range mates {
+			if rec != nil && rec.Flags&biogosam.MateUnmapped != 0 {
+				return "mate unmapped"
+			}
+		}
+		return "mate missing"
+	case mates[0].Ref.Name() != mates[1].Ref.Name():
+		return "mates on different references"
+	case mates[0].Flags&biogosam.Reverse == mates[1].Flags&biogosam.Reverse:
+		return "mates on the same strand"
+	case mates[0].Flags&biogosam.ProperPair == 0 || mates[1].Flags&biogosam.ProperPair == 0:
+		return "not a proper pair"
+	}
+
+	return ""
+}
+
+// writeDiscordantPairs reads discordant pairs from a channel and, once it is closed, writes
+// them to outfile (if it isn't empty) as a tab-separated query/reason table in input order.
+// The number of discordant pairs (if any) is added to the run summary either way
+func writeDiscordantPairs(ch chan discordantPair, outfile string, cdone chan bool, cerr chan error) {
+
+	pairs := make([]discordantPair, 0)
+
+	for p := range ch {
+		pairs = append(pairs, p)
+	}
+
+	if len(pairs) > 0 {
+		summary.Add(discordantPairs, len(pairs))
+	}
+
+	if len(outfile) > 0 {
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].idx < pairs[j].idx })
+
+		f, err := os.Create(outfile)
+		if err != nil {
+			cerr <- err
+			return
+		}
+		defer f.Close()
+
+		w := bufio.NewWriter(f)
+		w.WriteString("##" + version.Provenance() + "\nquery\treason\n")
+		for _, p := range pairs {
+			w.WriteString(p.query + "\t" + p.reason + "\n")
+		}
+		err = w.Flush()
+		if err != nil {
+			cerr <- err
+			return
+		}
+	}
+
+	cdone <- true
+}
@@ -0,0 +1,111 @@
+package sam
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/version"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+var pairedSam = "@HD\tVN:1.6\tSO:queryname\n@SQ\tSN:ref\tLN:12\n" +
+	"f1\t99\tref\t1\t60\t6M\t=\t5\t10\tACGTAC\tIIIII#\n" +
+	"f1\t147\tref\t5\t60\t6M\t=\t1\t-10\tTTGGCC\t#IIIII\n" +
+	"f2\t73\tref\t1\t60\t4M\t=\t1\t0\tACGT\tIIII\n" +
+	"f3\t67\tref\t1\t60\t4M\t=\t3\t6\tACGT\tIIII\n" +
+	"f3\t131\tref\t3\t60\t4M\t=\t1\t-6\tGTAC\tIIII\n" +
+	"f4\t97\tref\t1\t60\t4M\t=\t7\t10\tACGT\tIIII\n" +
+	"f4\t145\tref\t7\t60\t4M\t=\t1\t-10\tGGCC\tIIII\n" +
+	"f5\t0\tref\t1\t60\t4M\t*\t0\t0\tACGT\tIIII\n"
+
+func TestCheckPair(t *testing.T) {
+	s, err := newSamReader(strings.NewReader(pairedSam + "f6\t99\tref\t1\t60\t4M\t=\t7\t10\tACGT\tIIII\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := make(map[string][]biogosam.Record)
+	order := make([]string, 0)
+	for {
+		rec, err := readSamRecord(s)
+		if err != nil {
+			break
+		}
+		if _, ok := blocks[rec.Name]; !ok {
+			order = append(order, rec.Name)
+		}
+		blocks[rec.Name] = append(blocks[rec.Name], *rec)
+	}
+
+	expected := map[string]string{
+		"f1": "",
+		"f2": "mate unmapped",
+		"f3": "mates on the same strand",
+		"f4": "not a proper pair",
+		"f5": "",
+		"f6": "mate missing",
+	}
+
+	for _, q := range order {
+		if reason := checkPair(blocks[q]); reason != expected[q] {
+			t.Errorf("problem in TestCheckPair: %s: got %q, expected %q", q, reason, expected[q])
+		}
+	}
+}
+
+func TestToMultiAlignPaired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	err = ioutil.WriteFile(samFile, []byte(pairedSam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "out.fasta")
+	discordantFile := filepath.Join(dir, "discordant.tsv")
+
+	opts := DefaultToMultiAlignOptions()
+	opts.DiscordantFile = discordantFile
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if err == nil {
+		t.Errorf("problem in TestToMultiAlignPaired: expected an error for --discordant without --paired")
+	}
+
+	opts = DefaultToMultiAlignOptions()
+	opts.Paired = true
+	opts.DiscordantFile = discordantFile
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// f1's mates disagree at positions 5 and 6, where the higher quality base wins
+	fasta, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ">f1\nACGTATGGCC--\n>f2\nACGT--------\n>f3\nACGTAC------\n>f4\nACGTNNGGCC--\n>f5\nACGT--------\n"
+	if string(fasta) != expected {
+		t.Errorf("problem in TestToMultiAlignPaired: got\n%s\nexpected\n%s", fasta, expected)
+	}
+
+	discordant, err := ioutil.ReadFile(discordantFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "##" + version.Provenance() + "\nquery\treason\nf2\tmate unmapped\nf3\tmates on the same strand\nf4\tnot a proper pair\n"
+	if string(discordant) != expected {
+		t.Errorf("problem in TestToMultiAlignPaired: got\n%s\nexpected\n%s", discordant, expected)
+	}
+}
@@ -0,0 +1,42 @@
+package sam
+
+import (
+	"sync"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// seqPool holds the byte slices that SEQ fields are expanded into. These only
+// live as long as one record is being processed, so rather than allocating one
+// per record, each worker takes one from the pool and puts it back when it is done.
+var seqPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+// getExpandedSeq expands a SAM record's SEQ into a byte slice from seqPool. The
+// slice must be given back with putExpandedSeq once nothing refers to it any more
+func getExpandedSeq(s biogosam.Seq) *[]byte {
+	bp := seqPool.Get().(*[]byte)
+	b := (*bp)[:0]
+	for i := 0; i < s.Length; i++ {
+		b = append(b, s.At(i))
+	}
+	*bp = b
+	return bp
+}
+
+// putExpandedSeq returns a byte slice from getExpandedSeq to seqPool
+func putExpandedSeq(bp *[]byte) {
+	seqPool.Put(bp)
+}
+
+// padTo appends b to s until it is n long
+func padTo(s []byte, b byte, n int) []byte {
+	for len(s) < n {
+		s = append(s, b)
+	}
+	return s
+}
@@ -0,0 +1,51 @@
+package sam
+
+import (
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+)
+
+// consensus is a sample's consensus sequence, in the same form as a query's flattened
+// sequence before it is trimmed and padded: at each site, the base (or deletion) that more
+// than half of the reads covering it have, an N if none of them does (or with soft, the
+// most common base, soft-masked), or unmapped (see siteState) if fewer than minDepth reads cover it
+func (p *pileup) consensus(sample string, minDepth int, soft bool) []byte {
+
+	counts := p.samples[sample]
+	deletions := p.deletions[sample]
+
+	seq := make([]byte, p.refLen)
+	for i := range seq {
+		depth := counts[i].depth() + int(deletions[i])
+		if depth == 0 || depth < minDepth {
+			seq[i] = unmappedSite
+			continue
+		}
+		best, most := byte('-'), int(deletions[i])
+		for b, base := range pileupBases {
+			if counts[i].count(b) > most {
+				best, most = base, counts[i].count(b)
+			}
+		}
+		if most*2 <= depth {
+			best = hardOrSoftMask(best, soft)
+		}
+		seq[i] = best
+	}
+
+	return seq
+}
+
+// readGroupsToFastaRecords sends the consensus of each sample in the pileup to cFR, in
+// alphabetical order of sample, masked, trimmed and padded as the queries would be
+func readGroupsToFastaRecords(p *pileup, minDepth int, m *mask.Mask, soft bool, trim bool, pad bool, trimstart int, trimend int, cFR chan fastaio.FastaRecord, cErr chan error) error {
+	for i, sample := range p.sampleNames() {
+		FR := getFastaRecord(p.consensus(sample, minDepth, soft), sample, i, m, soft, trim, pad, trimstart, trimend)
+		select {
+		case cFR <- FR:
+		case err := <-cErr:
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package sam
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/usage"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// readReference reads the reference sequence from a fasta file. If the SAM header has a single
+// @SQ line and the fasta file has an up to date .fai index (see gofasta faidx), only that record
+// is read, so the reference can be one of many in a large file; otherwise the file should have
+// exactly one record. If referenceFile is HeaderReference, the reference is found from the
+// header instead (see referenceFromHeader)
+func readReference(referenceFile string, header biogosam.Header) (fastaio.FastaRecord, error) {
+
+	if referenceFile == HeaderReference {
+		return referenceFromHeader(header)
+	}
+
+	if len(header.Refs()) == 1 && fastaio.HasIndex(referenceFile) {
+		refs, err := fastaio.ReadIndexedRecords(referenceFile, []string{header.Refs()[0].Name()})
+		if err != nil {
+			return fastaio.FastaRecord{}, err
+		}
+		return refs[0], nil
+	}
+
+	refs, err := cliio.ReadRecords(referenceFile)
+	if err != nil {
+		return fastaio.FastaRecord{}, err
+	}
+
+	if len(refs) != 1 {
+		return fastaio.FastaRecord{}, errors.New("there should be exactly one record in --reference")
+	}
+
+	return refs[0], nil
+}
+
+// referenceCache reads the reference for a batch of SAM files only once for each record of it
+// that they were aligned against (see readReference)
+type referenceCache struct {
+	referenceFile string
+	refs          map[string]fastaio.FastaRecord
+}
+
+func newReferenceCache(referenceFile string) *referenceCache {
+	return &referenceCache{referenceFile: referenceFile, refs: make(map[string]fastaio.FastaRecord)}
+}
+
+// get returns the reference for a SAM file with this header, reading it the first time it's needed
+func (rc *referenceCache) get(header biogosam.Header) (fastaio.FastaRecord, error) {
+
+	// readReference only reads a record by name for a single @SQ line and an indexed reference,
+	// or from the header
+	key := ""
+	switch {
+	case rc.referenceFile == HeaderReference && len(header.Refs()) > 0:
+		key = header.Refs()[0].String()
+	case len(header.Refs()) == 1 && fastaio.HasIndex(rc.referenceFile):
+		key = header.Refs()[0].Name()
+	}
+
+	if ref, ok := rc.refs[key]; ok {
+		return ref, nil
+	}
+
+	ref, err := readReference(rc.referenceFile, header)
+	if err != nil {
+		return ref, err
+	}
+	rc.refs[key] = ref
+
+	return ref, nil
+}
+
+// checkReference makes sure that the reference sequence we have been given is the
+// one that the SAM file was aligned against, by comparing its name, its length and
+// (if the header has an M5 tag) its MD5 checksum with the @SQ line in the header.
+func checkReference(header biogosam.Header, ref fastaio.FastaRecord) error {
+
+	refs := header.Refs()
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	var sq *biogosam.Reference
+
+	for _, r := range refs {
+		if r.Name() == ref.ID {
+			sq = r
+			break
+		}
+	}
+
+	if sq == nil {
+		if len(refs) > 1 {
+			return fmt.Errorf("reference name mismatch: --reference is %s, but there is no @SQ line with that name in the SAM header", ref.ID)
+		}
+		return fmt.Errorf("reference name mismatch: --reference is %s, but the SAM file was aligned against %s", ref.ID, refs[0].Name())
+	}
+
+	if sq.Len() != len(ref.Seq) {
+		return fmt.Errorf("reference length mismatch: --reference %s is %d bp long, but it is %d bp long in the SAM header", ref.ID, len(ref.Seq), sq.Len())
+	}
+
+	if checksum := sq.MD5(); checksum != nil {
+		// ReadAlignment has already upper-cased the sequence, which is how
+		// the SAM spec says the checksum should be calculated
+		sum := md5.Sum([]byte(ref.Seq))
+		if !bytes.Equal(sum[:], checksum) {
+			return fmt.Errorf("reference checksum mismatch: the MD5 of --reference %s doesn't match the M5 tag in the SAM header", ref.ID)
+		}
+	}
+
+	return nil
+}
+
+// HeaderReference is the --reference that means "the reference in the SAM header": the file or
+// URL in the UR tag of the first @SQ line, or if there isn't one, the sequence with the
+// checksum in its M5 tag, which is fetched from a sequence registry (see md5URL)
+const HeaderReference = "@SQ"
+
+// md5URL is where a reference is fetched from by its MD5 checksum (in hex), which is appended
+// to it: ENA's CRAM reference registry, as used by samtools (a variable so that it can be
+// pointed at a test server)
+var md5URL = "https://www.ebi.ac.uk/ena/cram/md5/"
+
+var urTag = biogosam.NewTag("UR")
+
+// referenceFromHeader reads the reference that the first @SQ line of a SAM header points
+// to. The UR tag can be a path, a file: URI, or an https:// or s3:// URL (which are read
+// like any other --reference).
+// A reference fetched by its M5 tag is cached (see referenceCacheDir), so that it is only
+// downloaded once. Either way, the reference is checked against the header by the caller
+// (see checkReference)
+func referenceFromHeader(header biogosam.Header) (fastaio.FastaRecord, error) {
+
+	if len(header.Refs()) == 0 {
+		return fastaio.FastaRecord{}, usage.Errorf("--reference %s needs an @SQ line in the SAM header", HeaderReference)
+	}
+	sq := header.Refs()[0]
+
+	if ur := sq.Get(urTag); len(ur) > 0 {
+		path := ur
+		if u, err := url.Parse(ur); err == nil && u.Scheme == "file" {
+			path = u.Path
+			if len(path) == 0 {
+				path = u.Opaque
+			}
+		}
+		if path == HeaderReference {
+			return fastaio.FastaRecord{}, fmt.Errorf("the UR tag on the @SQ line for %s is %s", sq.Name(), ur)
+		}
+		// so a big indexed file (e.g. a genome assembly) is read by the @SQ line's name
+		return readReference(path, header)
+	}
+
+	if checksum := sq.MD5(); checksum != nil {
+		seq, err := fetchByMD5(hex.EncodeToString(checksum))
+		if err != nil {
+			return fastaio.FastaRecord{}, err
+		}
+		return fastaio.FastaRecord{ID: sq.Name(), Description: sq.Name(), Seq: strings.ToUpper(strings.Join(strings.Fields(string(seq)), ""))}, nil
+	}
+
+	return fastaio.FastaRecord{}, usage.Errorf("--reference %s needs a UR or M5 tag on the @SQ line for %s in the SAM header", HeaderReference, sq.Name())
+}
+
+// referenceCacheDir is where references fetched by their checksums are kept:
+// $GOFASTA_CACHE_DIR if it is set, otherwise a gofasta directory in the user's cache directory
+func referenceCacheDir() (string, error) {
+	if dir := os.Getenv("GOFASTA_CACHE_DIR"); len(dir) > 0 {
+		return filepath.Join(dir, "references"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gofasta", "references"), nil
+}
+
+// m5Hash is the MD5 of a sequence as the SAM spec says the M5 tag is calculated: of the
+// sequence in upper case, without any whitespace
+type m5Hash struct {
+	h hash.Hash
+}
+
+func newM5Hash() m5Hash {
+	return m5Hash{h: md5.New()}
+}
+
+func (m m5Hash) Write(p []byte) (int, error) {
+	kept := make([]byte, 0, len(p))
+	for _, b := range p {
+		if b > ' ' && b < 0x7f {
+			if b >= 'a' && b <= 'z' {
+				b -= 'a' - 'A'
+			}
+			kept = append(kept, b)
+		}
+	}
+	m.h.Write(kept)
+	return len(p), nil
+}
+
+// sum is the checksum in hex, as it is in the registry's URLs
+func (m m5Hash) sum() string {
+	return hex.EncodeToString(m.h.Sum(nil))
+}
+
+// md5Of is the M5 checksum of seq, in hex
+func md5Of(seq []byte) string {
+	m := newM5Hash()
+	m.Write(seq)
+	return m.sum()
+}
+
+// fetchByMD5 gets the sequence with this (hex) MD5 checksum from the registry at md5URL, or
+// from the cache if it has been fetched before. What the registry sends is checked against the
+// checksum as it is written to the cache, and it is only cached (and used) if it matches, so
+// that a truncated download or an error page can't stand in for the reference
+func fetchByMD5(checksum string) ([]byte, error) {
+
+	dir, err := referenceCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	cached := filepath.Join(dir, checksum)
+
+	if seq, err := ioutil.ReadFile(cached); err == nil {
+		if md5Of(seq) == checksum {
+			return seq, nil
+		}
+		// something else has written to the cache, so the sequence is fetched again
+		os.Remove(cached)
+	}
+
+	client := http.Client{Timeout: 60 * time.Second}
+
+	resp, err := client.Get(md5URL + checksum)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch the reference with MD5 %s: %v", checksum, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("couldn't fetch the reference with MD5 %s: %s", checksum, resp.Status)
+	}
+
+	// a failure to cache the sequence isn't fatal: we can still use it
+	var tmp *os.File
+	if os.MkdirAll(dir, 0755) == nil {
+		tmp, err = ioutil.TempFile(dir, checksum+".*.tmp")
+		if err != nil {
+			tmp = nil
+		}
+	}
+
+	var seq bytes.Buffer
+	m := newM5Hash()
+	w := io.MultiWriter(&seq, m)
+	if tmp != nil {
+		w = io.MultiWriter(w, tmp)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	if err == nil && m.sum() != checksum {
+		err = fmt.Errorf("couldn't fetch the reference with MD5 %s: what %s sent has MD5 %s", checksum, md5URL, m.sum())
+	}
+
+	if tmp != nil {
+		cacheErr := tmp.Close()
+		if err == nil && cacheErr == nil {
+			cacheErr = os.Rename(tmp.Name(), cached)
+		}
+		if err != nil || cacheErr != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return seq.Bytes(), nil
+}
@@ -0,0 +1,77 @@
+package sam
+
+// maxRepeatUnit is the longest unit of a short tandem repeat that an indel can be in
+const maxRepeatUnit = 6
+
+// repeat is the homopolymer or short tandem repeat in the reference that an indel is in: the
+// repeated unit, and how long the run of it is in the reference. Indels in homopolymers are
+// often artefacts, especially from nanopore reads. An indel that isn't in one has no unit, and
+// a length of 0
+type repeat struct {
+	Unit   string `json:"unit"`
+	Length int    `json:"length"`
+}
+
+// periodic is true if seq repeats with period u
+func periodic(seq []byte, u int) bool {
+	for i := u; i < len(seq); i++ {
+		if seq[i] != seq[i-u] {
+			return false
+		}
+	}
+	return true
+}
+
+// repeatContext finds the repeat in ref that the indel k is in. An indel is in a repeat if it
+// adds or removes whole copies of the repeat's unit (of up to maxRepeatUnit bases), and there are
+// at least two copies of the unit in the reference where it is. The shortest unit that fits is
+// used, so an indel in a homopolymer is in a homopolymer rather than a dinucleotide repeat
+func repeatContext(ref []byte, k indelKey) repeat {
+
+	p := k.pos
+	L := len(ref)
+
+	if p < 0 || p > L {
+		return repeat{}
+	}
+
+	for u := 1; u <= maxRepeatUnit; u++ {
+
+		var b, e int
+
+		if len(k.seq) > 0 {
+			// an insertion, before reference position p. The reference around it has to carry
+			// on the inserted sequence's pattern
+			seq := []byte(k.seq)
+			if len(seq)%u != 0 || !periodic(seq, u) {
+				continue
+			}
+			e = p
+			for e < L && ref[e] == seq[(e-p)%u] {
+				e++
+			}
+			b = p
+			for b > 0 && ref[b-1] == seq[((b-1-p)%u+u)%u] {
+				b--
+			}
+		} else {
+			// a deletion of the reference from position p
+			if k.length%u != 0 || p+k.length > L || !periodic(ref[p:p+k.length], u) {
+				continue
+			}
+			b, e = p, p+k.length
+			for e < L && ref[e] == ref[e-u] {
+				e++
+			}
+			for b > 0 && ref[b-1] == ref[b-1+u] {
+				b--
+			}
+		}
+
+		if e-b >= 2*u {
+			return repeat{Unit: string(ref[b : b+u]), Length: e - b}
+		}
+	}
+
+	return repeat{}
+}
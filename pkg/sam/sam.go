@@ -1,12 +1,21 @@
 package sam
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/cov-ert/gofasta/internal/cigar"
+	"github.com/cov-ert/gofasta/pkg/remote"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/usage"
+
 	biogosam "github.com/biogo/hts/sam"
 )
 
@@ -16,17 +25,18 @@ import (
 type samRecords struct {
 	records []biogosam.Record
 	idx	int
+	end	int64 // the byte offset in the SAM file just after the block's last record (-1 if unknown)
 }
 
 // getOneLine processes one non-header line of a SAM file into an aligned sequence
 func getOneLine(samLine biogosam.Record, refLen int, includeInsertions bool) ([]byte, error) {
 
-	var lambda_dict map[string]func(int, int, int, []byte) (int, int, []byte)
+	var lambda_dict map[string]cigar.Op
 
 	if includeInsertions {
-		lambda_dict = getCigarOperationMapWithInsertions()
+		lambda_dict = cigar.WithInsertions()
 	} else {
-		lambda_dict = getCigarOperationMapNoInsertions()
+		lambda_dict = cigar.NoInsertions()
 	}
 
 	// QNAME := samLine.Name
@@ -37,14 +47,20 @@ func getOneLine(samLine biogosam.Record, refLen int, includeInsertions bool) ([]
 		return []byte{}, errors.New("unmapped read")
 	}
 
-	SEQ := samLine.Seq.Expand()
+	err := checkCigar(samLine, refLen)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	bp := getExpandedSeq(samLine.Seq)
+	defer putExpandedSeq(bp)
+	SEQ := *bp
 
 	CIGAR := samLine.Cigar
 
-	newSeqArray := make([]byte, POS)
-	for i, _ := range newSeqArray {
-		newSeqArray[i] = '*'
-	}
+	// allocate the whole sequence up front, so that appending to it doesn't
+	// reallocate (without insertions, it will be exactly refLen long)
+	newSeqArray := padTo(make([]byte, 0, refLen), unmappedSite, POS)
 
 	qstart := 0
 	rstart := POS
@@ -66,12 +82,7 @@ func getOneLine(samLine biogosam.Record, refLen int, includeInsertions bool) ([]
 	}
 
 	if ! includeInsertions {
-		rightpad := make([]byte, refLen-len(newSeqArray))
-		for i, _ := range rightpad {
-			rightpad[i] = '*'
-		}
-
-		newSeqArray = append(newSeqArray, rightpad...)
+		newSeqArray = padTo(newSeqArray, unmappedSite, refLen)
 	}
 
 	// fmt.Println(string(newSeqArray))
@@ -83,12 +94,12 @@ func getOneLine(samLine biogosam.Record, refLen int, includeInsertions bool) ([]
 // (aligned) sequences which are the query and the reference
 func getOneLinePlusRef(samLine biogosam.Record, reference []byte, includeInsertions bool) ([]byte, []byte, error) {
 
-	var lambda_dict map[string]func(int, int, int, []byte, []byte) (int, int, []byte, []byte)
+	var lambda_dict map[string]cigar.OpWithRef
 
 	if includeInsertions {
-		lambda_dict = getCigarOperationMapWithInsertionsWithRef()
+		lambda_dict = cigar.WithInsertionsWithRef()
 	} else {
-		lambda_dict = getCigarOperationMapNoInsertionsWithRef()
+		lambda_dict = cigar.NoInsertionsWithRef()
 	}
 
 	// QNAME := samLine.Name
@@ -99,19 +110,21 @@ func getOneLinePlusRef(samLine biogosam.Record, reference []byte, includeInserti
 		return []byte{}, []byte{}, errors.New("unmapped read")
 	}
 
-	SEQ := samLine.Seq.Expand()
+	err := checkCigar(samLine, len(reference))
+	if err != nil {
+		return []byte{}, []byte{}, err
+	}
+
+	bp := getExpandedSeq(samLine.Seq)
+	defer putExpandedSeq(bp)
+	SEQ := *bp
 
 	CIGAR := samLine.Cigar
 
-	newSeqArray := make([]byte, POS)
-	for i, _ := range newSeqArray {
-		newSeqArray[i] = '*'
-	}
+	newSeqArray := padTo(make([]byte, 0, len(reference)), unmappedSite, POS)
 
-	newRefSeqArray := make([]byte, POS)
-	for i, _ := range newRefSeqArray {
-		newRefSeqArray[i] = reference[i]
-	}
+	newRefSeqArray := make([]byte, POS, len(reference))
+	copy(newRefSeqArray, reference[:POS])
 
 	qstart := 0
 	rstart := POS
@@ -139,13 +152,7 @@ func getOneLinePlusRef(samLine biogosam.Record, reference []byte, includeInserti
 	}
 
 	if ! includeInsertions {
-		rightpad := make([]byte, len(reference)-len(newSeqArray))
-		// fmt.Println(len(rightpad))
-		for i, _ := range rightpad {
-			rightpad[i] = '*'
-		}
-
-		newSeqArray = append(newSeqArray, rightpad...)
+		newSeqArray = padTo(newSeqArray, unmappedSite, len(reference))
 	}
 
 	// fmt.Println(string(newSeqArray))
@@ -178,7 +185,7 @@ func getSetFromSlice(s []byte) []byte {
 // getNucFromSite flattens a site to a single nucleotide when a query sequence
 // has secondary mappings (multiple records/lines) in the SAM file.
 // * If there is more than one alphabetic character at this site, an N is returned.
-// * Alphabetic characters override '-'s and '*'s
+// * Alphabetic characters override '-'s and unmapped sites (see siteState)
 func getNucFromSite(s []byte, qname string) byte {
 
 	check := 0
@@ -193,13 +200,19 @@ func getNucFromSite(s []byte, qname string) byte {
 	}
 
 	if check > 1 {
-		os.Stderr.WriteString("ambiguous overlapping alignment: " + qname + ": " + string(ss) + "\n")
+		letters := make([]byte, 0, len(ss))
+		for _, e := range ss {
+			if isLetter(e) {
+				letters = append(letters, e)
+			}
+		}
+		os.Stderr.WriteString("ambiguous overlapping alignment: " + qname + ": " + string(letters) + "\n")
 		return 'N'
 	}
 
 	var m byte
 	for i, e := range ss {
-		if i == 0 || e > m {
+		if i == 0 || stateOf(e) > stateOf(m) || (stateOf(e) == stateOf(m) && e > m) {
 			m = e
 		}
 	}
@@ -225,88 +238,108 @@ func checkAndGetFlattenedSeq(block [][]byte, qname string) []byte {
 	return seq
 }
 
-// getSeqFromBlock wraps the above functions to get a sequence from one query's
-// SAM records - if there is only one line (only a primary mapping) it
-// returns that aligned sequence without needing to do any flattening
-func getSeqFromBlock(records []biogosam.Record, refLen int, includeInsertions bool) ([]byte, error) {
+// alignedInterval is one SAM record's contribution to a query's aligned sequence:
+// the bases from the first to the last reference position that the record covers,
+// rather than a whole reference-length sequence. Insertions relative to the
+// reference are discarded, deletions are '-'s and skipped regions are unmapped sites.
+type alignedInterval struct {
+	start int    // 0-based reference position of seq[0]
+	seq   []byte
+	quals []byte // base qualities of seq (0 for deletions/skips); empty if not asked for
+}
 
-	qname := records[0].Name
+// getAlignedInterval processes one non-header line of a SAM file into iv. iv's
+// slices are reused, so one alignedInterval can be used for any number of records
+// without allocating (once it has grown to the length of the longest read).
+func getAlignedInterval(samLine biogosam.Record, withQuals bool, iv *alignedInterval) error {
 
-	block := make([][]byte, len(records))
-	for i, _ := range block {
-		block[i] = make([]byte, refLen)
+	if samLine.Pos < 0 {
+		return errors.New("unmapped read")
 	}
 
-	for i, line := range records {
-		temp, err := getOneLine(line, refLen, includeInsertions)
-		if err != nil {
-			return []byte{}, err
-		}
-		block[i] = temp
-	}
+	hasQuals := withQuals && len(samLine.Qual) == samLine.Seq.Length
 
-	var seq []byte
+	iv.start = samLine.Pos
+	iv.seq = iv.seq[:0]
+	iv.quals = iv.quals[:0]
 
-	if len(block) > 1 {
-		seq = checkAndGetFlattenedSeq(block, qname)
-	} else {
-		seq = block[0]
-	}
+	qstart := 0
 
-	// fmt.Println(seq)
-	return seq, nil
-}
+	for _, op := range samLine.Cigar {
+		size := op.Len()
+		consumes := op.Type().Consumes()
 
-// swapInNs replaces unmapped positions with Ns
-func swapInNs(seq []byte) []byte {
-	for i, L := range seq {
-		if L == '*' {
-			seq[i] = 'N'
+		switch {
+		case consumes.Query == 1 && consumes.Reference == 1:
+			for i := qstart; i < qstart+size; i++ {
+				iv.seq = append(iv.seq, samLine.Seq.At(i))
+			}
+			if hasQuals {
+				iv.quals = append(iv.quals, samLine.Qual[qstart:qstart+size]...)
+			} else if withQuals {
+				for i := 0; i < size; i++ {
+					iv.quals = append(iv.quals, 0xff)
+				}
+			}
+
+		case consumes.Reference == 1:
+			fill := byte('-')
+			if op.Type().String() == "N" {
+				fill = unmappedSite
+			}
+			for i := 0; i < size; i++ {
+				iv.seq = append(iv.seq, fill)
+				if withQuals {
+					iv.quals = append(iv.quals, 0)
+				}
+			}
 		}
+
+		qstart += size * consumes.Query
 	}
-	return seq
+
+	return nil
 }
 
-// swapInGapNss replaces internal unmapped positions with Ns and external
-// unmapped positions with '-'s
-func swapInGapsNs(seq []byte) []byte {
-	firstLetter := true
-	var firstLetterIndx int
-	var lastLetterIndx int
+// getSeqFromBlock gets a sequence from one query's SAM records. Each record is
+// processed into an alignedInterval, and these are added to a flattener one at a time,
+// so the only reference-length sequence is the flattened one that is returned
+// (see flattener for the memory this needs).
+// flatten is the strategy for resolving sites where the records disagree:
+// "letters" (any disagreement gives an N) or "quality" (see flattener.result).
+// fl is reset for every query so that each worker can reuse its memory: the
+// sequence that is returned belongs to fl, and is only valid until the next call.
+func getSeqFromBlock(records []biogosam.Record, refLen int, includeInsertions bool, flatten string, fl *flattener) ([]byte, error) {
 
-	for i, L := range seq {
-		r, _ := utf8.DecodeRune([]byte{L})
-		if unicode.IsLetter(r) {
+	qname := records[0].Name
 
-			if firstLetter {
-				firstLetterIndx = i
-				firstLetter = false
+	// with insertions, the records aren't in reference coordinates, so can't
+	// be overlaid on each other - the block has to be flattened as a whole
+	if includeInsertions {
+		block := make([][]byte, len(records))
+		for i, line := range records {
+			temp, err := getOneLine(line, refLen, includeInsertions)
+			if err != nil {
+				return []byte{}, err
 			}
-
-			lastLetterIndx = i
-
+			block[i] = temp
 		}
+		if len(block) > 1 {
+			return checkAndGetFlattenedSeq(block, qname), nil
+		}
+		return block[0], nil
 	}
 
-	for i, L := range seq {
-		if i < firstLetterIndx {
-			if L == '*' {
-				seq[i] = '-'
-			}
-		}
-		if i > firstLetterIndx && i < lastLetterIndx {
-			if L == '*' {
-				seq[i] = 'N'
-			}
-		}
-		if i > lastLetterIndx {
-			if L == '*' {
-				seq[i] = '-'
-			}
+	fl.reset(qname, refLen, flatten == "quality" && len(records) > 1)
+
+	for _, line := range records {
+		err := fl.addRecord(line)
+		if err != nil {
+			return []byte{}, err
 		}
 	}
 
-	return seq
+	return fl.result(), nil
 }
 
 // // getSamHeader uses Biogo/sam to return the header of a SAM file
@@ -334,39 +367,415 @@ func swapInGapsNs(seq []byte) []byte {
 // 	return header, nil
 // }
 
+// maskLowQuality replaces bases in a SAM record whose Phred quality (from the
+// QUAL field) is below minQual with Ns. Records without qualities (QUAL == '*')
+// are left untouched, as are all records if minQual < 1
+func maskLowQuality(rec *biogosam.Record, minQual int) {
+
+	if minQual < 1 || len(rec.Qual) == 0 {
+		return
+	}
+
+	seq := rec.Seq.Expand()
+	masked := false
+
+	for i, q := range rec.Qual {
+		// biogo sets all qualities to 0xff if they are missing
+		if q != 0xff && int(q) < minQual {
+			seq[i] = 'N'
+			masked = true
+		}
+	}
+
+	if masked {
+		rec.Seq = biogosam.NewSeq(seq)
+	}
+}
+
+// corruptRecordError is a problem with one SAM record, as opposed to with reading the
+// file, so the record can be skipped (see skipRecord)
+type corruptRecordError struct {
+	msg string
+}
+
+func (e corruptRecordError) Error() string {
+	return e.msg
+}
+
+// malformed returns a corruptRecordError about the record called name
+func malformed(name string, format string, a ...interface{}) error {
+	return corruptRecordError{msg: "malformed SAM record: " + name + ": " + fmt.Sprintf(format, a...)}
+}
+
+// skipRecord reports whether the nth record in a SAM file should be skipped because of
+// err, which it should be if skipCorrupt and err is a problem with the record itself.
+// A warning is written for each record that is skipped
+func skipRecord(err error, n int, skipCorrupt bool) bool {
+	var c corruptRecordError
+	if !skipCorrupt || !errors.As(err, &c) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "skipping SAM record %d: %v\n", n, err)
+	return true
+}
+
+// checkCigar makes sure that a record's CIGAR consumes exactly its SEQ, and that it
+// doesn't go past the end of a refLen-long reference (unless refLen < 0), naming the
+// operation where it goes wrong
+func checkCigar(rec biogosam.Record, refLen int) error {
+
+	qpos := 0
+	rpos := rec.Pos
+
+	for i, op := range rec.Cigar {
+		consumes := op.Type().Consumes()
+
+		qpos += op.Len() * consumes.Query
+		rpos += op.Len() * consumes.Reference
+
+		if qpos > rec.Seq.Length {
+			return malformed(rec.Name, "CIGAR operation %d (%s) goes past the end of SEQ (length %d)", i+1, op.String(), rec.Seq.Length)
+		}
+		if refLen >= 0 && rpos > refLen {
+			return malformed(rec.Name, "CIGAR operation %d (%s) goes past the end of the reference (length %d)", i+1, op.String(), refLen)
+		}
+	}
+
+	if qpos < rec.Seq.Length {
+		return malformed(rec.Name, "the CIGAR only covers %d of the %d bases in SEQ", qpos, rec.Seq.Length)
+	}
+
+	return nil
+}
+
+// checkMissingSeq makes sure that --missing-seq is something we know how to do
+func checkMissingSeq(missingSeq string) error {
+	switch missingSeq {
+	case "skip", "mask":
+		return nil
+	}
+	return usage.Errorf("unrecognised --missing-seq: %s (choose one of: skip, mask)", missingSeq)
+}
+
+// fillMissingSeq deals with a mapped record that has no stored sequence (SEQ == '*'),
+// which aligners write e.g. for secondary alignments. If missingSeq is "mask", SEQ
+// becomes an N for each query base that the CIGAR consumes (so hard clips are still
+// left out), and QUAL is cleared. Otherwise, it returns true, meaning that the record
+// should be skipped
+func fillMissingSeq(rec *biogosam.Record, missingSeq string) bool {
+
+	if rec.Seq.Length > 0 || len(rec.Cigar) == 0 {
+		return false
+	}
+
+	if missingSeq != "mask" {
+		os.Stderr.WriteString("skipping SAM record with no SEQ: " + rec.Name + "\n")
+		return true
+	}
+
+	qlen := 0
+	for _, op := range rec.Cigar {
+		qlen += op.Len() * op.Type().Consumes().Query
+	}
+
+	rec.Seq = biogosam.NewSeq(bytes.Repeat([]byte{'N'}, qlen))
+	rec.Qual = nil
+
+	return false
+}
+
+// acceptRecord reports whether the nth record of a SAM file is used. Unmapped records aren't,
+// nor are secondary mappings unless withSecondary, nor records without a SEQ unless missingSeq
+// fills it in (see fillMissingSeq). A malformed record is an error, unless skipCorrupt, when
+// it is skipped with a warning (see skipRecord)
+func acceptRecord(rec *biogosam.Record, n int, withSecondary bool, skipCorrupt bool, missingSeq string) (bool, error) {
+
+	// the third bit (== 4) in the sam flag is set if the read is unmapped
+	if rec.Flags&biogosam.Unmapped != 0 {
+		os.Stderr.WriteString("skipping unmapped read: " + rec.Name + "\n")
+		return false, nil
+	}
+
+	// the 9th bit (== 256) is set if the mapping is secondary
+	if !withSecondary && rec.Flags&biogosam.Secondary != 0 {
+		os.Stderr.WriteString("ignoring secondary mapping: " + rec.Name + "\n")
+		return false, nil
+	}
+
+	if fillMissingSeq(rec, missingSeq) {
+		return false, nil
+	}
+
+	err := checkSamRecord(rec)
+	if err != nil {
+		if skipRecord(err, n, skipCorrupt) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// checkSamRecord makes sure that a mapped SAM record can be turned into an aligned
+// sequence: that it has a POS and a SEQ, that its CIGAR only has operations we know how to
+// handle and consumes the whole SEQ, and that it doesn't align past the end of its reference
+func checkSamRecord(rec *biogosam.Record) error {
+
+	if rec.Pos < 0 {
+		return malformed(rec.Name, "mapped, but has no POS")
+	}
+
+	if rec.Seq.Length == 0 {
+		return malformed(rec.Name, "no SEQ")
+	}
+
+	for i, op := range rec.Cigar {
+		switch op.Type() {
+		case biogosam.CigarMatch, biogosam.CigarInsertion, biogosam.CigarDeletion, biogosam.CigarSkipped,
+			biogosam.CigarSoftClipped, biogosam.CigarHardClipped, biogosam.CigarPadded,
+			biogosam.CigarEqual, biogosam.CigarMismatch:
+		default:
+			return malformed(rec.Name, "unsupported CIGAR operation %d (%s)", i+1, op.String())
+		}
+	}
+
+	// without an @SQ line for it, biogo adds the record's reference to the header with no
+	// length, and the records are checked against the reference's length later, if it is known
+	refLen := -1
+	if rec.Ref != nil && rec.Ref.Len() > 0 {
+		refLen = rec.Ref.Len()
+	}
+
+	return checkCigar(*rec, refLen)
+}
+
+// newSamReader is biogosam.NewReader, except that biogo panics on some malformed
+// headers, which are returned as errors here instead
+func newSamReader(r io.Reader) (s *biogosam.Reader, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("malformed SAM header: %v", p)
+		}
+	}()
+	return biogosam.NewReader(r)
+}
+
+// warnNoRecords writes a warning that there are no records in a SAM file. An empty input isn't
+// an error: whatever is made from it is empty too
+func warnNoRecords(infile string) {
+	os.Stderr.WriteString("warning: there are no records in " + infile + "\n")
+}
+
+// noSQ is for when a SAM header that the reference's length is needed from has no @SQ line,
+// which is only all right if there are no records either (e.g. the file is empty). It waits
+// for the first block of records, and returns an error if there is one. cSR may be buffered,
+// so the reader can be done while there are still blocks in it, and it may be closed once the
+// reader is done
+func noSQ(cSR chan samRecords, cReadDone chan bool, cErr chan error) error {
+	err := errors.New("no reference (@SQ line) in the SAM header: give the --reference, so that its length can be used")
+	select {
+	case err := <-cErr:
+		return err
+	case _, ok := <-cSR:
+		if ok {
+			return err
+		}
+		return nil
+	case <-cReadDone:
+		select {
+		case _, ok := <-cSR:
+			if ok {
+				return err
+			}
+			return nil
+		default:
+			return nil
+		}
+	}
+}
+
+// inferRefLen is for when a SAM header has no @SQ line, and there isn't a --reference to
+// take the reference's length from either. If infile is a file, it is read through once
+// first, and the reference is taken to be as long as the furthest that any record reaches
+// (with a warning, because it may be longer). If it is stdin, it can't be read twice, so
+// this is an error unless there are no records (see noSQ). The length is 0 if there are
+// no records
+func inferRefLen(infile string, cSR chan samRecords, cReadDone chan bool, cErr chan error) (int, error) {
+
+	if len(infile) == 0 {
+		return 0, noSQ(cSR, cReadDone, cErr)
+	}
+
+	refLen, err := furthestEnd(infile)
+	if err != nil {
+		return 0, err
+	}
+	if refLen == 0 {
+		return 0, noSQ(cSR, cReadDone, cErr)
+	}
+	fmt.Fprintf(os.Stderr, "warning: no reference (@SQ line) in the header of %s, so the reference is taken to be %d bp long, which is as far as the records reach: give the --reference for its real length\n", infile, refLen)
+
+	return refLen, nil
+}
+
+// furthestEnd is the furthest (1-based) reference position that any mapped record in a
+// SAM file reaches. Malformed records are left for the pass that reads the file properly
+func furthestEnd(infile string) (int, error) {
+
+	f, err := remote.Open(infile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s, err := newSamReader(bufio.NewReader(f))
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	end := 0
+	for {
+		rec, err := readSamRecord(s)
+		if err == io.EOF {
+			break
+		}
+		var corrupt corruptRecordError
+		if errors.As(err, &corrupt) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if rec.Flags&biogosam.Unmapped == 0 && rec.End() > end {
+			end = rec.End()
+		}
+	}
+
+	return end, nil
+}
+
+// warnPastEnd warns about each record that reaches past the end of a reference of length
+// refLen, which can only happen if the SAM header has no @SQ line to check it against (see
+// checkSamRecord). Only the part of the record that is on the reference is used
+func warnPastEnd(records []biogosam.Record, refLen int) {
+	for _, rec := range records {
+		if rec.End() > refLen {
+			fmt.Fprintf(os.Stderr, "warning: %s reaches past the end of the reference (length %d), so it has been cut short\n", rec.Name, refLen)
+		}
+	}
+}
+
+// readSamRecord is s.Read(), returning an error instead of panicking on malformed records.
+// Records that biogo can't parse give a corruptRecordError
+func readSamRecord(s *biogosam.Reader) (rec *biogosam.Record, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = corruptRecordError{msg: fmt.Sprintf("malformed SAM record: %v", p)}
+		}
+	}()
+	rec, err = s.Read()
+	if err != nil && strings.HasPrefix(err.Error(), "sam: ") {
+		err = corruptRecordError{msg: "malformed SAM record: " + strings.TrimPrefix(err.Error(), "sam: ")}
+	}
+	return rec, err
+}
+
+// countingReader counts the bytes that are read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
 // groupSamRecords yields blocks of SAM records that correspond to the same query
-// sequence (to a channel)
-func groupSamRecords(infile string, cHeader chan biogosam.Header, chnl chan samRecords, cdone chan bool, cerr chan error) {
+// sequence (to a channel). Low quality bases are masked according to minQual, and
+// records without a SEQ are dealt with according to missingSeq (see fillMissingSeq),
+// as the records are read
+func groupSamRecords(infile string, minQual int, skipCorrupt bool, missingSeq string, cHeader chan biogosam.Header, chnl chan samRecords, cdone chan bool, cerr chan error) {
+	groupSamRecordsFrom(infile, 0, minQual, skipCorrupt, missingSeq, cHeader, chnl, cdone, cerr, nil)
+}
+
+// groupSamRecordsFrom is groupSamRecords, except that if from > 0, the records are read from
+// that byte offset in the file (after reading the header from the start), which should be the
+// end of a block from a previous run. Each block records the offset just after its last record.
+// If stop is closed (e.g. because a later stage of the pipeline has failed), it returns without
+// reading any more of the file, and without passing a true to cdone
+func groupSamRecordsFrom(infile string, from int64, minQual int, skipCorrupt bool, missingSeq string, cHeader chan biogosam.Header, chnl chan samRecords, cdone chan bool, cerr chan error, stop chan struct{}) {
 
 	var err error
-	f := os.Stdin
+	var f remote.File = os.Stdin
 
 	if len(infile) > 0 {
-		f, err = os.Open(infile)
+		f, err = remote.Open(infile)
 		if err != nil {
 			cerr <- err
+			return
 		}
 	}
 
 	defer f.Close()
 
-	s, err := biogosam.NewReader(f)
-	if err != nil {
+	// biogo reads through this bufio.Reader rather than wrapping it in its own, and it
+	// reads one line per record, so the offset of the next record is what has been read
+	// from the file less what is still buffered
+	cr := &countingReader{r: f}
+	br := bufio.NewReader(cr)
+	offset := func() int64 {
+		return cr.n - int64(br.Buffered())
+	}
+
+	// a zero-length file is read as a SAM file with no header and no records
+	s, err := newSamReader(br)
+	empty := err == io.EOF
+	if err != nil && !empty {
 		cerr <- err
+		return
+	}
+
+	if from > 0 && !empty {
+		if from < offset() {
+			cerr <- fmt.Errorf("can't resume from byte %d of %s, which is in the header", from, infile)
+			return
+		}
+		_, err = f.Seek(from, io.SeekStart)
+		if err != nil {
+			cerr <- err
+			return
+		}
+		cr.n = from
+		br.Reset(cr)
 	}
 
-	cHeader<- *s.Header()
+	if empty {
+		h, _ := biogosam.NewHeader(nil, nil)
+		cHeader <- *h
+	} else {
+		cHeader <- *s.Header()
+	}
 
 	// this counter will be used to preserve order in input and output:
 	counter := 0
 
+	// how many records were read, and how many of them were skipped
+	nRead := 0
+	nSkipped := 0
+
 	first := true
 	samLineGroup := samRecords{idx: counter}
 	var previous string
 
-	for {
+	for !empty {
 
-		rec, err := s.Read()
+		rec, err := readSamRecord(s)
 
 		if err == io.EOF {
 
@@ -374,43 +783,54 @@ func groupSamRecords(infile string, cHeader chan biogosam.Header, chnl chan samR
 
 		} else if err != nil {
 
+			nRead++
+			if skipRecord(err, nRead, skipCorrupt) {
+				nSkipped++
+				continue
+			}
 			cerr <- err
+			return
 
 		} else {
-			// if this read is unmapped, then skip it.
-			// the third bit (== 4) in the sam flag is set if the read is unmapped,
-			// can use the rightshift method to check this:
-			if ((rec.Flags >> 2) & 1) == 1 {
-				os.Stderr.WriteString("skipping unmapped read: " + rec.Name + "\n")
-				continue
-			}
+			nRead++
 
-			// if this mapping is secondary, then skip it.
-			// the 9th bit (== 256) in the sam flag is set if the mapping is secondary,
-			// can use the rightshift method to check this:
-			if ((rec.Flags >> 8) & 1) == 1 {
-				os.Stderr.WriteString("ignoring secondary mapping: " + rec.Name + "\n")
+			keep, err := acceptRecord(rec, nRead, false, skipCorrupt, missingSeq)
+			if err != nil {
+				cerr <- err
+				return
+			}
+			if !keep {
+				nSkipped++
 				continue
 			}
 
+			maskLowQuality(rec, minQual)
+
 			if first {
 				samLineGroup.records = append(samLineGroup.records, *rec)
+				samLineGroup.end = offset()
 				first = false
 				previous = rec.Name
 				continue
 			}
 
 			if rec.Name != previous {
-				chnl <- samLineGroup
+				select {
+				case chnl <- samLineGroup:
+				case <-stop:
+					return
+				}
 				counter++
 
 				samLineGroup = samRecords{idx: counter}
 				samLineGroup.records = append(samLineGroup.records, *rec)
+				samLineGroup.end = offset()
 				previous = rec.Name
 				continue
 			}
 
 			samLineGroup.records = append(samLineGroup.records, *rec)
+			samLineGroup.end = offset()
 			previous = rec.Name
 
 		}
@@ -418,8 +838,23 @@ func groupSamRecords(infile string, cHeader chan biogosam.Header, chnl chan samR
 	}
 
 	if len(samLineGroup.records) > 0 {
-		chnl <- samLineGroup
+		select {
+		case chnl <- samLineGroup:
+		case <-stop:
+			return
+		}
+		counter++
+	}
+
+	if len(infile) == 0 {
+		infile = "stdin"
+	}
+	if nRead == 0 {
+		warnNoRecords(infile)
 	}
+	summary.Read(infile, nRead)
+	summary.Add(summary.Skipped, nSkipped)
+	summary.Add(summary.Processed, counter)
 
 	cdone <- true
 }
@@ -0,0 +1,61 @@
+//go:build go1.18
+// +build go1.18
+
+package sam
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// the fuzz targets are in their own file, because testing.F needs Go 1.18
+
+func FuzzSamRecords(f *testing.F) {
+	f.Add([]byte("@SQ\tSN:ref\tLN:20\nq1\t0\tref\t3\t60\t2S4M2I3M2D3M\t*\t0\t0\tACGTACGTACGTAC\t*\tMD:Z:4^AC3\n"))
+	f.Add([]byte("@SQ\tSN:ref\tLN:20\nq1\t0\tref\t15\t60\t10M\t*\t0\t0\tACGTACGTAC\t*\n"))
+	f.Add([]byte("@SQ\tSN:ref\tLN:20\nq1\t0\tref\t1\t60\t4M\t*\t0\t0\t*\t*\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// malformed records may be rejected, but must not cause a panic
+		s, err := newSamReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		fl := newFlattener("", 0, false, 10)
+
+		for {
+			rec, err := readSamRecord(s)
+			if err == io.EOF || err != nil {
+				return
+			}
+			if ((rec.Flags>>2)&1) == 1 || rec.Ref == nil || rec.Ref.Len() > 100000 {
+				continue
+			}
+			if checkSamRecord(rec) != nil {
+				continue
+			}
+
+			ref := make([]byte, rec.Ref.Len())
+			for i := range ref {
+				ref[i] = 'A'
+			}
+
+			getOneLine(*rec, len(ref), true)
+			getOneLine(*rec, len(ref), false)
+			getOneLinePlusRef(*rec, ref, true)
+			getOneLinePlusRef(*rec, ref, false)
+			getSeqFromBlock([]biogosam.Record{*rec, *rec}, len(ref), false, "quality", fl)
+			getSNPsFromRef(*rec, ref)
+			getSNPsFromCigar(*rec, ref)
+			if aux, ok := rec.Tag([]byte("MD")); ok {
+				if md, isString := aux.Value().(string); isString {
+					getSNPsFromMD(*rec, md)
+				}
+			}
+		}
+	})
+}
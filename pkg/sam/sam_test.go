@@ -0,0 +1,506 @@
+package sam
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/usage"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// makeBenchmarkBlock makes a block of n records for one query, each of which is a
+// readLen-long stretch of a refLen-long genome, with a small deletion in the middle
+func makeBenchmarkBlock(refLen int, readLen int, n int) []biogosam.Record {
+
+	r := rand.New(rand.NewSource(1))
+	nucs := []byte("ACGT")
+
+	genome := make([]byte, refLen)
+	for j := range genome {
+		genome[j] = nucs[r.Intn(4)]
+	}
+
+	records := make([]biogosam.Record, n)
+
+	for i := range records {
+		pos := r.Intn(refLen - readLen - 3)
+		half := readLen / 2
+		seq := make([]byte, 0, readLen)
+		seq = append(seq, genome[pos:pos+half]...)
+		seq = append(seq, genome[pos+half+3:pos+readLen+3]...)
+		qual := make([]byte, readLen)
+		for j := range qual {
+			qual[j] = byte(r.Intn(40))
+		}
+		cigar, err := biogosam.ParseCigar([]byte(strconv.Itoa(half) + "M3D" + strconv.Itoa(readLen-half) + "M"))
+		if err != nil {
+			panic(err)
+		}
+		records[i] = biogosam.Record{
+			Name:  "query",
+			Pos:   pos,
+			Cigar: cigar,
+			Seq:   biogosam.NewSeq(seq),
+			Qual:  qual,
+		}
+	}
+
+	return records
+}
+
+func BenchmarkGetSeqFromBlock(b *testing.B) {
+	records := makeBenchmarkBlock(30000, 400, 50)
+	fl := newFlattener("", 30000, false, 10)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := getSeqFromBlock(records, 30000, false, "letters", fl)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetOneLinePlusRef(b *testing.B) {
+	records := makeBenchmarkBlock(30000, 400, 1)
+	ref := make([]byte, 30000)
+	for i := range ref {
+		ref[i] = 'A'
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, err := getOneLinePlusRef(records[0], ref, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestGetSeqFromBlockReusesFlattener(t *testing.T) {
+	block1 := makeBenchmarkBlock(1000, 100, 5)
+	block2 := makeBenchmarkBlock(800, 50, 3)
+
+	fresh, err := getSeqFromBlock(block2, 800, false, "quality", newFlattener("", 800, false, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := string(fresh)
+
+	fl := newFlattener("", 1000, false, 10)
+	_, err = getSeqFromBlock(block1, 1000, false, "quality", fl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reused, err := getSeqFromBlock(block2, 800, false, "quality", fl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(reused) != want {
+		t.Errorf("problem in TestGetSeqFromBlockReusesFlattener: a reused flattener gave a different sequence")
+	}
+}
+
+func TestCheckCigar(t *testing.T) {
+	tests := []struct {
+		cigar    string
+		seq      string
+		expected string
+	}{
+		{"2S3=1X1I2M3H", "ACGTACGTA", ""},
+		{"2S3=1X1I2M3H", "ACGTACGT", "malformed SAM record: read: CIGAR operation 5 (2M) goes past the end of SEQ (length 8)"},
+		{"4M2D4M", "ACGTACGTAC", "malformed SAM record: read: the CIGAR only covers 8 of the 10 bases in SEQ"},
+		{"4M8D4M", "ACGTACGT", "malformed SAM record: read: CIGAR operation 2 (8D) goes past the end of the reference (length 12)"},
+	}
+
+	for _, test := range tests {
+		cigar, err := biogosam.ParseCigar([]byte(test.cigar))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := biogosam.Record{Name: "read", Pos: 1, Cigar: cigar, Seq: biogosam.NewSeq([]byte(test.seq))}
+		err = checkCigar(rec, 12)
+		if (err == nil && len(test.expected) > 0) || (err != nil && err.Error() != test.expected) {
+			t.Errorf("problem in TestCheckCigar: %s %s: got error %v, expected %s", test.cigar, test.seq, err, test.expected)
+		}
+		if err != nil {
+			if _, ok := err.(corruptRecordError); !ok {
+				t.Errorf("problem in TestCheckCigar: %v isn't a corruptRecordError", err)
+			}
+		}
+	}
+}
+
+func TestSNPsSkipCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n" +
+		"q1\t0\tref\t1\t60\t4M\t*\t0\t0\tACTT\t*\tMD:Z:2G1\n" +
+		"q2\t0\tref\t1\t60\t4M\t*\t0\t0\tACGTA\t*\tMD:Z:4\n" +
+		"q3\t0\tref\t10\t60\t4M\t*\t0\t0\tACGT\t*\tMD:Z:4\n" +
+		"q4\t0\tref\t1\t60\t4M\t*\t0\t0\tACGA\t*\tMD:Z:3T\n"
+	err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "snps.csv")
+
+	err = SNPs(samFile, "", outFile, 0, false, "skip", false, 2)
+	if err == nil {
+		t.Errorf("problem in TestSNPsSkipCorrupt: expected an error without skipCorrupt")
+	}
+
+	err = SNPs(samFile, "", outFile, 0, true, "skip", false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snps, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "query,SNPs\nq1,G3T\nq4,T4A\n"
+	if string(snps) != expected {
+		t.Errorf("problem in TestSNPsSkipCorrupt: got\n%s\nexpected\n%s", snps, expected)
+	}
+}
+
+func TestFillMissingSeq(t *testing.T) {
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:20\n" +
+		"q1\t256\tref\t3\t0\t5H2S4M1I3M2D2M\t*\t0\t0\t*\t*\n"
+
+	for _, missingSeq := range []string{"skip", "mask"} {
+		s, err := newSamReader(strings.NewReader(sam))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := readSamRecord(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		skip := fillMissingSeq(rec, missingSeq)
+		if skip != (missingSeq == "skip") {
+			t.Errorf("problem in TestFillMissingSeq: %s: got skip = %v", missingSeq, skip)
+		}
+		if skip {
+			continue
+		}
+
+		if string(rec.Seq.Expand()) != "NNNNNNNNNNNN" || len(rec.Qual) != 0 {
+			t.Errorf("problem in TestFillMissingSeq: got SEQ %s and QUAL %v", rec.Seq.Expand(), rec.Qual)
+		}
+		err = checkSamRecord(rec)
+		if err != nil {
+			t.Errorf("problem in TestFillMissingSeq: %v", err)
+		}
+		seq, err := getOneLine(*rec, 20, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if showSites(seq) != "**NNNNNNN--NN*******" {
+			t.Errorf("problem in TestFillMissingSeq: got aligned sequence %s", showSites(seq))
+		}
+	}
+
+	if usage.Is(checkMissingSeq("mask")) || !usage.Is(checkMissingSeq("guess")) {
+		t.Errorf("problem in TestFillMissingSeq: checkMissingSeq")
+	}
+}
+
+func TestReadReferenceIndexed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	refFile := filepath.Join(dir, "refs.fasta")
+	err = ioutil.WriteFile(refFile, []byte(">r1\nAAAA\n>r2\nACGT\nAC\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sq, err := biogosam.NewReference("r2", "", "", 6, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := biogosam.NewHeader(nil, []*biogosam.Reference{sq})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without an index, there should be only one record in the file
+	_, err = readReference(refFile, *header)
+	if err == nil {
+		t.Errorf("problem in TestReadReferenceIndexed: expected an error for two records without an index")
+	}
+
+	idx, err := fastaio.BuildIndex(refFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fastaio.WriteIndex(idx, fastaio.IndexPath(refFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := readReference(refFile, *header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.ID != "r2" || ref.Seq != "ACGTAC" {
+		t.Errorf("problem in TestReadReferenceIndexed: got %s %s, expected r2 ACGTAC", ref.ID, ref.Seq)
+	}
+	err = checkReference(*header, ref)
+	if err != nil {
+		t.Errorf("problem in TestReadReferenceIndexed: %v", err)
+	}
+}
+
+func TestCheckReference(t *testing.T) {
+	ref := fastaio.FastaRecord{ID: "ref", Seq: "ACGTAC"}
+	good := fmt.Sprintf("%x", md5.Sum([]byte("ACGTAC")))
+	bad := fmt.Sprintf("%x", md5.Sum([]byte("ACGTAA")))
+
+	tests := []struct {
+		header string
+		err    string
+	}{
+		{"@SQ\tSN:ref\tLN:6\n", ""},
+		{"@SQ\tSN:ref\tLN:6\tM5:" + good + "\n", ""},
+		// the reference is found by name among several
+		{"@SQ\tSN:other\tLN:10\n@SQ\tSN:ref\tLN:6\tM5:" + good + "\n", ""},
+		// without an @SQ line, there is nothing to check against
+		{"@HD\tVN:1.6\n", ""},
+		{"@SQ\tSN:ref\tLN:7\n", "length mismatch"},
+		{"@SQ\tSN:ref\tLN:6\tM5:" + bad + "\n", "checksum mismatch"},
+		{"@SQ\tSN:other\tLN:6\n", "name mismatch"},
+		{"@SQ\tSN:other\tLN:6\n@SQ\tSN:another\tLN:6\n", "name mismatch"},
+	}
+
+	for _, tt := range tests {
+		r, err := biogosam.NewReader(strings.NewReader(tt.header))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = checkReference(*r.Header(), ref)
+		switch {
+		case len(tt.err) == 0 && err != nil:
+			t.Errorf("problem in TestCheckReference: %q: %v", tt.header, err)
+		case len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("problem in TestCheckReference: %q: expected a %s, got %v", tt.header, tt.err, err)
+		}
+	}
+}
+
+func TestReferenceFromHeader(t *testing.T) {
+	dir := t.TempDir()
+
+	refFile := filepath.Join(dir, "ref.fasta")
+	err := ioutil.WriteFile(refFile, []byte(">ref\nacgtac\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/"+fmt.Sprintf("%x", md5.Sum([]byte("ACGTAC"))) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, "ACGTAC")
+	}))
+	defer server.Close()
+
+	defaultURL := md5URL
+	md5URL = server.URL + "/"
+	defer func() { md5URL = defaultURL }()
+
+	os.Setenv("GOFASTA_CACHE_DIR", dir)
+	defer os.Unsetenv("GOFASTA_CACHE_DIR")
+
+	tests := []struct {
+		tags  string
+		fails bool
+	}{
+		{"\tUR:" + refFile, false},
+		{"\tUR:file://" + refFile, false},
+		{"\tM5:" + fmt.Sprintf("%x", md5.Sum([]byte("ACGTAC"))), false},
+		{"\tM5:" + fmt.Sprintf("%x", md5.Sum([]byte("ACGTAA"))), true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 2; i++ {
+			r, err := biogosam.NewReader(strings.NewReader("@SQ\tSN:ref\tLN:6" + tt.tags + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			header := *r.Header()
+
+			ref, err := readReference(HeaderReference, header)
+			if err == nil {
+				err = checkReference(header, ref)
+			}
+			switch {
+			case tt.fails && err == nil:
+				t.Errorf("problem in TestReferenceFromHeader: expected an error for %q", tt.tags)
+			case !tt.fails && err != nil:
+				t.Errorf("problem in TestReferenceFromHeader: %q: %v", tt.tags, err)
+			case !tt.fails && (ref.ID != "ref" || ref.Seq != "ACGTAC"):
+				t.Errorf("problem in TestReferenceFromHeader: %q: got %s %s", tt.tags, ref.ID, ref.Seq)
+			}
+		}
+	}
+
+	// the good M5 is fetched once and then cached, and the bad one is never found
+	if requests != 3 {
+		t.Errorf("problem in TestReferenceFromHeader: expected 3 requests, got %d", requests)
+	}
+}
+
+// what the registry sends is only used and cached if it has the checksum that was asked for
+func TestFetchByMD5(t *testing.T) {
+	dir := t.TempDir()
+
+	checksum := fmt.Sprintf("%x", md5.Sum([]byte("ACGTAC")))
+
+	body := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	defaultURL := md5URL
+	md5URL = server.URL + "/"
+	defer func() { md5URL = defaultURL }()
+
+	os.Setenv("GOFASTA_CACHE_DIR", dir)
+	defer os.Unsetenv("GOFASTA_CACHE_DIR")
+
+	cacheDir := filepath.Join(dir, "references")
+
+	for _, bad := range []string{"ACGTAA", "ACG", "<html>not found</html>"} {
+		body = bad
+		_, err := fetchByMD5(checksum)
+		if err == nil {
+			t.Errorf("problem in TestFetchByMD5: expected an error for %q", bad)
+		}
+		files, _ := ioutil.ReadDir(cacheDir)
+		if len(files) > 0 {
+			t.Errorf("problem in TestFetchByMD5: %q left %s in the cache", bad, files[0].Name())
+		}
+	}
+
+	// the checksum is of the sequence in upper case without whitespace
+	body = "acgt\nac\n"
+	seq, err := fetchByMD5(checksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(seq) != body {
+		t.Errorf("problem in TestFetchByMD5: got %q, expected %q", seq, body)
+	}
+
+	// and it is now cached, so what the registry sends doesn't matter
+	body = "ACGTAA"
+	seq, err = fetchByMD5(checksum)
+	if err != nil || string(seq) != "acgt\nac\n" {
+		t.Errorf("problem in TestFetchByMD5: the cached sequence is %q (%v)", seq, err)
+	}
+
+	// unless the cache has been overwritten
+	err = ioutil.WriteFile(filepath.Join(cacheDir, checksum), []byte("ACGTAA"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = "ACGTAC"
+	seq, err = fetchByMD5(checksum)
+	if err != nil || string(seq) != "ACGTAC" {
+		t.Errorf("problem in TestFetchByMD5: after the cache was overwritten, got %q (%v)", seq, err)
+	}
+}
+
+// SAM files with Windows line endings give the same results, including from the tags at the
+// ends of the lines (read groups, and the MD tags that SNPs are found from)
+func TestCRLF(t *testing.T) {
+	dir := t.TempDir()
+
+	mdSam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n" +
+		"q1\t0\tref\t1\t60\t4M\t*\t0\t0\tACTT\t*\tMD:Z:2G1\n" +
+		"q2\t0\tref\t1\t60\t4M\t*\t0\t0\tACGA\t*\tMD:Z:3T\n"
+
+	run := func(rgSam string, sam string, name string) (string, string) {
+		samFile := filepath.Join(dir, name, "in.sam")
+		err := os.MkdirAll(filepath.Dir(samFile), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(samFile, []byte(rgSam), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fastaFile := filepath.Join(dir, name, "out.fasta")
+		opts := DefaultToMultiAlignOptions()
+		opts.ByReadGroup = true
+		opts.Threads = 2
+		err = ToMultiAlign(samFile, "", fastaFile, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fasta, err := ioutil.ReadFile(fastaFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(samFile, []byte(sam), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snpsFile := filepath.Join(dir, name, "snps.csv")
+		err = SNPs(samFile, "", snpsFile, 0, false, "skip", false, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snps, err := ioutil.ReadFile(snpsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(fasta), string(snps)
+	}
+
+	fasta, snps := run(readGroupSam, mdSam, "lf")
+	crlfFasta, crlfSnps := run(testutil.CRLF(readGroupSam), testutil.CRLF(mdSam), "crlf")
+
+	if crlfFasta != fasta {
+		t.Errorf("problem in TestCRLF: got\n%s\nexpected\n%s", crlfFasta, fasta)
+	}
+	if crlfSnps != snps || snps != "query,SNPs\nq1,G3T\nq2,T4A\n" {
+		t.Errorf("problem in TestCRLF: got\n%s\nexpected\n%s", crlfSnps, snps)
+	}
+}
@@ -0,0 +1,70 @@
+package sam
+
+import (
+	"github.com/cov-ert/gofasta/internal/cigar"
+)
+
+// siteState is the state of one site of a query's aligned sequence while it is being built. In
+// the sequence itself, a mapped site is its base (or an N), a deleted site is a '-', and an
+// unmapped site (one that no record covers, or that is in a region a record skips) is
+// unmappedSite. The states are ordered: where a query's records disagree about a site, the
+// highest state wins
+type siteState uint8
+
+const (
+	unmapped siteState = iota
+	deleted
+	mapped
+)
+
+// unmappedSite is how an unmapped site is held in an aligned sequence. It isn't printable, so
+// unlike the '*' that used to be used, it can't be confused with SAM's '*' (no SEQ or QUAL) or a
+// stop codon, and every output has to render it (see render). The aligned sequences only leave
+// this package in two ways, which both render them: as FastaRecords, which are only made by
+// getFastaRecord (for sam toMultiAlign, in every mode, ReadAligned and AlignRecords), and as the
+// query and reference of an alignPair made by blockToPairwiseAlignment (for sam toPairAlign and
+// sam variants). sam snps, minorVariants and indels read the records' CIGARs themselves, so never
+// make an aligned sequence. TestNoUnmappedSitesOut checks each of these
+const unmappedSite = cigar.Unmapped
+
+// stateOf is the state of a site of an aligned sequence
+func stateOf(b byte) siteState {
+	switch b {
+	case unmappedSite:
+		return unmapped
+	case '-':
+		return deleted
+	}
+	return mapped
+}
+
+// render writes the unmapped sites of an aligned sequence, in place, as they are output: as Ns if
+// pad, otherwise as '-'s before the query's first base and after its last, and as Ns between them
+// (so a sequence without any bases is all '-'s). Mapped and deleted sites are left as they are
+func render(seq []byte, pad bool) []byte {
+
+	first, last := len(seq), -1
+	if !pad {
+		for i, b := range seq {
+			if isLetter(b) {
+				if i < first {
+					first = i
+				}
+				last = i
+			}
+		}
+	}
+
+	for i, b := range seq {
+		if stateOf(b) != unmapped {
+			continue
+		}
+		if pad || (i > first && i < last) {
+			seq[i] = 'N'
+		} else {
+			seq[i] = '-'
+		}
+	}
+
+	return seq
+}
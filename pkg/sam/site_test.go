@@ -0,0 +1,169 @@
+package sam
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+)
+
+// sites is an aligned sequence written with '*'s for its unmapped sites, for tests
+func sites(s string) []byte {
+	return []byte(strings.ReplaceAll(s, "*", string(unmappedSite)))
+}
+
+// showSites writes an aligned sequence's unmapped sites as '*'s, for tests
+func showSites(seq []byte) string {
+	return strings.ReplaceAll(string(seq), string(unmappedSite), "*")
+}
+
+func TestStateOf(t *testing.T) {
+	for _, tt := range []struct {
+		b        byte
+		expected siteState
+	}{
+		{'A', mapped},
+		{'n', mapped},
+		{'-', deleted},
+		{unmappedSite, unmapped},
+		// a '*' isn't an unmapped site any more
+		{'*', mapped},
+	} {
+		if stateOf(tt.b) != tt.expected {
+			t.Errorf("problem in TestStateOf: %q: got %d, expected %d", tt.b, stateOf(tt.b), tt.expected)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	for _, tt := range []struct {
+		seq      string
+		pad      bool
+		expected string
+	}{
+		{"**AC*-*GT**", false, "--ACN-NGT--"},
+		{"**AC*-*GT**", true, "NNACN-NGTNN"},
+		{"*-**", false, "----"},
+		{"****", true, "NNNN"},
+		{"", false, ""},
+	} {
+		got := string(render(sites(tt.seq), tt.pad))
+		if got != tt.expected {
+			t.Errorf("problem in TestRender: %s (pad %v): got %s, expected %s", tt.seq, tt.pad, got, tt.expected)
+		}
+	}
+}
+
+// unmappedSam has queries with every kind of unmapped site: before and after the records, in a
+// region a record skips (N), between two records, and under a skip that another record covers
+var unmappedSam = "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:24\n@RG\tID:rg1\tSM:s1\n@RG\tID:rg2\tSM:s2\n" +
+	"q1\t0\tref\t3\t60\t4M4N4M\t*\t0\t0\tACGTACGT\t*\tRG:Z:rg1\n" +
+	"q2\t0\tref\t1\t60\t4M\t*\t0\t0\tACGT\t*\tRG:Z:rg1\n" +
+	"q2\t2048\tref\t13\t60\t2M1I3M\t*\t0\t0\tACTGTA\t*\tRG:Z:rg2\n" +
+	"q3\t0\tref\t5\t60\t2M6N2M1D2M\t*\t0\t0\tACGTAC\t*\tRG:Z:rg2\n" +
+	"q3\t2048\tref\t7\t60\t6M\t*\t0\t0\tGTACGT\t*\tRG:Z:rg2\n" +
+	"q4\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\t*\n"
+
+// unmapped sites are always rendered before they are output (see unmappedSite)
+func TestNoUnmappedSitesOut(t *testing.T) {
+	dir := t.TempDir()
+
+	samFile := filepath.Join(dir, "in.sam")
+	refFile := filepath.Join(dir, "ref.fasta")
+	gbFile := filepath.Join(dir, "ref.gb")
+	for name, content := range map[string]string{
+		samFile: unmappedSam,
+		refFile: ">ref\nACGTACGTACGTACGTACGTACGT\n",
+		gbFile: `LOCUS       ref                       24 bp    DNA     linear   VRL 01-JAN-2021
+FEATURES             Location/Qualifiers
+     CDS             1..24
+                     /gene="g1"
+ORIGIN
+        1 acgtacgtac gtacgtacgt acgt
+//
+`,
+	} {
+		err := ioutil.WriteFile(name, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(name string, out []byte) {
+		if len(out) == 0 {
+			t.Errorf("problem in TestNoUnmappedSitesOut: %s wrote nothing", name)
+		}
+		if bytes.IndexByte(out, unmappedSite) >= 0 {
+			t.Errorf("problem in TestNoUnmappedSitesOut: %s wrote an unmapped site:\n%q", name, out)
+		}
+	}
+
+	for i, set := range []func(*ToMultiAlignOptions){
+		func(opts *ToMultiAlignOptions) {},
+		func(opts *ToMultiAlignOptions) { opts.Pad = true },
+		func(opts *ToMultiAlignOptions) { opts.Trim, opts.TrimStart, opts.TrimEnd = true, 2, 20 },
+		func(opts *ToMultiAlignOptions) { opts.Trim, opts.TrimStart, opts.TrimEnd, opts.Pad = true, 2, 20, true },
+		func(opts *ToMultiAlignOptions) { opts.Flatten = "quality" },
+		func(opts *ToMultiAlignOptions) { opts.MinDepth = 2 },
+		func(opts *ToMultiAlignOptions) { opts.PerRead = true },
+		func(opts *ToMultiAlignOptions) { opts.ByReadGroup = true },
+		func(opts *ToMultiAlignOptions) { opts.ByReadGroup, opts.MinDepth = true, 2 },
+		func(opts *ToMultiAlignOptions) { opts.TrimEnds = 1 },
+	} {
+		outFile := filepath.Join(dir, "out.fasta")
+		opts := DefaultToMultiAlignOptions()
+		set(&opts)
+		err := ToMultiAlign(samFile, "", outFile, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(fmt.Sprintf("ToMultiAlign with options %d", i), out)
+	}
+
+	for _, omitIns := range []bool{true, false} {
+		outDir := filepath.Join(dir, fmt.Sprintf("pairs-%v", omitIns))
+		err := ToPairAlign(samFile, refFile, gbFile, "", outDir, false, omitIns, 0, false, "skip", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := filepath.Glob(filepath.Join(outDir, "*.fasta"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []byte
+		for _, file := range files {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, b...)
+		}
+		check(fmt.Sprintf("ToPairAlign (omitIns %v)", omitIns), out)
+	}
+
+	records := func(cFR chan fastaio.FastaRecord, cErr chan error, cDone chan bool) []byte {
+		var out []byte
+		for _, FR := range collectAligned(t, cFR, cErr, cDone) {
+			out = append(out, FR.Seq...)
+		}
+		return out
+	}
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go ReadAligned(samFile, 0, false, "skip", 2, cFR, cErr, cDone)
+	check("ReadAligned", records(cFR, cErr, cDone))
+
+	go AlignRecords(streamRecords(t, unmappedSam), 24, 0, false, "skip", 2, cFR, cErr, cDone)
+	check("AlignRecords", records(cFR, cErr, cDone))
+}
@@ -0,0 +1,422 @@
+package sam
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/usage"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// snpOccurrence is one SNP in one SAM record, in 0-based reference coordinates
+type snpOccurrence struct {
+	pos   int
+	refAl byte
+	queAl byte
+}
+
+// samSNPs is a struct for one query's SNPs, with an index which is used to retain
+// input order in the output
+type samSNPs struct {
+	queryname string
+	snps      []string
+	idx       int
+}
+
+// mdMismatch is a mismatch described by an MD tag: offset is the number of
+// reference bases that the MD tag covers before the mismatch (so it counts
+// aligned bases and deleted bases, but not skipped (N) bases)
+type mdMismatch struct {
+	offset int
+	refAl  byte
+}
+
+// parseMD parses the value of an MD tag, e.g. "10A5^AC6", into a list of
+// mismatches, ignoring deletions (which are only used to move along the reference)
+func parseMD(md string) ([]mdMismatch, error) {
+
+	mismatches := make([]mdMismatch, 0)
+
+	offset := 0
+	number := 0
+	deletion := false
+
+	for i := 0; i < len(md); i++ {
+		c := md[i]
+
+		switch {
+		case c >= '0' && c <= '9':
+			number = number*10 + int(c-'0')
+			deletion = false
+
+		case c == '^':
+			offset += number
+			number = 0
+			deletion = true
+
+		case unicode.IsLetter(rune(c)):
+			offset += number
+			number = 0
+			if !deletion {
+				mismatches = append(mismatches, mdMismatch{offset: offset, refAl: byte(unicode.ToUpper(rune(c)))})
+			}
+			offset++
+
+		default:
+			return []mdMismatch{}, fmt.Errorf("couldn't parse MD tag: %s", md)
+		}
+	}
+
+	return mismatches, nil
+}
+
+// getSNPsFromMD gets the SNPs in one SAM record using its MD tag and its CIGAR,
+// without needing the reference sequence
+func getSNPsFromMD(samLine biogosam.Record, md string) ([]snpOccurrence, error) {
+
+	mismatches, err := parseMD(md)
+	if err != nil {
+		return []snpOccurrence{}, err
+	}
+
+	bp := getExpandedSeq(samLine.Seq)
+	defer putExpandedSeq(bp)
+	SEQ := *bp
+
+	snps := make([]snpOccurrence, 0)
+
+	qpos := 0
+	rpos := samLine.Pos
+	mdpos := 0
+	m := 0
+
+	for _, op := range samLine.Cigar {
+		operation := op.Type().String()
+		size := op.Len()
+		consumes := op.Type().Consumes()
+
+		if operation == "M" || operation == "=" || operation == "X" {
+			for m < len(mismatches) && mismatches[m].offset < mdpos+size {
+				if mismatches[m].offset < mdpos {
+					return []snpOccurrence{}, fmt.Errorf("MD tag is inconsistent with CIGAR for read: %s", samLine.Name)
+				}
+				d := mismatches[m].offset - mdpos
+				snps = append(snps, snpOccurrence{pos: rpos + d, refAl: mismatches[m].refAl, queAl: SEQ[qpos+d]})
+				m++
+			}
+		}
+
+		if operation != "N" {
+			mdpos += size * consumes.Reference
+		}
+		qpos += size * consumes.Query
+		rpos += size * consumes.Reference
+	}
+
+	if m != len(mismatches) {
+		return []snpOccurrence{}, fmt.Errorf("MD tag is inconsistent with CIGAR for read: %s", samLine.Name)
+	}
+
+	return snps, nil
+}
+
+// getSNPsFromRef gets the SNPs in one SAM record by walking its CIGAR along the
+// reference sequence. This is used for records that don't have an MD tag
+func getSNPsFromRef(samLine biogosam.Record, ref []byte) ([]snpOccurrence, error) {
+
+	bp := getExpandedSeq(samLine.Seq)
+	defer putExpandedSeq(bp)
+	SEQ := *bp
+
+	snps := make([]snpOccurrence, 0)
+
+	qpos := 0
+	rpos := samLine.Pos
+
+	for _, op := range samLine.Cigar {
+		operation := op.Type().String()
+		size := op.Len()
+		consumes := op.Type().Consumes()
+
+		if operation == "M" || operation == "=" || operation == "X" {
+			if rpos+size > len(ref) {
+				return []snpOccurrence{}, fmt.Errorf("read aligns past the end of the reference: %s", samLine.Name)
+			}
+			for i := 0; i < size; i++ {
+				if ref[rpos+i] != SEQ[qpos+i] {
+					snps = append(snps, snpOccurrence{pos: rpos + i, refAl: ref[rpos+i], queAl: SEQ[qpos+i]})
+				}
+			}
+		}
+
+		qpos += size * consumes.Query
+		rpos += size * consumes.Reference
+	}
+
+	return snps, nil
+}
+
+// getSNPsFromCigar gets the SNPs in one SAM record from the X (mismatch) operations in
+// its CIGAR (e.g. minimap2 --eqx), instead of comparing every aligned base with the
+// reference, which is only used for the reference allele at each mismatch. A CIGAR
+// with M operations doesn't say which bases are mismatches, so it is an error
+func getSNPsFromCigar(samLine biogosam.Record, ref []byte) ([]snpOccurrence, error) {
+
+	bp := getExpandedSeq(samLine.Seq)
+	defer putExpandedSeq(bp)
+	SEQ := *bp
+
+	snps := make([]snpOccurrence, 0)
+
+	qpos := 0
+	rpos := samLine.Pos
+
+	for _, op := range samLine.Cigar {
+		operation := op.Type().String()
+		size := op.Len()
+		consumes := op.Type().Consumes()
+
+		switch operation {
+		case "M":
+			return []snpOccurrence{}, fmt.Errorf("can't call SNPs from the CIGAR of read %s: it has M operations instead of =/X (e.g. use minimap2 --eqx)", samLine.Name)
+		case "X":
+			if rpos+size > len(ref) {
+				return []snpOccurrence{}, fmt.Errorf("read aligns past the end of the reference: %s", samLine.Name)
+			}
+			for i := 0; i < size; i++ {
+				snps = append(snps, snpOccurrence{pos: rpos + i, refAl: ref[rpos+i], queAl: SEQ[qpos+i]})
+			}
+		}
+
+		qpos += size * consumes.Query
+		rpos += size * consumes.Reference
+	}
+
+	return snps, nil
+}
+
+// blockToSNPs gets the SNPs for each block of SAM records (one query's primary and
+// supplementary alignments) from a channel. SNPs between ambiguous nucleotides are
+// not reported, and SNPs that are present in more than one record are only reported once.
+// If fromCigar, the SNPs are the X operations in each record's CIGAR (see getSNPsFromCigar).
+func blockToSNPs(cSR chan samRecords, cSNPs chan samSNPs, cErr chan error, ref []byte, fromCigar bool) {
+
+	EA := encoding.MakeEncodingArray()
+
+	for group := range cSR {
+
+		occurrences := make([]snpOccurrence, 0)
+
+		for _, line := range group.records {
+			var snps []snpOccurrence
+			var err error
+
+			if fromCigar {
+				snps, err = getSNPsFromCigar(line, ref)
+			} else if aux, ok := line.Tag([]byte("MD")); ok {
+				md, isString := aux.Value().(string)
+				if !isString {
+					cErr <- fmt.Errorf("couldn't parse MD tag for read: %s", line.Name)
+					return
+				}
+				snps, err = getSNPsFromMD(line, md)
+			} else if len(ref) > 0 {
+				snps, err = getSNPsFromRef(line, ref)
+			} else {
+				err = errors.New("no MD tag for read: " + line.Name + ": please provide the --reference")
+			}
+
+			if err != nil {
+				cErr <- err
+				return
+			}
+
+			occurrences = append(occurrences, snps...)
+		}
+
+		sort.SliceStable(occurrences, func(i, j int) bool {
+			return occurrences[i].pos < occurrences[j].pos
+		})
+
+		SS := samSNPs{queryname: group.records[0].Name, idx: group.idx}
+		SS.snps = make([]string, 0)
+
+		previous := ""
+		for _, snp := range occurrences {
+			if (EA[snp.refAl] & EA[snp.queAl]) >= 16 {
+				continue
+			}
+			s := string(snp.refAl) + strconv.Itoa(snp.pos+1) + string(snp.queAl)
+			if s == previous {
+				continue
+			}
+			SS.snps = append(SS.snps, s)
+			previous = s
+		}
+
+		cSNPs <- SS
+	}
+}
+
+// writeSNPs writes the snps for each query to stdout or a file as they arrive.
+// It uses a map to write things in the same order as they are in the input file.
+func writeSNPs(outfile string, cSNPs chan samSNPs, cWriteDone chan bool, cErr chan error) {
+
+	outputMap := make(map[int]samSNPs)
+
+	counter := 0
+
+	var f *os.File
+	var err error
+
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			cErr <- err
+		}
+	} else {
+		f = os.Stdout
+	}
+
+	defer f.Close()
+
+	_, err = f.WriteString("query,SNPs\n")
+	if err != nil {
+		cErr <- err
+	}
+
+	for SS := range cSNPs {
+		outputMap[SS.idx] = SS
+
+		for {
+			SS, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			_, err = f.WriteString(SS.queryname + "," + strings.Join(SS.snps, "|") + "\n")
+			if err != nil {
+				cErr <- err
+			}
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	cWriteDone <- true
+}
+
+// SNPs finds snps relative to the reference for each query in a SAM file. Where
+// records have an MD tag, SNPs are called from the tag and the CIGAR alone, so
+// the reference is only needed for records that don't have one. If fromCigar, SNPs are
+// called from the X operations in the CIGARs instead, which needs the reference. If
+// skipCorrupt, malformed SAM records are skipped instead of being an error, and records
+// without a SEQ are skipped or masked according to missingSeq.
+func SNPs(samFile string, referenceFile string, outfile string, minQual int, skipCorrupt bool, missingSeq string, fromCigar bool, threads int) error {
+
+	defer profiling.Region("sam snps")()
+
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	if fromCigar && len(referenceFile) == 0 {
+		return usage.New("--from-cigar needs the --reference (for the reference allele at each mismatch)")
+	}
+
+	err := checkMissingSeq(missingSeq)
+	if err != nil {
+		return err
+	}
+
+	cErr := make(chan error)
+
+	cSR := make(chan samRecords, threads)
+	cSH := make(chan biogosam.Header)
+	cSNPs := make(chan samSNPs)
+
+	cReadDone := make(chan bool)
+	cSNPsDone := make(chan bool)
+	cWriteDone := make(chan bool)
+
+	go groupSamRecords(samFile, minQual, skipCorrupt, missingSeq, cSH, cSR, cReadDone, cErr)
+
+	var header biogosam.Header
+	select {
+	case err := <-cErr:
+		return err
+	case header = <-cSH:
+	}
+
+	var ref fastaio.FastaRecord
+
+	if len(referenceFile) > 0 {
+		ref, err = readReference(referenceFile, header)
+		if err != nil {
+			return err
+		}
+		err = checkReference(header, ref)
+		if err != nil {
+			return err
+		}
+	}
+
+	go writeSNPs(outfile, cSNPs, cWriteDone, cErr)
+
+	var wgSNPs sync.WaitGroup
+	wgSNPs.Add(threads)
+
+	for n := 0; n < threads; n++ {
+		go func() {
+			blockToSNPs(cSR, cSNPs, cErr, []byte(ref.Seq), fromCigar)
+			wgSNPs.Done()
+		}()
+	}
+
+	go func() {
+		wgSNPs.Wait()
+		cSNPsDone <- true
+	}()
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cReadDone:
+			close(cSR)
+			close(cSH)
+			n--
+		}
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cSNPsDone:
+			close(cSNPs)
+			n--
+		}
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cWriteDone:
+			n--
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package sam
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+func TestParseMD(t *testing.T) {
+	mismatches, err := parseMD("5A14^CC8g17")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(mismatches) != 2 {
+		t.Fatalf("problem in TestParseMD: wrong number of mismatches (%d)", len(mismatches))
+	}
+
+	// 5 matches then the mismatch; 14 matches, 2 deleted bases and 8 matches then the mismatch
+	if mismatches[0].offset != 5 || mismatches[0].refAl != 'A' {
+		t.Errorf("problem in TestParseMD: %d %s", mismatches[0].offset, string(mismatches[0].refAl))
+	}
+	if mismatches[1].offset != 30 || mismatches[1].refAl != 'G' {
+		t.Errorf("problem in TestParseMD: %d %s", mismatches[1].offset, string(mismatches[1].refAl))
+	}
+
+	_, err = parseMD("5A1*4")
+	if err == nil {
+		t.Errorf("problem in TestParseMD: expected an error for a bad MD tag")
+	}
+}
+
+func TestGetSNPsFromMDMatchesRef(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 5000)
+
+	for i := 0; i < 100; i++ {
+		rd := testutil.RandomRead(r, "read", ref, 500)
+		cigar, err := biogosam.ParseCigar([]byte(rd.Cigar))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := biogosam.Record{Name: rd.Name, Pos: rd.Pos, Cigar: cigar, Seq: biogosam.NewSeq(rd.Seq)}
+
+		fromMD, err := getSNPsFromMD(rec, rd.MD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromRef, err := getSNPsFromRef(rec, ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(fromMD, fromRef) {
+			t.Errorf("problem in TestGetSNPsFromMDMatchesRef: %s %s: %v != %v", rd.Cigar, rd.MD, fromMD, fromRef)
+		}
+	}
+}
+
+func TestGetSNPsFromCigar(t *testing.T) {
+	ref := []byte("ACGTACGTACGT")
+
+	cigar, err := biogosam.ParseCigar([]byte("2=1X1I2=2D1X"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := biogosam.Record{Name: "read", Pos: 1, Cigar: cigar, Seq: biogosam.NewSeq([]byte("CGATACG"))}
+
+	snps, err := getSNPsFromCigar(rec, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []snpOccurrence{{pos: 3, refAl: 'T', queAl: 'A'}, {pos: 8, refAl: 'A', queAl: 'G'}}
+	if !reflect.DeepEqual(snps, expected) {
+		t.Errorf("problem in TestGetSNPsFromCigar: got %v, expected %v", snps, expected)
+	}
+
+	fromRef, err := getSNPsFromRef(rec, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snps, fromRef) {
+		t.Errorf("problem in TestGetSNPsFromCigar: %v != %v", snps, fromRef)
+	}
+
+	rec.Cigar, _ = biogosam.ParseCigar([]byte("3M1I2M2D1M"))
+	_, err = getSNPsFromCigar(rec, ref)
+	if err == nil {
+		t.Errorf("problem in TestGetSNPsFromCigar: expected an error for a CIGAR with M operations")
+	}
+}
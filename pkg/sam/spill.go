@@ -0,0 +1,485 @@
+package sam
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/internal/memlimit"
+)
+
+// indelKey is one occurrence of an indel in a query (or, with no query, the indel
+// itself). Insertions have a seq, and deletions a length. read is the read that it is
+// in, which is only kept for its readStats, and file is which of the inputs it is from;
+// neither is spilled. An occurrence read from an earlier report that only has counts
+// stands for count occurrences (otherwise count is 0)
+type indelKey struct {
+	pos    int
+	length int
+	seq    string
+	query  string
+	read   readInfo
+	file   int
+	count  int
+}
+
+// readInfo is the strand and the span on the reference of the read that an indel is in
+type readInfo struct {
+	reverse bool
+	start   int
+	end     int
+}
+
+// readStats is how the reads that have an indel are spread: how many are on each strand,
+// and whether they all start (or all end) at the same position, which is typical of an
+// artefact rather than a real indel
+type readStats struct {
+	Forward   int  `json:"forward"`
+	Reverse   int  `json:"reverse"`
+	SameStart bool `json:"same_start"`
+	SameEnd   bool `json:"same_end"`
+	start     int
+	end       int
+}
+
+// add adds a read to the stats
+func (s *readStats) add(r readInfo) {
+	if s.Forward+s.Reverse == 0 {
+		s.start, s.end = r.start, r.end
+		s.SameStart, s.SameEnd = true, true
+	}
+	if r.start != s.start {
+		s.SameStart = false
+	}
+	if r.end != s.end {
+		s.SameEnd = false
+	}
+	if r.reverse {
+		s.Reverse++
+	} else {
+		s.Forward++
+	}
+}
+
+// readStatsHeader is the names of the columns that the readStats of an indel are written in
+var readStatsHeader = []string{"forward", "reverse", "same_start", "same_end"}
+
+// columns is the readStats, as they are written in the report
+func (s *readStats) columns() []string {
+	return []string{strconv.Itoa(s.Forward), strconv.Itoa(s.Reverse), strconv.FormatBool(s.SameStart), strconv.FormatBool(s.SameEnd)}
+}
+
+// less orders indels by position, then length, then inserted sequence, then query
+func (a indelKey) less(b indelKey) bool {
+	if a.pos != b.pos {
+		return a.pos < b.pos
+	}
+	if a.length != b.length {
+		return a.length < b.length
+	}
+	if a.seq != b.seq {
+		return a.seq < b.seq
+	}
+	return a.query < b.query
+}
+
+// lessByQuery orders occurrences by query, then position, then length, then inserted sequence
+func (a indelKey) lessByQuery(b indelKey) bool {
+	if a.query != b.query {
+		return a.query < b.query
+	}
+	if a.pos != b.pos {
+		return a.pos < b.pos
+	}
+	if a.length != b.length {
+		return a.length < b.length
+	}
+	return a.seq < b.seq
+}
+
+// sameIndel is true if a and b are occurrences of the same indel
+func (a indelKey) sameIndel(b indelKey) bool {
+	return a.pos == b.pos && a.length == b.length && a.seq == b.seq
+}
+
+func sortIndelKeys(keys []indelKey) {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+}
+
+// sortChunk sorts the occurrences in memory in the table's order
+func (t *indelTable) sortChunk() {
+	if t.byQuery {
+		sort.Slice(t.chunk, func(i, j int) bool { return t.chunk[i].lessByQuery(t.chunk[j]) })
+		return
+	}
+	sortIndelKeys(t.chunk)
+}
+
+// spillSize is how many occurrences an indelTable holds in memory before it sorts
+// them and writes them to a temporary file (fewer, if they wouldn't fit in the
+// --max-mem budget)
+var spillSize = 1 << 20
+
+// indelKeySize is roughly how much memory an occurrence takes, with its strings
+const indelKeySize = 128
+
+// indelTable collects the occurrences of indels. If the queries with each indel
+// aren't needed, it only keeps a count per distinct indel. Otherwise the occurrences
+// are sorted in chunks of up to spillSize, which are spilled to temporary files and merged
+// when the table is read, so that memory use is bounded however big the SAM file is.
+// With withReadStats, the readStats of each distinct indel are kept as well, and if
+// files isn't nil (the names of the inputs), which of them each distinct indel is in.
+// A table made by newQueryTable reads its occurrences back by query instead (see occurrences)
+type indelTable struct {
+	noSamples bool
+	byQuery   bool
+	counts    map[indelKey]int
+	stats     map[indelKey]*readStats
+	files     []string
+	seenIn    map[indelKey][]int
+	chunk     []indelKey
+	chunkSize int
+	spills    []string
+	dir       string
+}
+
+func newIndelTable(noSamples bool, withReadStats bool, files []string) *indelTable {
+	t := &indelTable{noSamples: noSamples, counts: make(map[indelKey]int), chunk: make([]indelKey, 0), chunkSize: memlimit.Batch(spillSize, indelKeySize)}
+	if withReadStats {
+		t.stats = make(map[indelKey]*readStats)
+	}
+	if files != nil {
+		t.files = files
+		t.seenIn = make(map[indelKey][]int)
+	}
+	return t
+}
+
+// newQueryTable returns a table whose occurrences are read back by query, then position (see
+// lessByQuery), e.g. for the insertions fasta
+func newQueryTable() *indelTable {
+	t := newIndelTable(false, false, nil)
+	t.byQuery = true
+	return t
+}
+
+// indel is the indel that k is an occurrence of
+func (k indelKey) indel() indelKey {
+	return indelKey{pos: k.pos, length: k.length, seq: k.seq}
+}
+
+// add records one occurrence of an indel
+func (t *indelTable) add(k indelKey) error {
+	if t.stats != nil {
+		s, ok := t.stats[k.indel()]
+		if !ok {
+			s = &readStats{}
+			t.stats[k.indel()] = s
+		}
+		s.add(k.read)
+	}
+	if t.seenIn != nil {
+		t.seenIn[k.indel()] = addFile(t.seenIn[k.indel()], k.file)
+	}
+	if t.noSamples {
+		if k.count > 0 {
+			t.counts[k.indel()] += k.count
+		} else {
+			t.counts[k.indel()]++
+		}
+		return nil
+	}
+	if k.count > 0 {
+		return errors.New(t.files[k.file] + " has the number of queries with each indel rather than their names, so it can only be merged with --no-samples (and no --insertions-fasta)")
+	}
+	k.read = readInfo{}
+	k.file = 0
+	t.chunk = append(t.chunk, k)
+	if len(t.chunk) >= t.chunkSize {
+		return t.spill()
+	}
+	return nil
+}
+
+// spill sorts the occurrences in memory and writes them to a temporary file
+func (t *indelTable) spill() error {
+
+	if len(t.dir) == 0 {
+		dir, err := ioutil.TempDir("", "gofasta-indels")
+		if err != nil {
+			return err
+		}
+		t.dir = dir
+	}
+
+	t.sortChunk()
+
+	f, err := ioutil.TempFile(t.dir, "spill")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, k := range t.chunk {
+		_, err = w.WriteString(strconv.Itoa(k.pos) + "\t" + strconv.Itoa(k.length) + "\t" + k.seq + "\t" + k.query + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	t.spills = append(t.spills, f.Name())
+	t.chunk = t.chunk[:0]
+
+	return nil
+}
+
+// readStats is the readStats of an indel, or nil if the table doesn't keep them
+func (t *indelTable) readStats(k indelKey) *readStats {
+	if t.stats == nil {
+		return nil
+	}
+	return t.stats[k.indel()]
+}
+
+// addFile adds a file to the sorted list of the files that an indel is in, if it isn't already there
+func addFile(files []int, file int) []int {
+	i := sort.SearchInts(files, file)
+	if i < len(files) && files[i] == file {
+		return files
+	}
+	files = append(files, 0)
+	copy(files[i+1:], files[i:])
+	files[i] = file
+	return files
+}
+
+// inputs is the names of the inputs that an indel is in, or nil if the table doesn't keep them
+func (t *indelTable) inputs(k indelKey) []string {
+	if t.seenIn == nil {
+		return nil
+	}
+	names := make([]string, 0)
+	for _, i := range t.seenIn[k.indel()] {
+		names = append(names, t.files[i])
+	}
+	return names
+}
+
+// cleanup removes any spilled files
+func (t *indelTable) cleanup() {
+	if len(t.dir) > 0 {
+		os.RemoveAll(t.dir)
+	}
+}
+
+// spillCursor is the next occurrence in one spilled file
+type spillCursor struct {
+	s *bufio.Scanner
+	k indelKey
+}
+
+func (c *spillCursor) advance() (bool, error) {
+	if !c.s.Scan() {
+		return false, c.s.Err()
+	}
+	fields := strings.SplitN(c.s.Text(), "\t", 4)
+	if len(fields) != 4 {
+		return false, errors.New("corrupt spill file: " + c.s.Text())
+	}
+	pos, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return false, err
+	}
+	length, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false, err
+	}
+	c.k = indelKey{pos: pos, length: length, seq: fields[2], query: fields[3]}
+	return true, nil
+}
+
+// spillHeap is a min-heap of cursors, ordered by their next occurrence (by query, if byQuery)
+type spillHeap struct {
+	cursors []*spillCursor
+	byQuery bool
+}
+
+func (h spillHeap) Len() int { return len(h.cursors) }
+func (h spillHeap) Less(i, j int) bool {
+	if h.byQuery {
+		return h.cursors[i].k.lessByQuery(h.cursors[j].k)
+	}
+	return h.cursors[i].k.less(h.cursors[j].k)
+}
+func (h spillHeap) Swap(i, j int)       { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+func (h *spillHeap) Push(x interface{}) { h.cursors = append(h.cursors, x.(*spillCursor)) }
+func (h *spillHeap) Pop() interface{} {
+	old := h.cursors
+	c := old[len(old)-1]
+	h.cursors = old[:len(old)-1]
+	return c
+}
+
+// occurrences calls fn with every occurrence, in order (by query, if byQuery), merging the
+// spilled files
+func (t *indelTable) occurrences(fn func(k indelKey) error) error {
+
+	if len(t.spills) == 0 {
+		t.sortChunk()
+		for _, k := range t.chunk {
+			err := fn(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(t.chunk) > 0 {
+		err := t.spill()
+		if err != nil {
+			return err
+		}
+	}
+
+	h := spillHeap{cursors: make([]*spillCursor, 0, len(t.spills)), byQuery: t.byQuery}
+
+	for _, name := range t.spills {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		c := &spillCursor{s: bufio.NewScanner(f)}
+		ok, err := c.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.cursors = append(h.cursors, c)
+		}
+	}
+
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		c := h.cursors[0]
+		err := fn(c.k)
+		if err != nil {
+			return err
+		}
+		ok, err := c.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	return nil
+}
+
+// each calls fn once for each distinct indel, in order, with the queries that have
+// it (in order; nil if the table has noSamples) and how many there are
+func (t *indelTable) each(fn func(k indelKey, samples []string, count int) error) error {
+
+	if t.noSamples {
+		keys := make([]indelKey, 0, len(t.counts))
+		for k := range t.counts {
+			keys = append(keys, k)
+		}
+		sortIndelKeys(keys)
+		for _, k := range keys {
+			err := fn(k, nil, t.counts[k])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var current indelKey
+	samples := make([]string, 0)
+
+	err := t.occurrences(func(k indelKey) error {
+		if len(samples) > 0 && !k.sameIndel(current) {
+			err := fn(current, samples, len(samples))
+			if err != nil {
+				return err
+			}
+			samples = make([]string, 0)
+		}
+		current = k
+		samples = append(samples, k.query)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(samples) > 0 {
+		return fn(current, samples, len(samples))
+	}
+
+	return nil
+}
+
+// eachByPosition is each, except that if byCount, the indels at each position are
+// ordered by how many queries have them (most first), instead of by length and
+// inserted sequence (which still breaks ties)
+func (t *indelTable) eachByPosition(byCount bool, fn func(k indelKey, samples []string, count int) error) error {
+
+	if !byCount {
+		return t.each(fn)
+	}
+
+	type group struct {
+		k       indelKey
+		samples []string
+		count   int
+	}
+
+	groups := make([]group, 0)
+
+	flush := func() error {
+		sort.SliceStable(groups, func(i, j int) bool { return groups[i].count > groups[j].count })
+		for _, g := range groups {
+			err := fn(g.k, g.samples, g.count)
+			if err != nil {
+				return err
+			}
+		}
+		groups = groups[:0]
+		return nil
+	}
+
+	err := t.each(func(k indelKey, samples []string, count int) error {
+		if len(groups) > 0 && groups[0].k.pos != k.pos {
+			err := flush()
+			if err != nil {
+				return err
+			}
+		}
+		groups = append(groups, group{k: k, samples: samples, count: count})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
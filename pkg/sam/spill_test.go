@@ -0,0 +1,131 @@
+package sam
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/memlimit"
+)
+
+// tableContents reads an indelTable back as a string
+func tableContents(t *testing.T, table *indelTable) string {
+	var b strings.Builder
+	err := table.each(func(k indelKey, samples []string, count int) error {
+		fmt.Fprintf(&b, "%d %d %s %d %s\n", k.pos, k.length, k.seq, count, strings.Join(samples, "|"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestIndelTableSpills(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	keys := make([]indelKey, 1000)
+	for i := range keys {
+		keys[i] = indelKey{pos: r.Intn(20), length: r.Intn(3), query: "q" + fmt.Sprint(r.Intn(50))}
+	}
+
+	inMemory := newIndelTable(false, false, nil)
+	for _, k := range keys {
+		inMemory.add(k)
+	}
+	expected := tableContents(t, inMemory)
+
+	defer func(n int) { spillSize = n }(spillSize)
+	spillSize = 7
+
+	spilled := newIndelTable(false, false, nil)
+	defer spilled.cleanup()
+	for _, k := range keys {
+		err := spilled.add(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(spilled.spills) == 0 {
+		t.Fatal("problem in TestIndelTableSpills: nothing was spilled")
+	}
+
+	if got := tableContents(t, spilled); got != expected {
+		t.Errorf("problem in TestIndelTableSpills: spilled table is different to the in-memory one")
+	}
+
+	// a --max-mem budget that only has room for a few occurrences at a time spills them too
+	spillSize = 1 << 20
+	memlimit.Set(7 * indelKeySize * 8)
+	budgeted := newIndelTable(false, false, nil)
+	memlimit.Set(0)
+	defer budgeted.cleanup()
+	for _, k := range keys {
+		err := budgeted.add(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(budgeted.spills) == 0 {
+		t.Fatal("problem in TestIndelTableSpills: nothing was spilled with a --max-mem budget")
+	}
+
+	if got := tableContents(t, budgeted); got != expected {
+		t.Errorf("problem in TestIndelTableSpills: table spilled with a --max-mem budget is different to the in-memory one")
+	}
+
+	counted := newIndelTable(true, false, nil)
+	for _, k := range keys {
+		counted.add(k)
+	}
+	err := counted.each(func(k indelKey, samples []string, count int) error {
+		if samples != nil {
+			t.Errorf("problem in TestIndelTableSpills: a table with noSamples returned samples")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// a table from newQueryTable reads its occurrences back by query, whether or not they were spilled
+func TestQueryTableSpills(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	defer func(n int) { spillSize = n }(spillSize)
+	spillSize = 7
+
+	table := newQueryTable()
+	defer table.cleanup()
+	for i := 0; i < 500; i++ {
+		err := table.add(indelKey{pos: r.Intn(20), seq: "ACGT"[:1+r.Intn(3)], query: "q" + fmt.Sprint(r.Intn(50))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(table.spills) == 0 {
+		t.Fatal("problem in TestQueryTableSpills: nothing was spilled")
+	}
+
+	var previous indelKey
+	n := 0
+	err := table.occurrences(func(k indelKey) error {
+		if n > 0 && k.lessByQuery(previous) {
+			t.Errorf("problem in TestQueryTableSpills: %v came after %v", k, previous)
+		}
+		previous = k
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 500 {
+		t.Errorf("problem in TestQueryTableSpills: got %d occurrences, expected 500", n)
+	}
+}
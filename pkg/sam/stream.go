@@ -0,0 +1,204 @@
+package sam
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/pipeline"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// groupRecords is groupSamRecords for records that have already been read, which are grouped
+// into a block for each query in the same way. The stream is read until it is closed, even after
+// an error (the rest of it is discarded), so that the caller isn't left blocked sending to it
+func groupRecords(records <-chan *biogosam.Record, minQual int, skipCorrupt bool, missingSeq string, chnl chan samRecords, cerr chan error) {
+
+	counter := 0
+	nRead := 0
+	nSkipped := 0
+
+	block := samRecords{idx: counter, end: -1}
+
+	for rec := range records {
+		nRead++
+
+		keep, err := acceptRecord(rec, nRead, false, skipCorrupt, missingSeq)
+		if err != nil {
+			cerr <- err
+			for range records {
+			}
+			return
+		}
+		if !keep {
+			nSkipped++
+			continue
+		}
+
+		maskLowQuality(rec, minQual)
+
+		if len(block.records) > 0 && rec.Name != block.records[0].Name {
+			chnl <- block
+			counter++
+			block = samRecords{idx: counter, end: -1}
+		}
+		block.records = append(block.records, *rec)
+	}
+
+	if len(block.records) > 0 {
+		chnl <- block
+		counter++
+	}
+
+	summary.Add(summary.Skipped, nSkipped)
+	summary.Add(summary.Processed, counter)
+}
+
+// AlignRecords is ReadAligned for records that the caller has already read, e.g. from a BAM file
+// with biogo/hts's bam.Reader, so that they don't have to be written out as SAM to be read again.
+// The records of each query have to be next to each other (as aligners write them), and refLen is
+// the length of the reference that they are aligned to (e.g. from the header's @SQ line). The
+// records are checked, and may be changed (e.g. their low quality bases masked), in the same way
+// as they are when they are read from a file. The stream is read until it is closed, even if
+// there is an error. threads <= 0 means one worker per CPU. Once everything has stopped, either
+// the first error is sent to cErr or true is sent to cDone
+func AlignRecords(records <-chan *biogosam.Record, refLen int, minQual int, skipCorrupt bool, missingSeq string, threads int, cFR chan fastaio.FastaRecord, cErr chan error, cDone chan bool) {
+
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	err := checkMissingSeq(missingSeq)
+	if err != nil {
+		for range records {
+		}
+		cErr <- err
+		return
+	}
+
+	pl := pipeline.New()
+
+	cSR := make(chan samRecords, threads)
+
+	// cSR is closed once the grouper has returned, whether or not it failed
+	pl.Run(func() {
+		groupRecords(records, minQual, skipCorrupt, missingSeq, cSR, pl.Errs)
+		close(cSR)
+	})
+
+	for n := 0; n < threads; n++ {
+		pl.Run(func() {
+			blockToFastaRecord(cSR, cFR, nil, pl.Errs, refLen, nil, false, 0, false, false, -1, -1, false, "letters", 0, 1, false, nil)
+		})
+	}
+
+	err = pl.Wait()
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	cDone <- true
+}
+
+// Indel is an insertion or a deletion in one record: the inserted sequence, before the 0-based
+// reference position Pos, or the number of reference bases from Pos that are deleted
+type Indel struct {
+	Query     string
+	Pos       int
+	Insertion string
+	Length    int
+	Reverse   bool // whether the record is on the reverse strand
+}
+
+// RecordIndels sends each insertion and deletion in some records that the caller has already read
+// (see AlignRecords) to cIndel, as sam indels finds them, by threads workers, so not in order. If
+// ref (the upper case reference) isn't nil, they are left-aligned against it. Unmapped records
+// are skipped, as are records without a SEQ unless missingSeq is "mask" (see fillMissingSeq). The
+// stream is read until it is closed, even if there is an error. threads <= 0 means one worker per
+// CPU. Once everything has stopped, either the first error is sent to cErr or true is sent to
+// cDone
+func RecordIndels(records <-chan *biogosam.Record, ref []byte, skipCorrupt bool, missingSeq string, threads int, cIndel chan Indel, cErr chan error, cDone chan bool) {
+
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	err := checkMissingSeq(missingSeq)
+	if err != nil {
+		for range records {
+		}
+		cErr <- err
+		return
+	}
+
+	pl := pipeline.New()
+
+	cSR := make(chan biogosam.Record, threads)
+	cIns := make(chan indelKey, threads)
+	cDel := make(chan indelKey, threads)
+
+	// after an error, the rest of the stream is discarded, and cSR is closed at its end as usual
+	pl.Run(func() {
+		n := 0
+		for rec := range records {
+			if pl.Failed() {
+				continue
+			}
+			n++
+			keep, err := acceptRecord(rec, n, true, skipCorrupt, missingSeq)
+			if err != nil {
+				pl.Errs <- err
+				continue
+			}
+			if keep {
+				cSR <- *rec
+			}
+		}
+		close(cSR)
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+	for n := 0; n < threads; n++ {
+		pl.Run(func() {
+			getIndels(cSR, ref, 0, cIns, cDel, pl.Errs)
+			wg.Done()
+		})
+	}
+
+	pl.Run(func() {
+		wg.Wait()
+		close(cIns)
+		close(cDel)
+	})
+
+	// each channel is set to nil once it has been closed and emptied
+	ins, del := cIns, cDel
+	for ins != nil || del != nil {
+		select {
+		case k, ok := <-ins:
+			if !ok {
+				ins = nil
+				continue
+			}
+			cIndel <- Indel{Query: k.query, Pos: k.pos, Insertion: k.seq, Reverse: k.read.reverse}
+		case k, ok := <-del:
+			if !ok {
+				del = nil
+				continue
+			}
+			cIndel <- Indel{Query: k.query, Pos: k.pos, Length: k.length, Reverse: k.read.reverse}
+		}
+	}
+
+	err = pl.Wait()
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	cDone <- true
+}
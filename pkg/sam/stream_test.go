@@ -0,0 +1,188 @@
+package sam
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+var streamSam = "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n" +
+	"q1\t0\tref\t1\t60\t4M1D7M\t*\t0\t0\tACGTAAACGTA\t*\n" +
+	"q2\t16\tref\t1\t60\t2M1I10M\t*\t0\t0\tACTGTAAAACGTA\t*\n" +
+	"q3\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\t*\n" +
+	"q4\t0\tref\t3\t60\t6M\t*\t0\t0\tGTAAAA\t*\n" +
+	"q4\t2048\tref\t10\t60\t3M\t*\t0\t0\tGTA\t*\n"
+
+// streamRecords parses a SAM file, and sends its records to a channel, as a caller might from a BAM file
+func streamRecords(t *testing.T, sam string) chan *biogosam.Record {
+	r, err := biogosam.NewReader(strings.NewReader(sam))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make(chan *biogosam.Record)
+	go func() {
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			records <- rec
+		}
+		close(records)
+	}()
+	return records
+}
+
+// collectAligned reads the aligned sequences that a pipeline sends, in the order of the queries
+func collectAligned(t *testing.T, cFR chan fastaio.FastaRecord, cErr chan error, cDone chan bool) []fastaio.FastaRecord {
+	records := make([]fastaio.FastaRecord, 0)
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			t.Fatal(err)
+		case FR := <-cFR:
+			records = append(records, FR)
+		case <-cDone:
+			n--
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Idx < records[j].Idx })
+	return records
+}
+
+func TestAlignRecords(t *testing.T) {
+	dir := t.TempDir()
+	samFile := filepath.Join(dir, "in.sam")
+	err := ioutil.WriteFile(samFile, []byte(streamSam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go ReadAligned(samFile, 0, false, "skip", 2, cFR, cErr, cDone)
+	expected := collectAligned(t, cFR, cErr, cDone)
+
+	go AlignRecords(streamRecords(t, streamSam), 12, 0, false, "skip", 2, cFR, cErr, cDone)
+	aligned := collectAligned(t, cFR, cErr, cDone)
+
+	if len(expected) != 3 {
+		t.Errorf("problem in TestAlignRecords: expected 3 queries from the SAM file, got %d", len(expected))
+	}
+	if !reflect.DeepEqual(aligned, expected) {
+		t.Errorf("problem in TestAlignRecords: got\n%v\nexpected the same as from the SAM file:\n%v", aligned, expected)
+	}
+}
+
+func TestRecordIndels(t *testing.T) {
+	cIndel := make(chan Indel)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go RecordIndels(streamRecords(t, streamSam), nil, false, "skip", 2, cIndel, cErr, cDone)
+
+	indels := make([]Indel, 0)
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			t.Fatal(err)
+		case indel := <-cIndel:
+			indels = append(indels, indel)
+		case <-cDone:
+			n--
+		}
+	}
+	sort.Slice(indels, func(i, j int) bool { return indels[i].Query < indels[j].Query })
+
+	expected := []Indel{
+		{Query: "q1", Pos: 4, Length: 1},
+		{Query: "q2", Pos: 2, Insertion: "T", Reverse: true},
+	}
+	if !reflect.DeepEqual(indels, expected) {
+		t.Errorf("problem in TestRecordIndels: got %v, expected %v", indels, expected)
+	}
+}
+
+// streamResult is what a pipeline ends with: its error, or nil once it is done. It fails the
+// test if the pipeline doesn't end, or sends anything after it has ended
+func streamResult(t *testing.T, cFR chan fastaio.FastaRecord, cIndel chan Indel, cErr chan error, cDone chan bool) error {
+	var result error
+	timeout := time.After(10 * time.Second)
+	for ended := false; !ended; {
+		select {
+		case <-cFR:
+		case <-cIndel:
+		case result = <-cErr:
+			ended = true
+		case <-cDone:
+			ended = true
+		case <-timeout:
+			t.Fatal("problem in TestStreamEnds: the pipeline didn't end")
+		}
+	}
+
+	select {
+	case <-cFR:
+	case <-cIndel:
+	case <-cErr:
+	case <-cDone:
+	case <-time.After(100 * time.Millisecond):
+		return result
+	}
+	t.Fatal("problem in TestStreamEnds: the pipeline sent something after it ended")
+	return nil
+}
+
+// the stream functions end (with an error or done) and read the whole stream whether a record is
+// malformed or not, and with the default number of threads
+func TestStreamEnds(t *testing.T) {
+	malformed := "q5\t0\tref\t0\t60\t3M\t*\t0\t0\tACG\t*\n"
+	sams := []string{
+		streamSam,
+		streamSam + malformed + strings.Repeat(streamSam[strings.Index(streamSam, "q1"):], 10),
+	}
+
+	for i, sam := range sams {
+		for _, threads := range []int{0, 2} {
+			cFR := make(chan fastaio.FastaRecord)
+			cIndel := make(chan Indel)
+			cErr := make(chan error)
+			cDone := make(chan bool)
+
+			records := streamRecords(t, sam)
+			go AlignRecords(records, 12, 0, false, "skip", threads, cFR, cErr, cDone)
+			err := streamResult(t, cFR, nil, cErr, cDone)
+			if (err != nil) != (i == 1) {
+				t.Errorf("problem in TestStreamEnds: AlignRecords with %d threads: got error %v for SAM %d", threads, err, i)
+			}
+			if _, ok := <-records; ok {
+				t.Errorf("problem in TestStreamEnds: AlignRecords with %d threads didn't read the whole stream of SAM %d", threads, i)
+			}
+
+			records = streamRecords(t, sam)
+			go RecordIndels(records, nil, false, "skip", threads, cIndel, cErr, cDone)
+			err = streamResult(t, nil, cIndel, cErr, cDone)
+			if (err != nil) != (i == 1) {
+				t.Errorf("problem in TestStreamEnds: RecordIndels with %d threads: got error %v for SAM %d", threads, err, i)
+			}
+			if _, ok := <-records; ok {
+				t.Errorf("problem in TestStreamEnds: RecordIndels with %d threads didn't read the whole stream of SAM %d", threads, i)
+			}
+		}
+	}
+}
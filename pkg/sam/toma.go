@@ -1,33 +1,44 @@
 package sam
 
 import (
-	"errors"
+	"fmt"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/cov-ert/gofasta/internal/pipeline"
+	"github.com/cov-ert/gofasta/internal/summary"
 	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/usage"
 
 	biogosam "github.com/biogo/hts/sam"
 )
 
 // getFastaRecord returns a FastaRecord struct with a sequence ID and a sequence
-// that has been optionally trimmed and padded
-func getFastaRecord(rawseq []byte, id string, idx int, trim bool, pad bool, trimstart int,
+// that has been optionally masked, trimmed and padded. The columns in m, and the trimmed
+// regions if pad, are Ns, or with soft, soft-masked instead (see softMask)
+func getFastaRecord(rawseq []byte, id string, idx int, m *mask.Mask, soft bool, trim bool, pad bool, trimstart int,
 	trimend int) fastaio.FastaRecord {
 
-	var seq []byte
-
-	if pad {
-		seq = swapInNs(rawseq)
-	} else {
-		seq = swapInGapsNs(rawseq)
+	for i, L := range rawseq {
+		if stateOf(L) != unmapped && m.Masked(i) {
+			rawseq[i] = hardOrSoftMask(L, soft)
+		}
 	}
 
+	seq := render(rawseq, pad)
+
 	if trim {
 		if pad {
 			for i, _ := range seq {
 				if i < trimstart || i >= trimend {
-					seq[i] = 'N'
+					seq[i] = hardOrSoftMask(seq[i], soft)
 				}
 			}
 		} else {
@@ -40,18 +51,27 @@ func getFastaRecord(rawseq []byte, id string, idx int, trim bool, pad bool, trim
 	return FR
 }
 
+// hardOrSoftMask masks one site of an output sequence: with an N, or if soft, by
+// soft-masking the base (which leaves gaps and Ns as they are)
+func hardOrSoftMask(b byte, soft bool) byte {
+	if soft {
+		return softMask(b)
+	}
+	return 'N'
+}
+
 // sanity checks the trimming and padding arguments (given the length of the ref seq)
 func checkArgs(refLen int, trim bool, pad bool, trimstart int, trimend int) error {
 
 	if trim {
 		if trimstart > refLen-2 || trimstart < 1 {
-			return errors.New("error parsing trimming coordinates: check or include --trimstart")
+			return usage.New("error parsing trimming coordinates: check or include --trimstart")
 		}
 		if trimend > refLen-1 || trimend < 1 {
-			return errors.New("error parsing trimming coordinates: check or include --trimend")
+			return usage.New("error parsing trimming coordinates: check or include --trimend")
 		}
 		if trimstart >= trimend {
-			return errors.New("error parsing trimming coordinates: check trimstart and trimend")
+			return usage.New("error parsing trimming coordinates: check trimstart and trimend")
 		}
 	}
 
@@ -66,55 +86,134 @@ func checkArgs(refLen int, trim bool, pad bool, trimstart int, trimend int) erro
 }
 
 // worker function that takes items from a channel of sam block structs (with indices)
-// and writes the corresponding fasta records to a channel
-func blockToFastaRecord(ch_in chan samRecords, ch_out chan fastaio.FastaRecord, ch_err chan error,
-	refLen int, trim bool, pad bool, trimstart int, trimend int, includeInsertions bool) {
+// and writes the corresponding fasta records to a channel. If paired, each block is
+// treated as one paired-end fragment, and its mates are checked (see checkPair), with
+// any discordant pairs written to ch_discordant. Where each block ends is passed to cp.
+// If soft, conflicts and bases with a quality below minQual are soft-masked instead of
+// being Ns (see flattener.add), as are the columns in m (see getFastaRecord). Sites that fewer
+// than minDepth of a query's records cover are Ns too (see flattener.requireDepth)
+func blockToFastaRecord(ch_in chan samRecords, ch_out chan fastaio.FastaRecord, ch_discordant chan discordantPair, ch_err chan error,
+	refLen int, m *mask.Mask, soft bool, minQual int, trim bool, pad bool, trimstart int, trimend int, includeInsertions bool, flatten string, qualMargin int, minDepth int, paired bool, cp *checkpointer) {
+
+	// one flattener per worker, which is reused for every query
+	fl := newFlattener("", refLen, false, qualMargin)
+	if soft {
+		fl.softMasking(minQual)
+	}
+	fl.requireDepth(minDepth)
 
 	for group := range ch_in {
 
 		id := group.records[0].Name
-		rawseq, err := getSeqFromBlock(group.records, refLen, includeInsertions)
+		if paired {
+			if reason := checkPair(group.records); len(reason) > 0 {
+				ch_discordant <- discordantPair{idx: group.idx, query: id, reason: reason}
+			}
+		}
+		warnPastEnd(group.records, refLen)
+		rawseq, err := getSeqFromBlock(group.records, refLen, includeInsertions, flatten, fl)
 		if err != nil {
 			ch_err <- err
+			continue
 		}
-		ch_out <- getFastaRecord(rawseq, id, group.idx, trim, pad, trimstart, trimend)
+		cp.blockDone(group.idx, group.end, id)
+		ch_out <- getFastaRecord(rawseq, id, group.idx, m, soft, trim, pad, trimstart, trimend)
 	}
 	return
 }
 
-// writeAlignmentOut reads fasta records from a channel and writes them to a single
-// outfile, in the order in which they are present in the input file.
+// readNames names each of one query's SAM records, for writing them as separate
+// sequences: the mates of a pair are suffixed with /1 and /2, and unpaired records with
+// their (1-based) index in the block. Any name that would be repeated (e.g. for a
+// supplementary alignment of a mate) is given a further _2, _3, etc.
+func readNames(records []biogosam.Record) []string {
+
+	names := make([]string, len(records))
+	seen := make(map[string]int)
+
+	for i, rec := range records {
+		suffix := "/" + strconv.Itoa(i+1)
+		if rec.Flags&biogosam.Paired != 0 {
+			if rec.Flags&biogosam.Read2 != 0 {
+				suffix = "/2"
+			} else {
+				suffix = "/1"
+			}
+		}
+
+		name := rec.Name + suffix
+		seen[name]++
+		if seen[name] > 1 {
+			name = name + "_" + strconv.Itoa(seen[name])
+		}
+		names[i] = name
+	}
+
+	return names
+}
+
+// splitBlocks passes each record in the blocks from ch_in on to ch_out as a block of its
+// own, renamed by readNames and renumbered so that the output is still in input order.
+// Only the last record of each block keeps the block's end. It closes ch_out when ch_in is closed
+func splitBlocks(ch_in chan samRecords, ch_out chan samRecords) {
+
+	counter := 0
+
+	for group := range ch_in {
+		names := readNames(group.records)
+		for i, rec := range group.records {
+			rec.Name = names[i]
+			end := int64(-1)
+			if i == len(group.records)-1 {
+				end = group.end
+			}
+			ch_out <- samRecords{idx: counter, records: []biogosam.Record{rec}, end: end}
+			counter++
+		}
+	}
+
+	close(ch_out)
+}
+
+// writeAlignmentOut reads fasta records from a channel and writes them to out, in the
+// order in which they are present in the input file. Records that don't pass flt are
+// dropped. If cp isn't nil, checkpoints of f (the file that out writes to) are written
+// as it goes.
 // It passes a true to a done channel when the channel of fasta records is empty
-func writeAlignmentOut(ch chan fastaio.FastaRecord, outfile string, cdone chan bool, cerr chan error) {
+func writeAlignmentOut(ch chan fastaio.FastaRecord, out fastaio.OutputWriter, f *os.File, flt *fastaio.Filter, md *metadata.Metadata, cp *checkpointer, cdone chan bool, cerr chan error) {
 
 	outputMap := make(map[int]fastaio.FastaRecord)
 
 	counter := 0
+	skipped := 0
 
-	var f *os.File
-	var err error
-
-	if outfile != "stdout" {
-		f, err = os.Create(outfile)
+	emit := func(fastarecord fastaio.FastaRecord) {
+		if !md.Keep(fastarecord.ID) {
+			skipped++
+			return
+		}
+		keep, err := flt.Keep(fastarecord)
+		if err != nil {
+			cerr <- err
+		}
+		if !keep {
+			return
+		}
+		err = out.WriteRecord(fastarecord)
 		if err != nil {
 			cerr <- err
 		}
-	} else {
-		f = os.Stdout
 	}
 
-	defer f.Close()
+	var err error
 
 	for FR := range ch {
 
 		outputMap[FR.Idx] = FR
 
 		if fastarecord, ok := outputMap[counter]; ok {
-			_, err = f.WriteString(">" + fastarecord.ID + "\n")
-			if err != nil {
-				cerr <- err
-			}
-			_, err = f.WriteString(fastarecord.Seq + "\n")
+			emit(fastarecord)
+			err = cp.written(counter, f, flt)
 			if err != nil {
 				cerr <- err
 			}
@@ -130,11 +229,8 @@ func writeAlignmentOut(ch chan fastaio.FastaRecord, outfile string, cdone chan b
 			break
 		}
 		fastarecord := outputMap[counter]
-		_, err = f.WriteString(">" + fastarecord.ID + "\n")
-		if err != nil {
-			cerr <- err
-		}
-		_, err = f.WriteString(fastarecord.Seq + "\n")
+		emit(fastarecord)
+		err = cp.written(counter, f, flt)
 		if err != nil {
 			cerr <- err
 		}
@@ -142,83 +238,530 @@ func writeAlignmentOut(ch chan fastaio.FastaRecord, outfile string, cdone chan b
 		counter++
 	}
 
+	err = out.Flush()
+	if err != nil {
+		cerr <- err
+	}
+
+	err = cp.finish(f, flt)
+	if err != nil {
+		cerr <- err
+	}
+
+	err = flt.Close()
+	if err != nil {
+		cerr <- err
+	}
+
+	summary.Add(summary.Filtered, skipped)
+
 	cdone <- true
 }
 
-// ToMultiAlign converts a SAM file to a fasta-format alignment
-// Insertions relative to the reference are discarded.
-func ToMultiAlign(infile string, reffile string, outfile string, trim bool, pad bool, trimstart int,
-	trimend int, threads int) error {
+// checkOutFormat makes sure that the alignment output format is one we can write
+func checkOutFormat(format string) error {
+	switch format {
+	case "fasta", "phylip", "phylip-interleaved", "nexus", "diff":
+		return nil
+	}
+	return usage.Errorf("unrecognised --out-format: %s (choose one of: fasta, phylip, phylip-interleaved, nexus, diff)", format)
+}
 
+// getCharsetsFromGenbank returns one nexus charset per CDS in a genbank file, in
+// the coordinates of the (optionally trimmed) output alignment
+func getCharsetsFromGenbank(genbankFile string, trim bool, pad bool, trimstart int, trimend int) ([]fastaio.Charset, error) {
 
-	cSR := make(chan samRecords, threads)
-	cReadDone := make(chan bool)
+	charsets := make([]fastaio.Charset, 0)
 
-	cSH := make(chan biogosam.Header)
+	if len(genbankFile) == 0 {
+		return charsets, nil
+	}
 
-	cFR := make(chan fastaio.FastaRecord)
-	cWriteDone := make(chan bool)
+	gb, err := genbank.Load(genbankFile)
+	if err != nil {
+		return charsets, err
+	}
+
+	seen := make(map[string]int)
+
+	for i, feature := range getFeaturesFromAnnotation(gb, "CDS") {
+		positions, err := parsePositions(feature.Pos)
+		if err != nil {
+			return charsets, err
+		}
 
-	cErr := make(chan error)
+		name := feature.Info.Get("gene")
+		if len(name) == 0 {
+			name = "CDS_" + strconv.Itoa(i+1)
+		}
+		name = strings.ReplaceAll(name, " ", "_")
+		seen[name]++
+		if seen[name] > 1 {
+			name = name + "_" + strconv.Itoa(seen[name])
+		}
 
-	cWaitGroupDone := make(chan bool)
+		// if the alignment is trimmed without padding, the columns are shifted
+		// left by trimstart, and anything outside the trimmed region is dropped
+		if trim && !pad {
+			shifted := make([]int, 0)
+			for j := 0; j < len(positions); j += 2 {
+				start := positions[j] - trimstart
+				stop := positions[j+1] - trimstart
+				if stop < 1 || start > trimend-trimstart {
+					continue
+				}
+				if start < 1 {
+					start = 1
+				}
+				if stop > trimend-trimstart {
+					stop = trimend - trimstart
+				}
+				shifted = append(shifted, start, stop)
+			}
+			positions = shifted
+		}
 
-	go groupSamRecords(infile, cSH, cSR, cReadDone, cErr)
+		if len(positions) == 0 {
+			continue
+		}
+
+		charsets = append(charsets, fastaio.Charset{Name: name, Positions: positions})
+	}
 
-	header := <-cSH
-	refLen := header.Refs()[0].Len()
+	return charsets, nil
+}
 
-	err := checkArgs(refLen, trim, pad, trimstart, trimend)
+// ToMultiAlignOptions are the options of ToMultiAlign. DefaultToMultiAlignOptions returns
+// them as gofasta sam toMultiAlign's flags default to, which is where callers should start
+// from
+type ToMultiAlignOptions struct {
+	// if Trim, the alignment is trimmed to the (0-based) reference positions TrimStart to
+	// TrimEnd, or if Pad, everything outside them is written as Ns instead. If Pad without
+	// Trim, the unmapped sites at the ends of each sequence are Ns rather than '-'s
+	Trim      bool
+	Pad       bool
+	TrimStart int
+	TrimEnd   int
+
+	// Format is the format of the alignment (see fastaio.NewOutputWriter), and if RNA, it is
+	// written as RNA. The CDS in GenbankFile are written as nexus charsets, if it isn't empty
+	Format      string
+	RNA         bool
+	GenbankFile string
+
+	// bases with a quality below MinQual are Ns. If SkipCorrupt, malformed SAM records are
+	// skipped instead of being an error. Records without a SEQ are skipped or masked
+	// according to MissingSeq
+	MinQual     int
+	SkipCorrupt bool
+	MissingSeq  string
+
+	// the columns in MaskFile (see mask.Load) are written as Ns. If SoftMask, they, the bases
+	// with a quality below MinQual, the sites where a query's records conflict (or a sample's
+	// reads have no majority) and the padded regions are soft-masked (written in lower case)
+	// instead of being Ns, so that the calls are kept
+	MaskFile string
+	SoftMask bool
+
+	// Flatten is how the records of a query that overlap are resolved ("letters" or
+	// "quality"), with QualMargin the margin that "quality" needs (see flattener)
+	Flatten    string
+	QualMargin int
+
+	// if TrimEnds > 0 or TrimQual > 0, the ends of every read are trimmed before they are
+	// flattened (or added to the pileup): TrimEnds aligned bases, and then any with a quality
+	// below TrimQual (see trimReadEnds)
+	TrimEnds int
+	TrimQual int
+
+	// if Paired, the records for each query are the mates of a paired-end fragment, which
+	// are merged into one sequence with their overlap resolved by base quality, and
+	// discordant pairs are written to DiscordantFile (if it isn't empty). If PerRead, each
+	// SAM record is written as its own sequence instead (see readNames). If ByReadGroup, the
+	// reads are grouped by sample (the SM of their read group, or the read group's ID)
+	// instead of by query, and one consensus sequence is written per sample (see
+	// pileup.consensus)
+	Paired         bool
+	DiscordantFile string
+	PerRead        bool
+	ByReadGroup    bool
+
+	// sites that fewer than MinDepth of a query's records (or a sample's reads) cover are
+	// written as Ns. Sequences with less than MinCompleteness non-missing sites, or more than
+	// MaxN Ns (if it isn't negative), are dropped, and written to RejectsFile if it isn't
+	// empty
+	MinDepth        int
+	MinCompleteness float64
+	MaxN            int
+	RejectsFile     string
+
+	// if ShardSize > 0 or ShardBy isn't empty, the alignment is split into several files named
+	// after the outfile (see fastaio.NewSharder), by ShardBy's column in Metadata and/or
+	// ShardSize sequences. Queries that Metadata doesn't keep (see metadata.Metadata.Keep)
+	// aren't written, and those that it does are named with its header template, if it has
+	// one (see fastaio.HeaderWriter)
+	ShardSize int
+	Metadata  *metadata.Metadata
+	ShardBy   string
+
+	// if CheckpointFile isn't empty, a checkpoint is written to it every CheckpointEvery
+	// queries, and if Resume, a run that was interrupted carries on from its last checkpoint
+	CheckpointFile  string
+	CheckpointEvery int
+	Resume          bool
+
+	// Threads is the number of workers, or one per CPU if it is <= 0
+	Threads int
+}
+
+// DefaultToMultiAlignOptions returns the options that gofasta sam toMultiAlign uses if none of
+// its flags are given
+func DefaultToMultiAlignOptions() ToMultiAlignOptions {
+	return ToMultiAlignOptions{
+		Format:          "fasta",
+		MissingSeq:      "skip",
+		Flatten:         "letters",
+		QualMargin:      10,
+		MinDepth:        1,
+		MaxN:            -1,
+		CheckpointEvery: 10000,
+	}
+}
+
+// ToMultiAlign converts a SAM file to a fasta-format alignment, as set out by opts (see
+// ToMultiAlignOptions). Insertions relative to the reference are discarded. The alignment is
+// written to out, if it isn't nil, and otherwise to outfile
+func ToMultiAlign(infile string, reffile string, outfile string, out fastaio.OutputWriter, opts ToMultiAlignOptions) error {
+
+	defer profiling.Region("sam toMultiAlign")()
+
+	if opts.Threads <= 0 {
+		opts.Threads = runtime.NumCPU()
+	}
+
+	err := checkOutFormat(opts.Format)
 	if err != nil {
 		return err
 	}
+	if opts.Format == "diff" && len(reffile) == 0 && out == nil {
+		return usage.New("--out-format diff needs the --reference")
+	}
+	if opts.Format == "diff" && opts.RNA {
+		return usage.New("--rna can't be used with --out-format diff")
+	}
+	if opts.Format == "diff" && opts.SoftMask {
+		return usage.New("--soft-mask can't be used with --out-format diff, which has no lower case")
+	}
 
-	go writeAlignmentOut(cFR, outfile, cWriteDone, cErr)
+	if opts.MinCompleteness < 0 || opts.MinCompleteness > 1 {
+		return usage.New("--min-completeness should be between 0 and 1")
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(threads)
+	if opts.Flatten != "letters" && opts.Flatten != "quality" {
+		return usage.Errorf("unrecognised --flatten-strategy: %s (choose one of: letters, quality)", opts.Flatten)
+	}
 
-	for n := 0; n < threads; n++ {
-		go func() {
-			blockToFastaRecord(cSR, cFR, cErr, refLen, trim, pad, trimstart, trimend, false)
-			wg.Done()
-		}()
+	if len(opts.DiscordantFile) > 0 && !opts.Paired {
+		return usage.New("--discordant only makes sense with --paired")
 	}
 
-	go func() {
-		wg.Wait()
-		cWaitGroupDone <- true
-	}()
+	if opts.Paired && opts.PerRead {
+		return usage.New("--paired and --per-read can't be used together")
+	}
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cReadDone:
-			close(cSR)
-			close(cSH)
-			n--
+	if opts.TrimEnds < 0 || opts.TrimQual < 0 {
+		return usage.New("--trim-read-ends and --trim-read-qual can't be negative")
+	}
+
+	if opts.ByReadGroup {
+		switch {
+		case opts.Paired || opts.PerRead:
+			return usage.New("--by-read-group can't be used with --paired or --per-read")
+		case len(opts.CheckpointFile) > 0:
+			return usage.New("--by-read-group can't be used with --checkpoint")
 		}
 	}
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
+	switch {
+	case opts.MinDepth < 1:
+		return usage.New("--min-depth must be at least 1")
+	case opts.MinDepth > 1 && opts.PerRead:
+		return usage.New("--min-depth can't be used with --per-read, where each sequence is one read")
+	}
+
+	// the mates of a fragment overlap, and where they disagree the better quality base wins
+	if opts.Paired {
+		opts.Flatten = "quality"
+	}
+
+	err = checkMissingSeq(opts.MissingSeq)
+	if err != nil {
+		return err
+	}
+
+	var sh *fastaio.Sharder
+	if opts.ShardSize != 0 || len(opts.ShardBy) > 0 {
+		switch {
+		case out != nil:
+			return usage.New("sharding can't be used with a custom output writer")
+		case opts.Format != "fasta":
+			return usage.New("sharding only works with --out-format fasta")
+		case len(opts.CheckpointFile) > 0:
+			return usage.New("sharding can't be used with --checkpoint")
+		}
+		sh, err = fastaio.NewSharder(fastaio.ShardPrefix(outfile), opts.ShardSize, opts.Metadata, opts.ShardBy)
+		if err != nil {
 			return err
-		case <-cWaitGroupDone:
-			close(cFR)
-			n--
 		}
 	}
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
+	if len(opts.CheckpointFile) > 0 {
+		switch {
+		case out != nil:
+			return usage.New("--checkpoint can't be used with a custom output writer")
+		case outfile == "stdout":
+			return usage.New("--checkpoint needs the alignment to be written to a file (--fasta-out), not stdout")
+		case opts.Format != "fasta":
+			return usage.New("--checkpoint only works with --out-format fasta")
+		case len(opts.DiscordantFile) > 0:
+			return usage.New("--checkpoint can't be used with --discordant")
+		}
+	}
+
+	cp, err := newCheckpointer(opts.CheckpointFile, opts.CheckpointEvery, infile, opts.Resume)
+	if err != nil {
+		return err
+	}
+	if cp.complete() {
+		fmt.Fprintf(os.Stderr, "the checkpoint in %s says that this run is already complete\n", opts.CheckpointFile)
+		return nil
+	}
+	if cp.resumed() {
+		fmt.Fprintf(os.Stderr, "resuming after query %d (%s), from byte %d of %s\n", cp.state.queries, cp.state.lastQuery, cp.state.samOffset, infile)
+	}
+
+	// every stage of the pipeline is waited for before this returns, even if it fails, so
+	// that nothing is still writing to out (or reading infile) afterwards
+	pl := pipeline.New()
+	cErr := pl.Errs
+
+	cSR := make(chan samRecords, opts.Threads)
+	cReadDone := make(chan bool, 1)
+
+	cSH := make(chan biogosam.Header, 1)
+
+	cFR := make(chan fastaio.FastaRecord)
+	cWriteDone := make(chan bool, 1)
+
+	cDiscordant := make(chan discordantPair)
+	cDiscordantDone := make(chan bool, 1)
+
+	// when soft-masking, the flattener masks the low quality bases, so the reader mustn't.
+	// Reads that are added to a pileup are counted as they are, so low quality bases are
+	// still Ns, which aren't counted
+	readQual := opts.MinQual
+	if opts.SoftMask && !opts.ByReadGroup {
+		readQual = 0
+	}
+
+	// cSR is closed once the reader has returned, whether it got to the end of the file or not
+	pl.Run(func() {
+		groupSamRecordsFrom(infile, cp.samOffset(), readQual, opts.SkipCorrupt, opts.MissingSeq, cSH, cSR, cReadDone, cErr, pl.Stop)
+		close(cSR)
+	})
+
+	// the workers take blocks from cBlocks, which is cSR unless every record is its own block,
+	// or the ends of the reads are trimmed first
+	cBlocks := cSR
+	if opts.TrimEnds > 0 || opts.TrimQual > 0 {
+		cIn, cTrimmed := cBlocks, make(chan samRecords, opts.Threads)
+		pl.Run(func() { trimBlocks(cIn, cTrimmed, opts.TrimEnds, opts.TrimQual) })
+		cBlocks = cTrimmed
+	}
+	if opts.PerRead {
+		cIn, cSplit := cBlocks, make(chan samRecords, opts.Threads)
+		pl.Run(func() { splitBlocks(cIn, cSplit) })
+		cBlocks = cSplit
+	}
+
+	// abort stops the pipeline before the workers have been started, and returns err, or the
+	// pipeline's own error if it has already failed
+	abort := func(err error) error {
+		if err != nil {
+			pl.Fail(err)
+		}
+		for range cBlocks {
+		}
+		return pl.Wait()
+	}
+
+	var header biogosam.Header
+	select {
+	case header = <-cSH:
+	case <-pl.Stop:
+		return abort(nil)
+	}
+
+	// without an @SQ line, the reference's length comes from the --reference, or is inferred
+	// from the records
+	refLen := 0
+	if len(header.Refs()) > 0 {
+		refLen = header.Refs()[0].Len()
+	}
+
+	var refSeq []byte
+	if len(reffile) > 0 {
+		ref, err := readReference(reffile, header)
+		if err != nil {
+			return abort(err)
+		}
+		err = checkReference(header, ref)
+		if err != nil {
+			return abort(err)
+		}
+		refSeq = []byte(strings.ToUpper(ref.Seq))
+		if refLen == 0 {
+			refLen = len(refSeq)
+		}
+	}
+
+	if refLen == 0 {
+		// the reader's errors are the pipeline's, so noSQ only waits for it to send the first
+		// block or finish
+		refLen, err = inferRefLen(infile, cSR, cReadDone, nil)
+		if err != nil {
+			return abort(err)
+		}
+	}
+	if refLen == 0 {
+		// there are no records, so the alignment (and the rejects file) is empty
+		err = abort(nil)
+		if err != nil {
+			return err
+		}
+		flt, err := fastaio.ResumeFilter(opts.MinCompleteness, opts.MaxN, opts.RejectsFile, cp.rejectsOffset())
+		if err != nil {
 			return err
-		case <-cWriteDone:
-			n--
 		}
+		err = flt.Close()
+		if err != nil {
+			return err
+		}
+		switch {
+		case sh != nil:
+			return sh.Flush()
+		case out == nil && outfile != "stdout":
+			f, err := os.Create(outfile)
+			if err != nil {
+				return err
+			}
+			return f.Close()
+		}
+		return nil
+	}
+
+	err = checkArgs(refLen, opts.Trim, opts.Pad, opts.TrimStart, opts.TrimEnd)
+	if err != nil {
+		return abort(err)
+	}
+
+	m, err := mask.Load(opts.MaskFile, refLen)
+	if err != nil {
+		return abort(err)
+	}
+
+	if opts.Trim && !opts.Pad && len(refSeq) > 0 {
+		refSeq = refSeq[opts.TrimStart:opts.TrimEnd]
+	}
+
+	charsets, err := getCharsetsFromGenbank(opts.GenbankFile, opts.Trim, opts.Pad, opts.TrimStart, opts.TrimEnd)
+	if err != nil {
+		return abort(err)
+	}
+
+	// the alignment is written to out, the shards, or outfile
+	var f *os.File
+	switch {
+	case out != nil:
+	case sh != nil:
+		out = sh
+	default:
+		if outfile != "stdout" {
+			f, err = fastaio.OpenAt(outfile, cp.outOffset())
+			if err != nil {
+				return abort(err)
+			}
+			defer f.Close()
+		} else {
+			f = os.Stdout
+		}
+		out, err = fastaio.NewOutputWriter(f, opts.Format, charsets, refSeq)
+		if err != nil {
+			return abort(err)
+		}
+	}
+
+	// the sharder renames the sequences itself, once it has grouped them by their own names
+	if sh == nil {
+		out = fastaio.NewHeaderWriter(out, opts.Metadata)
+	}
+	if opts.RNA {
+		out = fastaio.NewRNAWriter(out)
+	}
+
+	// the rejects file is only opened once every argument has been checked, so that a
+	// mistake doesn't leave it empty (or cut an earlier one short)
+	flt, err := fastaio.ResumeFilter(opts.MinCompleteness, opts.MaxN, opts.RejectsFile, cp.rejectsOffset())
+	if err != nil {
+		return abort(err)
 	}
 
+	pl.Run(func() { writeAlignmentOut(cFR, out, f, flt, opts.Metadata, cp, cWriteDone, cErr) })
+	pl.Run(func() { writeDiscordantPairs(cDiscordant, opts.DiscordantFile, cDiscordantDone, cErr) })
+
+	// with byReadGroup, the workers add the reads to a pileup, and the consensus sequences
+	// are written once every read has been added
+	var p *pileup
+	var rgSamples map[string]string
+	if opts.ByReadGroup {
+		p = newPileup(refLen)
+		rgSamples = readGroupSamples(header)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(opts.Threads)
+
+	for n := 0; n < opts.Threads; n++ {
+		pl.Run(func() {
+			if opts.ByReadGroup {
+				blockToPileup(cBlocks, p, rgSamples, defaultSampleName(infile))
+			} else {
+				blockToFastaRecord(cBlocks, cFR, cDiscordant, cErr, refLen, m, opts.SoftMask, opts.MinQual, opts.Trim, opts.Pad, opts.TrimStart, opts.TrimEnd, false, opts.Flatten, opts.QualMargin, opts.MinDepth, opts.Paired, cp)
+			}
+			wg.Done()
+		})
+	}
+
+	// once the workers are done, so are the writers' inputs. The consensus of each read group
+	// is only written if nothing has gone wrong
+	pl.Run(func() {
+		wg.Wait()
+		if opts.ByReadGroup && !pl.Failed() {
+			err := readGroupsToFastaRecords(p, opts.MinDepth, m, opts.SoftMask, opts.Trim, opts.Pad, opts.TrimStart, opts.TrimEnd, cFR, nil)
+			if err != nil {
+				cErr <- err
+			}
+		}
+		close(cFR)
+		close(cDiscordant)
+	})
+
+	err = pl.Wait()
+	if err != nil {
+		return err
+	}
+
+	opts.Metadata.Report()
+
 	return nil
 }
@@ -0,0 +1,523 @@
+package sam
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/usage"
+
+	biogosam "github.com/biogo/hts/sam"
+)
+
+func TestReadNames(t *testing.T) {
+	records := []biogosam.Record{
+		{Name: "q", Flags: biogosam.Paired | biogosam.Read1},
+		{Name: "q", Flags: biogosam.Paired | biogosam.Read2},
+		{Name: "q", Flags: biogosam.Paired | biogosam.Read1 | biogosam.Supplementary},
+		{Name: "q"},
+	}
+
+	names := readNames(records)
+	expected := []string{"q/1", "q/2", "q/1_2", "q/4"}
+
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("problem in TestReadNames: got %s, expected %s", names[i], expected[i])
+		}
+	}
+}
+
+func TestToMultiAlignPerRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	err = ioutil.WriteFile(samFile, []byte(pairedSam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "out.fasta")
+
+	opts := DefaultToMultiAlignOptions()
+	opts.Paired = true
+	opts.PerRead = true
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if err == nil {
+		t.Errorf("problem in TestToMultiAlignPerRead: expected an error for --per-read with --paired")
+	}
+
+	opts = DefaultToMultiAlignOptions()
+	opts.PerRead = true
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fasta, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ">f1/1\nACGTAC------\n>f1/2\n----TTGGCC--\n>f2/1\nACGT--------\n>f3/1\nACGT--------\n>f3/2\n--GTAC------\n" +
+		">f4/1\nACGT--------\n>f4/2\n------GGCC--\n>f5/1\nACGT--------\n"
+	if string(fasta) != expected {
+		t.Errorf("problem in TestToMultiAlignPerRead: got\n%s\nexpected\n%s", fasta, expected)
+	}
+}
+
+// threads <= 0 is one worker per CPU, not none
+func TestToMultiAlignDefaultThreads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	err = ioutil.WriteFile(samFile, []byte(pairedSam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "out.fasta")
+
+	expected := ">f1\nACGTNNGGCC--\n>f2\nACGT--------\n>f3\nACGTAC------\n>f4\nACGTNNGGCC--\n>f5\nACGT--------\n"
+
+	for _, threads := range []int{0, -1} {
+		opts := DefaultToMultiAlignOptions()
+		opts.Threads = threads
+		err = ToMultiAlign(samFile, "", outFile, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fasta, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fasta) != expected {
+			t.Errorf("problem in TestToMultiAlignDefaultThreads (%d threads): got\n%s\nexpected\n%s", threads, fasta, expected)
+		}
+	}
+}
+
+var readGroupSam = `@HD	VN:1.6	SO:unsorted
+@SQ	SN:ref	LN:12
+@RG	ID:rg1	SM:s1
+@RG	ID:rg2	SM:s2
+r1	0	ref	1	60	8M	*	0	0	ACGTACGT	*	RG:Z:rg1
+r4	0	ref	3	60	2M2D4M	*	0	0	GTGTAC	*	RG:Z:rg2
+r2	16	ref	1	60	8M	*	0	0	ACGAACGT	*	RG:Z:rg1
+r5	0	ref	3	60	2M2D4M	*	0	0	GTGTAC	*	RG:Z:rg2
+r3	0	ref	5	60	4M	*	0	0	ACGT	*	RG:Z:rg1
+r6	0	ref	1	60	4M	*	0	0	ACGT	*
+`
+
+func TestToMultiAlignByReadGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	samFile := filepath.Join(dir, "in.sam")
+	err = ioutil.WriteFile(samFile, []byte(readGroupSam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "out.fasta")
+
+	opts := DefaultToMultiAlignOptions()
+	opts.ByReadGroup = true
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fasta, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the read without a read group is from a sample named after the file. s1's reads are
+	// tied at site 4, and both of s2's have a deletion at 5-6
+	expected := ">in\nACGT--------\n>s1\nACGNACGT----\n>s2\n--GT--GTAC--\n"
+	if string(fasta) != expected {
+		t.Errorf("problem in TestToMultiAlignByReadGroup: got\n%s\nexpected\n%s", fasta, expected)
+	}
+
+	// with --min-depth 2, all of in (which only has one read) is missing, and with --pad the
+	// missing sites at the ends are Ns
+
+	opts = DefaultToMultiAlignOptions()
+	opts.Pad = true
+	opts.ByReadGroup = true
+	opts.MinDepth = 2
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fasta, err = ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = ">in\nNNNNNNNNNNNN\n>s1\nACGNACGTNNNN\n>s2\nNNGT--GTACNN\n"
+	if string(fasta) != expected {
+		t.Errorf("problem in TestToMultiAlignByReadGroup: got\n%s\nexpected\n%s", fasta, expected)
+	}
+
+	opts = DefaultToMultiAlignOptions()
+	opts.Paired = true
+	opts.ByReadGroup = true
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestToMultiAlignByReadGroup: expected a usage error for --by-read-group with --paired, got %v", err)
+	}
+}
+
+func TestGetFastaRecordSoftMask(t *testing.T) {
+	maskFile := filepath.Join(t.TempDir(), "mask.txt")
+	err := ioutil.WriteFile(maskFile, []byte("3-4\n10\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mask.Load(maskFile, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		soft     bool
+		expected string
+	}{
+		{false, "NNNN--GTNNNN"},
+		{true, "acgt--GTacNN"},
+	}
+
+	for _, tt := range tests {
+		FR := getFastaRecord(sites("ACGT--GTAC**"), "q", 0, m, tt.soft, true, true, 2, 8)
+		if FR.Seq != tt.expected {
+			t.Errorf("problem in TestGetFastaRecordSoftMask: got %s, expected %s", FR.Seq, tt.expected)
+		}
+	}
+}
+
+// a query whose sequence can't be made is an error, and the worker goes on to the next one
+// rather than trimming an empty sequence (which would panic)
+func TestBlockToFastaRecordError(t *testing.T) {
+	record := func(name string, pos int) biogosam.Record {
+		return biogosam.Record{
+			Name:  name,
+			Pos:   pos,
+			Cigar: biogosam.Cigar{biogosam.NewCigarOp(biogosam.CigarMatch, 4)},
+			Seq:   biogosam.NewSeq([]byte("ACGT")),
+		}
+	}
+
+	cSR := make(chan samRecords, 2)
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+
+	cSR <- samRecords{idx: 0, records: []biogosam.Record{record("q1", -1)}}
+	cSR <- samRecords{idx: 1, records: []biogosam.Record{record("q2", 2)}}
+	close(cSR)
+
+	go func() {
+		blockToFastaRecord(cSR, cFR, nil, cErr, 12, nil, false, 0, true, false, 2, 8, false, "letters", 0, 1, false, nil)
+		close(cFR)
+	}()
+
+	errs := 0
+	var records []fastaio.FastaRecord
+	for cFR != nil {
+		select {
+		case <-cErr:
+			errs++
+		case FR, ok := <-cFR:
+			if !ok {
+				cFR = nil
+				continue
+			}
+			records = append(records, FR)
+		}
+	}
+
+	if errs != 1 || len(records) != 1 || records[0].ID != "q2" || records[0].Seq != "ACGT--" {
+		t.Errorf("problem in TestBlockToFastaRecordError: got %d errors and %v, expected one error and q2's sequence, ACGT--", errs, records)
+	}
+}
+
+func TestEmptySam(t *testing.T) {
+	dir := t.TempDir()
+
+	// a zero-length file, and one with a header but no records
+	for _, sam := range []string{"", "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n"} {
+		samFile := filepath.Join(dir, "in.sam")
+		err := ioutil.WriteFile(samFile, []byte(sam), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outFile := filepath.Join(dir, "out.fasta")
+		opts := DefaultToMultiAlignOptions()
+		opts.Threads = 2
+		err = ToMultiAlign(samFile, "", outFile, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fasta, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fasta) != 0 {
+			t.Errorf("problem in TestEmptySam: got\n%s\nexpected an empty alignment", fasta)
+		}
+
+		snpsFile := filepath.Join(dir, "snps.csv")
+		err = SNPs(samFile, "", snpsFile, 0, false, "skip", false, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snps, err := ioutil.ReadFile(snpsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(snps) != "query,SNPs\n" {
+			t.Errorf("problem in TestEmptySam: got\n%s\nexpected just the header", snps)
+		}
+
+		indelsOpts := DefaultIndelsOptions()
+		indelsOpts.Threads = 2
+		err = Indels([]string{samFile}, filepath.Join(dir, "ins.tsv"), filepath.Join(dir, "dels.tsv"), "", indelsOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+}
+
+// without an @SQ line, the reference's length comes from the --reference, or from the records
+func TestNoSQ(t *testing.T) {
+	dir := t.TempDir()
+
+	samFile := filepath.Join(dir, "noSQ.sam")
+	err := ioutil.WriteFile(samFile, []byte("q1\t0\tref\t1\t60\t4M\t*\t0\t0\tACGT\t*\n"+
+		"q2\t0\tref\t3\t60\t4M\t*\t0\t0\tGTAC\t*\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refFile := filepath.Join(dir, "ref.fasta")
+	err = ioutil.WriteFile(refFile, []byte(">ref\nACGTA\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out.fasta")
+
+	tests := []struct {
+		reffile  string
+		expected string
+	}{
+		{"", ">q1\nACGT--\n>q2\n--GTAC\n"},
+		// q2 reaches past the end of the reference, so it is cut short
+		{refFile, ">q1\nACGT-\n>q2\n--GTA\n"},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultToMultiAlignOptions()
+		opts.Threads = 2
+		err = ToMultiAlign(samFile, tt.reffile, outFile, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fasta, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fasta) != tt.expected {
+			t.Errorf("problem in TestNoSQ: got\n%s\nexpected\n%s", fasta, tt.expected)
+		}
+	}
+
+	// stdin can't be read twice, so there has to be a --reference
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	os.Stdin, err = os.Open(samFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Stdin.Close()
+
+	opts := DefaultToMultiAlignOptions()
+	opts.Threads = 2
+	err = ToMultiAlign("", "", outFile, nil, opts)
+	if err == nil {
+		t.Errorf("problem in TestNoSQ: expected an error for records without an @SQ line or a --reference on stdin")
+	}
+}
+
+func TestToMultiAlignMinDepth(t *testing.T) {
+	dir := t.TempDir()
+
+	// q1's reads overlap at sites 4-7, q2 only has one read
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n" +
+		"q1\t0\tref\t1\t60\t8M\t*\t0\t0\tACGTACGT\t*\n" +
+		"q1\t2048\tref\t5\t60\t6M\t*\t0\t0\tACGTAC\t*\n" +
+		"q2\t0\tref\t3\t60\t6M\t*\t0\t0\tGTACGT\t*\n"
+
+	samFile := filepath.Join(dir, "in.sam")
+	err := ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out.fasta")
+
+	tests := []struct {
+		softMask bool
+		expected string
+	}{
+		{false, ">q1\nNNNNACGTNN--\n>q2\n--NNNNNN----\n"},
+		{true, ">q1\nacgtACGTac--\n>q2\n--gtacgt----\n"},
+	}
+
+	for _, tt := range tests {
+		opts := DefaultToMultiAlignOptions()
+		opts.SoftMask = tt.softMask
+		opts.MinDepth = 2
+		opts.Threads = 2
+		err = ToMultiAlign(samFile, "", outFile, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fasta, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fasta) != tt.expected {
+			t.Errorf("problem in TestToMultiAlignMinDepth: got\n%s\nexpected\n%s", fasta, tt.expected)
+		}
+	}
+
+	opts := DefaultToMultiAlignOptions()
+	opts.PerRead = true
+	opts.MinDepth = 2
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestToMultiAlignMinDepth: expected a usage error for --min-depth with --per-read, got %v", err)
+	}
+}
+
+func TestGetCharsetsFromGenbank(t *testing.T) {
+	gbFile := filepath.Join(t.TempDir(), "test.gb")
+	err := ioutil.WriteFile(gbFile, []byte(`LOCUS       test                      21 bp    DNA     linear   VRL 01-JAN-2021
+FEATURES             Location/Qualifiers
+     CDS             1..9
+                     /gene="a"
+     CDS             join(10..12,14..21)
+                     /gene="a b"
+     CDS             5..7
+                     /gene="a"
+     CDS             16..18
+ORIGIN
+        1 atgaaacccg ggttttaaac g
+//
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		trim     bool
+		pad      bool
+		expected []fastaio.Charset
+	}{
+		// names are made safe and unique, and a CDS without a gene is numbered
+		{false, false, []fastaio.Charset{
+			{Name: "a", Positions: []int{1, 9}},
+			{Name: "a_b", Positions: []int{10, 12, 14, 21}},
+			{Name: "a_2", Positions: []int{5, 7}},
+			{Name: "CDS_4", Positions: []int{16, 18}},
+		}},
+		// padding keeps the columns where they were
+		{true, true, []fastaio.Charset{
+			{Name: "a", Positions: []int{1, 9}},
+			{Name: "a_b", Positions: []int{10, 12, 14, 21}},
+			{Name: "a_2", Positions: []int{5, 7}},
+			{Name: "CDS_4", Positions: []int{16, 18}},
+		}},
+		// trimming to 9-15 (1-based) shifts the columns, cuts the CDSs that overlap the ends
+		// and drops those that are outside it
+		{true, false, []fastaio.Charset{
+			{Name: "a", Positions: []int{1, 1}},
+			{Name: "a_b", Positions: []int{2, 4, 6, 7}},
+		}},
+	}
+
+	for _, tt := range tests {
+		charsets, err := getCharsetsFromGenbank(gbFile, tt.trim, tt.pad, 8, 15)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(charsets, tt.expected) {
+			t.Errorf("problem in TestGetCharsetsFromGenbank: trim %v, pad %v: got %v, expected %v", tt.trim, tt.pad, charsets, tt.expected)
+		}
+	}
+
+	charsets, err := getCharsetsFromGenbank("", false, false, -1, -1)
+	if err != nil || len(charsets) != 0 {
+		t.Errorf("problem in TestGetCharsetsFromGenbank: got %v, %v without a genbank file", charsets, err)
+	}
+}
+
+// a mistake in the arguments is found before the rejects file is opened, so an earlier one
+// is left alone
+func TestToMultiAlignRejectsUntouched(t *testing.T) {
+	dir := t.TempDir()
+
+	samFile := filepath.Join(dir, "in.sam")
+	err := ioutil.WriteFile(samFile, []byte(readGroupSam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectsFile := filepath.Join(dir, "rejects.fasta")
+	err = ioutil.WriteFile(rejectsFile, []byte(">old\nACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out.fasta")
+
+	opts := DefaultToMultiAlignOptions()
+	opts.Flatten = "bogus"
+	opts.MinCompleteness = 0.5
+	opts.RejectsFile = rejectsFile
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestToMultiAlignRejectsUntouched: expected a usage error for an unrecognised --flatten-strategy, got %v", err)
+	}
+
+	rejects, err := ioutil.ReadFile(rejectsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rejects) != ">old\nACGT\n" {
+		t.Errorf("problem in TestToMultiAlignRejectsUntouched: the rejects file was changed to %q", rejects)
+	}
+}
@@ -5,12 +5,13 @@ import (
 	"sync"
 	"sort"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
 	"strconv"
+	"runtime"
 
 	"github.com/cov-ert/gofasta/pkg/genbank"
-	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/profiling"
 
 	biogosam "github.com/biogo/hts/sam"
 )
@@ -26,6 +27,7 @@ type alignPair struct {
 	featPosArray []int // parsed start/end positions of feature from annotation (can be >length(2) if Join())
 	featType string
 	featName string
+	coding genbank.Coding // for a CDS, its /codon_start, genetic code and translation exceptions
 	idx int // for retaining input order in the output
 }
 
@@ -151,7 +153,7 @@ func blockToSeqPair(alignedBlock alignedBlockInfo, ref []byte) alignPair {
 			diff := max - len(line)
 			stars := make([]byte, diff)
 			for i, _ := range stars {
-				stars[i] = '*'
+				stars[i] = unmappedSite
 			}
 			line = append(line, stars...)
 		}
@@ -167,7 +169,7 @@ func blockToSeqPair(alignedBlock alignedBlockInfo, ref []byte) alignPair {
 			diff := max - len(line)
 			stars := make([]byte, diff)
 			for i, _ := range stars {
-				stars[i] = '*'
+				stars[i] = unmappedSite
 			}
 			line = append(line, stars...)
 		}
@@ -187,13 +189,16 @@ func blockToSeqPair(alignedBlock alignedBlockInfo, ref []byte) alignPair {
 		diff := (totalInsertionLength + len(ref)) - len(R)
 		extendRight := make([]byte, diff)
 		for i, _ := range(extendRight) {
-			extendRight[i] = '*'
+			extendRight[i] = unmappedSite
 		}
 		Q = append(Q, extendRight...)
 		R = append(R, ref[len(ref) - diff:]...)
 	}
 
-	Q = swapInNs(Q)
+	// the longest record's reference covers every column, so R shouldn't have any unmapped
+	// sites, but it is rendered anyway, so that none can be written out
+	Q = render(Q, true)
+	R = render(R, true)
 
 	return alignPair{ref: R, query: Q}
 }
@@ -251,7 +256,7 @@ func blockToPairwiseAlignment(cSR chan samRecords, cPair chan alignPair, cErr ch
 				Q = append(Q, seq)
 			}
 
-			Qflat := swapInNs(checkAndGetFlattenedSeq(Q, qname))
+			Qflat := render(checkAndGetFlattenedSeq(Q, qname), true)
 
 			pair := alignPair{ref: ref, query: Qflat}
 			pair.queryname = group.records[0].Name
@@ -334,6 +339,65 @@ func findOffsetPos(i int, a []int) int {
 	return pos
 }
 
+// featurePairs splits a pairwise alignment into one pair per feature, whose names are the
+// features' anno qualifiers
+func featurePairs(pair alignPair, features []genbank.GenbankFeature, anno string) ([]alignPair, error) {
+
+	aps := make([]alignPair, 0, len(features))
+
+	idx := getRefAdjustedPositions(pair.ref)
+
+	for _, feature := range(features) {
+
+		subPair := alignPair{}
+
+		subPair.refname = pair.refname
+		subPair.queryname = pair.queryname
+		subPair.featType = feature.Feature
+		subPair.featName = feature.Info.Get(anno)
+		subPair.descriptor = pair.queryname + "." + feature.Feature + "." + strings.ReplaceAll(feature.Info.Get(anno), " ", "_")
+
+		positions, err := parsePositions(feature.Pos)
+		if err != nil {
+			return nil, err
+		}
+
+		subPair.featPosArray = positions
+
+		if feature.Feature == "CDS" {
+			subPair.coding, err = feature.Coding()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var newRef []byte
+		var newQue []byte
+
+		if len(positions) / 2 > 1 {
+			for i := 0; i < len(positions); i += 2 {
+				start := findOffsetPos(positions[i], idx)
+				stop := findOffsetPos(positions[i + 1], idx) + 1
+				newRef = append(newRef, pair.ref[start:stop]...)
+				newQue = append(newQue, pair.query[start:stop]...)
+			}
+			subPair.ref = newRef
+			subPair.query = newQue
+		} else {
+			start := findOffsetPos(positions[0], idx)
+			stop := findOffsetPos(positions[1], idx) + 1
+			newRef = pair.ref[start:stop]
+			newQue = pair.query[start:stop]
+			subPair.ref = newRef
+			subPair.query = newQue
+		}
+
+		aps = append(aps, subPair)
+	}
+
+	return aps, nil
+}
+
 // TODO - allow multiple feature types in features []genbank.GenbankFeature
 func parseAlignmentByAnnotation(features []genbank.GenbankFeature, cPairIn chan alignPair, cPairOut chan alignPairs, cErr chan error) {
 
@@ -359,51 +423,13 @@ func parseAlignmentByAnnotation(features []genbank.GenbankFeature, cPairIn chan
 
 		for pair := range(cPairIn) {
 
-			A := alignPairs{idx: pair.idx}
-
-			idx := getRefAdjustedPositions(pair.ref)
-
-			for _, feature := range(features) {
-
-				subPair := alignPair{}
-
-				subPair.refname = pair.refname
-				subPair.queryname = pair.queryname
-				subPair.featType = feature.Feature
-				subPair.featName = feature.Info[anno]
-				subPair.descriptor = pair.queryname + "." + feature.Feature + "." + strings.ReplaceAll(feature.Info[anno], " ", "_")
-
-				positions, err := parsePositions(feature.Pos)
-				if err != nil {
-					cErr<- err
-				}
-
-				subPair.featPosArray = positions
-
-				var newRef []byte
-				var newQue []byte
-
-				if len(positions) / 2 > 1 {
-					for i := 0; i < len(positions); i += 2 {
-						start := findOffsetPos(positions[i], idx)
-						stop := findOffsetPos(positions[i + 1], idx) + 1
-						newRef = append(newRef, pair.ref[start:stop]...)
-						newQue = append(newQue, pair.query[start:stop]...)
-					}
-					subPair.ref = newRef
-					subPair.query = newQue
-				} else {
-					start := findOffsetPos(positions[0], idx)
-					stop := findOffsetPos(positions[1], idx) + 1
-					newRef = pair.ref[start:stop]
-					newQue = pair.query[start:stop]
-					subPair.ref = newRef
-					subPair.query = newQue
-				}
-
-				A.aps = append(A.aps, subPair)
+			aps, err := featurePairs(pair, features, anno)
+			if err != nil {
+				cErr<- err
 			}
 
+			A := alignPairs{aps: aps, idx: pair.idx}
+
 			cPairOut<- A
 		}
 	}
@@ -411,9 +437,11 @@ func parseAlignmentByAnnotation(features []genbank.GenbankFeature, cPairIn chan
 	return
 }
 
-func writePairwiseAlignment(p string, cPair chan alignPairs, cWriteDone chan bool, cErr chan error, omitRef bool) {
+// pairFileName replaces the characters in an alignment's descriptor that can't be in a file name
+// on every platform (the path separators, and the ones that Windows doesn't allow) with _
+var pairFileName = strings.NewReplacer("/", "_", "\\", "_", "|", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_")
 
-	_ = path.Join()
+func writePairwiseAlignment(p string, cPair chan alignPairs, cWriteDone chan bool, cErr chan error, omitRef bool) {
 
 	var err error
 
@@ -445,9 +473,7 @@ func writePairwiseAlignment(p string, cPair chan alignPairs, cWriteDone chan boo
 
 		for array := range cPair {
 			for _, AP := range(array.aps) {
-				des := strings.ReplaceAll(AP.descriptor, "/", "_")
-				des = strings.ReplaceAll(des, "|", "_")
-				f, err := os.Create(path.Join(p, des + ".fasta"))
+				f, err := os.Create(filepath.Join(p, pairFileName.Replace(AP.descriptor) + ".fasta"))
 				if err != nil {
 					cErr <- err
 				}
@@ -478,10 +504,23 @@ func writePairwiseAlignment(p string, cPair chan alignPairs, cWriteDone chan boo
 
 // ToPairAlign converts a SAM file into pairwise fasta-format alignments
 // optionally including the reference, optionally split by annotations,
-// optionally skipping insertions relative to the reference
-func ToPairAlign(samFile string, referenceFile string, genbankFile string, feat string, outpath string, omitRef bool, omitIns bool, threads int) error {
+// optionally skipping insertions relative to the reference, and optionally skipping
+// malformed SAM records instead of returning an error. Records without a SEQ are
+// skipped or masked according to missingSeq
+func ToPairAlign(samFile string, referenceFile string, genbankFile string, feat string, outpath string, omitRef bool, omitIns bool, minQual int, skipCorrupt bool, missingSeq string, threads int) error {
+
+	defer profiling.Region("sam toPairAlign")()
+
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	err := checkMissingSeq(missingSeq)
+	if err != nil {
+		return err
+	}
 
-	gb, err := genbank.ReadGenBank(genbankFile)
+	gb, err := genbank.Load(genbankFile)
 	if err != nil {
 		return err
 	}
@@ -497,30 +536,6 @@ func ToPairAlign(samFile string, referenceFile string, genbankFile string, feat
 
 	cErr := make(chan error)
 
-	cRef := make(chan fastaio.FastaRecord)
-	cRefDone := make(chan bool)
-
-	go fastaio.ReadAlignment(referenceFile, cRef, cErr, cRefDone)
-
-	var refSeq string
-	// var refName string
-
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case FR := <-cRef:
-			refSeq = FR.Seq
-			// refName = FR.ID
-		case <-cRefDone:
-			close(cRef)
-			n--
-		}
-	}
-
-	// fmt.Println(refSeq)
-	// fmt.Println()
-
 	cSR := make(chan samRecords, threads)
 	cSH := make(chan biogosam.Header)
 
@@ -532,9 +547,26 @@ func ToPairAlign(samFile string, referenceFile string, genbankFile string, feat
 	cParseWaitGroupDone := make(chan bool)
 	cWriteDone := make(chan bool)
 
-	go groupSamRecords(samFile, cSH, cSR, cReadDone, cErr)
+	go groupSamRecords(samFile, minQual, skipCorrupt, missingSeq, cSH, cSR, cReadDone, cErr)
+
+	var header biogosam.Header
+	select {
+	case err := <-cErr:
+		return err
+	case header = <-cSH:
+	}
 
-	_ = <-cSH
+	ref, err := readReference(referenceFile, header)
+	if err != nil {
+		return err
+	}
+
+	refSeq := ref.Seq
+
+	err = checkReference(header, ref)
+	if err != nil {
+		return err
+	}
 
 	go writePairwiseAlignment(outpath, cPairParse, cWriteDone, cErr, omitRef)
 
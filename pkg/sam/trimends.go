@@ -0,0 +1,135 @@
+package sam
+
+import (
+	biogosam "github.com/biogo/hts/sam"
+)
+
+// lowQualityBase reports whether the base at (0-based) query position i of a record has a
+// quality below minQual. Bases without a quality never do
+func lowQualityBase(rec *biogosam.Record, i int, minQual int) bool {
+	return minQual > 0 && i < len(rec.Qual) && rec.Qual[i] != 0xff && int(rec.Qual[i]) < minQual
+}
+
+// trimReadEnds soft-clips the ends of a record, as ivar trim and the ARTIC pipeline do, so that
+// the errors that pile up at the ends of reads don't get into the consensus: n aligned bases from
+// each end, and then any more until there is one with a quality of at least minQual (if it is >
+// 0). The deletions and insertions that are left at the ends are clipped too, and Pos is moved to
+// the first base that is kept. If every aligned base is trimmed, the whole read is soft-clipped,
+// so it covers no sites but is still its query's record
+func trimReadEnds(rec *biogosam.Record, n int, minQual int) {
+
+	if n < 1 && minQual < 1 {
+		return
+	}
+
+	// the query positions of the aligned bases (those that consume the query and the reference)
+	aligned := make([]int, 0, rec.Seq.Length)
+	q := 0
+	for _, op := range rec.Cigar {
+		consumes := op.Type().Consumes()
+		if consumes.Query == 1 && consumes.Reference == 1 {
+			for i := 0; i < op.Len(); i++ {
+				aligned = append(aligned, q+i)
+			}
+		}
+		q += op.Len() * consumes.Query
+	}
+
+	left := n
+	for left < len(aligned) && lowQualityBase(rec, aligned[left], minQual) {
+		left++
+	}
+	right := n
+	for left+right < len(aligned) && lowQualityBase(rec, aligned[len(aligned)-1-right], minQual) {
+		right++
+	}
+
+	if left == 0 && right == 0 {
+		return
+	}
+
+	// the first and last query positions that are kept, or none of them
+	first, last := q, -1
+	if left+right < len(aligned) {
+		first, last = aligned[left], aligned[len(aligned)-1-right]
+	}
+
+	trimmed := make(biogosam.Cigar, 0, len(rec.Cigar)+2)
+	clip := func(length int) {
+		if length == 0 {
+			return
+		}
+		if k := len(trimmed) - 1; k >= 0 && trimmed[k].Type() == biogosam.CigarSoftClipped {
+			length += trimmed[k].Len()
+			trimmed = trimmed[:k]
+		}
+		trimmed = append(trimmed, biogosam.NewCigarOp(biogosam.CigarSoftClipped, length))
+	}
+
+	q = 0
+	pos := rec.Pos
+	for _, op := range rec.Cigar {
+		consumes := op.Type().Consumes()
+		length := op.Len()
+
+		switch {
+		case op.Type() == biogosam.CigarHardClipped:
+			trimmed = append(trimmed, op)
+
+		case consumes.Query == 1:
+			// the parts of the operation before first and after last are clipped
+			before := first - q
+			if before < 0 {
+				before = 0
+			}
+			if before > length {
+				before = length
+			}
+			after := q + length - 1 - last
+			if after < 0 {
+				after = 0
+			}
+			if after > length-before {
+				after = length - before
+			}
+			clip(before)
+			if kept := length - before - after; kept > 0 {
+				trimmed = append(trimmed, biogosam.NewCigarOp(op.Type(), kept))
+			}
+			clip(after)
+			pos += before * consumes.Reference
+
+		default:
+			// a deletion, skip or padding is only kept if it is between two kept bases
+			if q > first && q <= last {
+				trimmed = append(trimmed, op)
+			} else if q <= first {
+				pos += length * consumes.Reference
+			}
+		}
+
+		q += length * consumes.Query
+	}
+
+	if last < 0 {
+		// nothing is aligned any more
+		pos = rec.Pos
+	}
+
+	rec.Cigar = trimmed
+	rec.Pos = pos
+}
+
+// trimBlocks passes the blocks from ch_in on to ch_out with the ends of each record trimmed
+// (see trimReadEnds). It closes ch_out when ch_in is closed
+func trimBlocks(ch_in chan samRecords, ch_out chan samRecords, n int, minQual int) {
+
+	for group := range ch_in {
+		for i := range group.records {
+			trimReadEnds(&group.records[i], n, minQual)
+		}
+		ch_out <- group
+	}
+
+	close(ch_out)
+}
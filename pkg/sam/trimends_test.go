@@ -0,0 +1,100 @@
+package sam
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrimReadEnds(t *testing.T) {
+	tests := []struct {
+		cigar    string
+		seq      string
+		qual     string
+		n        int
+		minQual  int
+		expected string
+		pos      int
+	}{
+		{"10M", "ACGTACGTAC", "*", 2, 0, "2S6M2S", 4},
+		{"10M", "ACGTACGTAC", "*", 0, 0, "10M", 2},
+		// the deletion is left at the start, so it goes too
+		{"2M2D6M", "ACGTACGT", "*", 2, 0, "2S4M2S", 6},
+		{"3M2I5M", "ACGTACGTAC", "*", 3, 0, "5S2M3S", 5},
+		{"10M", "ACGTACGTAC", "!!IIIIII!I", 0, 20, "2S8M", 4},
+		{"10M", "ACGTACGTAC", "!!IIIIII!I", 1, 20, "2S6M2S", 4},
+		{"5H2S4M1I3M", "ACGTACGTAC", "*", 1, 0, "5H3S3M1I2M1S", 3},
+		// every base is trimmed, so the read doesn't cover anything
+		{"4M", "ACGT", "*", 2, 0, "4S", 2},
+	}
+
+	for _, tt := range tests {
+		sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:30\n" +
+			"q1\t0\tref\t3\t60\t" + tt.cigar + "\t*\t0\t0\t" + tt.seq + "\t" + tt.qual + "\n"
+		s, err := newSamReader(strings.NewReader(sam))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := readSamRecord(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		trimReadEnds(rec, tt.n, tt.minQual)
+
+		if rec.Cigar.String() != tt.expected || rec.Pos != tt.pos {
+			t.Errorf("problem in TestTrimReadEnds: %s (%d, %d): got %s at %d, expected %s at %d", tt.cigar, tt.n, tt.minQual, rec.Cigar.String(), rec.Pos, tt.expected, tt.pos)
+		}
+	}
+}
+
+func TestToMultiAlignTrimReadEnds(t *testing.T) {
+	dir := t.TempDir()
+
+	// the last base of q1's first record and the first base of its second are errors, where
+	// the records overlap
+	sam := "@HD\tVN:1.6\n@SQ\tSN:ref\tLN:12\n" +
+		"q1\t0\tref\t1\t60\t10M\t*\t0\t0\tACGTACGTAA\t*\n" +
+		"q1\t2048\tref\t7\t60\t6M\t*\t0\t0\tCTACGT\t*\n"
+
+	samFile := filepath.Join(dir, "in.sam")
+	err := ioutil.WriteFile(samFile, []byte(sam), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out.fasta")
+
+	for _, tt := range []struct {
+		trimEnds int
+		expected string
+	}{
+		{0, ">q1\nACGTACNTANGT\n"},
+		{1, ">q1\n-CGTACGTACG-\n"},
+	} {
+		opts := DefaultToMultiAlignOptions()
+		opts.TrimEnds = tt.trimEnds
+		opts.Threads = 2
+		err = ToMultiAlign(samFile, "", outFile, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fasta, err := ioutil.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(fasta) != tt.expected {
+			t.Errorf("problem in TestToMultiAlignTrimReadEnds: --trim-read-ends %d: got\n%s\nexpected\n%s", tt.trimEnds, fasta, tt.expected)
+		}
+	}
+
+	opts := DefaultToMultiAlignOptions()
+	opts.TrimEnds = -1
+	opts.Threads = 2
+	err = ToMultiAlign(samFile, "", outFile, nil, opts)
+	if err == nil {
+		t.Errorf("problem in TestToMultiAlignTrimReadEnds: expected an error for a negative --trim-read-ends")
+	}
+
+}
@@ -0,0 +1,75 @@
+package sam
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/cov-ert/gofasta/pkg/remote"
+)
+
+// CheckHeader reads the header and the first record of a SAM file (stdin if infile is
+// empty), and checks the reference (if referenceFile isn't empty) against the header in
+// the same way as the commands do (see checkReference), without reading the rest of the
+// file. It returns the length of the reference that the file was aligned against, or 0
+// if the file is empty. If the header has no @SQ line, the length is the --reference's,
+// or as far as the records reach (see inferRefLen)
+func CheckHeader(infile string, referenceFile string) (int, error) {
+
+	var err error
+	var f remote.File = os.Stdin
+
+	if len(infile) > 0 {
+		f, err = remote.Open(infile)
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer f.Close()
+
+	s, err := newSamReader(bufio.NewReader(f))
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	header := *s.Header()
+
+	_, err = readSamRecord(s)
+	empty := err == io.EOF
+	if err != nil && !empty {
+		return 0, err
+	}
+
+	refLen := 0
+	if len(header.Refs()) > 0 {
+		refLen = header.Refs()[0].Len()
+	}
+
+	if len(referenceFile) > 0 {
+		ref, err := readReference(referenceFile, header)
+		if err != nil {
+			return 0, err
+		}
+		err = checkReference(header, ref)
+		if err != nil {
+			return 0, err
+		}
+		if refLen == 0 {
+			refLen = len(ref.Seq)
+		}
+	}
+
+	// without an @SQ line or a --reference, toMultiAlign infers the length from the records,
+	// which it can only do if it can read the file twice
+	if refLen == 0 && !empty {
+		if len(infile) == 0 {
+			return 0, errors.New("no reference (@SQ line) in the SAM header: give the --reference, so that its length can be used")
+		}
+		return furthestEnd(infile)
+	}
+
+	return refLen, nil
+}
@@ -3,6 +3,7 @@ package sam
 import (
 	"errors"
 	"fmt"
+	"runtime"
 	"sync"
 	// "sort"
 	"os"
@@ -10,10 +11,11 @@ import (
 	"strings"
 	"strconv"
 
+	"github.com/cov-ert/gofasta/internal/pipeline"
 	"github.com/cov-ert/gofasta/pkg/genbank"
-	"github.com/cov-ert/gofasta/pkg/fastaio"
 	"github.com/cov-ert/gofasta/pkg/alphabet"
 	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/profiling"
 
 	biogosam "github.com/biogo/hts/sam"
 )
@@ -83,9 +85,12 @@ func getSNPPos(i int, A []int) (int, error) {
 	return pos, nil
 }
 
-func getVariantsFromAlignPair(pair alignPair) ([]annoStruct, error) {
+// getVariantsFromAlignPair finds the amino acid changes and synonymous SNPs in a pairwise alignment of
+// one feature. Codons that are deleted in the query are changes to -, and partly deleted codons are
+// translated by t, according to its policy. The feature's codons start at its /codon_start, and are
+// translated with its /transl_table and /transl_except. Codons that translate as X aren't reported
+func getVariantsFromAlignPair(pair alignPair, t *alphabet.Translator) ([]annoStruct, error) {
 
-	codon_2_AA := alphabet.MakeCodonDict()
 	rune_2_byte := encoding.MakeByteDict2() // this is emmanual paradis bitwise coding scheme byte
 
 	if len(pair.ref) != len(pair.query) {
@@ -94,7 +99,20 @@ func getVariantsFromAlignPair(pair alignPair) ([]annoStruct, error) {
 
 	annotation_array := make([]annoStruct, 0)
 
-	ref_codon := make([]byte, 3)
+	// the offset of the first codon
+	offset := 0
+	if pair.coding.CodonStart > 1 {
+		offset = pair.coding.CodonStart - 1
+	}
+	if offset > len(pair.ref) {
+		return annotation_array, nil
+	}
+
+	t = pair.coding.Translator(t)
+
+	ref_AAs := t.Translate(pair.ref[offset:])
+	que_AAs := t.Translate(pair.query[offset:])
+
 	que_codon := make([]byte, 3)
 
 	codon_snps := make([]annoStruct, 0)
@@ -102,13 +120,14 @@ func getVariantsFromAlignPair(pair alignPair) ([]annoStruct, error) {
 	counter := 0
 
 	// now compare ref and query
-	for i, _ := range(pair.ref) {
+	for i := offset; i < len(pair.ref); i++ {
 
 		if pair.ref[i] != pair.query[i] {
 			a := rune_2_byte[pair.ref[i]]
 			b := rune_2_byte[pair.query[i]]
 
-			if (a & b) < 16 {
+			// gaps are dealt with as (part of) a deleted codon
+			if (a & b) < 16 && pair.ref[i] != '-' && pair.query[i] != '-' {
 				// need to calculate pos here because pair.ref is no longer in reference coordinates if the genbank feature is two stretches of sequence Join()ed together:
 				pos, err := getSNPPos(i, pair.featPosArray)
 				if err != nil {
@@ -118,20 +137,20 @@ func getVariantsFromAlignPair(pair alignPair) ([]annoStruct, error) {
 			}
 		}
 
-		ref_codon[counter] = pair.ref[i]
 		que_codon[counter] = pair.query[i]
 
 		counter += 1
 
 		if counter == 3 {
-			ref_AA, ok_ref := codon_2_AA[string(ref_codon)]
-			que_AA, ok_que := codon_2_AA[string(que_codon)]
+			codon := (i - offset) / 3
+			ref_AA := string(ref_AAs[codon])
+			que_AA := string(que_AAs[codon])
 
-			if ok_ref && ok_que {
+			if ref_AA != "X" && ref_AA != string(alphabet.Deleted) && que_AA != "X" {
 
 				if ref_AA != que_AA {
-					annotation_array = append(annotation_array, annoStruct{queryname: pair.queryname, refAl: ref_AA, queAl: que_AA, position: (i + 1) / 3, changetype: "AA", feature: pair.featName})
-				} else {
+					annotation_array = append(annotation_array, annoStruct{queryname: pair.queryname, refAl: ref_AA, queAl: que_AA, position: codon + 1, changetype: "AA", feature: pair.featName})
+				} else if !strings.Contains(string(que_codon), "-") {
 					if len(codon_snps) > 0 {
 						for _, snp := range(codon_snps) {
 							snp.changetype = "synSNP"
@@ -151,16 +170,18 @@ func getVariantsFromAlignPair(pair alignPair) ([]annoStruct, error) {
 }
 
 // Apply some other function over the channel of align pairs
-func getVariantsFromCDS(cPairParse chan alignPairs, cAnnotate chan annoStructs, cErr chan error) {
+func getVariantsFromCDS(cPairParse chan alignPairs, policy alphabet.CodonPolicy, cAnnotate chan annoStructs, cErr chan error) {
 	// this is what comes with the descriptor field of each alignPair struct from cPairParse:
-	// subPair.descriptor = pair.queryname + "." + feature.Feature + "." + strings.ReplaceAll(feature.Info[anno], " ", "_")
+	// subPair.descriptor = pair.queryname + "." + feature.Feature + "." + strings.ReplaceAll(feature.Info.Get(anno), " ", "_")
+
+	t := alphabet.NewTranslator(policy)
 
 	for A := range(cPairParse) {
 
 		annoArray := annoStructs{queryname: A.aps[0].queryname, idx: A.idx}
 
 		for _, pair := range(A.aps) {
-			anno, err := getVariantsFromAlignPair(pair)
+			anno, err := getVariantsFromAlignPair(pair, t)
 			if err != nil {
 				cErr<- err
 			}
@@ -276,54 +297,139 @@ func writeAnnotation(outfile string, cAnnotate chan annoStructs, cWriteDone chan
 	cWriteDone<- true
 }
 
-// Variants annotates variants wrt. a reference sequence
-func Variants(samFile string, referenceFile string, genbankFile string,
-	      outfile string, threads int) error {
+// VariantsOf is the variants (as written by Variants) of a query that is aligned to the reference
+// (so that it is the same length, with insertions removed), in the CDSs in gb. It is for callers
+// that have the sequences in memory rather than in files
+func VariantsOf(ref string, query string, queryname string, gb genbank.Genbank, policy alphabet.CodonPolicy) ([]string, error) {
 
-	gb, err := genbank.ReadGenBank(genbankFile)
+	if len(ref) != len(query) {
+		return nil, errors.New(queryname + " isn't the same length as the reference: is it aligned to it?")
+	}
+
+	pair := alignPair{ref: []byte(strings.ToUpper(ref)), query: []byte(strings.ToUpper(query)), queryname: queryname}
+
+	aps, err := featurePairs(pair, getFeaturesFromAnnotation(gb, "CDS"), "gene")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	cErr := make(chan error)
+	t := alphabet.NewTranslator(policy)
 
-	cRef := make(chan fastaio.FastaRecord)
-	cRefDone := make(chan bool)
+	variants := make([]string, 0)
+	for _, ap := range aps {
+		anno, err := getVariantsFromAlignPair(ap, t)
+		if err != nil {
+			return nil, err
+		}
+		for _, aS := range anno {
+			line, err := getAnnoLine(aS)
+			if err != nil {
+				return nil, err
+			}
+			variants = append(variants, line)
+		}
+	}
 
-	go fastaio.ReadAlignment(referenceFile, cRef, cErr, cRefDone)
+	return variants, nil
+}
 
-	var refSeq string
+// Variants annotates variants wrt. a reference sequence. If skipCorrupt, malformed
+// SAM records are skipped instead of being an error, and records without a SEQ are
+// skipped or masked according to missingSeq. Partly deleted codons are translated
+// according to policy
+func Variants(samFile string, referenceFile string, genbankFile string, policy alphabet.CodonPolicy,
+	      outfile string, minQual int, skipCorrupt bool, missingSeq string, threads int) error {
+	return VariantsBatch([]string{samFile}, referenceFile, genbankFile, policy, []string{outfile}, minQual, skipCorrupt, missingSeq, threads)
+}
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
+// VariantsBatch is Variants for a batch of SAM files aligned to the same reference, whose variants
+// are written to the matching outfiles. The reference and the annotation are only read once, for
+// the whole batch
+func VariantsBatch(samFiles []string, referenceFile string, genbankFile string, policy alphabet.CodonPolicy,
+	      outfiles []string, minQual int, skipCorrupt bool, missingSeq string, threads int) error {
+
+	defer profiling.Region("sam variants")()
+
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	err := checkMissingSeq(missingSeq)
+	if err != nil {
+		return err
+	}
+
+	gb, err := genbank.Load(genbankFile)
+	if err != nil {
+		return err
+	}
+
+	features := getFeaturesFromAnnotation(gb, "CDS")
+
+	refs := newReferenceCache(referenceFile)
+
+	for i, samFile := range samFiles {
+		err = variantsOfFile(samFile, refs, features, policy, outfiles[i], minQual, skipCorrupt, missingSeq, threads)
+		if err != nil {
 			return err
-		case FR := <-cRef:
-			refSeq = FR.Seq
-			// refName = FR.ID
-		case <-cRefDone:
-			close(cRef)
-			n--
 		}
 	}
 
+	return nil
+}
+
+// variantsOfFile annotates the variants in one SAM file, for VariantsBatch
+func variantsOfFile(samFile string, refs *referenceCache, features []genbank.GenbankFeature, policy alphabet.CodonPolicy,
+	      outfile string, minQual int, skipCorrupt bool, missingSeq string, threads int) error {
+
+	// every stage is waited for before this returns, even if it fails
+	pl := pipeline.New()
+	cErr := pl.Errs
+
 	cSamRecords := make(chan samRecords, threads)
-	cSH := make(chan biogosam.Header)
+	cSH := make(chan biogosam.Header, 1)
 	cPairAlign := make(chan alignPair)
 	cPairParse := make(chan alignPairs)
 	cVariants := make(chan annoStructs)
 
-	cReadDone := make(chan bool)
-	cAlignWaitGroupDone := make(chan bool)
-	cParseWaitGroupDone := make(chan bool)
-	cVariantsDone := make(chan bool)
-	cWriteDone := make(chan bool)
+	cReadDone := make(chan bool, 1)
+	cWriteDone := make(chan bool, 1)
 
-	go groupSamRecords(samFile, cSH, cSamRecords, cReadDone, cErr)
+	pl.Run(func() {
+		groupSamRecordsFrom(samFile, 0, minQual, skipCorrupt, missingSeq, cSH, cSamRecords, cReadDone, cErr, pl.Stop)
+		close(cSamRecords)
+	})
 
-	_ = <-cSH
+	// abort stops the pipeline before the workers have been started
+	abort := func(err error) error {
+		if err != nil {
+			pl.Fail(err)
+		}
+		for range cSamRecords {
+		}
+		return pl.Wait()
+	}
+
+	var header biogosam.Header
+	select {
+	case <-pl.Stop:
+		return abort(nil)
+	case header = <-cSH:
+	}
+
+	ref, err := refs.get(header)
+	if err != nil {
+		return abort(err)
+	}
+
+	refSeq := ref.Seq
+
+	err = checkReference(header, ref)
+	if err != nil {
+		return abort(err)
+	}
 
-	go writeAnnotation(outfile, cVariants, cWriteDone, cErr)
+	pl.Run(func() { writeAnnotation(outfile, cVariants, cWriteDone, cErr) })
 
 	var wgAlign sync.WaitGroup
 	wgAlign.Add(threads)
@@ -335,92 +441,35 @@ func Variants(samFile string, referenceFile string, genbankFile string,
 	wgVar.Add(threads)
 
 	for n := 0; n < threads; n++ {
-		go func() {
+		pl.Run(func() {
 			blockToPairwiseAlignment(cSamRecords, cPairAlign, cErr, []byte(refSeq), true)
 			wgAlign.Done()
-		}()
+		})
 	}
 
-	features := getFeaturesFromAnnotation(gb, "CDS")
-
 	for n := 0; n < threads; n++ {
-		go func() {
+		pl.Run(func() {
 			parseAlignmentByAnnotation(features, cPairAlign, cPairParse, cErr)
 			wgParse.Done()
-		}()
+		})
 	}
 
 	for n := 0; n < threads; n++ {
-		go func() {
-			getVariantsFromCDS(cPairParse, cVariants, cErr)
+		pl.Run(func() {
+			getVariantsFromCDS(cPairParse, policy, cVariants, cErr)
 			wgVar.Done()
-		}()
+		})
 	}
 
-	go func() {
+	// each stage's output is closed once all of its workers are done
+	pl.Run(func() {
 		wgAlign.Wait()
-		cAlignWaitGroupDone<- true
-	}()
-
-	go func() {
+		close(cPairAlign)
 		wgParse.Wait()
-		cParseWaitGroupDone<- true
-	}()
-
-	go func() {
+		close(cPairParse)
 		wgVar.Wait()
-		cVariantsDone<- true
-	}()
-
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cReadDone:
-			close(cSamRecords)
-			close(cSH)
-			n--
-		}
-	}
+		close(cVariants)
+	})
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cAlignWaitGroupDone:
-			close(cPairAlign)
-			n--
-		}
-	}
-
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cParseWaitGroupDone:
-			close(cPairParse)
-			n--
-		}
-	}
-
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cVariantsDone:
-			close(cVariants)
-			n--
-		}
-	}
-
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cWriteDone:
-			n--
-		}
-	}
-
-	return nil
+	return pl.Wait()
 }
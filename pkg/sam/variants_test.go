@@ -0,0 +1,41 @@
+package sam
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+)
+
+func TestVariantsOf(t *testing.T) {
+	gb, err := genbank.Parse(strings.NewReader(`LOCUS       test                      21 bp    DNA     linear   VRL 01-JAN-2021
+FEATURES             Location/Qualifiers
+     CDS             1..18
+                     /gene="g1"
+ORIGIN
+        1 atgaaacccg ggttttaaac g
+//
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref := "ATGAAACCCGGGTTTTAAACG"
+
+	// K2E, a deleted fourth codon, and a synonymous SNP in the fifth
+	variants, err := VariantsOf(ref, "ATGGAACCC---TTCTAAACG", "q1", gb, alphabet.CodonX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := []string{"g1:K2E", "g1:G4-", "synSNP:T15C"}
+	if !reflect.DeepEqual(variants, desired) {
+		t.Errorf("problem in TestVariantsOf: got %v, wanted %v", variants, desired)
+	}
+
+	_, err = VariantsOf(ref, "ATG", "q2", gb, alphabet.CodonX)
+	if err == nil {
+		t.Error("problem in TestVariantsOf: expected an error for a query that isn't aligned")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Package sample downsamples an alignment, either uniformly at random or stratified by
+metadata (e.g. at most so many sequences per country and week), in one pass through it.
+*/
+package sample
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// stratum is one --by, a metadata column, optionally binned by week or month if it is a date
+type stratum struct {
+	column string
+	unit   string
+}
+
+// parseStrata parses the --by columns, which are column or column:week or column:month
+func parseStrata(by []string, md *metadata.Metadata) ([]stratum, error) {
+
+	if len(by) > 0 && md == nil {
+		return nil, usage.New("--by needs --metadata or --name-format")
+	}
+
+	strata := make([]stratum, 0, len(by))
+	for _, s := range by {
+		parts := strings.SplitN(s, ":", 2)
+		st := stratum{column: parts[0]}
+		if len(parts) == 2 {
+			st.unit = parts[1]
+			if st.unit != "week" && st.unit != "month" {
+				return nil, usage.Errorf("--by %s: a date column can only be binned by week or month", s)
+			}
+		}
+		if !md.HasColumn(st.column) {
+			return nil, usage.Errorf("--by %s: there is no column called %s in the metadata", s, st.column)
+		}
+		strata = append(strata, st)
+	}
+
+	return strata, nil
+}
+
+// group is which stratum a sequence is in, e.g. England|2020-W09
+func group(name string, strata []stratum, md *metadata.Metadata) string {
+	values := make([]string, 0, len(strata))
+	for _, st := range strata {
+		value, _ := md.Value(name, st.column)
+		if len(st.unit) > 0 {
+			value = metadata.DateBin(value, st.unit)
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, "|")
+}
+
+// reservoir is a uniform random sample of at most size of the records offered to it
+type reservoir struct {
+	size    int
+	seen    int
+	records []fastaio.FastaRecord
+}
+
+func (r *reservoir) offer(FR fastaio.FastaRecord, rng *rand.Rand) {
+	r.seen++
+	if len(r.records) < r.size {
+		r.records = append(r.records, FR)
+		return
+	}
+	j := rng.Intn(r.seen)
+	if j < r.size {
+		r.records[j] = FR
+	}
+}
+
+// Sample writes a random sample of n of the records in infile to outfile, in fasta format and in
+// the order they were in infile. If by isn't empty, n records are sampled from each group of records
+// that have the same values of those columns of md (see parseStrata) instead. Only the records that
+// md keeps are sampled, and they are named with its header template, if it has one. Each group is sampled with a reservoir as the records are read, so only the
+// sample is held in memory. The same seed gives the same sample; seed < 0 picks one, which is
+// written to stderr. Either way, the seed is recorded in the run's summary
+func Sample(infile string, outfile string, n int, by []string, seed int64, md *metadata.Metadata) error {
+
+	if n < 1 {
+		return usage.New("--number must be at least 1")
+	}
+
+	strata, err := parseStrata(by, md)
+	if err != nil {
+		return err
+	}
+
+	if seed < 0 {
+		seed = time.Now().UnixNano() & 0x7fffffffffff
+		fmt.Fprintf(os.Stderr, "sampling with --seed %d\n", seed)
+	}
+	summary.Seed(seed)
+	rng := rand.New(rand.NewSource(seed))
+
+	reservoirs := make(map[string]*reservoir)
+
+	cFR := make(chan fastaio.FastaRecord)
+	cErr := make(chan error)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	counter := 0
+	dropped := 0
+
+	for i := 1; i > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			FR.Idx = counter
+			counter++
+			if !md.Keep(FR.ID) {
+				dropped++
+				continue
+			}
+			g := group(FR.ID, strata, md)
+			r, ok := reservoirs[g]
+			if !ok {
+				r = &reservoir{size: n}
+				reservoirs[g] = r
+			}
+			r.offer(FR, rng)
+		case <-cDone:
+			i--
+		}
+	}
+
+	records := make([]fastaio.FastaRecord, 0)
+	for _, r := range reservoirs {
+		records = append(records, r.records...)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Idx < records[j].Idx })
+
+	var f *os.File
+	if outfile != "stdout" {
+		f, err = os.Create(outfile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	w := bufio.NewWriter(f)
+	for _, FR := range records {
+		_, err = w.WriteString(">" + md.Header(FR.ID, FR.Description) + "\n" + FR.Seq + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	summary.Add(summary.Processed, len(records))
+	summary.Add(summary.Filtered, dropped)
+
+	md.Report()
+
+	return nil
+}
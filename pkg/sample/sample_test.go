@@ -0,0 +1,122 @@
+package sample
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// names reads the names of the records in a fasta file
+func names(t *testing.T, path string) []string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, ">") {
+			names = append(names, line[1:])
+		}
+	}
+	return names
+}
+
+func TestSample(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var aln, meta strings.Builder
+	meta.WriteString("name,country,date\n")
+	for i := 0; i < 100; i++ {
+		country := "England"
+		if i%3 == 0 {
+			country = "Wales"
+		}
+		date := "2020-03-01"
+		if i%2 == 1 {
+			date = "2020-03-08"
+		}
+		name := "s" + string(rune('A'+i/26)) + string(rune('a'+i%26))
+		aln.WriteString(">" + name + "\nACGT\n")
+		meta.WriteString(name + "," + country + "," + date + "\n")
+	}
+
+	alnFile := filepath.Join(dir, "aln.fasta")
+	err = ioutil.WriteFile(alnFile, []byte(aln.String()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaFile := filepath.Join(dir, "metadata.csv")
+	err = ioutil.WriteFile(metaFile, []byte(meta.String()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := metadata.Load(metaFile, nil, nil, "date", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "out.fasta")
+
+	err = Sample(alnFile, outFile, 10, nil, 1, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := names(t, outFile)
+	if len(first) != 10 {
+		t.Errorf("problem in TestSample: got %d sequences, expected 10", len(first))
+	}
+	for i := 1; i < len(first); i++ {
+		if first[i] <= first[i-1] {
+			t.Errorf("problem in TestSample: the sample isn't in the order of the input: %v", first)
+		}
+	}
+
+	err = Sample(alnFile, outFile, 10, nil, 1, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names(t, outFile), ",") != strings.Join(first, ",") {
+		t.Errorf("problem in TestSample: the same seed gave a different sample")
+	}
+
+	// two countries and two weeks (2020-03-01 is in 2020-W09, and 2020-03-08 in 2020-W10)
+	err = Sample(alnFile, outFile, 3, []string{"country", "date:week"}, 1, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, name := range names(t, outFile) {
+		country, _ := md.Value(name, "country")
+		date, _ := md.Value(name, "date")
+		counts[country+"|"+metadata.DateBin(date, "week")]++
+	}
+	if len(counts) != 4 {
+		t.Errorf("problem in TestSample: expected 4 groups, got %v", counts)
+	}
+	for g, count := range counts {
+		if count != 3 {
+			t.Errorf("problem in TestSample: got %d sequences from %s, expected 3", count, g)
+		}
+	}
+
+	for _, by := range [][]string{{"lineage"}, {"date:day"}} {
+		err = Sample(alnFile, outFile, 3, by, 1, md)
+		if !usage.Is(err) {
+			t.Errorf("problem in TestSample: expected a usage error for --by %v, got %v", by, err)
+		}
+	}
+	err = Sample(alnFile, outFile, 3, []string{"country"}, 1, nil)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestSample: expected a usage error for --by without metadata, got %v", err)
+	}
+}
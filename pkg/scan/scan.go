@@ -0,0 +1,285 @@
+// Package scan looks for stretches of aligned sequences that are unusually divergent
+// from the reference, or unusually full of Ns, in sliding windows
+package scan
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// window is one window of one query. start and end are 0-based, end exclusive
+type window struct {
+	start      int
+	end        int
+	known      int // sites where the query has an unambiguous nucleotide
+	diffs      int // known sites where the query differs from the reference
+	ns         int // sites where the query is N or ?
+	divergence float64
+	nFraction  float64
+}
+
+// flagged is the windows in one query that pass one of the thresholds
+type flagged struct {
+	queryname string
+	windows   []window
+	idx       int
+}
+
+// WindowStarts returns the start of every window of size in a length-long alignment,
+// every step sites. If the windows don't reach the end of the alignment, there is
+// one more window which ends at the end
+func WindowStarts(length int, size int, step int) []int {
+
+	if length <= size {
+		return []int{0}
+	}
+
+	starts := make([]int, 0)
+	for start := 0; start+size <= length; start += step {
+		starts = append(starts, start)
+	}
+	if starts[len(starts)-1]+size < length {
+		starts = append(starts, length-size)
+	}
+
+	return starts
+}
+
+// scanWindow counts the differences and Ns in one window of a query (both encoded
+// using EP's bitwise coding scheme). Only sites where the query has a known (unambiguous)
+// nucleotide count towards the divergence, and gaps count towards neither measure
+func scanWindow(refSeq []byte, seq []byte, start int, end int) window {
+
+	w := window{start: start, end: end}
+
+	for i := start; i < end; i++ {
+		nuc := seq[i]
+		switch {
+		case nuc&8 == 8:
+			w.known++
+			if refSeq[i]&nuc < 16 {
+				w.diffs++
+			}
+		case nuc == 240 || nuc == 242:
+			w.ns++
+		}
+	}
+
+	if w.known > 0 {
+		w.divergence = float64(w.diffs) / float64(w.known)
+	}
+	w.nFraction = float64(w.ns) / float64(end-start)
+
+	return w
+}
+
+// scanQueries is a worker that scans each record from cFR in windows, and passes the
+// windows whose divergence is above maxDivergence, or whose proportion of Ns is above
+// maxN, to cFlagged
+func scanQueries(refSeq []byte, size int, step int, maxDivergence float64, maxN float64, cFR chan fastaio.EncodedFastaRecord, cFlagged chan flagged, cErr chan error) {
+
+	starts := WindowStarts(len(refSeq), size, step)
+
+	for FR := range cFR {
+		if len(FR.Seq) != len(refSeq) {
+			cErr <- errors.New(FR.ID + " isn't the same length as the reference: is the file aligned?")
+			return
+		}
+
+		fl := flagged{queryname: FR.ID, idx: FR.Idx, windows: make([]window, 0)}
+
+		for _, start := range starts {
+			end := start + size
+			if end > len(refSeq) {
+				end = len(refSeq)
+			}
+			w := scanWindow(refSeq, FR.Seq, start, end)
+			if w.divergence > maxDivergence || w.nFraction > maxN {
+				fl.windows = append(fl.windows, w)
+			}
+		}
+
+		cFlagged <- fl
+	}
+}
+
+// reasons says which thresholds a window is over, "|"-separated
+func (w window) reasons(maxDivergence float64, maxN float64) string {
+	r := make([]string, 0, 2)
+	if w.divergence > maxDivergence {
+		r = append(r, "divergence")
+	}
+	if w.nFraction > maxN {
+		r = append(r, "Ns")
+	}
+	return strings.Join(r, "|")
+}
+
+// writeFlagged writes the flagged windows as they arrive, in the same order as the queries
+// are in the input file. It uses a map to hold the queries that arrive out of order
+func writeFlagged(outFile string, maxDivergence float64, maxN float64, cFlagged chan flagged, cErr chan error, cWriteDone chan bool) {
+
+	outputMap := make(map[int]flagged)
+
+	counter := 0
+
+	var f *os.File
+	var err error
+
+	if outFile != "stdout" {
+		f, err = os.Create(outFile)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+
+	_, err = f.WriteString("query,start,end,known_sites,differences,divergence,Ns,N_fraction,reason\n")
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	write := func(fl flagged) {
+		for _, w := range fl.windows {
+			_, err := f.WriteString(strings.Join([]string{
+				fl.queryname,
+				strconv.Itoa(w.start + 1),
+				strconv.Itoa(w.end),
+				strconv.Itoa(w.known),
+				strconv.Itoa(w.diffs),
+				strconv.FormatFloat(w.divergence, 'f', 4, 64),
+				strconv.Itoa(w.ns),
+				strconv.FormatFloat(w.nFraction, 'f', 4, 64),
+				w.reasons(maxDivergence, maxN),
+			}, ",") + "\n")
+			if err != nil {
+				cErr <- err
+			}
+		}
+	}
+
+	for fl := range cFlagged {
+		outputMap[fl.idx] = fl
+
+		for {
+			next, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			write(next)
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	summary.Add(summary.Processed, counter)
+
+	cWriteDone <- true
+}
+
+// Scan scans each query in a fasta-format alignment in windows of size sites, every step
+// sites, and writes the windows whose divergence from the reference is more than
+// maxDivergence, or whose proportion of Ns is more than maxN, using threads workers
+func Scan(referenceFile string, alignmentFile string, outFile string, size int, step int, maxDivergence float64, maxN float64, threads int) error {
+
+	defer profiling.Region("scan")()
+
+	if size < 1 || step < 1 {
+		return usage.Errorf("--window and --step should be at least 1, not %d and %d", size, step)
+	}
+
+	cErr := make(chan error)
+
+	cRef := make(chan fastaio.EncodedFastaRecord)
+	cRefDone := make(chan bool)
+
+	cFR := make(chan fastaio.EncodedFastaRecord)
+	cFRDone := make(chan bool)
+
+	cFlagged := make(chan flagged, threads)
+	cScanDone := make(chan bool)
+
+	cWriteDone := make(chan bool)
+
+	go fastaio.ReadEncodeAlignment(referenceFile, cRef, cErr, cRefDone)
+
+	var refSeq []byte
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cRef:
+			refSeq = FR.Seq
+		case <-cRefDone:
+			close(cRef)
+			n--
+		}
+	}
+
+	if len(refSeq) == 0 {
+		return errors.New("no sequence in --reference")
+	}
+
+	go fastaio.ReadEncodeAlignment(alignmentFile, cFR, cErr, cFRDone)
+
+	go writeFlagged(outFile, maxDivergence, maxN, cFlagged, cErr, cWriteDone)
+
+	var wgScan sync.WaitGroup
+	wgScan.Add(threads)
+
+	for n := 0; n < threads; n++ {
+		go func() {
+			scanQueries(refSeq, size, step, maxDivergence, maxN, cFR, cFlagged, cErr)
+			wgScan.Done()
+		}()
+	}
+
+	go func() {
+		wgScan.Wait()
+		cScanDone <- true
+	}()
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cFRDone:
+			close(cFR)
+			n--
+		}
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cScanDone:
+			close(cFlagged)
+			n--
+		}
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cWriteDone:
+			n--
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package scan
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func TestWindowStarts(t *testing.T) {
+	tests := []struct {
+		length   int
+		size     int
+		step     int
+		expected []int
+	}{
+		{20, 8, 4, []int{0, 4, 8, 12}},
+		{21, 8, 4, []int{0, 4, 8, 12, 13}},
+		{20, 10, 10, []int{0, 10}},
+		{5, 8, 4, []int{0}},
+	}
+
+	for _, test := range tests {
+		starts := WindowStarts(test.length, test.size, test.step)
+		if !reflect.DeepEqual(starts, test.expected) {
+			t.Errorf("problem in TestWindowStarts: %d %d %d: got %v, expected %v", test.length, test.size, test.step, starts, test.expected)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	refFile := filepath.Join(dir, "ref.fasta")
+	err = ioutil.WriteFile(refFile, []byte(">ref\nACGTACGTACGTACGTACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// q2 has three differences around the middle, q3 starts with Ns, and q4's
+	// ambiguity codes and gaps count as neither
+	alnFile := filepath.Join(dir, "aln.fasta")
+	err = ioutil.WriteFile(alnFile, []byte(">q1\nACGTACGTACGTACGTACGT\n>q2\nACGTACGTACTAGCGTACGT\n>q3\nNNNNNNNNACGTACGTACGT\n>q4\nRYGT--GTACGTACGTACGT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outFile := filepath.Join(dir, "out.csv")
+
+	err = Scan(refFile, alnFile, outFile, 8, 4, 0.2, 0.5, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "query,start,end,known_sites,differences,divergence,Ns,N_fraction,reason\n" +
+		"q2,5,12,8,2,0.2500,0,0.0000,divergence\n" +
+		"q2,9,16,8,3,0.3750,0,0.0000,divergence\n" +
+		"q3,1,8,0,0,0.0000,8,1.0000,Ns\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestScan: got\n%s\nexpected\n%s", out, expected)
+	}
+
+	err = Scan(refFile, alnFile, outFile, 0, 4, 0.2, 0.5, 2)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestScan: expected a usage error for --window 0, got %v", err)
+	}
+}
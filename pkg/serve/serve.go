@@ -0,0 +1,364 @@
+/*
+Package serve runs the main conversions (sam toMultiAlign, snps, sam variants and closest) as
+an HTTP API, so that web applications and LIMS can call them without starting a process for
+each request. Each operation is a POST endpoint that takes its input files as the parts of a
+multipart/form-data body (or, if it only needs one file, as the whole body), and its options
+as query parameters with the same names as the command line flags. Uploads are written to a
+temporary directory as they arrive, rather than being held in memory, and the alignment from
+sam/toMultiAlign is streamed back as it is made.
+*/
+package serve
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/closest"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/sam"
+	"github.com/cov-ert/gofasta/pkg/snps"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// errorTrailer is the trailer that an error is reported in if it happens after the response
+// has started, when it is too late to change the status code
+const errorTrailer = "Gofasta-Error"
+
+// endpoint is one operation. Its input files are the parts in required and optional, which run
+// is given the paths of (after they have been uploaded), and it writes its output to w
+type endpoint struct {
+	required    []string
+	optional    []string
+	contentType string
+	run         func(in map[string]string, q url.Values, dir string, threads int, w io.Writer) error
+}
+
+var endpoints = map[string]endpoint{
+	"/sam/toMultiAlign": {
+		required:    []string{"sam"},
+		optional:    []string{"reference"},
+		contentType: "text/x-fasta",
+		run:         toMultiAlign,
+	},
+	"/snps": {
+		required:    []string{"reference", "query"},
+		contentType: "text/csv",
+		run:         snpsRun,
+	},
+	"/sam/variants": {
+		required:    []string{"sam", "reference"},
+		optional:    []string{"genbank"},
+		contentType: "text/csv",
+		run:         variants,
+	},
+	"/closest": {
+		required:    []string{"query", "target"},
+		contentType: "text/csv",
+		run:         closestRun,
+	},
+}
+
+// responseWriter remembers whether anything has been written, and flushes each write so
+// that the client gets the output as it is made
+type responseWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wrote = true
+	n, err := rw.ResponseWriter.Write(b)
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// upload writes the input files in a request's body to dir, and returns their paths. A body
+// that isn't multipart is the first required file
+func upload(r *http.Request, dir string, e endpoint) (map[string]string, error) {
+
+	in := make(map[string]string)
+
+	save := func(name string, body io.Reader) error {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(f, body)
+		if err != nil {
+			return err
+		}
+		in[name] = path
+		return nil
+	}
+
+	allowed := make(map[string]bool)
+	for _, name := range append(append([]string{}, e.required...), e.optional...) {
+		allowed[name] = true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		err := save(e.required[0], r.Body)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, usage.Errorf("couldn't read the request body: %v", err)
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, usage.Errorf("couldn't read the request body: %v", err)
+			}
+			name := part.FormName()
+			switch {
+			case !allowed[name]:
+				return nil, usage.Errorf("unexpected part of the request body: %s", name)
+			case len(in[name]) > 0:
+				return nil, usage.Errorf("more than one %s in the request body", name)
+			}
+			err = save(name, part)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, name := range e.required {
+		if len(in[name]) == 0 {
+			return nil, usage.Errorf("the request body needs a %s part", name)
+		}
+	}
+
+	return in, nil
+}
+
+// intParam is the value of an integer query parameter, or def if it isn't given
+func intParam(q url.Values, name string, def int) (int, error) {
+	if len(q.Get(name)) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(q.Get(name))
+	if err != nil {
+		return 0, usage.Errorf("%s should be an integer, not %s", name, q.Get(name))
+	}
+	return n, nil
+}
+
+// boolParam is the value of a boolean query parameter, which is false if it isn't given
+func boolParam(q url.Values, name string) (bool, error) {
+	if len(q.Get(name)) == 0 {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(q.Get(name))
+	if err != nil {
+		return false, usage.Errorf("%s should be true or false, not %s", name, q.Get(name))
+	}
+	return b, nil
+}
+
+// sendFile copies an output file to w
+func sendFile(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// toMultiAlign takes --trim, --pad, --trimstart, --trimend and --out-format (not diff)
+func toMultiAlign(in map[string]string, q url.Values, dir string, threads int, w io.Writer) error {
+
+	trim, err := boolParam(q, "trim")
+	if err != nil {
+		return err
+	}
+	pad, err := boolParam(q, "pad")
+	if err != nil {
+		return err
+	}
+	trimstart, err := intParam(q, "trimstart", -1)
+	if err != nil {
+		return err
+	}
+	trimend, err := intParam(q, "trimend", -1)
+	if err != nil {
+		return err
+	}
+	format := q.Get("out-format")
+	if len(format) == 0 {
+		format = "fasta"
+	}
+
+	out, err := fastaio.NewOutputWriter(w, format, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	opts := sam.DefaultToMultiAlignOptions()
+	opts.Trim = trim
+	opts.Pad = pad
+	opts.TrimStart = trimstart
+	opts.TrimEnd = trimend
+	opts.Format = format
+	opts.Threads = threads
+	return sam.ToMultiAlign(in["sam"], in["reference"], "", out, opts)
+}
+
+func snpsRun(in map[string]string, q url.Values, dir string, threads int, w io.Writer) error {
+	outfile := filepath.Join(dir, "snps.csv")
+	err := snps.SNPs(in["reference"], in["query"], outfile, "", nil, alphabet.Nucleotide, threads)
+	if err != nil {
+		return err
+	}
+	return sendFile(outfile, w)
+}
+
+// variants takes --codons, and the genbank annotation as a part of the body or, with the
+// genbank query parameter, as an accession to fetch from NCBI
+func variants(in map[string]string, q url.Values, dir string, threads int, w io.Writer) error {
+
+	policy := alphabet.CodonX
+	if len(q.Get("codons")) > 0 {
+		var err error
+		policy, err = alphabet.ParseCodonPolicy(q.Get("codons"))
+		if err != nil {
+			return err
+		}
+	}
+
+	genbankFile := in["genbank"]
+	switch {
+	case len(genbankFile) > 0 && len(q.Get("genbank")) > 0:
+		return usage.New("give the genbank annotation as a part of the request body or an accession, not both")
+	case len(q.Get("genbank")) > 0:
+		if !genbank.IsAccession(q.Get("genbank")) {
+			return usage.Errorf("not a nucleotide accession: %s", q.Get("genbank"))
+		}
+		genbankFile = q.Get("genbank")
+	case len(genbankFile) == 0:
+		return usage.New("the request needs a genbank part, or a genbank accession")
+	}
+
+	outfile := filepath.Join(dir, "variants.csv")
+	err := sam.Variants(in["sam"], in["reference"], genbankFile, policy, outfile, 0, false, "skip", threads)
+	if err != nil {
+		return err
+	}
+	return sendFile(outfile, w)
+}
+
+func closestRun(in map[string]string, q url.Values, dir string, threads int, w io.Writer) error {
+	outfile := filepath.Join(dir, "closest.csv")
+	err := closest.Closest(in["query"], in["target"], outfile, "", nil, false, "", 0, -1, threads)
+	if err != nil {
+		return err
+	}
+	return sendFile(outfile, w)
+}
+
+// NewHandler returns the API's handler. At most maxRequests requests are run at once (the
+// rest wait their turn), each with threads workers. A request that fails part of the way
+// through has stopped everything it started by the time its handler returns, so nothing
+// writes to the response, or reads the uploads, after that
+func NewHandler(maxRequests int, threads int) http.Handler {
+
+	sem := make(chan bool, maxRequests)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, version.Version+"\n")
+	})
+
+	for path, e := range endpoints {
+		e := e
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, "use POST", http.StatusMethodNotAllowed)
+				return
+			}
+
+			dir, err := ioutil.TempDir("", "gofasta-serve")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer os.RemoveAll(dir)
+
+			rw := &responseWriter{ResponseWriter: w}
+
+			fail := func(err error) {
+				switch {
+				case rw.wrote:
+					w.Header().Set(errorTrailer, err.Error())
+				case usage.Is(err):
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				default:
+					http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				}
+			}
+
+			in, err := upload(r, dir, e)
+			if err != nil {
+				fail(err)
+				return
+			}
+			defer func() {
+				for _, path := range in {
+					summary.Forget(path)
+				}
+			}()
+
+			select {
+			case sem <- true:
+				defer func() { <-sem }()
+			case <-r.Context().Done():
+				return
+			}
+
+			w.Header().Set("Trailer", errorTrailer)
+			w.Header().Set("Content-Type", e.contentType)
+
+			err = e.run(in, r.URL.Query(), dir, threads, rw)
+			if err != nil {
+				fail(err)
+			}
+		})
+	}
+
+	return mux
+}
+
+// Serve runs the API on addr (e.g. localhost:8080) until it fails
+func Serve(addr string, maxRequests int, threads int) error {
+	if maxRequests < 1 {
+		return usage.New("--max-requests must be at least 1")
+	}
+	fmt.Fprintf(os.Stderr, "gofasta %s listening on %s\n", version.Version, addr)
+	return http.ListenAndServe(addr, NewHandler(maxRequests, threads))
+}
@@ -0,0 +1,173 @@
+package serve
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const samFile = "@SQ\tSN:ref\tLN:12\n" +
+	"q1\t0\tref\t1\t60\t12M\t*\t0\t0\tACGTTCGTACGA\t*\n" +
+	"q2\t0\tref\t3\t60\t4M2D4M\t*\t0\t0\tGTACACGT\t*\n"
+
+// multipartBody makes a multipart/form-data body with one part per file
+func multipartBody(t *testing.T, files map[string]string) (*bytes.Buffer, string) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	for name, content := range files {
+		fw, err := mw.CreateFormFile(name, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	err := mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &b, mw.FormDataContentType()
+}
+
+func TestServe(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(1, 1))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/sam/toMultiAlign?trim=true&trimstart=2&trimend=10", "text/plain", strings.NewReader(samFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	desired := ">q1\nGTTCGTAC\n>q2\nGTAC--AC\n"
+	if resp.StatusCode != http.StatusOK || string(body) != desired || len(resp.Trailer.Get(errorTrailer)) > 0 {
+		t.Errorf("problem in TestServe: toMultiAlign got %s %q (trailer %q), wanted %q", resp.Status, body, resp.Trailer.Get(errorTrailer), desired)
+	}
+
+	b, contentType := multipartBody(t, map[string]string{
+		"reference": ">ref\nACGTACGTACGT\n",
+		"query":     ">q1\nACGTTCGTACGA\n>q2\nACGTACGTACGT\n",
+	})
+	resp, err = http.Post(ts.URL+"/snps", contentType, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	desired = "query,SNPs\nq1,A5T|T12A\nq2,\n"
+	if resp.StatusCode != http.StatusOK || string(body) != desired {
+		t.Errorf("problem in TestServe: snps got %s %q, wanted %q", resp.Status, body, desired)
+	}
+
+	// a missing input is a bad request
+	b, contentType = multipartBody(t, map[string]string{"query": ">q1\nACGT\n"})
+	resp, err = http.Post(ts.URL+"/snps", contentType, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("problem in TestServe: snps without a reference got %s", resp.Status)
+	}
+
+	// so is a bad option
+	resp, err = http.Post(ts.URL+"/sam/toMultiAlign?out-format=diff", "text/plain", strings.NewReader(samFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("problem in TestServe: toMultiAlign with a bad --out-format got %s", resp.Status)
+	}
+
+	// and bad data can't be processed
+	resp, err = http.Post(ts.URL+"/sam/toMultiAlign", "text/plain", strings.NewReader("not a sam file\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("problem in TestServe: toMultiAlign of a bad SAM file got %s", resp.Status)
+	}
+
+	resp, err = http.Get(ts.URL + "/snps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("problem in TestServe: GET got %s", resp.Status)
+	}
+}
+
+// A SAM file that goes wrong part of the way through fails that request, after its output has
+// started, and leaves nothing running that could break the next one
+func TestServeCorruptSam(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(1, 2))
+	defer ts.Close()
+
+	corrupt := samFile
+	for i := 0; i < 200; i++ {
+		corrupt += "q" + strconv.Itoa(i+3) + "\t0\tref\t1\t60\t12M\t*\t0\t0\tACGTTCGTACGA\t*\n"
+	}
+	corrupt += "bad\t0\tref\tone\t60\t12M\t*\t0\t0\tACGTTCGTACGA\t*\n"
+	for i := 0; i < 200; i++ {
+		corrupt += "r" + strconv.Itoa(i) + "\t0\tref\t1\t60\t12M\t*\t0\t0\tACGTTCGTACGA\t*\n"
+	}
+
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 5; i++ {
+		resp, err := http.Post(ts.URL+"/sam/toMultiAlign", "text/plain", strings.NewReader(corrupt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && len(resp.Trailer.Get(errorTrailer)) == 0 {
+			t.Errorf("problem in TestServeCorruptSam: a corrupt SAM file got %s, without an error", resp.Status)
+		}
+
+		b, contentType := multipartBody(t, map[string]string{
+			"sam":       corrupt,
+			"reference": ">ref\nACGTACGTACGT\n",
+			"genbank":   "LOCUS       ref 12 bp DNA\nORIGIN\n        1 acgtacgtac gt\n//\n",
+		})
+		resp, err = http.Post(ts.URL+"/sam/variants", contentType, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("problem in TestServeCorruptSam: variants of a corrupt SAM file got %s", resp.Status)
+		}
+	}
+
+	// the requests' goroutines have all finished (other than the connections' own)
+	http.DefaultClient.CloseIdleConnections()
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Errorf("problem in TestServeCorruptSam: %d goroutines are running after the requests, %d before", runtime.NumGoroutine(), before)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err := http.Post(ts.URL+"/sam/toMultiAlign?trim=true&trimstart=2&trimend=10", "text/plain", strings.NewReader(samFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	desired := ">q1\nGTTCGTAC\n>q2\nGTAC--AC\n"
+	if resp.StatusCode != http.StatusOK || string(body) != desired || len(resp.Trailer.Get(errorTrailer)) > 0 {
+		t.Errorf("problem in TestServeCorruptSam: the next request got %s %q (trailer %q), wanted %q", resp.Status, body, resp.Trailer.Get(errorTrailer), desired)
+	}
+}
@@ -0,0 +1,530 @@
+/*
+Package sketch makes MinHash sketches of aligned sequences, so that the targets that are most
+likely to be close to a query can be picked out of a large panel without comparing every column.
+
+A sequence's sketch is the size smallest hashes of its positional k-mers (each run of k
+unambiguous bases, hashed together with the alignment column it starts at), so two sequences
+with few differences between them share most of their sketches. An index of the sketches of a
+target alignment is written once by Write, kept up to date by Update as records are added to the
+alignment, and read back by OpenIndex.
+*/
+package sketch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// Sketch is the smallest hashes of a sequence's positional k-mers, in increasing order
+type Sketch []uint64
+
+// empty pads the sketches in an index of sequences that have fewer k-mers than its size
+const empty = ^uint64(0)
+
+// baseCodes are the 2-bit codes of the EP codes of A, C, G and T, and -1 for anything else
+var baseCodes [256]int8
+
+func init() {
+	for i := range baseCodes {
+		baseCodes[i] = -1
+	}
+	baseCodes[136] = 0
+	baseCodes[40] = 1
+	baseCodes[72] = 2
+	baseCodes[24] = 3
+}
+
+// hash mixes the bits of a k-mer's code
+func hash(code uint64) uint64 {
+	code *= 0x9e3779b97f4a7c15
+	return code ^ code>>29
+}
+
+// checkParams checks that k and size can be used to make sketches
+func checkParams(k int, size int) error {
+	if k < 1 || k > 32 {
+		return usage.Errorf("--kmer must be between 1 and 32, not %d", k)
+	}
+	if size < 1 {
+		return usage.Errorf("--size must be at least 1, not %d", size)
+	}
+	return nil
+}
+
+// New makes the sketch of an encoded sequence (in EP's bitwise coding scheme) from its k-mers
+// (k <= 32) that are only made of A, C, G and T. It has fewer than size hashes if the sequence
+// doesn't have that many k-mers
+func New(seq []byte, k int, size int) Sketch {
+
+	var code uint64
+	mask := ^uint64(0)
+	if k < 32 {
+		mask = uint64(1)<<(2*k) - 1
+	}
+	valid := 0
+
+	hashes := make([]uint64, 0, len(seq))
+
+	for i, nuc := range seq {
+		c := baseCodes[nuc]
+		if c < 0 {
+			valid = 0
+			continue
+		}
+		code = (code<<2 | uint64(c)) & mask
+		valid++
+		if valid < k {
+			continue
+		}
+		hashes = append(hashes, hash(hash(code)^uint64(i-k+1)))
+	}
+
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	s := make(Sketch, 0, size)
+	for i, h := range hashes {
+		if len(s) == size {
+			break
+		}
+		if i > 0 && h == hashes[i-1] {
+			continue
+		}
+		s = append(s, h)
+	}
+
+	return s
+}
+
+// Similarity estimates the Jaccard similarity of the k-mers of the sequences that two sketches
+// were made from: the proportion of the size smallest hashes of both sketches together that
+// are in both of them
+func Similarity(a Sketch, b Sketch, size int) float64 {
+	shared := 0
+	seen := 0
+	i, j := 0, 0
+	for seen < size && i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			shared++
+			i++
+			j++
+		}
+		seen++
+	}
+	for seen < size && i < len(a) {
+		i++
+		seen++
+	}
+	for seen < size && j < len(b) {
+		j++
+		seen++
+	}
+	if seen == 0 {
+		return 0
+	}
+	return float64(shared) / float64(seen)
+}
+
+// indexMagic starts every file written by Write. The rest of the 64-byte header is:
+//
+//	16-23  the number of sketches (little-endian uint64)
+//	24-31  the width of the alignment they were made from
+//	32-35  k (little-endian uint32)
+//	36-39  the size of each sketch
+//
+// The header is followed by one sketch for each record in the alignment, in the same order,
+// each padded to size little-endian uint64s
+const indexMagic = "GOFASTA-SKETCH\x01\x00"
+
+const headerSize = 64
+
+// Index is a file of sketches written by Write, which is read one sketch at a time
+type Index struct {
+	K     int
+	Size  int
+	N     int
+	Width int
+
+	path string
+	f    *os.File
+}
+
+// OpenIndex opens a file written by Write and reads its header. Close must be called once
+// it has been read
+func OpenIndex(path string) (*Index, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, headerSize)
+	_, err = io.ReadFull(f, b)
+	if err != nil || string(b[:len(indexMagic)]) != indexMagic {
+		f.Close()
+		return nil, usage.Errorf("%s wasn't written by gofasta sketch", path)
+	}
+
+	idx := &Index{
+		N:     int(binary.LittleEndian.Uint64(b[16:])),
+		Width: int(binary.LittleEndian.Uint64(b[24:])),
+		K:     int(binary.LittleEndian.Uint32(b[32:])),
+		Size:  int(binary.LittleEndian.Uint32(b[36:])),
+		path:  path,
+		f:     f,
+	}
+
+	if checkParams(idx.K, idx.Size) != nil {
+		f.Close()
+		return nil, errors.New("bad gofasta sketch header in " + path)
+	}
+
+	return idx, nil
+}
+
+// Each calls fn with each of the index's sketches in turn, and the (0-based) index of the
+// record in the alignment that it was made from. The sketch is only valid until fn returns
+func (idx *Index) Each(fn func(i int, s Sketch) error) error {
+
+	_, err := idx.f.Seek(headerSize, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(idx.f)
+	b := make([]byte, 8*idx.Size)
+	s := make(Sketch, idx.Size)
+
+	for i := 0; i < idx.N; i++ {
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return fmt.Errorf("%s is truncated", idx.path)
+		}
+		l := 0
+		for ; l < idx.Size; l++ {
+			h := binary.LittleEndian.Uint64(b[8*l:])
+			if h == empty {
+				break
+			}
+			s[l] = h
+		}
+		err = fn(i, s[:l])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the index's file
+func (idx *Index) Close() error {
+	return idx.f.Close()
+}
+
+// sketchRecords sketches each record that arrives on cIn
+func sketchRecords(k int, size int, cIn chan fastaio.EncodedFastaRecord, cOut chan fastaio.EncodedFastaRecord) {
+	for EFR := range cIn {
+		s := New(EFR.Seq, k, size)
+		b := make([]byte, 8*size)
+		for i := 0; i < size; i++ {
+			h := empty
+			if i < len(s) {
+				h = s[i]
+			}
+			binary.LittleEndian.PutUint64(b[8*i:], h)
+		}
+		// the sequence isn't needed any more, so it carries the sketch to the writer
+		EFR.Seq = b
+		cOut <- EFR
+	}
+}
+
+// writeSketches writes the sketches to w in the same order as the records were in the alignment
+// as they arrive, and sends the number of them to cWriteDone
+func writeSketches(w *bufio.Writer, cOut chan fastaio.EncodedFastaRecord, cErr chan error, cWriteDone chan int) {
+
+	outputMap := make(map[int]fastaio.EncodedFastaRecord)
+	counter := 0
+
+	for EFR := range cOut {
+		outputMap[EFR.Idx] = EFR
+		for {
+			next, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			_, err := w.Write(next.Seq)
+			if err != nil {
+				cErr <- err
+				return
+			}
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	cWriteDone <- counter
+}
+
+// readRecords sends the records in inFile to cEFR. If inFile was written by gofasta encode, they
+// are read by index from record start on, including any that have been removed, so that the
+// sketches stay in step with the records' places in the file
+func readRecords(inFile string, start int, cEFR chan fastaio.EncodedFastaRecord, cErr chan error, cDone chan bool) {
+
+	if !fastaio.IsEncoded(inFile) {
+		fastaio.ReadEncodeAlignment(inFile, cEFR, cErr, cDone)
+		return
+	}
+
+	ma, err := fastaio.OpenMapped(inFile)
+	if err != nil {
+		cErr <- err
+		return
+	}
+	defer ma.Close()
+
+	n, err := ma.Len()
+	if err != nil {
+		cErr <- err
+		return
+	}
+
+	for i := start; i < n; i++ {
+		EFR, err := ma.Record(i)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		EFR.Idx = i - start
+		cEFR <- EFR
+	}
+
+	summary.Read(inFile, n-start)
+
+	cDone <- true
+}
+
+// writeAll sketches the records in inFile (from record start on, if it was written by gofasta
+// encode) and writes their sketches to w, in order. The records are sketched by threads workers.
+// It returns the number of records, and their width (or -1 if there weren't any)
+func writeAll(inFile string, start int, w *bufio.Writer, k int, size int, threads int) (int, int, error) {
+
+	cErr := make(chan error)
+
+	cEFR := make(chan fastaio.EncodedFastaRecord)
+	cEFRDone := make(chan bool)
+
+	cIn := make(chan fastaio.EncodedFastaRecord, threads)
+	cOut := make(chan fastaio.EncodedFastaRecord, threads)
+	cSketchDone := make(chan bool)
+	cWriteDone := make(chan int)
+
+	go readRecords(inFile, start, cEFR, cErr, cEFRDone)
+
+	go writeSketches(w, cOut, cErr, cWriteDone)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+
+	for n := 0; n < threads; n++ {
+		go func() {
+			sketchRecords(k, size, cIn, cOut)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cSketchDone <- true
+	}()
+
+	width := -1
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return 0, 0, err
+		case EFR := <-cEFR:
+			if width == -1 {
+				width = len(EFR.Seq)
+			} else if len(EFR.Seq) != width {
+				return 0, 0, errors.New("different length sequences in input file: is this an alignment?")
+			}
+			cIn <- EFR
+		case <-cEFRDone:
+			close(cIn)
+			n--
+		}
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return 0, 0, err
+		case <-cSketchDone:
+			close(cOut)
+			n--
+		}
+	}
+
+	var counter int
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return 0, 0, err
+		case counter = <-cWriteDone:
+			n--
+		}
+	}
+
+	return counter, width, w.Flush()
+}
+
+// writeHeader writes the header of an index to the start of f
+func writeHeader(f *os.File, n int, width int, k int, size int) error {
+	h := make([]byte, headerSize)
+	copy(h, indexMagic)
+	binary.LittleEndian.PutUint64(h[16:], uint64(n))
+	binary.LittleEndian.PutUint64(h[24:], uint64(width))
+	binary.LittleEndian.PutUint32(h[32:], uint32(k))
+	binary.LittleEndian.PutUint32(h[36:], uint32(size))
+	_, err := f.WriteAt(h, 0)
+	return err
+}
+
+// Write sketches every record in an alignment (in fasta format, or written by gofasta encode)
+// with k-mers of length k, and writes the sketches to outFile as an index that can be read by
+// OpenIndex. The records are sketched by threads workers (or one per CPU if threads == 0)
+func Write(inFile string, outFile string, k int, size int, threads int) error {
+
+	defer profiling.Region("sketch")()
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	err := checkParams(k, size)
+	if err != nil {
+		return err
+	}
+
+	if outFile == "stdout" {
+		return usage.New("the sketches have to be written to a file (--outfile), not stdout")
+	}
+
+	f, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+
+	// the header is filled in at the end, once we know the number of records
+	_, err = w.Write(make([]byte, headerSize))
+	if err != nil {
+		return err
+	}
+
+	counter, width, err := writeAll(inFile, 0, w, k, size, threads)
+	if err != nil {
+		return err
+	}
+
+	summary.Add(summary.Processed, counter)
+
+	if width == -1 {
+		width = 0
+	}
+
+	return writeHeader(f, counter, width, k, size)
+}
+
+// Update sketches the records that have been added to inFile (which was written by gofasta encode)
+// since indexFile was written from it (see fastaio.AppendEncoded), with the same k and size as the
+// rest, and adds them to the end of indexFile. Removed records keep their sketches, and are skipped
+// when the records are read
+func Update(inFile string, indexFile string, threads int) error {
+
+	defer profiling.Region("sketch update")()
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	if !fastaio.IsEncoded(inFile) {
+		return usage.New("sketches can only be updated from an alignment that was written by gofasta encode")
+	}
+
+	idx, err := OpenIndex(indexFile)
+	if err != nil {
+		return err
+	}
+	idx.Close()
+
+	ma, err := fastaio.OpenMapped(inFile)
+	if err != nil {
+		return err
+	}
+	n, err := ma.Len()
+	ma.Close()
+	if err != nil {
+		return err
+	}
+
+	if n < idx.N {
+		return usage.Errorf("%s has %d records, but %s has the sketches of %d: sketch it again from scratch", inFile, n, indexFile, idx.N)
+	}
+
+	f, err := os.OpenFile(indexFile, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	end := int64(headerSize + idx.N*8*idx.Size)
+	err = f.Truncate(end)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(end, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	counter, width, err := writeAll(inFile, idx.N, bufio.NewWriter(f), idx.K, idx.Size, threads)
+	if err != nil {
+		// the header is only rewritten below, so the new sketches are just cut off again
+		f.Truncate(end)
+		return err
+	}
+
+	summary.Add(summary.Processed, counter)
+
+	if idx.N == 0 && width != -1 {
+		idx.Width = width
+	} else if width != -1 && width != idx.Width {
+		f.Truncate(end)
+		return errors.New("the new records aren't the same width as the ones that were sketched before")
+	}
+
+	return writeHeader(f, idx.N+counter, idx.Width, idx.K, idx.Size)
+}
@@ -0,0 +1,252 @@
+package sketch
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+func TestSimilarity(t *testing.T) {
+	EA := encoding.MakeEncodingArray()
+	encode := func(s []byte) []byte {
+		seq := make([]byte, len(s))
+		for i := range s {
+			seq[i] = EA[s[i]]
+		}
+		return seq
+	}
+
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 2000)
+
+	// a copy of ref with n differences, spread out along it
+	mutant := func(n int) []byte {
+		seq := make([]byte, len(ref))
+		copy(seq, ref)
+		for i := 0; i < n; i++ {
+			pos := (i*2 + 1) * len(seq) / (2 * n)
+			if seq[pos] == 'A' {
+				seq[pos] = 'C'
+			} else {
+				seq[pos] = 'A'
+			}
+		}
+		return seq
+	}
+
+	k, size := 15, 500
+	s := New(encode(ref), k, size)
+
+	if len(s) != size {
+		t.Errorf("problem in TestSimilarity: the sketch has %d hashes, expected %d", len(s), size)
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] <= s[i-1] {
+			t.Errorf("problem in TestSimilarity: the sketch isn't sorted")
+			break
+		}
+	}
+
+	if sim := Similarity(s, s, size); sim != 1 {
+		t.Errorf("problem in TestSimilarity: a sketch's similarity to itself is %f", sim)
+	}
+
+	last := 1.0
+	for _, n := range []int{2, 10, 50} {
+		sim := Similarity(s, New(encode(mutant(n)), k, size), size)
+		if sim >= last {
+			t.Errorf("problem in TestSimilarity: %d differences gave similarity %f, which isn't less than %f", n, sim, last)
+		}
+		last = sim
+	}
+
+	if sim := Similarity(s, New(encode(testutil.RandomSeq(r, 2000)), k, size), size); sim > 0.01 {
+		t.Errorf("problem in TestSimilarity: an unrelated sequence has similarity %f", sim)
+	}
+
+	// the same k-mer in a different column isn't shared
+	shifted := append([]byte("A"), ref[:len(ref)-1]...)
+	if sim := Similarity(s, New(encode(shifted), k, size), size); sim > 0.01 {
+		t.Errorf("problem in TestSimilarity: a shifted sequence has similarity %f", sim)
+	}
+
+	// k-mers with anything but A, C, G or T in them aren't used
+	if got := New(encode([]byte("ACGTNACGT-ACGTRACG")), 4, 100); len(got) != 3 {
+		t.Errorf("problem in TestSimilarity: expected 3 k-mers, got %d", len(got))
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 300)
+
+	// the last sequence has fewer k-mers than the size of the sketches
+	seqs := testutil.RandomAlignment(r, ref, 20, 0.01)
+	seqs = append(seqs, testutil.Sequence{ID: "mostlyN", Seq: append([]byte("ACGTACGTACGTACGTACGT"), make([]byte, 280)...)})
+	for i := 20; i < 300; i++ {
+		seqs[20].Seq[i] = 'N'
+	}
+
+	alnFile := filepath.Join(dir, "aln.fasta")
+	f, err := os.Create(alnFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testutil.WriteFasta(f, seqs)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexFile := filepath.Join(dir, "aln.gfs")
+	err = Write(alnFile, indexFile, 11, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := fastaio.ReadEncodeAlignmentToList(alnFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := OpenIndex(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	if idx.K != 11 || idx.Size != 100 || idx.N != len(records) || idx.Width != 300 {
+		t.Errorf("problem in TestIndex: the header is %d %d %d %d", idx.K, idx.Size, idx.N, idx.Width)
+	}
+
+	counter := 0
+	err = idx.Each(func(i int, s Sketch) error {
+		expected := New(records[i].Seq, 11, 100)
+		if !reflect.DeepEqual(s, expected) {
+			t.Errorf("problem in TestIndex: sketch %d is %v, expected %v", i, s, expected)
+		}
+		counter++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter != len(records) {
+		t.Errorf("problem in TestIndex: read %d sketches, expected %d", counter, len(records))
+	}
+
+	err = Write(alnFile, indexFile, 33, 100, 2)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestIndex: expected a usage error for k = 33, got %v", err)
+	}
+
+	_, err = OpenIndex(alnFile)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestIndex: expected a usage error opening a fasta file as an index, got %v", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 300)
+	seqs := testutil.RandomAlignment(r, ref, 30, 0.01)
+
+	write := func(name string, seqs []testutil.Sequence) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		err = testutil.WriteFasta(f, seqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	allFile := write("all.fasta", seqs)
+	firstFile := write("first.fasta", seqs[:20])
+	secondFile := write("second.fasta", seqs[20:])
+
+	encodedFile := filepath.Join(dir, "aln.gfe")
+	err = fastaio.WriteEncoded(firstFile, encodedFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexFile := filepath.Join(dir, "aln.gfs")
+	err = Write(encodedFile, indexFile, 11, 50, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = fastaio.AppendEncoded(secondFile, encodedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Update(encodedFile, indexFile, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedFile := filepath.Join(dir, "expected.gfs")
+	err = Write(allFile, expectedFile, 11, 50, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile(expectedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(expected) {
+		t.Errorf("problem in TestUpdate: the updated index isn't the same as one written from scratch")
+	}
+
+	// updating again does nothing
+	err = Update(encodedFile, indexFile, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(expected) {
+		t.Errorf("problem in TestUpdate: updating an up to date index changed it")
+	}
+
+	// an index of more records than the alignment has was made from another alignment
+	err = fastaio.WriteEncoded(firstFile, encodedFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Update(encodedFile, indexFile, 2)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestUpdate: expected a usage error for an index of a bigger alignment, got %v", err)
+	}
+}
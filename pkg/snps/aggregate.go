@@ -0,0 +1,280 @@
+package snps
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/internal/memlimit"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// AggregateFormats are the formats that Aggregate can write
+var AggregateFormats = []string{"tsv", "vcf"}
+
+// what a query can have at a site, as an index into siteCounts
+const (
+	countGap = 4 // a deletion
+	countN   = 5 // N or another ambiguity code
+)
+
+// siteCounts is the number of queries with A, C, G, T, a gap and N (or another ambiguity
+// code) at a site
+type siteCounts [6]int
+
+// bases are the bases that siteCounts counts, in order
+const bases = "ACGT"
+
+// countIndex is where each encoded base is counted in siteCounts
+func countIndex(DA [256]string) [256]int {
+	var idx [256]int
+	for i := range idx {
+		switch DA[i] {
+		case "A":
+			idx[i] = 0
+		case "C":
+			idx[i] = 1
+		case "G":
+			idx[i] = 2
+		case "T":
+			idx[i] = 3
+		case "-":
+			idx[i] = countGap
+		default:
+			idx[i] = countN
+		}
+	}
+	return idx
+}
+
+// countSites adds up what the queries it is sent have at each site, and sends the totals
+// to cCounts when cFR is closed. Records that md doesn't keep are skipped
+func countSites(length int, md *metadata.Metadata, cFR chan fastaio.EncodedFastaRecord, cCounts chan []siteCounts, cErr chan error) {
+
+	idx := countIndex(alphabet.Nucleotide.Decoding())
+	counts := make([]siteCounts, length)
+	processed := 0
+	filtered := 0
+
+	for FR := range cFR {
+		if !md.Keep(FR.ID) {
+			filtered++
+			continue
+		}
+		if len(FR.Seq) != length {
+			cErr <- errors.New(FR.ID + " isn't the same length as the reference: is it aligned to it?")
+			return
+		}
+		for i, b := range FR.Seq {
+			counts[i][idx[b]]++
+		}
+		processed++
+	}
+
+	summary.Add(summary.Processed, processed)
+	summary.Add(summary.Filtered, filtered)
+
+	cCounts <- counts
+}
+
+// alts are the bases other than ref that are counted at a site, in the order of bases
+func (c siteCounts) alts(ref byte) []int {
+	alts := make([]int, 0)
+	for i := range bases {
+		if bases[i] != ref && c[i] > 0 {
+			alts = append(alts, i)
+		}
+	}
+	return alts
+}
+
+// informative is the number of queries with A, C, G or T at a site
+func (c siteCounts) informative() int {
+	return c[0] + c[1] + c[2] + c[3]
+}
+
+// writeAggregateTSV writes one line per site where any query has a base other than the reference's
+func writeAggregateTSV(w *bufio.Writer, ref []byte, counts []siteCounts, m *mask.Mask) error {
+
+	w.WriteString("##" + version.Provenance() + "\n")
+	w.WriteString("pos\tref\tA\tC\tG\tT\tgap\tN\talt_count\talt_freq\n")
+
+	for i, c := range counts {
+		alts := c.alts(ref[i])
+		if m.Masked(i) || len(alts) == 0 {
+			continue
+		}
+		altCount := 0
+		for _, a := range alts {
+			altCount += c[a]
+		}
+		line := []string{strconv.Itoa(i + 1), string(ref[i])}
+		for _, n := range c {
+			line = append(line, strconv.Itoa(n))
+		}
+		line = append(line, strconv.Itoa(altCount), cliio.FormatRatio(altCount, c.informative(), "."))
+		_, err := w.WriteString(strings.Join(line, "\t") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeAggregateVCF writes a sites-only VCF with one line per site where any query has a base
+// other than the reference's, with all the alternative bases at the site
+func writeAggregateVCF(w *bufio.Writer, refName string, ref []byte, counts []siteCounts, m *mask.Mask) error {
+
+	w.WriteString("##fileformat=VCFv4.2\n")
+	w.WriteString("##source=" + version.Provenance() + "\n")
+	w.WriteString("##contig=<ID=" + refName + ",length=" + strconv.Itoa(len(ref)) + ">\n")
+	w.WriteString("##INFO=<ID=AC,Number=A,Type=Integer,Description=\"Number of queries with each alternative base\">\n")
+	w.WriteString("##INFO=<ID=AN,Number=1,Type=Integer,Description=\"Number of queries with A, C, G or T at the site\">\n")
+	w.WriteString("##INFO=<ID=AF,Number=A,Type=Float,Description=\"Frequency of each alternative base among the queries with A, C, G or T\">\n")
+	w.WriteString("##INFO=<ID=NN,Number=1,Type=Integer,Description=\"Number of queries with N or another ambiguity code at the site\">\n")
+	w.WriteString("##INFO=<ID=GAP,Number=1,Type=Integer,Description=\"Number of queries with a gap at the site\">\n")
+	w.WriteString("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n")
+
+	for i, c := range counts {
+		alts := c.alts(ref[i])
+		if m.Masked(i) || len(alts) == 0 {
+			continue
+		}
+		altBases := make([]string, len(alts))
+		ac := make([]string, len(alts))
+		af := make([]string, len(alts))
+		for j, a := range alts {
+			altBases[j] = bases[a : a+1]
+			ac[j] = strconv.Itoa(c[a])
+			af[j] = cliio.FormatRatio(c[a], c.informative(), ".")
+		}
+		info := "AC=" + strings.Join(ac, ",") + ";AN=" + strconv.Itoa(c.informative()) + ";AF=" + strings.Join(af, ",") +
+			";NN=" + strconv.Itoa(c[countN]) + ";GAP=" + strconv.Itoa(c[countGap])
+		line := []string{refName, strconv.Itoa(i + 1), ".", string(ref[i]), strings.Join(altBases, ","), ".", ".", info}
+		_, err := w.WriteString(strings.Join(line, "\t") + "\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Aggregate summarises the SNPs in a fasta-format alignment across all its queries, instead of
+// listing each query's: for each site where any query has a base other than the reference's, it
+// writes the number of queries with each base, a gap, and N (or another ambiguity code), in tsv
+// or vcf format. The columns in maskFile (if it isn't empty) are left out, and only the queries
+// that md keeps are counted, by threads workers (or one per CPU if threads == 0)
+func Aggregate(referenceFile string, alignmentFile string, outFile string, format string, maskFile string, md *metadata.Metadata, threads int) error {
+
+	defer profiling.Region("snps aggregate")()
+
+	if format != "tsv" && format != "vcf" {
+		return usage.Errorf("unrecognised --format: %s (choose one of: %s)", format, strings.Join(AggregateFormats, ", "))
+	}
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	refs, err := fastaio.ReadEncodeAlignmentToListIn(referenceFile, alphabet.Nucleotide)
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return errors.New("there is no sequence in the reference file " + referenceFile)
+	}
+	DA := alphabet.Nucleotide.Decoding()
+	ref := make([]byte, len(refs[0].Seq))
+	for i, b := range refs[0].Seq {
+		ref[i] = DA[b][0]
+	}
+
+	m, err := mask.Load(maskFile, len(ref))
+	if err != nil {
+		return err
+	}
+
+	cErr := make(chan error)
+	cFR := make(chan fastaio.EncodedFastaRecord, memlimit.Buffer(threads, len(ref)))
+	cFRDone := make(chan bool)
+	cCounts := make(chan []siteCounts, memlimit.Buffer(threads, len(ref)*len(siteCounts{})*8))
+
+	go fastaio.ReadEncodeAlignmentIn(alignmentFile, alphabet.Nucleotide, cFR, cErr, cFRDone)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+	for n := 0; n < threads; n++ {
+		go func() {
+			countSites(len(ref), md, cFR, cCounts, cErr)
+			wg.Done()
+		}()
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cFRDone:
+			close(cFR)
+			n--
+		}
+	}
+
+	counts := make([]siteCounts, len(ref))
+	for n := threads; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case c := <-cCounts:
+			for i := range c {
+				for j := range c[i] {
+					counts[i][j] += c[i][j]
+				}
+			}
+			n--
+		}
+	}
+	wg.Wait()
+
+	f := os.Stdout
+	if outFile != "stdout" {
+		f, err = os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	w := bufio.NewWriter(f)
+
+	if format == "vcf" {
+		err = writeAggregateVCF(w, refs[0].ID, ref, counts, m)
+	} else {
+		err = writeAggregateTSV(w, ref, counts, m)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	md.Report()
+
+	return nil
+}
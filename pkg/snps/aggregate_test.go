@@ -0,0 +1,73 @@
+package snps
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	dir := t.TempDir()
+
+	refFile := filepath.Join(dir, "ref.fasta")
+	queryFile := filepath.Join(dir, "query.fasta")
+	maskFile := filepath.Join(dir, "mask.txt")
+
+	files := map[string]string{
+		refFile: ">ref\nACGTACGT\n",
+		// site 2 has two alternative bases, site 4 is in the mask, and site 7 has only Ns and gaps
+		queryFile: ">q1\nATGAACNT\n>q2\nAGGTACGT\n>q3\nATGAACGT\n>q4\nNNGT-C-T\n",
+		maskFile:  "4\n",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(name, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tsvFile := filepath.Join(dir, "sites.tsv")
+	err := Aggregate(refFile, queryFile, tsvFile, "tsv", maskFile, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(tsvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(string(out), "\n", 2)
+	expected := "pos\tref\tA\tC\tG\tT\tgap\tN\talt_count\talt_freq\n" +
+		"2\tC\t0\t0\t1\t2\t0\t1\t3\t1.0000\n"
+	if !strings.HasPrefix(lines[0], "##gofasta=") || lines[1] != expected {
+		t.Errorf("problem in TestAggregate: got\n%s\nwant\n%s", lines[1], expected)
+	}
+
+	vcfFile := filepath.Join(dir, "sites.vcf")
+	err = Aggregate(refFile, queryFile, vcfFile, "vcf", "", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = ioutil.ReadFile(vcfFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			records = append(records, line)
+		}
+	}
+	expectedRecords := []string{
+		"ref\t2\t.\tC\tG,T\t.\t.\tAC=1,2;AN=3;AF=0.3333,0.6667;NN=1;GAP=0",
+		"ref\t4\t.\tT\tA\t.\t.\tAC=2;AN=4;AF=0.5000;NN=0;GAP=0",
+	}
+	if strings.Join(records, "\n") != strings.Join(expectedRecords, "\n") {
+		t.Errorf("problem in TestAggregate: got\n%s\nwant\n%s", strings.Join(records, "\n"), strings.Join(expectedRecords, "\n"))
+	}
+
+	err = Aggregate(refFile, queryFile, vcfFile, "bed", "", nil, 1)
+	if err == nil {
+		t.Errorf("problem in TestAggregate: expected an error for --format bed")
+	}
+}
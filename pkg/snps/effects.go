@@ -0,0 +1,168 @@
+package snps
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// what a SNP does to the codon that it is in
+const (
+	effectSynonymous    = "synonymous"
+	effectNonsynonymous = "nonsynonymous"
+	effectStopGained    = "stop_gained"
+	effectStopLost      = "stop_lost"
+	effectStartLost     = "start_lost"
+	// the query's codon couldn't be translated (it has ambiguity codes or gaps in it)
+	effectUnknown = "unknown"
+	// the SNP isn't in a CDS
+	effectIntergenic = "intergenic"
+)
+
+// effectsHeader is the header of the effects file
+const effectsHeader = "query\tSNP\tgene\tcodon\tcodon_position\tref_codon\talt_codon\tref_aa\talt_aa\teffect"
+
+// codingSite is a nucleotide of a CDS
+type codingSite struct {
+	cds   int // the index of the CDS in annotation.cdss
+	codon int // 0-based
+	frame int // its 0-based position in the codon
+}
+
+// cds is a CDS's nucleotides, in the order that they make up its codons
+type cds struct {
+	name         string
+	positions    []int  // the 0-based position in the reference of each nucleotide
+	complemented []bool // whether each nucleotide is on the reverse strand
+	t            *alphabet.Translator
+}
+
+// annotation is the CDSs of the reference, and which of them each position is in
+type annotation struct {
+	cdss  []cds
+	sites map[int][]codingSite
+}
+
+// loadAnnotation reads the CDSs in genbankFile, for a reference of length nucleotides
+func loadAnnotation(genbankFile string, length int) (*annotation, error) {
+
+	gb, err := genbank.Load(genbankFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(gb.ORIGIN) > 0 && len(gb.ORIGIN) != length {
+		return nil, fmt.Errorf("the sequence in %s is %d nucleotides long, but the reference is %d", genbankFile, len(gb.ORIGIN), length)
+	}
+
+	an := &annotation{sites: make(map[int][]codingSite)}
+	t := alphabet.NewTranslator(alphabet.CodonX)
+
+	features, names := gb.CDSs()
+	for k, f := range features {
+		positions, complemented, err := f.Positions()
+		if err != nil {
+			return nil, err
+		}
+		coding, err := f.Coding()
+		if err != nil {
+			return nil, err
+		}
+		// an incomplete final codon isn't translated
+		positions = positions[:len(positions)/3*3]
+
+		c := cds{name: names[k], positions: make([]int, len(positions)), complemented: complemented, t: coding.Translator(t)}
+		for j, pos := range positions {
+			if pos > length {
+				return nil, fmt.Errorf("%s (%s) is outside the reference, which is %d nucleotides long", names[k], f.Pos, length)
+			}
+			c.positions[j] = pos - 1
+			an.sites[pos-1] = append(an.sites[pos-1], codingSite{cds: len(an.cdss), codon: j / 3, frame: j % 3})
+		}
+		an.cdss = append(an.cdss, c)
+	}
+
+	return an, nil
+}
+
+// base returns a function that gives the jth nucleotide of c in seq (encoded in the alphabet
+// that DA decodes), for alphabet.Translator.At
+func (c cds) base(seq []byte, DA [256]string) func(int) byte {
+	return func(j int) byte {
+		b := DA[seq[c.positions[j]]][0]
+		if c.complemented[j] {
+			return alphabet.Complement(b)
+		}
+		return b
+	}
+}
+
+// codon is codon i of c in seq
+func (c cds) codon(i int, seq []byte, DA [256]string) string {
+	base := c.base(seq, DA)
+	return string([]byte{base(i * 3), base(i*3 + 1), base(i*3 + 2)})
+}
+
+// classify is the effect of changing refAA to altAA at codon i (0-based) of a CDS
+func classify(i int, refAA byte, altAA byte) string {
+	switch {
+	case refAA == 'X' || altAA == 'X' || altAA == alphabet.Deleted:
+		return effectUnknown
+	case refAA == altAA:
+		return effectSynonymous
+	case altAA == '*':
+		return effectStopGained
+	case refAA == '*':
+		return effectStopLost
+	case i == 0 && refAA == 'M':
+		return effectStartLost
+	}
+	return effectNonsynonymous
+}
+
+// effectsOf is a line of the effects file for each CDS that each of a query's SNPs is in (or one
+// line for a SNP that isn't in a CDS). The amino acids are those of the query's whole codon, so
+// SNPs in the same codon have the same effect
+func (an *annotation) effectsOf(query string, refSeq []byte, seq []byte, a *alphabet.Alphabet, DA [256]string) []string {
+
+	lines := make([]string, 0)
+
+	for i, nuc := range seq {
+		if a.Same(refSeq[i], nuc) {
+			continue
+		}
+		snp := DA[refSeq[i]] + strconv.Itoa(i+1) + DA[nuc]
+
+		sites := an.sites[i]
+		if len(sites) == 0 {
+			lines = append(lines, strings.Join([]string{query, snp, "", "", "", "", "", "", "", effectIntergenic}, "\t"))
+			continue
+		}
+
+		for _, s := range sites {
+			c := an.cdss[s.cds]
+			n := len(c.positions) / 3
+			refAA := c.t.At(s.codon, n, c.base(refSeq, DA))
+			altAA := c.t.At(s.codon, n, c.base(seq, DA))
+			lines = append(lines, strings.Join([]string{
+				query, snp, c.name, strconv.Itoa(s.codon + 1), strconv.Itoa(s.frame + 1),
+				c.codon(s.codon, refSeq, DA), c.codon(s.codon, seq, DA),
+				string(refAA), string(altAA), classify(s.codon, refAA, altAA),
+			}, "\t"))
+		}
+	}
+
+	return lines
+}
+
+// checkEffectsAlphabet checks that the sequences are nucleotides, since the effects of SNPs can
+// only be worked out for them
+func checkEffectsAlphabet(a *alphabet.Alphabet) error {
+	if a != alphabet.Nucleotide {
+		return usage.New("the effects of SNPs can only be found in nucleotide alignments")
+	}
+	return nil
+}
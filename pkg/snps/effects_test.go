@@ -0,0 +1,78 @@
+package snps
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+var testGenbank = `LOCUS       test                      27 bp    DNA     linear   VRL 01-JAN-2021
+FEATURES             Location/Qualifiers
+     CDS             1..18
+                     /gene="g1"
+     CDS             complement(19..24)
+                     /gene="g2"
+ORIGIN
+        1 atgaaacccg ggttttaaac gtacaaa
+//
+`
+
+func TestSNPsWithEffects(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"test.gb":   testGenbank,
+		"ref.fasta": ">ref\nATGAAACCCGGGTTTTAAACGTACAAA\n",
+		// q1 loses g1's start and stop codons, has a synonymous change in its second codon and changes
+		// g2's first codon (on the reverse strand). q2 has an ambiguous first codon, two SNPs that make
+		// g1's fourth codon a stop, and one that isn't in a CDS
+		"query.fasta": ">q1\nGTGAAGCCCGGGTTTAAAACGTATAAA\n>q2\nARGAAACCCTAGTTTTAAACGTACACA\n",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outFile := filepath.Join(dir, "snps.csv")
+	effectsFile := filepath.Join(dir, "effects.tsv")
+
+	err := SNPsWithEffects(filepath.Join(dir, "ref.fasta"), filepath.Join(dir, "query.fasta"), outFile, "", nil, alphabet.Nucleotide, filepath.Join(dir, "test.gb"), effectsFile, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snps, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(snps) != "query,SNPs\nq1,A1G|A6G|T16A|C24T\nq2,T2R|G10T|G11A|A26C\n" {
+		t.Errorf("problem in TestSNPsWithEffects: wrong SNPs:\n%s", snps)
+	}
+
+	effects, err := ioutil.ReadFile(effectsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := effectsHeader + "\n" +
+		"q1\tA1G\tg1\t1\t1\tATG\tGTG\tM\tV\tstart_lost\n" +
+		"q1\tA6G\tg1\t2\t3\tAAA\tAAG\tK\tK\tsynonymous\n" +
+		"q1\tT16A\tg1\t6\t1\tTAA\tAAA\t*\tK\tstop_lost\n" +
+		"q1\tC24T\tg2\t1\t1\tGTA\tATA\tV\tI\tnonsynonymous\n" +
+		"q2\tT2R\tg1\t1\t2\tATG\tARG\tM\tX\tunknown\n" +
+		"q2\tG10T\tg1\t4\t1\tGGG\tTAG\tG\t*\tstop_gained\n" +
+		"q2\tG11A\tg1\t4\t2\tGGG\tTAG\tG\t*\tstop_gained\n" +
+		"q2\tA26C\t\t\t\t\t\t\t\tintergenic\n"
+	if string(effects) != expected {
+		t.Errorf("problem in TestSNPsWithEffects: got\n%s\nwant\n%s", effects, expected)
+	}
+
+	err = SNPsWithEffects(filepath.Join(dir, "ref.fasta"), filepath.Join(dir, "query.fasta"), outFile, "", nil, alphabet.Nucleotide, filepath.Join(dir, "test.gb"), "", 2)
+	if !usage.Is(err) {
+		t.Errorf("problem in TestSNPsWithEffects: expected a usage error for --genbank without --effects, got %v", err)
+	}
+}
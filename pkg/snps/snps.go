@@ -1,14 +1,22 @@
 package snps
 
 import (
+	"bufio"
+	"errors"
 	"os"
 	"sync"
 	"runtime"
 	"strings"
 	"strconv"
 
+	"github.com/cov-ert/gofasta/pkg/alphabet"
 	"github.com/cov-ert/gofasta/pkg/fastaio"
-	"github.com/cov-ert/gofasta/pkg/encoding"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/usage"
+	"github.com/cov-ert/gofasta/internal/pipeline"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/profiling"
 )
 
 // snpLine is a struct for one Fasta record's SNPs
@@ -16,38 +24,74 @@ type snpLine struct {
 	queryname string
 	snps []string
 	idx int
+	skip bool // whether the record was filtered out by --where
+	effects []string // the lines of the effects file, if there is one
 }
 
-// getSNPs gets the SNPs between the reference and each Fasta record at a time
-func getSNPs(refSeq []byte, cFR chan fastaio.EncodedFastaRecord, cSNPs chan snpLine, cErr chan error) {
+// snpsOf is the SNPs (or amino acid substitutions) between an encoded reference and query in
+// alphabet a, e.g. C241T or N501Y
+func snpsOf(refSeq []byte, seq []byte, a *alphabet.Alphabet, DA [256]string) []string {
+	SNPs := make([]string, 0)
+	for i, nuc := range(seq) {
+		if !a.Same(refSeq[i], nuc) {
+			SNPs = append(SNPs, DA[refSeq[i]] + strconv.Itoa(i + 1) + DA[nuc])
+		}
+	}
+	return SNPs
+}
+
+// Find is the SNPs between a reference and a query that is aligned to it (as written by
+// SNPs), for callers that have the sequences in memory rather than in files
+func Find(ref string, query string) ([]string, error) {
+	if len(ref) != len(query) {
+		return nil, errors.New("the query isn't the same length as the reference: is it aligned to it?")
+	}
+	EA := alphabet.Nucleotide.Encoding()
+	refSeq := make([]byte, len(ref))
+	seq := make([]byte, len(query))
+	for i := range(ref) {
+		refSeq[i] = EA[ref[i]]
+		seq[i] = EA[query[i]]
+	}
+	return snpsOf(refSeq, seq, alphabet.Nucleotide, alphabet.Nucleotide.Decoding()), nil
+}
+
+// getSNPs gets the SNPs between the reference and each Fasta record at a time.
+// Columns in m are ignored, and records that md doesn't keep are skipped. If an
+// isn't nil, the effects of the SNPs on the CDSs in it are found too
+func getSNPs(refSeq []byte, a *alphabet.Alphabet, m *mask.Mask, md *metadata.Metadata, an *annotation, cFR chan fastaio.EncodedFastaRecord, cSNPs chan snpLine, cErr chan error) {
 
-	DA := encoding.MakeDecodingArray()
+	DA := a.Decoding()
 
 	for FR := range(cFR) {
+		m.Fill(FR.Seq, a.EncodedUnknown())
 		SL := snpLine{}
 		SL.queryname = FR.ID
 		SL.idx = FR.Idx
-		SNPs := make([]string, 0)
-		for i, nuc := range(FR.Seq) {
-			if (refSeq[i] & nuc) < 16 {
-				snpLine := DA[refSeq[i]] + strconv.Itoa(i + 1) + DA[nuc]
-				SNPs = append(SNPs, snpLine)
-			}
+		if !md.Keep(FR.ID) {
+			SL.skip = true
+			cSNPs<- SL
+			continue
+		}
+		SL.snps = snpsOf(refSeq, FR.Seq, a, DA)
+		if an != nil {
+			SL.effects = an.effectsOf(FR.ID, refSeq, FR.Seq, a, DA)
 		}
-		SL.snps = SNPs
 		cSNPs<- SL
 	}
 
 	return
 }
 
-// writeOutput writes the output to stdout or a file as it arrives.
+// writeOutput writes the output to stdout or a file as it arrives, and the effects of the
+// SNPs to effectsFile, if it isn't empty.
 // It uses a map to write things in the same order as they are in the input file.
-func writeOutput(outFile string, cSNPs chan snpLine, cErr chan error, cWriteDone chan bool) {
+func writeOutput(outFile string, effectsFile string, md *metadata.Metadata, cSNPs chan snpLine, cErr chan error, cWriteDone chan bool) {
 
 	outputMap := make(map[int]snpLine)
 
 	counter := 0
+	skipped := 0
 
 	var f *os.File
 	var err error
@@ -63,7 +107,37 @@ func writeOutput(outFile string, cSNPs chan snpLine, cErr chan error, cWriteDone
 
 	defer f.Close()
 
-	_, err = f.WriteString("query,SNPs\n")
+	var effects *bufio.Writer
+	if len(effectsFile) > 0 {
+		ef, err := os.Create(effectsFile)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		defer ef.Close()
+		effects = bufio.NewWriter(ef)
+		effects.WriteString(effectsHeader + "\n")
+	}
+
+	write := func(SL snpLine) error {
+		if SL.skip {
+			skipped++
+			return nil
+		}
+		_, err := f.WriteString(SL.queryname + "," + strings.Join(SL.snps, "|") + md.CSVFields(SL.queryname) + "\n")
+		if err != nil || effects == nil {
+			return err
+		}
+		for _, line := range SL.effects {
+			_, err = effects.WriteString(line + "\n")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	_, err = f.WriteString("query,SNPs" + md.CSVHeader() + "\n")
 	if err != nil {
 		cErr <- err
 	}
@@ -72,7 +146,7 @@ func writeOutput(outFile string, cSNPs chan snpLine, cErr chan error, cWriteDone
 		outputMap[snpLine.idx] = snpLine
 
 		if SL, ok := outputMap[counter]; ok {
-			_, err := f.WriteString(SL.queryname + "," + strings.Join(SL.snps, "|") + "\n")
+			err := write(SL)
 			if err != nil {
 				cErr <- err
 			}
@@ -89,7 +163,7 @@ func writeOutput(outFile string, cSNPs chan snpLine, cErr chan error, cWriteDone
 			break
 		}
 		SL := outputMap[counter]
-		_, err := f.WriteString(SL.queryname + "," + strings.Join(SL.snps, "|") + "\n")
+		err := write(SL)
 		if err != nil {
 			cErr <- err
 		}
@@ -97,88 +171,132 @@ func writeOutput(outFile string, cSNPs chan snpLine, cErr chan error, cWriteDone
 		counter++
 	}
 
+	if effects != nil {
+		err = effects.Flush()
+		if err != nil {
+			cErr <- err
+			return
+		}
+	}
+
+	summary.Add(summary.Processed, counter - skipped)
+	summary.Add(summary.Filtered, skipped)
+
 	cWriteDone <- true
 }
 
-// SNPs annotates snps in a fasta-format alignment with respect to a reference sequence
-func SNPs(referenceFile string, alignmentFile string, outFile string) error {
+// SNPs annotates snps in a fasta-format alignment with respect to a reference sequence,
+// ignoring the columns in maskFile (if it isn't empty), and keeping and annotating queries
+// according to md, using threads workers (or one per CPU if threads <= 0). The sequences
+// are in alphabet a, so for alphabet.Protein the differences are amino acid substitutions
+func SNPs(referenceFile string, alignmentFile string, outFile string, maskFile string, md *metadata.Metadata, a *alphabet.Alphabet, threads int) error {
+	return SNPsWithEffects(referenceFile, alignmentFile, outFile, maskFile, md, a, "", "", threads)
+}
 
-	cErr := make(chan error)
+// SNPsWithEffects is SNPs, which also writes the effect of each SNP on the CDSs in genbankFile (the
+// reference's annotation) to effectsFile, in tsv format, with one line per query per SNP per CDS
+// that it is in: its gene, codon and position in the codon, the reference's and query's codons and
+// amino acids, and whether it is synonymous, nonsynonymous, stop_gained, stop_lost, start_lost,
+// unknown (the query's codon can't be translated) or intergenic (it isn't in a CDS). The effects
+// are only found if neither file is empty
+func SNPsWithEffects(referenceFile string, alignmentFile string, outFile string, maskFile string, md *metadata.Metadata, a *alphabet.Alphabet, genbankFile string, effectsFile string, threads int) error {
 
-	cRef := make(chan fastaio.EncodedFastaRecord)
-	cRefDone := make(chan bool)
+	defer profiling.Region("snps")()
 
-	cFR := make(chan fastaio.EncodedFastaRecord)
-	cFRDone := make(chan bool)
+	if (len(genbankFile) == 0) != (len(effectsFile) == 0) {
+		return usage.New("the effects of SNPs need both the reference's annotation (--genbank) and a file to write them to (--effects)")
+	}
+	if len(effectsFile) > 0 {
+		if err := checkEffectsAlphabet(a); err != nil {
+			return err
+		}
+	}
 
-	cSNPs := make(chan snpLine, runtime.NumCPU())
-	cSNPsDone := make(chan bool)
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+
+	// the reference's reader is waited for too, so that it isn't left behind if it fails
+	refPl := pipeline.New()
 
-	cWriteDone := make(chan bool)
+	cRef := make(chan fastaio.EncodedFastaRecord)
+	cRefDone := make(chan bool, 1)
 
-	go fastaio.ReadEncodeAlignment(referenceFile, cRef, cErr, cRefDone)
+	refPl.Run(func() {
+		fastaio.ReadEncodeAlignmentIn(referenceFile, a, cRef, refPl.Errs, cRefDone)
+		close(cRef)
+	})
 
 	var refSeq []byte
+	for FR := range cRef {
+		refSeq = FR.Seq
+	}
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
+	err := refPl.Wait()
+	if err != nil {
+		return err
+	}
+
+	if len(refSeq) == 0 {
+		return errors.New("there is no sequence in the reference file " + referenceFile)
+	}
+
+	m, err := mask.Load(maskFile, len(refSeq))
+	if err != nil {
+		return err
+	}
+
+	var an *annotation
+	if len(genbankFile) > 0 {
+		an, err = loadAnnotation(genbankFile, len(refSeq))
+		if err != nil {
 			return err
-		case FR := <-cRef:
-			refSeq = FR.Seq
-		case <-cRefDone:
-			close(cRef)
-			n--
 		}
 	}
 
-	go fastaio.ReadEncodeAlignment(alignmentFile, cFR, cErr, cFRDone)
+	// every stage is waited for before this returns, even if it fails
+	pl := pipeline.New()
+
+	cFR := make(chan fastaio.EncodedFastaRecord)
+	cFRDone := make(chan bool, 1)
+
+	cSNPs := make(chan snpLine, threads)
 
-	go writeOutput(outFile, cSNPs, cErr, cWriteDone)
+	cWriteDone := make(chan bool, 1)
+
+	// the alignment is closed once the reader has returned, whether it got to the end or not,
+	// and the SNPs are drained if the writer gives up early
+	pl.Run(func() {
+		fastaio.ReadEncodeAlignmentIn(alignmentFile, a, cFR, pl.Errs, cFRDone)
+		close(cFR)
+	})
+	pl.Run(func() {
+		writeOutput(outFile, effectsFile, md, cSNPs, pl.Errs, cWriteDone)
+		for range cSNPs {
+		}
+	})
 
 	var wgSNPs sync.WaitGroup
-	wgSNPs.Add(runtime.NumCPU())
+	wgSNPs.Add(threads)
 
-	for n := 0; n < runtime.NumCPU(); n++ {
-		go func() {
-			getSNPs(refSeq, cFR, cSNPs, cErr)
+	for n := 0; n < threads; n++ {
+		pl.Run(func() {
+			getSNPs(refSeq, a, m, md, an, cFR, cSNPs, pl.Errs)
 			wgSNPs.Done()
-		}()
+		})
 	}
 
-	go func() {
+	pl.Run(func() {
 		wgSNPs.Wait()
-		cSNPsDone<- true
-	}()
+		close(cSNPs)
+	})
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cFRDone:
-			close(cFR)
-			n--
-		}
-	}
-
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cSNPsDone:
-			close(cSNPs)
-			n--
-		}
+	err = pl.Wait()
+	if err != nil {
+		return err
 	}
 
-	for n := 1; n > 0; {
-		select {
-		case err := <-cErr:
-			return err
-		case <-cWriteDone:
-			n--
-		}
-	}
+	md.Report()
 
 	return nil
 }
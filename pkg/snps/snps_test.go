@@ -0,0 +1,75 @@
+package snps
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+)
+
+// a failed run leaves nothing running, since SNPs is called in a loop by the C library, and the
+// number of workers defaults to one per CPU
+func TestSNPsFailures(t *testing.T) {
+	dir := t.TempDir()
+
+	refFile := filepath.Join(dir, "ref.fasta")
+	badRefFile := filepath.Join(dir, "badref.fasta")
+	queryFile := filepath.Join(dir, "query.fasta")
+	badQueryFile := filepath.Join(dir, "badquery.fasta")
+	outFile := filepath.Join(dir, "snps.csv")
+
+	files := map[string]string{
+		refFile:      ">ref\nACGTACGT\n",
+		badRefFile:   ">ref\nAC!TAC!T\n",
+		queryFile:    ">q1\nATGTACGA\n>q2\nACGTACGT\n",
+		badQueryFile: ">q1\nATGTACGA\n>q2\nACGTACGTAA\n>q3\nACGTACGT\n",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(name, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := runtime.NumGoroutine()
+
+	tests := []struct {
+		ref   string
+		query string
+	}{
+		{filepath.Join(dir, "missing.fasta"), queryFile},
+		{badRefFile, queryFile},
+		{refFile, filepath.Join(dir, "missing.fasta")},
+		{refFile, badQueryFile},
+	}
+	for _, test := range tests {
+		err := SNPs(test.ref, test.query, outFile, "", nil, alphabet.Nucleotide, -1)
+		if err == nil {
+			t.Errorf("problem in TestSNPsFailures: expected an error for %s and %s", test.ref, test.query)
+		}
+	}
+
+	for i := 0; runtime.NumGoroutine() > before; i++ {
+		if i == 100 {
+			t.Errorf("problem in TestSNPsFailures: %d goroutines are running after the failed runs, %d before", runtime.NumGoroutine(), before)
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err := SNPs(refFile, queryFile, outFile, "", nil, alphabet.Nucleotide, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "query,SNPs\nq1,C2T|T8A\nq2,\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestSNPsFailures: got\n%s\nexpected\n%s", out, expected)
+	}
+}
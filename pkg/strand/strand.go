@@ -0,0 +1,344 @@
+/*
+Package strand finds the sequences (e.g. consensus sequences) that are the reverse complement
+of the reference, by comparing their minimizers to the reference's on both strands, and flips
+them, so that they can be compared column by column with the rest.
+*/
+package strand
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/cov-ert/gofasta/internal/cliio"
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+)
+
+// the names of the counts of flipped sequences and sequences whose strand couldn't be found
+// in the run summary
+const (
+	flipped = "reverse_complemented"
+	unknown = "unknown_strand"
+)
+
+const (
+	k = 15 // the length of the k-mers that minimizers are chosen from
+	w = 10 // the number of consecutive k-mers that each minimizer is the smallest of
+)
+
+// baseCodes are the 2-bit codes of A, C, G and T (in either case), and -1 for anything else
+var baseCodes [256]int8
+
+func init() {
+	for i := range baseCodes {
+		baseCodes[i] = -1
+	}
+	for i, b := range []byte("ACGT") {
+		baseCodes[b] = int8(i)
+		baseCodes[b+'a'-'A'] = int8(i)
+	}
+}
+
+// hash mixes the bits of a k-mer's code, so that the minimizers aren't all poly-A
+func hash(code uint64) uint64 {
+	code *= 0x9e3779b97f4a7c15
+	return code ^ code>>29
+}
+
+// minimizers calls fn with the hash of each (w,k)-minimizer of seq. Gaps are skipped, and
+// k-mers with anything other than A, C, G and T in them aren't used
+func minimizers(seq []byte, fn func(h uint64)) {
+	var code uint64
+	mask := uint64(1)<<(2*k) - 1
+	valid := 0
+
+	// the hashes of the k-mers since the last ambiguous base
+	hashes := make([]uint64, 0, len(seq))
+	last := -1
+
+	for _, b := range seq {
+		if b == '-' {
+			continue
+		}
+		c := baseCodes[b]
+		if c < 0 {
+			valid = 0
+			hashes = hashes[:0]
+			last = -1
+			continue
+		}
+		code = (code<<2 | uint64(c)) & mask
+		valid++
+		if valid < k {
+			continue
+		}
+		hashes = append(hashes, hash(code))
+		if len(hashes) < w {
+			continue
+		}
+		min := len(hashes) - w
+		for i := min + 1; i < len(hashes); i++ {
+			if hashes[i] < hashes[min] {
+				min = i
+			}
+		}
+		if min != last {
+			fn(hashes[min])
+			last = min
+		}
+	}
+}
+
+// Detector finds which strand of a reference sequences are from
+type Detector struct {
+	ref map[uint64]bool
+}
+
+// NewDetector returns a Detector for a reference sequence
+func NewDetector(ref []byte) *Detector {
+	d := &Detector{ref: make(map[uint64]bool)}
+	minimizers(ref, func(h uint64) {
+		d.ref[h] = true
+	})
+	return d
+}
+
+// hits is the number of minimizers of seq that are minimizers of the reference too
+func (d *Detector) hits(seq []byte) int {
+	n := 0
+	minimizers(seq, func(h uint64) {
+		if d.ref[h] {
+			n++
+		}
+	})
+	return n
+}
+
+// Hits is the number of minimizers of seq, and of its reverse complement, that are also
+// minimizers of the reference
+func (d *Detector) Hits(seq []byte) (forward int, reverse int) {
+	return d.hits(seq), d.hits(alphabet.ReverseComplement(seq))
+}
+
+// Strand is + if seq is from the same strand as the reference, - if it is from the other
+// strand, and ? if it doesn't share any minimizers with the reference on either strand
+func (d *Detector) Strand(seq []byte) byte {
+	return strandOf(d.Hits(seq))
+}
+
+// strandOf is the strand of a sequence with forward and reverse minimizer hits
+func strandOf(forward int, reverse int) byte {
+	switch {
+	case reverse > forward:
+		return '-'
+	case forward > 0:
+		return '+'
+	default:
+		return '?'
+	}
+}
+
+// oriented is a query after its strand has been found
+type oriented struct {
+	FR      fastaio.FastaRecord
+	strand  byte
+	forward int
+	reverse int
+}
+
+// orientQueries finds the strand of each query that arrives on cIn, and flips it if it is -
+func orientQueries(d *Detector, cIn chan fastaio.FastaRecord, cOut chan oriented) {
+	for FR := range cIn {
+		o := oriented{FR: FR}
+		seq := []byte(FR.Seq)
+		o.forward, o.reverse = d.Hits(seq)
+		o.strand = strandOf(o.forward, o.reverse)
+		if o.strand == '-' {
+			o.FR.Seq = string(alphabet.ReverseComplement(seq))
+		}
+		cOut <- o
+	}
+}
+
+// writeOutput writes the queries, and their strands to reportFile if it isn't empty, in the
+// same order as they were in the input as they arrive
+func writeOutput(outFile string, reportFile string, cOut chan oriented, cErr chan error, cWriteDone chan bool) {
+
+	var f *os.File
+	var err error
+
+	if outFile != "stdout" {
+		f, err = os.Create(outFile)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		defer f.Close()
+	} else {
+		f = os.Stdout
+	}
+	w := bufio.NewWriter(f)
+
+	var rw *bufio.Writer
+	if len(reportFile) > 0 {
+		rf, err := os.Create(reportFile)
+		if err != nil {
+			cErr <- err
+			return
+		}
+		defer rf.Close()
+		rw = bufio.NewWriter(rf)
+		rw.WriteString("query\tstrand\tforward_hits\treverse_hits\n")
+	}
+
+	nFlipped := 0
+	nUnknown := 0
+
+	write := func(o oriented) error {
+		switch o.strand {
+		case '-':
+			nFlipped++
+		case '?':
+			nUnknown++
+		}
+		_, err := w.WriteString(">" + o.FR.Description + "\n" + o.FR.Seq + "\n")
+		if err != nil || rw == nil {
+			return err
+		}
+		_, err = rw.WriteString(o.FR.ID + "\t" + string(o.strand) + "\t" + strconv.Itoa(o.forward) + "\t" + strconv.Itoa(o.reverse) + "\n")
+		return err
+	}
+
+	outputMap := make(map[int]oriented)
+	counter := 0
+
+	for o := range cOut {
+		outputMap[o.FR.Idx] = o
+		for {
+			next, ok := outputMap[counter]
+			if !ok {
+				break
+			}
+			err = write(next)
+			if err != nil {
+				cErr <- err
+				return
+			}
+			delete(outputMap, counter)
+			counter++
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		cErr <- err
+		return
+	}
+	if rw != nil {
+		err = rw.Flush()
+		if err != nil {
+			cErr <- err
+			return
+		}
+	}
+
+	summary.Add(flipped, nFlipped)
+	summary.Add(unknown, nUnknown)
+
+	cWriteDone <- true
+}
+
+// Orient writes the sequences in queryFile to outFile, with the ones that are from the other
+// strand to the reference (see Detector.Strand) reverse complemented. If reportFile isn't empty,
+// the strand of each query, and the minimizer hits that it was decided by, are written to it
+// as a tab-separated file. The queries are oriented by threads workers (or one per CPU if
+// threads == 0)
+func Orient(referenceFile string, queryFile string, outFile string, reportFile string, threads int) error {
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+
+	ref, err := cliio.ReadReference(referenceFile)
+	if err != nil {
+		return err
+	}
+
+	d := NewDetector([]byte(ref.Seq))
+
+	cErr := make(chan error)
+
+	cFR := make(chan fastaio.FastaRecord)
+	cFRDone := make(chan bool)
+
+	cIn := make(chan fastaio.FastaRecord, threads)
+	cOut := make(chan oriented, threads)
+	cOrientDone := make(chan bool)
+	cWriteDone := make(chan bool)
+
+	go fastaio.ReadAlignment(queryFile, cFR, cErr, cFRDone)
+
+	go writeOutput(outFile, reportFile, cOut, cErr, cWriteDone)
+
+	var wg sync.WaitGroup
+	wg.Add(threads)
+
+	for n := 0; n < threads; n++ {
+		go func() {
+			orientQueries(d, cIn, cOut)
+			wg.Done()
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cOrientDone <- true
+	}()
+
+	counter := 0
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			// an empty input file gives us one empty record
+			if len(FR.ID) == 0 && len(FR.Seq) == 0 {
+				continue
+			}
+			FR.Idx = counter
+			counter++
+			cIn <- FR
+		case <-cFRDone:
+			close(cIn)
+			n--
+		}
+	}
+
+	summary.Add(summary.Processed, counter)
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cOrientDone:
+			close(cOut)
+			n--
+		}
+	}
+
+	for n := 1; n > 0; {
+		select {
+		case err := <-cErr:
+			return err
+		case <-cWriteDone:
+			n--
+		}
+	}
+
+	return nil
+}
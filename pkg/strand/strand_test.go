@@ -0,0 +1,68 @@
+package strand
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cov-ert/gofasta/internal/testutil"
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+)
+
+func TestOrient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := rand.New(rand.NewSource(1))
+	ref := testutil.RandomSeq(r, 2000)
+
+	// q2 is flipped, with a few Ns in it, and q3 isn't like the reference at all
+	q2 := alphabet.ReverseComplement(ref)
+	copy(q2[100:], "NNNNN")
+	seqs := []string{string(ref), string(q2), string(testutil.RandomSeq(r, 2000))}
+
+	refFile := filepath.Join(dir, "ref.fasta")
+	err = ioutil.WriteFile(refFile, []byte(">ref\n"+string(ref)+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryFile := filepath.Join(dir, "queries.fasta")
+	err = ioutil.WriteFile(queryFile, []byte(">q1\n"+seqs[0]+"\n>q2 flipped\n"+seqs[1]+"\n>q3\n"+seqs[2]+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outFile := filepath.Join(dir, "out.fasta")
+	reportFile := filepath.Join(dir, "report.tsv")
+
+	err = Orient(refFile, queryFile, outFile, reportFile, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ">q1\n" + seqs[0] + "\n>q2 flipped\n" + string(alphabet.ReverseComplement(q2)) + "\n>q3\n" + seqs[2] + "\n"
+	if string(out) != expected {
+		t.Errorf("problem in TestOrient: the sequences weren't oriented")
+	}
+
+	report, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strands := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(report)), "\n")[1:] {
+		strands = append(strands, strings.Split(line, "\t")[1])
+	}
+	if strings.Join(strands, "") != "+-?" {
+		t.Errorf("problem in TestOrient: got report\n%s", report)
+	}
+}
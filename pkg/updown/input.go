@@ -7,10 +7,11 @@ import (
 	"errors"
 	"strings"
 	"strconv"
-	"runtime"
 	"encoding/csv"
 
 	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/internal/summary"
 )
 
 func getAmbArr(s string) ([]int, error) {
@@ -52,6 +53,49 @@ func getAmbArr(s string) ([]int, error) {
 	return A, nil
 }
 
+// maskLine removes the SNPs and ambiguities in masked columns from a line that was read
+// from the output of gofasta updown list, in the same way as getLines skips them
+func maskLine(udL *updownLine, m *mask.Mask) {
+
+	if m.Len() == 0 {
+		return
+	}
+
+	snps := make([]string, 0, len(udL.snps))
+	snpPos := make([]int, 0, len(udL.snpsPos))
+	for i, pos := range(udL.snpsPos) {
+		if !m.Masked(pos - 1) {
+			snps = append(snps, udL.snps[i])
+			snpPos = append(snpPos, pos)
+		}
+	}
+
+	ambs := make([]int, 0, len(udL.ambs))
+	for i := 0; i < len(udL.ambs); i += 2 {
+		start := 0
+		for pos := udL.ambs[i]; pos <= udL.ambs[i+1]; pos++ {
+			if m.Masked(pos - 1) {
+				udL.ambCount--
+				if start > 0 {
+					ambs = append(ambs, start, pos-1)
+					start = 0
+				}
+				continue
+			}
+			if start == 0 {
+				start = pos
+			}
+		}
+		if start > 0 {
+			ambs = append(ambs, start, udL.ambs[i+1])
+		}
+	}
+
+	udL.snps = snps
+	udL.snpsPos = snpPos
+	udL.ambs = ambs
+}
+
 func headerEqual(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -64,7 +108,7 @@ func headerEqual(a, b []string) bool {
 	return true
 }
 
-func readCSVToChan(inFile string, cudL chan updownLine, cErr chan error, cReadDone chan bool) {
+func readCSVToChan(inFile string, m *mask.Mask, cudL chan updownLine, cErr chan error, cReadDone chan bool) {
 	f, err := os.Open(inFile)
 	if err != nil {
 		cErr<- err
@@ -77,6 +121,7 @@ func readCSVToChan(inFile string, cudL chan updownLine, cErr chan error, cReadDo
 	header := true
 	r := csv.NewReader(f)
 
+	counter := 0
 	for {
 		record, err := r.Read()
 		if err == io.EOF {
@@ -117,13 +162,17 @@ func readCSVToChan(inFile string, cudL chan updownLine, cErr chan error, cReadDo
 		}
 
 		udL := updownLine{id: record[0], snps: snps, snpsPos: snpPos, ambs: a, ambCount: amb_count}
+		maskLine(&udL, m)
 		cudL<- udL
+		counter++
 	}
 
+	summary.Read(inFile, counter)
+
 	cReadDone<- true
 }
 
-func readCSVToList(inFile string) ([]updownLine, error) {
+func readCSVToList(inFile string, m *mask.Mask) ([]updownLine, error) {
 	f, err := os.Open(inFile)
 	if err != nil {
 		return make([]updownLine, 0), err
@@ -178,14 +227,17 @@ func readCSVToList(inFile string) ([]updownLine, error) {
 			return make([]updownLine, 0), err
 		}
 		udL := updownLine{id: record[0], idx: counter, snps: snps, snpsPos: snpPos, ambs: a, ambCount: amb_count}
+		maskLine(&udL, m)
 		LudL = append(LudL, udL)
 		counter++
 	}
 
+	summary.Read(inFile, counter)
+
 	return LudL, nil
 }
 
-func fastaToUDLslice(infile string, refSeq []byte) ([]updownLine, error) {
+func fastaToUDLslice(infile string, refSeq []byte, m *mask.Mask, threads int) ([]updownLine, error) {
 
 	var udla []updownLine
 
@@ -193,7 +245,7 @@ func fastaToUDLslice(infile string, refSeq []byte) ([]updownLine, error) {
 
 	cFR := make(chan fastaio.EncodedFastaRecord)
 	cFRDone := make(chan bool)
-	cudLs := make(chan updownLine, runtime.NumCPU())
+	cudLs := make(chan updownLine, threads)
 	cudLsDone := make(chan bool)
 	cArrayDone := make(chan bool)
 
@@ -203,7 +255,7 @@ func fastaToUDLslice(infile string, refSeq []byte) ([]updownLine, error) {
 	wgudLs.Add(1)
 
 	go func() {
-		getLines(refSeq, cFR, cudLs, cInternalErr)
+		getLines(refSeq, m, cFR, cudLs, cInternalErr)
 		wgudLs.Done()
 	}()
 
@@ -284,7 +336,7 @@ func reorderRecords(cIn, cOut chan updownLine, cReorderDone chan bool) {
 	cReorderDone<- true
 }
 
-func readFastaToChan(target string, refSeq []byte, cudL chan updownLine, cErr chan error, cReadDone chan bool) {
+func readFastaToChan(target string, refSeq []byte, m *mask.Mask, threads int, cudL chan updownLine, cErr chan error, cReadDone chan bool) {
 	cInternalErr := make(chan error)
 
 	cFR := make(chan fastaio.EncodedFastaRecord)
@@ -298,11 +350,11 @@ func readFastaToChan(target string, refSeq []byte, cudL chan updownLine, cErr ch
 	go fastaio.ReadEncodeAlignment(target, cFR, cInternalErr, cFRDone)
 
 	var wgudLs sync.WaitGroup
-	wgudLs.Add(runtime.NumCPU())
+	wgudLs.Add(threads)
 
-	for n := 0; n < runtime.NumCPU(); n++ {
+	for n := 0; n < threads; n++ {
 		go func() {
-			getLines(refSeq, cFR, cReOrder, cInternalErr)
+			getLines(refSeq, m, cFR, cReOrder, cInternalErr)
 			wgudLs.Done()
 		}()
 	}
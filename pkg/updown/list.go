@@ -9,8 +9,11 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/cov-ert/gofasta/internal/summary"
 	"github.com/cov-ert/gofasta/pkg/encoding"
 	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/profiling"
 )
 
 type updownLine struct {
@@ -23,8 +26,10 @@ type updownLine struct {
 	ambCount int   // total number of sites that are not ATGC
 }
 
-// getLine gets the mutation + ambiguity lists between the reference and each Fasta record at a time
-func getLines(refSeq []byte, cFR chan fastaio.EncodedFastaRecord, cUDs chan updownLine, cErr chan error) {
+// getLine gets the mutation + ambiguity lists between the reference and each Fasta record at a time.
+// Columns in m are skipped, so they are neither SNPs nor ambiguities (and a masked column ends a tract
+// of ambiguities)
+func getLines(refSeq []byte, m *mask.Mask, cFR chan fastaio.EncodedFastaRecord, cUDs chan updownLine, cErr chan error) {
 
 	DA := encoding.MakeDecodingArray()
 
@@ -58,6 +63,15 @@ func getLines(refSeq []byte, cFR chan fastaio.EncodedFastaRecord, cUDs chan updo
 
 		for i, que_nuc := range FR.Seq {
 
+			if m.Masked(i) {
+				if cont {
+					ambs = append(ambs, amb_start+1)
+					ambs = append(ambs, amb_stop+1)
+					cont = false
+				}
+				continue
+			}
+
 			// if query nucleotide is a known base
 			if que_nuc&8 == 8 {
 				// if it is different from the reference:
@@ -177,18 +191,27 @@ func writeOutput(outFile string, cudLs chan updownLine, cErr chan error, cWriteD
 		counter++
 	}
 
+	summary.Add(summary.Processed, counter)
+
 	cWriteDone <- true
 }
 
-// List gets a list of ATGC SNPs and ambiguous sites for each query
-func List(referenceFile string, alignmentFile string, outFile string) error {
+// List gets a list of ATGC SNPs and ambiguous sites for each query, ignoring the columns
+// in maskFile (if it isn't empty), using threads workers (or one per CPU if threads == 0)
+func List(referenceFile string, alignmentFile string, outFile string, maskFile string, threads int) error {
+
+	defer profiling.Region("updown list")()
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
 
 	cErr := make(chan error)
 
 	cFR := make(chan fastaio.EncodedFastaRecord)
 	cFRDone := make(chan bool)
 
-	cudLs := make(chan updownLine, runtime.NumCPU())
+	cudLs := make(chan updownLine, threads)
 	cudLsDone := make(chan bool)
 
 	cWriteDone := make(chan bool)
@@ -200,6 +223,9 @@ func List(referenceFile string, alignmentFile string, outFile string) error {
 	if len(temp) > 1 {
 		return errors.New("More than one record in --reference")
 	}
+	if len(temp) == 0 {
+		return errors.New("No record in --reference")
+	}
 
 	refSeq := temp[0].Seq
 
@@ -212,16 +238,21 @@ func List(referenceFile string, alignmentFile string, outFile string) error {
 		}
 	}
 
+	m, err := mask.Load(maskFile, len(refSeq))
+	if err != nil {
+		return err
+	}
+
 	go fastaio.ReadEncodeAlignment(alignmentFile, cFR, cErr, cFRDone)
 
 	go writeOutput(outFile, cudLs, cErr, cWriteDone)
 
 	var wgudLs sync.WaitGroup
-	wgudLs.Add(runtime.NumCPU())
+	wgudLs.Add(threads)
 
-	for n := 0; n < runtime.NumCPU(); n++ {
+	for n := 0; n < threads; n++ {
 		go func() {
-			getLines(refSeq, cFR, cudLs, cErr)
+			getLines(refSeq, m, cFR, cudLs, cErr)
 			wgudLs.Done()
 		}()
 	}
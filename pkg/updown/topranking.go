@@ -6,11 +6,16 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/cov-ert/gofasta/internal/summary"
 	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/profiling"
+	"github.com/cov-ert/gofasta/pkg/usage"
 )
 
 /*
@@ -690,6 +695,8 @@ func writeUpDownCatchment(fileOut string, results []updownCatchmentStruct, sizeA
 	var size [4]int
 	var sizeObserved [4]int
 
+	summary.Add(summary.Processed, len(results))
+
 	_, err = f.WriteString("query,closestsame,closestup,closestdown,closestside\n")
 	if err != nil {
 		return err
@@ -785,8 +792,10 @@ func checkArgs(query string, target string, reference string,
 		qtype = "fasta"
 	case ".fa":
 		qtype = "fasta"
+	case ".sto", ".stk", ".aln":
+		qtype = "fasta"
 	default:
-		return [4]int{}, [4]int{}, qtype, ttype, errors.New("couldn't tell if --query was a .csv or a .fasta file")
+		return [4]int{}, [4]int{}, qtype, ttype, usage.New("couldn't tell if --query was a .csv or a .fasta file")
 	}
 
 	switch filepath.Ext(target) {
@@ -796,16 +805,18 @@ func checkArgs(query string, target string, reference string,
 		ttype = "fasta"
 	case ".fa":
 		ttype = "fasta"
+	case ".sto", ".stk", ".aln":
+		ttype = "fasta"
 	default:
-		return [4]int{}, [4]int{}, qtype, ttype, errors.New("couldn't tell if --target was a .csv or a .fasta file")
+		return [4]int{}, [4]int{}, qtype, ttype, usage.New("couldn't tell if --target was a .csv or a .fasta file")
 	}
 
 	if (qtype == "fasta" || ttype == "fasta") && len(reference) == 0 {
-		return [4]int{}, [4]int{}, qtype, ttype, errors.New("if your either of your input files are fastas, you must provide a --reference")
+		return [4]int{}, [4]int{}, qtype, ttype, usage.New("if your either of your input files are fastas, you must provide a --reference")
 	}
 
 	if sizetotal == 0 && allZero([]int{sizeup, sizedown, sizeside, sizesame}) && allZero([]int{distup, distdown, distside, distall}) {
-		return [4]int{}, [4]int{}, qtype, ttype, errors.New(`
+		return [4]int{}, [4]int{}, qtype, ttype, usage.New(`
 Please provide values to either --size-total,
 or all or some of --size-up, --size-down, --size-side and --size-same,
 
@@ -887,10 +898,16 @@ func sum4(a [4]int) int {
 	return t
 }
 
-func TopRanking(query string, target string, outfile string, reference string, ignoreFile string,
+func TopRanking(query string, target string, outfile string, reference string, ignoreFile string, maskFile string,
 	sizetotal int, sizeup int, sizedown int, sizeside int, sizesame int,
 	distall int, distup int, distdown int, distside int,
-	threshpair float32, threshtarg int, nofill bool, pushdist bool) error {
+	threshpair float32, threshtarg int, nofill bool, pushdist bool, threads int) error {
+
+	defer profiling.Region("updown topranking")()
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
 
 	sizeArray, distArray, q_in_type, t_in_type, err := checkArgs(query, target, reference, sizetotal, sizeup, sizedown, sizeside, sizesame, distall, distup, distdown, distside)
 	if err != nil {
@@ -913,20 +930,33 @@ func TopRanking(query string, target string, outfile string, reference string, i
 		if len(temp) > 1 {
 			return errors.New("More than one record in --reference")
 		}
+		if len(temp) == 0 {
+			return errors.New("No record in --reference")
+		}
 		refSeq = temp[0].Seq
 	}
 
+	// without a reference (if the inputs are both csvs), the mask can't be checked against the alignment length
+	maskLength := -1
+	if len(refSeq) > 0 {
+		maskLength = len(refSeq)
+	}
+	m, err := mask.Load(maskFile, maskLength)
+	if err != nil {
+		return err
+	}
+
 	cErr := make(chan error)
 	var queries []updownLine
 
 	switch q_in_type {
 	case "csv":
-		queries, err = readCSVToList(query)
+		queries, err = readCSVToList(query, m)
 		if err != nil {
 			return err
 		}
 	case "fasta":
-		queries, err = fastaToUDLslice(query, refSeq)
+		queries, err = fastaToUDLslice(query, refSeq, m, threads)
 		if err != nil {
 			return err
 		}
@@ -962,9 +992,9 @@ func TopRanking(query string, target string, outfile string, reference string, i
 
 	switch t_in_type {
 	case "csv":
-		go readCSVToChan(target, cudL, cErr, cReadDone)
+		go readCSVToChan(target, m, cudL, cErr, cReadDone)
 	case "fasta":
-		go readFastaToChan(target, refSeq, cudL, cErr, cReadDone)
+		go readFastaToChan(target, refSeq, m, threads, cudL, cErr, cReadDone)
 	}
 
 	go splitInput(queries, ignore,
@@ -0,0 +1,34 @@
+// Package usage marks errors that are caused by how a function was called (e.g. an
+// option value that isn't allowed), as opposed to by the data it was given, so that
+// the command line can exit with a different code for each
+package usage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error is an error in how something was called
+type Error struct {
+	msg string
+}
+
+func (e Error) Error() string {
+	return e.msg
+}
+
+// Errorf formats its arguments into an Error
+func Errorf(format string, a ...interface{}) error {
+	return Error{msg: fmt.Sprintf(format, a...)}
+}
+
+// New returns an Error with the given message
+func New(msg string) error {
+	return Error{msg: msg}
+}
+
+// Is reports whether err (or anything it wraps) is an Error
+func Is(err error) bool {
+	var e Error
+	return errors.As(err, &e)
+}
@@ -0,0 +1,31 @@
+package usage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIs(t *testing.T) {
+	err := Errorf("unrecognised --format: %s", "xml")
+	if err.Error() != "unrecognised --format: xml" {
+		t.Errorf("problem in TestIs: got %s", err.Error())
+	}
+
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{err, true},
+		{New("bad option"), true},
+		{fmt.Errorf("toMultiAlign: %w", err), true},
+		{errors.New("badly formatted fasta file"), false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if Is(test.err) != test.expected {
+			t.Errorf("problem in TestIs: Is(%v) should be %v", test.err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Package validate checks a command's inputs (--validate-only) without running it: that each of them
+can be parsed (the SAM header, reference, alignments, Genbank annotation, mask and metadata), and that
+they fit together (the reference is the one the SAM file was aligned against, the alignments are as
+wide as the reference and each other, the Genbank record is as long as the reference, the mask is
+inside the alignment, and how many of the sequences aren't in the metadata). Every problem is reported, rather than
+just the first, so that a big batch job can be checked cheaply before it is run.
+*/
+package validate
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/mask"
+	"github.com/cov-ert/gofasta/pkg/metadata"
+	"github.com/cov-ert/gofasta/pkg/sam"
+)
+
+// Report is the result of checking some inputs. Each input that is checked is noted (with what was
+// found in it) to w, and each problem is both written to w and kept. Width is the length of the
+// reference, or the width of the first alignment that was checked, which everything else has to fit,
+// or 0 if it isn't known yet
+type Report struct {
+	w        io.Writer
+	Problems []string
+	Width    int
+	widthOf  string // the input that Width came from
+}
+
+// New returns an empty Report that writes to w
+func New(w io.Writer) *Report {
+	return &Report{w: w}
+}
+
+func (r *Report) problem(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	r.Problems = append(r.Problems, msg)
+	fmt.Fprintln(r.w, "problem: "+msg)
+}
+
+func (r *Report) note(format string, a ...interface{}) {
+	fmt.Fprintf(r.w, format+"\n", a...)
+}
+
+// fits checks that an input that is width long fits the inputs that have been checked so far
+func (r *Report) fits(name string, width int) {
+	switch {
+	case r.Width == 0:
+		r.Width = width
+		r.widthOf = name
+	case width != r.Width:
+		r.problem("%s is %d long, but %s is %d long", name, width, r.widthOf, r.Width)
+	}
+}
+
+// SAM checks the header of a SAM file (stdin if infile is empty), and that the reference, if
+// referenceFile isn't empty, is the one that it was aligned against (see sam.CheckHeader)
+func (r *Report) SAM(infile string, referenceFile string) {
+	name := infile
+	if len(name) == 0 {
+		name = "stdin"
+	}
+	refLen, err := sam.CheckHeader(infile, referenceFile)
+	if err != nil {
+		r.problem("%s: %v", name, err)
+		return
+	}
+	if refLen == 0 {
+		r.note("%s: no records", name)
+		return
+	}
+	r.note("%s: aligned to a %d bp reference", name, refLen)
+	r.fits("the reference in the header of "+name, refLen)
+}
+
+// readFasta reads every record of a fasta file, passing each one to fn
+func readFasta(infile string, fn func(fastaio.FastaRecord)) error {
+
+	cErr := make(chan error)
+	cFR := make(chan fastaio.FastaRecord)
+	cDone := make(chan bool)
+
+	go fastaio.ReadAlignment(infile, cFR, cErr, cDone)
+
+	for {
+		select {
+		case err := <-cErr:
+			return err
+		case FR := <-cFR:
+			fn(FR)
+		case <-cDone:
+			return nil
+		}
+	}
+}
+
+// Reference checks that a reference fasta file has one record, which everything else has to fit
+func (r *Report) Reference(referenceFile string) {
+	n := 0
+	width := 0
+	err := readFasta(referenceFile, func(FR fastaio.FastaRecord) {
+		n++
+		width = len(FR.Seq)
+	})
+	switch {
+	case err != nil:
+		r.problem("%s: %v", referenceFile, err)
+	case n != 1:
+		r.problem("%s: there should be exactly one record in the reference, but there are %d", referenceFile, n)
+	default:
+		r.note("%s: a %d bp reference", referenceFile, width)
+		r.fits("the reference "+referenceFile, width)
+	}
+}
+
+// Fasta checks that a fasta file can be read, and that its sequences have unique names that are
+// all in md (if it isn't nil). If aligned, the sequences have to be the same width as each other
+// and everything else
+func (r *Report) Fasta(infile string, aligned bool, md *metadata.Metadata) {
+
+	n := 0
+	width := -1
+	widths := 0
+	names := make(map[string]bool)
+	duplicates := 0
+
+	err := readFasta(infile, func(FR fastaio.FastaRecord) {
+		n++
+		if names[FR.ID] {
+			duplicates++
+		}
+		names[FR.ID] = true
+		md.Keep(FR.ID)
+		if len(FR.Seq) != width {
+			widths++
+			width = len(FR.Seq)
+		}
+	})
+	if err != nil {
+		r.problem("%s: %v", infile, err)
+		return
+	}
+
+	if duplicates > 0 {
+		r.problem("%s: %d sequence names are used more than once", infile, duplicates)
+	}
+
+	if !aligned || n == 0 {
+		r.note("%s: %d sequences", infile, n)
+		return
+	}
+	if widths > 1 {
+		r.problem("%s: the sequences aren't all the same length, so it isn't an alignment", infile)
+		return
+	}
+	r.note("%s: %d sequences, %d wide", infile, n, width)
+	r.fits("the alignment "+infile, width)
+}
+
+// Genbank checks that a Genbank record (a file, or an accession to fetch) can be read, and that
+// it is as long as the reference. Any problems that genbank.Load warns about are written to stderr
+func (r *Report) Genbank(genbankFile string) {
+	gb, err := genbank.Load(genbankFile)
+	if err != nil {
+		r.problem("%s: %v", genbankFile, err)
+		return
+	}
+	r.note("%s: a %d bp record", genbankFile, len(gb.ORIGIN))
+	if len(gb.ORIGIN) > 0 {
+		r.fits("the Genbank record "+genbankFile, len(gb.ORIGIN))
+	}
+}
+
+// Mask checks that a mask can be read, and is inside the alignment, if its width is known
+func (r *Report) Mask(maskFile string) {
+	width := r.Width
+	if width == 0 {
+		width = -1
+	}
+	m, err := mask.Load(maskFile, width)
+	if err != nil {
+		r.problem("%s: %v", maskFile, err)
+		return
+	}
+	r.note("%s: %d masked sites", maskFile, m.Len())
+}
+
+// Metadata records an error from loading the metadata, which can't be checked any further
+func (r *Report) Metadata(path string, err error) {
+	if err != nil {
+		r.problem("%s: %v", path, err)
+	}
+}
+
+// Err is nil if no problems have been found, otherwise an error that says how many there are
+func (r *Report) Err() error {
+	switch len(r.Problems) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("found 1 problem with the inputs")
+	}
+	return fmt.Errorf("found %d problems with the inputs", len(r.Problems))
+}
@@ -0,0 +1,61 @@
+package validate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	ref := write("ref.fasta", ">ref\nACGTACGTAC\n")
+	aln := write("aln.fasta", ">a\nACGTACGTAC\n>b\nACGTACGTAC\n")
+	short := write("short.fasta", ">a\nACGTACGTA\n>a\nACGTACGTA\n")
+	ragged := write("ragged.fasta", ">a\nACGTACGTAC\n>b\nACGT\n")
+	samFile := write("in.sam", "@SQ\tSN:ref\tLN:10\nq\t0\tref\t1\t60\t4M\t*\t0\t0\tACGT\t*\n")
+	noSQ := write("nosq.sam", "q\t0\tref\t1\t60\t4M\t*\t0\t0\tACGT\t*\n")
+	mask := write("mask.txt", "3-4\n12\n")
+
+	var out bytes.Buffer
+
+	r := New(&out)
+	r.SAM(samFile, ref)
+	r.Fasta(aln, true, nil)
+	if r.Err() != nil || r.Width != 10 {
+		t.Errorf("problem in TestReport: %v %d\n%s", r.Err(), r.Width, out.String())
+	}
+
+	tests := []struct {
+		check    func(r *Report)
+		problems int
+	}{
+		{func(r *Report) { r.Reference(ref); r.Fasta(short, true, nil) }, 2},
+		{func(r *Report) { r.Fasta(ragged, true, nil); r.Fasta(ragged, false, nil) }, 1},
+		{func(r *Report) { r.Reference(aln) }, 1},
+		{func(r *Report) { r.SAM(noSQ, ""); r.Reference(ref) }, 1},
+		{func(r *Report) { r.SAM(noSQ, ref) }, 0},
+		{func(r *Report) { r.Reference(ref); r.Mask(mask) }, 1},
+		{func(r *Report) { r.Mask(mask) }, 0},
+		{func(r *Report) { r.Fasta(filepath.Join(dir, "missing.fasta"), false, nil) }, 1},
+	}
+
+	for i, tt := range tests {
+		out.Reset()
+		r := New(&out)
+		tt.check(r)
+		if len(r.Problems) != tt.problems || (tt.problems > 0) != (r.Err() != nil) {
+			t.Errorf("problem in TestReport: test %d: expected %d problems, got\n%s", i, tt.problems, out.String())
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Package version holds the version of gofasta, and the command line it was run
+// with, so that outputs can record where they came from. The version can be set
+// when building, e.g.:
+//
+//	go build -ldflags "-X github.com/cov-ert/gofasta/pkg/version.Version=v1.2.0"
+package version
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Version is the version of gofasta
+var Version = "0.0.5"
+
+// Command is the command line that gofasta was run with. It is empty if gofasta is
+// being used as a library
+var Command string
+
+// SetCommand sets Command from a list of arguments (e.g. os.Args), quoting any that
+// contain whitespace
+func SetCommand(args []string) {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) == 0 || strings.ContainsAny(arg, " \t\n") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	Command = strings.Join(quoted, " ")
+}
+
+// Provenance describes the version and command line, for the header of an output
+// file, e.g. "gofasta=v1.2.0 command=gofasta sam indels -s in.sam". Every tsv, BED and GFF
+// output records it on a line of its own that starts with ##, as in ##gofasta=v1.2.0: BED
+// readers skip any line that starts with #, and GFF3 readers ignore ## directives that they
+// don't know (in GFF3 the line comes after ##gff-version 3, which has to be first). VCFs
+// record it as ##source=, and nexus files in a [comment]
+func Provenance() string {
+	if len(Command) == 0 {
+		return "gofasta=" + Version
+	}
+	return "gofasta=" + Version + " command=" + Command
+}
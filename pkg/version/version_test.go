@@ -0,0 +1,20 @@
+package version
+
+import (
+	"testing"
+)
+
+func TestProvenance(t *testing.T) {
+	defer func() { Command = "" }()
+
+	Command = ""
+	if Provenance() != "gofasta="+Version {
+		t.Errorf("problem in TestProvenance: got %s", Provenance())
+	}
+
+	SetCommand([]string{"gofasta", "sam", "indels", "-s", "my file.sam", "--insertions-fasta", ""})
+	expected := "gofasta=" + Version + ` command=gofasta sam indels -s "my file.sam" --insertions-fasta ""`
+	if Provenance() != expected {
+		t.Errorf("problem in TestProvenance: got %s, expected %s", Provenance(), expected)
+	}
+}
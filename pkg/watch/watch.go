@@ -0,0 +1,209 @@
+/*
+Package watch processes the files that arrive in a directory, e.g. the SAM or fasta files that
+a sequencing instrument's pipeline writes, as they arrive. The directory is scanned every so
+often, and a file is processed once its size and modification time have stopped changing between
+two scans, so that files that are still being written aren't read. Once a file has been processed,
+a marker is written next to its outputs: name.done if it succeeded, or name.failed (with the
+error) if it didn't. Files that have a marker are skipped, so a watcher can be stopped and started
+again without processing anything twice, and a failed file can be retried by deleting its marker.
+*/
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cov-ert/gofasta/internal/summary"
+	"github.com/cov-ert/gofasta/pkg/usage"
+)
+
+// the name of the count of files that couldn't be processed in the run summary
+const failedCount = "failed"
+
+// Job is a file to process
+type Job struct {
+	// Path is the path of the file
+	Path string
+	// Name is its base name without its extension, for naming its outputs
+	Name string
+}
+
+// Runner processes a job. If it returns an error, the error is written to the job's
+// failed marker
+type Runner func(Job) error
+
+// fileState is what is checked to tell whether a file is still being written
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watcher watches a directory for files to process
+type Watcher struct {
+	dir       string
+	patterns  []string
+	markerDir string
+	run       Runner
+
+	// files that have been seen but haven't stopped changing yet
+	pending map[string]fileState
+}
+
+// New makes a Watcher that runs run on the files in dir whose names match any of patterns (in
+// the syntax of filepath.Match), writing their markers to markerDir (which is made if it doesn't exist)
+func New(dir string, patterns []string, markerDir string, run Runner) (*Watcher, error) {
+
+	if len(patterns) == 0 {
+		return nil, usage.New("there must be at least one pattern for the files to process")
+	}
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, usage.Errorf("bad pattern %s: %v", pattern, err)
+		}
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, usage.Errorf("%s isn't a directory", dir)
+	}
+
+	err = os.MkdirAll(markerDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watcher{dir: dir, patterns: patterns, markerDir: markerDir, run: run, pending: make(map[string]fileState)}, nil
+}
+
+// matches reports whether a file name matches any of the watcher's patterns. Hidden files
+// (e.g. the temporary files that rsync writes) never match
+func (w *Watcher) matches(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, pattern := range w.patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// marker is the path of a file's marker with the given extension (done or failed)
+func (w *Watcher) marker(name string, ext string) string {
+	return filepath.Join(w.markerDir, name+"."+ext)
+}
+
+// marked reports whether a file has a done or failed marker
+func (w *Watcher) marked(name string) bool {
+	for _, ext := range []string{"done", "failed"} {
+		if _, err := os.Stat(w.marker(name, ext)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan processes the files in the directory that haven't been processed yet, in order of their
+// names. If settled is false, files are only processed if they haven't changed since the last
+// scan (and files that are seen for the first time are only processed by a later scan). It returns
+// the number of files that were processed, including any that failed
+func (w *Watcher) Scan(settled bool) (int, error) {
+
+	entries, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]bool)
+	counter := 0
+
+	for _, info := range entries {
+		name := info.Name()
+		if !info.Mode().IsRegular() || !w.matches(name) || w.marked(name) {
+			continue
+		}
+		seen[name] = true
+
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		if last, ok := w.pending[name]; !settled && (!ok || last != state) {
+			w.pending[name] = state
+			continue
+		}
+		delete(w.pending, name)
+
+		err = w.process(name)
+		if err != nil {
+			return counter, err
+		}
+		counter++
+	}
+
+	// forget files that have gone away before they were processed
+	for name := range w.pending {
+		if !seen[name] {
+			delete(w.pending, name)
+		}
+	}
+
+	return counter, nil
+}
+
+// process runs the watcher's Runner on a file, and writes its marker
+func (w *Watcher) process(name string) error {
+
+	job := Job{Path: filepath.Join(w.dir, name), Name: strings.TrimSuffix(name, filepath.Ext(name))}
+
+	fmt.Fprintf(os.Stderr, "processing %s\n", job.Path)
+
+	start := time.Now()
+	runErr := w.run(job)
+	seconds := time.Since(start).Seconds()
+
+	if runErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to process %s: %v\n", job.Path, runErr)
+		summary.Add(failedCount, 1)
+		return ioutil.WriteFile(w.marker(name, "failed"), []byte(fmt.Sprintf("%v\n", runErr)), 0644)
+	}
+
+	summary.Add(summary.Processed, 1)
+
+	return ioutil.WriteFile(w.marker(name, "done"), []byte(fmt.Sprintf("%s\t%.3f seconds\n", start.Format(time.RFC3339), seconds)), 0644)
+}
+
+// Watch scans the directory every interval until ctx is done. If once is true, it scans it
+// once, processing every file that is there, and returns
+func (w *Watcher) Watch(ctx context.Context, interval time.Duration, once bool) error {
+
+	if once {
+		_, err := w.Scan(true)
+		return err
+	}
+
+	if interval <= 0 {
+		return usage.Errorf("the interval between scans must be more than 0, not %v", interval)
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		_, err := w.Scan(false)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
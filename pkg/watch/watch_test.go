@@ -0,0 +1,72 @@
+package watch
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	markerDir := filepath.Join(dir, "out")
+
+	write := func(name string, contents string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ran []string
+	w, err := New(dir, []string{"*.sam", "*.fasta"}, markerDir, func(j Job) error {
+		ran = append(ran, j.Name)
+		if j.Name == "bad" {
+			return errors.New("bad input")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("run1.sam", "@HD\n")
+	write("bad.fasta", ">q\n")
+	write("notes.txt", "not an input\n")
+	write(".run2.sam.tmp", "")
+
+	// files are only processed once they have stopped changing between scans
+	n, err := w.Scan(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("problem in TestScan: processed %d files on the first scan", n)
+	}
+
+	n, err = w.Scan(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !reflect.DeepEqual(ran, []string{"bad", "run1"}) {
+		t.Errorf("problem in TestScan: processed %d files (%v), expected bad and run1", n, ran)
+	}
+
+	for _, marker := range []string{"run1.sam.done", "bad.fasta.failed"} {
+		if _, err := os.Stat(filepath.Join(markerDir, marker)); err != nil {
+			t.Errorf("problem in TestScan: %v", err)
+		}
+	}
+
+	// files with markers aren't processed again
+	write("run3.sam", "@HD\n")
+	n, err = w.Scan(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || ran[len(ran)-1] != "run3" {
+		t.Errorf("problem in TestScan: processed %d files (%v), expected only run3", n, ran)
+	}
+}
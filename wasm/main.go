@@ -0,0 +1,130 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Command wasm is a thin wrapper around gofasta's SNP and variant calling, for
+// WebAssembly, so that a web page can get each sample's mutations without uploading
+// its sequences anywhere. It adds a gofasta object to the page's global scope, whose
+// functions take the contents of the files as strings:
+//
+//	gofasta.snps(reference, alignment)
+//	gofasta.variants(reference, alignment, genbank, codons)
+//	gofasta.version
+//
+// The alignment has to be aligned to the reference (e.g. by gofasta sam toMultiAlign).
+// Each function returns an array of {query, snps} or {query, variants} objects, or an
+// {error} object if the input couldn't be processed. Build it with:
+//
+//	GOOS=js GOARCH=wasm go build -o gofasta.wasm ./wasm
+package main
+
+import (
+	"errors"
+	"strings"
+	"syscall/js"
+
+	"github.com/cov-ert/gofasta/pkg/alphabet"
+	"github.com/cov-ert/gofasta/pkg/fastaio"
+	"github.com/cov-ert/gofasta/pkg/genbank"
+	"github.com/cov-ert/gofasta/pkg/sam"
+	"github.com/cov-ert/gofasta/pkg/snps"
+	"github.com/cov-ert/gofasta/pkg/version"
+)
+
+// readInputs reads the reference (the first record of a fasta file) and the alignment
+func readInputs(reference string, alignment string) (fastaio.FastaRecord, []fastaio.FastaRecord, error) {
+	refs, err := fastaio.ReadFasta(strings.NewReader(reference))
+	if err != nil {
+		return fastaio.FastaRecord{}, nil, err
+	}
+	if len(refs) == 0 {
+		return fastaio.FastaRecord{}, nil, errors.New("there is no reference sequence")
+	}
+	records, err := fastaio.ReadFasta(strings.NewReader(alignment))
+	if err != nil {
+		return fastaio.FastaRecord{}, nil, err
+	}
+	return refs[0], records, nil
+}
+
+// jsStrings converts a []string to something that js.ValueOf takes
+func jsStrings(s []string) []interface{} {
+	a := make([]interface{}, len(s))
+	for i := range s {
+		a[i] = s[i]
+	}
+	return a
+}
+
+func jsError(err error) interface{} {
+	return map[string]interface{}{"error": err.Error()}
+}
+
+// arg is the ith argument as a string, or "" if it wasn't given
+func arg(args []js.Value, i int) string {
+	if i >= len(args) || args[i].Type() != js.TypeString {
+		return ""
+	}
+	return args[i].String()
+}
+
+func snpsFunc(this js.Value, args []js.Value) interface{} {
+
+	ref, records, err := readInputs(arg(args, 0), arg(args, 1))
+	if err != nil {
+		return jsError(err)
+	}
+
+	results := make([]interface{}, 0, len(records))
+	for _, FR := range records {
+		found, err := snps.Find(ref.Seq, FR.Seq)
+		if err != nil {
+			return jsError(errors.New(FR.ID + ": " + err.Error()))
+		}
+		results = append(results, map[string]interface{}{"query": FR.ID, "snps": jsStrings(found)})
+	}
+
+	return results
+}
+
+func variantsFunc(this js.Value, args []js.Value) interface{} {
+
+	ref, records, err := readInputs(arg(args, 0), arg(args, 1))
+	if err != nil {
+		return jsError(err)
+	}
+
+	gb, err := genbank.Parse(strings.NewReader(arg(args, 2)))
+	if err != nil {
+		return jsError(err)
+	}
+
+	policy := alphabet.CodonX
+	if codons := arg(args, 3); len(codons) > 0 {
+		policy, err = alphabet.ParseCodonPolicy(codons)
+		if err != nil {
+			return jsError(err)
+		}
+	}
+
+	results := make([]interface{}, 0, len(records))
+	for _, FR := range records {
+		variants, err := sam.VariantsOf(ref.Seq, FR.Seq, FR.ID, gb, policy)
+		if err != nil {
+			return jsError(err)
+		}
+		results = append(results, map[string]interface{}{"query": FR.ID, "variants": jsStrings(variants)})
+	}
+
+	return results
+}
+
+func main() {
+	js.Global().Set("gofasta", js.ValueOf(map[string]interface{}{
+		"snps":     js.FuncOf(snpsFunc),
+		"variants": js.FuncOf(variantsFunc),
+		"version":  version.Version,
+	}))
+
+	// the functions can only be called while the program is running
+	select {}
+}